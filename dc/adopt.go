@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+	"dc/internal/docker"
+	"gopkg.in/yaml.v3"
+)
+
+// adoptedSuffix is appended to the names of the adopted containers while their stack comes up,
+// so the stack can use the names; they are restored if it doesn't come up
+const adoptedSuffix = "-pre-adopt"
+
+// adoptCompose reconstructs the stack file of containers started by hand. Each container
+// becomes a service named after it and keeps its container name.
+func adoptCompose(stackName string, containers []docker.Inspect) (compose.File, error) {
+	var labelled []docker.Inspect
+	names := make(map[string]string)
+	for _, container := range containers {
+		containerName := strings.TrimPrefix(container.Name, "/")
+		if project := container.Config.Labels["com.docker.compose.project"]; project != "" {
+			return compose.File{}, fmt.Errorf("container %s already belongs to stack %s", containerName, project)
+		}
+		if container.HostConfig.AutoRemove {
+			return compose.File{}, fmt.Errorf("container %s was started with --rm and would be deleted when it is stopped", containerName)
+		}
+		serviceName := sanitizeServiceName(containerName)
+		if serviceName == "" {
+			return compose.File{}, fmt.Errorf("cannot derive a service name from container %s", containerName)
+		}
+		if other, ok := names[serviceName]; ok {
+			return compose.File{}, fmt.Errorf("containers %s and %s would both be service %s", other, containerName, serviceName)
+		}
+		names[serviceName] = containerName
+
+		// Reconstruction takes the stack and service from the compose labels
+		labels := map[string]string{"com.docker.compose.project": stackName, "com.docker.compose.service": serviceName}
+		for key, value := range container.Config.Labels {
+			labels[key] = value
+		}
+		container.Config.Labels = labels
+		labelled = append(labelled, container)
+	}
+
+	composeFile := reconstructCompose(labelled, stackName)
+	for serviceName, service := range composeFile.Services {
+		service.ContainerName = names[serviceName]
+		composeFile.Services[serviceName] = service
+	}
+	return composeFile, nil
+}
+
+// stackServicesRunning reports whether each service of the stack has a running container
+func stackServicesRunning(stackName string, composeFile *compose.File) bool {
+	for serviceName := range composeFile.Services {
+		out, err := engineCommand("ps", "-q",
+			"--filter", "label=com.docker.compose.project="+stackName,
+			"--filter", "label=com.docker.compose.service="+serviceName,
+			"--filter", "status=running").Output()
+		if err != nil || strings.TrimSpace(string(out)) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// restoreAdopted renames the adopted containers back and starts the ones that were running
+func restoreAdopted(containers []docker.Inspect) {
+	for _, container := range containers {
+		name := strings.TrimPrefix(container.Name, "/")
+		if err := engineCommand("rename", name+adoptedSuffix, name).Run(); err != nil {
+			stackLog.Error("Failed to restore container name", "container", name, "err", err)
+			continue
+		}
+		if container.State.Running {
+			if err := engineCommand("start", name).Run(); err != nil {
+				stackLog.Error("Failed to start container", "container", name, "err", err)
+			}
+		}
+	}
+}
+
+// adoptedSecrets collects the passwords of adopted containers, so that they are only stored once
+// the stack file is certain to be used
+type adoptedSecrets map[string]string
+
+func (a adoptedSecrets) Insert(key, value string) error {
+	a[key] = value
+	return nil
+}
+
+func (a adoptedSecrets) Generate(key string) error { return nil }
+
+// store stores the passwords for the stack. A variable that already holds another value is an
+// error: the adopted containers would come up with the wrong password.
+func (a adoptedSecrets) store(stackName string) error {
+	existing, err := readStackEnv(stackName)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(a))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value, ok := existing[key]; ok && value != a[key] {
+			return fmt.Errorf("%s already holds another value; remove it or adopt the containers under another stack name", key)
+		}
+	}
+	for _, key := range keys {
+		if err := (secretsManager{}).Insert(key, a[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// projectHasContainers reports whether docker has containers of the compose project, running or not
+func projectHasContainers(stackName string) (bool, error) {
+	out, err := engineCommand("ps", "-aq", "--filter", "label=com.docker.compose.project="+stackName).Output()
+	if err != nil {
+		return false, fmt.Errorf("docker ps failed: %w", err)
+	}
+	return strings.TrimSpace(string(out)) != "", nil
+}
+
+// stackFileBackup holds the stack files of a stack before it is adopted into, to restore them
+// if the adopted stack doesn't come up
+type stackFileBackup map[string][]byte
+
+// backupStackFiles reads the stack files adoption writes; missing files are left out
+func backupStackFiles(stackName string) stackFileBackup {
+	backup := make(stackFileBackup)
+	for _, path := range []string{GetStackPath(stackName, false), GetStackPath(stackName, true)} {
+		if content, err := os.ReadFile(path); err == nil {
+			backup[path] = content
+		}
+	}
+	return backup
+}
+
+// restore puts the stack files back, removing the ones that didn't exist
+func (b stackFileBackup) restore(stackName string) {
+	for _, path := range []string{GetStackPath(stackName, false), GetStackPath(stackName, true)} {
+		content, ok := b[path]
+		if !ok {
+			os.Remove(path)
+			continue
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			stackLog.Error("Failed to restore stack file", "path", path, "err", err)
+		}
+	}
+}
+
+// adoptedContainers returns the containers adoption set aside for the services of a stack file
+func adoptedContainers(composeFile *compose.File) []string {
+	var names []string
+	for _, service := range composeFile.Services {
+		if service.ContainerName == "" {
+			continue
+		}
+		name := service.ContainerName + adoptedSuffix
+		if err := engineCommand("inspect", "--type=container", name).Run(); err == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// confirmAdoption removes the original containers of an adopted stack, which adoption keeps
+// stopped until the stack has been checked
+func confirmAdoption(stackName string) error {
+	content, _, err := findYAML(stackName)
+	if err != nil {
+		return err
+	}
+	var composeFile compose.File
+	if err := yaml.Unmarshal(content, &composeFile); err != nil {
+		return fmt.Errorf("failed to parse stack %s: %w", stackName, err)
+	}
+	names := adoptedContainers(&composeFile)
+	if len(names) == 0 {
+		return fmt.Errorf("stack %s has no containers set aside by adoption", stackName)
+	}
+	if DryRun {
+		fmt.Fprintln(os.Stdout, msg("dry_run_header"))
+		fmt.Fprintf(os.Stdout, "# Would remove %s\n", strings.Join(names, ", "))
+		return nil
+	}
+	for _, name := range names {
+		if err := engineCommand("rm", name).Run(); err != nil {
+			return fmt.Errorf("failed to remove container %s: %w", name, err)
+		}
+	}
+	fmt.Fprintln(os.Stderr, msg("adopt_confirmed", stackName, len(names)))
+	return nil
+}
+
+// HandleAdoptStack turns containers started by hand into a stack: it writes a stack file
+// reconstructed from them and recreates them with docker compose. Their passwords move to the
+// secrets store. The containers are stopped and renamed while the stack comes up, and kept
+// until `dc stack adopt <name> --confirm=true` removes them; their volumes stay. If a service
+// doesn't come up, the stack is taken down, its previous stack files restored and the
+// containers restored. --force=true adopts into an existing stack file, unless the stack has
+// containers.
+func HandleAdoptStack(stackName string, refs []string) error {
+	if err := validateStackName(stackName); err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		if !getFlagBool("confirm", false) {
+			return fmt.Errorf("no containers to adopt")
+		}
+		return confirmAdoption(stackName)
+	}
+	if _, path, err := findYAML(stackName); err == nil {
+		if !getFlagBool("force", false) {
+			return fmt.Errorf("%s", msg("stack_exists", stackName, path))
+		}
+		running, err := projectHasContainers(stackName)
+		if err != nil {
+			return err
+		}
+		if running {
+			return fmt.Errorf("stack %s has containers; take it down before adopting containers into it", stackName)
+		}
+	}
+	containers, err := inspectContainers(refs)
+	if err != nil {
+		return err
+	}
+	composeFile, err := adoptCompose(stackName, containers)
+	if err != nil {
+		return err
+	}
+	passwords := adoptedSecrets{}
+	if _, err := compose.SanitizeEnvironment(&composeFile, stackKeyFunc(stackName), passwords); err != nil {
+		return err
+	}
+	var buf strings.Builder
+	if err := encodeYAMLWithMultiline(&buf, &composeFile); err != nil {
+		return err
+	}
+	body := []byte(buf.String())
+
+	if DryRun {
+		fmt.Fprintln(os.Stdout, msg("dry_run_header"))
+		os.Stdout.Write(body)
+		return nil
+	}
+	// Fail before any container is touched
+	if err := enforcePolicies(body, stackName); err != nil {
+		return err
+	}
+	if err := passwords.store(stackName); err != nil {
+		return fmt.Errorf("failed to store the passwords of the containers: %w", err)
+	}
+	backup := backupStackFiles(stackName)
+
+	for i, container := range containers {
+		name := strings.TrimPrefix(container.Name, "/")
+		stackLog.Info("Stopping container", "container", name)
+		err := engineCommand("stop", name).Run()
+		if err == nil {
+			err = engineCommand("rename", name, name+adoptedSuffix).Run()
+		}
+		if err != nil {
+			restoreAdopted(containers[:i])
+			return fmt.Errorf("failed to set container %s aside: %w", name, err)
+		}
+	}
+
+	err = HandleDockerComposeFile(body, stackName, false, compose.ActionUp)
+	if err != nil || !stackServicesRunning(stackName, &composeFile) {
+		stackLog.Error("The stack did not come up, restoring the containers", "stack", stackName, "err", err)
+		if err := HandleDockerComposeFile(body, stackName, false, compose.ActionDown); err != nil {
+			stackLog.Warn("Failed to take the stack down", "stack", stackName, "err", err)
+		}
+		backup.restore(stackName)
+		restoreAdopted(containers)
+		return fmt.Errorf("%s", msg("adopt_rolled_back", stackName))
+	}
+
+	fmt.Fprintln(os.Stderr, msg("stack_adopted", stackName, len(containers)))
+	fmt.Fprintln(os.Stderr, msg("stack_adopted_kept", adoptedSuffix, stackName))
+	return nil
+}
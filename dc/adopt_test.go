@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"dc/internal/compose"
+	"dc/internal/docker"
+)
+
+func TestAdoptKeepsPasswords(t *testing.T) {
+	useTempStacksDir(t)
+	oldProdEnvPath := ProdEnvPath
+	ProdEnvPath = filepath.Join(StacksDir, "prod.env")
+	t.Cleanup(func() { ProdEnvPath = oldProdEnvPath })
+
+	containers := []docker.Inspect{{
+		Name:   "/wiki",
+		Config: docker.ContainerConfig{Image: "wiki:latest", Env: []string{"DB_PASSWORD=pa$$word", "TZ=UTC"}},
+	}}
+	composeFile, err := adoptCompose("notes", containers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwords := adoptedSecrets{}
+	if _, err := compose.SanitizeEnvironment(&composeFile, stackKeyFunc("notes"), passwords); err != nil {
+		t.Fatal(err)
+	}
+	if want := (adoptedSecrets{"NOTES_DB_PASSWORD": "pa$$word"}); !reflect.DeepEqual(passwords, want) {
+		t.Errorf("passwords = %v, want %v", passwords, want)
+	}
+	env := compose.NormalizeEnvironment(composeFile.Services["wiki"].Environment)
+	if want := []string{"DB_PASSWORD=${NOTES_DB_PASSWORD}", "TZ=UTC"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("environment = %q, want %q", env, want)
+	}
+
+	// an existing variable with another value would give the containers the wrong password
+	if err := os.WriteFile(ProdEnvPath, []byte("NOTES_DB_PASSWORD=other\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := passwords.store("notes"); err == nil {
+		t.Error("store replaced the value of an existing variable")
+	}
+}
+
+func TestStackFileBackupRestore(t *testing.T) {
+	useTempStacksDir(t)
+	regular, effective := GetStackPath("web", false), GetStackPath("web", true)
+	backup := backupStackFiles("web")
+	if err := os.WriteFile(regular, []byte("adopted\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(effective, []byte("adopted\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backup.restore("web")
+	if content, err := os.ReadFile(regular); err != nil || string(content) != "services: {}\n" {
+		t.Errorf("stack file = %q, %v, want the previous content", content, err)
+	}
+	if _, err := os.Stat(effective); !os.IsNotExist(err) {
+		t.Errorf("effective file was not removed: %v", err)
+	}
+}
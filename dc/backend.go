@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+)
+
+// Execution backends of a stack (x-composectl.backend, else the backend config key):
+//   - compose: docker compose runs the stack on this host (default)
+//   - swarm: docker stack deploy runs it as swarm services; dc translates the settings swarm
+//     ignores and creates the secrets and configs swarm can't create itself
+const (
+	BackendCompose = "compose"
+	BackendSwarm   = "swarm"
+)
+
+// stackBackend returns the execution backend of a stack
+func stackBackend(composeFile *compose.File) string {
+	backend := getConfig("backend", BackendCompose)
+	if composeFile.Composectl != nil && composeFile.Composectl.Backend != "" {
+		backend = composeFile.Composectl.Backend
+	}
+	if strings.ToLower(backend) == BackendSwarm {
+		return BackendSwarm
+	}
+	return BackendCompose
+}
+
+// backendCommand returns the engine command running an action on a stack with its backend; the
+// stack file is passed on stdin. projectDir is only used by watch.
+func backendCommand(backend, stackName string, action compose.Action, projectDir string) (*exec.Cmd, error) {
+	if backend == BackendSwarm {
+		if containerEngine() == EnginePodman {
+			return nil, fmt.Errorf("the swarm backend needs docker; podman has no swarm mode")
+		}
+		switch action {
+		case compose.ActionUp:
+			return engineCommand("stack", "deploy", "--compose-file", "-", "--prune", "--with-registry-auth", stackName), nil
+		case compose.ActionDown, compose.ActionRemove:
+			return engineCommand("stack", "rm", stackName), nil
+		default:
+			return nil, fmt.Errorf("the swarm backend can't %s a stack; use up or down", action)
+		}
+	}
+	switch action {
+	case compose.ActionUp:
+		return composeCommand("-f", "-", "-p", stackName, "up", "-d", "--wait", "--remove-orphans"), nil
+	case compose.ActionRemove:
+		return composeCommand("-f", "-", "-p", stackName, "down"), nil
+	case compose.ActionWatch:
+		return composeCommand("-f", "-", "-p", stackName, "--project-directory", projectDir, "watch"), nil
+	default:
+		return composeCommand("-f", "-", "-p", stackName, action.String()), nil
+	}
+}
+
+// swarmRestartConditions maps compose restart policies to swarm restart conditions
+var swarmRestartConditions = map[string]string{
+	"no":             "none",
+	"always":         "any",
+	"unless-stopped": "any",
+	"on-failure":     "on-failure",
+}
+
+// prepareSwarmCompose translates a stack file for docker stack deploy: mem_limit, cpus and
+// restart become their deploy: equivalents (existing deploy settings win), container_name is
+// dropped and inline configs become swarm configs. It returns what swarm can't honor.
+func prepareSwarmCompose(composeFile *compose.File, stackName string) ([]string, error) {
+	var warnings []string
+	names := make([]string, 0, len(composeFile.Services))
+	for name := range composeFile.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		service := composeFile.Services[name]
+		if service.Deploy == nil {
+			service.Deploy = map[string]interface{}{}
+		}
+		resources := deploySection(service.Deploy, "resources")
+		limits := deploySection(resources, "limits")
+		if service.MemLimit != "" {
+			setDefault(limits, "memory", service.MemLimit)
+			service.MemLimit = ""
+		}
+		if service.CPUs != nil {
+			setDefault(limits, "cpus", fmt.Sprint(service.CPUs))
+			service.CPUs = nil
+		}
+		if service.Restart != "" {
+			if condition, ok := swarmRestartConditions[service.Restart]; ok {
+				setDefault(deploySection(service.Deploy, "restart_policy"), "condition", condition)
+			}
+			service.Restart = ""
+		}
+		if service.ContainerName != "" {
+			warnings = append(warnings, fmt.Sprintf("service %s: container_name is not supported by swarm and was dropped", name))
+			service.ContainerName = ""
+		}
+		if service.Build != nil {
+			warnings = append(warnings, fmt.Sprintf("service %s: build is ignored by swarm; the image must be in a registry", name))
+		}
+		if len(service.Devices) > 0 {
+			warnings = append(warnings, fmt.Sprintf("service %s: devices are not supported by swarm", name))
+		}
+		if len(limits) == 0 {
+			delete(resources, "limits")
+		}
+		if len(resources) == 0 {
+			delete(service.Deploy, "resources")
+		}
+		if len(service.Deploy) == 0 {
+			service.Deploy = nil
+		}
+		composeFile.Services[name] = service
+	}
+
+	for name, config := range composeFile.Configs {
+		if config.Content == "" {
+			continue
+		}
+		swarmName, err := ensureSwarmConfig(stackName, name, config.Content)
+		if err != nil {
+			return warnings, fmt.Errorf("config %s: %w", name, err)
+		}
+		composeFile.Configs[name] = compose.Config{Name: swarmName, External: true}
+	}
+	return warnings, nil
+}
+
+// deploySection returns the map under key of a deploy: section, creating it if missing
+func deploySection(parent map[string]interface{}, key string) map[string]interface{} {
+	section, ok := parent[key].(map[string]interface{})
+	if !ok {
+		section = map[string]interface{}{}
+		parent[key] = section
+	}
+	return section
+}
+
+// setDefault sets a key of a map unless it is set already
+func setDefault(m map[string]interface{}, key string, value interface{}) {
+	if _, ok := m[key]; !ok {
+		m[key] = value
+	}
+}
+
+// ensureSwarmConfig creates the swarm config holding inline content unless it exists and returns
+// its name. Like swarm secrets, swarm configs can't be changed, so the name carries a hash.
+func ensureSwarmConfig(stackName, name, content string) (string, error) {
+	sum := sha256.Sum256([]byte(stackName + "\x00" + name + "\x00" + content))
+	swarmName := fmt.Sprintf("%s_%s_%s", stackName, name, hex.EncodeToString(sum[:])[:12])
+	if engineCommand("config", "inspect", swarmName).Run() == nil {
+		return swarmName, nil
+	}
+	cmd := engineCommand("config", "create", "--label", "com.docker.stack.namespace="+stackName, swarmName, "-")
+	cmd.Stdin = strings.NewReader(content)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("docker config create %s: %w: %s", swarmName, err, strings.TrimSpace(string(output)))
+	}
+	stackLog.Info("Created swarm config", "name", swarmName)
+	return swarmName, nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultBackupRetention is the number of snapshots kept per stack (config key backup_retention)
+	defaultBackupRetention = 7
+
+	// snapshotTimeFormat names snapshot directories so that they sort chronologically
+	snapshotTimeFormat = "20060102-150405"
+)
+
+// getBackupsDir returns the directory holding volume snapshots (config key backups_dir).
+// Snapshots are stored as <backups_dir>/<stack>/<snapshot>/<volume>.tar.gz
+func getBackupsDir() string {
+	return getConfig("backups_dir", filepath.Join(StacksDir, "backups"))
+}
+
+// stackVolumeName returns the docker volume name compose uses for a declared volume
+func stackVolumeName(stackName, key string, volume compose.Volume) string {
+	if volume.Name != "" {
+		return volume.Name
+	}
+	if volume.External {
+		return key
+	}
+	return stackName + "_" + key
+}
+
+// stackVolumes returns the sorted docker volume names of the stack's named volumes
+func stackVolumes(stackName string) ([]string, error) {
+	body, _, err := findYAML(stackName)
+	if err != nil {
+		return nil, err
+	}
+	var composeFile compose.File
+	if err := yaml.Unmarshal(body, &composeFile); err != nil {
+		return nil, fmt.Errorf("failed to parse stack %s: %w", stackName, err)
+	}
+	var names []string
+	for key, volume := range composeFile.Volumes {
+		names = append(names, stackVolumeName(stackName, key, volume))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// listSnapshots returns the snapshot IDs of a stack, newest first
+func listSnapshots(stackName string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(getBackupsDir(), stackName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var snapshots []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			snapshots = append(snapshots, entry.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(snapshots)))
+	return snapshots, nil
+}
+
+// volumeTarCommand runs tar in a throwaway container with the volume and snapshot directory mounted
+func volumeTarCommand(volume, snapshotDir string, readOnly bool, script string) *exec.Cmd {
+	mount := volume + ":/volume"
+	if readOnly {
+		mount += ":ro"
+	}
+	return engineCommand("run", "--rm",
+		"-v", mount,
+		"-v", snapshotDir+":/backup",
+		getConfig("probe_image", defaultProbeImage),
+		"sh", "-c", script)
+}
+
+// backupRetention returns the number of snapshots kept per stack (config key backup_retention)
+func backupRetention() int {
+	retention, err := strconv.Atoi(getConfig("backup_retention", strconv.Itoa(defaultBackupRetention)))
+	if err != nil || retention < 1 {
+		return defaultBackupRetention
+	}
+	return retention
+}
+
+// pruneSnapshots removes all but the newest backup_retention snapshots of a stack
+func pruneSnapshots(stackName string) {
+	retention := backupRetention()
+	snapshots, err := listSnapshots(stackName)
+	if err != nil || len(snapshots) <= retention {
+		return
+	}
+	for _, snapshot := range snapshots[retention:] {
+		dir := filepath.Join(getBackupsDir(), stackName, snapshot)
+		if err := os.RemoveAll(dir); err != nil {
+			backupLog.Debug("Failed to remove old snapshot", "dir", dir, "err", err)
+			continue
+		}
+		backupLog.Info("Removed old snapshot", "snapshot", snapshot)
+	}
+}
+
+// listRemoteSnapshots returns the snapshot IDs of a stack on the storage target, newest first
+func listRemoteSnapshots(target storageTarget, stackName string) ([]string, error) {
+	return remoteEntries(target, path.Join(storageBackupsPrefix, stackName))
+}
+
+// uploadSnapshot copies a local snapshot to the storage target and applies the retention
+// policy there
+func uploadSnapshot(target storageTarget, stackName, snapshot, snapshotDir string) error {
+	archives, err := filepath.Glob(filepath.Join(snapshotDir, "*.tar.gz"))
+	if err != nil {
+		return err
+	}
+	dir := path.Join(storageBackupsPrefix, stackName, snapshot)
+	for _, archive := range archives {
+		key := path.Join(dir, filepath.Base(archive))
+		if err := target.Upload(key, archive, uploadProgress(key)); err != nil {
+			return fmt.Errorf("failed to upload %s to %s: %w", key, target, err)
+		}
+	}
+	backupLog.Info("Uploaded snapshot", "snapshot", snapshot, "stack", stackName, "target", target)
+	pruneRemote(target, path.Join(storageBackupsPrefix, stackName), backupRetention())
+	return nil
+}
+
+// downloadSnapshot fetches a snapshot from the storage target into a temporary directory
+// below the backups directory, which the caller removes
+func downloadSnapshot(target storageTarget, stackName, snapshot string) (string, error) {
+	dir := path.Join(storageBackupsPrefix, stackName, snapshot)
+	names, err := target.List(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to list %s on %s: %w", dir, target, err)
+	}
+	if err := os.MkdirAll(getBackupsDir(), 0700); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(getBackupsDir(), ".restore-")
+	if err != nil {
+		return "", err
+	}
+	if tmp, err = filepath.Abs(tmp); err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".tar.gz") {
+			continue
+		}
+		backupLog.Info("Downloading", "file", path.Join(dir, name), "target", target)
+		if err := target.Download(path.Join(dir, name), filepath.Join(tmp, name)); err != nil {
+			os.RemoveAll(tmp)
+			return "", fmt.Errorf("failed to download %s from %s: %w", name, target, err)
+		}
+	}
+	return tmp, nil
+}
+
+// HandleBackupStack snapshots every named volume of a stack into a new snapshot directory
+// and applies the retention policy. Returns the snapshot ID.
+func HandleBackupStack(stackName string) (string, error) {
+	volumes, err := stackVolumes(stackName)
+	if err != nil {
+		return "", err
+	}
+	if len(volumes) == 0 {
+		return "", fmt.Errorf("stack %s has no named volumes to back up", stackName)
+	}
+	target, err := getStorageTarget()
+	if err != nil {
+		return "", err
+	}
+
+	snapshot := time.Now().Format(snapshotTimeFormat)
+	snapshotDir, err := filepath.Abs(filepath.Join(getBackupsDir(), stackName, snapshot))
+	if err != nil {
+		return "", err
+	}
+	if DryRun {
+		fmt.Fprintf(os.Stdout, "%s\n# Would snapshot %s into %s\n", msg("dry_run_header"), strings.Join(volumes, ", "), snapshotDir)
+		if target != nil {
+			fmt.Fprintf(os.Stdout, "# Would upload the snapshot to %s\n", target)
+		}
+		return snapshot, nil
+	}
+	if err := os.MkdirAll(snapshotDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", snapshotDir, err)
+	}
+
+	for _, volume := range volumes {
+		backupLog.Info("Backing up volume", "volume", volume)
+		cmd := volumeTarCommand(volume, snapshotDir, true,
+			fmt.Sprintf("tar czf /backup/%s.tar.gz -C /volume .", volume))
+		if output, err := cmd.CombinedOutput(); err != nil {
+			os.RemoveAll(snapshotDir)
+			return "", fmt.Errorf("failed to back up volume %s: %v: %s", volume, err, strings.TrimSpace(string(output)))
+		}
+	}
+	backupLog.Info("Created snapshot", "snapshot", snapshot, "stack", stackName, "volumes", len(volumes))
+
+	pruneSnapshots(stackName)
+	if target != nil {
+		if err := uploadSnapshot(target, stackName, snapshot, snapshotDir); err != nil {
+			return snapshot, fmt.Errorf("snapshot %s was created locally but not uploaded: %w", snapshot, err)
+		}
+	}
+	pingHeartbeat("backup")
+	return snapshot, nil
+}
+
+// HandleRestoreStack takes the stack down, replaces the contents of its volumes with the given
+// snapshot (the newest one when empty) and brings the stack back up. With --remote the
+// snapshot is fetched from the storage target instead of the local backups directory.
+func HandleRestoreStack(stackName, snapshot string) error {
+	body, _, err := findYAML(stackName)
+	if err != nil {
+		return err
+	}
+	var target storageTarget
+	if getFlagBool("remote", false) {
+		if target, err = getStorageTarget(); err != nil {
+			return err
+		} else if target == nil {
+			return fmt.Errorf("no backup_target configured")
+		}
+	}
+	if snapshot == "" {
+		var snapshots []string
+		if target != nil {
+			snapshots, err = listRemoteSnapshots(target, stackName)
+		} else {
+			snapshots, err = listSnapshots(stackName)
+		}
+		if err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			return fmt.Errorf("stack %s has no snapshots", stackName)
+		}
+		snapshot = snapshots[0]
+	}
+	if strings.ContainsAny(snapshot, "/\\") || snapshot == ".." {
+		return fmt.Errorf("invalid snapshot %q", snapshot)
+	}
+	var snapshotDir string
+	if target != nil {
+		if DryRun {
+			fmt.Fprintf(os.Stdout, "%s\n# Would restore snapshot %s from %s\n", msg("dry_run_header"), snapshot, target)
+			return nil
+		}
+		if snapshotDir, err = downloadSnapshot(target, stackName, snapshot); err != nil {
+			return err
+		}
+		defer os.RemoveAll(snapshotDir)
+	} else if snapshotDir, err = filepath.Abs(filepath.Join(getBackupsDir(), stackName, snapshot)); err != nil {
+		return err
+	}
+	archives, err := filepath.Glob(filepath.Join(snapshotDir, "*.tar.gz"))
+	if err != nil || len(archives) == 0 {
+		return fmt.Errorf("snapshot %s of stack %s not found", snapshot, stackName)
+	}
+	if DryRun {
+		fmt.Fprintf(os.Stdout, "%s\n# Would restore %d volume(s) from %s\n", msg("dry_run_header"), len(archives), snapshotDir)
+		return nil
+	}
+
+	if err := HandleDockerComposeFile(body, stackName, false, compose.ActionDown); err != nil {
+		return err
+	}
+	for _, archive := range archives {
+		volume := strings.TrimSuffix(filepath.Base(archive), ".tar.gz")
+		backupLog.Info("Restoring volume", "volume", volume)
+		if err := engineCommand("volume", "inspect", volume).Run(); err != nil {
+			if output, err := engineCommand("volume", "create", volume).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to create volume %s: %v: %s", volume, err, strings.TrimSpace(string(output)))
+			}
+		}
+		cmd := volumeTarCommand(volume, snapshotDir, false,
+			fmt.Sprintf("find /volume -mindepth 1 -delete && tar xzf /backup/%s.tar.gz -C /volume", volume))
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to restore volume %s: %v: %s", volume, err, strings.TrimSpace(string(output)))
+		}
+	}
+	backupLog.Info("Restored snapshot", "snapshot", snapshot, "stack", stackName)
+
+	return HandleDockerComposeFile(body, stackName, false, compose.ActionUp)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// BootRecord is the outcome of the last `dc boot`, as printed by `dc boot status`
+type BootRecord struct {
+	StartedAt  time.Time    `json:"startedAt"`
+	FinishedAt time.Time    `json:"finishedAt"`
+	Tiers      [][]string   `json:"tiers"`
+	Results    []BulkResult `json:"results"`
+	Failed     int          `json:"failed"`
+}
+
+// bootRecordPath returns where the last boot is recorded (config key boot_record)
+func bootRecordPath() string {
+	return getConfig("boot_record", filepath.Join(StacksDir, ".boot.json"))
+}
+
+// autostartStacks returns the stacks marked autostart in x-composectl or their metadata
+func autostartStacks() []string {
+	var stacks []string
+	metas := loadStackMetas()
+	for stackName, file := range stackFiles() {
+		if metas[stackName].Autostart {
+			stacks = append(stacks, stackName)
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var composeFile compose.File
+		if err := yaml.Unmarshal(content, &composeFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping stack %s: %v\n", stackName, err)
+			continue
+		}
+		if composeFile.Composectl != nil && composeFile.Composectl.Autostart {
+			stacks = append(stacks, stackName)
+		}
+	}
+	sort.Strings(stacks)
+	return stacks
+}
+
+// HandleAutostart brings up the stacks marked autostart tier by tier in group_order. Each stack
+// waits until its services are healthy (--wait-healthy=false to skip), so a tier only starts once
+// the previous one is healthy. The outcome is recorded for `dc boot status`; failed stacks don't
+// stop later tiers, so that a reboot brings back as much as possible.
+func HandleAutostart(args []string) error {
+	stacks := autostartStacks()
+	record := BootRecord{StartedAt: time.Now().UTC(), Tiers: bootTiers(stacks, stackGroups())}
+	if len(stacks) == 0 {
+		writeFrame(FrameStdout, "No stacks are marked autostart")
+	}
+
+	args = append(args, "--wait-healthy="+strconv.FormatBool(getConfigBool("wait_healthy", true)))
+	results, err := runTiers("up", record.Tiers, args)
+	if err != nil {
+		return err
+	}
+	record.Results = results
+	record.FinishedAt = time.Now().UTC()
+	for _, result := range results {
+		if result.ExitCode != 0 {
+			record.Failed++
+		}
+	}
+
+	if !DryRun {
+		data, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(bootRecordPath(), data, 0644); err != nil {
+			return fmt.Errorf("failed to record boot: %w", err)
+		}
+		if record.Failed == 0 {
+			pingHeartbeat("boot")
+		}
+	}
+	return reportBulkResults(results)
+}
+
+// HandleBootStatus prints the record of the last boot, or null if there was none
+func HandleBootStatus() error {
+	data, err := os.ReadFile(bootRecordPath())
+	if os.IsNotExist(err) {
+		fmt.Println("null")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var record BootRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return fmt.Errorf("invalid boot record %s: %w", bootRecordPath(), err)
+	}
+	return json.NewEncoder(os.Stdout).Encode(record)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultBulkParallelism is how many stacks a bulk operation handles at once (config key bulk_parallelism)
+const defaultBulkParallelism = 4
+
+// bulkActions maps the actions of bulk operations to the dc arguments performing them per stack
+var bulkActions = map[string][]string{
+	"start":  {"start"},
+	"stop":   {"stop"},
+	"up":     {"up"},
+	"down":   {"down"},
+	"update": {"up", "--pull-before-up=true"},
+}
+
+// bulkFlags are the flags consumed by the bulk operation itself rather than passed on to each stack
+var bulkFlags = map[string]bool{"all": true, "label": true, "group": true, "action": true, "parallel": true, "output-format": true, "stacks-git": true}
+
+// BulkResult is the outcome of a bulk operation for one stack
+type BulkResult struct {
+	Stack    string `json:"stack"`
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// flagName returns the name of a command line flag without dashes and value
+func flagName(arg string) string {
+	name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+	return name
+}
+
+// takesSeparateValue reports whether args[i] is a bulk flag whose value is the next argument,
+// as in `--label group=media`
+func takesSeparateValue(args []string, i int) bool {
+	arg := args[i]
+	return strings.HasPrefix(arg, "-") && !strings.Contains(arg, "=") && bulkFlags[flagName(arg)] &&
+		flagName(arg) != "all" && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-")
+}
+
+// bulkFlagValue returns the value of a bulk flag given on the command line
+func bulkFlagValue(args []string, name string) string {
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") || flagName(arg) != name {
+			continue
+		}
+		if _, value, ok := strings.Cut(arg, "="); ok {
+			return value
+		}
+		if takesSeparateValue(args, i) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// isBulkInvocation reports whether a stack command targets several stacks: --all, --label,
+// --group or more than one stack name, unless the names after the first are its services
+func isBulkInvocation(args []string) bool {
+	for _, arg := range args {
+		if name := flagName(arg); strings.HasPrefix(arg, "-") && (name == "all" || name == "label" || name == "group") {
+			return true
+		}
+	}
+	return len(bulkNames(args)) > 1 && positionalServices(args) == nil
+}
+
+// bulkNames returns the stack names given after the command
+func bulkNames(args []string) []string {
+	var names []string
+	for i := 2; i < len(args); i++ {
+		if takesSeparateValue(args, i) {
+			i++
+		} else if !strings.HasPrefix(args[i], "-") {
+			names = append(names, args[i])
+		}
+	}
+	return names
+}
+
+// stackLabels reads x-composectl.labels of all stacks. The group of a stack is its "group" label.
+func stackLabels() map[string]map[string]string {
+	labels := make(map[string]map[string]string)
+	metas := loadStackMetas()
+	for stackName, file := range stackFiles() {
+		labels[stackName] = map[string]string{}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var composeFile compose.File
+		if err := yaml.Unmarshal(content, &composeFile); err != nil {
+			continue
+		}
+		if composeFile.Composectl != nil {
+			for key, value := range composeFile.Composectl.Labels {
+				labels[stackName][key] = value
+			}
+		}
+		if group := stackGroup(metas[stackName], &composeFile); group != "" {
+			labels[stackName]["group"] = group
+		}
+	}
+	return labels
+}
+
+// selectStacks returns the stacks whose labels match a selector of comma-separated
+// key=value pairs, all of which must match. A bare key matches stacks having the label.
+func selectStacks(selector string) ([]string, error) {
+	var names []string
+	for stackName, labels := range stackLabels() {
+		matches, err := matchSelector(selector, labels)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			names = append(names, stackName)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// bulkTargets resolves the stacks of a bulk operation
+func bulkTargets(args []string) ([]string, error) {
+	if selector := bulkFlagValue(args, "label"); selector != "" {
+		return selectStacks(selector)
+	}
+	if group := bulkFlagValue(args, "group"); group != "" {
+		return selectStacks("group=" + group)
+	}
+	names := bulkNames(args)
+	if len(names) > 0 {
+		return names, nil
+	}
+	all := false
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") && flagName(arg) == "all" {
+			_, value, hasValue := strings.Cut(arg, "=")
+			all = !hasValue || value == "true"
+		}
+	}
+	if !all {
+		return nil, fmt.Errorf("Usage: dc stack %s <name>... | --all | --label <key>=<value> | --group <group>", args[1])
+	}
+	for stackName := range knownStacks() {
+		names = append(names, stackName)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// bulkPassthrough returns the flags of the bulk invocation to hand on to each stack
+func bulkPassthrough(args []string) []string {
+	var flags []string
+	for i := 1; i < len(args); i++ {
+		if takesSeparateValue(args, i) {
+			i++
+		} else if strings.HasPrefix(args[i], "-") && !bulkFlags[flagName(args[i])] {
+			flags = append(flags, args[i])
+		}
+	}
+	return flags
+}
+
+// runBulkStack runs the action for one stack in a child dc, relaying its output tagged with the stack
+func runBulkStack(self, stackName string, action []string, flags []string) BulkResult {
+	start := time.Now()
+	failed := func(err error) BulkResult {
+		return BulkResult{Stack: stackName, ExitCode: -1, Error: err.Error(), Duration: time.Since(start).Round(time.Millisecond).String()}
+	}
+	args := append([]string{"stack", action[0], stackName}, action[1:]...)
+	// stacks_git is off for the children: they would race for the index, the bulk command commits once
+	args = append(append(args, flags...), "--output-format=json", "--stacks-git=false")
+	cmd := exec.Command(self, args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return failed(err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return failed(err)
+	}
+	if err := cmd.Start(); err != nil {
+		return failed(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	lastError := ""
+	relay := func(r io.Reader, stream string) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			var frame OutputFrame
+			framed := json.Unmarshal([]byte(line), &frame) == nil && frame.Stream != ""
+			if !framed {
+				frame = OutputFrame{Stream: stream, Line: redactText(line), TS: time.Now().UTC()}
+			}
+			frame.Stack = stackName
+			// dc reports why it failed in an error frame or, from die, as the last unframed line
+			if frame.Stream == FrameError || (!framed && stream == FrameStderr) {
+				mu.Lock()
+				lastError = frame.Line
+				mu.Unlock()
+			}
+			emitFrame(frame)
+		}
+	}
+	wg.Add(2)
+	go relay(stdout, FrameStdout)
+	go relay(stderr, FrameStderr)
+	wg.Wait()
+
+	result := BulkResult{Stack: stackName}
+	if err := cmd.Wait(); err != nil {
+		result.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		}
+		result.Error = err.Error()
+		if lastError != "" {
+			result.Error = lastError
+		}
+	}
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	return result
+}
+
+// bulkParallelism returns how many stacks to handle at once: --parallel, else bulk_parallelism
+func bulkParallelism(args []string) int {
+	parallel, err := strconv.Atoi(bulkFlagValue(args, "parallel"))
+	if err != nil {
+		parallel, err = strconv.Atoi(getConfig("bulk_parallelism", strconv.Itoa(defaultBulkParallelism)))
+	}
+	if err != nil || parallel < 1 {
+		return defaultBulkParallelism
+	}
+	return parallel
+}
+
+// runBulk runs an action for the stacks concurrently, at most parallel at a time, relaying
+// the output of each stack as frames tagged with the stack
+func runBulk(actionName string, stacks []string, parallel int, flags []string) ([]BulkResult, error) {
+	action, ok := bulkActions[actionName]
+	if !ok {
+		return nil, fmt.Errorf("unknown bulk action %q", actionName)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate dc: %w", err)
+	}
+
+	writeFrame(FrameStdout, fmt.Sprintf("%s %d stacks, %d at a time: %s", actionName, len(stacks), parallel, strings.Join(stacks, ", ")))
+	results := make([]BulkResult, len(stacks))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, stackName := range stacks {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, stackName string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = runBulkStack(self, stackName, action, flags)
+			if results[i].ExitCode != 0 {
+				emitFrame(OutputFrame{Stream: FrameError, Line: fmt.Sprintf("%s failed after %s: %s", actionName, results[i].Duration, results[i].Error), TS: time.Now().UTC(), Stack: stackName})
+			} else {
+				emitFrame(OutputFrame{Stream: FrameDone, Line: fmt.Sprintf("%s completed in %s", actionName, results[i].Duration), TS: time.Now().UTC(), Stack: stackName})
+			}
+		}(i, stackName)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// reportBulkResults prints the results as JSON and fails if any stack failed
+func reportBulkResults(results []BulkResult) error {
+	if err := json.NewEncoder(os.Stdout).Encode(results); err != nil {
+		return err
+	}
+	failed := 0
+	for _, result := range results {
+		if result.ExitCode != 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d stacks failed", failed, len(results))
+	}
+	return nil
+}
+
+// HandleBulkAction runs an action for several stacks concurrently, at most --parallel (config key
+// bulk_parallelism) at a time. The output of each stack is relayed as frames tagged with the stack
+// and a JSON list of BulkResult is printed once all are done.
+func HandleBulkAction(actionName string, args []string) error {
+	stacks, err := bulkTargets(args)
+	if err != nil {
+		return err
+	}
+	if len(stacks) == 0 {
+		return fmt.Errorf("no stacks selected")
+	}
+	results, err := runBulk(actionName, stacks, bulkParallelism(args), bulkPassthrough(args))
+	if err != nil {
+		return err
+	}
+	return reportBulkResults(results)
+}
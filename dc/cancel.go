@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// exitCodeCancelled is dc's exit code after a cancelled command, as after an interrupt in a shell
+const exitCodeCancelled = 130
+
+// defaultCancelGrace is how long a command may take to stop after SIGTERM (config key cancel_grace)
+const defaultCancelGrace = 10 * time.Second
+
+var (
+	errCancelled = errors.New("cancelled")
+	errTimedOut  = errors.New("timed out")
+)
+
+// Why cancelOnSignal stopped a command
+const (
+	stopNone = iota
+	stopCancelled
+	stopTimedOut
+)
+
+func cancelGrace() time.Duration {
+	if d, err := time.ParseDuration(getConfig("cancel_grace", "")); err == nil && d > 0 {
+		return d
+	}
+	return defaultCancelGrace
+}
+
+// cancelOnSignal stops a started command when dc is interrupted (Ctrl-C), terminated (dcapi
+// cancelling a job) or once timeout is over (0 for none): SIGTERM first so that docker compose
+// can finish cleanly, SIGKILL if it is still running after cancel_grace. The returned function
+// must be called once the command has exited; it reports why the command was stopped, if it was.
+func cancelOnSignal(cmd *exec.Cmd, timeout time.Duration) func() int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var deadline <-chan time.Time
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.NewTimer(timeout)
+		deadline = timer.C
+	}
+	exited := make(chan struct{})
+	var reason atomic.Int32
+	go func() {
+		select {
+		case sig := <-signals:
+			reason.Store(stopCancelled)
+			writeFrame(FrameError, msg("cancelling"))
+			// Ctrl-C already reached the command through the terminal's process group
+			if sig != syscall.SIGTERM {
+				break
+			}
+			_ = cmd.Process.Signal(syscall.SIGTERM)
+		case <-deadline:
+			reason.Store(stopTimedOut)
+			writeFrame(FrameError, msg("command_timed_out", timeout))
+			_ = cmd.Process.Signal(syscall.SIGTERM)
+		case <-exited:
+			return
+		}
+		select {
+		case <-exited:
+		case <-time.After(cancelGrace()):
+			_ = cmd.Process.Kill()
+		}
+	}()
+	return func() int {
+		signal.Stop(signals)
+		if timer != nil {
+			timer.Stop()
+		}
+		close(exited)
+		return int(reason.Load())
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// CapacityReport sums the mem_limit and cpus the stacks declare and compares them with the host,
+// as returned by GET /api/capacity
+type CapacityReport struct {
+	Host       ResourceTotals  `json:"host"`
+	Declared   ResourceTotals  `json:"declared"`
+	Stacks     []StackCapacity `json:"stacks"`
+	Overcommit []string        `json:"overcommit"` // memory and/or cpus
+}
+
+// ResourceTotals is an amount of memory in bytes and of CPUs
+type ResourceTotals struct {
+	Memory uint64  `json:"memory"`
+	CPUs   float64 `json:"cpus"`
+}
+
+// StackCapacity is what a stack declares. Unlimited lists the services without a memory or CPU
+// limit, which the totals can't account for.
+type StackCapacity struct {
+	Name       string          `json:"name"`
+	Declared   ResourceTotals  `json:"declared"`
+	Unlimited  []string        `json:"unlimited,omitempty"`
+	Budget     *ResourceTotals `json:"budget,omitempty"`
+	OverBudget []string        `json:"overBudget,omitempty"` // memory and/or cpus
+	Error      string          `json:"error,omitempty"`
+}
+
+// replicas returns the number of containers a service runs: deploy.replicas, else 1
+func replicas(service compose.Service) int {
+	switch v := service.Deploy["replicas"].(type) {
+	case int:
+		return v
+	case string:
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			return n
+		}
+	}
+	return 1
+}
+
+// serviceLimits returns the memory and CPU limits of a service from mem_limit and cpus or
+// deploy.resources.limits, and whether it sets each of them
+func serviceLimits(service compose.Service) (memory uint64, cpus float64, hasMemory, hasCPUs bool, err error) {
+	resources, _ := service.Deploy["resources"].(map[string]interface{})
+	limits, _ := resources["limits"].(map[string]interface{})
+
+	memLimit := strings.TrimSpace(service.MemLimit)
+	if memLimit == "" && limits["memory"] != nil {
+		memLimit = fmt.Sprint(limits["memory"])
+	}
+	if memLimit != "" {
+		if memory, err = parseByteSize(memLimit); err != nil {
+			return 0, 0, false, false, fmt.Errorf("mem_limit: %w", err)
+		}
+		hasMemory = true
+	}
+
+	cpuLimit := service.CPUs
+	if cpuLimit == nil || fmt.Sprint(cpuLimit) == "" {
+		cpuLimit = limits["cpus"]
+	}
+	if cpuLimit != nil && fmt.Sprint(cpuLimit) != "" {
+		if cpus, err = strconv.ParseFloat(strings.TrimSpace(fmt.Sprint(cpuLimit)), 64); err != nil {
+			return 0, 0, false, false, fmt.Errorf("invalid cpus %v", cpuLimit)
+		}
+		hasCPUs = true
+	}
+	return memory, cpus, hasMemory, hasCPUs, nil
+}
+
+// stackCapacity sums the limits the services of a stack declare, counting every replica
+func stackCapacity(composeFile *compose.File, stackName string) StackCapacity {
+	capacity := StackCapacity{Name: stackName}
+	for name, service := range composeFile.Services {
+		memory, cpus, hasMemory, hasCPUs, err := serviceLimits(service)
+		if err != nil {
+			capacity.Error = fmt.Sprintf("service %s: %v", name, err)
+			continue
+		}
+		n := replicas(service)
+		capacity.Declared.Memory += memory * uint64(n)
+		capacity.Declared.CPUs += cpus * float64(n)
+		if !hasMemory || !hasCPUs {
+			capacity.Unlimited = append(capacity.Unlimited, name)
+		}
+	}
+	sort.Strings(capacity.Unlimited)
+
+	if composeFile.Composectl == nil || composeFile.Composectl.Budget == nil {
+		return capacity
+	}
+	budget := composeFile.Composectl.Budget
+	capacity.Budget = &ResourceTotals{CPUs: budget.CPUs}
+	if budget.Memory != "" {
+		memory, err := parseByteSize(budget.Memory)
+		if err != nil {
+			capacity.Error = fmt.Sprintf("budget: memory: %v", err)
+			return capacity
+		}
+		capacity.Budget.Memory = memory
+	}
+	if capacity.Budget.Memory > 0 && capacity.Declared.Memory > capacity.Budget.Memory {
+		capacity.OverBudget = append(capacity.OverBudget, "memory")
+	}
+	if capacity.Budget.CPUs > 0 && capacity.Declared.CPUs > capacity.Budget.CPUs {
+		capacity.OverBudget = append(capacity.OverBudget, "cpus")
+	}
+	return capacity
+}
+
+// checkBudget refuses a stack whose services declare more memory or CPUs than its
+// x-composectl.budget
+func checkBudget(composeFile *compose.File, stackName string) error {
+	capacity := stackCapacity(composeFile, stackName)
+	if capacity.Budget == nil {
+		return nil
+	}
+	if capacity.Error != "" {
+		return fmt.Errorf("stack %s: %s", stackName, capacity.Error)
+	}
+	var exceeded []string
+	for _, resource := range capacity.OverBudget {
+		switch resource {
+		case "memory":
+			exceeded = append(exceeded, fmt.Sprintf("memory %s > %s", formatByteSize(capacity.Declared.Memory), formatByteSize(capacity.Budget.Memory)))
+		case "cpus":
+			exceeded = append(exceeded, fmt.Sprintf("cpus %g > %g", capacity.Declared.CPUs, capacity.Budget.CPUs))
+		}
+	}
+	if len(exceeded) > 0 {
+		return fmt.Errorf("stack %s exceeds its budget: %s", stackName, strings.Join(exceeded, ", "))
+	}
+	return nil
+}
+
+// hostCapacity returns the CPUs and memory the engine reports for the host
+func hostCapacity() (ResourceTotals, error) {
+	out, err := engineCommand("info", "--format", "{{.NCPU}} {{.MemTotal}}").Output()
+	if err != nil {
+		return ResourceTotals{}, fmt.Errorf("failed to query the engine: %w", err)
+	}
+	var host ResourceTotals
+	if _, err := fmt.Sscan(string(out), &host.CPUs, &host.Memory); err != nil {
+		return ResourceTotals{}, fmt.Errorf("unexpected docker info output %q", strings.TrimSpace(string(out)))
+	}
+	return host, nil
+}
+
+// buildCapacityReport sums the limits of every stack, from its effective file if it was deployed
+// so that the resource defaults count, and flags what the host can't cover
+func buildCapacityReport() (*CapacityReport, error) {
+	host, err := hostCapacity()
+	if err != nil {
+		return nil, err
+	}
+	report := &CapacityReport{Host: host, Stacks: []StackCapacity{}, Overcommit: []string{}}
+	for stackName, file := range stackFiles() {
+		effective := strings.TrimSuffix(file, ".yml") + ".effective.yml"
+		if _, err := os.Stat(effective); err == nil {
+			file = effective
+		}
+		var composeFile compose.File
+		content, err := os.ReadFile(file)
+		if err == nil {
+			err = yaml.Unmarshal(content, &composeFile)
+		}
+		if err != nil {
+			report.Stacks = append(report.Stacks, StackCapacity{Name: stackName, Error: err.Error()})
+			continue
+		}
+		capacity := stackCapacity(&composeFile, stackName)
+		report.Declared.Memory += capacity.Declared.Memory
+		report.Declared.CPUs += capacity.Declared.CPUs
+		report.Stacks = append(report.Stacks, capacity)
+	}
+	sort.Slice(report.Stacks, func(i, j int) bool { return report.Stacks[i].Name < report.Stacks[j].Name })
+
+	if report.Declared.Memory > host.Memory {
+		report.Overcommit = append(report.Overcommit, "memory")
+	}
+	if report.Declared.CPUs > host.CPUs {
+		report.Overcommit = append(report.Overcommit, "cpus")
+	}
+	return report, nil
+}
+
+// HandleCapacity prints the capacity report as JSON
+func HandleCapacity() error {
+	report, err := buildCapacityReport()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(report)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stackContainers returns the names of the running containers of a compose project,
+// optionally restricted to one service
+func stackContainers(stackName, service string) ([]string, error) {
+	args := []string{"ps", "--format", "{{.Names}}", "--filter", "label=com.docker.compose.project=" + stackName}
+	if service != "" {
+		args = append(args, "--filter", "label=com.docker.compose.service="+service)
+	}
+	out, err := engineCommand(args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker ps failed: %w", err)
+	}
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line != "" {
+			names = append(names, line)
+		}
+	}
+	return names, nil
+}
+
+// HandleChaos deliberately disrupts one container of a stack to verify monitoring, healthchecks
+// and restart policies. mode is "kill" (docker kill) or "restart" (stop, wait, start).
+// It is refused unless chaos is enabled (--enable-chaos=true or ENABLE_CHAOS=true).
+// Options: --container=<name> (default: a random running container), --service=<name>
+// to pick among the containers of one service, --delay=<seconds> to wait before acting
+// (kill) or between stop and start (restart).
+func HandleChaos(stackName, mode string) error {
+	if !getConfigBool("enable_chaos", false) {
+		return fmt.Errorf("%s", msg("chaos_disabled"))
+	}
+	if mode != "kill" && mode != "restart" {
+		return fmt.Errorf("unknown chaos action %q (expected kill or restart)", mode)
+	}
+	delay, err := strconv.Atoi(getFlag("delay", "0"))
+	if err != nil || delay < 0 {
+		return fmt.Errorf("invalid delay %q", getFlag("delay", "0"))
+	}
+
+	containers, err := stackContainers(stackName, getFlag("service", ""))
+	if err != nil {
+		return err
+	}
+	target := getFlag("container", "")
+	if target == "" {
+		if len(containers) == 0 {
+			return fmt.Errorf("stack %s has no running containers", stackName)
+		}
+		target = containers[rand.Intn(len(containers))]
+	} else {
+		found := false
+		for _, name := range containers {
+			found = found || name == target
+		}
+		if !found {
+			return fmt.Errorf("container %s is not a running container of stack %s", target, stackName)
+		}
+	}
+
+	if DryRun {
+		fmt.Fprintf(os.Stdout, "%s\n# Would %s container %s (delay %ds)\n", msg("dry_run_header"), mode, target, delay)
+		return nil
+	}
+
+	wait := func() {
+		if delay > 0 {
+			chaosLog.Info("Waiting", "seconds", delay)
+			time.Sleep(time.Duration(delay) * time.Second)
+		}
+	}
+	run := func(args ...string) error {
+		if output, err := engineCommand(args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("docker %s %s failed: %v: %s", args[0], target, err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+
+	switch mode {
+	case "kill":
+		wait()
+		if err := run("kill", target); err != nil {
+			return err
+		}
+		chaosLog.Info("Killed container", "target", target, "stack", stackName)
+	case "restart":
+		if err := run("stop", target); err != nil {
+			return err
+		}
+		chaosLog.Info("Stopped container", "target", target)
+		wait()
+		if err := run("start", target); err != nil {
+			return err
+		}
+		chaosLog.Info("Restarted container", "target", target, "stack", stackName)
+	}
+	return nil
+}
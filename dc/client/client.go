@@ -0,0 +1,342 @@
+// Package client talks to the dcapi REST API: stacks, their streamed actions and logs, and the
+// jobs dcapi runs in the background. The dc CLI uses it for everything it asks dcapi.
+//
+//	c := client.New("http://localhost:8882", "")
+//	if err := c.Login(ctx, "admin", password); err != nil { ... }
+//	err := c.UpStack(ctx, "web", client.UpOptions{Pull: true}, func(f client.Frame) {
+//		fmt.Println(f.Line)
+//	})
+//
+// The websocket endpoints (file change notifications and container terminals) are not wrapped.
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiPrefix is the prefix of the API version this package speaks
+const apiPrefix = "/api/v1"
+
+// ndjsonContentType asks streamed endpoints for one JSON frame per line
+const ndjsonContentType = "application/x-ndjson"
+
+// exitCodeTrailer carries the exit code of a streamed action once it is done
+const exitCodeTrailer = "X-Exit-Code"
+
+// Client is a dcapi client. Token is a session token of Login or a personal access token.
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// New returns a client of the dcapi at baseURL, e.g. http://localhost:8882
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Token: token, HTTPClient: http.DefaultClient}
+}
+
+// Error is an error response of dcapi
+type Error struct {
+	Status  int    // HTTP status
+	Code    string // stable key such as not_found; empty if the body was no error envelope
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("dcapi answered %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("dcapi answered %d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// ExitError reports a streamed action that ran but failed
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit code %d", e.Code)
+}
+
+// Frame is one line of streamed output
+type Frame struct {
+	Stream string    `json:"stream"` // stdout, stderr, error or log
+	Line   string    `json:"line"`
+	TS     time.Time `json:"ts"`
+	Stack  string    `json:"stack,omitempty"` // set on the output of bulk operations
+}
+
+// Stack is a stack as listed by dcapi
+type Stack struct {
+	Name       string      `json:"name"`
+	Group      string      `json:"group,omitempty"`
+	Containers []Container `json:"containers"`
+}
+
+// Container is the part of a container's docker inspect output that clients commonly need
+type Container struct {
+	ID    string         `json:"id"`
+	Name  string         `json:"name"`
+	Image string         `json:"image"`
+	State ContainerState `json:"state"`
+}
+
+// ContainerState is the state of a container
+type ContainerState struct {
+	Status    string `json:"status"`
+	Running   bool   `json:"running"`
+	ExitCode  int    `json:"exitcode"`
+	StartedAt string `json:"startedat"`
+}
+
+// Job is a dc action dcapi runs in the background
+type Job struct {
+	ID         string     `json:"id"`
+	Stack      string     `json:"stack"`
+	Action     string     `json:"action"`
+	Principal  string     `json:"principal,omitempty"`
+	Status     string     `json:"status"`
+	ExitCode   int        `json:"exitCode"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	LogDropped int        `json:"logDropped,omitempty"`
+	Log        []Frame    `json:"log,omitempty"`
+}
+
+// UpOptions are the options of UpStack
+type UpOptions struct {
+	Services []string // only these services of the stack
+	Pull     bool     // pull images first
+	DryRun   bool     // report the planned changes instead of applying them
+}
+
+// StreamResult is the outcome of following a stream
+type StreamResult struct {
+	Received int  // frames received
+	Done     bool // whether the action finished; false if the connection dropped before
+	ExitCode int  // of the action once it is done
+}
+
+// httpClient returns the HTTP client of requests
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// request sends a request and turns error responses into an *Error
+func (c *Client) request(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType, accept string) (*http.Response, error) {
+	target := c.BaseURL + apiPrefix + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+// responseError reads the error envelope of a response, falling back to its text
+func responseError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var envelope struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &envelope) == nil && envelope.Error.Code != "" {
+		return &Error{Status: resp.StatusCode, Code: envelope.Error.Code, Message: envelope.Error.Message}
+	}
+	return &Error{Status: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+}
+
+// getJSON decodes the JSON response of a GET request into v
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	resp, err := c.request(ctx, http.MethodGet, path, query, nil, "", "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Login logs in with a username and password and keeps the session token for later requests
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+apiPrefix+"/auth/login", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(username, password)
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+	token, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+	c.Token = strings.TrimSpace(string(token))
+	return nil
+}
+
+// ListStacks lists the stacks with their containers
+func (c *Client) ListStacks(ctx context.Context) ([]Stack, error) {
+	var stacks []Stack
+	if err := c.getJSON(ctx, "/stacks", nil, &stacks); err != nil {
+		return nil, err
+	}
+	return stacks, nil
+}
+
+// GetStack returns the stack file of a stack
+func (c *Client) GetStack(ctx context.Context, name string) (string, error) {
+	resp, err := c.request(ctx, http.MethodGet, "/stacks/"+url.PathEscape(name), nil, nil, "", "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return string(data), err
+}
+
+// UpStack deploys a stack and passes its output to onFrame while it runs. It returns an
+// *ExitError if the deployment failed.
+func (c *Client) UpStack(ctx context.Context, name string, opts UpOptions, onFrame func(Frame)) error {
+	query := url.Values{"stream": {"true"}}
+	if opts.Pull {
+		query.Set("pull", "true")
+	}
+	if opts.DryRun {
+		query.Set("dry_run", "true")
+	}
+	body, err := json.Marshal(map[string][]string{"services": opts.Services})
+	if err != nil {
+		return err
+	}
+	resp, err := c.request(ctx, http.MethodPost, "/stacks/"+url.PathEscape(name)+"/up", query, strings.NewReader(string(body)), "application/json", ndjsonContentType)
+	if err != nil {
+		return err
+	}
+	result, err := readStream(resp, onFrame)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return &ExitError{Code: result.ExitCode}
+	}
+	return nil
+}
+
+// GetLogs follows the logs of a stack until ctx is cancelled or the stack is gone
+func (c *Client) GetLogs(ctx context.Context, name string, onFrame func(Frame)) error {
+	resp, err := c.request(ctx, http.MethodGet, "/stacks/"+url.PathEscape(name)+"/logs", nil, nil, "", ndjsonContentType)
+	if err != nil {
+		return err
+	}
+	_, err = readStream(resp, onFrame)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// ListJobs lists the jobs without their output, newest first, of a stack or of all if empty
+func (c *Client) ListJobs(ctx context.Context, stack string) ([]Job, error) {
+	query := url.Values{}
+	if stack != "" {
+		query.Set("stack", stack)
+	}
+	var jobs []Job
+	if err := c.getJSON(ctx, "/jobs", query, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// GetJob returns a job with its buffered output
+func (c *Client) GetJob(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	if err := c.getJSON(ctx, "/jobs/"+url.PathEscape(id), nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CancelJob cancels a running job
+func (c *Client) CancelJob(ctx context.Context, id string) error {
+	resp, err := c.request(ctx, http.MethodDelete, "/jobs/"+url.PathEscape(id), nil, nil, "", "application/json")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// StreamJob follows the output of a job, skipping the first from frames. If the connection
+// drops before the job is done, the result is not Done and streaming can be resumed from
+// from+Received.
+func (c *Client) StreamJob(ctx context.Context, id string, from int, onFrame func(Frame)) (StreamResult, error) {
+	query := url.Values{"from": {strconv.Itoa(from)}}
+	resp, err := c.request(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id)+"/stream", query, nil, "", ndjsonContentType)
+	if err != nil {
+		return StreamResult{}, err
+	}
+	return readStream(resp, onFrame)
+}
+
+// readStream passes the frames of a streamed response to onFrame and reads the exit code
+// trailer, which is only sent once the action is done
+func readStream(resp *http.Response, onFrame func(Frame)) (StreamResult, error) {
+	defer resp.Body.Close()
+	var result StreamResult
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var frame Frame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			continue
+		}
+		if onFrame != nil {
+			onFrame(frame)
+		}
+		result.Received++
+	}
+	// a read error means the connection dropped: the result is not done
+	if exitCode := resp.Trailer.Get(exitCodeTrailer); scanner.Err() == nil && exitCode != "" {
+		result.Done = true
+		result.ExitCode, _ = strconv.Atoi(exitCode)
+	}
+	return result, nil
+}
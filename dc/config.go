@@ -1,7 +1,6 @@
 package main
 
 import (
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,6 +16,12 @@ var (
 	// SecretsManager is the executable used to manage secrets (default: "pw")
 	SecretsManager string
 
+	// DryRun disables all side effects (docker, stack files, secrets store); set via --dry-run=true
+	DryRun bool
+
+	// OutputFormat is the framing of streamed command output, text or json; set via --output-format
+	OutputFormat string
+
 	// initialized tracks whether paths have been initialized
 	initialized bool
 )
@@ -87,7 +92,8 @@ func getDefaultStacksDir() string {
 	// Get user's home directory
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		log.Fatalf("Failed to get user home directory: %v", err)
+		configLog.Error("Failed to get user home directory", "err", err)
+		os.Exit(1)
 	}
 	return filepath.Join(homeDir, ".local", "containers")
 }
@@ -113,12 +119,12 @@ func InitPaths(args []string) {
 
 	// Ensure directories exist
 	if err := os.MkdirAll(StacksDir, 0755); err != nil {
-		log.Printf("Warning: Failed to create directory %s: %v", StacksDir, err)
+		configLog.Debug("Failed to create the stacks directory", "dir", StacksDir, "err", err)
 	}
 
-	log.Printf("Using stacks directory: %s", StacksDir)
-	log.Printf("Prod.env path: %s", ProdEnvPath)
-	log.Printf("Secrets manager: %s", SecretsManager)
+	configLog.Debug("Using stacks directory", "dir", StacksDir)
+	configLog.Debug("Using prod.env", "path", ProdEnvPath)
+	configLog.Debug("Using secrets manager", "manager", SecretsManager)
 
 	initialized = true
 }
@@ -132,46 +138,101 @@ func GetStackPath(stackName string, effective bool) string {
 	return filepath.Join(StacksDir, stackName+suffix)
 }
 
-// getConfig retrieves a configuration value with the following priority:
-// 1. Check program arguments for -key or --key flag
-// 2. Check KEY_FILE env var (Docker secrets pattern)
-// 3. Check KEY env var
-// 4. Check prod.env file (case insensitive) - only if ProdEnvPath is initialized
-// 5. Check default Docker secrets location (/run/secrets/KEY - case insensitive)
-// 6. Return provided default value
-func getConfig(key string, defaultValue string) string {
+// settingsDirName is the subdirectory of StacksDir holding the settings files of dc and dcapi.
+// Stack files are only looked up directly in the stack dirs, so nothing in it is taken for a stack.
+const settingsDirName = "settings"
+
+// getSettingsPath returns the default path of a settings file, e.g. healthchecks.yml
+func getSettingsPath(name string) string {
+	return filepath.Join(StacksDir, settingsDirName, name)
+}
+
+// getConfigBool retrieves a boolean configuration value via getConfig.
+// Accepts true/false, 1/0, yes/no and on/off (case insensitive).
+func getConfigBool(key string, defaultValue bool) bool {
+	return parseConfigBool(getConfig(key, ""), defaultValue)
+}
+
+// getFlag retrieves the value of a flag of the command (-key, --key or --key=value) from the
+// program arguments only. One-shot options such as --force or --name use it rather than
+// getConfig, so that a variable of the environment, the config file or prod.env can't set them.
+func getFlag(key string, defaultValue string) string {
+	if value, ok := argumentValue(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// getFlagBool retrieves a boolean flag via getFlag, parsed like getConfigBool
+func getFlagBool(key string, defaultValue bool) bool {
+	return parseConfigBool(getFlag(key, ""), defaultValue)
+}
+
+// parseConfigBool parses true/false, 1/0, yes/no and on/off (case insensitive)
+func parseConfigBool(value string, defaultValue bool) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "1", "yes", "on":
+		return true
+	case "false", "0", "no", "off":
+		return false
+	default:
+		return defaultValue
+	}
+}
+
+// argumentValue looks up -key or --key flag in the program arguments
+func argumentValue(key string) (string, bool) {
 	keyLower := strings.ToLower(key)
 	keyUpper := strings.ToUpper(key)
-	// Create title case manually (first char upper, rest lower)
-	//keyTitle := ""
-	//if len(keyLower) > 0 {
-	//	keyTitle = strings.ToUpper(string(keyLower[0])) + keyLower[1:]
-	//}
-
-	// Check program arguments first
 	args := os.Args[1:] // Skip program name
 	for i, arg := range args {
+		// Arguments after "--" belong to the command dc runs or converts
+		if arg == "--" {
+			break
+		}
 		// Replace underscores with dashes for command-line flag names
 		keyFlag := strings.ReplaceAll(keyLower, "_", "-")
 		argFlag := "-" + keyFlag
 		argFlagDouble := "--" + keyFlag
 
 		if (arg == argFlag || arg == argFlagDouble) && i+1 < len(args) {
-			log.Printf("Loaded %s from program arguments: %s", keyUpper, args[i+1])
-			return args[i+1]
+			configLog.Debug("Loaded from program arguments", "key", keyUpper)
+			return args[i+1], true
 		}
 		// Handle --key=value format
 		if strings.HasPrefix(arg, argFlagDouble+"=") {
-			value := strings.TrimPrefix(arg, argFlagDouble+"=")
-			log.Printf("Loaded %s from program arguments: %s", keyUpper, value)
-			return value
+			configLog.Debug("Loaded from program arguments", "key", keyUpper)
+			return strings.TrimPrefix(arg, argFlagDouble+"="), true
 		}
 		if strings.HasPrefix(arg, argFlag+"=") {
-			value := strings.TrimPrefix(arg, argFlag+"=")
-			log.Printf("Loaded %s from program arguments: %s", keyUpper, value)
-			return value
+			configLog.Debug("Loaded from program arguments", "key", keyUpper)
+			return strings.TrimPrefix(arg, argFlag+"="), true
 		}
 	}
+	return "", false
+}
+
+// getConfig retrieves a configuration value with the following priority:
+// 1. Check program arguments for -key or --key flag
+// 2. Check KEY_FILE env var (Docker secrets pattern)
+// 3. Check KEY env var
+// 4. Check the selected profile of the config file (~/.config/dc/config.yml, see UserConfig)
+// 5. Check prod.env file (case insensitive) - only if ProdEnvPath is initialized
+// 6. Check default Docker secrets location (/run/secrets/KEY - case insensitive)
+// 7. Return provided default value
+func getConfig(key string, defaultValue string) string {
+	keyLower := strings.ToLower(key)
+	keyUpper := strings.ToUpper(key)
+	// Create title case manually (first char upper, rest lower)
+	//keyTitle := ""
+	//if len(keyLower) > 0 {
+	//	keyTitle = strings.ToUpper(string(keyLower[0])) + keyLower[1:]
+	//}
+
+	// Check program arguments first
+	if value, ok := argumentValue(key); ok {
+		return value
+	}
 
 	// Try to read from file specified in KEY_FILE env var
 	// fileEnvVar := keyUpper + "_FILE"
@@ -189,17 +250,25 @@ func getConfig(key string, defaultValue string) string {
 		return value
 	}
 
+	// Check the config file; the profile key itself selects the profile and isn't read from it
+	if keyLower != "profile" {
+		if value, profile, ok := userConfigValue(keyLower); ok {
+			configLog.Debug("Loaded from the config file", "key", keyUpper, "profile", profile)
+			return value
+		}
+	}
+
 	// Check prod.env (case insensitive) - only if ProdEnvPath is initialized
 	// This avoids circular dependency during path initialization
 	if ProdEnvPath != "" {
 		envVars, err := readProdEnv(ProdEnvPath)
 		if err != nil {
-			log.Printf("Warning: Failed to read prod.env for %s: %v", keyUpper, err)
+			configLog.Debug("Failed to read prod.env", "key", keyUpper, "err", err)
 		} else {
 			// Try case-insensitive lookup
 			for envKey, value := range envVars {
 				if strings.ToLower(envKey) == keyLower {
-					log.Printf("Loaded %s from prod.env: %s", keyUpper, envKey)
+					configLog.Debug("Loaded from prod.env", "key", keyUpper, "variable", envKey)
 					return value
 				}
 			}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGetFlagReadsOnlyTheArguments(t *testing.T) {
+	oldArgs := os.Args
+	t.Cleanup(func() { os.Args = oldArgs })
+	t.Setenv("FORCE", "true")
+	t.Setenv("NAME", "from-env")
+
+	os.Args = []string{"dc", "stack", "import", "./project"}
+	if getFlagBool("force", false) {
+		t.Error("getFlagBool read --force from the environment")
+	}
+	if got := getFlag("name", "default"); got != "default" {
+		t.Errorf("getFlag(name) = %q, want the default", got)
+	}
+	if got := getConfig("name", "default"); got != "from-env" {
+		t.Errorf("getConfig(name) = %q, want the environment", got)
+	}
+
+	os.Args = []string{"dc", "stack", "import", "./project", "--force=yes", "--name", "site", "--", "--all=true"}
+	if !getFlagBool("force", false) {
+		t.Error("getFlagBool missed --force=yes")
+	}
+	if got := getFlag("name", ""); got != "site" {
+		t.Errorf("getFlag(name) = %q, want site", got)
+	}
+	if getFlagBool("all", false) {
+		t.Error("getFlagBool read a flag after --")
+	}
+}
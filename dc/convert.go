@@ -0,0 +1,609 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dc/internal/compose"
+	"dc/internal/secrets"
+)
+
+// ConvertResult is the output of `dc convert run --output-format=json`
+type ConvertResult struct {
+	YAML     string   `json:"yaml"`
+	Stack    string   `json:"stack"`
+	Warnings []string `json:"warnings"`
+}
+
+// runShortOptions are the one-letter options of docker run by their long name
+var runShortOptions = map[byte]string{
+	'd': "detach",
+	'e': "env",
+	'h': "hostname",
+	'i': "interactive",
+	'l': "label",
+	'm': "memory",
+	'p': "publish",
+	'P': "publish-all",
+	't': "tty",
+	'u': "user",
+	'v': "volume",
+	'w': "workdir",
+}
+
+// runBoolOptions are the docker run options without a value
+var runBoolOptions = map[string]bool{
+	"detach": true, "interactive": true, "tty": true, "rm": true, "init": true, "privileged": true,
+	"read-only": true, "no-healthcheck": true, "publish-all": true,
+}
+
+// runValueOptions are the docker run options with a value that dc converts, "net" is the old
+// name of --network
+var runValueOptions = map[string]bool{
+	"publish": true, "volume": true, "mount": true, "env": true, "env-file": true, "label": true,
+	"name": true, "restart": true, "network": true, "net": true, "network-alias": true, "user": true,
+	"workdir": true, "hostname": true, "entrypoint": true, "cap-add": true, "cap-drop": true,
+	"sysctl": true, "add-host": true, "device": true, "dns": true, "security-opt": true,
+	"tmpfs": true, "group-add": true, "ulimit": true, "shm-size": true, "stop-signal": true,
+	"pid": true, "ipc": true, "memory": true, "memory-swap": true, "cpus": true, "gpus": true,
+	"log-driver": true, "log-opt": true, "health-cmd": true, "health-interval": true,
+	"health-timeout": true, "health-start-period": true, "health-retries": true, "expose": true,
+	"platform": true, "pull": true,
+}
+
+// runIgnoredOptions are converted to nothing, with the reason as a warning; detach is what
+// compose does anyway
+var runIgnoredOptions = map[string]string{
+	"rm":            "--rm has no compose equivalent, the container is kept when it stops",
+	"publish-all":   "-P has no compose equivalent, publish the ports with -p",
+	"network-alias": "--network-alias is not converted, add aliases to the service's networks",
+	"expose":        "--expose is not converted, services on a network reach all ports",
+	"platform":      "--platform is not converted",
+	"pull":          "--pull is not converted, use dc stack up --pull-before-up=true",
+}
+
+// serviceNameInvalid matches what compose doesn't allow in service names
+var serviceNameInvalid = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// splitCommandLine splits a shell command line into words: quotes group words, backslashes
+// escape the next character outside single quotes and continue a line before a newline.
+// Variables and other expansions are kept as written.
+func splitCommandLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+			if c != '\n' {
+				word.WriteRune(c)
+				inWord = true
+			}
+		case c == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// runOption is an option of a docker run command line, by its long name
+type runOption struct {
+	name  string
+	value string
+}
+
+// parseRunArgs splits docker run arguments into the options, the image and the command. A
+// leading "docker run", "docker container run" or "podman run" is skipped.
+func parseRunArgs(args []string) ([]runOption, string, []string, error) {
+	if len(args) > 0 && (args[0] == "docker" || args[0] == "podman") {
+		args = args[1:]
+	}
+	if len(args) > 0 && args[0] == "container" {
+		args = args[1:]
+	}
+	if len(args) > 0 && args[0] == "run" {
+		args = args[1:]
+	}
+
+	var options []runOption
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			if arg == "--" {
+				i++
+			}
+			if i >= len(args) {
+				break
+			}
+			return options, args[i], args[i+1:], nil
+		}
+
+		// value returns the value of a value option: the rest of the argument or the next one
+		value := func(inline string, hasInline bool) (string, error) {
+			if hasInline {
+				return inline, nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("option %s needs a value", arg)
+			}
+			i++
+			return args[i], nil
+		}
+
+		if strings.HasPrefix(arg, "--") {
+			name, inline, hasInline := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+			switch {
+			case runBoolOptions[name]:
+				if hasInline && inline != "true" {
+					if inline != "false" {
+						return nil, "", nil, fmt.Errorf("option --%s takes true or false, not %q", name, inline)
+					}
+					continue
+				}
+				options = append(options, runOption{name: name})
+			case runValueOptions[name]:
+				v, err := value(inline, hasInline)
+				if err != nil {
+					return nil, "", nil, err
+				}
+				if name == "net" {
+					name = "network"
+				}
+				options = append(options, runOption{name: name, value: v})
+			default:
+				return nil, "", nil, fmt.Errorf("docker run option --%s is not supported", name)
+			}
+			continue
+		}
+
+		// Short options: bool ones may be combined (-dit), the last may take a value (-p80:80)
+		letters := strings.TrimPrefix(arg, "-")
+		if letters == "" {
+			return nil, "", nil, fmt.Errorf("unexpected argument -")
+		}
+		for j := 0; j < len(letters); j++ {
+			name, ok := runShortOptions[letters[j]]
+			if !ok {
+				return nil, "", nil, fmt.Errorf("docker run option -%c is not supported", letters[j])
+			}
+			if runBoolOptions[name] {
+				options = append(options, runOption{name: name})
+				continue
+			}
+			inline := strings.TrimPrefix(letters[j+1:], "=")
+			v, err := value(inline, inline != "")
+			if err != nil {
+				return nil, "", nil, err
+			}
+			options = append(options, runOption{name: name, value: v})
+			break
+		}
+	}
+	return nil, "", nil, fmt.Errorf("no image given")
+}
+
+// runServiceName is the service name of a converted container: its --name, else the image
+// name without registry, tag and digest
+func runServiceName(options []runOption, image string) string {
+	name := ""
+	for _, option := range options {
+		if option.name == "name" {
+			name = option.value
+		}
+	}
+	if name == "" {
+		name, _, _ = strings.Cut(image, "@")
+		name = path.Base(name)
+		name, _, _ = strings.Cut(name, ":")
+	}
+	return sanitizeServiceName(name)
+}
+
+// sanitizeServiceName turns a container or image name into a service name compose accepts
+func sanitizeServiceName(name string) string {
+	return strings.Trim(serviceNameInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-.")
+}
+
+// convertMount turns a --mount specification into a volume or tmpfs entry
+func convertMount(spec string, service *compose.Service) error {
+	fields := map[string]string{"type": "volume"}
+	for _, field := range strings.Split(spec, ",") {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "src":
+			key = "source"
+		case "dst", "destination":
+			key = "target"
+		case "ro":
+			key = "readonly"
+		}
+		fields[key] = value
+	}
+	for key := range fields {
+		switch key {
+		case "type", "source", "target", "readonly":
+		default:
+			return fmt.Errorf("--mount option %s is not supported", key)
+		}
+	}
+	if fields["target"] == "" {
+		return fmt.Errorf("--mount %s has no target", spec)
+	}
+	readonly := false
+	if value, ok := fields["readonly"]; ok {
+		readonly = value == "" || value == "true" || value == "1"
+	}
+	switch fields["type"] {
+	case "bind", "volume":
+		volume := fields["target"]
+		if fields["source"] != "" {
+			volume = fields["source"] + ":" + volume
+		}
+		if readonly {
+			volume += ":ro"
+		}
+		service.Volumes = append(service.Volumes, volume)
+	case "tmpfs":
+		tmpfs, _ := service.Tmpfs.([]string)
+		service.Tmpfs = append(tmpfs, fields["target"])
+	default:
+		return fmt.Errorf("--mount type %s is not supported", fields["type"])
+	}
+	return nil
+}
+
+// convertUlimit turns a --ulimit name=soft[:hard] into a compose ulimit
+func convertUlimit(spec string, service *compose.Service) error {
+	name, limits, ok := strings.Cut(spec, "=")
+	if !ok {
+		return fmt.Errorf("invalid --ulimit %q, expected <name>=<soft>[:<hard>]", spec)
+	}
+	softValue, hardValue, hasHard := strings.Cut(limits, ":")
+	soft, err := strconv.ParseInt(softValue, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid --ulimit %q: %w", spec, err)
+	}
+	if service.Ulimits == nil {
+		service.Ulimits = make(map[string]interface{})
+	}
+	if !hasHard {
+		service.Ulimits[name] = soft
+		return nil
+	}
+	hard, err := strconv.ParseInt(hardValue, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid --ulimit %q: %w", spec, err)
+	}
+	service.Ulimits[name] = map[string]interface{}{"soft": soft, "hard": hard}
+	return nil
+}
+
+// convertRunCommand builds a compose service from docker run arguments. It returns the
+// service name, the service and warnings about options that have no compose equivalent.
+// allowEnvFile is false for callers that must not read files of this host, e.g. dcapi.
+func convertRunCommand(args []string, allowEnvFile bool) (string, compose.Service, []string, error) {
+	options, image, command, err := parseRunArgs(args)
+	if err != nil {
+		return "", compose.Service{}, nil, err
+	}
+	serviceName := runServiceName(options, image)
+	if serviceName == "" {
+		return "", compose.Service{}, nil, fmt.Errorf("cannot derive a service name from image %q, pass --name", image)
+	}
+
+	service := compose.Service{Image: image}
+	if len(command) > 0 {
+		service.Command = escapeAllDollars(command)
+	}
+	var warnings []string
+	var environment []string
+	labels := make(map[string]interface{})
+	sysctls := make(map[string]interface{})
+	var networks []interface{}
+	var extraHosts, dns []string
+	healthcheck := make(map[string]interface{})
+	for _, option := range options {
+		value := option.value
+		switch option.name {
+		case "detach":
+		case "interactive":
+			service.StdinOpen = true
+		case "tty":
+			service.Tty = true
+		case "init":
+			service.Init = true
+		case "privileged":
+			service.Privileged = true
+		case "read-only":
+			service.ReadOnly = true
+		case "name":
+			service.ContainerName = value
+		case "publish":
+			service.Ports = append(service.Ports, value)
+		case "volume":
+			service.Volumes = append(service.Volumes, value)
+		case "mount":
+			if err := convertMount(value, &service); err != nil {
+				return "", compose.Service{}, nil, err
+			}
+		case "env":
+			// -e KEY passes the variable of the calling shell, which the stack file can't
+			if key, val, ok := strings.Cut(value, "="); ok {
+				environment = append(environment, key+"="+compose.EscapeDollars(val))
+			} else {
+				warnings = append(warnings, fmt.Sprintf("-e %s takes its value from the calling shell and is not converted, add %s=<value>", value, value))
+			}
+		case "env-file":
+			// The variables are inlined, the stack file can't refer to a file of this host
+			if !allowEnvFile {
+				return "", compose.Service{}, nil, fmt.Errorf("--env-file is not allowed here, pass the variables with -e")
+			}
+			if _, err := os.Stat(value); err != nil {
+				return "", compose.Service{}, nil, fmt.Errorf("--env-file: %w", err)
+			}
+			vars, err := secrets.ReadEnvFile(value)
+			if err != nil {
+				return "", compose.Service{}, nil, fmt.Errorf("--env-file: %w", err)
+			}
+			keys := make([]string, 0, len(vars))
+			for key := range vars {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				environment = append(environment, key+"="+compose.EscapeDollars(vars[key]))
+			}
+		case "label":
+			key, val, _ := strings.Cut(value, "=")
+			labels[key] = compose.EscapeDollars(val)
+		case "restart":
+			service.Restart = value
+		case "network":
+			switch {
+			case value == "bridge" || value == "default":
+			case value == "host" || value == "none" || strings.HasPrefix(value, "container:"):
+				service.NetworkMode = value
+			default:
+				networks = append(networks, value)
+			}
+		case "user":
+			service.User = value
+		case "workdir":
+			service.WorkingDir = value
+		case "hostname":
+			service.Hostname = value
+		case "entrypoint":
+			if value != "" {
+				service.Entrypoint = []string{compose.EscapeDollars(value)}
+			}
+		case "cap-add":
+			service.CapAdd = append(service.CapAdd, value)
+		case "cap-drop":
+			service.CapDrop = append(service.CapDrop, value)
+		case "sysctl":
+			key, val, _ := strings.Cut(value, "=")
+			sysctls[key] = val
+		case "add-host":
+			extraHosts = append(extraHosts, value)
+		case "device":
+			service.Devices = append(service.Devices, value)
+		case "dns":
+			dns = append(dns, value)
+		case "security-opt":
+			service.SecurityOpt = append(service.SecurityOpt, value)
+		case "tmpfs":
+			tmpfs, _ := service.Tmpfs.([]string)
+			service.Tmpfs = append(tmpfs, value)
+		case "group-add":
+			service.GroupAdd = append(service.GroupAdd, value)
+		case "ulimit":
+			if err := convertUlimit(value, &service); err != nil {
+				return "", compose.Service{}, nil, err
+			}
+		case "shm-size":
+			service.ShmSize = value
+		case "stop-signal":
+			service.StopSignal = value
+		case "pid":
+			service.Pid = value
+		case "ipc":
+			service.Ipc = value
+		case "memory":
+			service.MemLimit = value
+		case "memory-swap":
+			if value == "-1" {
+				service.MemswapLimit = -1
+				break
+			}
+			size, err := parseByteSize(value)
+			if err != nil {
+				return "", compose.Service{}, nil, fmt.Errorf("invalid --memory-swap %q: %w", value, err)
+			}
+			service.MemswapLimit = int64(size)
+		case "cpus":
+			cpus, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return "", compose.Service{}, nil, fmt.Errorf("invalid --cpus %q: %w", value, err)
+			}
+			service.CPUs = cpus
+		case "gpus":
+			device := map[string]interface{}{"driver": "nvidia", "capabilities": []string{"gpu"}}
+			if value == "all" {
+				device["count"] = "all"
+			} else if count, err := strconv.Atoi(value); err == nil {
+				device["count"] = count
+			} else {
+				return "", compose.Service{}, nil, fmt.Errorf("--gpus %s is not supported, only all or a number", value)
+			}
+			service.Deploy = map[string]interface{}{
+				"resources": map[string]interface{}{"reservations": map[string]interface{}{"devices": []interface{}{device}}},
+			}
+		case "log-driver":
+			if service.Logging == nil {
+				service.Logging = &compose.Logging{}
+			}
+			service.Logging.Driver = value
+		case "log-opt":
+			if service.Logging == nil {
+				service.Logging = &compose.Logging{Driver: defaultLogDriver}
+			}
+			if service.Logging.Options == nil {
+				service.Logging.Options = make(map[string]string)
+			}
+			key, val, _ := strings.Cut(value, "=")
+			service.Logging.Options[key] = val
+		case "health-cmd":
+			healthcheck["test"] = []string{"CMD-SHELL", compose.EscapeDollars(value)}
+		case "health-interval":
+			healthcheck["interval"] = value
+		case "health-timeout":
+			healthcheck["timeout"] = value
+		case "health-start-period":
+			healthcheck["start_period"] = value
+		case "health-retries":
+			retries, err := strconv.Atoi(value)
+			if err != nil {
+				return "", compose.Service{}, nil, fmt.Errorf("invalid --health-retries %q: %w", value, err)
+			}
+			healthcheck["retries"] = retries
+		case "no-healthcheck":
+			healthcheck = map[string]interface{}{"disable": true}
+		default:
+			if reason, ok := runIgnoredOptions[option.name]; ok {
+				warnings = append(warnings, reason)
+			}
+		}
+	}
+
+	if len(environment) > 0 {
+		service.Environment = environment
+	}
+	if len(labels) > 0 {
+		service.Labels = labels
+	}
+	if len(sysctls) > 0 {
+		service.Sysctls = sysctls
+	}
+	if len(networks) > 0 {
+		if service.NetworkMode != "" {
+			return "", compose.Service{}, nil, fmt.Errorf("--network %s can't be combined with other networks", service.NetworkMode)
+		}
+		service.Networks = networks
+	}
+	if len(extraHosts) > 0 {
+		service.ExtraHosts = extraHosts
+	}
+	if len(dns) > 0 {
+		service.DNS = dns
+	}
+	if len(healthcheck) > 0 {
+		if _, ok := healthcheck["test"]; !ok && healthcheck["disable"] == nil {
+			warnings = append(warnings, "the --health-* options need --health-cmd, they are not converted")
+		} else {
+			service.Healthcheck = healthcheck
+		}
+	}
+	return serviceName, service, warnings, nil
+}
+
+// HandleConvertRun converts a docker run command into a stack: the arguments after "--", or a
+// command line read from stdin. It prints the stack file, or with --output-format=json a
+// ConvertResult. With --save=true the stack is saved like `dc stack import` does, moving
+// plaintext passwords to the secrets store; --name names it, else the service does. Printed
+// stacks show the references --save would store the passwords under instead of the values.
+// --allow-env-file=false rejects --env-file, which reads a file of this host.
+func HandleConvertRun(args []string) error {
+	var runArgs []string
+	for i, arg := range args {
+		if arg == "--" {
+			runArgs = args[i+1:]
+			break
+		}
+	}
+	if runArgs == nil {
+		line, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+		if runArgs, err = splitCommandLine(string(line)); err != nil {
+			return err
+		}
+	}
+
+	serviceName, service, warnings, err := convertRunCommand(runArgs, getFlagBool("allow_env_file", true))
+	if err != nil {
+		return err
+	}
+	stackName := getFlag("name", serviceName)
+	if err := validateStackName(stackName); err != nil {
+		return err
+	}
+	composeFile := compose.File{Services: map[string]compose.Service{serviceName: service}}
+	save := getFlagBool("save", false)
+	if !save {
+		warnings = append(warnings, maskConvertedSecrets(&composeFile, stackName)...)
+	}
+	for _, warning := range warnings {
+		stackLog.Warn(warning)
+	}
+	if save {
+		return saveImportedStack(&composeFile, stackName, "docker run", "")
+	}
+
+	var buf strings.Builder
+	if err := encodeYAMLWithMultiline(&buf, &composeFile); err != nil {
+		return err
+	}
+	if OutputFormat == OutputFormatJSON {
+		if warnings == nil {
+			warnings = []string{}
+		}
+		return json.NewEncoder(os.Stdout).Encode(ConvertResult{YAML: buf.String(), Stack: stackName, Warnings: warnings})
+	}
+	os.Stdout.WriteString(buf.String())
+	return nil
+}
+
+// maskConvertedSecrets masks the passwords of a converted stack that is printed rather than
+// saved, returning a warning for every masked value
+func maskConvertedSecrets(composeFile *compose.File, stackName string) []string {
+	var warnings []string
+	for _, secret := range maskComposePasswords(composeFile, stackName) {
+		warnings = append(warnings, fmt.Sprintf("the value of %s of service %s is replaced by ${%s}, the variable --save=true stores it in", secret.Key, secret.Service, secret.Variable))
+	}
+	return warnings
+}
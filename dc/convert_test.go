@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"dc/internal/compose"
+)
+
+func TestConvertRunCommandEnvFile(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), "prod.env")
+	if err := os.WriteFile(envFile, []byte("ADMIN_PASSWORD=hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"docker", "run", "--env-file", envFile, "nginx"}
+	if _, _, _, err := convertRunCommand(args, false); err == nil {
+		t.Error("convertRunCommand read --env-file though it isn't allowed")
+	}
+	_, service, _, err := convertRunCommand(args, true)
+	if err != nil {
+		t.Fatalf("convertRunCommand: %v", err)
+	}
+	if want := []string{"ADMIN_PASSWORD=hunter2"}; !reflect.DeepEqual(compose.NormalizeEnvironment(service.Environment), want) {
+		t.Errorf("environment = %v, want %v", service.Environment, want)
+	}
+}
+
+func TestMaskConvertedSecrets(t *testing.T) {
+	StacksDir = t.TempDir()
+	composeFile := compose.File{Services: map[string]compose.Service{"nginx": {
+		Environment: []string{"DB_PASSWORD=plain", "TZ=UTC", "API_TOKEN=${TOKEN}"},
+	}}}
+	warnings := maskConvertedSecrets(&composeFile, "web")
+	want := []string{"DB_PASSWORD=${WEB_DB_PASSWORD}", "TZ=UTC", "API_TOKEN=${TOKEN}"}
+	if got := compose.NormalizeEnvironment(composeFile.Services["nginx"].Environment); !reflect.DeepEqual(got, want) {
+		t.Errorf("environment = %v, want %v", got, want)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("warnings = %q, want one for DB_PASSWORD", warnings)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+)
+
+// Dashboard label flavors (dashboard_labels, comma-separated): the dashboards whose docker
+// discovery labels enrichment adds to the services listed in x-composectl.dashboard
+const (
+	DashboardHomepage = "homepage"
+	DashboardDashy    = "dashy"
+	DashboardHomarr   = "homarr"
+)
+
+// dashboardLabelKeys are the label keys of each flavor for the name, group, icon, URL and
+// description of a service, below the flavor's prefix
+var dashboardLabelKeys = map[string][5]string{
+	DashboardHomepage: {"name", "group", "icon", "href", "description"},
+	DashboardDashy:    {"title", "section", "icon", "url", "description"},
+	DashboardHomarr:   {"name", "group", "icon", "url", "description"},
+}
+
+// dashboardFlavors returns the flavors of dashboard_labels (default homepage); "none" adds no
+// labels. Unknown flavors are skipped with a warning.
+func dashboardFlavors() []string {
+	var flavors []string
+	for _, flavor := range strings.Split(getConfig("dashboard_labels", DashboardHomepage), ",") {
+		flavor = strings.ToLower(strings.TrimSpace(flavor))
+		switch {
+		case flavor == "" || flavor == "none":
+		case dashboardLabelKeys[flavor] == [5]string{}:
+			enrichLog.Warn("Unknown dashboard label flavor, skipping", "flavor", flavor)
+		default:
+			flavors = append(flavors, flavor)
+		}
+	}
+	return flavors
+}
+
+// dashboardURL returns the URL of a service on its proxy host if it serves HTTP, with the
+// scheme of dashboard_scheme (default http)
+func dashboardURL(service *compose.Service, serviceName string) string {
+	if _, _, ok := compose.DetectHTTPPort(service); !ok {
+		return ""
+	}
+	return getConfig("dashboard_scheme", "http") + "://" + proxyHost(serviceName)
+}
+
+// ensureDashboardLabels adds the dashboard labels of every flavor to the services listed in
+// x-composectl.dashboard, so that homepage, Dashy or Homarr list them, in group unless an entry
+// names its own. Labels already set in the stack file are kept. It returns warnings about
+// entries of unknown services.
+func ensureDashboardLabels(composeFile *compose.File, group string) []string {
+	if composeFile == nil || composeFile.Composectl == nil || len(composeFile.Composectl.Dashboard) == 0 {
+		return nil
+	}
+	flavors := dashboardFlavors()
+	if len(flavors) == 0 {
+		return nil
+	}
+
+	var warnings []string
+	for serviceName, entry := range composeFile.Composectl.Dashboard {
+		service, ok := composeFile.Services[serviceName]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("x-composectl.dashboard lists the unknown service %s", serviceName))
+			continue
+		}
+		if entry.Name == "" {
+			entry.Name = serviceName
+		}
+		if entry.Group == "" {
+			entry.Group = group
+		}
+		if entry.URL == "" {
+			entry.URL = dashboardURL(&service, serviceName)
+		}
+		values := [5]string{entry.Name, entry.Group, entry.Icon, entry.URL, entry.Description}
+
+		enrichLog.Info("Adding dashboard labels", "service", serviceName, "flavors", strings.Join(flavors, ","))
+		flat := compose.LabelsToMap(service.Labels)
+		for _, flavor := range flavors {
+			for i, key := range dashboardLabelKeys[flavor] {
+				key = flavor + "." + key
+				if _, set := flat[key]; !set && values[i] != "" {
+					flat[key] = values[i]
+				}
+			}
+		}
+		service.Labels = compose.MapToLabels(flat, service.Labels)
+		composeFile.Services[serviceName] = service
+	}
+	sort.Strings(warnings)
+	return warnings
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"dc/internal/compose"
+	"dc/internal/docker"
+)
+
+// defaultWaitTimeout bounds --wait-healthy (config key wait_timeout)
+const defaultWaitTimeout = 5 * time.Minute
+
+// normalizeDependsOn returns the services a service depends on with their condition.
+// depends_on can be a list of service names (condition service_started) or a map of
+// service name to {condition: ...}.
+func normalizeDependsOn(dependsOn interface{}) map[string]string {
+	deps := make(map[string]string)
+	switch v := dependsOn.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if name, ok := item.(string); ok {
+				deps[name] = compose.ConditionServiceStarted
+			}
+		}
+	case []string:
+		for _, name := range v {
+			deps[name] = compose.ConditionServiceStarted
+		}
+	case map[string]interface{}:
+		for name, options := range v {
+			deps[name] = compose.ConditionServiceStarted
+			if m, ok := options.(map[string]interface{}); ok {
+				if condition, ok := m["condition"].(string); ok && condition != "" {
+					deps[name] = condition
+				}
+			}
+		}
+	}
+	return deps
+}
+
+// startupOrder returns the services of a compose file ordered so that every service comes
+// after the services it depends on. Services in a dependency cycle are appended last.
+func startupOrder(composeFile *compose.File) []string {
+	names := make([]string, 0, len(composeFile.Services))
+	for name := range composeFile.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var order []string
+	state := make(map[string]int) // 0 unvisited, 1 visiting, 2 done
+	var visit func(name string)
+	visit = func(name string) {
+		if state[name] != 0 {
+			return
+		}
+		state[name] = 1
+		deps := normalizeDependsOn(composeFile.Services[name].DependsOn)
+		depNames := make([]string, 0, len(deps))
+		for dep := range deps {
+			depNames = append(depNames, dep)
+		}
+		sort.Strings(depNames)
+		for _, dep := range depNames {
+			if _, ok := composeFile.Services[dep]; ok && state[dep] == 0 {
+				visit(dep)
+			}
+		}
+		state[name] = 2
+		order = append(order, name)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return order
+}
+
+// dependencyCycle returns the services of a depends_on cycle, or nil if there is none
+func dependencyCycle(composeFile *compose.File) []string {
+	state := make(map[string]int)
+	var path []string
+	var cycle []string
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		state[name] = 1
+		path = append(path, name)
+		for dep := range normalizeDependsOn(composeFile.Services[name].DependsOn) {
+			if _, ok := composeFile.Services[dep]; !ok {
+				continue
+			}
+			if state[dep] == 1 {
+				for i, p := range path {
+					if p == dep {
+						cycle = append(append([]string{}, path[i:]...), dep)
+					}
+				}
+				return true
+			}
+			if state[dep] == 0 && visit(dep) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = 2
+		return false
+	}
+	names := make([]string, 0, len(composeFile.Services))
+	for name := range composeFile.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if state[name] == 0 && visit(name) {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// serviceReadiness describes whether the containers of a service are ready:
+// healthy when it has a healthcheck, running otherwise. done is false while it may still become ready.
+func serviceReadiness(containers []docker.Inspect) (ready bool, done bool, status string) {
+	if len(containers) == 0 {
+		return false, false, "no container yet"
+	}
+	ready = true
+	for _, c := range containers {
+		switch {
+		case c.State.Health != nil && c.State.Health.Status == "unhealthy":
+			return false, true, "unhealthy"
+		case c.State.Health != nil && c.State.Health.Status != "healthy":
+			ready, status = false, c.State.Health.Status
+		case c.State.Status == "exited" && c.State.ExitCode != 0:
+			return false, true, fmt.Sprintf("exited with code %d", c.State.ExitCode)
+		case c.State.Status == "exited":
+			status = "completed"
+		case !c.State.Running:
+			ready, status = false, c.State.Status
+		case c.State.Health == nil && status == "":
+			status = "running (no healthcheck)"
+		case c.State.Health != nil && status == "":
+			status = "healthy"
+		}
+	}
+	return ready, ready, status
+}
+
+// waitHealthy blocks until every service of the stack is ready, reporting each service once
+// it is ready in startup order. It fails when a service turns unhealthy or exits with an error,
+// or when the timeout (--wait-timeout, default 5m) passes.
+func waitHealthy(composeFile *compose.File, stackName string) error {
+	timeout, err := time.ParseDuration(getConfig("wait_timeout", ""))
+	if err != nil || timeout <= 0 {
+		timeout = defaultWaitTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	order := startupOrder(composeFile)
+	reported := make(map[string]bool)
+	writeFrame(FrameStdout, fmt.Sprintf("Waiting for %d services of %s to become healthy", len(order), stackName))
+
+	for {
+		out, err := engineCommand("ps", "-aq", "--filter", "label=com.docker.compose.project="+stackName).Output()
+		if err != nil {
+			return fmt.Errorf("docker ps failed: %w", err)
+		}
+		containers, err := inspectContainers(strings.Fields(string(out)))
+		if err != nil {
+			return err
+		}
+		byService := make(map[string][]docker.Inspect)
+		for _, c := range containers {
+			service := c.Config.Labels["com.docker.compose.service"]
+			byService[service] = append(byService[service], c)
+		}
+
+		var pending []string
+		for _, service := range order {
+			if reported[service] {
+				continue
+			}
+			ready, done, status := serviceReadiness(byService[service])
+			if ready {
+				reported[service] = true
+				writeFrame(FrameStdout, fmt.Sprintf("[READY] %s: %s", service, status))
+			} else if done {
+				return fmt.Errorf("service %s is %s", service, status)
+			} else {
+				pending = append(pending, service+" ("+status+")")
+			}
+		}
+		if len(pending) == 0 {
+			writeFrame(FrameDone, fmt.Sprintf("All services of %s are healthy", stackName))
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for %s", timeout, strings.Join(pending, ", "))
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
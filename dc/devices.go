@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultDevicePollInterval is how often watched device paths are checked (config key device_poll_interval)
+const defaultDevicePollInterval = 2 * time.Second
+
+// watchedDevice is a device path and the services to restart when it returns
+type watchedDevice struct {
+	Stack    string
+	Path     string
+	Services []string
+}
+
+// deviceServices returns the services of a compose file mapping the host device path
+func deviceServices(composeFile *compose.File, path string) []string {
+	var services []string
+	for name, service := range composeFile.Services {
+		for _, device := range service.Devices {
+			host, _, _ := strings.Cut(device, ":")
+			if host == path {
+				services = append(services, name)
+				break
+			}
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+// collectDeviceWatches reads x-composectl.devices.watch from all stack files
+func collectDeviceWatches() []watchedDevice {
+	var watches []watchedDevice
+	seen := make(map[string]bool)
+	for _, dir := range getAllStackDirs() {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.yml"))
+		for _, file := range files {
+			if strings.HasSuffix(file, ".effective.yml") {
+				continue
+			}
+			stackName := strings.TrimSuffix(filepath.Base(file), ".yml")
+			if seen[stackName] {
+				continue
+			}
+			seen[stackName] = true
+
+			content, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			var composeFile compose.File
+			if err := yaml.Unmarshal(content, &composeFile); err != nil || composeFile.Composectl == nil || composeFile.Composectl.Devices == nil {
+				continue
+			}
+			for _, watch := range composeFile.Composectl.Devices.Watch {
+				if watch.Path == "" {
+					continue
+				}
+				services := []string{watch.Service}
+				if watch.Service == "" {
+					services = deviceServices(&composeFile, watch.Path)
+				}
+				if len(services) == 0 {
+					devicesLog.Debug("Stack watches a device no service maps", "stack", stackName, "device", watch.Path)
+					continue
+				}
+				watches = append(watches, watchedDevice{Stack: stackName, Path: watch.Path, Services: services})
+			}
+		}
+	}
+	return watches
+}
+
+// restartServiceContainers restarts all containers (running or not) of a stack's service
+func restartServiceContainers(stackName, service string) error {
+	out, err := engineCommand("ps", "-a", "-q",
+		"--filter", "label=com.docker.compose.project="+stackName,
+		"--filter", "label=com.docker.compose.service="+service).Output()
+	if err != nil {
+		return fmt.Errorf("docker ps failed: %w", err)
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return fmt.Errorf("service %s of stack %s has no containers", service, stackName)
+	}
+	if output, err := engineCommand(append([]string{"restart"}, ids...)...).CombinedOutput(); err != nil {
+		return fmt.Errorf("docker restart failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// HandleWatchDevices polls the device paths configured via x-composectl.devices.watch and
+// restarts the dependent services whenever a removed device appears again. It runs until killed.
+func HandleWatchDevices() error {
+	interval, err := time.ParseDuration(getConfig("device_poll_interval", defaultDevicePollInterval.String()))
+	if err != nil || interval <= 0 {
+		interval = defaultDevicePollInterval
+	}
+
+	present := make(map[string]bool)
+	devicesLog.Info("Watching devices", "interval", interval)
+	for {
+		for _, watch := range collectDeviceWatches() {
+			key := watch.Stack + "\x00" + watch.Path
+			_, statErr := os.Stat(watch.Path)
+			exists := statErr == nil
+			was, known := present[key]
+			present[key] = exists
+
+			switch {
+			case !known:
+				if !exists {
+					devicesLog.Warn("Device is missing", "device", watch.Path, "stack", watch.Stack)
+				}
+			case was && !exists:
+				devicesLog.Warn("Device was removed", "device", watch.Path, "stack", watch.Stack)
+			case !was && exists:
+				devicesLog.Info("Device is back, restarting its services", "device", watch.Path, "services", strings.Join(watch.Services, ", "), "stack", watch.Stack)
+				if DryRun {
+					continue
+				}
+				for _, service := range watch.Services {
+					if err := restartServiceContainers(watch.Stack, service); err != nil {
+						devicesLog.Error(err.Error())
+					}
+				}
+			}
+		}
+		pingHeartbeat("devices")
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultDNSTTL is the TTL of the records dc creates, in seconds
+const defaultDNSTTL = 300
+
+// DNSSettings configures the DNS records of the hosts the proxy labels route (config key
+// dns_file, default settings/dns.yml in the stacks dir, which dcapi manages under /api/settings/dns). Without the file no
+// records are managed.
+type DNSSettings struct {
+	Target    string              `yaml:"target"` // what the records point at: the proxy's address or host name
+	TTL       int                 `yaml:"ttl,omitempty"`
+	Providers []DNSProviderConfig `yaml:"providers"`
+}
+
+// DNSProviderConfig is a DNS provider responsible for the hosts in Zone. Target overrides the
+// target of the settings, e.g. a LAN address for the local resolver.
+type DNSProviderConfig struct {
+	Type   string `yaml:"type"` // cloudflare, pihole or rfc2136
+	Zone   string `yaml:"zone"`
+	Target string `yaml:"target,omitempty"`
+
+	// cloudflare: an API token with DNS edit permission; the zone ID is looked up if unset
+	Token  string `yaml:"token,omitempty"`
+	ZoneID string `yaml:"zone_id,omitempty"`
+
+	// pihole: the address of Pi-hole (v6) and its app password
+	URL      string `yaml:"url,omitempty"`
+	Password string `yaml:"password,omitempty"`
+
+	// rfc2136: the primary server (host[:port]) and the TSIG key, sent through nsupdate
+	Server       string `yaml:"server,omitempty"`
+	KeyName      string `yaml:"key_name,omitempty"`
+	KeySecret    string `yaml:"key_secret,omitempty"`
+	KeyAlgorithm string `yaml:"key_algorithm,omitempty"` // default hmac-sha256
+}
+
+// dnsRecord is the record of a host: A, AAAA or CNAME depending on the target
+type dnsRecord struct {
+	Type  string
+	Value string
+	TTL   int
+}
+
+// dnsProvider creates and removes the records of hosts
+type dnsProvider interface {
+	String() string
+	// Upsert creates the record of host, replacing a record of the same type with another value
+	Upsert(host string, record dnsRecord) error
+	// Delete removes the record of host if it still has the value dc gave it
+	Delete(host string, record dnsRecord) error
+}
+
+// hostRulePattern matches the Host() matchers of Traefik router rules
+var hostRulePattern = regexp.MustCompile("\\bHost\\(([^)]*)\\)")
+
+// getDNSSettingsPath returns the file holding the DNS settings (config key dns_file)
+func getDNSSettingsPath() string {
+	return getConfig("dns_file", getSettingsPath("dns.yml"))
+}
+
+// loadDNSSettings reads the DNS settings, nil if there are none
+func loadDNSSettings() (*DNSSettings, error) {
+	content, err := os.ReadFile(getDNSSettingsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var settings DNSSettings
+	if err := yaml.Unmarshal(content, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", getDNSSettingsPath(), err)
+	}
+	return &settings, nil
+}
+
+// routedHosts returns the hosts of the Host() rules of the Traefik routers of a compose file
+func routedHosts(composeFile *compose.File) []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, service := range composeFile.Services {
+		for key, value := range compose.LabelsToMap(service.Labels) {
+			if !strings.HasPrefix(key, "traefik.http.routers.") || !strings.HasSuffix(key, ".rule") {
+				continue
+			}
+			for _, match := range hostRulePattern.FindAllStringSubmatch(value, -1) {
+				for _, host := range strings.Split(match[1], ",") {
+					host = strings.ToLower(strings.Trim(strings.TrimSpace(host), "`\"'"))
+					if host != "" && !seen[host] {
+						seen[host] = true
+						hosts = append(hosts, host)
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// recordFor returns the record pointing a host at target
+func recordFor(target string, ttl int) dnsRecord {
+	record := dnsRecord{Type: "CNAME", Value: strings.TrimSuffix(target, "."), TTL: ttl}
+	if ip := net.ParseIP(target); ip != nil {
+		record.Type, record.Value = "A", ip.String()
+		if ip.To4() == nil {
+			record.Type = "AAAA"
+		}
+	}
+	return record
+}
+
+// providerFor returns the provider whose zone holds host, the longest zone winning
+func (s *DNSSettings) providerFor(host string) (DNSProviderConfig, bool) {
+	var best DNSProviderConfig
+	found := false
+	for _, p := range s.Providers {
+		zone := strings.ToLower(strings.Trim(p.Zone, "."))
+		if zone == "" || (host != zone && !strings.HasSuffix(host, "."+zone)) {
+			continue
+		}
+		if !found || len(zone) > len(strings.Trim(best.Zone, ".")) {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
+
+// newDNSProvider returns the provider of a configuration
+func newDNSProvider(config DNSProviderConfig) (dnsProvider, error) {
+	switch config.Type {
+	case "cloudflare":
+		if config.Token == "" {
+			return nil, fmt.Errorf("cloudflare provider of zone %s: token is required", config.Zone)
+		}
+		return &cloudflareDNS{config: config}, nil
+	case "pihole":
+		if config.URL == "" {
+			return nil, fmt.Errorf("pihole provider of zone %s: url is required", config.Zone)
+		}
+		return &piholeDNS{config: config}, nil
+	case "rfc2136":
+		if config.Server == "" {
+			return nil, fmt.Errorf("rfc2136 provider of zone %s: server is required", config.Zone)
+		}
+		return &rfc2136DNS{config: config}, nil
+	default:
+		return nil, fmt.Errorf("unknown DNS provider type %q (known: cloudflare, pihole, rfc2136)", config.Type)
+	}
+}
+
+// syncDNSRecords creates (present) or removes the records of the hosts routed to a stack's
+// services. Hosts outside the zones of all providers are skipped. Failures are reported but
+// never fail the stack action.
+func syncDNSRecords(composeFile *compose.File, stackName string, present bool) {
+	settings, err := loadDNSSettings()
+	if err != nil {
+		dnsLog.Warn("Failed to read the DNS settings", "err", err)
+		return
+	}
+	if settings == nil || len(settings.Providers) == 0 {
+		return
+	}
+	ttl := settings.TTL
+	if ttl <= 0 {
+		ttl = defaultDNSTTL
+	}
+	for _, host := range routedHosts(composeFile) {
+		config, ok := settings.providerFor(host)
+		if !ok {
+			continue
+		}
+		target := config.Target
+		if target == "" {
+			target = settings.Target
+		}
+		if target == "" {
+			dnsLog.Warn("No DNS target configured, skipping", "host", host)
+			continue
+		}
+		provider, err := newDNSProvider(config)
+		if err != nil {
+			dnsLog.Warn(err.Error())
+			continue
+		}
+		record := recordFor(target, ttl)
+		if present {
+			err = provider.Upsert(host, record)
+		} else {
+			err = provider.Delete(host, record)
+		}
+		if err != nil {
+			dnsLog.Warn("Failed to update DNS record", "stack", stackName, "host", host, "provider", provider.String(), "err", err)
+			continue
+		}
+		verb := "Created"
+		if !present {
+			verb = "Removed"
+		}
+		dnsLog.Info(verb+" DNS record", "stack", stackName, "host", host, "type", record.Type, "value", record.Value, "provider", provider.String())
+	}
+}
+
+// dnsHTTPClient is the client of the DNS provider APIs
+var dnsHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// dnsRequest sends a JSON request and decodes the JSON response into out if it is not nil
+func dnsRequest(method, address string, header http.Header, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, address, reader)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := dnsHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(content)))
+	}
+	if out != nil && len(content) > 0 {
+		return json.Unmarshal(content, out)
+	}
+	return nil
+}
+
+// cloudflareAPI is the base URL of the Cloudflare API
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// cloudflareDNS manages records through the Cloudflare API. Records are created unproxied.
+type cloudflareDNS struct {
+	config DNSProviderConfig
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+}
+
+func (c *cloudflareDNS) String() string { return "cloudflare:" + c.config.Zone }
+
+// call sends a request to the API and decodes the result of its envelope into out
+func (c *cloudflareDNS) call(method, path string, body, out interface{}) error {
+	var envelope struct {
+		Success bool                       `json:"success"`
+		Errors  []struct{ Message string } `json:"errors"`
+		Result  json.RawMessage            `json:"result"`
+	}
+	header := http.Header{"Authorization": {"Bearer " + c.config.Token}}
+	if err := dnsRequest(method, cloudflareAPI+path, header, body, &envelope); err != nil {
+		return err
+	}
+	if !envelope.Success {
+		var messages []string
+		for _, e := range envelope.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("cloudflare: %s", strings.Join(messages, "; "))
+	}
+	if out != nil {
+		return json.Unmarshal(envelope.Result, out)
+	}
+	return nil
+}
+
+// zoneID returns the configured zone ID, else looks it up by the zone name
+func (c *cloudflareDNS) zoneID() (string, error) {
+	if c.config.ZoneID != "" {
+		return c.config.ZoneID, nil
+	}
+	var zones []struct{ ID string }
+	if err := c.call(http.MethodGet, "/zones?name="+url.QueryEscape(strings.Trim(c.config.Zone, ".")), nil, &zones); err != nil {
+		return "", err
+	}
+	if len(zones) == 0 {
+		return "", fmt.Errorf("cloudflare: zone %s not found", c.config.Zone)
+	}
+	c.config.ZoneID = zones[0].ID
+	return c.config.ZoneID, nil
+}
+
+// records returns the records of host of a type
+func (c *cloudflareDNS) records(zoneID, host, recordType string) ([]cloudflareRecord, error) {
+	var records []cloudflareRecord
+	query := url.Values{"name": {host}, "type": {recordType}}
+	err := c.call(http.MethodGet, "/zones/"+zoneID+"/dns_records?"+query.Encode(), nil, &records)
+	return records, err
+}
+
+func (c *cloudflareDNS) Upsert(host string, record dnsRecord) error {
+	zoneID, err := c.zoneID()
+	if err != nil {
+		return err
+	}
+	existing, err := c.records(zoneID, host, record.Type)
+	if err != nil {
+		return err
+	}
+	want := cloudflareRecord{Type: record.Type, Name: host, Content: record.Value, TTL: record.TTL}
+	if len(existing) == 0 {
+		return c.call(http.MethodPost, "/zones/"+zoneID+"/dns_records", want, nil)
+	}
+	if existing[0].Content == record.Value && existing[0].TTL == record.TTL {
+		return nil
+	}
+	return c.call(http.MethodPut, "/zones/"+zoneID+"/dns_records/"+existing[0].ID, want, nil)
+}
+
+func (c *cloudflareDNS) Delete(host string, record dnsRecord) error {
+	zoneID, err := c.zoneID()
+	if err != nil {
+		return err
+	}
+	existing, err := c.records(zoneID, host, record.Type)
+	if err != nil {
+		return err
+	}
+	for _, r := range existing {
+		if r.Content != record.Value {
+			continue
+		}
+		if err := c.call(http.MethodDelete, "/zones/"+zoneID+"/dns_records/"+r.ID, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// piholeDNS manages the local DNS records of Pi-hole through its v6 API: A and AAAA records are
+// its hosts entries, CNAME records its cnameRecords
+type piholeDNS struct {
+	config DNSProviderConfig
+}
+
+func (p *piholeDNS) String() string { return "pihole:" + p.config.Zone }
+
+// session logs in with the app password and returns the header of the session and a function
+// ending it. Pi-hole without a password needs no session.
+func (p *piholeDNS) session() (http.Header, func(), error) {
+	base := strings.TrimSuffix(p.config.URL, "/")
+	if p.config.Password == "" {
+		return http.Header{}, func() {}, nil
+	}
+	var auth struct {
+		Session struct {
+			Valid bool   `json:"valid"`
+			SID   string `json:"sid"`
+		} `json:"session"`
+	}
+	if err := dnsRequest(http.MethodPost, base+"/api/auth", nil, map[string]string{"password": p.config.Password}, &auth); err != nil {
+		return nil, nil, err
+	}
+	if !auth.Session.Valid {
+		return nil, nil, fmt.Errorf("pihole: login failed")
+	}
+	header := http.Header{"X-Ftl-Sid": {auth.Session.SID}}
+	return header, func() { dnsRequest(http.MethodDelete, base+"/api/auth", header, nil, nil) }, nil
+}
+
+// entries returns the config list (hosts or cnameRecords) and the entry of a record in it
+func (p *piholeDNS) entries(host string, record dnsRecord) (string, string) {
+	if record.Type == "CNAME" {
+		return "cnameRecords", host + "," + record.Value
+	}
+	return "hosts", record.Value + " " + host
+}
+
+// piholeEntryHost returns the host of an entry of a config list
+func piholeEntryHost(list, entry string) string {
+	if list == "cnameRecords" {
+		host, _, _ := strings.Cut(entry, ",")
+		return host
+	}
+	fields := strings.Fields(entry)
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[1]
+}
+
+// list returns the entries of a config list
+func (p *piholeDNS) list(header http.Header, list string) ([]string, error) {
+	var config struct {
+		Config struct {
+			DNS map[string][]string `json:"dns"`
+		} `json:"config"`
+	}
+	err := dnsRequest(http.MethodGet, strings.TrimSuffix(p.config.URL, "/")+"/api/config/dns/"+list, header, nil, &config)
+	return config.Config.DNS[list], err
+}
+
+// change adds (PUT) or removes (DELETE) an entry of a config list
+func (p *piholeDNS) change(header http.Header, method, list, entry string) error {
+	return dnsRequest(method, strings.TrimSuffix(p.config.URL, "/")+"/api/config/dns/"+list+"/"+url.PathEscape(entry), header, nil, nil)
+}
+
+func (p *piholeDNS) Upsert(host string, record dnsRecord) error {
+	header, logout, err := p.session()
+	if err != nil {
+		return err
+	}
+	defer logout()
+	list, want := p.entries(host, record)
+	entries, err := p.list(header, list)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry == want {
+			return nil
+		}
+		if strings.EqualFold(piholeEntryHost(list, entry), host) {
+			if err := p.change(header, http.MethodDelete, list, entry); err != nil {
+				return err
+			}
+		}
+	}
+	return p.change(header, http.MethodPut, list, want)
+}
+
+func (p *piholeDNS) Delete(host string, record dnsRecord) error {
+	header, logout, err := p.session()
+	if err != nil {
+		return err
+	}
+	defer logout()
+	list, want := p.entries(host, record)
+	entries, err := p.list(header, list)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry == want {
+			return p.change(header, http.MethodDelete, list, entry)
+		}
+	}
+	return nil
+}
+
+// rfc2136DNS sends dynamic updates (RFC 2136) signed with a TSIG key through nsupdate. The key
+// is passed on stdin, so it doesn't show in the process list.
+type rfc2136DNS struct {
+	config DNSProviderConfig
+}
+
+func (r *rfc2136DNS) String() string { return "rfc2136:" + r.config.Server }
+
+// update runs nsupdate with the update commands
+func (r *rfc2136DNS) update(commands ...string) error {
+	var script strings.Builder
+	server, port, err := net.SplitHostPort(r.config.Server)
+	if err != nil {
+		server, port = r.config.Server, "53"
+	}
+	fmt.Fprintf(&script, "server %s %s\n", server, port)
+	fmt.Fprintf(&script, "zone %s.\n", strings.Trim(r.config.Zone, "."))
+	if r.config.KeyName != "" {
+		algorithm := r.config.KeyAlgorithm
+		if algorithm == "" {
+			algorithm = "hmac-sha256"
+		}
+		fmt.Fprintf(&script, "key %s:%s %s\n", algorithm, r.config.KeyName, r.config.KeySecret)
+	}
+	for _, command := range commands {
+		script.WriteString(command + "\n")
+	}
+	script.WriteString("send\n")
+
+	cmd := exec.Command("nsupdate")
+	cmd.Stdin = strings.NewReader(script.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("nsupdate: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// fqdn returns a name with the trailing dot nsupdate needs for absolute names
+func fqdn(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+func (r *rfc2136DNS) Upsert(host string, record dnsRecord) error {
+	value := record.Value
+	if record.Type == "CNAME" {
+		value = fqdn(value)
+	}
+	return r.update(
+		fmt.Sprintf("update delete %s %s", fqdn(host), record.Type),
+		fmt.Sprintf("update add %s %d %s %s", fqdn(host), record.TTL, record.Type, value))
+}
+
+func (r *rfc2136DNS) Delete(host string, record dnsRecord) error {
+	value := record.Value
+	if record.Type == "CNAME" {
+		value = fqdn(value)
+	}
+	return r.update(fmt.Sprintf("update delete %s %s %s", fqdn(host), record.Type, value))
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Results of a doctor check
+const (
+	CheckOK   = "ok"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+// DoctorCheck is the result of one check of `dc doctor`
+type DoctorCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"` // ok, warn or fail
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"` // how to fix a warning or failure
+}
+
+// DoctorReport is the output of `dc doctor`
+type DoctorReport struct {
+	Healthy bool          `json:"healthy"` // no check failed
+	Checks  []DoctorCheck `json:"checks"`
+}
+
+// commandOutput runs a command and returns its trimmed stdout, or an error with its stderr
+func commandOutput(cmd *exec.Cmd) (string, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", errors.New(message)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// checkEngineSocket checks that the API socket of the engine exists and this user may use it
+func checkEngineSocket() DoctorCheck {
+	check := DoctorCheck{Name: "socket"}
+	if host := os.Getenv("DOCKER_HOST"); host != "" && !strings.HasPrefix(host, "unix://") {
+		check.Status, check.Message = CheckOK, "using DOCKER_HOST "+host
+		return check
+	}
+	socket := engineSocketPath()
+	if socket == "" {
+		check.Status, check.Message = CheckFail, "no "+containerEngine()+" socket found"
+		check.Hint = "start the " + containerEngine() + " daemon, or set DOCKER_HOST or DOCKER_SOCK to its socket"
+		if containerEngine() == EnginePodman {
+			check.Hint = "systemctl --user enable --now podman.socket"
+		}
+		return check
+	}
+	conn, err := net.DialTimeout("unix", socket, 2*time.Second)
+	if err != nil {
+		check.Status, check.Message = CheckFail, fmt.Sprintf("cannot connect to %s: %v", socket, err)
+		if errors.Is(err, os.ErrPermission) {
+			check.Hint = "add the user to the docker group (sudo usermod -aG docker $USER, then log in again) or use rootless docker"
+		} else {
+			check.Hint = "start the " + containerEngine() + " daemon"
+		}
+		return check
+	}
+	conn.Close()
+	check.Status, check.Message = CheckOK, socket
+	return check
+}
+
+// checkEngine checks that the engine binary is installed and reports its version
+func checkEngine() DoctorCheck {
+	check := DoctorCheck{Name: "engine"}
+	path, err := exec.LookPath(containerEngine())
+	if err != nil {
+		check.Status, check.Message = CheckFail, containerEngine()+" is not installed"
+		check.Hint = "install docker or podman, or set ENGINE"
+		return check
+	}
+	version, err := commandOutput(engineCommand("--version"))
+	if err != nil || version == "" {
+		version = containerEngine() + ", unknown version"
+	}
+	check.Status, check.Message = CheckOK, fmt.Sprintf("%s (%s)", version, path)
+	return check
+}
+
+// checkEngineDaemon checks that the daemon of the engine answers
+func checkEngineDaemon() DoctorCheck {
+	check := DoctorCheck{Name: "daemon"}
+	if _, err := exec.LookPath(containerEngine()); err != nil {
+		check.Status, check.Message = CheckFail, "not checked, "+containerEngine()+" is not installed"
+		return check
+	}
+	version, err := commandOutput(engineCommand("version", "--format", "{{.Server.Version}}"))
+	if err != nil {
+		check.Status, check.Message = CheckFail, fmt.Sprintf("%s daemon unreachable: %v", containerEngine(), err)
+		check.Hint = "check that the daemon runs (systemctl status " + containerEngine() + ") and the socket check"
+		return check
+	}
+	check.Status, check.Message = CheckOK, containerEngine()+" "+version
+	return check
+}
+
+// checkCompose checks that compose is installed and at least version 2
+func checkCompose() DoctorCheck {
+	check := DoctorCheck{Name: "compose"}
+	version, err := commandOutput(composeCommand("version", "--short"))
+	if err != nil {
+		check.Status, check.Message = CheckFail, fmt.Sprintf("compose unavailable: %v", err)
+		check.Hint = "install the docker compose plugin (docker-compose-plugin), or set COMPOSE_COMMAND"
+		return check
+	}
+	version = strings.TrimPrefix(version, "v")
+	major, _, _ := strings.Cut(version, ".")
+	if n, err := strconv.Atoi(major); err == nil && n < 2 {
+		check.Status, check.Message = CheckWarn, "compose "+version+" is older than 2"
+		check.Hint = "install compose v2; watch, include and other features need it"
+		return check
+	}
+	check.Status, check.Message = CheckOK, "compose "+version
+	return check
+}
+
+// checkStacksDir checks that the stacks directory exists and is writable
+func checkStacksDir() DoctorCheck {
+	check := DoctorCheck{Name: "stacks_dir"}
+	info, err := os.Stat(StacksDir)
+	if err != nil || !info.IsDir() {
+		check.Status, check.Message = CheckFail, StacksDir+" is not a directory"
+		check.Hint = "create it (mkdir -p " + StacksDir + ") or set STACKS_DIR"
+		return check
+	}
+	probe, err := os.CreateTemp(StacksDir, ".dc-doctor-*")
+	if err != nil {
+		check.Status, check.Message = CheckFail, fmt.Sprintf("%s is not writable: %v", StacksDir, err)
+		check.Hint = "chown -R $USER " + StacksDir
+		return check
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	check.Status, check.Message = CheckOK, StacksDir
+	return check
+}
+
+// checkStackFiles checks the files of the stack dirs: broken symlinks, .yaml files dc ignores,
+// names compose refuses, files that aren't YAML, stacks shadowed by the same name in an earlier
+// dir and effective files left behind by removed stacks
+func checkStackFiles() []DoctorCheck {
+	var checks []DoctorCheck
+	warn := func(name, message, hint string) {
+		checks = append(checks, DoctorCheck{Name: name, Status: CheckWarn, Message: message, Hint: hint})
+	}
+	seen := make(map[string]string)
+	count := 0
+	dirs := getAllStackDirs()
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			warn("stack_files", fmt.Sprintf("cannot read %s: %v", dir, err), "chown -R $USER "+dir)
+			continue
+		}
+		for _, entry := range entries {
+			file := filepath.Join(dir, entry.Name())
+			if entry.Type()&os.ModeSymlink != 0 {
+				if _, err := os.Stat(file); err != nil {
+					target, _ := os.Readlink(file)
+					warn("symlink", fmt.Sprintf("%s points to %s, which does not exist", file, target),
+						fmt.Sprintf("point it at the moved file (ln -sfn <file> %s), or replace it with a file reconstructed from the containers (dc stack reconstruct %s --write=true)", file, strings.TrimSuffix(entry.Name(), ".yml")))
+					continue
+				}
+			}
+			if entry.IsDir() {
+				continue
+			}
+			switch name := entry.Name(); {
+			case strings.HasSuffix(name, stackMetaSuffix):
+			case strings.HasSuffix(name, ".effective.yml"):
+				source := strings.TrimSuffix(file, ".effective.yml") + ".yml"
+				if _, err := os.Stat(source); os.IsNotExist(err) {
+					warn("stack_files", file+" belongs to no stack file", "rm "+file)
+				}
+			case strings.HasSuffix(name, ".yaml"):
+				warn("stack_files", file+" is ignored, dc only reads .yml stack files", fmt.Sprintf("mv %s %s", file, strings.TrimSuffix(file, ".yaml")+".yml"))
+			case strings.HasSuffix(name, ".yml"):
+				stackName := strings.TrimSuffix(name, ".yml")
+				if first, ok := seen[stackName]; ok {
+					warn("stack_files", fmt.Sprintf("%s is shadowed by %s", file, first), "remove or rename one of them")
+					continue
+				}
+				seen[stackName] = file
+				count++
+				if err := validateStackName(stackName); err != nil {
+					warn("stack_files", fmt.Sprintf("%s: %v", file, err), "rename the file")
+				}
+				content, err := os.ReadFile(file)
+				var document map[string]interface{}
+				if err == nil {
+					err = yaml.Unmarshal(content, &document)
+				}
+				if err != nil {
+					warn("stack_files", fmt.Sprintf("%s is not valid YAML: %v", file, err), "dc lint "+file)
+				}
+			}
+		}
+	}
+	if len(checks) == 0 {
+		checks = append(checks, DoctorCheck{Name: "stack_files", Status: CheckOK, Message: fmt.Sprintf("%d stack files in %s", count, strings.Join(dirs, ", "))})
+	}
+	return checks
+}
+
+// checkProdEnv checks that prod.env, which holds the secrets, is readable only by its owner
+func checkProdEnv() DoctorCheck {
+	check := DoctorCheck{Name: "prod_env"}
+	info, err := os.Stat(ProdEnvPath)
+	if os.IsNotExist(err) {
+		check.Status, check.Message = CheckOK, ProdEnvPath+" does not exist yet; it is created with the first secret"
+		return check
+	} else if err != nil {
+		check.Status, check.Message = CheckFail, err.Error()
+		return check
+	}
+	file, err := os.Open(ProdEnvPath)
+	if err != nil {
+		check.Status, check.Message = CheckFail, fmt.Sprintf("%s is not readable: %v", ProdEnvPath, err)
+		check.Hint = "chown $USER " + ProdEnvPath
+		return check
+	}
+	file.Close()
+	if mode := info.Mode().Perm(); mode&0077 != 0 {
+		check.Status, check.Message = CheckWarn, fmt.Sprintf("%s has mode %04o, other users can read the secrets", ProdEnvPath, mode)
+		check.Hint = "chmod 600 " + ProdEnvPath
+		return check
+	}
+	check.Status, check.Message = CheckOK, ProdEnvPath
+	return check
+}
+
+// runDoctor runs all checks
+func runDoctor() DoctorReport {
+	report := DoctorReport{Healthy: true}
+	report.Checks = []DoctorCheck{checkEngine(), checkEngineDaemon(), checkEngineSocket(), checkCompose(), checkStacksDir()}
+	report.Checks = append(report.Checks, checkStackFiles()...)
+	report.Checks = append(report.Checks, checkProdEnv())
+	for _, check := range report.Checks {
+		if check.Status == CheckFail {
+			report.Healthy = false
+		}
+	}
+	return report
+}
+
+// HandleDoctor checks that dc can work on this host: the engine, its daemon and socket, compose,
+// the stacks directory and its files, and prod.env. It prints the checks with hints on how to fix failures, as
+// JSON with --output-format=json, and fails if a check failed.
+func HandleDoctor() error {
+	report := runDoctor()
+	if OutputFormat == OutputFormatJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			return err
+		}
+	} else {
+		for _, check := range report.Checks {
+			fmt.Printf("%-4s  %-11s  %s\n", check.Status, check.Name, check.Message)
+			if check.Hint != "" {
+				fmt.Printf("      %-11s  hint: %s\n", "", check.Hint)
+			}
+		}
+	}
+	if !report.Healthy {
+		return fmt.Errorf("some checks failed")
+	}
+	return nil
+}
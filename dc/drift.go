@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+	"dc/internal/docker"
+	"gopkg.in/yaml.v3"
+)
+
+// Drift modes of a stack (x-composectl.drift, default drift_mode)
+const (
+	DriftModeReport  = "report"  // drift is reported only
+	DriftModeCorrect = "correct" // drift is corrected by bringing the stack up again with --correct
+	DriftModeIgnore  = "ignore"  // the stack isn't checked
+)
+
+// Kinds of drift between a stack's effective file and its containers
+const (
+	DriftMissing  = "missing"  // a service has no container
+	DriftStopped  = "stopped"  // a container of the service isn't running
+	DriftImage    = "image"    // a container runs another image than the service declares
+	DriftOrphaned = "orphaned" // a container belongs to a service the stack no longer declares
+)
+
+// DriftDifference is one way in which running containers differ from the effective file
+type DriftDifference struct {
+	Service   string `json:"service"`
+	Container string `json:"container,omitempty"`
+	Kind      string `json:"kind"`
+	Expected  string `json:"expected,omitempty"`
+	Actual    string `json:"actual,omitempty"`
+}
+
+// DriftReport is the drift of one stack, as printed by `dc drift`
+type DriftReport struct {
+	Stack       string            `json:"stack"`
+	Mode        string            `json:"mode"`
+	Drifted     bool              `json:"drifted"`
+	Differences []DriftDifference `json:"differences"`
+	Stopped     bool              `json:"stopped,omitempty"` // stopped on purpose, not checked
+	Corrected   bool              `json:"corrected,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// normalizedImage adds the implicit latest tag, so that nginx and nginx:latest compare equal
+func normalizedImage(image string) string {
+	if !strings.Contains(image, "@") && imageName(image) == image {
+		return image + ":latest"
+	}
+	return image
+}
+
+// stackDrift compares the containers of a stack with its effective file. Stacks without
+// containers are down rather than drifted and report no differences, like stacks stopped with
+// dc stack stop, which must not be started again by drift correction.
+func stackDrift(stackName string, composeFile *compose.File) (*DriftReport, error) {
+	report := &DriftReport{Stack: stackName, Mode: getConfig("drift_mode", DriftModeReport), Differences: []DriftDifference{}}
+	if composeFile.Composectl != nil && composeFile.Composectl.Drift != "" {
+		report.Mode = composeFile.Composectl.Drift
+	}
+	if report.Mode == DriftModeIgnore {
+		return report, nil
+	}
+	if readStackMeta(stackName).Stopped {
+		report.Stopped = true
+		return report, nil
+	}
+
+	out, err := engineCommand("ps", "-a", "-q", "--no-trunc",
+		"--filter", "label=com.docker.compose.project="+stackName).Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker ps failed: %w", err)
+	}
+	containers, err := inspectContainers(strings.Fields(string(out)))
+	if err != nil || len(containers) == 0 {
+		return report, err
+	}
+
+	byService := make(map[string][]docker.Inspect)
+	for _, c := range containers {
+		service := c.Config.Labels["com.docker.compose.service"]
+		byService[service] = append(byService[service], c)
+	}
+	for serviceName, service := range composeFile.Services {
+		serviceContainers := byService[serviceName]
+		if len(serviceContainers) == 0 {
+			report.Differences = append(report.Differences, DriftDifference{Service: serviceName, Kind: DriftMissing})
+		}
+		for _, c := range serviceContainers {
+			name := strings.TrimPrefix(c.Name, "/")
+			// one-shot services without restart policy are done, not drifted, once they exit cleanly
+			oneShot := (service.Restart == "" || service.Restart == "no") && c.State.ExitCode == 0
+			if !c.State.Running && !oneShot {
+				report.Differences = append(report.Differences, DriftDifference{
+					Service: serviceName, Container: name, Kind: DriftStopped, Expected: "running", Actual: c.State.Status})
+			}
+			// images with unresolved variables can't be compared
+			if service.Image != "" && !strings.Contains(service.Image, "$") && normalizedImage(service.Image) != normalizedImage(c.Config.Image) {
+				report.Differences = append(report.Differences, DriftDifference{
+					Service: serviceName, Container: name, Kind: DriftImage, Expected: service.Image, Actual: c.Config.Image})
+			}
+		}
+	}
+	for serviceName, serviceContainers := range byService {
+		if _, declared := composeFile.Services[serviceName]; declared {
+			continue
+		}
+		for _, c := range serviceContainers {
+			report.Differences = append(report.Differences, DriftDifference{
+				Service: serviceName, Container: strings.TrimPrefix(c.Name, "/"), Kind: DriftOrphaned})
+		}
+	}
+	sort.Slice(report.Differences, func(i, j int) bool {
+		a, b := report.Differences[i], report.Differences[j]
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		if a.Container != b.Container {
+			return a.Container < b.Container
+		}
+		return a.Kind < b.Kind
+	})
+	report.Drifted = len(report.Differences) > 0
+	return report, nil
+}
+
+// deployedStacks returns the stacks with an effective file, i.e. those dc brought up
+func deployedStacks() []string {
+	var stacks []string
+	for stackName := range stackFiles() {
+		if _, err := os.Stat(GetStackPath(stackName, true)); err == nil {
+			stacks = append(stacks, stackName)
+		}
+	}
+	sort.Strings(stacks)
+	return stacks
+}
+
+// HandleDrift compares the containers of the given stacks (default: all stacks with an effective
+// file) against their effective files and prints a DriftReport per stack as JSON. With
+// --correct=true drifted stacks in correct mode are brought up again.
+func HandleDrift(args []string) error {
+	stacks := positionalArgs(args)[1:]
+	if len(stacks) == 0 {
+		stacks = deployedStacks()
+	}
+	correct := getFlagBool("correct", false)
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate dc: %w", err)
+	}
+
+	reports := []DriftReport{}
+	for _, stackName := range stacks {
+		content, err := os.ReadFile(GetStackPath(stackName, true))
+		if err != nil {
+			return fmt.Errorf("stack %s has no effective file %s: %w", stackName, filepath.Base(GetStackPath(stackName, true)), err)
+		}
+		var composeFile compose.File
+		if err := yaml.Unmarshal(content, &composeFile); err != nil {
+			return fmt.Errorf("failed to parse effective file of stack %s: %w", stackName, err)
+		}
+		report, err := stackDrift(stackName, &composeFile)
+		if err != nil {
+			report = &DriftReport{Stack: stackName, Differences: []DriftDifference{}, Error: err.Error()}
+		}
+
+		if report.Drifted && correct && report.Mode == DriftModeCorrect {
+			if DryRun {
+				fmt.Fprintf(os.Stderr, "%s\n# Would bring up stack %s to correct its drift\n", msg("dry_run_header"), stackName)
+			} else {
+				writeFrame(FrameStdout, fmt.Sprintf("Correcting drift of stack %s", stackName))
+				result := runBulkStack(self, stackName, bulkActions["up"], bulkPassthrough(args))
+				report.Corrected = result.ExitCode == 0
+				report.Error = result.Error
+			}
+		}
+		reports = append(reports, *report)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(reports); err != nil {
+		return err
+	}
+	pingHeartbeat("drift")
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Container engines (config key engine, else the first of docker and podman on the PATH). Podman
+// runs stacks with `podman compose`, or the command of compose_command such as podman-compose.
+const (
+	EngineDocker = "docker"
+	EnginePodman = "podman"
+)
+
+var (
+	engineOnce sync.Once
+	engineName string
+)
+
+// containerEngine returns the binary of the container engine
+func containerEngine() string {
+	engineOnce.Do(func() {
+		engineName = strings.ToLower(getConfig("engine", ""))
+		switch engineName {
+		case EngineDocker, EnginePodman:
+			return
+		case "":
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: unknown engine %q, using docker\n", engineName)
+			engineName = EngineDocker
+			return
+		}
+		engineName = EngineDocker
+		if _, err := exec.LookPath(EngineDocker); err != nil {
+			if _, err := exec.LookPath(EnginePodman); err == nil {
+				engineName = EnginePodman
+			}
+		}
+	})
+	return engineName
+}
+
+// engineCommand returns a command of the container engine, e.g. engineCommand("ps", "-a")
+func engineCommand(args ...string) *exec.Cmd {
+	return exec.Command(containerEngine(), args...)
+}
+
+// composeCommand returns a command of the compose implementation: compose_command if set, else
+// the compose subcommand of the engine
+func composeCommand(args ...string) *exec.Cmd {
+	if command := strings.Fields(getConfig("compose_command", "")); len(command) > 0 {
+		return exec.Command(command[0], append(command[1:], args...)...)
+	}
+	return exec.Command(containerEngine(), append([]string{"compose"}, args...)...)
+}
+
+// engineSocketPath returns the API socket of the container engine: DOCKER_SOCK, else the socket
+// of DOCKER_HOST, else the first existing rootless then rootful socket of the engine. It is
+// empty if none exists.
+func engineSocketPath() string {
+	if v := os.Getenv("DOCKER_SOCK"); v != "" {
+		return v
+	}
+	if host, ok := strings.CutPrefix(os.Getenv("DOCKER_HOST"), "unix://"); ok {
+		return host
+	}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	candidates := []string{filepath.Join(runtimeDir, "docker.sock"), "/var/run/docker.sock"}
+	if containerEngine() == EnginePodman {
+		candidates = []string{filepath.Join(runtimeDir, "podman", "podman.sock"), "/run/podman/podman.sock"}
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stackEventActions are the container lifecycle events republished per stack
+var stackEventActions = []string{"start", "die", "health_status", "oom"}
+
+// StackEvent is a container lifecycle event of a stack
+type StackEvent struct {
+	Stack     string    `json:"stack"`
+	Service   string    `json:"service"`
+	Container string    `json:"container"`
+	Action    string    `json:"action"`           // start, die, health_status or oom
+	Health    string    `json:"health,omitempty"` // for health_status: healthy, unhealthy or starting
+	ExitCode  *int      `json:"exitCode,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// dockerEvent is the part of `docker events --format '{{json .}}'` used here
+type dockerEvent struct {
+	Action string `json:"Action"`
+	Actor  struct {
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+// parseDockerEvent converts a docker event of a compose container into a StackEvent
+func parseDockerEvent(line string) (*StackEvent, bool) {
+	var raw dockerEvent
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, false
+	}
+	attrs := raw.Actor.Attributes
+	stack := attrs["com.docker.compose.project"]
+	if stack == "" {
+		return nil, false
+	}
+	event := &StackEvent{
+		Stack:     stack,
+		Service:   attrs["com.docker.compose.service"],
+		Container: attrs["name"],
+		Action:    raw.Action,
+		Time:      time.Unix(0, raw.TimeNano).UTC(),
+	}
+	// health events arrive as "health_status: healthy"
+	if action, health, ok := strings.Cut(raw.Action, ":"); ok {
+		event.Action, event.Health = action, strings.TrimSpace(health)
+	}
+	if code, err := strconv.Atoi(attrs["exitCode"]); err == nil && event.Action == "die" {
+		event.ExitCode = &code
+	}
+	return event, true
+}
+
+// String formats the event for the terminal
+func (e StackEvent) String() string {
+	detail := ""
+	switch {
+	case e.Health != "":
+		detail = " (" + e.Health + ")"
+	case e.ExitCode != nil:
+		detail = fmt.Sprintf(" (exit code %d)", *e.ExitCode)
+	}
+	return fmt.Sprintf("%s %s/%s %s%s", e.Time.Local().Format(time.RFC3339), e.Stack, e.Service, e.Action, detail)
+}
+
+// HandleEvents prints container lifecycle events of all stacks, or of --stack=<name>. Without
+// follow it prints the events of the last --since (default 1h) and exits. With
+// --output-format=json each event is printed as a JSON line.
+func HandleEvents(follow bool) error {
+	args := []string{"events", "--format", "{{json .}}", "--filter", "type=container"}
+	for _, action := range stackEventActions {
+		args = append(args, "--filter", "event="+action)
+	}
+	if stack := getFlag("stack", ""); stack != "" {
+		args = append(args, "--filter", "label=com.docker.compose.project="+stack)
+	} else {
+		args = append(args, "--filter", "label=com.docker.compose.project")
+	}
+	if !follow {
+		args = append(args, "--since", getFlag("since", "1h"), "--until", strconv.FormatInt(time.Now().Unix(), 10))
+	}
+
+	cmd := engineCommand(args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("docker events failed: %w", err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		event, ok := parseDockerEvent(scanner.Text())
+		if !ok {
+			continue
+		}
+		if OutputFormat == OutputFormatJSON {
+			if err := encoder.Encode(event); err != nil {
+				return err
+			}
+		} else {
+			fmt.Println(event)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("docker events failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// defaultExecCommand is run by `dc stack exec` and `dc container exec` when no command is given
+var defaultExecCommand = []string{"sh"}
+
+// execCommandArgs splits the command to run off the arguments: everything after "--", or else
+// the positional arguments after the first skip ones
+func execCommandArgs(args []string, skip int) []string {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[i+1:]
+		}
+	}
+	if pos := positionalArgs(args); len(pos) > skip {
+		return pos[skip:]
+	}
+	return nil
+}
+
+// stdinIsTerminal reports whether dc runs attached to a terminal, in which case docker exec
+// allocates a TTY in the container too
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// HandleExec runs a command (default: sh) in a container that belongs to a stack, with dc's
+// stdin, stdout and stderr attached. It returns the command's *exec.ExitError on failure.
+func HandleExec(container string, command []string) error {
+	out, err := engineCommand("inspect", "--format", `{{index .Config.Labels "com.docker.compose.project"}}`, container).Output()
+	if err != nil {
+		return fmt.Errorf("container %s not found", container)
+	}
+	if strings.TrimSpace(string(out)) == "" {
+		return fmt.Errorf("container %s does not belong to a stack", container)
+	}
+	if len(command) == 0 {
+		command = defaultExecCommand
+	}
+
+	args := []string{"exec", "-i"}
+	if stdinIsTerminal() {
+		args = append(args, "-t")
+	}
+	cmd := engineCommand(append(append(args, container), command...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// HandleStackExec runs a command in the (first) running container of a stack's service
+func HandleStackExec(stackName, service string, command []string) error {
+	containers, err := stackContainers(stackName, service)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("service %s of stack %s has no running container", service, stackName)
+	}
+	return HandleExec(containers[0], command)
+}
+
+// execExitCode returns the exit code of a command run by HandleExec, or 0 if err is not one
+func execExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return 0
+}
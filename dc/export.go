@@ -0,0 +1,484 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// Bundle member names
+const (
+	bundleManifest      = "manifest.json"
+	bundleSecrets       = "secrets.env"
+	bundleSecretsCipher = "secrets.env.enc"
+	bundleVolumes       = "volumes.json"
+	bundleFormatVersion = 1
+	bundleKDFIterations = 200000
+)
+
+// BundleManifest describes the contents of an exported stack bundle
+type BundleManifest struct {
+	Version   int       `json:"version"`
+	Stack     string    `json:"stack"`
+	CreatedAt time.Time `json:"created_at"`
+	Encrypted bool      `json:"encrypted"`
+	Secrets   []string  `json:"secrets"`
+	Volumes   []string  `json:"volumes"`
+}
+
+// BundleVolume is the metadata of a named volume recorded in a bundle
+type BundleVolume struct {
+	Name    string            `json:"name"`
+	Driver  string            `json:"driver"`
+	Options map[string]string `json:"options,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// referencedVariables returns the names of all ${VAR}/$VAR placeholders in the YAML content
+// plus the environment variables backing top-level secrets.
+func referencedVariables(content []byte, composeFile *compose.File) []string {
+	seen := make(map[string]bool)
+	for _, name := range compose.Variables(string(content)) {
+		seen[name] = true
+	}
+	for _, secret := range composeFile.Secrets {
+		if secret.Environment != "" {
+			seen[secret.Environment] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// deriveBundleKey derives an AES-256 key from a passphrase using PBKDF2-HMAC-SHA256
+func deriveBundleKey(passphrase string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, bundleKDFIterations, 32)
+}
+
+// encryptBundleSecrets encrypts data with AES-GCM. Output layout: salt(16) | nonce(12) | ciphertext
+func encryptBundleSecrets(data []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := deriveBundleKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(salt, nonce...)
+	return gcm.Seal(out, nonce, data, nil), nil
+}
+
+// decryptBundleSecrets reverses encryptBundleSecrets
+func decryptBundleSecrets(data []byte, passphrase string) ([]byte, error) {
+	if len(data) < 16+12 {
+		return nil, fmt.Errorf("encrypted secrets are truncated")
+	}
+	key, err := deriveBundleKey(passphrase, data[:16])
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonceEnd := 16 + gcm.NonceSize()
+	plain, err := gcm.Open(nil, data[16:nonceEnd], data[nonceEnd:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secrets (wrong passphrase?)")
+	}
+	return plain, nil
+}
+
+// inspectBundleVolumes collects metadata of the stack's named volumes. Volumes that don't
+// exist on this host are recorded with their declared configuration.
+func inspectBundleVolumes(composeFile *compose.File) []BundleVolume {
+	names := make([]string, 0, len(composeFile.Volumes))
+	for name := range composeFile.Volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var volumes []BundleVolume
+	for _, name := range names {
+		declared := composeFile.Volumes[name]
+		target := name
+		if declared.Name != "" {
+			target = declared.Name
+		}
+		volume := BundleVolume{Name: target, Driver: declared.Driver, Options: declared.DriverOpts}
+
+		out, err := engineCommand("volume", "inspect", target).Output()
+		if err == nil {
+			var inspected []struct {
+				Driver  string            `json:"Driver"`
+				Options map[string]string `json:"Options"`
+				Labels  map[string]string `json:"Labels"`
+			}
+			if json.Unmarshal(out, &inspected) == nil && len(inspected) == 1 {
+				volume.Driver = inspected[0].Driver
+				volume.Options = inspected[0].Options
+				volume.Labels = inspected[0].Labels
+			}
+		} else {
+			exportLog.Debug("Docker volume inspect failed", "target", target, "err", err)
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes
+}
+
+// bundleFile is a member written to a bundle archive
+type bundleFile struct {
+	name string
+	data []byte
+	mode int64
+}
+
+// writeTarFile adds a regular file to the tar archive
+func writeTarFile(tw *tar.Writer, name string, data []byte, mode int64) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    mode,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// bundlePassphrase returns the passphrase of bundles, from EXPORT_PASSPHRASE only: arguments
+// can be read by any local user
+func bundlePassphrase() string {
+	return os.Getenv("EXPORT_PASSPHRASE")
+}
+
+// HandleExportStack writes a tar.gz bundle of a stack to out: the compose files, the prod.env
+// entries it references (encrypted when EXPORT_PASSPHRASE is set) and named-volume metadata.
+func HandleExportStack(stackName string, out io.Writer) error {
+	content, _, err := findYAML(stackName)
+	if err != nil {
+		return err
+	}
+	var composeFile compose.File
+	if err := yaml.Unmarshal(content, &composeFile); err != nil {
+		return fmt.Errorf("failed to parse YAML: %w", err)
+	}
+
+	envVars, err := readProdEnv(ProdEnvPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", ProdEnvPath, err)
+	}
+	var secrets strings.Builder
+	manifest := BundleManifest{Version: bundleFormatVersion, Stack: stackName, CreatedAt: time.Now().UTC()}
+	for _, name := range referencedVariables(content, &composeFile) {
+		if value, ok := envVars[name]; ok {
+			fmt.Fprintf(&secrets, "%s=%s\n", name, value)
+			manifest.Secrets = append(manifest.Secrets, name)
+		}
+	}
+
+	volumes := inspectBundleVolumes(&composeFile)
+	for _, v := range volumes {
+		manifest.Volumes = append(manifest.Volumes, v.Name)
+	}
+	volumesJSON, err := json.MarshalIndent(volumes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	secretsName, secretsData := bundleSecrets, []byte(secrets.String())
+	if passphrase := bundlePassphrase(); passphrase != "" {
+		secretsData, err = encryptBundleSecrets(secretsData, passphrase)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt secrets: %w", err)
+		}
+		secretsName = bundleSecretsCipher
+		manifest.Encrypted = true
+	} else {
+		exportLog.Warn("Exporting secrets unencrypted; set EXPORT_PASSPHRASE to encrypt them", "secrets", len(manifest.Secrets))
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	files := []bundleFile{
+		{bundleManifest, manifestJSON, 0644},
+		{stackName + ".yml", content, 0644},
+		{secretsName, secretsData, 0600},
+		{bundleVolumes, volumesJSON, 0644},
+	}
+	if effective, err := os.ReadFile(GetStackPath(stackName, true)); err == nil {
+		files = append(files, bundleFile{stackName + ".effective.yml", effective, 0644})
+	}
+	for _, f := range files {
+		if err := writeTarFile(tw, f.name, f.data, f.mode); err != nil {
+			return fmt.Errorf("failed to write %s to bundle: %w", f.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readBundle reads all members of a tar.gz bundle into memory
+func readBundle(in io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("not a gzip bundle: %w", err)
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[filepath.Base(header.Name)] = data
+	}
+	return files, nil
+}
+
+// HandleImportBundle restores a bundle produced by HandleExportStack: secrets are stored via the
+// secrets manager, volumes are created and the compose files are written to the stacks directory.
+// Options: --name=<stack> to rename, --force=true to overwrite; EXPORT_PASSPHRASE decrypts
+// encrypted bundles.
+func HandleImportBundle(in io.Reader) error {
+	files, err := readBundle(in)
+	if err != nil {
+		return err
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(files[bundleManifest], &manifest); err != nil {
+		return fmt.Errorf("bundle has no valid %s: %w", bundleManifest, err)
+	}
+	if manifest.Version > bundleFormatVersion {
+		return fmt.Errorf("bundle format version %d is newer than supported version %d", manifest.Version, bundleFormatVersion)
+	}
+
+	content, ok := files[manifest.Stack+".yml"]
+	if !ok {
+		return fmt.Errorf("bundle does not contain %s.yml", manifest.Stack)
+	}
+	stackName := getFlag("name", manifest.Stack)
+	if err := validateStackName(stackName); err != nil {
+		return err
+	}
+	dest := filepath.Join(getFirstWritableStackDir(), stackName+".yml")
+	if _, err := os.Stat(dest); err == nil && !getFlagBool("force", false) {
+		return fmt.Errorf("%s", msg("stack_exists", stackName, dest))
+	}
+
+	secretsData := files[bundleSecrets]
+	if manifest.Encrypted {
+		passphrase := bundlePassphrase()
+		if passphrase == "" {
+			return fmt.Errorf("bundle secrets are encrypted; set EXPORT_PASSPHRASE")
+		}
+		secretsData, err = decryptBundleSecrets(files[bundleSecretsCipher], passphrase)
+		if err != nil {
+			return err
+		}
+	}
+	for _, line := range strings.Split(string(secretsData), "\n") {
+		key, value, found := strings.Cut(line, "=")
+		if !found || key == "" {
+			continue
+		}
+		if err := (secretsManager{}).Insert(key, value); err != nil {
+			exportLog.Warn("Failed to store secret", "key", key, "err", err)
+		}
+	}
+
+	if DryRun {
+		fmt.Fprintf(os.Stdout, "%s\n# Would create %d volume(s) and write %s\n", msg("dry_run_header"), len(manifest.Volumes), dest)
+		return nil
+	}
+
+	var volumes []BundleVolume
+	if err := json.Unmarshal(files[bundleVolumes], &volumes); err == nil {
+		for _, v := range volumes {
+			if engineCommand("volume", "inspect", v.Name).Run() == nil {
+				exportLog.Info("Volume already exists", "volume", v.Name)
+				continue
+			}
+			args := []string{"volume", "create"}
+			if v.Driver != "" {
+				args = append(args, "--driver", v.Driver)
+			}
+			for k, val := range v.Options {
+				args = append(args, "-o", fmt.Sprintf("%s=%s", k, val))
+			}
+			for k, val := range v.Labels {
+				args = append(args, "--label", fmt.Sprintf("%s=%s", k, val))
+			}
+			args = append(args, v.Name)
+			if output, err := engineCommand(args...).CombinedOutput(); err != nil {
+				exportLog.Warn("Failed to create volume", "volume", v.Name, "err", err, "output", strings.TrimSpace(string(output)))
+			} else {
+				exportLog.Info("Created volume", "volume", v.Name)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := writeStackFile(stackName, dest, content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+	if effective, ok := files[manifest.Stack+".effective.yml"]; ok && stackName == manifest.Stack {
+		if err := os.WriteFile(GetStackPath(stackName, true), effective, 0644); err != nil {
+			exportLog.Debug("Failed to write effective file", "stack", stackName, "err", err)
+		}
+	}
+	exportLog.Info("Imported bundle", "from", manifest.Stack, "stack", stackName, "secrets", len(manifest.Secrets), "volumes", len(manifest.Volumes))
+	return nil
+}
+
+// requireStorageTarget returns the configured storage target, failing if there is none
+func requireStorageTarget() (storageTarget, error) {
+	target, err := getStorageTarget()
+	if err == nil && target == nil {
+		err = fmt.Errorf("no backup_target configured")
+	}
+	return target, err
+}
+
+// HandleUploadExport exports a stack bundle to the storage target as
+// exports/<stack>/<time>/<stack>.tar.gz, applies backup_retention to the stack's exports
+// there and returns the export ID
+func HandleUploadExport(stackName string) (string, error) {
+	target, err := requireStorageTarget()
+	if err != nil {
+		return "", err
+	}
+	id := time.Now().Format(snapshotTimeFormat)
+	key := path.Join(storageExportsPrefix, stackName, id, stackName+".tar.gz")
+	if DryRun {
+		fmt.Fprintf(os.Stdout, "%s\n# Would export stack %s to %s\n", msg("dry_run_header"), stackName, key)
+		return id, nil
+	}
+
+	tmp, err := os.CreateTemp("", "dc-export-*.tar.gz")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if err := HandleExportStack(stackName, tmp); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := target.Upload(key, tmp.Name(), uploadProgress(key)); err != nil {
+		return "", fmt.Errorf("failed to upload %s to %s: %w", key, target, err)
+	}
+	exportLog.Info("Exported stack", "stack", stackName, "target", target)
+	pruneRemote(target, path.Join(storageExportsPrefix, stackName), backupRetention())
+	pingHeartbeat("export")
+	return id, nil
+}
+
+// listRemoteExports returns the export IDs of a stack on the storage target, newest first
+func listRemoteExports(stackName string) ([]string, error) {
+	target, err := requireStorageTarget()
+	if err != nil {
+		return nil, err
+	}
+	return remoteEntries(target, path.Join(storageExportsPrefix, stackName))
+}
+
+// downloadExport fetches an exported bundle from the storage target into a temporary file
+// which the caller removes. ref is <stack>/<id>, or <stack> for the newest export.
+func downloadExport(ref string) (string, error) {
+	target, err := requireStorageTarget()
+	if err != nil {
+		return "", err
+	}
+	stackName, id, _ := strings.Cut(ref, "/")
+	if err := validateStackName(stackName); err != nil {
+		return "", err
+	}
+	if id == "" {
+		ids, err := remoteEntries(target, path.Join(storageExportsPrefix, stackName))
+		if err != nil {
+			return "", err
+		}
+		if len(ids) == 0 {
+			return "", fmt.Errorf("stack %s has no exports on %s", stackName, target)
+		}
+		id = ids[0]
+	} else if strings.ContainsAny(id, "/\\") || id == ".." {
+		return "", fmt.Errorf("invalid export %q", id)
+	}
+
+	tmp, err := os.CreateTemp("", "dc-import-*.tar.gz")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	key := path.Join(storageExportsPrefix, stackName, id, stackName+".tar.gz")
+	exportLog.Info("Downloading", "key", key, "target", target)
+	if err := target.Download(key, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to download %s from %s: %w", key, target, err)
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestDeriveBundleKeyKeepsExistingBundlesReadable(t *testing.T) {
+	// the key the PBKDF2 of earlier versions derived, which encrypted existing bundles
+	const want = "905197aa21a104103f90ed24e9c1db3aab3582d34ef038652e913e2f0898b60e"
+	key, err := deriveBundleKey("correct horse", []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(key); got != want {
+		t.Errorf("deriveBundleKey = %s, want %s", got, want)
+	}
+}
+
+func TestBundleSecretsRoundTrip(t *testing.T) {
+	encrypted, err := encryptBundleSecrets([]byte("DB_PASSWORD=hunter2\n"), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := decryptBundleSecrets(encrypted, "correct horse")
+	if err != nil || string(decrypted) != "DB_PASSWORD=hunter2\n" {
+		t.Errorf("decryptBundleSecrets = %q, %v", decrypted, err)
+	}
+	if _, err := decryptBundleSecrets(encrypted, "wrong"); err == nil {
+		t.Error("decryptBundleSecrets accepted a wrong passphrase")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dc/internal/docker"
+)
+
+// listFilter selects and pages the items of a list command: --name (substring), --label
+// (selector as in bulk operations), --state, --group, --limit and --offset
+type listFilter struct {
+	Name   string
+	Label  string
+	State  string
+	Group  string
+	Limit  int // 0 for all
+	Offset int
+}
+
+// listFilterFromFlags reads the filter flags of a list command
+func listFilterFromFlags() (listFilter, error) {
+	f := listFilter{
+		Name:  getFlag("name", ""),
+		Label: getFlag("label", ""),
+		State: getFlag("state", ""),
+		Group: getFlag("group", ""),
+	}
+	for key, target := range map[string]*int{"limit": &f.Limit, "offset": &f.Offset} {
+		value := getFlag(key, "")
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("invalid --%s %q", key, value)
+		}
+		*target = n
+	}
+	if f.Label != "" {
+		if _, err := matchSelector(f.Label, nil); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// matches reports whether an item passes the filter
+func (f listFilter) matches(name string, labels map[string]string, state, group string) bool {
+	if f.Name != "" && !strings.Contains(strings.ToLower(name), strings.ToLower(f.Name)) {
+		return false
+	}
+	if f.State != "" && state != f.State {
+		return false
+	}
+	if f.Group != "" && group != f.Group {
+		return false
+	}
+	if f.Label != "" {
+		if ok, _ := matchSelector(f.Label, labels); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// paginate returns the page of items selected by --offset and --limit
+func paginate[T any](items []T, f listFilter) []T {
+	if f.Offset >= len(items) {
+		return items[:0]
+	}
+	items = items[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(items) {
+		items = items[:f.Limit]
+	}
+	return items
+}
+
+// matchSelector reports whether labels match a selector of comma-separated key or key=value
+// terms; every term must match
+func matchSelector(selector string, labels map[string]string) (bool, error) {
+	matches := true
+	for _, term := range strings.Split(selector, ",") {
+		key, value, hasValue := strings.Cut(strings.TrimSpace(term), "=")
+		if key == "" {
+			return false, fmt.Errorf("invalid label selector %q", selector)
+		}
+		actual, ok := labels[key]
+		if !ok || (hasValue && actual != value) {
+			matches = false
+		}
+	}
+	return matches, nil
+}
+
+// ContainerListEntry is a container in the list of `dc container ls`
+type ContainerListEntry struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Stack   string            `json:"stack,omitempty"`
+	Service string            `json:"service,omitempty"`
+	Image   string            `json:"image"`
+	State   string            `json:"state"`  // created, running, paused, restarting, exited or dead
+	Status  string            `json:"status"` // as shown by docker ps, e.g. "Up 2 hours (healthy)"
+	Ports   string            `json:"ports,omitempty"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// parseLabelList parses docker's comma-separated label list
+func parseLabelList(labels string) map[string]string {
+	parsed := make(map[string]string)
+	for _, label := range strings.Split(labels, ",") {
+		if k, v, ok := strings.Cut(label, "="); ok {
+			parsed[k] = v
+		}
+	}
+	return parsed
+}
+
+// HandleListContainers prints all containers from a single docker ps as JSON, filtered by
+// --name, --label (container labels), --state (docker state), --group and --stack and paged
+// by --limit and --offset
+func HandleListContainers() error {
+	filter, err := listFilterFromFlags()
+	if err != nil {
+		return err
+	}
+	stack := getFlag("stack", "")
+	var groups map[string]string
+	if filter.Group != "" {
+		groups = stackGroups()
+	}
+
+	containers := []ContainerListEntry{}
+	err = dockerJSONLines([]string{"ps", "-a", "--no-trunc", "--format", "{{json .}}"}, func(line []byte) error {
+		var c struct{ ID, Names, Image, State, Status, Ports, Labels string }
+		if err := json.Unmarshal(line, &c); err != nil {
+			return err
+		}
+		labels := parseLabelList(c.Labels)
+		entry := ContainerListEntry{ID: c.ID, Name: c.Names, Image: c.Image, State: c.State, Status: c.Status, Ports: c.Ports, Labels: labels,
+			Stack: labels["com.docker.compose.project"], Service: labels["com.docker.compose.service"]}
+		if (stack == "" || entry.Stack == stack) && filter.matches(entry.Name, labels, entry.State, groups[entry.Stack]) {
+			containers = append(containers, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false) // keep the -> of the ports readable
+	return encoder.Encode(paginate(containers, filter))
+}
+
+// stackState is running when all containers of a stack run, partial when some do and stopped
+// otherwise, as in the landing page summary
+func stackState(containers []docker.Inspect) string {
+	running := 0
+	for _, c := range containers {
+		if c.State.Running {
+			running++
+		}
+	}
+	switch {
+	case running == 0:
+		return "stopped"
+	case running < len(containers):
+		return "partial"
+	default:
+		return "running"
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Streams of an OutputFrame
+const (
+	FrameStdout = "stdout"
+	FrameStderr = "stderr"
+	FrameError  = "error"
+	FrameDone   = "done"
+)
+
+// Output formats for streamed command output (--output-format)
+const (
+	OutputFormatText = "text"
+	OutputFormatJSON = "json"
+)
+
+// OutputFrame is one line of streamed command output. In json output format each frame is
+// written as one JSON object per line; in text format it keeps the [STDOUT]/[STDERR] prefixes.
+type OutputFrame struct {
+	Stream string    `json:"stream"`
+	Line   string    `json:"line"`
+	TS     time.Time `json:"ts"`
+	Stack  string    `json:"stack,omitempty"` // set on the output of bulk operations
+}
+
+// framePrefixes are the text format prefixes by stream
+var framePrefixes = map[string]string{
+	FrameStdout: "[STDOUT] ",
+	FrameStderr: "[STDERR] ",
+	FrameError:  "[ERROR] ",
+	FrameDone:   "[DONE] ",
+}
+
+// Text formats the frame in the plain-text format
+func (f OutputFrame) Text() string {
+	if f.Stack != "" {
+		return framePrefixes[f.Stream] + "[" + f.Stack + "] " + f.Line
+	}
+	return framePrefixes[f.Stream] + f.Line
+}
+
+// frameMu keeps lines of concurrently streamed stdout and stderr from interleaving
+var frameMu sync.Mutex
+
+// writeFrame writes a redacted line of command output to stderr in the configured output format
+func writeFrame(stream, line string) {
+	emitFrame(OutputFrame{Stream: stream, Line: redactText(line), TS: time.Now().UTC()})
+}
+
+// emitFrame writes a frame to stderr in the configured output format
+func emitFrame(frame OutputFrame) {
+	frameMu.Lock()
+	defer frameMu.Unlock()
+	if OutputFormat == OutputFormatJSON {
+		_ = json.NewEncoder(os.Stderr).Encode(frame)
+		return
+	}
+	fmt.Fprintln(os.Stderr, frame.Text())
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"dc/internal/secrets"
+)
+
+// stacksGitIgnore keeps secret values and bulky data out of the stacks dir repository.
+// Secret names are tracked in prod.env.keys instead of prod.env.
+const stacksGitIgnore = `prod.env
+*.env
+backups/
+.revisions/
+.usage/
+.dc-meta.db
+settings/dns.yml
+settings/logging.yml
+`
+
+// prodEnvKeysFile lists the keys (never the values) of prod.env for the git history
+const prodEnvKeysFile = "prod.env.keys"
+
+// stacksGit runs git inside StacksDir
+func stacksGit(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", StacksDir}, args...)...)
+	return cmd.CombinedOutput()
+}
+
+// getActor returns the user a change is attributed to: --actor (passed by dcapi) or the OS user
+func getActor() string {
+	if actor := getConfig("actor", ""); actor != "" {
+		return actor
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "dc"
+}
+
+// stacksDirCommands are the stack subcommands that change StacksDir
+var stacksDirCommands = map[string]bool{
+	"boot": true, "start": true, "up": true, "stop": true, "down": true, "update": true,
+	"save": true, "put": true, "rename": true, "mv": true, "clone": true, "cp": true,
+	"adopt": true, "reconstruct": true, "import": true, "import-bundle": true, "restore": true,
+	"rollback": true, "rm": true, "remove": true, "del": true, "delete": true,
+}
+
+// readOnlySecretCommands are the secret subcommands that don't change prod.env
+var readOnlySecretCommands = map[string]bool{
+	"ls": true, "list": true, "get": true, "select": true, "inventory": true,
+}
+
+// changesStacksDir reports whether a dc command may change StacksDir, so that reads don't
+// run git
+func changesStacksDir(args []string) bool {
+	pos := positionalArgs(args)
+	if len(pos) == 0 {
+		return false
+	}
+	switch pos[0] {
+	case "stack", "stacks":
+		return len(pos) > 1 && stacksDirCommands[pos[1]]
+	case "boot":
+		return len(pos) == 1
+	case "convert":
+		return getFlagBool("save", false)
+	case "pw", "secret", "secrets":
+		return len(pos) > 1 && !readOnlySecretCommands[strings.ToLower(pos[1])]
+	}
+	return false
+}
+
+// initStacksGit turns StacksDir into a git repository on first use and makes sure the
+// repository ignores the secret files, also when it was not created by dc
+func initStacksGit() error {
+	if _, err := os.Stat(filepath.Join(StacksDir, ".git")); os.IsNotExist(err) {
+		if output, err := stacksGit("init", "-q"); err != nil {
+			return fmt.Errorf("git init failed: %v: %s", err, strings.TrimSpace(string(output)))
+		}
+		gitLog.Debug("Initialized git repository", "dir", StacksDir)
+	}
+	return ensureStacksGitIgnore()
+}
+
+// ensureStacksGitIgnore appends the entries of stacksGitIgnore missing from the .gitignore
+// of StacksDir
+func ensureStacksGitIgnore() error {
+	ignorePath := filepath.Join(StacksDir, ".gitignore")
+	content, err := os.ReadFile(ignorePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	present := make(map[string]bool)
+	for _, line := range strings.Split(string(content), "\n") {
+		present[strings.TrimSpace(line)] = true
+	}
+	var missing strings.Builder
+	for _, entry := range strings.Split(strings.TrimSpace(stacksGitIgnore), "\n") {
+		if !present[entry] {
+			missing.WriteString(entry + "\n")
+		}
+	}
+	if missing.Len() == 0 {
+		return nil
+	}
+	if len(content) > 0 && !strings.HasSuffix(string(content), "\n") {
+		content = append(content, '\n')
+	}
+	return os.WriteFile(ignorePath, append(content, missing.String()...), 0644)
+}
+
+// secretSettingsFiles are the settings files holding credentials, relative to StacksDir
+var secretSettingsFiles = []string{settingsDirName + "/dns.yml", settingsDirName + "/logging.yml"}
+
+// isSecretFile reports whether a path in the stacks repository holds secret values
+func isSecretFile(path string) bool {
+	return strings.HasSuffix(path, ".env") || slices.Contains(secretSettingsFiles, path)
+}
+
+// writeProdEnvKeys records the sorted secret names of prod.env, so that adding or removing
+// a secret shows up in the history without its value
+func writeProdEnvKeys() error {
+	if filepath.Dir(ProdEnvPath) != filepath.Clean(StacksDir) {
+		return nil
+	}
+	envVars, err := secrets.ReadEnvFile(ProdEnvPath)
+	if err != nil {
+		return nil
+	}
+	keys := make([]string, 0, len(envVars))
+	for key := range envVars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	content := strings.Join(keys, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	return os.WriteFile(filepath.Join(StacksDir, prodEnvKeysFile), []byte(content), 0644)
+}
+
+// commitStacksDir commits all pending changes of StacksDir, except secret files, when
+// stacks_git is enabled and the command may have changed it. The commit is authored by the
+// acting user and describes the dc command that made it.
+func commitStacksDir(args []string) {
+	if DryRun || !getConfigBool("stacks_git", false) || !changesStacksDir(args) {
+		return
+	}
+	// Inside git hooks the repository is busy receiving a push
+	if len(args) > 0 && args[0] == "git" {
+		return
+	}
+	if err := initStacksGit(); err != nil {
+		gitLog.Warn("Stacks dir git failed", "err", err)
+		return
+	}
+	if err := writeProdEnvKeys(); err != nil {
+		gitLog.Debug("Failed to write", "file", prodEnvKeysFile, "err", err)
+	}
+	// Secret files stay out even if they were tracked before the ignore entries existed
+	pathspec := []string{"add", "-A", "--", ".", ":(exclude)*.env"}
+	for _, file := range secretSettingsFiles {
+		pathspec = append(pathspec, ":(exclude)"+file)
+	}
+	if output, err := stacksGit(pathspec...); err != nil {
+		gitLog.Warn("Git add failed", "err", err, "output", strings.TrimSpace(string(output)))
+		return
+	}
+	staged, err := stacksGit("diff", "--cached", "--name-only")
+	if err != nil {
+		gitLog.Warn("Git diff failed", "err", err, "output", strings.TrimSpace(string(staged)))
+		return
+	}
+	if strings.TrimSpace(string(staged)) == "" {
+		return // nothing changed
+	}
+	for _, path := range strings.Split(strings.TrimSpace(string(staged)), "\n") {
+		if isSecretFile(path) {
+			gitLog.Warn("Not committing the stacks dir, a secret file is staged", "file", path)
+			return
+		}
+	}
+
+	actor := getActor()
+	identity := fmt.Sprintf("%s <%s@composectl>", actor, actor)
+	message := redactText(fmt.Sprintf("%s: dc %s", actor, strings.Join(positionalArgs(args), " ")))
+	output, err := stacksGit("-c", "user.name="+actor, "-c", "user.email="+actor+"@composectl",
+		"commit", "-q", "--author", identity, "-m", message)
+	if err != nil {
+		gitLog.Warn("Git commit failed", "err", err, "output", strings.TrimSpace(string(output)))
+		return
+	}
+	gitLog.Debug("Committed stacks dir change", "message", message)
+}
+
+// HandleStackHistory prints the git history of a stack's files, one commit per line:
+// <hash> <ISO date> <author> <message>
+func HandleStackHistory(stackName string) error {
+	if _, err := os.Stat(filepath.Join(StacksDir, ".git")); err != nil {
+		return fmt.Errorf("stacks dir %s is not a git repository (enable with STACKS_GIT=true)", StacksDir)
+	}
+	output, err := stacksGit("log", "--format=%h %aI %an %s", "--",
+		stackName+".yml", stackName+".effective.yml")
+	if err != nil {
+		return fmt.Errorf("git log failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	os.Stdout.Write(output)
+	return nil
+}
+
+// zeroRev is the object id git passes for created and deleted refs
+const zeroRev = "0000000000000000000000000000000000000000"
+
+// isStackFile reports whether a path in the stacks repository is a compose file to validate
+func isStackFile(path string) bool {
+	return (strings.HasSuffix(path, ".yml") || strings.HasSuffix(path, ".yaml")) &&
+		!strings.HasSuffix(path, ".effective.yml") && !strings.HasPrefix(path, ".revisions/") && !strings.HasPrefix(path, "backups/") &&
+		!strings.HasPrefix(path, settingsDirName+"/")
+}
+
+// pushedStackFiles lists the stack files added or modified between two revisions
+func pushedStackFiles(oldRev, newRev string) ([]string, error) {
+	var out []byte
+	var err error
+	if oldRev == zeroRev {
+		out, err = exec.Command("git", "ls-tree", "-r", "--name-only", newRev).Output()
+	} else {
+		out, err = exec.Command("git", "diff", "--name-only", "--diff-filter=AMR", oldRev, newRev).Output()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changed files: %w", err)
+	}
+	var files []string
+	for _, path := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if path != "" && isStackFile(path) {
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// HandlePreReceive validates pushed stack files (schema, lint, secret scan) as a git
+// pre-receive hook and rejects the push if any file has errors. It reads the
+// "<old> <new> <ref>" lines git passes on stdin.
+func HandlePreReceive() error {
+	var findings []LintFinding
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[1] == zeroRev {
+			continue
+		}
+		files, err := pushedStackFiles(fields[0], fields[1])
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			content, err := exec.Command("git", "cat-file", "blob", fields[1]+":"+file).Output()
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", file, err)
+			}
+			for _, f := range lintCompose(content) {
+				f.File = file
+				findings = append(findings, f)
+			}
+		}
+	}
+	for _, f := range findings {
+		fmt.Fprintln(os.Stderr, f)
+	}
+	if hasLintErrors(findings) {
+		return fmt.Errorf("push rejected: stack files have errors")
+	}
+	return nil
+}
+
+// preReceiveHook is installed into the stacks repository by `dc git install-hook`
+const preReceiveHook = `#!/bin/sh
+# Installed by dc git install-hook: validates pushed stack files before they are accepted
+exec %q git pre-receive --stacks-dir=%q
+`
+
+// HandleInstallHook installs the pre-receive hook and lets pushes update the checked-out
+// branch of StacksDir, so that validated pushes are applied to the stack files directly
+func HandleInstallHook() error {
+	if err := initStacksGit(); err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	hookPath := filepath.Join(StacksDir, ".git", "hooks", "pre-receive")
+	if err := os.MkdirAll(filepath.Dir(hookPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(hookPath, []byte(fmt.Sprintf(preReceiveHook, executable, StacksDir)), 0755); err != nil {
+		return err
+	}
+	if output, err := stacksGit("config", "receive.denyCurrentBranch", "updateInstead"); err != nil {
+		return fmt.Errorf("git config failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	gitLog.Info("Installed pre-receive hook", "path", hookPath)
+	return nil
+}
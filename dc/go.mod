@@ -1,5 +1,10 @@
 module dc
 
-go 1.21
+go 1.24
 
-require gopkg.in/yaml.v3 v3.0.1
+require (
+	go.etcd.io/bbolt v1.4.3
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.29.0 // indirect
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// Kinds of cross-stack dependencies
+const (
+	DependencyNetwork  = "network"  // the consumer joins an external network the provider creates
+	DependencyVolume   = "volume"   // the consumer mounts an external volume the provider creates
+	DependencyProxy    = "proxy"    // the consumer is routed by the provider's traefik
+	DependencyDatabase = "database" // the consumer's environment names a database service of the provider
+	DependencyService  = "service"  // the consumer's environment names another service of the provider
+)
+
+// databaseImages are the images whose services count as databases in the graph
+var databaseImages = map[string]bool{
+	"postgres": true, "postgis/postgis": true, "mysql": true, "mariadb": true, "mongo": true,
+	"redis": true, "valkey/valkey": true, "influxdb": true, "elasticsearch": true, "clickhouse/clickhouse-server": true,
+}
+
+// StackDependency is an edge of the stack graph: From depends on To. To is a stack, or
+// "network:<name>"/"volume:<name>" for external resources no stack provides.
+type StackDependency struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Kind     string `json:"kind"`
+	Resource string `json:"resource"`
+}
+
+// StackGraph is the output of `dc graph`
+type StackGraph struct {
+	Stacks   []string          `json:"stacks"`
+	External []string          `json:"external"` // resources used by stacks but provided by none
+	Edges    []StackDependency `json:"edges"`
+}
+
+// graphStack is what the graph needs to know about one stack
+type graphStack struct {
+	name      string
+	provides  map[string]bool // "network:<name>" and "volume:<name>" created by the stack
+	consumes  map[string]bool // external "network:<name>" and "volume:<name>"
+	hosts     map[string]string
+	databases map[string]bool
+	proxy     bool // runs traefik
+	routed    bool // has services with traefik routers
+	env       []string
+}
+
+// serviceNetworks returns the network names a service joins
+func serviceNetworks(networks interface{}) []string {
+	var names []string
+	switch v := networks.(type) {
+	case string:
+		names = append(names, v)
+	case []interface{}:
+		for _, n := range v {
+			if s, ok := n.(string); ok {
+				names = append(names, s)
+			}
+		}
+	case []string:
+		names = append(names, v...)
+	case map[string]interface{}:
+		for n := range v {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// analyzeStack collects the resources, hostnames and references of a stack
+func analyzeStack(stackName string, composeFile *compose.File) *graphStack {
+	s := &graphStack{
+		name: stackName, provides: map[string]bool{}, consumes: map[string]bool{},
+		hosts: map[string]string{}, databases: map[string]bool{},
+	}
+	referencedNetworks := map[string]bool{}
+	referencedVolumes := map[string]bool{}
+	for serviceName, service := range composeFile.Services {
+		s.hosts[serviceName] = serviceName
+		if service.ContainerName != "" {
+			s.hosts[service.ContainerName] = serviceName
+		}
+		image := imageName(service.Image)
+		image = strings.TrimPrefix(image, "library/")
+		if databaseImages[image] || databaseImages[image[strings.LastIndex(image, "/")+1:]] {
+			s.databases[serviceName] = true
+		}
+		if strings.HasSuffix(image, "traefik") {
+			s.proxy = true
+		}
+		for key, value := range compose.LabelsToMap(service.Labels) {
+			if strings.HasPrefix(key, "traefik.http.routers.") || (key == "traefik.enable" && value == "true") {
+				s.routed = true
+			}
+		}
+		for _, value := range compose.LabelsToMap(service.Environment) {
+			s.env = append(s.env, value)
+		}
+		for _, network := range serviceNetworks(service.Networks) {
+			referencedNetworks[network] = true
+		}
+		for _, volume := range service.Volumes {
+			name := strings.Split(volume, ":")[0]
+			if strings.Contains(volume, ":") && !strings.HasPrefix(name, "/") && !strings.HasPrefix(name, ".") && !strings.HasPrefix(name, "~") {
+				referencedVolumes[name] = true
+			}
+		}
+	}
+
+	for key, network := range composeFile.Networks {
+		name := key
+		if network.Name != "" {
+			name = network.Name
+		}
+		if network.External {
+			s.consumes["network:"+name] = true
+		} else {
+			s.provides["network:"+name] = true
+			s.provides["network:"+stackName+"_"+key] = true
+		}
+		delete(referencedNetworks, key)
+	}
+	for key, volume := range composeFile.Volumes {
+		if volume.External {
+			s.consumes["volume:"+stackVolumeName(stackName, key, volume)] = true
+		} else {
+			s.provides["volume:"+stackVolumeName(stackName, key, volume)] = true
+			s.provides["volume:"+key] = true
+		}
+		delete(referencedVolumes, key)
+	}
+	// compose treats undeclared resources as external
+	for network := range referencedNetworks {
+		if network != "default" {
+			s.consumes["network:"+network] = true
+		}
+	}
+	for volume := range referencedVolumes {
+		s.consumes["volume:"+volume] = true
+	}
+	return s
+}
+
+var hostPortPattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9_.-]*):\d+$`)
+
+// referencedHosts extracts hostnames from an environment value: a bare hostname, host:port,
+// or the host of a URL such as postgres://user:pw@db:5432/app. Lists separated by commas are split.
+func referencedHosts(value string) []string {
+	var hosts []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if strings.Contains(part, "://") {
+			if u, err := url.Parse(part); err == nil && u.Hostname() != "" {
+				hosts = append(hosts, u.Hostname())
+			}
+		} else if m := hostPortPattern.FindStringSubmatch(part); m != nil {
+			hosts = append(hosts, m[1])
+		} else if part != "" && !strings.ContainsAny(part, " /=:@") {
+			hosts = append(hosts, part)
+		}
+	}
+	return hosts
+}
+
+// loadGraphStacks reads every stack, preferring its effective file, which reflects what runs
+func loadGraphStacks() []*graphStack {
+	files := make(map[string]string)
+	for _, dir := range getAllStackDirs() {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.yml"))
+		for _, file := range matches {
+			if strings.HasSuffix(file, ".effective.yml") {
+				continue
+			}
+			stackName := strings.TrimSuffix(filepath.Base(file), ".yml")
+			if _, seen := files[stackName]; seen {
+				continue
+			}
+			files[stackName] = file
+			if _, err := os.Stat(GetStackPath(stackName, true)); err == nil {
+				files[stackName] = GetStackPath(stackName, true)
+			}
+		}
+	}
+
+	var stacks []*graphStack
+	for stackName, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var composeFile compose.File
+		if err := yaml.Unmarshal(content, &composeFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping stack %s: %v\n", stackName, err)
+			continue
+		}
+		stacks = append(stacks, analyzeStack(stackName, &composeFile))
+	}
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].name < stacks[j].name })
+	return stacks
+}
+
+// buildStackGraph links the stacks by shared resources, traefik routing and hostnames
+func buildStackGraph(stacks []*graphStack) StackGraph {
+	graph := StackGraph{Stacks: []string{}, External: []string{}, Edges: []StackDependency{}}
+	providers := make(map[string][]string)
+	hostOwners := make(map[string][]*graphStack)
+	var proxies []string
+	for _, s := range stacks {
+		graph.Stacks = append(graph.Stacks, s.name)
+		for resource := range s.provides {
+			providers[resource] = append(providers[resource], s.name)
+		}
+		for host := range s.hosts {
+			hostOwners[host] = append(hostOwners[host], s)
+		}
+		if s.proxy {
+			proxies = append(proxies, s.name)
+		}
+	}
+
+	seen := make(map[StackDependency]bool)
+	add := func(edge StackDependency) {
+		if edge.From != edge.To && !seen[edge] {
+			seen[edge] = true
+			graph.Edges = append(graph.Edges, edge)
+		}
+	}
+	external := make(map[string]bool)
+	for _, s := range stacks {
+		for resource := range s.consumes {
+			kind, name, _ := strings.Cut(resource, ":")
+			owners := providers[resource]
+			if len(owners) == 0 {
+				external[resource] = true
+				add(StackDependency{From: s.name, To: resource, Kind: kind, Resource: name})
+			}
+			for _, owner := range owners {
+				add(StackDependency{From: s.name, To: owner, Kind: kind, Resource: name})
+			}
+		}
+		if s.routed {
+			for _, proxy := range proxies {
+				add(StackDependency{From: s.name, To: proxy, Kind: DependencyProxy, Resource: "traefik"})
+			}
+		}
+		for _, value := range s.env {
+			for _, host := range referencedHosts(value) {
+				// the stack's own services shadow equally named ones elsewhere; ambiguous names are skipped
+				if _, own := s.hosts[host]; own || len(hostOwners[host]) != 1 {
+					continue
+				}
+				owner := hostOwners[host][0]
+				kind := DependencyService
+				if owner.databases[owner.hosts[host]] {
+					kind = DependencyDatabase
+				}
+				add(StackDependency{From: s.name, To: owner.name, Kind: kind, Resource: host})
+			}
+		}
+	}
+	for resource := range external {
+		graph.External = append(graph.External, resource)
+	}
+	sort.Strings(graph.External)
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		a, b := graph.Edges[i], graph.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Resource < b.Resource
+	})
+	return graph
+}
+
+var mermaidUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// mermaidID turns a node name into a mermaid-safe identifier
+func mermaidID(name string) string {
+	return mermaidUnsafe.ReplaceAllString(name, "_")
+}
+
+// writeGraphMermaid renders the graph as a mermaid flowchart
+func writeGraphMermaid(w io.Writer, graph StackGraph) {
+	fmt.Fprintln(w, "graph LR")
+	for _, stack := range graph.Stacks {
+		fmt.Fprintf(w, "  %s[\"%s\"]\n", mermaidID(stack), stack)
+	}
+	for _, resource := range graph.External {
+		fmt.Fprintf(w, "  %s[(\"%s\")]\n", mermaidID(resource), resource)
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(w, "  %s -->|\"%s %s\"| %s\n", mermaidID(edge.From), edge.Kind, edge.Resource, mermaidID(edge.To))
+	}
+}
+
+// writeGraphDOT renders the graph in graphviz DOT
+func writeGraphDOT(w io.Writer, graph StackGraph) {
+	fmt.Fprintln(w, "digraph stacks {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	for _, stack := range graph.Stacks {
+		fmt.Fprintf(w, "  %q [shape=box];\n", stack)
+	}
+	for _, resource := range graph.External {
+		fmt.Fprintf(w, "  %q [shape=cylinder];\n", resource)
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(w, "  %q -> %q [label=%q];\n", edge.From, edge.To, edge.Kind+" "+edge.Resource)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// HandleGraph prints the cross-stack dependency graph as JSON, or with --format=dot|mermaid
+func HandleGraph() error {
+	graph := buildStackGraph(loadGraphStacks())
+	switch format := getFlag("format", "json"); format {
+	case "json":
+		return json.NewEncoder(os.Stdout).Encode(graph)
+	case "dot":
+		writeGraphDOT(os.Stdout, graph)
+	case "mermaid":
+		writeGraphMermaid(os.Stdout, graph)
+	default:
+		return fmt.Errorf("unknown graph format %q (json, dot, mermaid)", format)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+)
+
+// stackMetaSuffix names the sidecar file next to a stack file (e.g. media.meta.yaml) that held
+// stack metadata before it moved into the stack metadata database
+const stackMetaSuffix = ".meta.yaml"
+
+// StackMeta is the metadata of a stack that should stay out of the compose file
+type StackMeta struct {
+	Group     string `yaml:"group,omitempty" json:"group,omitempty"`
+	Autostart bool   `yaml:"autostart,omitempty" json:"autostart,omitempty"`
+	// Stopped is set by dc stack stop and down and cleared by up and start, so that drift takes
+	// the stopped containers for the intended state
+	Stopped bool `yaml:"stopped,omitempty" json:"stopped,omitempty"`
+}
+
+// stackMetaPath returns the sidecar metadata file of a stack file
+func stackMetaPath(stackFile string) string {
+	return strings.TrimSuffix(stackFile, ".yml") + stackMetaSuffix
+}
+
+// stackFiles returns the stack file of every stack, the first stack dir holding it winning
+func stackFiles() map[string]string {
+	files := make(map[string]string)
+	for _, dir := range getAllStackDirs() {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.yml"))
+		for _, file := range matches {
+			if strings.HasSuffix(file, ".effective.yml") {
+				continue
+			}
+			stackName := strings.TrimSuffix(filepath.Base(file), ".yml")
+			if _, seen := files[stackName]; !seen {
+				files[stackName] = file
+			}
+		}
+	}
+	return files
+}
+
+// stackGroup returns the group of a stack: the group in its metadata, else x-dc-group
+func stackGroup(meta StackMeta, composeFile *compose.File) string {
+	if meta.Group != "" {
+		return meta.Group
+	}
+	if composeFile != nil {
+		return composeFile.Group
+	}
+	return ""
+}
+
+// stackGroups returns the group of every stack that has one
+func stackGroups() map[string]string {
+	groups := make(map[string]string)
+	for stackName, labels := range stackLabels() {
+		if group := labels["group"]; group != "" {
+			groups[stackName] = group
+		}
+	}
+	return groups
+}
+
+// bootTiers orders the stacks for booting: one tier per group listed in group_order (e.g.
+// "core,media"), then one tier with the stacks of all other groups and those without group
+func bootTiers(stacks []string, groups map[string]string) [][]string {
+	var order []string
+	for _, group := range strings.Split(getConfig("group_order", ""), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			order = append(order, group)
+		}
+	}
+	rank := make(map[string]int)
+	for i, group := range order {
+		if _, dup := rank[group]; !dup {
+			rank[group] = i
+		}
+	}
+	tiers := make([][]string, len(order)+1)
+	for _, stackName := range stacks {
+		i, ok := rank[groups[stackName]]
+		if !ok {
+			i = len(order)
+		}
+		tiers[i] = append(tiers[i], stackName)
+	}
+	var result [][]string
+	for _, tier := range tiers {
+		if len(tier) > 0 {
+			sort.Strings(tier)
+			result = append(result, tier)
+		}
+	}
+	return result
+}
+
+// HandleBootStacks brings up the stacks group by group in the order of group_order: the stacks
+// of a group run concurrently once the previous group is done. Without names, --label or --group
+// all stacks are booted. With --action=start the containers are started instead of brought up.
+func HandleBootStacks(args []string) error {
+	actionName := getFlag("action", "up")
+	if actionName != "up" && actionName != "start" {
+		return fmt.Errorf("invalid boot action %q (up, start)", actionName)
+	}
+	var stacks []string
+	if isBulkInvocation(args) || len(bulkNames(args)) > 0 {
+		var err error
+		if stacks, err = bulkTargets(args); err != nil {
+			return err
+		}
+	} else {
+		for stackName := range stackFiles() {
+			stacks = append(stacks, stackName)
+		}
+	}
+	if len(stacks) == 0 {
+		return fmt.Errorf("no stacks selected")
+	}
+
+	results, err := runTiers(actionName, bootTiers(stacks, stackGroups()), args)
+	if err != nil {
+		return err
+	}
+	return reportBulkResults(results)
+}
+
+// runTiers runs an action for the stacks of each tier concurrently, one tier after the other
+func runTiers(actionName string, tiers [][]string, args []string) ([]BulkResult, error) {
+	parallel := bulkParallelism(args)
+	flags := bulkPassthrough(args)
+	results := []BulkResult{}
+	for _, tier := range tiers {
+		tierResults, err := runBulk(actionName, tier, parallel, flags)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, tierResults...)
+	}
+	return results, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultHealthchecks are the healthchecks injected for well-known images, keyed by image name
+// without registry and tag. Each test only uses tools shipped in the official image.
+var defaultHealthchecks = map[string]map[string]interface{}{
+	"postgres": {"test": []interface{}{"CMD-SHELL", "pg_isready -U $${POSTGRES_USER:-postgres}"}},
+	"mysql":    {"test": []interface{}{"CMD", "mysqladmin", "ping", "-h", "localhost"}},
+	"mariadb":  {"test": []interface{}{"CMD", "healthcheck.sh", "--connect", "--innodb_initialized"}},
+	"redis":    {"test": []interface{}{"CMD", "redis-cli", "ping"}},
+	"valkey":   {"test": []interface{}{"CMD", "valkey-cli", "ping"}},
+	"mongo":    {"test": []interface{}{"CMD", "mongosh", "--quiet", "--eval", "db.adminCommand('ping')"}},
+	"rabbitmq": {"test": []interface{}{"CMD", "rabbitmq-diagnostics", "-q", "ping"}},
+	"nginx":    {"test": []interface{}{"CMD-SHELL", "curl -fs -o /dev/null http://localhost/ || exit 1"}},
+}
+
+// healthcheckTimings are added to injected healthchecks that don't set them
+var healthcheckTimings = map[string]interface{}{
+	"interval":     "30s",
+	"timeout":      "5s",
+	"retries":      5,
+	"start_period": "30s",
+}
+
+// getHealthchecksPath returns the file with user-defined healthchecks (config key healthchecks_file).
+// It maps image names to compose healthcheck sections and takes precedence over the defaults:
+//
+//	ghcr.io/paperless-ngx/paperless-ngx:
+//	  test: ["CMD", "curl", "-fs", "http://localhost:8000"]
+func getHealthchecksPath() string {
+	return getConfig("healthchecks_file", getSettingsPath("healthchecks.yml"))
+}
+
+// loadHealthchecks returns the default healthchecks merged with the user-defined ones
+func loadHealthchecks() map[string]map[string]interface{} {
+	healthchecks := make(map[string]map[string]interface{}, len(defaultHealthchecks))
+	for image, healthcheck := range defaultHealthchecks {
+		healthchecks[image] = healthcheck
+	}
+	content, err := os.ReadFile(getHealthchecksPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			enrichLog.Debug("Failed to read the user-defined healthchecks", "path", getHealthchecksPath(), "err", err)
+		}
+		return healthchecks
+	}
+	var custom map[string]map[string]interface{}
+	if err := yaml.Unmarshal(content, &custom); err != nil {
+		enrichLog.Debug("Failed to parse the user-defined healthchecks", "path", getHealthchecksPath(), "err", err)
+		return healthchecks
+	}
+	for image, healthcheck := range custom {
+		healthchecks[image] = healthcheck
+	}
+	return healthchecks
+}
+
+// imageName strips the tag and digest of an image reference
+func imageName(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// imageCandidates returns the names an image is looked up by, most specific first: its full name
+// (e.g. ghcr.io/org/app), then without registry and library/ prefix, then its last path segment
+func imageCandidates(image string) []string {
+	name := imageName(image)
+	candidates := []string{name}
+	if first, rest, ok := strings.Cut(name, "/"); ok && strings.ContainsAny(first, ".:") {
+		candidates = append(candidates, rest)
+		name = rest
+	}
+	return append(candidates, strings.TrimPrefix(name, "library/"), name[strings.LastIndex(name, "/")+1:])
+}
+
+// healthcheckForImage looks up the healthcheck for an image by the names of imageCandidates
+func healthcheckForImage(image string, healthchecks map[string]map[string]interface{}) map[string]interface{} {
+	for _, candidate := range imageCandidates(image) {
+		if healthcheck, ok := healthchecks[candidate]; ok {
+			result := make(map[string]interface{}, len(healthcheck)+len(healthcheckTimings))
+			for key, value := range healthcheckTimings {
+				result[key] = value
+			}
+			for key, value := range healthcheck {
+				result[key] = value
+			}
+			return result
+		}
+	}
+	return nil
+}
+
+// addDefaultHealthchecks injects healthchecks for well-known images into services that
+// declare none and returns the names of the services it changed
+func addDefaultHealthchecks(composeFile *compose.File) []string {
+	healthchecks := loadHealthchecks()
+	var changed []string
+	for serviceName, service := range composeFile.Services {
+		if service.Healthcheck != nil || service.Image == "" {
+			continue
+		}
+		if healthcheck := healthcheckForImage(service.Image, healthchecks); healthcheck != nil {
+			service.Healthcheck = healthcheck
+			composeFile.Services[serviceName] = service
+			changed = append(changed, serviceName)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// ensureDefaultHealthchecks applies addDefaultHealthchecks when healthcheck_defaults is enabled
+func ensureDefaultHealthchecks(composeFile *compose.File) {
+	if composeFile == nil || !getConfigBool("healthcheck_defaults", false) {
+		return
+	}
+	for _, serviceName := range addDefaultHealthchecks(composeFile) {
+		enrichLog.Info("Added default healthcheck", "service", serviceName)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Heartbeats tell an external dead-man switch (a healthchecks.io check, an Uptime Kuma push
+// monitor) that a background operation keeps succeeding; the monitor alerts once the pings stop.
+// The ping URL of an operation is heartbeat_<operation>_url, falling back to heartbeat_url.
+// Operations: backup, export, usage, devices (and update_check in dcapi).
+
+// heartbeatMinInterval throttles pings of operations that run in tight loops
+const heartbeatMinInterval = time.Minute
+
+var lastHeartbeat = make(map[string]time.Time)
+
+// heartbeatURL returns the ping URL configured for an operation, or ""
+func heartbeatURL(operation string) string {
+	return getConfig("heartbeat_"+operation+"_url", getConfig("heartbeat_url", ""))
+}
+
+// pingHeartbeat reports a successful run of an operation. Failures are only printed: a missed
+// ping is exactly what the monitor is there to notice.
+func pingHeartbeat(operation string) {
+	target := heartbeatURL(operation)
+	if target == "" || DryRun || time.Since(lastHeartbeat[operation]) < heartbeatMinInterval {
+		return
+	}
+	lastHeartbeat[operation] = time.Now()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(target)
+	if err != nil {
+		// the URL itself is the monitor's secret, keep it out of the message
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		fmt.Fprintf(os.Stderr, "Warning: heartbeat ping for %s failed: %v\n", operation, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		fmt.Fprintf(os.Stderr, "Warning: heartbeat ping for %s failed: %s\n", operation, resp.Status)
+	}
+}
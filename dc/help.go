@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a node of dc's command tree, used for `dc help` and shell completion
+type command struct {
+	Name    string
+	Aliases []string
+	Args    string // usage of the arguments and flags after the command
+	Summary string
+	Stack   bool     // the first argument is a stack name
+	Flags   []string // flags offered by completion, besides globalFlags
+	Sub     []*command
+}
+
+// globalFlags are understood by every command
+var globalFlags = []string{"--dry-run=true", "--output-format=json", "--verbose", "--quiet", "--log-level=", "--log-format=", "--stacks-dir=", "--env-path=", "--lang=", "--actor=", "--profile="}
+
+// bulkArgs is the usage of the actions that run on several stacks
+const bulkArgs = "<name>... | --all | --label=<key>=<value>,... | --group=<group> [--parallel=<n>]"
+
+// serviceArgs is the usage of the actions that can be limited to some services of a stack
+const serviceArgs = "<name> <service>..."
+
+// listFilterArgs is the usage of the filter flags of list commands
+const listFilterArgs = "--name=<substring>] [--label=<key>=<value>,...] [--state=<state>] [--group=<group>] [--limit=<n>] [--offset=<n>"
+
+// listFilterFlags are the filter flags of list commands
+var listFilterFlags = []string{"--name=", "--label=", "--state=", "--group=", "--limit=", "--offset="}
+
+// bulkCompletionFlags are the flags of the actions that run on several stacks
+var bulkCompletionFlags = []string{"--all=true", "--label=", "--group=", "--parallel="}
+
+// resourceCommands are the subcommands of dc network and dc volume
+func resourceCommands(kind string) []*command {
+	return []*command{
+		{Name: "ls", Summary: "List the " + kind + "s as JSON"},
+		{Name: "inspect", Args: "<name>", Summary: "Print a " + kind + " as JSON"},
+		{Name: "create", Args: "<name> [--driver=<driver>] [--driver-opts=k=v,...]", Summary: "Create a " + kind, Flags: []string{"--driver=", "--driver-opts="}},
+		{Name: "rm", Args: "<name>", Summary: "Remove a " + kind},
+		{Name: "prune", Args: "[--all=true]", Summary: "Remove unused " + kind + "s", Flags: []string{"--all=true"}},
+	}
+}
+
+// commandTree is every command of dc. The dispatch in main must be kept in sync with it.
+var commandTree = &command{Name: "dc", Sub: []*command{
+	{Name: "stack", Aliases: []string{"stacks"}, Summary: "Manage stacks", Sub: []*command{
+		{Name: "ls", Aliases: []string{"list"}, Args: "[--detail=summary] [" + listFilterArgs + "]", Summary: "List stacks and their containers as JSON", Flags: append([]string{"--detail=summary"}, listFilterFlags...)},
+		{Name: "view", Args: "<name>", Summary: "Print the stack file", Stack: true},
+		{Name: "meta", Args: "<name> [--group=<group>] [--autostart=true|false]", Summary: "Print or set the group and autostart of a stack", Stack: true, Flags: []string{"--group=", "--autostart=true", "--autostart=false"}},
+		{Name: "dirs", Summary: "Print the stack directories as JSON"},
+		{Name: "settings-dir", Summary: "Print the directory of the settings files (healthchecks.yml, dns.yml, ...) as JSON"},
+		{Name: "boot", Args: "[" + bulkArgs + "] [--action=up|start]", Summary: "Bring up stacks group by group in group_order", Stack: true, Flags: append([]string{"--action="}, bulkCompletionFlags...)},
+		{Name: "start", Args: bulkArgs + " | " + serviceArgs, Summary: "Start the containers of stacks", Stack: true, Flags: bulkCompletionFlags},
+		{Name: "up", Args: bulkArgs + " | " + serviceArgs + " [--pull-before-up=true] [--wait-healthy=true] [--wait-timeout=<duration>] [--skip-preflight=true]", Summary: "Create and start stacks", Stack: true,
+			Flags: append([]string{"--pull-before-up=true", "--wait-healthy=true", "--wait-timeout=", "--skip-preflight=true", "--min-free-disk="}, bulkCompletionFlags...)},
+		{Name: "stop", Args: bulkArgs + " | " + serviceArgs, Summary: "Stop the containers of stacks", Stack: true, Flags: bulkCompletionFlags},
+		{Name: "down", Args: bulkArgs + " | " + serviceArgs, Summary: "Stop and remove the containers of stacks", Stack: true, Flags: bulkCompletionFlags},
+		{Name: "update", Args: bulkArgs, Summary: "Pull new images and bring stacks up again", Stack: true, Flags: bulkCompletionFlags},
+		{Name: "watch", Args: "<name>", Summary: "Run docker compose watch for a stack", Stack: true},
+		{Name: "save", Aliases: []string{"put"}, Args: "<name> < stack.yml", Summary: "Save a stack file read from stdin", Stack: true},
+		{Name: "rename", Aliases: []string{"mv"}, Args: "<name> <new-name> [--recreate=true]", Summary: "Rename a stack", Stack: true, Flags: []string{"--recreate=true"}},
+		{Name: "clone", Aliases: []string{"cp"}, Args: "<name> <new-name> [--recreate=true]", Summary: "Copy a stack", Stack: true, Flags: []string{"--recreate=true"}},
+		{Name: "import", Args: "<path|-> [--name=<name>] [--force=true] [--up=true]", Summary: "Import a compose file as a stack", Flags: []string{"--name=", "--force=true", "--up=true"}},
+		{Name: "adopt", Args: "<name> <container>... [--force=true] | <name> --confirm=true", Summary: "Turn containers started by hand into a stack", Stack: true, Flags: []string{"--force=true", "--confirm=true"}},
+		{Name: "reconstruct", Args: "<name> [--write=true] [--force=true]", Summary: "Rebuild a stack file from its containers, e.g. for a broken symlink", Stack: true, Flags: []string{"--write=true", "--force=true"}},
+		{Name: "export", Args: "<name> [--upload=true] > bundle.tar.gz (EXPORT_PASSPHRASE encrypts the secrets) | --format=k8s [--secret-values=true]", Summary: "Export a stack with its volumes as a bundle, or as Kubernetes manifests", Stack: true, Flags: []string{"--upload=true", "--format=k8s", "--secret-values=true"}},
+		{Name: "exports", Args: "<name>", Summary: "List the uploaded exports of a stack", Stack: true},
+		{Name: "import-bundle", Args: "<bundle.tar.gz|-|<stack>[/<export>] --remote=true> [--name=<name>] [--force=true] (EXPORT_PASSPHRASE for encrypted bundles)", Summary: "Import a stack bundle",
+			Flags: []string{"--remote=true", "--name=", "--force=true"}},
+		{Name: "backup", Args: "<name> [--backup-retention=<n>]", Summary: "Back up the volumes of a stack", Stack: true, Flags: []string{"--backup-retention="}},
+		{Name: "backups", Aliases: []string{"snapshots"}, Args: "<name> [--remote=true]", Summary: "List the backups of a stack", Stack: true, Flags: []string{"--remote=true"}},
+		{Name: "restore", Args: "<name> [--snapshot=<id>] [--remote=true]", Summary: "Restore the volumes of a stack from a backup", Stack: true, Flags: []string{"--snapshot=", "--remote=true"}},
+		{Name: "revisions", Args: "<name>", Summary: "List the saved revisions of a stack file", Stack: true},
+		{Name: "rollback", Args: "<name> <revision>", Summary: "Bring a stack back to a revision", Stack: true},
+		{Name: "history", Args: "<name>", Summary: "Print the git history of a stack", Stack: true},
+		{Name: "chaos", Args: "<name> kill|restart [--container=<name>] [--service=<name>] [--delay=<seconds>]", Summary: "Kill or restart a random container of a stack", Stack: true,
+			Flags: []string{"--container=", "--service=", "--delay="}},
+		{Name: "stats", Args: "<name> [--follow=true] [--interval=2s]", Summary: "Print the resource usage of a stack", Stack: true, Flags: []string{"--follow=true", "--interval="}},
+		{Name: "ps", Args: "<name> [--logs=<n>]", Summary: "Print the containers of a stack with state, health, ports, image, uptime and optionally their last log lines as JSON", Stack: true, Flags: []string{"--logs="}},
+		{Name: "exec", Args: "<name> <service> [-- <command>...]", Summary: "Run a command in a service of a stack", Stack: true},
+		{Name: "usage", Args: "<name> [--range=24h] [--points=<n>]", Summary: "Print the recorded usage history of a stack", Stack: true, Flags: []string{"--range=", "--points="}},
+		{Name: "rm", Aliases: []string{"remove", "del", "delete"}, Args: "<name>", Summary: "Take a stack down and remove its stack file", Stack: true},
+		{Name: "logs", Args: "<name>", Summary: "Follow the logs of a stack", Stack: true},
+	}},
+	{Name: "boot", Args: "[status] [--wait-healthy=false] [--group-order=<group>,...]", Summary: "Bring up the autostart stacks, or print the result of the last boot",
+		Flags: []string{"--wait-healthy=false", "--group-order="}, Sub: []*command{
+			{Name: "status", Summary: "Print the result of the last boot as JSON"},
+		}},
+	{Name: "drift", Args: "[<name>...] [--correct=true]", Summary: "Compare running containers with the effective stack files, skipping stacks stopped with dc stack stop", Stack: true, Flags: []string{"--correct=true"}},
+	{Name: "job", Aliases: []string{"jobs"}, Summary: "Follow the background jobs of dcapi", Sub: []*command{
+		{Name: "ls", Aliases: []string{"list"}, Args: "[--stack=<name>]", Summary: "List the jobs as JSON", Flags: []string{"--stack="}},
+		{Name: "status", Args: "<id>", Summary: "Print a job with its output as JSON"},
+		{Name: "attach", Args: "<id> [--detach=true]", Summary: "Stream the output of a job until it is done", Flags: []string{"--detach=true", "--dcapi-url=", "--dcapi-token="}},
+		{Name: "cancel", Args: "<id>", Summary: "Cancel a running job"},
+	}},
+	{Name: "graph", Args: "[--format=json|dot|mermaid]", Summary: "Print the dependency graph of the stacks", Flags: []string{"--format=json", "--format=dot", "--format=mermaid"}},
+	{Name: "devices", Summary: "Restart services when their devices come back", Sub: []*command{
+		{Name: "watch", Args: "[--device-poll-interval=2s]", Summary: "Watch the devices of x-composectl.devices.watch", Flags: []string{"--device-poll-interval="}},
+	}},
+	{Name: "system", Summary: "Docker disk usage", Sub: []*command{
+		{Name: "df", Summary: "Print the disk usage by category and stack as JSON"},
+		{Name: "prune", Args: "[--images=true] [--containers=true] [--networks=true]", Summary: "Remove unused docker objects", Flags: []string{"--images=true", "--containers=true", "--networks=true"}},
+	}},
+	{Name: "network", Aliases: []string{"networks"}, Summary: "Manage docker networks", Sub: resourceCommands("network")},
+	{Name: "volume", Aliases: []string{"volumes"}, Summary: "Manage docker volumes", Sub: resourceCommands("volume")},
+	{Name: "container", Aliases: []string{"containers"}, Summary: "Containers", Sub: []*command{
+		{Name: "ls", Aliases: []string{"list"}, Args: "[--stack=<name>] [" + listFilterArgs + "]", Summary: "List the containers as JSON", Flags: append([]string{"--stack="}, listFilterFlags...)},
+		{Name: "inspect", Args: "<id>", Summary: "Print the inspect data of a container as JSON, secrets masked"},
+		{Name: "exec", Args: "<id> [-- <command>...]", Summary: "Run a command in a container"},
+	}},
+	{Name: "usage", Summary: "Record the resource usage of stacks", Sub: []*command{
+		{Name: "collect", Args: "[--follow=true] [--usage-interval=1m] [--usage-retention=168h]", Summary: "Record the usage of all running stacks",
+			Flags: []string{"--follow=true", "--usage-interval=", "--usage-retention="}},
+	}},
+	{Name: "logs", Summary: "Ship container logs", Sub: []*command{
+		{Name: "forward", Summary: "Ship the logs of the containers of the stacks to Loki or Elasticsearch (logging_file)", Flags: []string{"--logging-file="}},
+	}},
+	{Name: "events", Args: "[-f] [--stack=<name>] [--since=1h]", Summary: "Print the container events of the stacks", Flags: []string{"--follow=true", "--stack=", "--since="}},
+	{Name: "lint", Args: "<file>... (- for stdin)", Summary: "Lint compose files"},
+	{Name: "policy", Aliases: []string{"policies"}, Summary: "Check compose files against the deploy policies", Sub: []*command{
+		{Name: "check", Args: "<file>... (- for stdin)", Summary: "Check compose files against the built-in and rego policies"},
+		{Name: "rules", Summary: "List the built-in policies and their mode as JSON"},
+	}},
+	{Name: "git", Summary: "Validate pushes to the stacks directory", Sub: []*command{
+		{Name: "install-hook", Summary: "Install the pre-receive hook in the stacks directory"},
+		{Name: "pre-receive", Summary: "Validate the pushed stack files (run by git)"},
+	}},
+	{Name: "transform", Args: "[--steps=<step>,...] < stack.yml", Summary: "Print the enriched compose file of stdin", Flags: []string{"--steps="}},
+	{Name: "convert", Args: "run [--name=<stack>] [--save=true] [--force=true] [--up=true] [--allow-env-file=false] -- docker run <option>... <image>", Summary: "Convert a docker run command into a stack file", Flags: []string{"--name=", "--save=true", "--force=true", "--up=true", "--allow-env-file=false"}},
+	{Name: "summary", Summary: "Print the landing page summary as JSON"},
+	{Name: "capacity", Summary: "Print the declared memory and CPUs of the stacks against the host capacity as JSON"},
+	{Name: "status", Summary: "Print the public status page data as JSON"},
+	{Name: "doctor", Summary: "Check the container engine, its socket, compose, the stacks directory and its files and prod.env, with hints on how to fix problems"},
+	{Name: "secret", Aliases: []string{"pw", "secrets"}, Args: "<args>...", Summary: "Manage secrets with the secrets manager", Sub: []*command{
+		{Name: "rotate", Args: "<name> [--restart=true] [--length=<n>]", Summary: "Generate a new value for a secret and list (or restart) the stacks using it", Flags: []string{"--restart=true", "--length="}},
+		{Name: "inventory", Args: "[<name>]", Summary: "List the secrets as JSON, values masked, with the stacks, services and labels using them"},
+		{Name: "store", Args: "create|update|set|delete <name> [--stack=<stack>] < value", Summary: "Write a secret of prod.env (or of <stack>.env) directly, the value read from stdin and never printed", Flags: []string{"--stack="}},
+		{Name: "prune", Summary: "Delete the secrets no stack uses anymore, except settings of dc and dcapi and those matching secret_keep", Flags: []string{"--dry-run=true"}},
+		{Name: "migrate", Args: "<stack>...|--all", Summary: "Give stacks their own copies of the global secrets they use, named <STACK>_<NAME>", Flags: []string{"--all=true", "--dry-run=true"}},
+	}},
+	{Name: "config", Summary: "Manage the profiles of the config file (~/.config/dc/config.yml)", Sub: []*command{
+		{Name: "get", Args: "<key> [--profile=<name>]", Summary: "Print a key of the profile", Flags: []string{"--profile="}},
+		{Name: "set", Args: "<key> <value> [--profile=<name>]", Summary: "Set a key of the profile, e.g. dcapi_url, dcapi_token, stacks_dir or proxy_domain", Flags: []string{"--profile="}},
+		{Name: "unset", Args: "<key> [--profile=<name>]", Summary: "Remove a key from the profile", Flags: []string{"--profile="}},
+		{Name: "use-profile", Args: "<name>", Summary: "Make a profile the current one"},
+		{Name: "profiles", Aliases: []string{"ls"}, Summary: "List the profiles as JSON"},
+	}},
+	{Name: "help", Args: "[<command>...]", Summary: "Show help for a command"},
+	{Name: "completion", Args: "bash|zsh|fish", Summary: "Print a shell completion script, e.g. source <(dc completion bash)"},
+}}
+
+// find returns the subcommand called name, if any
+func (c *command) find(name string) *command {
+	for _, sub := range c.Sub {
+		if sub.Name == name {
+			return sub
+		}
+		for _, alias := range sub.Aliases {
+			if alias == name {
+				return sub
+			}
+		}
+	}
+	return nil
+}
+
+// lookupCommand walks the tree along the words and returns the deepest command they name
+// together with its path
+func lookupCommand(words []string) (*command, []string) {
+	node, path := commandTree, []string{"dc"}
+	for _, word := range words {
+		next := node.find(word)
+		if next == nil {
+			break
+		}
+		node, path = next, append(path, next.Name)
+	}
+	return node, path
+}
+
+// wantsHelp reports whether --help or -h appears before a "--" separator
+func wantsHelp(args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		if arg == "--help" || arg == "-h" || arg == "--help=true" {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleHelp prints the usage of the command named by words and its subcommands
+func HandleHelp(w io.Writer, words []string) {
+	node, path := lookupCommand(words)
+	usage := strings.Join(path, " ")
+	if len(node.Sub) > 0 {
+		usage += " <command>"
+	}
+	if node.Args != "" {
+		usage += " " + node.Args
+	}
+	fmt.Fprintf(w, "Usage: %s\n", usage)
+	if node.Summary != "" {
+		fmt.Fprintf(w, "\n%s.\n", node.Summary)
+	}
+	if len(node.Aliases) > 0 {
+		fmt.Fprintf(w, "\nAliases: %s\n", strings.Join(node.Aliases, ", "))
+	}
+	if len(node.Sub) > 0 {
+		fmt.Fprintln(w, "\nCommands:")
+		width := 0
+		for _, sub := range node.Sub {
+			width = max(width, len(sub.Name))
+		}
+		for _, sub := range node.Sub {
+			fmt.Fprintf(w, "  %-*s  %s\n", width, sub.Name, sub.Summary)
+		}
+		fmt.Fprintf(w, "\nRun '%s <command>' for details.\n", strings.Join(append([]string{"dc help"}, path[1:]...), " "))
+	}
+	if node == commandTree {
+		fmt.Fprintln(w, "\nEvery setting can be given as --key=value, as KEY in the environment or in prod.env.")
+		fmt.Fprintf(w, "Global flags: %s\n", strings.Join(globalFlags, " "))
+	}
+}
+
+// completionStacks returns the stack names: stack files and running compose projects
+func completionStacks() []string {
+	seen := make(map[string]bool)
+	for name := range stackFiles() {
+		seen[name] = true
+	}
+	out, err := engineCommand("ps", "-a", "--format", `{{.Label "com.docker.compose.project"}}`).Output()
+	if err == nil {
+		for _, name := range strings.Fields(string(out)) {
+			seen[name] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeWords returns the candidates for the last of words, the word being completed;
+// the words before it are those already typed after "dc"
+func completeWords(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	node, args := commandTree, 0
+	for _, word := range words[:len(words)-1] {
+		if strings.HasPrefix(word, "-") {
+			continue
+		}
+		if next := node.find(word); next != nil && args == 0 {
+			node = next
+			continue
+		}
+		args++
+	}
+
+	var candidates []string
+	switch {
+	case strings.HasPrefix(current, "-"):
+		candidates = append(append(candidates, node.Flags...), globalFlags...)
+		candidates = append(candidates, "--help")
+	case node.Name == "help":
+		sub, _ := lookupCommand(positionalArgs(words[1 : len(words)-1]))
+		for _, c := range sub.Sub {
+			candidates = append(candidates, c.Name)
+		}
+	case node.Name == "completion":
+		if args == 0 {
+			candidates = []string{"bash", "zsh", "fish"}
+		}
+	case len(node.Sub) > 0 && args == 0:
+		for _, sub := range node.Sub {
+			candidates = append(candidates, sub.Name)
+		}
+	case node.Stack && (args == 0 || strings.Contains(node.Args, "...")):
+		candidates = completionStacks()
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, current) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
+
+// HandleComplete prints the completion candidates for the words, one per line. It backs the
+// scripts of `dc completion`, which call `dc __complete <words after dc>`.
+func HandleComplete(words []string) {
+	for _, candidate := range completeWords(words) {
+		fmt.Println(candidate)
+	}
+}
+
+// completionScripts are the shell glue around `dc __complete`
+var completionScripts = map[string]string{
+	"bash": `# bash completion for dc; add to ~/.bashrc: source <(dc completion bash)
+_dc() {
+    local IFS=$'\n'
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    COMPREPLY=($(dc __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+    [[ ${#COMPREPLY[@]} -eq 1 && ${COMPREPLY[0]} == *= ]] && compopt -o nospace
+}
+complete -F _dc dc
+`,
+	"zsh": `#compdef dc
+# zsh completion for dc; add to ~/.zshrc: source <(dc completion zsh)
+_dc() {
+    local -a candidates
+    candidates=(${(f)"$(dc __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+    compadd -S '' -- ${candidates:#*[^=]}
+    compadd -- ${candidates:#*=}
+}
+compdef _dc dc
+`,
+	"fish": `# fish completion for dc; save as ~/.config/fish/completions/dc.fish
+function __dc_complete
+    set -l tokens (commandline -opc) (commandline -ct)
+    dc __complete $tokens[2..-1] 2>/dev/null
+end
+complete -c dc -f -a '(__dc_complete)'
+`,
+}
+
+// HandleCompletion prints the completion script for a shell
+func HandleCompletion(shell string) error {
+	script, ok := completionScripts[shell]
+	if !ok {
+		return fmt.Errorf("unknown shell %q (expected bash, zsh or fish)", shell)
+	}
+	_, err := os.Stdout.WriteString(script)
+	return err
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+	"dc/internal/secrets"
+	"gopkg.in/yaml.v3"
+)
+
+// composeFileNames are the file names docker compose looks for in a project directory
+var composeFileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+var invalidStackNameChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// deriveStackName turns a file or directory name into a valid stack name
+func deriveStackName(s string) string {
+	name := strings.ToLower(s)
+	name = invalidStackNameChars.ReplaceAllString(name, "-")
+	return strings.Trim(name, "-_")
+}
+
+// resolveImportSource returns the compose file to import from a file or project directory
+// and the stack name derived from it.
+func resolveImportSource(path string) (string, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	if info.IsDir() {
+		for _, name := range composeFileNames {
+			candidate := filepath.Join(absPath, name)
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate, deriveStackName(filepath.Base(absPath)), nil
+			}
+		}
+		return "", "", fmt.Errorf("no compose file found in %s; tried: %v", path, composeFileNames)
+	}
+
+	base := filepath.Base(absPath)
+	for _, name := range composeFileNames {
+		if base == name {
+			// Generic compose file names take the project directory's name, like docker compose does
+			return absPath, deriveStackName(filepath.Base(filepath.Dir(absPath))), nil
+		}
+	}
+	return absPath, deriveStackName(strings.TrimSuffix(base, filepath.Ext(base))), nil
+}
+
+// importEnvFile stores the variables of a .env file next to the compose file in the secrets store
+// so they resolve during interpolation. Existing keys are kept as they are.
+func importEnvFile(envPath string) error {
+	vars, err := secrets.ReadEnvFile(envPath)
+	if err != nil {
+		return err
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+
+	existing, err := readProdEnv(ProdEnvPath)
+	if err != nil {
+		existing = map[string]string{}
+	}
+
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := strings.Trim(vars[key], `"'`)
+		if current, ok := existing[key]; ok {
+			if current != value {
+				stackLog.Warn("Variable differs from the value already in prod.env; keeping the existing value", "key", key, "path", envPath, "prod_env", ProdEnvPath)
+			}
+			continue
+		}
+		if value == "" {
+			continue
+		}
+		if err := (secretsManager{}).Insert(key, value); err != nil {
+			stackLog.Warn("Failed to import variable", "key", key, "path", envPath, "err", err)
+		}
+	}
+	return nil
+}
+
+// HandleImportStack imports a compose file (path to a file or project directory, or "-" for stdin)
+// into the stacks directory. Plaintext passwords are extracted like on save.
+// Options: --name=<stack>, --force=true to overwrite, --up=true to start it afterwards.
+func HandleImportStack(source string) error {
+	var content []byte
+	var stackName, projectDir, envPath string
+	var err error
+
+	if source == "-" {
+		content, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+	} else {
+		var composePath string
+		composePath, stackName, err = resolveImportSource(source)
+		if err != nil {
+			return err
+		}
+		content, err = os.ReadFile(composePath)
+		if err != nil {
+			return err
+		}
+		projectDir = filepath.Dir(composePath)
+		if _, statErr := os.Stat(filepath.Join(projectDir, ".env")); statErr == nil {
+			envPath = filepath.Join(projectDir, ".env")
+		}
+	}
+
+	if name := getFlag("name", ""); name != "" {
+		stackName = name
+	}
+	if stackName == "" {
+		return fmt.Errorf("a stack name is required (--name=<stack>)")
+	}
+	if err := validateStackName(stackName); err != nil {
+		return err
+	}
+
+	var composeFile compose.File
+	if err := yaml.Unmarshal(content, &composeFile); err != nil {
+		return fmt.Errorf("failed to parse compose file: %w", err)
+	}
+	if len(composeFile.Services) == 0 {
+		return fmt.Errorf("compose file defines no services")
+	}
+	if projectDir != "" {
+		rebaseProjectPaths(&composeFile, projectDir)
+	}
+	return saveImportedStack(&composeFile, stackName, source, envPath)
+}
+
+// rebaseProjectPath returns a path of a compose file relative to its project directory as an
+// absolute path, so that it still points into the project once the stack file moved
+func rebaseProjectPath(path, projectDir string) string {
+	if path == "" || filepath.IsAbs(path) || strings.HasPrefix(path, "~") || strings.HasPrefix(path, "$") {
+		return path
+	}
+	return filepath.Join(projectDir, path)
+}
+
+// rebaseProjectPaths makes the relative bind mounts, build contexts and secret and config files
+// of an imported compose file absolute. docker compose resolves them against the directory of the
+// compose file, which is the stacks dir after the import.
+func rebaseProjectPaths(composeFile *compose.File, projectDir string) {
+	for name, service := range composeFile.Services {
+		for i, volume := range service.Volumes {
+			// only sources starting with a dot are paths, others name a volume
+			if source, rest, _ := strings.Cut(volume, ":"); strings.HasPrefix(source, ".") {
+				service.Volumes[i] = strings.TrimSuffix(rebaseProjectPath(source, projectDir)+":"+rest, ":")
+			}
+		}
+		switch build := service.Build.(type) {
+		case string:
+			// remote contexts (git repositories, tarball URLs) are kept
+			if !strings.Contains(build, "://") && !strings.HasPrefix(build, "git@") {
+				service.Build = rebaseProjectPath(build, projectDir)
+			}
+		case map[string]interface{}:
+			context, _ := build["context"].(string)
+			if context == "" {
+				context = "."
+			}
+			if !strings.Contains(context, "://") && !strings.HasPrefix(context, "git@") {
+				build["context"] = rebaseProjectPath(context, projectDir)
+			}
+		}
+		composeFile.Services[name] = service
+	}
+	for name, secret := range composeFile.Secrets {
+		secret.File = rebaseProjectPath(secret.File, projectDir)
+		composeFile.Secrets[name] = secret
+	}
+	for name, config := range composeFile.Configs {
+		config.File = rebaseProjectPath(config.File, projectDir)
+		composeFile.Configs[name] = config
+	}
+}
+
+// saveImportedStack moves the plaintext passwords of an imported compose file to the secrets
+// store, imports the variables of envPath unless it is empty and writes it as the stack file,
+// unless that exists and --force=true isn't given. With --up=true the stack is deployed. A dry
+// run only prints the diff of the stack file and stores nothing.
+func saveImportedStack(composeFile *compose.File, stackName, source, envPath string) error {
+	dir := getFirstWritableStackDir()
+	dest := filepath.Join(dir, stackName+".yml")
+	if _, err := os.Stat(dest); err == nil && !getFlagBool("force", false) {
+		return fmt.Errorf("%s", msg("stack_exists", stackName, dest))
+	}
+	if DryRun {
+		reportExtractedSecrets(maskComposePasswords(composeFile, stackName))
+		var buf strings.Builder
+		if err := encodeYAMLWithMultiline(&buf, composeFile); err != nil {
+			return err
+		}
+		var current []byte
+		if existing, err := os.ReadFile(dest); err == nil {
+			current = existing
+		}
+		fmt.Fprintln(os.Stdout, msg("dry_run_header"))
+		os.Stdout.WriteString(unifiedDiff(string(current), buf.String(), dest, dest))
+		return nil
+	}
+
+	if envPath != "" {
+		stackLog.Info("Importing variables", "path", envPath)
+		if err := importEnvFile(envPath); err != nil {
+			return fmt.Errorf("failed to import %s: %w", envPath, err)
+		}
+	}
+	reportExtractedSecrets(sanitizeComposePasswords(composeFile, stackName))
+
+	var buf strings.Builder
+	if err := encodeYAMLWithMultiline(&buf, composeFile); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	if err := writeStackFile(stackName, dest, []byte(buf.String())); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", dest, err)
+	}
+	stackLog.Debug("Imported stack", "stack", stackName, "source", source)
+	fmt.Fprintln(os.Stderr, msg("stack_imported", stackName, dest))
+
+	if getFlagBool("up", false) {
+		return HandleDockerComposeFile([]byte(buf.String()), stackName, false, compose.ActionUp)
+	}
+	return nil
+}
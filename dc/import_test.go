@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"dc/internal/compose"
+)
+
+func TestRebaseProjectPaths(t *testing.T) {
+	composeFile := &compose.File{
+		Services: map[string]compose.Service{
+			"app": {
+				Volumes: []string{"./data:/data", "../shared:/shared:ro", ".:/app", "/srv/media:/media", "cache:/cache", "~/conf:/conf"},
+				Build:   ".",
+			},
+			"worker": {Build: map[string]interface{}{"dockerfile": "Dockerfile.worker"}},
+			"remote": {Build: "https://github.com/example/app.git"},
+		},
+		Secrets: map[string]compose.Secret{"token": {File: "secrets/token"}, "db": {Environment: "DB_PASSWORD"}},
+		Configs: map[string]compose.Config{"nginx": {File: "./nginx.conf"}},
+	}
+	rebaseProjectPaths(composeFile, "/home/user/project")
+
+	wantVolumes := []string{"/home/user/project/data:/data", "/home/user/shared:/shared:ro", "/home/user/project:/app", "/srv/media:/media", "cache:/cache", "~/conf:/conf"}
+	if got := composeFile.Services["app"].Volumes; !reflect.DeepEqual(got, wantVolumes) {
+		t.Errorf("volumes = %v, want %v", got, wantVolumes)
+	}
+	if got := composeFile.Services["app"].Build; got != "/home/user/project" {
+		t.Errorf("build = %v, want /home/user/project", got)
+	}
+	if got := composeFile.Services["worker"].Build.(map[string]interface{})["context"]; got != "/home/user/project" {
+		t.Errorf("build context = %v, want /home/user/project", got)
+	}
+	if got := composeFile.Services["remote"].Build; got != "https://github.com/example/app.git" {
+		t.Errorf("remote build = %v, want it unchanged", got)
+	}
+	if got := composeFile.Secrets["token"].File; got != "/home/user/project/secrets/token" {
+		t.Errorf("secret file = %s", got)
+	}
+	if got := composeFile.Secrets["db"].File; got != "" {
+		t.Errorf("secret without file got file %s", got)
+	}
+	if got := composeFile.Configs["nginx"].File; got != "/home/user/project/nginx.conf" {
+		t.Errorf("config file = %s", got)
+	}
+}
@@ -0,0 +1,63 @@
+package compose
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Describe returns a human readable name for the check
+func (c PreflightCheck) Describe() string {
+	target := c.URL
+	if target == "" {
+		target = c.Host
+		if c.Port > 0 {
+			target = fmt.Sprintf("%s:%d", c.Host, c.Port)
+		}
+	}
+	if c.Name != "" {
+		return fmt.Sprintf("%s (%s)", c.Name, target)
+	}
+	return target
+}
+
+// ProbeCommand returns the command a probe container runs for the check
+func (c PreflightCheck) ProbeCommand() ([]string, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 5
+	}
+	t := strconv.Itoa(timeout)
+	switch {
+	case c.URL != "":
+		return []string{"wget", "-q", "--spider", "-T", t, c.URL}, nil
+	case c.Host != "" && c.Port > 0:
+		return []string{"nc", "-z", "-w", t, c.Host, strconv.Itoa(c.Port)}, nil
+	case c.Host != "":
+		return []string{"nslookup", c.Host}, nil
+	default:
+		return nil, fmt.Errorf("preflight check %q needs a host or url", c.Name)
+	}
+}
+
+// Describe returns a human readable name for the requirement, e.g. "NFS /mnt/media"
+func (r HostRequirement) Describe() string {
+	target := r.Unit + r.Mount + r.Path
+	if r.Name != "" {
+		return r.Name + " " + target
+	}
+	return target
+}
+
+// Validate reports a requirement that doesn't set exactly one of unit, mount and path
+func (r HostRequirement) Validate() error {
+	set := 0
+	for _, field := range []string{r.Unit, r.Mount, r.Path} {
+		if field != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("requirement %q must set exactly one of unit, mount or path", r.Name)
+	}
+	return nil
+}
@@ -0,0 +1,401 @@
+package compose
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DetectHTTPPort returns the HTTP port of a service and its scheme: a standard HTTP port in a
+// label about ports, else the container port of the first published port, else a *PORT
+// environment variable
+func DetectHTTPPort(service *Service) (string, string, bool) {
+	standardHTTPPorts := []string{"80", "443", "8000", "8080", "8081", "3000", "3001", "5000", "5001", "8443"}
+
+	labelsMap := LabelsToMap(service.Labels)
+	for key, value := range labelsMap {
+		if strings.Contains(strings.ToLower(key), "port") {
+			valueStr := fmt.Sprintf("%v", value)
+			for _, httpPort := range standardHTTPPorts {
+				if strings.Contains(valueStr, httpPort) {
+					if httpPort == "443" || httpPort == "8443" {
+						return httpPort, "https", true
+					}
+					return httpPort, "http", true
+				}
+			}
+		}
+	}
+	// Check explicit ports first
+	for _, p := range service.Ports {
+		// port formats: host:container, ip:host:container, [ipv6]:host:container, container/proto
+		httpPort := ParsePort(p).ContainerPort
+		if httpPort != "" {
+			if httpPort == "443" || httpPort == "8443" {
+				return httpPort, "https", true
+			}
+			return httpPort, "http", true
+		}
+	}
+
+	// Check environment variables for common port names
+	envArr := NormalizeEnvironment(service.Environment)
+	for _, env := range envArr {
+		if strings.Contains(strings.ToUpper(env), "PORT=") {
+			parts := strings.SplitN(env, "=", 2)
+			if len(parts) == 2 {
+				httpPort := ExtractPort(parts[1])
+				if httpPort > 0 {
+					if httpPort == 443 || httpPort == 8443 {
+						return strconv.FormatInt(int64(httpPort), 10), "https", true
+					}
+					return strconv.FormatInt(int64(httpPort), 10), "http", true
+				}
+			}
+		}
+	}
+
+	return "", "", false
+}
+
+// AddProxyLabels adds the Traefik labels routing host to port of a service through entrypoint,
+// under a router named after the service
+func AddProxyLabels(service *Service, router, host, port, entrypoint string) {
+	flat := LabelsToMap(service.Labels)
+	flat[fmt.Sprintf("traefik.http.routers.%s.rule", router)] = fmt.Sprintf("Host(`%s`)", host)
+	flat[fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port", router)] = port
+	flat[fmt.Sprintf("traefik.http.routers.%s.entrypoints", router)] = entrypoint
+	service.Labels = MapToLabels(flat, service.Labels)
+}
+
+// EnsureNetwork makes sure every service not in a network mode references network.
+// Handles common network representations (nil, []interface{}, []string, map[string]interface{}).
+func EnsureNetwork(f *File, network string) {
+	if f == nil || f.Services == nil {
+		return
+	}
+
+	for name, service := range f.Services {
+		if service.NetworkMode != "" {
+			continue
+		}
+		added := false
+
+		switch v := service.Networks.(type) {
+		case nil:
+			// No networks declared, set to sequence containing the network
+			service.Networks = []interface{}{network}
+			added = true
+
+		case string:
+			// Single network as string
+			if v != network {
+				service.Networks = []interface{}{v, network}
+				added = true
+			}
+
+		case []interface{}:
+			found := false
+			for _, item := range v {
+				switch it := item.(type) {
+				case string:
+					if it == network {
+						found = true
+					}
+				case map[string]interface{}:
+					if _, ok := it[network]; ok {
+						found = true
+					}
+				case map[interface{}]interface{}:
+					if _, ok := it[network]; ok {
+						found = true
+					}
+				}
+				if found {
+					break
+				}
+			}
+			if !found {
+				// Prefer to append a string entry for simplicity; some compose parsers also accept a map entry.
+				v = append(v, network)
+				service.Networks = v
+				added = true
+			}
+
+		case []string:
+			found := false
+			for _, s := range v {
+				if s == network {
+					found = true
+					break
+				}
+			}
+			if !found {
+				v = append(v, network)
+				// convert to []interface{} to remain compatible with other code paths
+				iface := make([]interface{}, len(v))
+				for i := range v {
+					iface[i] = v[i]
+				}
+				service.Networks = iface
+				added = true
+			}
+
+		case map[string]interface{}:
+			if _, ok := v[network]; !ok {
+				// Add an empty map as network config
+				v[network] = map[string]interface{}{}
+				service.Networks = v
+				added = true
+			}
+
+		case map[interface{}]interface{}:
+			if _, ok := v[network]; !ok {
+				v[network] = map[string]interface{}{}
+				// convert map[interface{}]interface{} to map[string]interface{}
+				out := make(map[string]interface{})
+				for k, val := range v {
+					if ks, ok := k.(string); ok {
+						out[ks] = val
+					}
+				}
+				service.Networks = out
+				added = true
+			}
+
+		default:
+			// Unknown type: try to stringify and append if possible
+			if s, ok := v.(fmt.Stringer); ok {
+				cur := s.String()
+				if cur != network {
+					service.Networks = []interface{}{cur, network}
+					added = true
+				}
+			}
+		}
+
+		if added {
+			f.Services[name] = service
+		}
+	}
+}
+
+// EnsureContainerNames sets ContainerName to the service key when it's not defined.
+// This makes the effective compose file explicit about container names and ensures subsequent
+// processing (like simulated container creation) uses predictable names.
+func EnsureContainerNames(f *File) {
+	if f == nil || f.Services == nil {
+		return
+	}
+
+	for serviceName, service := range f.Services {
+		if strings.TrimSpace(service.ContainerName) == "" {
+			// Default container_name to the service key
+			service.ContainerName = serviceName
+			f.Services[serviceName] = service
+		}
+	}
+}
+
+// AddUndeclaredNetworksAndVolumes declares the networks and named volumes the services reference
+// but the file doesn't as external, and returns the names it added, sorted
+func AddUndeclaredNetworksAndVolumes(f *File) (networks, volumes []string) {
+	// Initialize maps if they don't exist
+	if f.Volumes == nil {
+		f.Volumes = make(map[string]Volume)
+	}
+	if f.Networks == nil {
+		f.Networks = make(map[string]Network)
+	}
+
+	// Collect all networks and volumes referenced by services
+	referencedNetworks := make(map[string]bool)
+	referencedVolumes := make(map[string]bool)
+
+	for _, service := range f.Services {
+		// Extract networks from service
+		switch v := service.Networks.(type) {
+		case nil:
+			// nothing to do
+		case string:
+			if v != "" {
+				referencedNetworks[v] = true
+			}
+		case []interface{}:
+			for _, net := range v {
+				switch n := net.(type) {
+				case string:
+					referencedNetworks[n] = true
+				case map[string]interface{}:
+					for name := range n {
+						referencedNetworks[name] = true
+					}
+				case map[interface{}]interface{}:
+					for k := range n {
+						if ks, ok := k.(string); ok {
+							referencedNetworks[ks] = true
+						}
+					}
+				}
+			}
+		case []string:
+			for _, net := range v {
+				referencedNetworks[net] = true
+			}
+		case map[string]interface{}:
+			for net := range v {
+				referencedNetworks[net] = true
+			}
+		case map[interface{}]interface{}:
+			for k := range v {
+				if ks, ok := k.(string); ok {
+					referencedNetworks[ks] = true
+				}
+			}
+		default:
+			// Unknown type: ignore safely
+		}
+
+		// Extract volumes from service
+		for _, volume := range service.Volumes {
+			// Parse volume definition to extract volume name
+			// Volume format can be:
+			// - "volume_name:/path/in/container"
+			// - "/host/path:/path/in/container"
+			// - "volume_name:/path:ro"
+			parts := strings.Split(volume, ":")
+			if len(parts) > 0 {
+				volumeName := parts[0]
+				// Only consider named volumes (not host paths starting with / or ./)
+				if !strings.HasPrefix(volumeName, "/") && !strings.HasPrefix(volumeName, "./") && !strings.HasPrefix(volumeName, "../") {
+					referencedVolumes[volumeName] = true
+				}
+			}
+		}
+	}
+
+	// Add missing networks as external
+	for network := range referencedNetworks {
+		if _, exists := f.Networks[network]; !exists {
+			f.Networks[network] = Network{External: true}
+			networks = append(networks, network)
+		}
+	}
+
+	// Add missing volumes as external
+	for volume := range referencedVolumes {
+		if _, exists := f.Volumes[volume]; !exists {
+			f.Volumes[volume] = Volume{External: true}
+			volumes = append(volumes, volume)
+		}
+	}
+	sort.Strings(networks)
+	sort.Strings(volumes)
+	return networks, volumes
+}
+
+// ExtractPort extracts the port number from various port formats
+// Supports: "80", "0.0.0.0:80", "127.0.0.1:80:80", "[::]:8080:80", "80/tcp", "0.0.0.0:80/tcp", etc.
+func ExtractPort(portStr string) int {
+	// The container port is always the last part (or only part if no bind address)
+	portPart := ParsePort(portStr).ContainerPort
+
+	// Try to parse as integer
+	var port int
+	fmt.Sscanf(portPart, "%d", &port)
+	return port
+}
+
+// LowestPrivilegedPort checks if any port below 1024 is used in the service
+// and returns the lowest privileged port found, or 0 if none found
+// Checks ports, environment variables, labels, and config content
+func LowestPrivilegedPort(service Service, labelsMap map[string]string, configs map[string]Config) int {
+	lowestPort := 0
+
+	// Check port declarations
+	for _, portMapping := range service.Ports {
+		// Check both host port and container port
+		spec := ParsePort(portMapping)
+		for _, part := range []string{spec.HostPort, spec.ContainerPort} {
+			port := ExtractPort(part)
+			if port > 0 && port < 1024 {
+				if lowestPort == 0 || port < lowestPort {
+					lowestPort = port
+				}
+			}
+		}
+	}
+
+	// Check environment variables for port values
+	envArray := NormalizeEnvironment(service.Environment)
+	for _, env := range envArray {
+		// Look for PORT=xxx or similar patterns
+		if strings.Contains(strings.ToUpper(env), "PORT") {
+			parts := strings.SplitN(env, "=", 2)
+			if len(parts) == 2 {
+				port := ExtractPort(parts[1])
+				if port > 0 && port < 1024 {
+					if lowestPort == 0 || port < lowestPort {
+						lowestPort = port
+					}
+				}
+			}
+		}
+	}
+
+	// Check labels for port values (including Traefik labels)
+	for key, value := range labelsMap {
+		if strings.Contains(strings.ToLower(key), "port") {
+			port := ExtractPort(value)
+			if port > 0 && port < 1024 {
+				if lowestPort == 0 || port < lowestPort {
+					lowestPort = port
+				}
+			}
+		}
+	}
+
+	// Check config content for port declarations
+	for _, configRef := range service.Configs {
+		if configDef, exists := configs[configRef.Source]; exists {
+			if configDef.Content != "" {
+				// Parse config content looking for port values
+				// Support JSON format: "port": 80 or "port":80
+				// Support YAML format: port: 80
+				// Support various port-related keys
+				portKeys := []string{"port", "PORT", "Port", "listen_port", "bind_port", "server_port", "http_port", "https_port"}
+
+				for _, key := range portKeys {
+					// Simple pattern matching without regex for performance
+					configLines := strings.Split(configDef.Content, "\n")
+					for _, line := range configLines {
+						// Look for "key": value or key: value
+						if strings.Contains(line, key) && strings.Contains(line, ":") {
+							// Extract the value after the colon
+							parts := strings.Split(line, ":")
+							if len(parts) >= 2 {
+								// Get the part after the key
+								for i, part := range parts {
+									if strings.Contains(part, key) && i+1 < len(parts) {
+										valuePart := strings.TrimSpace(parts[i+1])
+										// Remove trailing comma, quotes, etc.
+										valuePart = strings.Trim(valuePart, ` ,}"'`)
+										port := ExtractPort(valuePart)
+										if port > 0 && port < 1024 {
+											if lowestPort == 0 || port < lowestPort {
+												lowestPort = port
+											}
+										}
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return lowestPort
+}
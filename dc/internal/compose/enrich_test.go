@@ -0,0 +1,117 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectHTTPPort(t *testing.T) {
+	tests := []struct {
+		name       string
+		service    Service
+		port       string
+		scheme     string
+		detectable bool
+	}{
+		{"label", Service{Labels: map[string]interface{}{"app.port": "3000"}, Ports: []string{"9000:9000"}}, "3000", "http", true},
+		{"published port", Service{Ports: []string{"127.0.0.1:8081:3000/tcp"}}, "3000", "http", true},
+		{"environment", Service{Environment: []interface{}{"HTTP_PORT=443"}}, "443", "https", true},
+		{"none", Service{Environment: []interface{}{"TZ=UTC"}}, "", "", false},
+	}
+	for _, tt := range tests {
+		port, scheme, ok := DetectHTTPPort(&tt.service)
+		if port != tt.port || scheme != tt.scheme || ok != tt.detectable {
+			t.Errorf("%s: DetectHTTPPort = %q, %q, %v, want %q, %q, %v", tt.name, port, scheme, ok, tt.port, tt.scheme, tt.detectable)
+		}
+	}
+}
+
+func TestAddProxyLabels(t *testing.T) {
+	service := Service{Labels: []interface{}{"keep=me"}}
+	AddProxyLabels(&service, "wiki", "wiki.example.com", "3000", "https")
+	want := []string{
+		"keep=me",
+		"traefik.http.routers.wiki.entrypoints=https",
+		"traefik.http.routers.wiki.rule=Host(`wiki.example.com`)",
+		"traefik.http.services.wiki.loadbalancer.server.port=3000",
+	}
+	if got := sortedLabels(service.Labels); !reflect.DeepEqual(got, want) {
+		t.Errorf("labels = %q, want %q", got, want)
+	}
+	if _, ok := service.Labels.([]interface{}); !ok {
+		t.Errorf("labels changed type to %T", service.Labels)
+	}
+}
+
+func TestEnsureNetwork(t *testing.T) {
+	f := &File{Services: map[string]Service{
+		"none":  {},
+		"list":  {Networks: []interface{}{"backend"}},
+		"map":   {Networks: map[string]interface{}{"backend": nil}},
+		"has":   {Networks: []interface{}{"homelab"}},
+		"host":  {NetworkMode: "host"},
+		"strs":  {Networks: []string{"backend"}},
+		"named": {Networks: "backend"},
+	}}
+	EnsureNetwork(f, "homelab")
+
+	want := map[string]interface{}{
+		"none":  []interface{}{"homelab"},
+		"list":  []interface{}{"backend", "homelab"},
+		"map":   map[string]interface{}{"backend": nil, "homelab": map[string]interface{}{}},
+		"has":   []interface{}{"homelab"},
+		"host":  nil,
+		"strs":  []interface{}{"backend", "homelab"},
+		"named": []interface{}{"backend", "homelab"},
+	}
+	for name, networks := range want {
+		if got := f.Services[name].Networks; !reflect.DeepEqual(got, networks) {
+			t.Errorf("%s: networks = %#v, want %#v", name, got, networks)
+		}
+	}
+}
+
+func TestEnsureContainerNames(t *testing.T) {
+	f := &File{Services: map[string]Service{"web": {}, "db": {ContainerName: "postgres"}}}
+	EnsureContainerNames(f)
+	if got := f.Services["web"].ContainerName; got != "web" {
+		t.Errorf("web container_name = %q, want web", got)
+	}
+	if got := f.Services["db"].ContainerName; got != "postgres" {
+		t.Errorf("db container_name = %q, want postgres", got)
+	}
+}
+
+func TestAddUndeclaredNetworksAndVolumes(t *testing.T) {
+	f := &File{
+		Services: map[string]Service{
+			"web": {
+				Networks: []interface{}{"proxy", map[string]interface{}{"backend": nil}},
+				Volumes:  []string{"data:/data", "./conf:/conf:ro", "/srv/media:/media", "cache:/cache"},
+			},
+		},
+		Networks: map[string]Network{"backend": {}},
+		Volumes:  map[string]Volume{"data": {}},
+	}
+	networks, volumes := AddUndeclaredNetworksAndVolumes(f)
+	if !reflect.DeepEqual(networks, []string{"proxy"}) {
+		t.Errorf("added networks %q, want [proxy]", networks)
+	}
+	if !reflect.DeepEqual(volumes, []string{"cache"}) {
+		t.Errorf("added volumes %q, want [cache]", volumes)
+	}
+	if !f.Networks["proxy"].External || f.Networks["backend"].External {
+		t.Errorf("networks = %+v, want only proxy external", f.Networks)
+	}
+	if !f.Volumes["cache"].External || f.Volumes["data"].External {
+		t.Errorf("volumes = %+v, want only cache external", f.Volumes)
+	}
+}
+
+func TestExtractPort(t *testing.T) {
+	for in, want := range map[string]int{"80": 80, "0.0.0.0:80": 80, "127.0.0.1:8080:80": 80, "[::]:8080:81/tcp": 81, "abc": 0} {
+		if got := ExtractPort(in); got != want {
+			t.Errorf("ExtractPort(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package compose
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NormalizeEnvironment converts environment variables from map or array format to array format
+// Returns an array of strings in "KEY=VALUE" format
+func NormalizeEnvironment(env interface{}) []string {
+	if env == nil {
+		return nil
+	}
+
+	// If it's already an array
+	if envArray, ok := env.([]interface{}); ok {
+		result := make([]string, 0, len(envArray))
+		for _, item := range envArray {
+			if str, ok := item.(string); ok {
+				result = append(result, str)
+			}
+		}
+		return result
+	}
+
+	// If it's a map (from YAML)
+	if envMap, ok := env.(map[string]interface{}); ok {
+		result := make([]string, 0, len(envMap))
+		// Sort keys for consistent output
+		keys := make([]string, 0, len(envMap))
+		for k := range envMap {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			value := envMap[key]
+			// Convert value to string
+			var valueStr string
+			switch v := value.(type) {
+			case string:
+				valueStr = v
+			case int, int64, float64, bool:
+				valueStr = fmt.Sprintf("%v", v)
+			default:
+				valueStr = fmt.Sprintf("%v", v)
+			}
+			result = append(result, fmt.Sprintf("%s=%s", key, valueStr))
+		}
+		return result
+	}
+
+	// If it's already a []string (shouldn't happen after unmarshal, but just in case)
+	if envStrings, ok := env.([]string); ok {
+		return envStrings
+	}
+
+	return nil
+}
+
+// LabelsToMap normalizes any supported labels type into a flat map[string]string.
+func LabelsToMap(labels interface{}) map[string]string {
+	m := make(map[string]string)
+	switch v := labels.(type) {
+	case map[string]string:
+		for k, val := range v {
+			m[k] = val
+		}
+	case map[string]interface{}:
+		for k, val := range v {
+			m[k] = fmt.Sprintf("%v", val)
+		}
+	case map[interface{}]interface{}:
+		for k, val := range v {
+			if ks, ok := k.(string); ok {
+				m[ks] = fmt.Sprintf("%v", val)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				if parts := strings.SplitN(s, "=", 2); len(parts) == 2 {
+					m[parts[0]] = parts[1]
+				}
+			}
+		}
+	case []string:
+		for _, s := range v {
+			if parts := strings.SplitN(s, "=", 2); len(parts) == 2 {
+				m[parts[0]] = parts[1]
+			}
+		}
+	}
+	return m
+}
+
+// MapToLabels converts a flat map[string]string back to the same type as orig. Labels of an
+// unknown type are returned unchanged.
+func MapToLabels(m map[string]string, orig interface{}) interface{} {
+	switch orig.(type) {
+	case map[string]string, nil:
+		return m
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			out[k] = v
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[interface{}]interface{}, len(m))
+		for k, v := range m {
+			out[k] = v
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, 0, len(m))
+		for k, v := range m {
+			out = append(out, fmt.Sprintf("%s=%s", k, v))
+		}
+		return out
+	case []string:
+		out := make([]string, 0, len(m))
+		for k, v := range m {
+			out = append(out, fmt.Sprintf("%s=%s", k, v))
+		}
+		return out
+	default:
+		return orig
+	}
+}
@@ -0,0 +1,57 @@
+package compose
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestNormalizeEnvironment(t *testing.T) {
+	tests := []struct {
+		env  interface{}
+		want []string
+	}{
+		{nil, nil},
+		{[]interface{}{"A=1", "B"}, []string{"A=1", "B"}},
+		{map[string]interface{}{"B": 2, "A": "x", "C": true}, []string{"A=x", "B=2", "C=true"}},
+		{[]string{"A=1"}, []string{"A=1"}},
+	}
+	for _, tt := range tests {
+		if got := NormalizeEnvironment(tt.env); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NormalizeEnvironment(%v) = %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestLabelsRoundTrip(t *testing.T) {
+	tests := []interface{}{
+		map[string]interface{}{"a": "1", "b": "x=y"},
+		[]interface{}{"a=1", "b=x=y"},
+		[]string{"a=1", "b=x=y"},
+	}
+	for _, labels := range tests {
+		flat := LabelsToMap(labels)
+		if want := map[string]string{"a": "1", "b": "x=y"}; !reflect.DeepEqual(flat, want) {
+			t.Errorf("LabelsToMap(%v) = %v, want %v", labels, flat, want)
+		}
+		back := MapToLabels(flat, labels)
+		if reflect.TypeOf(back) != reflect.TypeOf(labels) {
+			t.Errorf("MapToLabels returned %T for %T labels", back, labels)
+		}
+		if got := LabelsToMap(back); !reflect.DeepEqual(got, flat) {
+			t.Errorf("labels %v changed to %v", flat, got)
+		}
+	}
+	if got := MapToLabels(map[string]string{"a": "1"}, 42); got != 42 {
+		t.Errorf("MapToLabels of unknown labels = %v, want them unchanged", got)
+	}
+}
+
+func sortedLabels(labels interface{}) []string {
+	var out []string
+	for k, v := range LabelsToMap(labels) {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
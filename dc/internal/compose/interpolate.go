@@ -0,0 +1,185 @@
+package compose
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Compose interpolation grammar:
+//
+//	$NAME, ${NAME}      the value of NAME
+//	${NAME:-default}    default if NAME is unset or empty; ${NAME-default} only if unset
+//	${NAME:?message}    fails with message if NAME is unset or empty; ${NAME?message} only if unset
+//	${NAME:+other}      other if NAME is set and not empty, else empty; ${NAME+other} if set
+//	$$                  an escaped $
+//
+// Defaults, messages and replacements are templates themselves, so variables and braces nest:
+// ${URL:-http://${HOST:-localhost}:${PORT:-8080}}.
+
+// templateOperators are the operators of ${NAME<op>word}, two-character ones first
+var templateOperators = []string{":-", ":?", ":+", "-", "?", "+"}
+
+// templateExpr is a variable reference of a template
+type templateExpr struct {
+	name     string // empty for a $ that starts no reference, and for $$
+	op       string // one of templateOperators, or empty
+	word     string // the default, message or replacement following op
+	original string // the text of the reference
+}
+
+// variableNameLen returns the length of the variable name s starts with, 0 if none
+func variableNameLen(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return i
+	}
+	return len(s)
+}
+
+// scanTemplateExpr reads the reference starting at the $ s begins with
+func scanTemplateExpr(s string) (templateExpr, error) {
+	if len(s) < 2 || s[1] == '$' {
+		return templateExpr{original: s[:min(len(s), 2)]}, nil
+	}
+	if s[1] != '{' {
+		n := variableNameLen(s[1:])
+		return templateExpr{name: s[1 : 1+n], original: s[:1+n]}, nil
+	}
+
+	// find the } closing the reference, skipping nested ${...} and $$
+	depth, end := 1, -1
+	for i := 2; i < len(s) && end < 0; i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '$':
+			i++
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case s[i] == '}':
+			if depth--; depth == 0 {
+				end = i
+			}
+		}
+	}
+	if end < 0 {
+		return templateExpr{}, fmt.Errorf("invalid interpolation format %q: missing }", s)
+	}
+
+	expr := templateExpr{original: s[:end+1]}
+	body := s[2:end]
+	n := variableNameLen(body)
+	if n == 0 {
+		return templateExpr{}, fmt.Errorf("invalid interpolation format %q: invalid variable name", expr.original)
+	}
+	expr.name, body = body[:n], body[n:]
+	if body == "" {
+		return expr, nil
+	}
+	for _, op := range templateOperators {
+		if strings.HasPrefix(body, op) {
+			expr.op, expr.word = op, body[len(op):]
+			return expr, nil
+		}
+	}
+	return templateExpr{}, fmt.Errorf("invalid interpolation format %q", expr.original)
+}
+
+// Interpolate expands the variables of a template. lookup returns the value of a variable and
+// whether it is set; missing returns the text for a variable without value or default. $$
+// escapes are kept, since the result is interpolated by docker compose again.
+func Interpolate(s string, lookup func(name string) (string, bool), missing func(name, original string) string) (string, error) {
+	var out strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			out.WriteString(s)
+			return out.String(), nil
+		}
+		out.WriteString(s[:i])
+		expr, err := scanTemplateExpr(s[i:])
+		if err != nil {
+			return "", err
+		}
+		s = s[i+len(expr.original):]
+		if expr.name == "" {
+			out.WriteString(expr.original)
+			continue
+		}
+		value, err := expr.expand(lookup, missing)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(value)
+	}
+}
+
+// expand returns the value of a reference
+func (e templateExpr) expand(lookup func(name string) (string, bool), missing func(name, original string) string) (string, error) {
+	value, set := lookup(e.name)
+	nonEmpty := set && value != ""
+	switch e.op {
+	case ":-", "-":
+		if nonEmpty || set && e.op == "-" {
+			return value, nil
+		}
+		return Interpolate(e.word, lookup, missing)
+	case ":?", "?":
+		if nonEmpty || set && e.op == "?" {
+			return value, nil
+		}
+		message, err := Interpolate(e.word, lookup, func(_, original string) string { return original })
+		if err != nil || message == "" {
+			return "", fmt.Errorf("required variable %s is missing a value", e.name)
+		}
+		return "", fmt.Errorf("required variable %s is missing a value: %s", e.name, message)
+	case ":+", "+":
+		if nonEmpty || set && e.op == "+" {
+			return Interpolate(e.word, lookup, missing)
+		}
+		return "", nil
+	}
+	if !set {
+		return missing(e.name, e.original), nil
+	}
+	return value, nil
+}
+
+// Variables returns the variables a template references, including those nested in
+// defaults and replacements. Malformed references are skipped.
+func Variables(s string) []string {
+	var names []string
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			return names
+		}
+		expr, err := scanTemplateExpr(s[i:])
+		if err != nil {
+			s = s[i+1:]
+			continue
+		}
+		s = s[i+len(expr.original):]
+		if expr.name != "" {
+			names = append(names, expr.name)
+			names = append(names, Variables(expr.word)...)
+		}
+	}
+}
+
+// ReferencesVariables reports whether a template references a variable; $$ escapes don't count
+func ReferencesVariables(s string) bool {
+	return len(Variables(s)) > 0
+}
+
+// EscapeDollars turns a literal value into a template, e.g. an apr1 or bcrypt hash
+func EscapeDollars(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+// UnescapeDollars returns the literal value of a template without variables
+func UnescapeDollars(s string) string {
+	return strings.ReplaceAll(s, "$$", "$")
+}
@@ -0,0 +1,251 @@
+// Package compose is the model of compose files as dc reads and writes them, and the compose
+// interpolation grammar.
+package compose
+
+// File is a compose file with the dc extensions
+type File struct {
+	Services map[string]Service `yaml:"services"`
+	Volumes  map[string]Volume  `yaml:"volumes,omitempty"`
+	Networks map[string]Network `yaml:"networks,omitempty"`
+	Configs  map[string]Config  `yaml:"configs,omitempty"`
+	Secrets  map[string]Secret  `yaml:"secrets,omitempty"`
+
+	// Composectl holds dc-specific settings that docker compose ignores (x- extension)
+	Composectl *Extension `yaml:"x-composectl,omitempty"`
+
+	// Group tags the stack for listing, bulk actions and boot order; the group in the stack metadata overrides it
+	Group string `yaml:"x-dc-group,omitempty"`
+}
+
+// Extension is the top-level x-composectl block of a stack file
+type Extension struct {
+	Preflight []PreflightCheck  `yaml:"preflight,omitempty"`
+	Requires  []HostRequirement `yaml:"requires,omitempty"`
+	Devices   *DevicesConfig    `yaml:"devices,omitempty"`
+
+	// Public lists the stack on the unauthenticated status page, under Title if set
+	Public bool   `yaml:"public,omitempty"`
+	Title  string `yaml:"title,omitempty"`
+
+	// Drift is report, correct or ignore: what the reconciler does when the containers drift
+	// from the effective file (default drift_mode)
+	Drift string `yaml:"drift,omitempty"`
+
+	// Autostart brings the stack up on `dc boot`, e.g. after a host reboot
+	Autostart bool `yaml:"autostart,omitempty"`
+
+	// AutoReload redeploys the stack when dcapi sees its stack file change
+	AutoReload bool `yaml:"auto_reload,omitempty"`
+
+	// Internal lists the services that must not publish ports on all addresses (public-bind policy)
+	Internal []string `yaml:"internal,omitempty"`
+
+	// Labels group stacks for bulk operations, e.g. `dc stacks up --label group=media`
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// Backend is compose or swarm: how the stack is run (default backend)
+	Backend string `yaml:"backend,omitempty"`
+
+	// KeepPlaintext lists by service the environment variables whose inline values are not
+	// secrets and stay in the stack file; "*" keeps all of the service
+	KeepPlaintext map[string][]string `yaml:"keep_plaintext,omitempty"`
+
+	// Dashboard lists by service the entries of the services on a dashboard (homepage, Dashy,
+	// Homarr), which enrichment turns into its discovery labels
+	Dashboard map[string]DashboardEntry `yaml:"dashboard,omitempty"`
+
+	// Budget caps the memory and CPUs the services of the stack may declare in total; deploys
+	// exceeding it are refused
+	Budget *ResourceBudget `yaml:"budget,omitempty"`
+}
+
+// ResourceBudget is the memory (e.g. "4g") and number of CPUs a stack may declare in total.
+// A zero value leaves that resource unbounded.
+type ResourceBudget struct {
+	Memory string  `yaml:"memory,omitempty"`
+	CPUs   float64 `yaml:"cpus,omitempty"`
+}
+
+// DashboardEntry describes a service on a dashboard. Name defaults to the service, Group to the
+// group of the stack and URL to the host the proxy labels route to the service.
+type DashboardEntry struct {
+	Name        string `yaml:"name,omitempty"`
+	Group       string `yaml:"group,omitempty"`
+	Icon        string `yaml:"icon,omitempty"` // e.g. jellyfin.png or mdi-movie
+	URL         string `yaml:"url,omitempty"`
+	Description string `yaml:"description,omitempty"`
+}
+
+// HostRequirement is a host-level precondition verified before "up". Exactly one of Unit
+// (the systemd unit is active), Mount (the path is a mount point) or Path (the path exists) is set.
+type HostRequirement struct {
+	Name     string `yaml:"name,omitempty"`
+	Unit     string `yaml:"unit,omitempty"`
+	UserUnit bool   `yaml:"user_unit,omitempty"` // Unit is a systemd --user unit
+	Mount    string `yaml:"mount,omitempty"`
+	Path     string `yaml:"path,omitempty"`
+}
+
+// DevicesConfig lists host devices whose re-appearance restarts dependent services
+type DevicesConfig struct {
+	Watch []DeviceWatch `yaml:"watch,omitempty"`
+}
+
+// DeviceWatch restarts Service (default: every service mapping Path in devices:) when Path
+// appears again after having been removed, e.g. a re-plugged /dev/serial/by-id/usb-... stick
+type DeviceWatch struct {
+	Path    string `yaml:"path"`
+	Service string `yaml:"service,omitempty"`
+}
+
+// PreflightCheck is an external dependency probed before "up".
+// Host only checks DNS resolution, Host+Port checks TCP reachability, URL checks HTTP.
+type PreflightCheck struct {
+	Name    string `yaml:"name,omitempty"`
+	Host    string `yaml:"host,omitempty"`
+	Port    int    `yaml:"port,omitempty"`
+	URL     string `yaml:"url,omitempty"`
+	Network string `yaml:"network,omitempty"`
+	Timeout int    `yaml:"timeout,omitempty"` // seconds
+}
+
+// Volume is a top-level volume
+type Volume struct {
+	External   bool              `yaml:"external,omitempty"`
+	Name       string            `yaml:"name,omitempty"`
+	Driver     string            `yaml:"driver,omitempty"`
+	DriverOpts map[string]string `yaml:"driver_opts,omitempty"`
+}
+
+// Network is a top-level network
+type Network struct {
+	External   bool              `yaml:"external,omitempty"`
+	Name       string            `yaml:"name,omitempty"`
+	Driver     string            `yaml:"driver,omitempty"`
+	DriverOpts map[string]string `yaml:"driver_opts,omitempty"`
+}
+
+// Config is a top-level config, inline (Content), from a file or external
+type Config struct {
+	Content  string `yaml:"content,omitempty"`
+	File     string `yaml:"file,omitempty"`
+	Name     string `yaml:"name,omitempty"`
+	External bool   `yaml:"external,omitempty"`
+}
+
+// Secret is a top-level secret, from an environment variable, a file or external
+type Secret struct {
+	Name        string `yaml:"name,omitempty"`
+	Environment string `yaml:"environment,omitempty"`
+	File        string `yaml:"file,omitempty"`
+	External    bool   `yaml:"external,omitempty"`
+}
+
+// ServiceConfig mounts a config into a service
+type ServiceConfig struct {
+	Source string `yaml:"source"`
+	Target string `yaml:"target"`
+}
+
+// Service is a service of a compose file; fields of several YAML shapes are kept as parsed
+type Service struct {
+	Image         string                 `yaml:"image"`
+	ContainerName string                 `yaml:"container_name,omitempty"`
+	User          string                 `yaml:"user,omitempty"`
+	Restart       string                 `yaml:"restart,omitempty"`
+	Volumes       []string               `yaml:"volumes,omitempty"`
+	Ports         []string               `yaml:"ports,omitempty"`
+	Environment   interface{}            `yaml:"environment,omitempty"` // Can be array or map
+	Networks      interface{}            `yaml:"networks,omitempty"`    // Can be array or map
+	Labels        interface{}            `yaml:"labels,omitempty"`      // Can be array or map
+	Command       interface{}            `yaml:"command,omitempty"`     // Can be string or array
+	Entrypoint    interface{}            `yaml:"entrypoint,omitempty"`  // Can be string or array
+	Configs       []ServiceConfig        `yaml:"configs,omitempty"`
+	CapAdd        []string               `yaml:"cap_add,omitempty"`
+	CapDrop       []string               `yaml:"cap_drop,omitempty"`
+	Sysctls       interface{}            `yaml:"sysctls,omitempty"` // Can be array or map
+	Secrets       []string               `yaml:"secrets,omitempty"`
+	MemLimit      string                 `yaml:"mem_limit,omitempty"`
+	MemswapLimit  int64                  `yaml:"memswap_limit,omitempty"`
+	CPUs          interface{}            `yaml:"cpus,omitempty"` // Can be string or number
+	Logging       *Logging               `yaml:"logging,omitempty"`
+	Devices       []string               `yaml:"devices,omitempty"`
+	ExtraHosts    interface{}            `yaml:"extra_hosts,omitempty"` // Can be array or map
+	Build         interface{}            `yaml:"build,omitempty"`       // Can be string or map
+	Develop       interface{}            `yaml:"develop,omitempty"`     // docker compose watch rules
+	DependsOn     interface{}            `yaml:"depends_on,omitempty"`  // Can be array or map with conditions
+	Healthcheck   interface{}            `yaml:"healthcheck,omitempty"`
+	Deploy        map[string]interface{} `yaml:"deploy,omitempty"` // replicas, resources, restart_policy, ...
+	Hostname      string                 `yaml:"hostname,omitempty"`
+	WorkingDir    string                 `yaml:"working_dir,omitempty"`
+	NetworkMode   string                 `yaml:"network_mode,omitempty"` // host, none, container:<name>, ...; such services join no networks
+	Privileged    bool                   `yaml:"privileged,omitempty"`
+	Init          bool                   `yaml:"init,omitempty"`
+	ReadOnly      bool                   `yaml:"read_only,omitempty"`
+	Tty           bool                   `yaml:"tty,omitempty"`
+	StdinOpen     bool                   `yaml:"stdin_open,omitempty"`
+	ShmSize       interface{}            `yaml:"shm_size,omitempty"` // Can be string or number
+	SecurityOpt   []string               `yaml:"security_opt,omitempty"`
+	GroupAdd      []string               `yaml:"group_add,omitempty"`
+	DNS           interface{}            `yaml:"dns,omitempty"`   // Can be string or array
+	Tmpfs         interface{}            `yaml:"tmpfs,omitempty"` // Can be string or array
+	Ulimits       map[string]interface{} `yaml:"ulimits,omitempty"`
+	StopSignal    string                 `yaml:"stop_signal,omitempty"`
+	Pid           string                 `yaml:"pid,omitempty"`
+	Ipc           string                 `yaml:"ipc,omitempty"`
+
+	// Publish is "auto" to have dc assign host ports from port_range to the mappings without one
+	Publish string `yaml:"x-dc-publish,omitempty"`
+
+	// ResourceDefaults is false to keep dc from setting mem_limit and cpus defaults
+	ResourceDefaults *bool `yaml:"x-dc-resource-defaults,omitempty"`
+}
+
+// Logging is the logging driver of a service
+type Logging struct {
+	Driver  string            `yaml:"driver"`
+	Options map[string]string `yaml:"options,omitempty"`
+}
+
+// depends_on conditions understood by docker compose
+const (
+	ConditionServiceStarted               = "service_started"
+	ConditionServiceHealthy               = "service_healthy"
+	ConditionServiceCompletedSuccessfully = "service_completed_successfully"
+)
+
+// Action is what dc does with a stack
+type Action int
+
+const (
+	ActionNone   Action = iota
+	ActionCreate Action = iota
+	ActionRemove Action = iota
+	ActionStart  Action = iota
+	ActionStop   Action = iota
+	ActionUp     Action = iota
+	ActionDown   Action = iota
+	ActionWatch  Action = iota
+)
+
+// String returns the dc verb of the action (as used on the command line)
+func (a Action) String() string {
+	switch a {
+	case ActionCreate:
+		return "create"
+	case ActionRemove:
+		return "rm"
+	case ActionStart:
+		return "start"
+	case ActionStop:
+		return "stop"
+	case ActionUp:
+		return "up"
+	case ActionDown:
+		return "down"
+	case ActionWatch:
+		return "watch"
+	default:
+		return "save"
+	}
+}
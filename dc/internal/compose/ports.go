@@ -0,0 +1,91 @@
+package compose
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PortSpec is a compose short-syntax port mapping: [HOST_IP:][HOST_PORT:]CONTAINER_PORT[/PROTOCOL].
+// IPv6 host addresses are written in brackets, e.g. "[::]:8080:80" or "[::1]::80".
+type PortSpec struct {
+	HostIP        string // without brackets, empty when the port is published on all addresses
+	HostPort      string // may be a range or empty for an ephemeral host port
+	ContainerPort string // may be a range
+	Protocol      string // empty when not given (docker defaults to tcp)
+}
+
+// ParsePort parses a compose short-syntax port mapping. Unbracketed IPv6 addresses are
+// accepted as long as the host and container port follow them.
+func ParsePort(s string) PortSpec {
+	var spec PortSpec
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		spec.Protocol = s[i+1:]
+		s = s[:i]
+	}
+
+	if strings.HasPrefix(s, "[") {
+		if end := strings.Index(s, "]"); end > 0 {
+			spec.HostIP = s[1:end]
+			s = strings.TrimPrefix(s[end+1:], ":")
+		}
+	}
+
+	parts := strings.Split(s, ":")
+	switch {
+	case len(parts) == 1:
+		spec.ContainerPort = parts[0]
+	case len(parts) == 2:
+		spec.HostPort, spec.ContainerPort = parts[0], parts[1]
+	default:
+		spec.HostIP = strings.Join(parts[:len(parts)-2], ":")
+		spec.HostPort, spec.ContainerPort = parts[len(parts)-2], parts[len(parts)-1]
+	}
+	return spec
+}
+
+// formatHostIP returns the host address as written in a port mapping, bracketing IPv6 addresses
+func formatHostIP(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "[" + ip + "]"
+	}
+	return ip
+}
+
+// IsWildcardIP reports whether the address binds all IPv4 or all IPv6 addresses
+func IsWildcardIP(ip string) bool {
+	return ip == "0.0.0.0" || ip == "::"
+}
+
+// String formats the mapping in compose short syntax
+func (p PortSpec) String() string {
+	s := p.ContainerPort
+	switch {
+	case p.HostIP != "":
+		s = fmt.Sprintf("%s:%s:%s", formatHostIP(p.HostIP), p.HostPort, p.ContainerPort)
+	case p.HostPort != "":
+		s = fmt.Sprintf("%s:%s", p.HostPort, p.ContainerPort)
+	}
+	if p.Protocol != "" {
+		s += "/" + p.Protocol
+	}
+	return s
+}
+
+// DualStackPorts replaces mappings published without a host address by one explicit IPv4
+// and one explicit IPv6 wildcard mapping. This keeps the ports reachable over both address
+// families regardless of the daemon's ipv6 defaults.
+func DualStackPorts(ports []string) []string {
+	var result []string
+	for _, port := range ports {
+		spec := ParsePort(port)
+		if spec.HostIP != "" || spec.HostPort == "" {
+			result = append(result, port)
+			continue
+		}
+		v4, v6 := spec, spec
+		v4.HostIP, v6.HostIP = "0.0.0.0", "::"
+		result = append(result, v4.String(), v6.String())
+	}
+	return result
+}
@@ -0,0 +1,47 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		in   string
+		want PortSpec
+	}{
+		{"80", PortSpec{ContainerPort: "80"}},
+		{"8080:80", PortSpec{HostPort: "8080", ContainerPort: "80"}},
+		{"127.0.0.1:8080:80/udp", PortSpec{HostIP: "127.0.0.1", HostPort: "8080", ContainerPort: "80", Protocol: "udp"}},
+		{"[::]:8080:80", PortSpec{HostIP: "::", HostPort: "8080", ContainerPort: "80"}},
+		{"[::1]::80", PortSpec{HostIP: "::1", ContainerPort: "80"}},
+		{"::1:8080:80", PortSpec{HostIP: "::1", HostPort: "8080", ContainerPort: "80"}},
+		{"9000-9001:9000-9001", PortSpec{HostPort: "9000-9001", ContainerPort: "9000-9001"}},
+	}
+	for _, tt := range tests {
+		if got := ParsePort(tt.in); got != tt.want {
+			t.Errorf("ParsePort(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPortSpecString(t *testing.T) {
+	for _, in := range []string{"80", "8080:80", "127.0.0.1:8080:80/udp", "[::]:8080:80", "[::1]::80"} {
+		if got := ParsePort(in).String(); got != in {
+			t.Errorf("ParsePort(%q).String() = %q", in, got)
+		}
+	}
+}
+
+func TestDualStackPorts(t *testing.T) {
+	got := DualStackPorts([]string{"8080:80", "53:53/udp", "127.0.0.1:9000:9000", "3000"})
+	want := []string{
+		"0.0.0.0:8080:80", "[::]:8080:80",
+		"0.0.0.0:53:53/udp", "[::]:53:53/udp",
+		"127.0.0.1:9000:9000",
+		"3000",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DualStackPorts = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"dc/internal/secrets"
+)
+
+// KeyFunc returns the variable backing the secret of a normalized environment key, e.g. one
+// namespaced with the stack. newValue is set when the secret gets a value from the stack file.
+// A nil KeyFunc uses the normalized key itself.
+type KeyFunc func(key string, newValue bool) string
+
+// variable returns the variable of key
+func (f KeyFunc) variable(key string, newValue bool) string {
+	normalized := secrets.NormalizeKey(key)
+	if f == nil {
+		return normalized
+	}
+	return f(normalized, newValue)
+}
+
+// ExtractedSecret is a plaintext value SanitizeEnvironment moved to the secrets store
+type ExtractedSecret struct {
+	Service  string `json:"service"`
+	Key      string `json:"key"`
+	Variable string `json:"variable"`
+}
+
+// KeepPlaintext reports whether x-composectl.keep_plaintext exempts an environment variable of
+// a service from extraction
+func KeepPlaintext(f *File, serviceName, key string) bool {
+	if f.Composectl == nil {
+		return false
+	}
+	for _, kept := range f.Composectl.KeepPlaintext[serviceName] {
+		if kept == "*" || strings.EqualFold(kept, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// SanitizeEnvVar replaces the value of a KEY=VALUE environment variable with a sensitive key by
+// a reference to the variable keyFor returns, e.g. PASSWORD=${WIKI_PASSWORD}. Values that
+// already reference a variable are kept.
+func SanitizeEnvVar(envStr string, keyFor KeyFunc) string {
+	key, value, ok := strings.Cut(envStr, "=")
+	if !ok || !secrets.IsSensitiveKey(key, value) || ReferencesVariables(value) {
+		return envStr
+	}
+	return fmt.Sprintf("%s=${%s}", key, keyFor.variable(key, value != ""))
+}
+
+// SanitizeEnvironment moves the plaintext values of sensitive environment variables to the
+// store and replaces them with references to the variables keyFor returns. Variables listed in
+// x-composectl.keep_plaintext are left alone. The store gets the literal value, so the $$
+// escapes of the stack file are undone. It returns what was extracted; a value the store
+// refused is reported in the error and still replaced by its reference.
+func SanitizeEnvironment(f *File, keyFor KeyFunc, store secrets.Store) ([]ExtractedSecret, error) {
+	var extracted []ExtractedSecret
+	var errs []error
+	for serviceName, service := range f.Services {
+		if service.Environment == nil {
+			continue
+		}
+		var sanitizedEnv []string
+		for _, envVar := range NormalizeEnvironment(service.Environment) {
+			key, value, ok := strings.Cut(envVar, "=")
+			if ok && KeepPlaintext(f, serviceName, key) {
+				sanitizedEnv = append(sanitizedEnv, envVar)
+				continue
+			}
+			if ok && secrets.IsSensitiveKey(key, value) && value != "" && !ReferencesVariables(value) && !strings.HasPrefix(value, "/run/secrets/") {
+				variable := keyFor.variable(key, true)
+				if err := store.Insert(variable, UnescapeDollars(value)); err != nil {
+					errs = append(errs, fmt.Errorf("failed to store %s of service %s: %w", variable, serviceName, err))
+				} else {
+					extracted = append(extracted, ExtractedSecret{Service: serviceName, Key: key, Variable: variable})
+				}
+			}
+			sanitizedEnv = append(sanitizedEnv, SanitizeEnvVar(envVar, keyFor))
+		}
+		service.Environment = sanitizedEnv
+		f.Services[serviceName] = service
+	}
+	sort.Slice(extracted, func(i, j int) bool {
+		if extracted[i].Service != extracted[j].Service {
+			return extracted[i].Service < extracted[j].Service
+		}
+		return extracted[i].Key < extracted[j].Key
+	})
+	return extracted, errors.Join(errs...)
+}
+
+// DeclareSecrets declares the secrets that environment values reference as /run/secrets/<name>
+// at both service and top level. A new top-level secret is backed by the variable variableFor
+// returns. It returns the variables backing the referenced secrets, sorted.
+func DeclareSecrets(f *File, variableFor func(secretName string) string) []string {
+	requiredSecrets := make(map[string]bool)
+
+	for serviceName, service := range f.Services {
+		serviceSecrets := make(map[string]bool)
+		for _, envVar := range NormalizeEnvironment(service.Environment) {
+			_, value, ok := strings.Cut(envVar, "=")
+			if !ok || !strings.HasPrefix(value, "/run/secrets/") {
+				continue
+			}
+			secretName := strings.TrimPrefix(value, "/run/secrets/")
+			if secretName == "" {
+				continue
+			}
+			// a reference written as /run/secrets/${NAME} declares the secret NAME
+			if strings.HasPrefix(secretName, "${") && strings.HasSuffix(secretName, "}") {
+				secretName = secretName[2 : len(secretName)-1]
+			}
+			serviceSecrets[secretName] = true
+			requiredSecrets[secretName] = true
+		}
+		if len(serviceSecrets) == 0 {
+			continue
+		}
+
+		existingSecrets := make(map[string]bool)
+		for _, secret := range service.Secrets {
+			existingSecrets[secret] = true
+		}
+		var added []string
+		for secretName := range serviceSecrets {
+			if !existingSecrets[secretName] {
+				added = append(added, secretName)
+			}
+		}
+		sort.Strings(added)
+		service.Secrets = append(service.Secrets, added...)
+		f.Services[serviceName] = service
+	}
+
+	if f.Secrets == nil {
+		f.Secrets = make(map[string]Secret)
+	}
+	var variables []string
+	for secretName := range requiredSecrets {
+		if _, exists := f.Secrets[secretName]; !exists {
+			f.Secrets[secretName] = Secret{Name: secretName, Environment: variableFor(secretName)}
+		}
+		if variable := f.Secrets[secretName].Environment; variable != "" {
+			variables = append(variables, variable)
+		}
+	}
+	sort.Strings(variables)
+	return variables
+}
@@ -0,0 +1,215 @@
+package compose
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// memStore is a secrets.Store keeping the values in a map
+type memStore map[string]string
+
+func (s memStore) Insert(key, value string) error {
+	if _, ok := s[key]; !ok {
+		s[key] = value
+	}
+	return nil
+}
+
+func (s memStore) Generate(key string) error {
+	return s.Insert(key, "generated")
+}
+
+// containerValue returns the value docker compose hands to the container for a value of the
+// effective file, which it interpolates once more without variables of its own
+func containerValue(t *testing.T, s string) string {
+	t.Helper()
+	value, err := Interpolate(s, func(string) (string, bool) { return "", false }, func(name, _ string) string {
+		t.Errorf("docker compose would see the variable %s in %q", name, s)
+		return ""
+	})
+	if err != nil {
+		t.Fatalf("docker compose would reject %q: %v", s, err)
+	}
+	return UnescapeDollars(value)
+}
+
+func parseStack(t *testing.T, content string) *File {
+	t.Helper()
+	var f File
+	if err := yaml.Unmarshal([]byte(content), &f); err != nil {
+		t.Fatalf("parse stack file: %v", err)
+	}
+	return &f
+}
+
+// TestSanitizeSubstituteRoundTrip follows a value of a stack file through the extraction to
+// the secrets store and the substitution of the effective file to what the container gets
+func TestSanitizeSubstituteRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		value     string            // as written in the stack file
+		vars      map[string]string // prod.env
+		stored    string            // what the store gets, empty if the value stays inline
+		sanitized string            // the value in the sanitized stack file
+		container string            // what the container sees
+	}{
+		{
+			name:      "apr1 htpasswd",
+			key:       "BASIC_AUTH_PASSWORD",
+			value:     "admin:$$apr1$$H6uskkkW$$IgXLP6ewTrSuBkTrqE8wj/",
+			stored:    "admin:$apr1$H6uskkkW$IgXLP6ewTrSuBkTrqE8wj/",
+			sanitized: "${BASIC_AUTH_PASSWORD}",
+			container: "admin:$apr1$H6uskkkW$IgXLP6ewTrSuBkTrqE8wj/",
+		},
+		{
+			name:      "bcrypt hash",
+			key:       "ADMIN_PASSWORD_HASH",
+			value:     "$$2y$$10$$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+			stored:    "$2y$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+			sanitized: "${ADMIN_PASSWORD_HASH}",
+			container: "$2y$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+		},
+		{
+			name:      "escaped reference only",
+			key:       "API_TOKEN",
+			value:     "tok$${X}",
+			vars:      map[string]string{"X": "ignored"},
+			stored:    "tok${X}",
+			sanitized: "${API_TOKEN}",
+			container: "tok${X}",
+		},
+		{
+			name:      "escaped and real reference",
+			key:       "DB_PASSWORD",
+			value:     "$${X}-${X}",
+			vars:      map[string]string{"X": "s3cret"},
+			sanitized: "$${X}-${X}",
+			container: "${X}-s3cret",
+		},
+		{
+			name:      "mixed references of a plain variable",
+			key:       "GREETING",
+			value:     "$${USER} is ${USER}",
+			vars:      map[string]string{"USER": "bob"},
+			sanitized: "$${USER} is ${USER}",
+			container: "${USER} is bob",
+		},
+		{
+			name:      "variable with a dollar",
+			key:       "SMTP_PASSWORD",
+			value:     "${MAIL_PASS}",
+			vars:      map[string]string{"MAIL_PASS": "pa$$word$"},
+			sanitized: "${MAIL_PASS}",
+			container: "pa$$word$",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := parseStack(t, fmt.Sprintf("services:\n  app:\n    image: app\n    environment:\n      %s: '%s'\n", tt.key, tt.value))
+			store := memStore{}
+			extracted, err := SanitizeEnvironment(f, nil, store)
+			if err != nil {
+				t.Fatalf("SanitizeEnvironment: %v", err)
+			}
+
+			if tt.stored == "" {
+				if len(store) != 0 || len(extracted) != 0 {
+					t.Errorf("extracted %v to %v, want the value kept inline", extracted, store)
+				}
+			} else {
+				if got := store[tt.key]; got != tt.stored {
+					t.Errorf("stored %q, want %q", got, tt.stored)
+				}
+				want := []ExtractedSecret{{Service: "app", Key: tt.key, Variable: tt.key}}
+				if !reflect.DeepEqual(extracted, want) {
+					t.Errorf("extracted %v, want %v", extracted, want)
+				}
+			}
+			env := NormalizeEnvironment(f.Services["app"].Environment)
+			if want := []string{tt.key + "=" + tt.sanitized}; !reflect.DeepEqual(env, want) {
+				t.Fatalf("sanitized environment %q, want %q", env, want)
+			}
+
+			// the sanitized file is written and read back before it is deployed
+			out, err := yaml.Marshal(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f = parseStack(t, string(out))
+
+			vars := map[string]string{}
+			for k, v := range tt.vars {
+				vars[k] = v
+			}
+			for k, v := range store {
+				vars[k] = v
+			}
+			if _, err := Substitute(f, lookupIn(vars)); err != nil {
+				t.Fatalf("Substitute: %v", err)
+			}
+			env = NormalizeEnvironment(f.Services["app"].Environment)
+			if len(env) != 1 || !strings.HasPrefix(env[0], tt.key+"=") {
+				t.Fatalf("effective environment %q", env)
+			}
+			if got := containerValue(t, strings.TrimPrefix(env[0], tt.key+"=")); got != tt.container {
+				t.Errorf("container gets %q, want %q", got, tt.container)
+			}
+		})
+	}
+}
+
+func TestSanitizeSubstituteKeepsHealthchecks(t *testing.T) {
+	tests := []struct {
+		name      string
+		test      string // the healthcheck test as written in the stack file
+		container []string
+	}{
+		{
+			name:      "shell string",
+			test:      `"curl -fsu admin:$$PASSWORD http://localhost/ || exit 1"`,
+			container: []string{"curl -fsu admin:$PASSWORD http://localhost/ || exit 1"},
+		},
+		{
+			name:      "exec list",
+			test:      `["CMD-SHELL", "test $$(cat /tmp/ready) = $${READY:-yes}"]`,
+			container: []string{"CMD-SHELL", "test $(cat /tmp/ready) = ${READY:-yes}"},
+		},
+		{
+			name:      "bcrypt hash argument",
+			test:      `["CMD", "check", "--hash", "$$2y$$05$$abcdefghijklmnopqrstuu"]`,
+			container: []string{"CMD", "check", "--hash", "$2y$05$abcdefghijklmnopqrstuu"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := parseStack(t, "services:\n  app:\n    image: app\n    environment:\n      PASSWORD: 'x$$y'\n    healthcheck:\n      test: "+tt.test+"\n")
+			if _, err := SanitizeEnvironment(f, nil, memStore{}); err != nil {
+				t.Fatalf("SanitizeEnvironment: %v", err)
+			}
+			if _, err := Substitute(f, lookupIn(map[string]string{"PASSWORD": "x$y"})); err != nil {
+				t.Fatalf("Substitute: %v", err)
+			}
+
+			var got []string
+			switch test := f.Services["app"].Healthcheck.(map[string]interface{})["test"].(type) {
+			case string:
+				got = []string{containerValue(t, test)}
+			case []interface{}:
+				for _, arg := range test {
+					got = append(got, containerValue(t, arg.(string)))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.container) {
+				t.Errorf("healthcheck %q, want %q", got, tt.container)
+			}
+			if got := NormalizeEnvironment(f.Services["app"].Environment); !reflect.DeepEqual(got, []string{"PASSWORD=x$$y"}) {
+				t.Errorf("environment %q, want PASSWORD=x$$y", got)
+			}
+		})
+	}
+}
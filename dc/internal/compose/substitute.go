@@ -0,0 +1,258 @@
+package compose
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Substitute replaces the variables in the values of a compose file that docker compose
+// interpolates, with the values lookup returns. The values are literal: they are escaped, since
+// docker compose interpolates the result again, which would mangle the $ of e.g. a bcrypt hash.
+// Variables lookup doesn't know become empty and are reported in the error. It returns a
+// warning for every resource whose substituted name replaces another one.
+func Substitute(f *File, lookup func(name string) (string, bool)) ([]string, error) {
+	var warnings []string
+	undefinedVars := make(map[string]bool)
+	var interpolationErrors []string
+
+	escaped := func(name string) (string, bool) {
+		v, ok := lookup(name)
+		return EscapeDollars(v), ok
+	}
+	missing := func(name, _ string) string {
+		undefinedVars[name] = true
+		return ""
+	}
+
+	// Helper to replace variables in a single string
+	replaceInString := func(s string) string {
+		if s == "" {
+			return s
+		}
+		result, err := Interpolate(s, escaped, missing)
+		if err != nil {
+			if !slices.Contains(interpolationErrors, err.Error()) {
+				interpolationErrors = append(interpolationErrors, err.Error())
+			}
+			return s
+		}
+		return result
+	}
+
+	// Process services
+	for name, service := range f.Services {
+		// Simple string fields
+		service.Image = replaceInString(service.Image)
+		service.ContainerName = replaceInString(service.ContainerName)
+		service.User = replaceInString(service.User)
+		service.Restart = replaceInString(service.Restart)
+
+		// Volumes
+		for i, vol := range service.Volumes {
+			service.Volumes[i] = replaceInString(vol)
+		}
+
+		// Ports
+		for i, p := range service.Ports {
+			service.Ports[i] = replaceInString(p)
+		}
+
+		// Environment: map or array
+		if service.Environment != nil {
+			if envMap, ok := service.Environment.(map[string]interface{}); ok {
+				for k, v := range envMap {
+					if strValue, ok := v.(string); ok {
+						envMap[k] = replaceInString(strValue)
+					}
+				}
+				service.Environment = envMap
+			} else if envArr, ok := service.Environment.([]interface{}); ok {
+				for i, item := range envArr {
+					if s, ok := item.(string); ok {
+						// If it's KEY=VALUE, only replace VALUE portion
+						if eq := strings.Index(s, "="); eq != -1 {
+							key := s[:eq]
+							val := s[eq+1:]
+							envArr[i] = fmt.Sprintf("%s=%s", key, replaceInString(val))
+						} else {
+							envArr[i] = replaceInString(s)
+						}
+					}
+				}
+				service.Environment = envArr
+			} else if envArr, ok := service.Environment.([]string); ok {
+				for i, s := range envArr {
+					if key, val, ok := strings.Cut(s, "="); ok {
+						envArr[i] = key + "=" + replaceInString(val)
+					} else {
+						envArr[i] = replaceInString(s)
+					}
+				}
+			}
+		}
+
+		// Networks (array form)
+		if service.Networks != nil {
+			if netArr, ok := service.Networks.([]interface{}); ok {
+				for i, item := range netArr {
+					if s, ok := item.(string); ok {
+						netArr[i] = replaceInString(s)
+					}
+				}
+				service.Networks = netArr
+			}
+		}
+
+		// Labels map or array
+		if service.Labels != nil {
+			if labMap, ok := service.Labels.(map[string]interface{}); ok {
+				for k, v := range labMap {
+					if str, ok := v.(string); ok {
+						labMap[k] = replaceInString(str)
+					}
+				}
+				service.Labels = labMap
+			} else if labArr, ok := service.Labels.([]interface{}); ok {
+				for i, item := range labArr {
+					if s, ok := item.(string); ok {
+						labArr[i] = replaceInString(s)
+					}
+				}
+				service.Labels = labArr
+			}
+		}
+
+		// Command
+		if service.Command != nil {
+			if cmdStr, ok := service.Command.(string); ok {
+				service.Command = replaceInString(cmdStr)
+			} else if cmdArr, ok := service.Command.([]interface{}); ok {
+				for i, item := range cmdArr {
+					if s, ok := item.(string); ok {
+						cmdArr[i] = replaceInString(s)
+					}
+				}
+				service.Command = cmdArr
+			}
+		}
+
+		// Configs
+		for i := range service.Configs {
+			service.Configs[i].Source = replaceInString(service.Configs[i].Source)
+			service.Configs[i].Target = replaceInString(service.Configs[i].Target)
+		}
+
+		// Sysctls
+		if service.Sysctls != nil {
+			if sMap, ok := service.Sysctls.(map[string]interface{}); ok {
+				for k, v := range sMap {
+					if str, ok := v.(string); ok {
+						sMap[k] = replaceInString(str)
+					}
+				}
+				service.Sysctls = sMap
+			} else if sArr, ok := service.Sysctls.([]interface{}); ok {
+				for i, item := range sArr {
+					if s, ok := item.(string); ok {
+						sArr[i] = replaceInString(s)
+					}
+				}
+				service.Sysctls = sArr
+			}
+		}
+
+		// Secrets
+		for i, s := range service.Secrets {
+			service.Secrets[i] = replaceInString(s)
+		}
+
+		// Logging options
+		if service.Logging != nil && service.Logging.Options != nil {
+			for k, v := range service.Logging.Options {
+				service.Logging.Options[k] = replaceInString(v)
+			}
+		}
+		f.Services[name] = service
+	}
+
+	// Volumes - update keys and values
+	if f.Volumes != nil {
+		newVolumes := make(map[string]Volume, len(f.Volumes))
+		for name, vol := range f.Volumes {
+			newName := replaceInString(name)
+			vol.Name = replaceInString(vol.Name)
+			vol.Driver = replaceInString(vol.Driver)
+			if vol.DriverOpts != nil {
+				newDriverOpts := make(map[string]string, len(vol.DriverOpts))
+				for k, v := range vol.DriverOpts {
+					newDriverOpts[replaceInString(k)] = replaceInString(v)
+				}
+				vol.DriverOpts = newDriverOpts
+			}
+			if _, exists := newVolumes[newName]; exists {
+				warnings = append(warnings, fmt.Sprintf("volume %s normalized to the duplicate name %s, overwriting the previous entry", name, newName))
+			}
+			if !strings.Contains(newName, "/") {
+				newVolumes[newName] = vol
+			}
+		}
+		f.Volumes = newVolumes
+	}
+
+	// Networks
+	for name, net := range f.Networks {
+		net.Driver = replaceInString(net.Driver)
+		for k, v := range net.DriverOpts {
+			net.DriverOpts[k] = replaceInString(v)
+		}
+		f.Networks[name] = net
+	}
+
+	// Configs - update keys and values
+	if f.Configs != nil {
+		newConfigs := make(map[string]Config, len(f.Configs))
+		for name, cfg := range f.Configs {
+			newName := replaceInString(name)
+			cfg.Content = replaceInString(cfg.Content)
+			cfg.File = replaceInString(cfg.File)
+			if _, exists := newConfigs[newName]; exists {
+				warnings = append(warnings, fmt.Sprintf("config %s normalized to the duplicate name %s, overwriting the previous entry", name, newName))
+			}
+			newConfigs[newName] = cfg
+		}
+		f.Configs = newConfigs
+	}
+
+	// Secrets - update keys and values
+	if f.Secrets != nil {
+		newSecrets := make(map[string]Secret, len(f.Secrets))
+		for name, s := range f.Secrets {
+			newName := replaceInString(name)
+			s.Name = replaceInString(s.Name)
+			s.Environment = replaceInString(s.Environment)
+			s.File = replaceInString(s.File)
+			if _, exists := newSecrets[newName]; exists {
+				warnings = append(warnings, fmt.Sprintf("secret %s normalized to the duplicate name %s, overwriting the previous entry", name, newName))
+			}
+			newSecrets[newName] = s
+		}
+		f.Secrets = newSecrets
+	}
+
+	if len(interpolationErrors) > 0 {
+		sort.Strings(interpolationErrors)
+		return warnings, fmt.Errorf("%s", strings.Join(interpolationErrors, "; "))
+	}
+	if len(undefinedVars) > 0 {
+		varList := make([]string, 0, len(undefinedVars))
+		for varName := range undefinedVars {
+			varList = append(varList, varName)
+		}
+		sort.Strings(varList)
+		return warnings, fmt.Errorf("undefined variables: %s", strings.Join(varList, ", "))
+	}
+
+	return warnings, nil
+}
@@ -0,0 +1,65 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+)
+
+func lookupIn(vars map[string]string) func(name string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	f := &File{
+		Services: map[string]Service{"app": {
+			Image:       "${IMAGE:-nginx}:${TAG}",
+			Ports:       []string{"${PORT}:80"},
+			Environment: map[string]interface{}{"URL": "https://${HOST}"},
+		}},
+		Volumes: map[string]Volume{"${STACK}_data": {}, "web_data": {}},
+	}
+	warnings, err := Substitute(f, lookupIn(map[string]string{"TAG": "1.27", "PORT": "8080", "HOST": "web.example.com", "STACK": "web"}))
+	if err != nil {
+		t.Fatalf("Substitute: %v", err)
+	}
+	service := f.Services["app"]
+	if service.Image != "nginx:1.27" || service.Ports[0] != "8080:80" {
+		t.Errorf("image %q, ports %q", service.Image, service.Ports)
+	}
+	if got := service.Environment.(map[string]interface{})["URL"]; got != "https://web.example.com" {
+		t.Errorf("URL = %v", got)
+	}
+	if _, ok := f.Volumes["web_data"]; !ok || len(f.Volumes) != 1 {
+		t.Errorf("volumes = %v, want only web_data", f.Volumes)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("warnings = %q, want one about the duplicate volume", warnings)
+	}
+}
+
+func TestSubstituteUndefined(t *testing.T) {
+	f := &File{Services: map[string]Service{"app": {
+		Image:       "${IMAGE}",
+		Environment: []interface{}{"A=${B}", "C=${B}"},
+	}}}
+	_, err := Substitute(f, lookupIn(nil))
+	if err == nil || err.Error() != "undefined variables: B, IMAGE" {
+		t.Errorf("err = %v, want undefined variables: B, IMAGE", err)
+	}
+	if got := f.Services["app"].Environment; !reflect.DeepEqual(got, []interface{}{"A=", "C="}) {
+		t.Errorf("environment = %q, want the undefined values empty", got)
+	}
+}
+
+func TestSubstituteInvalidTemplate(t *testing.T) {
+	f := &File{Services: map[string]Service{"app": {Image: "${IMAGE", User: "${IMAGE"}}}
+	if _, err := Substitute(f, lookupIn(nil)); err == nil {
+		t.Error("Substitute accepted an unterminated variable")
+	}
+	if got := f.Services["app"].Image; got != "${IMAGE" {
+		t.Errorf("image = %q, want it kept", got)
+	}
+}
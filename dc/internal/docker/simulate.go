@@ -0,0 +1,286 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"dc/internal/compose"
+)
+
+// Simulate returns the inspect data a service of a compose project would have as a created
+// container, for listing stacks that aren't deployed
+func Simulate(project, serviceName, containerName string, service compose.Service) Inspect {
+	// Build labels map
+	labels := make(map[string]string)
+	labels["com.docker.compose.project"] = project
+	labels["com.docker.compose.service"] = serviceName
+	labels["com.docker.compose.oneoff"] = "False"
+
+	// Add custom labels from the service definition
+	if service.Labels != nil {
+		switch v := service.Labels.(type) {
+		case []interface{}:
+			for _, label := range v {
+				if labelStr, ok := label.(string); ok {
+					if parts := strings.SplitN(labelStr, "=", 2); len(parts) == 2 {
+						labels[parts[0]] = parts[1]
+					}
+				}
+			}
+		case map[string]interface{}:
+			for k, val := range v {
+				labels[k] = fmt.Sprintf("%v", val)
+			}
+		}
+	}
+
+	// Build command array
+	var cmd []string
+	switch v := service.Command.(type) {
+	case string:
+		cmd = []string{v}
+	case []interface{}:
+		for _, c := range v {
+			if s, ok := c.(string); ok {
+				cmd = append(cmd, s)
+			}
+		}
+	}
+
+	// Build environment array
+	var env []string
+	switch v := service.Environment.(type) {
+	case []interface{}:
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				env = append(env, s)
+			}
+		}
+	case map[string]interface{}:
+		for k, val := range v {
+			env = append(env, fmt.Sprintf("%s=%v", k, val))
+		}
+	}
+
+	// Build mounts from volumes
+	var mounts []Mount
+	for _, volume := range service.Volumes {
+		parts := strings.Split(volume, ":")
+		mountType := "volume"
+		source := ""
+		destination := ""
+
+		if len(parts) >= 2 {
+			source = parts[0]
+			destination = parts[1]
+			// If source starts with / or ./, it's a bind mount
+			if strings.HasPrefix(source, "/") || strings.HasPrefix(source, "./") {
+				mountType = "bind"
+			}
+		}
+
+		mounts = append(mounts, Mount{
+			Type:        mountType,
+			Source:      source,
+			Destination: destination,
+			Mode:        "",
+			RW:          true,
+			Propagation: "rprivate",
+		})
+	}
+
+	// Build networks
+	networks := make(map[string]EndpointSettings)
+	switch v := service.Networks.(type) {
+	case []interface{}:
+		for _, net := range v {
+			if netStr, ok := net.(string); ok {
+				networks[netStr] = EndpointSettings{}
+			}
+		}
+	case map[string]interface{}:
+		for net := range v {
+			networks[net] = EndpointSettings{}
+		}
+	}
+
+	// Build exposed ports and port bindings
+	exposedPorts := make(map[string]interface{})
+	portBindings := make(map[string][]PortBinding)
+	for _, portStr := range service.Ports {
+		// Parse port format: "[ip:]host:container", "container" or with "/protocol"
+		spec := compose.ParsePort(portStr)
+		protocol := spec.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		containerPort := spec.ContainerPort + "/" + protocol
+
+		exposedPorts[containerPort] = struct{}{}
+
+		if spec.HostPort != "" {
+			hostIP := spec.HostIP
+			if hostIP == "" {
+				hostIP = "0.0.0.0"
+			}
+			portBindings[containerPort] = append(portBindings[containerPort], PortBinding{
+				HostIP:   hostIP,
+				HostPort: spec.HostPort,
+			})
+		}
+	}
+
+	container := Inspect{
+		ID:      "",
+		Created: "",
+		Path:    "",
+		Args:    []string{},
+		State: ContainerState{
+			Status:     "created",
+			Running:    false,
+			Paused:     false,
+			Restarting: false,
+			OOMKilled:  false,
+			Dead:       false,
+			Pid:        0,
+			ExitCode:   0,
+			Error:      "",
+			StartedAt:  "",
+			FinishedAt: "",
+		},
+		Image:           service.Image,
+		ResolvConfPath:  "",
+		HostnamePath:    "",
+		HostsPath:       "",
+		LogPath:         "",
+		Name:            "/" + containerName,
+		RestartCount:    0,
+		Driver:          "overlay2",
+		Platform:        "linux",
+		MountLabel:      "",
+		ProcessLabel:    "",
+		AppArmorProfile: "",
+		ExecIDs:         nil,
+		HostConfig: HostConfig{
+			Binds:           service.Volumes,
+			ContainerIDFile: "",
+			LogConfig: LogConfig{
+				Type:   "json-file",
+				Config: map[string]string{},
+			},
+			NetworkMode:  "default",
+			PortBindings: portBindings,
+			RestartPolicy: RestartPolicy{
+				Name:              "no",
+				MaximumRetryCount: 0,
+			},
+			AutoRemove:           false,
+			VolumeDriver:         "",
+			VolumesFrom:          nil,
+			CapabilityAdd:        nil,
+			CapabilityDrop:       nil,
+			DNS:                  []string{},
+			DNSOptions:           []string{},
+			DNSSearch:            []string{},
+			ExtraHosts:           nil,
+			GroupAdd:             nil,
+			IpcMode:              "private",
+			Cgroup:               "",
+			Links:                nil,
+			OomScoreAdj:          0,
+			PidMode:              "",
+			Privileged:           false,
+			PublishAllPorts:      false,
+			ReadonlyRootfs:       false,
+			SecurityOpt:          nil,
+			UTSMode:              "",
+			UsernsMode:           "",
+			ShmSize:              67108864,
+			Runtime:              "runc",
+			ConsoleSize:          []int{0, 0},
+			Isolation:            "",
+			CPUShares:            0,
+			Memory:               0,
+			NanoCPUs:             0,
+			CgroupParent:         "",
+			BlkioWeight:          0,
+			BlkioWeightDevice:    nil,
+			BlkioDeviceReadBps:   nil,
+			BlkioDeviceWriteBps:  nil,
+			BlkioDeviceReadIOps:  nil,
+			BlkioDeviceWriteIOps: nil,
+			CPUPeriod:            0,
+			CPUQuota:             0,
+			CPURealtimePeriod:    0,
+			CPURealtimeRuntime:   0,
+			CpusetCpus:           "",
+			CpusetMems:           "",
+			Devices:              nil,
+			DeviceCgroupRules:    nil,
+			DiskQuota:            0,
+			KernelMemory:         0,
+			MemoryReservation:    0,
+			MemorySwap:           0,
+			MemorySwappiness:     nil,
+			OomKillDisable:       nil,
+			PidsLimit:            nil,
+			Ulimits:              nil,
+			CPUCount:             0,
+			CPUPercent:           0,
+			IOMaximumIOps:        0,
+			IOMaximumBandwidth:   0,
+		},
+		GraphDriver: GraphDriver{
+			Name: "overlay2",
+			Data: map[string]string{
+				"lowerdir":  "",
+				"mergeddir": "",
+				"upperdir":  "",
+				"workdir":   "",
+			},
+		},
+		Mounts: mounts,
+		Config: ContainerConfig{
+			Hostname:     containerName,
+			Domainname:   "",
+			User:         "",
+			AttachStdin:  false,
+			AttachStdout: false,
+			AttachStderr: false,
+			ExposedPorts: exposedPorts,
+			Tty:          false,
+			OpenStdin:    false,
+			StdinOnce:    false,
+			Env:          env,
+			Cmd:          cmd,
+			Image:        service.Image,
+			Volumes:      nil,
+			WorkingDir:   "",
+			Entrypoint:   nil,
+			OnBuild:      nil,
+			Labels:       labels,
+		},
+		NetworkSettings: NetworkSettings{
+			Bridge:                 "",
+			SandboxID:              "",
+			HairpinMode:            false,
+			LinkLocalIPv6Address:   "",
+			LinkLocalIPv6PrefixLen: 0,
+			Ports:                  portBindings,
+			SandboxKey:             "",
+			SecondaryIPAddresses:   nil,
+			SecondaryIPv6Addresses: nil,
+			EndpointID:             "",
+			Gateway:                "",
+			GlobalIPv6Address:      "",
+			GlobalIPv6PrefixLen:    0,
+			IPAddress:              "",
+			IPPrefixLen:            0,
+			IPv6Gateway:            "",
+			MacAddress:             "",
+			Networks:               networks,
+		},
+	}
+
+	return container
+}
@@ -0,0 +1,50 @@
+package docker
+
+import (
+	"reflect"
+	"testing"
+
+	"dc/internal/compose"
+)
+
+func TestSimulate(t *testing.T) {
+	service := compose.Service{
+		Image:       "nginx:1.27",
+		Labels:      []interface{}{"traefik.enable=true"},
+		Command:     []interface{}{"nginx", "-g", "daemon off;"},
+		Environment: []interface{}{"TZ=UTC"},
+		Volumes:     []string{"./html:/usr/share/nginx/html", "cache:/var/cache/nginx"},
+		Networks:    []interface{}{"homelab"},
+		Ports:       []string{"127.0.0.1:8080:80", "443/udp"},
+	}
+	c := Simulate("web", "nginx", "web-nginx", service)
+
+	if c.Name != "/web-nginx" || c.State.Status != "created" || c.Image != "nginx:1.27" {
+		t.Errorf("name %q, status %q, image %q", c.Name, c.State.Status, c.Image)
+	}
+	labels := map[string]string{
+		"com.docker.compose.project": "web",
+		"com.docker.compose.service": "nginx",
+		"com.docker.compose.oneoff":  "False",
+		"traefik.enable":             "true",
+	}
+	if !reflect.DeepEqual(c.Config.Labels, labels) {
+		t.Errorf("labels = %v, want %v", c.Config.Labels, labels)
+	}
+	if !reflect.DeepEqual(c.Config.Cmd, []string{"nginx", "-g", "daemon off;"}) || !reflect.DeepEqual(c.Config.Env, []string{"TZ=UTC"}) {
+		t.Errorf("cmd %q, env %q", c.Config.Cmd, c.Config.Env)
+	}
+	if len(c.Mounts) != 2 || c.Mounts[0].Type != "bind" || c.Mounts[1].Type != "volume" || c.Mounts[1].Destination != "/var/cache/nginx" {
+		t.Errorf("mounts = %+v", c.Mounts)
+	}
+	if _, ok := c.NetworkSettings.Networks["homelab"]; !ok {
+		t.Errorf("networks = %v, want homelab", c.NetworkSettings.Networks)
+	}
+	bindings := map[string][]PortBinding{"80/tcp": {{HostIP: "127.0.0.1", HostPort: "8080"}}}
+	if !reflect.DeepEqual(c.HostConfig.PortBindings, bindings) {
+		t.Errorf("port bindings = %v, want %v", c.HostConfig.PortBindings, bindings)
+	}
+	if _, ok := c.Config.ExposedPorts["443/udp"]; !ok {
+		t.Errorf("exposed ports = %v, want 443/udp", c.Config.ExposedPorts)
+	}
+}
@@ -0,0 +1,161 @@
+// Package secrets reads and writes the env files holding the values of stacks' variables, and
+// tells which variables hold secrets.
+package secrets
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadEnvFile reads a single .env file and returns the key-value pairs
+func ReadEnvFile(filePath string) (map[string]string, error) {
+	envVars := make(map[string]string)
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// File doesn't exist, return empty map
+			return envVars, nil
+		}
+		return nil, fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Parse KEY=VALUE
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 {
+			key := strings.TrimSpace(parts[0])
+			value := strings.TrimSpace(parts[1])
+			envVars[key] = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+
+	return envVars, nil
+}
+
+// IsSensitiveKey checks if an environment variable key is considered sensitive
+// based on common password/secret keywords. Excludes variables with "_FILE" suffix and
+// values that reference /run/secrets (Docker secrets path).
+func IsSensitiveKey(key, value string) bool {
+	upperKey := strings.ToUpper(key)
+
+	// Exclude variables with "_FILE" suffix as they are file references, not actual passwords
+	if strings.Contains(upperKey, "_FILE") {
+		return false
+	}
+
+	// Do not treat as sensitive if the value starts with /run/secrets (Docker secrets path)
+	if strings.HasPrefix(value, "/run/secrets") {
+		return false
+	}
+
+	// Check for sensitive keywords
+	sensitiveKeywords := []string{"PASSWD", "PASSWORD", "SECRET", "KEY", "TOKEN", "API_KEY", "APIKEY", "PRIVATE"}
+	for _, keyword := range sensitiveKeywords {
+		if strings.Contains(upperKey, keyword) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NormalizeKey normalizes an environment key to uppercase with underscores
+// Multiple consecutive non-alphanumeric characters are replaced with a single underscore
+func NormalizeKey(key string) string {
+	// Convert to uppercase
+	normalized := strings.ToUpper(key)
+
+	// Replace non-alphanumeric characters with underscores
+	var result strings.Builder
+	lastWasUnderscore := false
+
+	for _, ch := range normalized {
+		if (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') {
+			result.WriteRune(ch)
+			lastWasUnderscore = false
+		} else {
+			// Replace any non-alphanumeric character with underscore
+			if !lastWasUnderscore {
+				result.WriteRune('_')
+				lastWasUnderscore = true
+			}
+		}
+	}
+
+	// Trim leading and trailing underscores
+	return strings.Trim(result.String(), "_")
+}
+
+// SetEnvFileKey sets (or with a nil value removes) a key of an env file, keeping all other lines,
+// and reports whether the key was there before. The file is created if missing, replaced
+// atomically and stays readable by its owner only.
+func SetEnvFileKey(path, key string, value *string) (bool, error) {
+	var lines []string
+	found := false
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if k, _, ok := strings.Cut(strings.TrimSpace(line), "="); ok && strings.TrimSpace(k) == key {
+				found = true
+				if value == nil {
+					continue
+				}
+				line = key + "=" + *value
+			}
+			lines = append(lines, line)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	if !found && value == nil {
+		return false, nil
+	}
+	if !found {
+		lines = append(lines, key+"="+*value)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return found, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".prod.env-*")
+	if err != nil {
+		return found, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return found, err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return found, err
+	}
+	if err := tmp.Close(); err != nil {
+		return found, err
+	}
+	return found, os.Rename(tmp.Name(), path)
+}
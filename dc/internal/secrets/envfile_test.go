@@ -0,0 +1,66 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIsSensitiveKey(t *testing.T) {
+	tests := []struct {
+		key, value string
+		want       bool
+	}{
+		{"DB_PASSWORD", "hunter2", true},
+		{"api-key", "abc", true},
+		{"DB_PASSWORD_FILE", "/run/secrets/db", false},
+		{"DB_PASSWORD", "/run/secrets/db", false},
+		{"TZ", "UTC", false},
+	}
+	for _, tt := range tests {
+		if got := IsSensitiveKey(tt.key, tt.value); got != tt.want {
+			t.Errorf("IsSensitiveKey(%q, %q) = %v, want %v", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeKey(t *testing.T) {
+	for in, want := range map[string]string{"db.password": "DB_PASSWORD", "--api--key--": "API_KEY", "Token2": "TOKEN2"} {
+		if got := NormalizeKey(in); got != want {
+			t.Errorf("NormalizeKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSetEnvFileKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prod.env")
+	if err := os.WriteFile(path, []byte("# stack values\nA=1\nB=2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	value := "$apr1$x$y"
+	if existed, err := SetEnvFileKey(path, "A", &value); err != nil || !existed {
+		t.Fatalf("SetEnvFileKey(A) = %v, %v", existed, err)
+	}
+	if existed, err := SetEnvFileKey(path, "B", nil); err != nil || !existed {
+		t.Fatalf("SetEnvFileKey(B, nil) = %v, %v", existed, err)
+	}
+	if existed, err := SetEnvFileKey(path, "C", &value); err != nil || existed {
+		t.Fatalf("SetEnvFileKey(C) = %v, %v", existed, err)
+	}
+
+	vars, err := ReadEnvFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"A": value, "C": value}; !reflect.DeepEqual(vars, want) {
+		t.Errorf("env file = %v, want %v", vars, want)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("env file has mode %o, want 0600", perm)
+	}
+}
@@ -0,0 +1,9 @@
+package secrets
+
+// Store keeps the values of secrets by key, e.g. the pw password manager
+type Store interface {
+	// Insert stores a value; a key that already exists keeps its value
+	Insert(key, value string) error
+	// Generate stores a new random value unless the key already exists
+	Generate(key string) error
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+)
+
+// homelabNetwork is the network every service is attached to
+const homelabNetwork = "homelab"
+
+// ipamPath returns the file recording the static addresses of the services on the homelab
+// network (config key ipam_file)
+func ipamPath() string {
+	return getConfig("ipam_file", filepath.Join(StacksDir, ".ipam.json"))
+}
+
+// readIPAM reads the static addresses by <stack>/<service>
+func readIPAM() (map[string]string, error) {
+	addresses := make(map[string]string)
+	data, err := os.ReadFile(ipamPath())
+	if os.IsNotExist(err) {
+		return addresses, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &addresses); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ipamPath(), err)
+	}
+	return addresses, nil
+}
+
+// writeIPAM replaces the file of static addresses
+func writeIPAM(addresses map[string]string) error {
+	data, err := json.MarshalIndent(addresses, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(ipamPath(), data)
+}
+
+// inspectHomelabIPAM returns the IPv4 subnet and the range of dynamic addresses (--ip-range) of
+// the homelab network; exists is false if there is no such network
+func inspectHomelabIPAM() (subnet, ipRange string, exists bool) {
+	output, err := engineCommand("network", "inspect", homelabNetwork, "--format", "{{json .IPAM.Config}}").Output()
+	if err != nil {
+		return "", "", false
+	}
+	var configs []struct {
+		Subnet  string
+		IPRange string
+	}
+	json.Unmarshal(output, &configs)
+	for _, config := range configs {
+		if ip, _, err := net.ParseCIDR(config.Subnet); err == nil && ip.To4() != nil {
+			return config.Subnet, config.IPRange, true
+		}
+	}
+	return "", "", true
+}
+
+// subnetHalf returns the lower or upper half of an IPv4 subnet
+func subnetHalf(subnet *net.IPNet, upper bool) *net.IPNet {
+	ones, bits := subnet.Mask.Size()
+	half := &net.IPNet{IP: make(net.IP, 4), Mask: net.CIDRMask(ones+1, bits)}
+	base := binary.BigEndian.Uint32(subnet.IP.To4())
+	if upper {
+		base |= 1 << (bits - ones - 1)
+	}
+	binary.BigEndian.PutUint32(half.IP, base)
+	return half
+}
+
+// cidrsOverlap reports whether two CIDR ranges share an address
+func cidrsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// parseHomelabSubnet parses an IPv4 subnet large enough to be split for static addresses
+func parseHomelabSubnet(subnet string) (*net.IPNet, error) {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil || ipNet.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid homelab_subnet %q, expected an IPv4 CIDR", subnet)
+	}
+	if ones, bits := ipNet.Mask.Size(); bits-ones < 3 {
+		return nil, fmt.Errorf("the %s subnet %s is too small for static addresses", homelabNetwork, subnet)
+	}
+	return ipNet, nil
+}
+
+// staticIPPool returns the IPv4 range static addresses are assigned from: static_ip_range if
+// set, else the upper half of the homelab subnet. The engine only accepts static addresses on
+// networks with a subnet of their own and hands out dynamic addresses from all of it unless
+// --ip-range restricts them, so a missing homelab network is created with homelab_subnet and
+// the lower half as --ip-range, and an existing one must have been created like that.
+func staticIPPool() (*net.IPNet, error) {
+	subnet, ipRange, exists := inspectHomelabIPAM()
+	if !exists {
+		subnet = getConfig("homelab_subnet", "")
+		if subnet == "" {
+			return nil, fmt.Errorf("static_ips needs homelab_subnet (e.g. 172.30.0.0/16) to create the %s network with a subnet of its own", homelabNetwork)
+		}
+		ipNet, err := parseHomelabSubnet(subnet)
+		if err != nil {
+			return nil, err
+		}
+		ipRange = subnetHalf(ipNet, false).String()
+		if !DryRun {
+			resourcesLog.Info("Creating network", "name", homelabNetwork, "subnet", subnet, "ip_range", ipRange)
+			output, err := engineCommand("network", "create", "--driver", "bridge", "--subnet", subnet, "--ip-range", ipRange,
+				"--label", managedLabel+"=true", homelabNetwork).CombinedOutput()
+			if err != nil {
+				return nil, fmt.Errorf("failed to create the %s network: %w: %s", homelabNetwork, err, strings.TrimSpace(string(output)))
+			}
+		}
+	}
+	recreate := fmt.Sprintf("recreate it with docker network create --subnet <subnet> --ip-range <lower half of the subnet> %s", homelabNetwork)
+	if subnet == "" || ipRange == "" {
+		return nil, fmt.Errorf("the %s network has no IPv4 subnet with an --ip-range for the dynamic addresses, which static addresses need; %s", homelabNetwork, recreate)
+	}
+	if configured := getConfig("homelab_subnet", ""); configured != "" && configured != subnet {
+		return nil, fmt.Errorf("the %s network has the subnet %s, not homelab_subnet %s; %s", homelabNetwork, subnet, configured, recreate)
+	}
+	ipNet, err := parseHomelabSubnet(subnet)
+	if err != nil {
+		return nil, err
+	}
+	_, dynamic, err := net.ParseCIDR(ipRange)
+	if err != nil {
+		return nil, fmt.Errorf("the %s network has an invalid --ip-range %q", homelabNetwork, ipRange)
+	}
+
+	pool := subnetHalf(ipNet, true)
+	if configured := getConfig("static_ip_range", ""); configured != "" {
+		_, pool, err = net.ParseCIDR(configured)
+		if err != nil || pool.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid static_ip_range %q, expected an IPv4 CIDR", configured)
+		}
+		subnetOnes, _ := ipNet.Mask.Size()
+		poolOnes, _ := pool.Mask.Size()
+		if !ipNet.Contains(pool.IP) || poolOnes < subnetOnes {
+			return nil, fmt.Errorf("static_ip_range %s is not part of the %s subnet %s", pool, homelabNetwork, subnet)
+		}
+	}
+	if cidrsOverlap(pool, dynamic) {
+		return nil, fmt.Errorf("the static addresses %s overlap the dynamic addresses %s of the %s network; %s", pool, dynamic, homelabNetwork, recreate)
+	}
+	return pool, nil
+}
+
+// poolAddress returns the i-th usable address of a pool, which excludes its first and last
+// address (the network and broadcast addresses of a whole subnet)
+func poolAddress(pool *net.IPNet, i uint32) string {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(pool.IP.To4())+1+i)
+	return ip.String()
+}
+
+// homelabAddress returns the ipv4_address a service sets itself on the homelab network
+func homelabAddress(service compose.Service) string {
+	if networks, ok := service.Networks.(map[string]interface{}); ok {
+		if config, ok := networks[homelabNetwork].(map[string]interface{}); ok {
+			address, _ := config["ipv4_address"].(string)
+			return address
+		}
+	}
+	return ""
+}
+
+// setHomelabAddress sets the ipv4_address of a service on the homelab network, turning its
+// list of networks into the map form that carries addresses
+func setHomelabAddress(service *compose.Service, address string) {
+	networks := make(map[string]interface{})
+	switch v := service.Networks.(type) {
+	case string:
+		networks[v] = map[string]interface{}{}
+	case []interface{}:
+		for _, item := range v {
+			if name, ok := item.(string); ok {
+				networks[name] = map[string]interface{}{}
+			}
+		}
+	case []string:
+		for _, name := range v {
+			networks[name] = map[string]interface{}{}
+		}
+	case map[string]interface{}:
+		networks = v
+	}
+	config, ok := networks[homelabNetwork].(map[string]interface{})
+	if !ok {
+		config = map[string]interface{}{}
+	}
+	config["ipv4_address"] = address
+	networks[homelabNetwork] = config
+	service.Networks = networks
+}
+
+// assignStaticIPs gives every service of a stack a static address on the homelab network if
+// static_ips is enabled. The address derives from the stack and service name, so it stays the
+// same across hosts as long as it doesn't collide, and is recorded so that it never changes;
+// addresses set in the stack file are kept and reserved. Addresses of services the stack no
+// longer has are released. Dry runs show the addresses but record nothing.
+func assignStaticIPs(composeFile *compose.File, stackName string) error {
+	if composeFile == nil || len(composeFile.Services) == 0 || !getConfigBool("static_ips", false) {
+		return nil
+	}
+	pool, err := staticIPPool()
+	if err != nil {
+		return err
+	}
+	ones, bits := pool.Mask.Size()
+	size := uint32(1)<<(bits-ones) - 2
+
+	unlock, err := lockFile(ipamPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	addresses, err := readIPAM()
+	if err != nil {
+		return err
+	}
+	owners := make(map[string]string)
+	for key, address := range addresses {
+		owners[address] = key
+	}
+
+	var names []string
+	for name := range composeFile.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	prefix := stackName + "/"
+	changed := false
+	for _, name := range names {
+		key := prefix + name
+		service := composeFile.Services[name]
+		if service.NetworkMode != "" {
+			continue
+		}
+		address := homelabAddress(service)
+		if address == "" {
+			address = addresses[key]
+		}
+		if address == "" {
+			h := fnv.New32a()
+			h.Write([]byte(key))
+			start := h.Sum32() % size
+			for i := uint32(0); i < size; i++ {
+				candidate := poolAddress(pool, (start+i)%size)
+				if owner, taken := owners[candidate]; !taken || owner == key {
+					address = candidate
+					break
+				}
+			}
+			if address == "" {
+				return fmt.Errorf("no free address left in %s", pool)
+			}
+			enrichLog.Info("Assigned static address", "stack", stackName, "service", name, "address", address)
+		}
+		if owner, taken := owners[address]; taken && owner != key {
+			return fmt.Errorf("service %s: address %s is already assigned to %s", name, address, owner)
+		}
+		if addresses[key] != address {
+			delete(owners, addresses[key])
+			addresses[key] = address
+			owners[address] = key
+			changed = true
+		}
+		setHomelabAddress(&service, address)
+		composeFile.Services[name] = service
+	}
+
+	for key, address := range addresses {
+		if _, ok := composeFile.Services[strings.TrimPrefix(key, prefix)]; strings.HasPrefix(key, prefix) && !ok {
+			enrichLog.Info("Released static address", "stack", stackName, "service", strings.TrimPrefix(key, prefix), "address", address)
+			delete(addresses, key)
+			changed = true
+		}
+	}
+	if DryRun || !changed {
+		return nil
+	}
+	return writeIPAM(addresses)
+}
+
+// releaseStaticIPs releases the static addresses of the services of a removed stack
+func releaseStaticIPs(stackName string) error {
+	prefix := stackName + "/"
+	owned := func(addresses map[string]string) bool {
+		for key := range addresses {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	if addresses, err := readIPAM(); err != nil || !owned(addresses) {
+		return err
+	}
+
+	unlock, err := lockFile(ipamPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	addresses, err := readIPAM()
+	if err != nil || !owned(addresses) {
+		return err
+	}
+	for key, address := range addresses {
+		if strings.HasPrefix(key, prefix) {
+			enrichLog.Info("Released static address", "stack", stackName, "service", strings.TrimPrefix(key, prefix), "address", address)
+			delete(addresses, key)
+		}
+	}
+	if DryRun {
+		return nil
+	}
+	return writeIPAM(addresses)
+}
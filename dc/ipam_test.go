@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useFakeEngine puts a docker script on PATH that answers network inspect with ipamJSON (or
+// fails if it is empty) and records the other commands in the returned file
+func useFakeEngine(t *testing.T, ipamJSON string) string {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "commands")
+	script := "#!/bin/sh\nif [ \"$1 $2\" = \"network inspect\" ]; then\n"
+	if ipamJSON == "" {
+		script += "  exit 1\n"
+	} else {
+		script += "  echo '" + ipamJSON + "'\n  exit 0\n"
+	}
+	script += "fi\necho \"$@\" >> " + log + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	engineOnce.Do(func() {})
+	oldEngine := engineName
+	engineName = EngineDocker
+	t.Cleanup(func() { engineName = oldEngine })
+	return log
+}
+
+func TestStaticIPPoolCreatesTheHomelabNetwork(t *testing.T) {
+	log := useFakeEngine(t, "")
+	t.Setenv("HOMELAB_SUBNET", "172.30.0.0/16")
+	t.Setenv("STATIC_IP_RANGE", "")
+	pool, err := staticIPPool()
+	if err != nil {
+		t.Fatalf("staticIPPool: %v", err)
+	}
+	if pool.String() != "172.30.128.0/17" {
+		t.Errorf("pool = %s, want the upper half 172.30.128.0/17", pool)
+	}
+	commands, _ := os.ReadFile(log)
+	if !strings.Contains(string(commands), "network create --driver bridge --subnet 172.30.0.0/16 --ip-range 172.30.0.0/17") {
+		t.Errorf("network not created with the subnet and the lower half as ip-range: %q", commands)
+	}
+
+	t.Setenv("HOMELAB_SUBNET", "")
+	if _, err := staticIPPool(); err == nil {
+		t.Error("staticIPPool without a homelab network and homelab_subnet did not fail")
+	}
+}
+
+func TestStaticIPPoolVerifiesTheHomelabNetwork(t *testing.T) {
+	t.Setenv("HOMELAB_SUBNET", "")
+	tests := []struct {
+		name      string
+		ipam      string
+		staticIPs string
+		want      string // "" for an error
+	}{
+		{"configured", `[{"Subnet":"10.5.0.0/24","IPRange":"10.5.0.0/25"}]`, "", "10.5.0.128/25"},
+		{"static range", `[{"Subnet":"10.5.0.0/24","IPRange":"10.5.0.0/25"}]`, "10.5.0.192/26", "10.5.0.192/26"},
+		{"no ip range", `[{"Subnet":"10.5.0.0/24"}]`, "", ""},
+		{"no subnet", `[]`, "", ""},
+		{"static range overlaps", `[{"Subnet":"10.5.0.0/24","IPRange":"10.5.0.0/25"}]`, "10.5.0.64/26", ""},
+		{"static range outside", `[{"Subnet":"10.5.0.0/24","IPRange":"10.5.0.0/25"}]`, "10.6.0.0/24", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeEngine(t, tt.ipam)
+			t.Setenv("STATIC_IP_RANGE", tt.staticIPs)
+			pool, err := staticIPPool()
+			switch {
+			case tt.want == "" && err == nil:
+				t.Errorf("staticIPPool = %s, want an error", pool)
+			case tt.want != "" && err != nil:
+				t.Errorf("staticIPPool: %v", err)
+			case tt.want != "" && pool.String() != tt.want:
+				t.Errorf("pool = %s, want %s", pool, tt.want)
+			}
+		})
+	}
+}
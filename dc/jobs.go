@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"dc/client"
+)
+
+// jobReconnectDelay is the pause before attaching to a job again after the connection dropped
+const jobReconnectDelay = 2 * time.Second
+
+// dcapiClient returns a client of dcapi (config keys dcapi_url and dcapi_token). Jobs live in
+// dcapi, which runs the actions requested over its API in the background.
+func dcapiClient() *client.Client {
+	return client.New(getConfig("dcapi_url", "http://localhost:8882"), getConfig("dcapi_token", ""))
+}
+
+// HandleJobs prints the jobs of dcapi as JSON (`dc job ls [--stack=<name>]`) or a single job
+// with its buffered output (`dc job status <id>`)
+func HandleJobs(id string) error {
+	var result interface{}
+	var err error
+	if id != "" {
+		result, err = dcapiClient().GetJob(context.Background(), id)
+	} else {
+		result, err = dcapiClient().ListJobs(context.Background(), getFlag("stack", ""))
+	}
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(result)
+}
+
+// HandleCancelJob cancels a running job
+func HandleCancelJob(id string) error {
+	return dcapiClient().CancelJob(context.Background(), id)
+}
+
+// HandleAttachJob streams the output of a job from the start and returns once it is done. A
+// dropped connection is attached again from the last line received, so following a long
+// update doesn't depend on a single connection. dc fails if the job failed. Ctrl-C cancels
+// the job, unless --detach=true, in which case it keeps running.
+func HandleAttachJob(id string) error {
+	if !getFlagBool("detach", false) {
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(interrupts)
+		go func() {
+			<-interrupts
+			writeFrame(FrameError, msg("cancelling"))
+			if err := HandleCancelJob(id); err != nil {
+				jobsLog.Error("Failed to cancel job", "id", id, "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	c := dcapiClient()
+	received := 0
+	for {
+		result, err := c.StreamJob(context.Background(), id, received, func(frame client.Frame) {
+			emitFrame(OutputFrame(frame))
+		})
+		if err != nil {
+			return err
+		}
+		received += result.Received
+		if result.Done {
+			if result.ExitCode != 0 {
+				return fmt.Errorf("job %s failed with exit code %d", id, result.ExitCode)
+			}
+			return nil
+		}
+		jobsLog.Info("Connection to the job lost, attaching again", "id", id)
+		time.Sleep(jobReconnectDelay)
+	}
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// k8sNameRe matches what a Kubernetes object name can't contain
+var k8sNameRe = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// k8sName joins parts into a valid object name (lowercase DNS label of at most 63 characters)
+func k8sName(parts ...string) string {
+	name := k8sNameRe.ReplaceAllString(strings.ToLower(strings.Join(parts, "-")), "-")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.Trim(name, "-")
+}
+
+// k8sQuantity converts a compose byte size (256m, 1g, 1gb, 512k or bytes) to a Kubernetes quantity
+func k8sQuantity(size string) string {
+	size = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(size)), "b")
+	for suffix, unit := range map[string]string{"k": "Ki", "m": "Mi", "g": "Gi", "t": "Ti"} {
+		if number, ok := strings.CutSuffix(size, suffix); ok {
+			return number + unit
+		}
+	}
+	return size
+}
+
+// k8sExport builds the manifests of a stack
+type k8sExport struct {
+	stack     string
+	values    map[string]string // stack variables, nil unless secret values are exported
+	manifests []map[string]interface{}
+	warnings  []string
+	claims    map[string]bool
+}
+
+func (e *k8sExport) warn(format string, args ...interface{}) {
+	e.warnings = append(e.warnings, fmt.Sprintf(format, args...))
+}
+
+func (e *k8sExport) add(kind, apiVersion, name string, fields map[string]interface{}) {
+	manifest := map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":   name,
+			"labels": map[string]interface{}{"app.kubernetes.io/part-of": e.stack},
+		},
+	}
+	for key, value := range fields {
+		manifest[key] = value
+	}
+	e.manifests = append(e.manifests, manifest)
+}
+
+// secretData returns the stringData of a Secret: the values of the variables, or empty
+// placeholders unless secret values are exported
+func (e *k8sExport) secretData(keys map[string]string) map[string]interface{} {
+	data := make(map[string]interface{}, len(keys))
+	for key, variable := range keys {
+		value := ""
+		if e.values != nil {
+			value = e.values[variable]
+		}
+		data[key] = value
+	}
+	return data
+}
+
+// env converts the environment of a service. Literal values are kept, values that are a single
+// variable come from the env Secret of the stack and composed ones use $(VAR) expansion of the
+// variables they reference.
+func (e *k8sExport) env(service compose.Service, envSecret map[string]string) []interface{} {
+	var env []interface{}
+	defined := make(map[string]bool)
+	fromSecret := func(name, variable string) {
+		if defined[name] {
+			return
+		}
+		defined[name] = true
+		envSecret[variable] = variable
+		env = append(env, map[string]interface{}{
+			"name": name,
+			"valueFrom": map[string]interface{}{
+				"secretKeyRef": map[string]interface{}{"name": k8sName(e.stack, "env"), "key": variable},
+			},
+		})
+	}
+
+	var literal []interface{}
+	for _, entry := range compose.NormalizeEnvironment(service.Environment) {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			// passed through from the environment of dc
+			value = "${" + key + "}"
+		}
+		names := compose.Variables(value)
+		switch {
+		case len(names) == 0:
+			literal = append(literal, map[string]interface{}{"name": key, "value": compose.UnescapeDollars(value)})
+		case len(names) == 1 && (value == "${"+names[0]+"}" || value == "$"+names[0]):
+			fromSecret(key, names[0])
+		default:
+			for _, name := range names {
+				fromSecret(name, name)
+			}
+			expanded, err := compose.Interpolate(value, func(name string) (string, bool) { return "$(" + name + ")", true }, func(_, original string) string { return original })
+			if err != nil {
+				e.warn("environment %s: %v", key, err)
+				continue
+			}
+			literal = append(literal, map[string]interface{}{"name": key, "value": compose.UnescapeDollars(expanded)})
+		}
+	}
+	return append(env, literal...)
+}
+
+// volumes converts the volumes of a service to mounts: named volumes become claims, host paths
+// hostPath volumes and anonymous volumes emptyDirs
+func (e *k8sExport) volumes(name string, service compose.Service, composeFile *compose.File) (mounts, volumes []interface{}) {
+	for i, spec := range service.Volumes {
+		parts := strings.Split(spec, ":")
+		volumeName := fmt.Sprintf("volume-%d", i)
+		mount := map[string]interface{}{"name": volumeName, "mountPath": parts[len(parts)-1]}
+		var source map[string]interface{}
+		switch {
+		case len(parts) == 1:
+			source = map[string]interface{}{"emptyDir": map[string]interface{}{}}
+		case strings.HasPrefix(parts[0], "/") || strings.HasPrefix(parts[0], ".") || strings.HasPrefix(parts[0], "~"):
+			mount["mountPath"] = parts[1]
+			source = map[string]interface{}{"hostPath": map[string]interface{}{"path": parts[0]}}
+			e.warn("service %s: bind mount %s became a hostPath volume, which pins the pod to a node with that path", name, parts[0])
+		default:
+			mount["mountPath"] = parts[1]
+			claim := k8sName(e.stack, parts[0])
+			if declared, ok := composeFile.Volumes[parts[0]]; ok && declared.External {
+				claim = k8sName(parts[0])
+			}
+			source = map[string]interface{}{"persistentVolumeClaim": map[string]interface{}{"claimName": claim}}
+			if !e.claims[claim] {
+				e.claims[claim] = true
+				e.add("PersistentVolumeClaim", "v1", claim, map[string]interface{}{
+					"spec": map[string]interface{}{
+						"accessModes": []interface{}{"ReadWriteOnce"},
+						"resources": map[string]interface{}{
+							"requests": map[string]interface{}{"storage": getConfig("k8s_volume_size", "1Gi")},
+						},
+					},
+				})
+			}
+		}
+		if len(parts) > 2 && strings.Contains(parts[2], "ro") {
+			mount["readOnly"] = true
+		}
+		source["name"] = volumeName
+		mounts = append(mounts, mount)
+		volumes = append(volumes, source)
+	}
+
+	for _, secret := range service.Secrets {
+		volumeName := k8sName("secret", secret)
+		mounts = append(mounts, map[string]interface{}{"name": volumeName, "mountPath": "/run/secrets/" + secret, "subPath": secret, "readOnly": true})
+		volumes = append(volumes, map[string]interface{}{"name": volumeName, "secret": map[string]interface{}{"secretName": k8sName(e.stack, "secret", secret)}})
+	}
+	for _, config := range service.Configs {
+		target := config.Target
+		if target == "" {
+			target = "/" + config.Source
+		}
+		volumeName := k8sName("config", config.Source)
+		mounts = append(mounts, map[string]interface{}{"name": volumeName, "mountPath": target, "subPath": config.Source, "readOnly": true})
+		volumes = append(volumes, map[string]interface{}{"name": volumeName, "configMap": map[string]interface{}{"name": k8sName(e.stack, "config", config.Source)}})
+	}
+	return mounts, volumes
+}
+
+// probe converts a compose healthcheck to an exec probe, nil if there is none
+func probe(healthcheck interface{}) map[string]interface{} {
+	check, ok := healthcheck.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var command []interface{}
+	switch test := check["test"].(type) {
+	case string:
+		command = []interface{}{"sh", "-c", compose.UnescapeDollars(test)}
+	case []interface{}:
+		if len(test) < 2 {
+			return nil
+		}
+		switch fmt.Sprint(test[0]) {
+		case "CMD":
+			command = test[1:]
+		case "CMD-SHELL":
+			command = []interface{}{"sh", "-c", compose.UnescapeDollars(fmt.Sprint(test[1]))}
+		default:
+			return nil
+		}
+	default:
+		return nil
+	}
+	p := map[string]interface{}{"exec": map[string]interface{}{"command": command}}
+	if interval, err := time.ParseDuration(fmt.Sprint(check["interval"])); err == nil && interval >= time.Second {
+		p["periodSeconds"] = int(interval.Seconds())
+	}
+	return p
+}
+
+// service converts a compose service to a Deployment and, if it publishes ports, a Service
+func (e *k8sExport) service(name string, service compose.Service, composeFile *compose.File, envSecret map[string]string) {
+	objectName := k8sName(e.stack, name)
+	selector := map[string]interface{}{"app.kubernetes.io/name": objectName}
+
+	container := map[string]interface{}{"name": k8sName(name), "image": service.Image}
+	switch command := service.Command.(type) {
+	case string:
+		if strings.ContainsAny(command, `"'`) {
+			e.warn("service %s: the quoting of command is not preserved; check its args", name)
+		}
+		container["args"] = strings.Fields(command)
+	case []interface{}:
+		container["args"] = command
+	case []string:
+		container["args"] = command
+	}
+	if env := e.env(service, envSecret); len(env) > 0 {
+		container["env"] = env
+	}
+
+	var containerPorts, servicePorts []interface{}
+	for _, mapping := range service.Ports {
+		spec := compose.ParsePort(mapping)
+		port, err := strconv.Atoi(spec.ContainerPort)
+		if err != nil {
+			e.warn("service %s: port range %s is not supported", name, mapping)
+			continue
+		}
+		published := port
+		if hostPort, err := strconv.Atoi(spec.HostPort); err == nil {
+			published = hostPort
+		}
+		protocol := strings.ToUpper(spec.Protocol)
+		if protocol == "" {
+			protocol = "TCP"
+		}
+		containerPorts = append(containerPorts, map[string]interface{}{"containerPort": port, "protocol": protocol})
+		servicePorts = append(servicePorts, map[string]interface{}{"name": fmt.Sprintf("%s-%d", strings.ToLower(protocol), published), "port": published, "targetPort": port, "protocol": protocol})
+	}
+	if len(containerPorts) > 0 {
+		container["ports"] = containerPorts
+	}
+
+	limits := map[string]interface{}{}
+	if resources, ok := service.Deploy["resources"].(map[string]interface{}); ok {
+		if deployLimits, ok := resources["limits"].(map[string]interface{}); ok {
+			if memory, ok := deployLimits["memory"]; ok {
+				limits["memory"] = k8sQuantity(fmt.Sprint(memory))
+			}
+			if cpus, ok := deployLimits["cpus"]; ok {
+				limits["cpu"] = fmt.Sprint(cpus)
+			}
+		}
+	}
+	if _, ok := limits["memory"]; !ok && service.MemLimit != "" {
+		limits["memory"] = k8sQuantity(service.MemLimit)
+	}
+	if _, ok := limits["cpu"]; !ok && service.CPUs != nil {
+		limits["cpu"] = fmt.Sprint(service.CPUs)
+	}
+	if len(limits) > 0 {
+		container["resources"] = map[string]interface{}{"limits": limits}
+	}
+	if p := probe(service.Healthcheck); p != nil {
+		container["readinessProbe"] = p
+	}
+	if len(service.CapAdd) > 0 {
+		container["securityContext"] = map[string]interface{}{"capabilities": map[string]interface{}{"add": service.CapAdd}}
+	}
+	if uid, err := strconv.Atoi(strings.Split(service.User, ":")[0]); err == nil {
+		podSecurity := map[string]interface{}{"runAsUser": uid}
+		container["securityContext"] = mergeMaps(container["securityContext"], podSecurity)
+	} else if service.User != "" {
+		e.warn("service %s: user %s is not numeric and was dropped", name, service.User)
+	}
+	if len(service.Devices) > 0 {
+		e.warn("service %s: devices are not supported and were dropped", name)
+	}
+	if service.Build != nil {
+		e.warn("service %s: build is ignored; the image must be in a registry", name)
+	}
+
+	mounts, volumes := e.volumes(name, service, composeFile)
+	if len(mounts) > 0 {
+		container["volumeMounts"] = mounts
+	}
+	pod := map[string]interface{}{"containers": []interface{}{container}}
+	if len(volumes) > 0 {
+		pod["volumes"] = volumes
+	}
+
+	replicas := 1
+	if value, ok := service.Deploy["replicas"].(int); ok {
+		replicas = value
+	}
+	e.add("Deployment", "apps/v1", objectName, map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+			"selector": map[string]interface{}{"matchLabels": selector},
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": selector},
+				"spec":     pod,
+			},
+		},
+	})
+	if len(servicePorts) > 0 {
+		// the Service keeps the compose service name so other services reach it as before
+		e.add("Service", "v1", k8sName(name), map[string]interface{}{
+			"spec": map[string]interface{}{"selector": selector, "ports": servicePorts},
+		})
+	}
+}
+
+// mergeMaps returns the keys of a (a map or nil) and b
+func mergeMaps(a interface{}, b map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	if m, ok := a.(map[string]interface{}); ok {
+		for key, value := range m {
+			merged[key] = value
+		}
+	}
+	for key, value := range b {
+		merged[key] = value
+	}
+	return merged
+}
+
+// buildK8sManifests converts a stack to Kubernetes manifests: a Deployment per service, a Service
+// per service publishing ports, a PersistentVolumeClaim per named volume, a ConfigMap per config
+// and Secrets for the secrets and the variables of the environment. It returns what couldn't be
+// converted as warnings.
+func buildK8sManifests(composeFile *compose.File, stackName string, values map[string]string) ([]map[string]interface{}, []string) {
+	e := &k8sExport{stack: stackName, values: values, claims: map[string]bool{}}
+
+	names := make([]string, 0, len(composeFile.Services))
+	for name := range composeFile.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	envSecret := make(map[string]string)
+	for _, name := range names {
+		e.service(name, composeFile.Services[name], composeFile, envSecret)
+	}
+
+	if len(envSecret) > 0 {
+		e.add("Secret", "v1", k8sName(stackName, "env"), map[string]interface{}{"type": "Opaque", "stringData": e.secretData(envSecret)})
+	}
+	for _, name := range sortedKeys(composeFile.Secrets) {
+		secret := composeFile.Secrets[name]
+		data := map[string]interface{}{name: ""}
+		switch {
+		case secret.Environment != "":
+			data = e.secretData(map[string]string{name: secret.Environment})
+		case secret.File != "" && values != nil:
+			if content, err := os.ReadFile(secretPath(secret.File)); err == nil {
+				data[name] = string(content)
+			}
+		case secret.External:
+			e.warn("secret %s is external; create Secret %s in the cluster", name, k8sName(stackName, "secret", name))
+			continue
+		}
+		e.add("Secret", "v1", k8sName(stackName, "secret", name), map[string]interface{}{"type": "Opaque", "stringData": data})
+	}
+	for _, name := range sortedKeys(composeFile.Configs) {
+		config := composeFile.Configs[name]
+		content := config.Content
+		if config.File != "" {
+			data, err := os.ReadFile(secretPath(config.File))
+			if err != nil {
+				e.warn("config %s: %v", name, err)
+				continue
+			}
+			content = string(data)
+		}
+		e.add("ConfigMap", "v1", k8sName(stackName, "config", name), map[string]interface{}{"data": map[string]interface{}{name: content}})
+	}
+	if values == nil && (len(envSecret) > 0 || len(composeFile.Secrets) > 0) {
+		e.warn("Secrets have empty values; fill them in or export with --secret-values=true")
+	}
+	return e.manifests, e.warnings
+}
+
+// secretPath resolves a file of a secret or config relative to the stacks directory
+func secretPath(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(StacksDir, file)
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// HandleExportK8s writes the Kubernetes manifests of a stack as a multi-document YAML, from the
+// effective (enriched) stack file if there is one. Secret values are only included with
+// --secret-values=true.
+func HandleExportK8s(stackName string, out io.Writer) error {
+	content, err := os.ReadFile(GetStackPath(stackName, true))
+	if err != nil {
+		if content, _, err = findYAML(stackName); err != nil {
+			return err
+		}
+	}
+	var composeFile compose.File
+	if err := yaml.Unmarshal(content, &composeFile); err != nil {
+		return fmt.Errorf("failed to parse YAML: %w", err)
+	}
+
+	var values map[string]string
+	if getFlagBool("secret_values", false) {
+		if values, err = readStackEnv(stackName); err != nil {
+			return err
+		}
+	}
+	manifests, warnings := buildK8sManifests(&composeFile, stackName, values)
+	for _, warning := range warnings {
+		exportLog.Warn(warning)
+	}
+
+	fmt.Fprintf(out, "# Kubernetes manifests of stack %s, generated by dc\n", stackName)
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	for _, manifest := range manifests {
+		if err := encoder.Encode(manifest); err != nil {
+			return err
+		}
+	}
+	return encoder.Close()
+}
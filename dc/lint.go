@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dc/internal/compose"
+	"dc/internal/secrets"
+	"gopkg.in/yaml.v3"
+)
+
+// Lint finding severities; errors reject a stack file, warnings are reported only
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// LintFinding is a problem found in a compose file
+type LintFinding struct {
+	File     string `json:"file,omitempty"`
+	Service  string `json:"service,omitempty"`
+	Severity string `json:"severity"`
+	Check    string `json:"check"` // schema, lint, secrets or policy:<rule>
+	Message  string `json:"message"`
+}
+
+func (f LintFinding) String() string {
+	location := f.File
+	if f.Service != "" {
+		location = strings.TrimPrefix(location+" service "+f.Service, " ")
+	}
+	if location != "" {
+		location += ": "
+	}
+	return fmt.Sprintf("%s: %s%s (%s)", f.Severity, location, f.Message, f.Check)
+}
+
+// validRestartPolicies are the restart values docker compose accepts (on-failure may carry a count)
+var validRestartPolicies = map[string]bool{"": true, "no": true, "always": true, "unless-stopped": true, "on-failure": true}
+
+// privateKeyRe matches PEM private keys pasted into a compose file
+var privateKeyRe = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)
+
+// lintCompose validates a compose file (schema), checks common mistakes (lint) and scans for
+// plaintext credentials (secrets). It only looks at the content and touches no server state.
+func lintCompose(content []byte) []LintFinding {
+	var findings []LintFinding
+	add := func(service, severity, check, format string, args ...interface{}) {
+		findings = append(findings, LintFinding{Service: service, Severity: severity, Check: check, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if privateKeyRe.Match(content) {
+		add("", SeverityError, "secrets", "contains a private key")
+	}
+
+	var composeFile compose.File
+	if err := yaml.Unmarshal(content, &composeFile); err != nil {
+		add("", SeverityError, "schema", "invalid YAML: %v", err)
+		return findings
+	}
+	if len(composeFile.Services) == 0 {
+		add("", SeverityError, "schema", "no services defined")
+	}
+
+	containerNames := make(map[string]string)
+	names := make([]string, 0, len(composeFile.Services))
+	for name := range composeFile.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		service := composeFile.Services[name]
+
+		if service.Image == "" && service.Build == nil {
+			add(name, SeverityError, "schema", "neither image nor build is set")
+		}
+		restart, _, _ := strings.Cut(service.Restart, ":")
+		if !validRestartPolicies[restart] {
+			add(name, SeverityError, "schema", "invalid restart policy %q", service.Restart)
+		}
+		for _, port := range service.Ports {
+			spec := compose.ParsePort(port)
+			for _, p := range []string{spec.HostPort, spec.ContainerPort} {
+				first, _, _ := strings.Cut(p, "-")
+				if n, err := strconv.Atoi(first); p != "" && (err != nil || n < 1 || n > 65535) {
+					add(name, SeverityError, "schema", "invalid port mapping %q", port)
+					break
+				}
+			}
+		}
+
+		if service.Image != "" && !imagePinned(service.Image) {
+			add(name, SeverityWarning, "lint", "image %s is not pinned to a version", service.Image)
+		}
+		deps := normalizeDependsOn(service.DependsOn)
+		depNames := make([]string, 0, len(deps))
+		for dep := range deps {
+			depNames = append(depNames, dep)
+		}
+		sort.Strings(depNames)
+		for _, dep := range depNames {
+			condition := deps[dep]
+			if _, ok := composeFile.Services[dep]; !ok {
+				add(name, SeverityError, "schema", "depends_on references unknown service %s", dep)
+			} else if condition == compose.ConditionServiceHealthy && composeFile.Services[dep].Healthcheck == nil {
+				add(name, SeverityWarning, "lint", "depends_on %s with condition service_healthy, but %s defines no healthcheck", dep, dep)
+			}
+			if condition != compose.ConditionServiceStarted && condition != compose.ConditionServiceHealthy && condition != compose.ConditionServiceCompletedSuccessfully {
+				add(name, SeverityError, "schema", "invalid depends_on condition %q", condition)
+			}
+		}
+		if service.ContainerName != "" {
+			if other, ok := containerNames[service.ContainerName]; ok {
+				add(name, SeverityError, "lint", "container_name %s is also used by service %s", service.ContainerName, other)
+			}
+			containerNames[service.ContainerName] = name
+		}
+
+		for _, envVar := range compose.NormalizeEnvironment(service.Environment) {
+			key, value, ok := strings.Cut(envVar, "=")
+			if ok && value != "" && !compose.ReferencesVariables(value) && secrets.IsSensitiveKey(key, value) && !compose.KeepPlaintext(&composeFile, name, key) {
+				add(name, SeverityError, "secrets", "%s holds a plaintext credential; use ${%s} instead", key, secrets.NormalizeKey(key))
+			}
+		}
+	}
+	if composeFile.Composectl != nil {
+		for _, requirement := range composeFile.Composectl.Requires {
+			if err := requirement.Validate(); err != nil {
+				add("", SeverityError, "schema", "x-composectl.requires: %v", err)
+			}
+		}
+	}
+	if cycle := dependencyCycle(&composeFile); cycle != nil {
+		add("", SeverityError, "schema", "depends_on cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return findings
+}
+
+// imagePinned reports whether an image reference has a digest or a tag other than latest
+func imagePinned(image string) bool {
+	if strings.Contains(image, "@") {
+		return true
+	}
+	if i := strings.LastIndex(image, "/"); i >= 0 {
+		image = image[i+1:]
+	}
+	return strings.Contains(image, ":") && !strings.HasSuffix(image, ":latest")
+}
+
+// hasLintErrors reports whether any finding is an error
+func hasLintErrors(findings []LintFinding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// printLintFindings prints findings as text, or as JSON with --output-format=json
+func printLintFindings(findings []LintFinding) error {
+	if OutputFormat == OutputFormatJSON {
+		if findings == nil {
+			findings = []LintFinding{}
+		}
+		return json.NewEncoder(os.Stdout).Encode(findings)
+	}
+	for _, f := range findings {
+		fmt.Println(f)
+	}
+	return nil
+}
+
+// HandleLint lints compose files (or stdin for "-") and fails if any has errors
+func HandleLint(files []string) error {
+	var findings []LintFinding
+	for _, file := range files {
+		var content []byte
+		var err error
+		if file == "-" {
+			content, err = io.ReadAll(os.Stdin)
+		} else {
+			content, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return err
+		}
+		for _, f := range lintCompose(content) {
+			if file != "-" {
+				f.File = file
+			}
+			findings = append(findings, f)
+		}
+	}
+	if err := printLintFindings(findings); err != nil {
+		return err
+	}
+	if hasLintErrors(findings) {
+		return fmt.Errorf("lint failed")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+)
+
+// defaultLogDriver is the logging driver given to services without one (config key log_driver)
+const defaultLogDriver = "json-file"
+
+// defaultLogging returns the logging config of services that declare none: log_driver with the
+// options of log_options (key=value,...). The json-file and local drivers rotate their files
+// at log_max_size (default 10m), keeping log_max_file (default 3) of them, unless log_options
+// sets max-size or max-file itself.
+func defaultLogging() *compose.Logging {
+	logging := &compose.Logging{Driver: getConfig("log_driver", defaultLogDriver), Options: map[string]string{}}
+	for _, pair := range strings.Split(getConfig("log_options", ""), ",") {
+		if key, value, ok := strings.Cut(pair, "="); ok && key != "" {
+			logging.Options[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if logging.Driver == "json-file" || logging.Driver == "local" {
+		if _, ok := logging.Options["max-size"]; !ok {
+			logging.Options["max-size"] = getConfig("log_max_size", "10m")
+		}
+		if _, ok := logging.Options["max-file"]; !ok {
+			logging.Options["max-file"] = getConfig("log_max_file", "3")
+		}
+	}
+	if len(logging.Options) == 0 {
+		logging.Options = nil
+	}
+	return logging
+}
+
+// addLogDefaults gives the services without a logging config the default one and returns their
+// names, so that no container writes unbounded logs to the disk
+func addLogDefaults(composeFile *compose.File) []string {
+	if composeFile == nil {
+		return nil
+	}
+	var changed []string
+	for serviceName, service := range composeFile.Services {
+		if service.Logging != nil {
+			continue
+		}
+		service.Logging = defaultLogging()
+		composeFile.Services[serviceName] = service
+		changed = append(changed, serviceName)
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// ensureLogDefaults applies addLogDefaults unless log_defaults is disabled
+func ensureLogDefaults(composeFile *compose.File) {
+	if !getConfigBool("log_defaults", true) {
+		return
+	}
+	for _, serviceName := range addLogDefaults(composeFile) {
+		enrichLog.Info("Added default logging", "service", serviceName, "driver", composeFile.Services[serviceName].Logging.Driver)
+	}
+}
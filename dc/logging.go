@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+
+	"dc/logging"
+)
+
+// logBuffer keeps the records below the log level so that successful invocations (e.g. "dc stack
+// ls") produce no diagnostic noise; die prints them when a command fails
+var logBuffer bytes.Buffer
+
+// rootLog is where all loggers write; initLogging configures it
+var rootLog = logging.NewSink(logging.Options{Below: &logBuffer})
+
+// Loggers of the components of dc
+var (
+	backupLog    = rootLog.Logger("backup")
+	chaosLog     = rootLog.Logger("chaos")
+	configLog    = rootLog.Logger("config")
+	devicesLog   = rootLog.Logger("devices")
+	dnsLog       = rootLog.Logger("dns")
+	enrichLog    = rootLog.Logger("enrich")
+	exportLog    = rootLog.Logger("export")
+	gitLog       = rootLog.Logger("git")
+	jobsLog      = rootLog.Logger("jobs")
+	logsLog      = rootLog.Logger("logs")
+	policyLog    = rootLog.Logger("policy")
+	preflightLog = rootLog.Logger("preflight")
+	resourcesLog = rootLog.Logger("resources")
+	secretsLog   = rootLog.Logger("secrets")
+	stackLog     = rootLog.Logger("stack")
+)
+
+// initLogging applies log_level (debug, info, warn or error), log_format and the --verbose and
+// --quiet flags, redacts dc's messages and routes the log package through slog
+func initLogging() {
+	rootLog.Setup(getConfig("log_format", logging.FormatConsole), getConfig("log_level", "info"), redactText)
+}
@@ -0,0 +1,183 @@
+// Package logging is the slog setup shared by dc and dcapi: component loggers that write to one
+// sink in the console, text or json format, with secrets redacted.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Log formats (log_format) of the messages on stderr:
+//   - console: "[INFO] message key=value" lines, prefixed with the time if Options.Timestamps is
+//     set (default)
+//   - text: slog's key=value lines
+//   - json: one JSON object per line
+const (
+	FormatConsole = "console"
+	FormatText    = "text"
+	FormatJSON    = "json"
+)
+
+// Options configure a Sink
+type Options struct {
+	// Timestamps prefixes console lines with the date and time
+	Timestamps bool
+	// Below receives the records below the level; they are dropped if it is nil
+	Below io.Writer
+}
+
+// Sink is where the loggers of a program write; Setup configures it
+type Sink struct {
+	mu         sync.Mutex
+	out        io.Writer
+	below      io.Writer
+	timestamps bool
+	format     string
+	redact     func(string) string
+	level      slog.LevelVar
+}
+
+// NewSink returns a sink writing to stderr in the console format at level info
+func NewSink(options Options) *Sink {
+	return &Sink{out: os.Stderr, below: options.Below, timestamps: options.Timestamps, format: FormatConsole}
+}
+
+// Logger returns the logger of a component, which tags its records with component=name
+func (s *Sink) Logger(component string) *slog.Logger {
+	return slog.New(&handler{sink: s}).With("component", component)
+}
+
+// Setup applies a log format and level (debug, info, warn or error) and the --verbose and --quiet
+// flags, redacts the records with redact unless it is nil and routes the log package through slog
+func (s *Sink) Setup(format, level string, redact func(string) string) {
+	s.mu.Lock()
+	switch format = strings.ToLower(format); format {
+	case FormatText, FormatJSON:
+		s.format = format
+	default:
+		s.format = FormatConsole
+	}
+	s.redact = redact
+	s.mu.Unlock()
+
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		l = slog.LevelInfo
+	}
+	switch {
+	case Flag("verbose"):
+		l = slog.LevelDebug
+	case Flag("quiet"):
+		l = slog.LevelError
+	}
+	s.level.Set(l)
+	slog.SetDefault(slog.New(&handler{sink: s}))
+}
+
+// Flag reports whether a boolean flag such as --verbose was passed
+func Flag(name string) bool {
+	for _, arg := range os.Args[1:] {
+		switch arg {
+		case "-" + name, "--" + name, "--" + name + "=true":
+			return true
+		}
+	}
+	return false
+}
+
+// handler formats the records of all loggers according to the sink's format and redacts them
+type handler struct {
+	sink   *Sink
+	attrs  []slog.Attr
+	prefix string // of the keys of attributes in groups
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.sink.below != nil || level >= h.sink.level.Level()
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr{}, h.attrs...), h.prefixed(attrs)...)
+	return &h2
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+// prefixed returns attributes with the keys of the current groups
+func (h *handler) prefixed(attrs []slog.Attr) []slog.Attr {
+	if h.prefix == "" {
+		return attrs
+	}
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = slog.Attr{Key: h.prefix + a.Key, Value: a.Value}
+	}
+	return out
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := append([]slog.Attr{}, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, h.prefixed([]slog.Attr{a})...)
+		return true
+	})
+
+	var buf bytes.Buffer
+	h.sink.mu.Lock()
+	defer h.sink.mu.Unlock()
+	switch h.sink.format {
+	case FormatConsole:
+		writeConsoleRecord(&buf, r, attrs, h.sink.timestamps)
+	default:
+		record := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+		record.AddAttrs(attrs...)
+		options := &slog.HandlerOptions{Level: slog.LevelDebug}
+		if h.sink.format == FormatJSON {
+			slog.NewJSONHandler(&buf, options).Handle(ctx, record)
+		} else {
+			slog.NewTextHandler(&buf, options).Handle(ctx, record)
+		}
+	}
+	text := buf.String()
+	if h.sink.redact != nil {
+		text = h.sink.redact(text)
+	}
+	out := h.sink.out
+	if r.Level < h.sink.level.Level() {
+		out = h.sink.below
+	}
+	_, err := io.WriteString(out, text)
+	return err
+}
+
+// writeConsoleRecord writes a record as a "[LEVEL] message key=value" line. The component is
+// left out: the message says what happened.
+func writeConsoleRecord(b *bytes.Buffer, r slog.Record, attrs []slog.Attr, timestamp bool) {
+	if timestamp {
+		b.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+	}
+	fmt.Fprintf(b, "[%s] %s", r.Level, r.Message)
+	for _, a := range attrs {
+		if a.Key == "component" {
+			continue
+		}
+		value := a.Value.Resolve().String()
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(b, " %s=%s", a.Key, value)
+	}
+	b.WriteByte('\n')
+}
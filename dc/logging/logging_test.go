@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSinkWritesRecords(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{FormatConsole, "[INFO] Started stack stack=web note=\"password=hunter2\"\n"},
+		{FormatText, "level=INFO msg=\"Started stack\" component=stack stack=web note=\"password=hunter2\"\n"},
+		{FormatJSON, `"level":"INFO","msg":"Started stack","component":"stack","stack":"web","note":"password=hunter2"}` + "\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		sink := NewSink(Options{})
+		sink.out = &out
+		sink.format = tt.format
+		sink.Logger("stack").Info("Started stack", "stack", "web", "note", "password=hunter2")
+		if got := out.String(); !strings.HasSuffix(got, tt.want) {
+			t.Errorf("%s: got %q, want suffix %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestSinkRedactsAndKeepsRecordsBelowTheLevel(t *testing.T) {
+	var out, below bytes.Buffer
+	sink := NewSink(Options{Below: &below})
+	sink.out = &out
+	sink.redact = func(s string) string { return strings.ReplaceAll(s, "hunter2", "***") }
+	log := sink.Logger("secrets")
+
+	log.Debug("Read secret", "value", "hunter2")
+	log.Warn("Secret is weak", "value", "hunter2")
+	if want := "[DEBUG] Read secret value=***\n"; below.String() != want {
+		t.Errorf("below = %q, want %q", below.String(), want)
+	}
+	if want := "[WARN] Secret is weak value=***\n"; out.String() != want {
+		t.Errorf("out = %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	sink = NewSink(Options{Timestamps: true})
+	sink.out = &out
+	if sink.Logger("secrets").Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("debug records are enabled without Below at level info")
+	}
+	sink.Logger("secrets").Warn("Secret is weak")
+	if line := out.String(); !regexp.MustCompile(`^\d{4}/\d\d/\d\d \d\d:\d\d:\d\d \[WARN\] Secret is weak\n$`).MatchString(line) {
+		t.Errorf("timestamped line = %q", line)
+	}
+}
+
+func TestSinkGroupsPrefixKeys(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewSink(Options{})
+	sink.out = &out
+	sink.Logger("http").WithGroup("request").Info("Handled request", "path", "/api/stacks")
+	if want := "[INFO] Handled request request.path=/api/stacks\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Log shipping destinations
+const (
+	LogShipLoki          = "loki"
+	LogShipElasticsearch = "elasticsearch"
+)
+
+const (
+	// logShipBatchSize is the number of lines from which a batch is shipped right away
+	logShipBatchSize = 500
+
+	// logShipInterval is how often the lines collected so far are shipped and containers
+	// looked for
+	logShipInterval = 2 * time.Second
+
+	// logShipRetries is how often a batch is sent before it is dropped
+	logShipRetries = 3
+)
+
+// LoggingSettings configures shipping the logs of the containers of the stacks to Loki or
+// Elasticsearch (config key logging_file, default settings/logging.yml in the stacks dir, which dcapi manages under
+// /api/settings/logging and runs `dc logs forward` for)
+type LoggingSettings struct {
+	Enabled bool   `yaml:"enabled"`
+	Type    string `yaml:"type"` // loki or elasticsearch
+	// URL is the push endpoint of Loki (http://loki:3100/loki/api/v1/push) or the address of
+	// Elasticsearch (http://elasticsearch:9200)
+	URL   string `yaml:"url"`
+	Index string `yaml:"index,omitempty"` // elasticsearch, default dc-logs
+
+	// Credentials: basic auth or a bearer token
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+
+	// Labels are added to every line, e.g. host: nas
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Stacks limits shipping to these stacks; default all stacks with a stack file
+	Stacks []string `yaml:"stacks,omitempty"`
+}
+
+// logLine is a line a container logged
+type logLine struct {
+	Time      time.Time
+	Stack     string
+	Service   string
+	Container string
+	Stream    string // stdout or stderr
+	Message   string
+}
+
+// getLoggingSettingsPath returns the file holding the log shipping settings (config key
+// logging_file)
+func getLoggingSettingsPath() string {
+	return getConfig("logging_file", getSettingsPath("logging.yml"))
+}
+
+// loadLoggingSettings reads the log shipping settings, nil if there are none
+func loadLoggingSettings() (*LoggingSettings, error) {
+	content, err := os.ReadFile(getLoggingSettingsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var settings LoggingSettings
+	if err := yaml.Unmarshal(content, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", getLoggingSettingsPath(), err)
+	}
+	return &settings, nil
+}
+
+// logShipper sends batches of lines to the destination
+type logShipper struct {
+	settings *LoggingSettings
+	client   *http.Client
+}
+
+// send posts a payload with the credentials of the settings
+func (s *logShipper) send(address, contentType string, payload []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, address, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	switch {
+	case s.settings.Token != "":
+		req.Header.Set("Authorization", "Bearer "+s.settings.Token)
+	case s.settings.Username != "":
+		req.SetBasicAuth(s.settings.Username, s.settings.Password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(content)))
+	}
+	return content, nil
+}
+
+// labels returns the labels of a line: those of the settings and its stack, service,
+// container and stream
+func (s *logShipper) labels(line logLine) map[string]string {
+	labels := make(map[string]string, len(s.settings.Labels)+4)
+	for key, value := range s.settings.Labels {
+		labels[key] = value
+	}
+	labels["stack"] = line.Stack
+	labels["service"] = line.Service
+	labels["container"] = line.Container
+	labels["stream"] = line.Stream
+	return labels
+}
+
+// ship sends a batch of lines
+func (s *logShipper) ship(lines []logLine) error {
+	if s.settings.Type == LogShipElasticsearch {
+		return s.shipElasticsearch(lines)
+	}
+	return s.shipLoki(lines)
+}
+
+// shipLoki pushes lines to Loki, one stream per container and output stream
+func (s *logShipper) shipLoki(lines []logLine) error {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	streams := make(map[string]*stream)
+	var keys []string
+	for _, line := range lines {
+		key := line.Container + "/" + line.Stream
+		if streams[key] == nil {
+			streams[key] = &stream{Stream: s.labels(line)}
+			keys = append(keys, key)
+		}
+		streams[key].Values = append(streams[key].Values, [2]string{strconv.FormatInt(line.Time.UnixNano(), 10), line.Message})
+	}
+	push := struct {
+		Streams []*stream `json:"streams"`
+	}{}
+	for _, key := range keys {
+		push.Streams = append(push.Streams, streams[key])
+	}
+	payload, err := json.Marshal(push)
+	if err != nil {
+		return err
+	}
+	_, err = s.send(s.settings.URL, "application/json", payload)
+	return err
+}
+
+// shipElasticsearch indexes lines through the bulk API, as documents with @timestamp, message
+// and the labels
+func (s *logShipper) shipElasticsearch(lines []logLine) error {
+	index := s.settings.Index
+	if index == "" {
+		index = "dc-logs"
+	}
+	action, err := json.Marshal(map[string]interface{}{"create": map[string]string{"_index": index}})
+	if err != nil {
+		return err
+	}
+	var payload bytes.Buffer
+	for _, line := range lines {
+		document := map[string]interface{}{"@timestamp": line.Time.Format(time.RFC3339Nano), "message": line.Message}
+		for key, value := range s.labels(line) {
+			document[key] = value
+		}
+		content, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+		payload.Write(action)
+		payload.WriteByte('\n')
+		payload.Write(content)
+		payload.WriteByte('\n')
+	}
+	content, err := s.send(strings.TrimSuffix(s.settings.URL, "/")+"/_bulk", "application/x-ndjson", payload.Bytes())
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Errors bool `json:"errors"`
+	}
+	if json.Unmarshal(content, &result) == nil && result.Errors {
+		return fmt.Errorf("elasticsearch rejected some of the %d lines", len(lines))
+	}
+	return nil
+}
+
+// logTail follows the logs of the containers of the stacks
+type logTail struct {
+	lines chan logLine
+
+	mu      sync.Mutex
+	tailing map[string]bool      // container IDs being followed
+	since   map[string]time.Time // of the last line of each container
+	started time.Time
+}
+
+// follow streams the logs of a container from where the last tail of it ended, or from the
+// start of the forwarder
+func (t *logTail) follow(id, stack, service, name string) {
+	t.mu.Lock()
+	since, ok := t.since[id]
+	if !ok {
+		since = t.started
+	}
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.tailing, id)
+		t.mu.Unlock()
+	}()
+
+	cmd := engineCommand("logs", "--follow", "--timestamps", "--since", since.Add(time.Nanosecond).Format(time.RFC3339Nano), id)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		logsLog.Warn("Failed to follow the logs of a container", "container", name, "err", err)
+		return
+	}
+	var wg sync.WaitGroup
+	read := func(r io.Reader, stream string) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			timestamp, message, _ := strings.Cut(scanner.Text(), " ")
+			at, err := time.Parse(time.RFC3339Nano, timestamp)
+			if err != nil {
+				at, message = time.Now(), scanner.Text()
+			}
+			t.lines <- logLine{Time: at, Stack: stack, Service: service, Container: name, Stream: stream, Message: message}
+			t.mu.Lock()
+			if at.After(t.since[id]) {
+				t.since[id] = at
+			}
+			t.mu.Unlock()
+		}
+	}
+	wg.Add(2)
+	go read(stdout, "stdout")
+	go read(stderr, "stderr")
+	wg.Wait()
+	cmd.Wait()
+}
+
+// discover starts following the running containers of the shipped stacks not followed yet
+func (t *logTail) discover(stacks map[string]bool) error {
+	output, err := engineCommand("ps", "--no-trunc", "--filter", "label=com.docker.compose.project", "--format",
+		`{{.ID}}	{{.Label "com.docker.compose.project"}}	{{.Label "com.docker.compose.service"}}	{{.Names}}`).Output()
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+	for _, row := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Split(row, "\t")
+		if len(fields) != 4 || !stacks[fields[1]] {
+			continue
+		}
+		t.mu.Lock()
+		tailing := t.tailing[fields[0]]
+		t.tailing[fields[0]] = true
+		t.mu.Unlock()
+		if !tailing {
+			logsLog.Debug("Following container logs", "stack", fields[1], "container", fields[3])
+			go t.follow(fields[0], fields[1], fields[2], fields[3])
+		}
+	}
+	return nil
+}
+
+// shippedStacks returns the stacks whose logs are shipped: those of the settings, else all
+// stacks with a stack file
+func shippedStacks(settings *LoggingSettings) map[string]bool {
+	stacks := make(map[string]bool)
+	if len(settings.Stacks) > 0 {
+		for _, stack := range settings.Stacks {
+			stacks[stack] = true
+		}
+		return stacks
+	}
+	for stack := range stackFiles() {
+		stacks[stack] = true
+	}
+	return stacks
+}
+
+// HandleLogsForward ships the logs of the containers of the stacks to Loki or Elasticsearch
+// until it is stopped. Lines logged while no forwarder ran are not shipped.
+func HandleLogsForward() error {
+	settings, err := loadLoggingSettings()
+	if err != nil {
+		return err
+	}
+	if settings == nil || !settings.Enabled {
+		return fmt.Errorf("log shipping is not enabled in %s", getLoggingSettingsPath())
+	}
+	if settings.Type != LogShipLoki && settings.Type != LogShipElasticsearch {
+		return fmt.Errorf("unknown log shipping type %q, expected %s or %s", settings.Type, LogShipLoki, LogShipElasticsearch)
+	}
+	if !strings.HasPrefix(settings.URL, "http") {
+		return fmt.Errorf("log shipping requires an http(s) url")
+	}
+
+	shipper := &logShipper{settings: settings, client: &http.Client{Timeout: 30 * time.Second}}
+	tail := &logTail{
+		lines:   make(chan logLine, 4*logShipBatchSize),
+		tailing: make(map[string]bool),
+		since:   make(map[string]time.Time),
+		started: time.Now(),
+	}
+	logsLog.Info("Shipping container logs", "type", settings.Type, "url", settings.URL)
+
+	flush := func(batch []logLine) {
+		sort.SliceStable(batch, func(i, j int) bool { return batch[i].Time.Before(batch[j].Time) })
+		for attempt := 1; ; attempt++ {
+			err := shipper.ship(batch)
+			if err == nil {
+				return
+			}
+			if attempt == logShipRetries {
+				logsLog.Error("Dropped log lines", "lines", len(batch), "err", err)
+				return
+			}
+			logsLog.Warn("Failed to ship log lines", "lines", len(batch), "attempt", attempt, "err", err)
+			time.Sleep(time.Duration(attempt) * logShipInterval)
+		}
+	}
+
+	ticker := time.NewTicker(logShipInterval)
+	defer ticker.Stop()
+	var batch []logLine
+	lastDiscovery := time.Time{}
+	for {
+		select {
+		case line := <-tail.lines:
+			batch = append(batch, line)
+			if len(batch) < logShipBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if time.Since(lastDiscovery) >= 5*logShipInterval {
+				if err := tail.discover(shippedStacks(settings)); err != nil {
+					logsLog.Warn(err.Error())
+				}
+				lastDiscovery = time.Now()
+			}
+		}
+		if len(batch) > 0 {
+			flush(batch)
+			batch = nil
+		}
+	}
+}
@@ -1,51 +1,95 @@
 package main
 
 import (
-	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"dc/internal/compose"
 )
 
 func main() {
-	// Buffer all log output so that successful invocations (e.g. "dc stacks ls")
-	// produce clean stdout with no diagnostic noise. Logs are only flushed to
-	// stderr on failure via die().
-	var logBuf bytes.Buffer
-	log.SetOutput(&logBuf)
+	// Records below the log level are buffered so that successful invocations (e.g. "dc stacks ls")
+	// produce clean stdout with no diagnostic noise. They are only flushed to stderr on failure
+	// via die().
+	initLogging()
 
 	die := func(format string, args ...interface{}) {
-		if logBuf.Len() > 0 {
-			os.Stderr.Write(logBuf.Bytes())
+		if logBuffer.Len() > 0 {
+			os.Stderr.WriteString(logBuffer.String())
 		}
-		fmt.Fprintf(os.Stderr, format+"\n", args...)
+		fmt.Fprintln(os.Stderr, redactText(fmt.Sprintf(format, args...)))
 		os.Exit(1)
 	}
 
 	// Initialize paths first (respects --stacks-dir and --env-path arguments)
 	InitPaths(os.Args)
 
+	DryRun = getConfigBool("dry_run", false)
+	if OutputFormat = getConfig("output_format", OutputFormatText); OutputFormat != OutputFormatJSON {
+		OutputFormat = OutputFormatText
+	}
+	initRedaction()
+
 	// Keep compatibility with flags that might be passed; ignore unknowns
 	host := flag.String("host", "", "(ignored) Server host")
+	// read by initLogging before the flags are parsed
+	flag.Bool("verbose", false, "Print debug messages")
+	flag.Bool("quiet", false, "Print errors only")
+	flag.Usage = func() { HandleHelp(os.Stderr, nil) }
 	flag.Parse()
 	_ = host
 
 	args := flag.Args()
 	if len(args) < 1 {
-		die("Usage: dc stack <command> [name]\nCommands: ls, start|up, stop, down, logs")
+		HandleHelp(os.Stderr, nil)
+		os.Exit(1)
 	}
 
+	// Help, completion and the config file don't touch stacks, so they return before the stacks dir is committed
+	switch {
+	case args[0] == "__complete":
+		HandleComplete(args[1:])
+		return
+	case args[0] == "help":
+		HandleHelp(os.Stdout, positionalArgs(args[1:]))
+		return
+	case args[0] == "completion":
+		if len(args) != 2 {
+			die("Usage: dc completion bash|zsh|fish")
+		}
+		if err := HandleCompletion(args[1]); err != nil {
+			die("%v", err)
+		}
+		return
+	case args[0] == "config":
+		if err := HandleConfig(args[1:]); err != nil {
+			die("%v", err)
+		}
+		return
+	case wantsHelp(args):
+		HandleHelp(os.Stdout, positionalArgs(args))
+		return
+	}
+	defer commitStacksDir(args)
+
 	switch args[0] {
 	case "stack", "stacks":
 		if len(args) < 2 {
 			die("Usage: dc stack <command> [name]")
 		}
 		cmd := args[1]
+		if _, ok := bulkActions[cmd]; ok && (cmd == "update" || isBulkInvocation(args)) {
+			if err := HandleBulkAction(cmd, args); err != nil {
+				die("%v", err)
+			}
+			return
+		}
 
 		switch cmd {
 		case "view":
@@ -60,15 +104,37 @@ func main() {
 				os.Stdout.Write(yamlBody)
 			}
 		case "ls", "list":
-			HandleListStacks()
+			if err := HandleListStacks(); err != nil {
+				die("%v", err)
+			}
+		case "meta":
+			pos := positionalArgs(args)
+			if len(pos) < 3 {
+				die("Usage: dc stack meta <name> [--group=<group>] [--autostart=true|false]")
+			}
+			if err := HandleStackMeta(pos[2], args[2:]); err != nil {
+				die("%v", err)
+			}
+		case "dirs":
+			// the directories holding stack files, e.g. for dcapi's file watcher
+			json.NewEncoder(os.Stdout).Encode(getAllStackDirs())
+		case "settings-dir":
+			// the directory of the settings files dcapi shares with dc, e.g. dns.yml
+			json.NewEncoder(os.Stdout).Encode(filepath.Join(StacksDir, settingsDirName))
+		case "boot":
+			if err := HandleBootStacks(args); err != nil {
+				die("%v", err)
+			}
 		case "start":
-			HandleStackAction(args, die, cmd, false, ComposeActionStart)
+			HandleStackAction(args, die, cmd, DryRun, compose.ActionStart)
 		case "up":
-			HandleStackAction(args, die, cmd, false, ComposeActionUp)
+			HandleStackAction(args, die, cmd, DryRun, compose.ActionUp)
 		case "stop":
-			HandleStackAction(args, die, cmd, false, ComposeActionStop)
+			HandleStackAction(args, die, cmd, DryRun, compose.ActionStop)
 		case "down":
-			HandleStackAction(args, die, cmd, false, ComposeActionDown)
+			HandleStackAction(args, die, cmd, DryRun, compose.ActionDown)
+		case "watch":
+			HandleStackAction(args, die, cmd, DryRun, compose.ActionWatch)
 		case "save", "put":
 			if len(args) < 3 {
 				die("Usage: dc stack save <name>")
@@ -76,19 +142,236 @@ func main() {
 			name := args[2]
 			content, err := io.ReadAll(os.Stdin)
 			if err != nil {
-				die("Failed to read stdin: %v", err)
+				die("%s", msg("stdin_read_failed", err))
+			}
+			if DryRun {
+				current, path := []byte{}, filepath.Join(getFirstWritableStackDir(), name+".yml")
+				if body, existing, err := findYAML(name); err == nil {
+					current, path = body, existing
+				}
+				fmt.Fprintln(os.Stdout, msg("dry_run_header"))
+				if diff := unifiedDiff(string(current), string(content), path, path); diff != "" {
+					os.Stdout.WriteString(diff)
+				} else {
+					fmt.Fprintf(os.Stdout, "# %s: unchanged\n", path)
+				}
+				return
 			}
 			dir := getFirstWritableStackDir()
 			if err := os.MkdirAll(dir, 0755); err != nil {
 				die("Failed to create directory %s: %v", dir, err)
 			}
 			path := filepath.Join(dir, name+".yml")
-			if err := os.WriteFile(path, content, 0644); err != nil {
+			if err := writeStackFile(name, path, content); err != nil {
 				die("Failed to write file %s: %v", path, err)
 			}
-			fmt.Fprintf(os.Stderr, "Saved stack %s to %s\n", name, path)
+			fmt.Fprintln(os.Stderr, msg("stack_saved", name, path))
+		case "rename", "mv", "clone", "cp":
+			pos := positionalArgs(args)
+			if len(pos) < 4 {
+				die("Usage: dc stack %s <name> <new-name> [--recreate=true]", cmd)
+			}
+			var err error
+			if cmd == "rename" || cmd == "mv" {
+				err = HandleRenameStack(pos[2], pos[3])
+			} else {
+				err = HandleCloneStack(pos[2], pos[3])
+			}
+			if err != nil {
+				die("%v", err)
+			}
+		case "adopt":
+			pos := positionalArgs(args)
+			if len(pos) < 4 && !(len(pos) == 3 && getFlagBool("confirm", false)) {
+				die("Usage: dc stack adopt <name> <container>... [--force=true] | dc stack adopt <name> --confirm=true")
+			}
+			if err := HandleAdoptStack(pos[2], pos[3:]); err != nil {
+				die("%v", err)
+			}
+		case "reconstruct":
+			pos := positionalArgs(args)
+			if len(pos) < 3 {
+				die("Usage: dc stack reconstruct <name> [--write=true] [--force=true]")
+			}
+			if err := HandleReconstructStack(pos[2]); err != nil {
+				die("%v", err)
+			}
+		case "import":
+			pos := positionalArgs(args)
+			if len(pos) < 3 {
+				die("Usage: dc stack import <path|-> [--name=<name>] [--force=true] [--up=true]")
+			}
+			if err := HandleImportStack(pos[2]); err != nil {
+				die("%v", err)
+			}
+		case "export":
+			pos := positionalArgs(args)
+			if len(pos) < 3 {
+				die("Usage: [EXPORT_PASSPHRASE=<secret>] dc stack export <name> [--upload=true] > bundle.tar.gz\n       dc stack export <name> --format=k8s [--secret-values=true] > manifests.yaml")
+			}
+			if getFlag("format", "") == "k8s" {
+				if err := HandleExportK8s(pos[2], os.Stdout); err != nil {
+					die("%v", err)
+				}
+			} else if getFlagBool("upload", false) {
+				id, err := HandleUploadExport(pos[2])
+				if err != nil {
+					die("%v", err)
+				}
+				fmt.Println(id)
+			} else if err := HandleExportStack(pos[2], os.Stdout); err != nil {
+				die("%v", err)
+			}
+		case "exports":
+			if len(args) < 3 {
+				die("Usage: dc stack exports <name>")
+			}
+			exports, err := listRemoteExports(args[2])
+			if err != nil {
+				die("%v", err)
+			}
+			for _, export := range exports {
+				fmt.Println(export)
+			}
+		case "import-bundle":
+			pos := positionalArgs(args)
+			if len(pos) < 3 {
+				die("Usage: dc stack import-bundle <bundle.tar.gz|-|<stack>[/<export>] --remote=true> [--name=<name>] [--force=true] (EXPORT_PASSPHRASE for encrypted bundles)")
+			}
+			in := os.Stdin
+			remote := getFlagBool("remote", false)
+			file := pos[2]
+			if remote {
+				downloaded, err := downloadExport(pos[2])
+				if err != nil {
+					die("%v", err)
+				}
+				file = downloaded
+			}
+			if file != "-" {
+				f, err := os.Open(file)
+				if remote {
+					// the open file stays readable after the download is unlinked
+					os.Remove(file)
+				}
+				if err != nil {
+					die("%v", err)
+				}
+				defer f.Close()
+				in = f
+			}
+			if err := HandleImportBundle(in); err != nil {
+				die("%v", err)
+			}
+		case "backup":
+			pos := positionalArgs(args)
+			if len(pos) < 3 {
+				die("Usage: dc stack backup <name> [--backup-retention=<n>]")
+			}
+			snapshot, err := HandleBackupStack(pos[2])
+			if err != nil {
+				die("%v", err)
+			}
+			fmt.Println(snapshot)
+		case "backups", "snapshots":
+			if len(args) < 3 {
+				die("Usage: dc stack backups <name> [--remote=true]")
+			}
+			var snapshots []string
+			var err error
+			if getFlagBool("remote", false) {
+				var target storageTarget
+				if target, err = requireStorageTarget(); err == nil {
+					snapshots, err = listRemoteSnapshots(target, args[2])
+				}
+			} else {
+				snapshots, err = listSnapshots(args[2])
+			}
+			if err != nil {
+				die("%v", err)
+			}
+			for _, snapshot := range snapshots {
+				fmt.Println(snapshot)
+			}
+		case "restore":
+			pos := positionalArgs(args)
+			if len(pos) < 3 {
+				die("Usage: dc stack restore <name> [--snapshot=<id>] [--remote=true]")
+			}
+			if err := HandleRestoreStack(pos[2], getFlag("snapshot", "")); err != nil {
+				die("%v", err)
+			}
+		case "revisions":
+			if len(args) < 3 {
+				die("Usage: dc stack revisions <name>")
+			}
+			revisions, err := listRevisions(args[2])
+			if err != nil {
+				die("%v", err)
+			}
+			for _, revision := range revisions {
+				fmt.Println(revision)
+			}
+		case "rollback":
+			pos := positionalArgs(args)
+			if len(pos) < 4 {
+				die("Usage: dc stack rollback <name> <revision>")
+			}
+			if err := HandleRollbackStack(pos[2], pos[3]); err != nil {
+				die("%v", err)
+			}
+		case "history":
+			if len(args) < 3 {
+				die("Usage: dc stack history <name>")
+			}
+			if err := HandleStackHistory(args[2]); err != nil {
+				die("%v", err)
+			}
+		case "chaos":
+			pos := positionalArgs(args)
+			if len(pos) < 4 {
+				die("Usage: dc stack chaos <name> kill|restart [--container=<name>] [--service=<name>] [--delay=<seconds>]")
+			}
+			if err := HandleChaos(pos[2], pos[3]); err != nil {
+				die("%v", err)
+			}
+		case "stats":
+			pos := positionalArgs(args)
+			if len(pos) < 3 {
+				die("Usage: dc stack stats <name> [--follow=true] [--interval=2s]")
+			}
+			if err := HandleStackStats(pos[2]); err != nil {
+				die("%v", err)
+			}
+		case "ps":
+			pos := positionalArgs(args)
+			if len(pos) < 3 {
+				die("Usage: dc stack ps <name> [--logs=<n>]")
+			}
+			if err := HandleStackPs(pos[2]); err != nil {
+				die("%v", err)
+			}
+		case "exec":
+			pos := positionalArgs(args)
+			if len(pos) < 4 {
+				die("Usage: dc stack exec <name> <service> [-- <command>...]")
+			}
+			if err := HandleStackExec(pos[2], pos[3], execCommandArgs(args, 4)); err != nil {
+				if code := execExitCode(err); code > 0 {
+					os.Exit(code)
+				}
+				die("%v", err)
+			}
+		case "usage":
+			pos := positionalArgs(args)
+			if len(pos) < 3 {
+				die("Usage: dc stack usage <name> [--range=24h] [--points=<n>]")
+			}
+			if err := HandleStackUsage(pos[2]); err != nil {
+				die("%v", err)
+			}
 		case "rm", "remove", "del", "delete":
-			HandleStackAction(args, die, cmd, false, ComposeActionRemove)
+			HandleStackAction(args, die, cmd, DryRun, compose.ActionRemove)
 		case "logs":
 			if len(args) < 3 {
 				die("Usage: dc stack logs <name>")
@@ -96,7 +379,221 @@ func main() {
 			name := args[2]
 			HandleStreamStackLogs(nil, "/api/stacks/"+name+"/logs")
 		default:
-			die("Unknown stack command: %s", cmd)
+			die("%s", msg("unknown_stack_command", cmd))
+		}
+
+	case "devices":
+		if len(args) < 2 || args[1] != "watch" {
+			die("Usage: dc devices watch [--device-poll-interval=2s]")
+		}
+		if err := HandleWatchDevices(); err != nil {
+			die("%v", err)
+		}
+
+	case "boot":
+		var err error
+		if pos := positionalArgs(args); len(pos) > 1 && pos[1] == "status" {
+			err = HandleBootStatus()
+		} else if len(pos) > 1 {
+			die("Usage: dc boot [status] [--wait-healthy=false] [--group-order=<group>,...]")
+		} else {
+			err = HandleAutostart(args)
+		}
+		if err != nil {
+			die("%v", err)
+		}
+
+	case "drift":
+		if err := HandleDrift(args); err != nil {
+			die("%v", err)
+		}
+
+	case "job", "jobs":
+		pos := positionalArgs(args)
+		var err error
+		switch {
+		case len(pos) == 2 && (pos[1] == "ls" || pos[1] == "list"):
+			err = HandleJobs("")
+		case len(pos) == 3 && pos[1] == "status":
+			err = HandleJobs(pos[2])
+		case len(pos) == 3 && pos[1] == "attach":
+			err = HandleAttachJob(pos[2])
+		case len(pos) == 3 && pos[1] == "cancel":
+			err = HandleCancelJob(pos[2])
+		default:
+			die("Usage: dc job ls [--stack=<name>] | dc job status|cancel <id> | dc job attach <id> [--detach=true] [--dcapi-url=http://localhost:8882] [--dcapi-token=<token>]")
+		}
+		if err != nil {
+			die("%v", err)
+		}
+
+	case "logs":
+		if pos := positionalArgs(args); len(pos) != 2 || pos[1] != "forward" {
+			die("Usage: dc logs forward [--logging-file=<path>]")
+		}
+		if err := HandleLogsForward(); err != nil {
+			die("%v", err)
+		}
+
+	case "graph":
+		if err := HandleGraph(); err != nil {
+			die("%v", err)
+		}
+
+	case "system":
+		pos := positionalArgs(args)
+		var err error
+		switch {
+		case len(pos) >= 2 && pos[1] == "df":
+			err = HandleSystemDF()
+		case len(pos) >= 2 && pos[1] == "prune":
+			err = HandleSystemPrune()
+		default:
+			die("Usage: dc system df | dc system prune [--images=true] [--containers=true] [--networks=true] [--dry-run=true]")
+		}
+		if err != nil {
+			die("%v", err)
+		}
+
+	case "network", "networks":
+		pos := positionalArgs(args)
+		if len(pos) < 2 {
+			die("Usage: dc network ls|inspect <name>|create <name> [--driver=bridge] [--driver-opts=k=v,...]|rm <name>|prune [--all=true]")
+		}
+		handleResourceCommand("network", pos, die, listNetworksJSON, HandleCreateNetwork, HandleRemoveNetwork, HandlePruneNetworks)
+
+	case "volume", "volumes":
+		pos := positionalArgs(args)
+		if len(pos) < 2 {
+			die("Usage: dc volume ls|inspect <name>|create <name> [--driver=local] [--driver-opts=k=v,...]|rm <name>|prune [--all=true]")
+		}
+		handleResourceCommand("volume", pos, die, listVolumesJSON, HandleCreateVolume, HandleRemoveVolume, HandlePruneVolumes)
+
+	case "container", "containers":
+		pos := positionalArgs(args)
+		if len(pos) == 2 && (pos[1] == "ls" || pos[1] == "list") {
+			if err := HandleListContainers(); err != nil {
+				die("%v", err)
+			}
+			return
+		}
+		if len(pos) == 3 && pos[1] == "inspect" {
+			if err := HandleInspectContainer(pos[2]); err != nil {
+				die("%v", err)
+			}
+			return
+		}
+		if len(pos) < 3 || pos[1] != "exec" {
+			die("Usage: dc container ls | dc container inspect <id> | dc container exec <id> [-- <command>...]")
+		}
+		if err := HandleExec(pos[2], execCommandArgs(args, 3)); err != nil {
+			if code := execExitCode(err); code > 0 {
+				os.Exit(code)
+			}
+			die("%v", err)
+		}
+
+	case "usage":
+		if len(args) < 2 || args[1] != "collect" {
+			die("Usage: dc usage collect [--follow=true] [--usage-interval=1m] [--usage-retention=168h]")
+		}
+		if err := HandleCollectUsage(); err != nil {
+			die("%v", err)
+		}
+
+	case "events":
+		follow := getFlagBool("follow", false)
+		for _, arg := range args[1:] {
+			if arg == "-f" {
+				follow = true
+			}
+		}
+		if err := HandleEvents(follow); err != nil {
+			die("%v", err)
+		}
+
+	case "policy", "policies":
+		if len(args) >= 2 && args[1] == "rules" {
+			if err := HandlePolicyRules(); err != nil {
+				die("%v", err)
+			}
+			return
+		}
+		var files []string
+		for _, arg := range args[min(2, len(args)):] {
+			if arg == "-" || !strings.HasPrefix(arg, "-") {
+				files = append(files, arg)
+			}
+		}
+		if len(args) < 2 || args[1] != "check" || len(files) == 0 {
+			die("Usage: dc policy check <file>... (- for stdin) [--output-format=json] | dc policy rules")
+		}
+		if err := HandlePolicyCheck(files); err != nil {
+			die("%v", err)
+		}
+
+	case "lint":
+		var files []string
+		for _, arg := range args[1:] {
+			if arg == "-" || !strings.HasPrefix(arg, "-") {
+				files = append(files, arg)
+			}
+		}
+		if len(files) == 0 {
+			die("Usage: dc lint <file>... (- for stdin) [--output-format=json]")
+		}
+		if err := HandleLint(files); err != nil {
+			die("%v", err)
+		}
+
+	case "git":
+		if len(args) < 2 {
+			die("Usage: dc git install-hook|pre-receive")
+		}
+		var err error
+		switch args[1] {
+		case "install-hook":
+			err = HandleInstallHook()
+		case "pre-receive":
+			err = HandlePreReceive()
+		default:
+			err = fmt.Errorf("unknown git command %q (expected install-hook or pre-receive)", args[1])
+		}
+		if err != nil {
+			die("%v", err)
+		}
+
+	case "transform":
+		if err := HandleTransform(); err != nil {
+			die("%v", err)
+		}
+
+	case "convert":
+		if len(args) < 2 || args[1] != "run" {
+			die("Usage: dc convert run [--name=<stack>] [--save=true] -- docker run <option>... <image> [<command>...] (or the command line on stdin)")
+		}
+		if err := HandleConvertRun(args[2:]); err != nil {
+			die("%v", err)
+		}
+
+	case "summary":
+		if err := HandleSummary(); err != nil {
+			die("%v", err)
+		}
+
+	case "capacity":
+		if err := HandleCapacity(); err != nil {
+			die("%v", err)
+		}
+
+	case "doctor":
+		if err := HandleDoctor(); err != nil {
+			die("%v", err)
+		}
+
+	case "status":
+		if err := HandlePublicStatus(); err != nil {
+			die("%v", err)
 		}
 
 	case "pw", "secret", "secrets":
@@ -104,7 +601,51 @@ func main() {
 		if len(args) < 2 {
 			die("Usage: dc %s <args...>", args[0])
 		}
-		cmdArgs := args[1:]
+		if args[1] == "rotate" {
+			rotateArgs := positionalArgs(args[2:])
+			if len(rotateArgs) != 1 {
+				die("Usage: dc secret rotate <name> [--restart=true] [--length=<n>] [--dry-run=true]")
+			}
+			if err := HandleSecretRotate(rotateArgs[0]); err != nil {
+				die("%v", err)
+			}
+			return
+		}
+		switch args[1] {
+		case "inventory":
+			var name string
+			if names := positionalArgs(args[2:]); len(names) > 0 {
+				name = names[0]
+			}
+			if err := HandleSecretInventory(name); err != nil {
+				die("%v", err)
+			}
+			return
+		case "prune":
+			if err := HandleSecretPrune(); err != nil {
+				die("%v", err)
+			}
+			return
+		case "store":
+			storeArgs := positionalArgs(args[2:])
+			if len(storeArgs) != 2 {
+				die("Usage: dc secret store create|update|set|delete <name> [--stack=<stack>] < value")
+			}
+			HandleSecretStore(storeArgs[0], storeArgs[1])
+			return
+		case "migrate":
+			if err := HandleSecretMigrate(positionalArgs(args[2:])); err != nil {
+				die("%v", err)
+			}
+			return
+		}
+		var cmdArgs []string
+		for _, arg := range args[1:] {
+			// dc-level flags are not understood by the secrets manager
+			if !strings.HasPrefix(arg, "--dry-run") && !strings.HasPrefix(arg, "--actor") && !strings.HasPrefix(arg, "--lang") {
+				cmdArgs = append(cmdArgs, arg)
+			}
+		}
 		// Normalize common long verbs to short aliases (insert/delete/update/upsert/get -> ins/del/upd/ups/get)
 		if len(cmdArgs) > 0 {
 			switch strings.ToLower(cmdArgs[0]) {
@@ -124,6 +665,13 @@ func main() {
 				cmdArgs[0] = "ls"
 			}
 		}
+		if DryRun && len(cmdArgs) > 0 {
+			switch cmdArgs[0] {
+			case "gen", "ins", "del", "upd", "ups":
+				fmt.Fprintf(os.Stdout, "# Dry run: would run %s %s\n", SecretsManager, strings.Join(cmdArgs, " "))
+				return
+			}
+		}
 		script := SecretsManager
 		// If script is a simple name, prefer PATH; otherwise if it contains a path use that directly when present.
 		if !strings.ContainsAny(script, string(os.PathSeparator)) {
@@ -169,11 +717,11 @@ func main() {
 		}
 
 	default:
-		die("Unknown command: %s", args[0])
+		die("%s", msg("unknown_command", args[0]))
 	}
 }
 
-func HandleStackAction(args []string, die func(format string, args ...interface{}), cmd string, dryRun bool, action ComposeAction) {
+func HandleStackAction(args []string, die func(format string, args ...interface{}), cmd string, dryRun bool, action compose.Action) {
 	if len(args) < 3 {
 		die("Usage: dc stack %s <name>", cmd)
 	}
@@ -182,13 +730,19 @@ func HandleStackAction(args []string, die func(format string, args ...interface{
 	if err != nil {
 		die("%v", err)
 	}
-	HandleDockerComposeFile(yamlBody, name, dryRun, action)
+	var services []string
+	if serviceActions[action] {
+		services = selectedServices(args)
+	}
+	if err := HandleDockerComposeFile(yamlBody, name, dryRun, action, services...); err != nil {
+		die("%v", err)
+	}
 }
 
 // findRunningStackConfigFile returns the compose config file path for a running stack
 // by reading the com.docker.compose.project.config_files Docker label.
 func findRunningStackConfigFile(name string) string {
-	cmd := exec.Command("docker", "ps", "-a", "--no-trunc",
+	cmd := engineCommand("ps", "-a", "--no-trunc",
 		"--filter", "label=com.docker.compose.project="+name,
 		"--format", "{{.Labels}}")
 	out, err := cmd.Output()
@@ -219,10 +773,29 @@ func findYAML(name string) ([]byte, string, error) {
 		}
 	}
 
+	candidates := stackFileCandidates(name)
+	var broken []string
+	for _, p := range candidates {
+		data, err := os.ReadFile(p)
+		if err == nil {
+			return data, p, nil
+		}
+		if isBrokenSymlink(p) {
+			broken = append(broken, p)
+		}
+	}
+	if len(broken) > 0 {
+		target, _ := os.Readlink(broken[0])
+		return nil, "", fmt.Errorf("%s", msg("stack_broken_symlink", broken[0], target, name))
+	}
+	return nil, "", fmt.Errorf("%s", msg("stack_not_found", name, candidates))
+}
+
+// stackFileCandidates returns the paths findYAML looks for the stack file at, in order
+func stackFileCandidates(name string) []string {
 	home, _ := os.UserHomeDir()
 	u := os.Getenv("USER")
-
-	candidates := []string{
+	return []string{
 		filepath.Join(StacksDir, name+".yml"),
 		fmt.Sprintf("./%s.yml", name),
 		filepath.Join("/stacks", name+".yml"),
@@ -232,66 +805,14 @@ func findYAML(name string) ([]byte, string, error) {
 		filepath.Join(home, ".local/containers", name+".yml"),
 		filepath.Join(home, ".dotfiles/users", u, ".local/containers", name+".yml"),
 	}
-
-	for _, p := range candidates {
-		data, err := os.ReadFile(p)
-		if err == nil {
-			return data, p, nil
-		}
-		data, err = repairBrokenSymlink(p, name)
-		if err == nil {
-			return data, p, nil
-		}
-	}
-	return nil, "", fmt.Errorf("no YAML found for stack %q; tried: %v", name, candidates)
 }
 
-// repairBrokenSymlink inspects all Docker containers, reconstructs a compose YAML, writes it
-// over the broken symlink, and returns the file contents.
-func repairBrokenSymlink(symlinkPath string, stackName string) ([]byte, error) {
-	// Collect container IDs (running + stopped) belonging to this compose project
-	out, err := exec.Command("docker", "ps", "-qa",
-		"--filter", "label=com.docker.compose.project="+stackName).Output()
-	if err != nil {
-		return nil, fmt.Errorf("docker ps -qa: %w", err)
+// isBrokenSymlink reports whether path is a symlink whose target doesn't exist
+func isBrokenSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return false
 	}
-
-	var ids []string
-	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
-		if id := strings.TrimSpace(line); id != "" {
-			ids = append(ids, id)
-		}
-	}
-	if len(ids) == 0 {
-		return nil, fmt.Errorf("no containers found for stack %q", stackName)
-	}
-
-	inspectData, err := inspectContainers(ids)
-	if err != nil {
-		return nil, fmt.Errorf("docker inspect: %w", err)
-	}
-
-	yamlContent, err := reconstructComposeFromContainers(inspectData, stackName)
-	if err != nil {
-		return nil, fmt.Errorf("reconstruction: %w", err)
-	}
-
-	// Prepend a specific notice about the broken symlink
-	header := "# WARNING: This file was auto-reconstructed after a broken symlink was detected.\n" +
-		"# Original symlink path: " + symlinkPath + "\n" +
-		"# Manual verification is required before using this configuration in production.\n"
-	full := header + yamlContent
-
-	// Replace the broken symlink (or create a new file) with the reconstructed YAML.
-	// Ignore remove errors — the path may not exist yet.
-	_ = os.Remove(symlinkPath)
-	if err := os.MkdirAll(filepath.Dir(symlinkPath), 0755); err != nil {
-		return nil, fmt.Errorf("mkdir for %s: %w", symlinkPath, err)
-	}
-	if err := os.WriteFile(symlinkPath, []byte(full), 0644); err != nil {
-		return nil, fmt.Errorf("write reconstructed YAML to %s: %w", symlinkPath, err)
-	}
-	_, _ = fmt.Fprintf(os.Stderr, "info: reconstructed YAML written to %s — please review before use\n", symlinkPath)
-
-	return []byte(full), nil
+	_, err = os.Stat(path)
+	return os.IsNotExist(err)
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultLanguage is used when neither --lang nor LANG selects a catalog
+const defaultLanguage = "en"
+
+// messages is the catalog of user-facing CLI messages by language and key.
+// To add a language, add a map with the same keys; missing keys fall back to English.
+var messages = map[string]map[string]string{
+	"en": {
+		"unknown_command":       "Unknown command: %s",
+		"unknown_stack_command": "Unknown stack command: %s",
+		"stack_not_found":       "no YAML found for stack %q; tried: %v",
+		"stack_exists":          "stack %q already exists at %s (use --force=true to overwrite)",
+		"stack_saved":           "Saved stack %s to %s",
+		"stack_renamed":         "Renamed stack %s to %s",
+		"stack_cloned":          "Cloned stack %s to %s",
+		"stack_imported":        "Imported stack %s to %s",
+		"stack_broken_symlink":  "%s is a broken symlink to %s; fix the link, or reconstruct the stack file from its containers with `dc stack reconstruct %s --write=true`",
+		"stack_reconstructed":   "Reconstructed stack %s to %s, please review it before use",
+		"stack_adopted":         "Adopted %[2]d containers into stack %[1]s",
+		"adopt_rolled_back":     "stack %s did not come up; the containers were restored",
+		"stack_adopted_kept":    "The original containers are kept stopped as <name>%[1]s; remove them with `dc stack adopt %[2]s --confirm=true` once the stack works",
+		"adopt_confirmed":       "Removed %[2]d original containers of stack %[1]s",
+		"stdin_read_failed":     "Failed to read stdin: %v",
+		"dry_run_header":        "# Dry run: no changes were made",
+		"chaos_disabled":        "chaos actions are disabled; set ENABLE_CHAOS=true to allow them",
+		"rolled_back":           "Rolled back stack %s to revision %s",
+		"cancelling":            "Cancelling, stopping docker compose",
+		"command_timed_out":     "Timed out after %s, stopping docker compose",
+		"compose_retry":         "Attempt %d of %d failed (%s), retrying in %s",
+		"compose_gave_up":       "Giving up after %d attempts",
+	},
+	"de": {
+		"unknown_command":       "Unbekannter Befehl: %s",
+		"unknown_stack_command": "Unbekannter Stack-Befehl: %s",
+		"stack_not_found":       "keine YAML-Datei für Stack %q gefunden; versucht: %v",
+		"stack_exists":          "Stack %q existiert bereits unter %s (mit --force=true überschreiben)",
+		"stack_saved":           "Stack %s nach %s gespeichert",
+		"stack_renamed":         "Stack %s in %s umbenannt",
+		"stack_cloned":          "Stack %s nach %s kopiert",
+		"stack_imported":        "Stack %s nach %s importiert",
+		"stack_broken_symlink":  "%s ist ein defekter Symlink auf %s; den Link reparieren oder die Stack-Datei mit `dc stack reconstruct %s --write=true` aus den Containern rekonstruieren",
+		"stack_reconstructed":   "Stack %s nach %s rekonstruiert, bitte vor der Verwendung prüfen",
+		"stack_adopted":         "%[2]d Container in Stack %[1]s übernommen",
+		"adopt_rolled_back":     "Stack %s ist nicht gestartet; die Container wurden wiederhergestellt",
+		"stack_adopted_kept":    "Die ursprünglichen Container bleiben gestoppt als <Name>%[1]s erhalten; mit `dc stack adopt %[2]s --confirm=true` entfernen, sobald der Stack funktioniert",
+		"adopt_confirmed":       "%[2]d ursprüngliche Container von Stack %[1]s entfernt",
+		"stdin_read_failed":     "Lesen von stdin fehlgeschlagen: %v",
+		"dry_run_header":        "# Probelauf: es wurden keine Änderungen vorgenommen",
+		"chaos_disabled":        "Chaos-Aktionen sind deaktiviert; zum Erlauben ENABLE_CHAOS=true setzen",
+		"rolled_back":           "Stack %s auf Revision %s zurückgesetzt",
+		"cancelling":            "Abbruch, docker compose wird beendet",
+		"command_timed_out":     "Zeitüberschreitung nach %s, docker compose wird beendet",
+		"compose_retry":         "Versuch %d von %d fehlgeschlagen (%s), neuer Versuch in %s",
+		"compose_gave_up":       "Aufgegeben nach %d Versuchen",
+	},
+}
+
+// language returns the catalog language: --lang (passed by dcapi from Accept-Language),
+// LANG/LC_MESSAGES (e.g. de_DE.UTF-8) or English
+func language() string {
+	for _, candidate := range []string{getConfig("lang", ""), os.Getenv("LC_MESSAGES"), os.Getenv("LANG")} {
+		lang := strings.ToLower(candidate)
+		if i := strings.IndexAny(lang, "_-."); i >= 0 {
+			lang = lang[:i]
+		}
+		if _, ok := messages[lang]; ok {
+			return lang
+		}
+	}
+	return defaultLanguage
+}
+
+// msg returns the catalog message for key in the selected language, formatted with args
+func msg(key string, args ...interface{}) string {
+	format, ok := messages[language()][key]
+	if !ok {
+		if format, ok = messages[defaultLanguage][key]; !ok {
+			format = key
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
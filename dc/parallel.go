@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+)
+
+// defaultListParallelism is how many docker inspect calls and stack files listing the stacks
+// handles at once (config key list_parallelism)
+const defaultListParallelism = 8
+
+// listParallelism returns list_parallelism, at least 1
+func listParallelism() int {
+	parallel, err := strconv.Atoi(getConfig("list_parallelism", strconv.Itoa(defaultListParallelism)))
+	if err != nil || parallel < 1 {
+		return defaultListParallelism
+	}
+	return parallel
+}
+
+// forEachParallel calls fn for 0..n-1, at most parallel calls at a time, and returns once all are
+// done. fn writes its result to index i of a slice, so the order of the results doesn't depend
+// on which call finishes first.
+func forEachParallel(n, parallel int, fn func(i int)) {
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy modes: off skips a rule, warn reports its violations, block also refuses the deploy
+const (
+	PolicyOff   = "off"
+	PolicyWarn  = "warn"
+	PolicyBlock = "block"
+)
+
+// policyRule is a built-in policy checked on every service of a stack file
+type policyRule struct {
+	Name        string
+	Description string
+	check       func(name string, service map[string]interface{}, stack *policyStack) []string
+}
+
+// policyStack is the part of a stack file the built-in rules need besides the service
+type policyStack struct {
+	internal map[string]bool // services listed in x-composectl.internal
+}
+
+// policyRules are the built-in policies. Each is configured by policy_<name> (dashes as
+// underscores), falling back to the policy config key (default warn).
+var policyRules = []policyRule{
+	{"privileged", "services must not run privileged", func(name string, service map[string]interface{}, _ *policyStack) []string {
+		if privileged, _ := service["privileged"].(bool); privileged {
+			return []string{"runs privileged"}
+		}
+		return nil
+	}},
+	{"host-network", "services must not use the host network", func(name string, service map[string]interface{}, _ *policyStack) []string {
+		if mode, _ := service["network_mode"].(string); mode == "host" {
+			return []string{"uses the host network"}
+		}
+		return nil
+	}},
+	{"public-bind", "services in x-composectl.internal must not publish ports on all addresses", func(name string, service map[string]interface{}, stack *policyStack) []string {
+		if !stack.internal[name] {
+			return nil
+		}
+		var violations []string
+		ports, _ := service["ports"].([]interface{})
+		for _, port := range ports {
+			hostIP, published := "", true
+			switch p := port.(type) {
+			case string:
+				hostIP = compose.ParsePort(p).HostIP
+			case int:
+			case map[string]interface{}:
+				hostIP, _ = p["host_ip"].(string)
+				published = p["published"] != nil
+			}
+			if published && (hostIP == "" || compose.IsWildcardIP(hostIP)) {
+				violations = append(violations, fmt.Sprintf("is internal but publishes port %v on all addresses", port))
+			}
+		}
+		return violations
+	}},
+	{"unpinned-image", "images must be pinned to a tag other than latest or a digest", func(name string, service map[string]interface{}, _ *policyStack) []string {
+		if image, _ := service["image"].(string); image != "" && !imagePinned(image) {
+			return []string{fmt.Sprintf("image %s is not pinned to a version", image)}
+		}
+		return nil
+	}},
+	{"resource-limits", "services must set a memory and a CPU limit", func(name string, service map[string]interface{}, _ *policyStack) []string {
+		limits := map[string]interface{}{}
+		if deploy, ok := service["deploy"].(map[string]interface{}); ok {
+			if resources, ok := deploy["resources"].(map[string]interface{}); ok {
+				limits, _ = resources["limits"].(map[string]interface{})
+			}
+		}
+		var missing []string
+		if service["mem_limit"] == nil && limits["memory"] == nil {
+			missing = append(missing, "memory")
+		}
+		if service["cpus"] == nil && limits["cpus"] == nil {
+			missing = append(missing, "CPU")
+		}
+		if len(missing) > 0 {
+			return []string{fmt.Sprintf("sets no %s limit", strings.Join(missing, " or "))}
+		}
+		return nil
+	}},
+}
+
+// policyMode returns the mode of a rule: policy_<rule>, else policy, else warn
+func policyMode(rule string) string {
+	mode := strings.ToLower(getConfig("policy", PolicyWarn))
+	mode = strings.ToLower(getConfig("policy_"+strings.ReplaceAll(rule, "-", "_"), mode))
+	switch mode {
+	case PolicyOff, PolicyBlock:
+		return mode
+	default:
+		return PolicyWarn
+	}
+}
+
+// policyFinding turns a violation of a rule into a finding: an error when the rule blocks, else
+// a warning. The check of the finding is policy:<rule>.
+func policyFinding(rule, service, mode, message string) LintFinding {
+	severity := SeverityWarning
+	if mode == PolicyBlock {
+		severity = SeverityError
+	}
+	return LintFinding{Service: service, Severity: severity, Check: "policy:" + rule, Message: message}
+}
+
+// evaluatePolicies checks a stack file against the built-in rules and the rego policies of
+// policy_dir. It looks at the file as written, so settings dc doesn't model are checked too.
+func evaluatePolicies(content []byte, stackName string) []LintFinding {
+	var composeFile map[string]interface{}
+	if err := yaml.Unmarshal(content, &composeFile); err != nil {
+		return []LintFinding{{Severity: SeverityError, Check: "schema", Message: fmt.Sprintf("invalid YAML: %v", err)}}
+	}
+	services, _ := composeFile["services"].(map[string]interface{})
+	stack := &policyStack{internal: map[string]bool{}}
+	if extension, ok := composeFile["x-composectl"].(map[string]interface{}); ok {
+		internal, _ := extension["internal"].([]interface{})
+		for _, name := range internal {
+			stack.internal[fmt.Sprint(name)] = true
+		}
+	}
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var findings []LintFinding
+	for _, rule := range policyRules {
+		mode := policyMode(rule.Name)
+		if mode == PolicyOff {
+			continue
+		}
+		for _, name := range names {
+			service, _ := services[name].(map[string]interface{})
+			for _, violation := range rule.check(name, service, stack) {
+				findings = append(findings, policyFinding(rule.Name, name, mode, violation))
+			}
+		}
+	}
+	return append(findings, evaluateRegoPolicies(composeFile, stackName)...)
+}
+
+// regoResult is the part of `opa eval --format json` output holding the value of data.composectl
+type regoResult struct {
+	Result []struct {
+		Expressions []struct {
+			Value struct {
+				Deny []string `json:"deny"`
+				Warn []string `json:"warn"`
+			} `json:"value"`
+		} `json:"expressions"`
+	} `json:"result"`
+}
+
+// evaluateRegoPolicies evaluates the *.rego files of policy_dir (default <stacks dir>/policies)
+// with the opa binary. Policies are written in package composectl and get the stack name and
+// the stack file as input.stack and input.compose; messages in deny block the deploy (unless
+// policy is off) and messages in warn are reported.
+func evaluateRegoPolicies(composeFile map[string]interface{}, stackName string) []LintFinding {
+	mode := strings.ToLower(getConfig("policy", PolicyWarn))
+	dir := getConfig("policy_dir", filepath.Join(StacksDir, "policies"))
+	files, _ := filepath.Glob(filepath.Join(dir, "*.rego"))
+	if mode == PolicyOff || len(files) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath("opa"); err != nil {
+		return []LintFinding{policyFinding("rego", "", PolicyWarn, fmt.Sprintf("%d rego policies in %s skipped, opa is not installed", len(files), dir))}
+	}
+
+	input, err := json.Marshal(map[string]interface{}{"stack": stackName, "compose": composeFile})
+	if err != nil {
+		return []LintFinding{policyFinding("rego", "", PolicyBlock, fmt.Sprintf("cannot pass the stack file to opa: %v", err))}
+	}
+	cmd := exec.Command("opa", "eval", "--format", "json", "--stdin-input", "--data", dir, "data.composectl")
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return []LintFinding{policyFinding("rego", "", PolicyBlock, fmt.Sprintf("opa eval failed: %v %s", err, strings.TrimSpace(stderr.String())))}
+	}
+	var result regoResult
+	if err := json.Unmarshal(out, &result); err != nil {
+		return []LintFinding{policyFinding("rego", "", PolicyBlock, fmt.Sprintf("unexpected opa output: %v", err))}
+	}
+
+	var findings []LintFinding
+	for _, r := range result.Result {
+		for _, expression := range r.Expressions {
+			for _, message := range expression.Value.Deny {
+				findings = append(findings, policyFinding("rego", "", PolicyBlock, message))
+			}
+			for _, message := range expression.Value.Warn {
+				findings = append(findings, policyFinding("rego", "", PolicyWarn, message))
+			}
+		}
+	}
+	return findings
+}
+
+// enforcePolicies reports the policy findings of a stack file before a deploy and fails if a
+// blocking policy is violated
+func enforcePolicies(content []byte, stackName string) error {
+	findings := evaluatePolicies(content, stackName)
+	for _, f := range findings {
+		f.File = stackName
+		policyLog.Warn(f.Message, "stack", stackName, "service", f.Service, "check", f.Check, "severity", f.Severity)
+	}
+	if hasLintErrors(findings) {
+		return fmt.Errorf("deploy of stack %s blocked by policy", stackName)
+	}
+	return nil
+}
+
+// HandlePolicyCheck checks compose files (or stdin for "-") against the policies, printed like
+// lint findings, and fails if a blocking policy is violated
+func HandlePolicyCheck(files []string) error {
+	var findings []LintFinding
+	for _, file := range files {
+		var content []byte
+		var err error
+		if file == "-" {
+			content, err = io.ReadAll(os.Stdin)
+		} else {
+			content, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return err
+		}
+		stackName := strings.TrimSuffix(filepath.Base(file), ".yml")
+		for _, f := range evaluatePolicies(content, stackName) {
+			if file != "-" {
+				f.File = file
+			}
+			findings = append(findings, f)
+		}
+	}
+	if err := printLintFindings(findings); err != nil {
+		return err
+	}
+	if hasLintErrors(findings) {
+		return fmt.Errorf("policy check failed")
+	}
+	return nil
+}
+
+// HandlePolicyRules prints the built-in rules with their configured mode
+func HandlePolicyRules() error {
+	type ruleInfo struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Mode        string `json:"mode"`
+	}
+	rules := []ruleInfo{}
+	for _, rule := range policyRules {
+		rules = append(rules, ruleInfo{Name: rule.Name, Description: rule.Description, Mode: policyMode(rule.Name)})
+	}
+	return json.NewEncoder(os.Stdout).Encode(rules)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dc/internal/compose"
+)
+
+// PublishAuto is the x-dc-publish value of services whose host ports dc assigns
+const PublishAuto = "auto"
+
+// defaultPortRange is the range host ports are assigned from (config key port_range)
+const defaultPortRange = "20000-29999"
+
+// portAllocationsPath returns the file recording the assigned host ports (config key
+// port_allocations)
+func portAllocationsPath() string {
+	return getConfig("port_allocations", filepath.Join(StacksDir, ".ports.json"))
+}
+
+// portRange returns the first and last port of port_range
+func portRange() (int, int, error) {
+	value := getConfig("port_range", defaultPortRange)
+	first, last, ok := strings.Cut(value, "-")
+	low, err1 := strconv.Atoi(strings.TrimSpace(first))
+	high, err2 := strconv.Atoi(strings.TrimSpace(last))
+	if !ok || err1 != nil || err2 != nil || low < 1 || high > 65535 || low > high {
+		return 0, 0, fmt.Errorf("invalid port_range %q, expected <first>-<last>", value)
+	}
+	return low, high, nil
+}
+
+// lockFile serializes the dc processes changing a file, e.g. those of a bulk up, with a lock
+// file next to it and returns the function releasing the lock. A lock older than a minute is
+// left over by a crash.
+func lockFile(path string) (func(), error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > time.Minute {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for %s", lock)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// readPortAllocations reads the assigned host ports by <stack>/<service>/<container port>[/<protocol>]
+func readPortAllocations() (map[string]int, error) {
+	allocations := make(map[string]int)
+	data, err := os.ReadFile(portAllocationsPath())
+	if os.IsNotExist(err) {
+		return allocations, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &allocations); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", portAllocationsPath(), err)
+	}
+	return allocations, nil
+}
+
+// writePortAllocations replaces the file of assigned host ports
+func writePortAllocations(allocations map[string]int) error {
+	data, err := json.MarshalIndent(allocations, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(portAllocationsPath(), data)
+}
+
+// replaceFile writes a file through a temporary file, so readers never see it half written
+func replaceFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// hostPortFree reports whether nothing listens on a TCP port of the host
+func hostPortFree(port int) bool {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// allocateHostPorts publishes the services marked x-dc-publish: auto on host ports of
+// port_range: mappings without a host port ("80" or "127.0.0.1::80") get one, and a service
+// without mappings gets one for its HTTP port, which the proxy labels then route to. A port
+// stays assigned to its stack, service and container port until the stack is removed, so it is
+// the same on every deploy; assignments of mappings the stack no longer has are released. Dry
+// runs show the ports but record nothing.
+func allocateHostPorts(composeFile *compose.File, stackName string) error {
+	var names []string
+	for name, service := range composeFile.Services {
+		if service.Publish == PublishAuto {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return releaseHostPorts(stackName)
+	}
+	sort.Strings(names)
+	low, high, err := portRange()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(portAllocationsPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	allocations, err := readPortAllocations()
+	if err != nil {
+		return err
+	}
+	taken := make(map[int]bool)
+	for _, port := range allocations {
+		taken[port] = true
+	}
+
+	prefix := stackName + "/"
+	used := make(map[string]bool)
+	changed := false
+	next := low
+	for _, name := range names {
+		service := composeFile.Services[name]
+		if len(service.Ports) == 0 {
+			if port, _, ok := compose.DetectHTTPPort(&service); ok {
+				service.Ports = []string{port}
+			}
+		}
+		for i, mapping := range service.Ports {
+			spec := compose.ParsePort(mapping)
+			if spec.HostPort != "" || strings.Contains(spec.ContainerPort, "-") {
+				continue
+			}
+			key := prefix + name + "/" + spec.ContainerPort
+			if spec.Protocol != "" {
+				key += "/" + spec.Protocol
+			}
+			used[key] = true
+			port, ok := allocations[key]
+			if !ok {
+				for ; next <= high && (taken[next] || !hostPortFree(next)); next++ {
+				}
+				if next > high {
+					return fmt.Errorf("no free host port left in port_range %d-%d", low, high)
+				}
+				port = next
+				allocations[key] = port
+				taken[port] = true
+				changed = true
+				enrichLog.Info("Assigned host port", "stack", stackName, "service", name, "container_port", spec.ContainerPort, "host_port", port)
+			}
+			spec.HostPort = strconv.Itoa(port)
+			service.Ports[i] = spec.String()
+		}
+		composeFile.Services[name] = service
+	}
+
+	for key := range allocations {
+		if strings.HasPrefix(key, prefix) && !used[key] {
+			enrichLog.Info("Released host port", "stack", stackName, "mapping", strings.TrimPrefix(key, prefix), "host_port", allocations[key])
+			delete(allocations, key)
+			changed = true
+		}
+	}
+	if DryRun || !changed {
+		return nil
+	}
+	return writePortAllocations(allocations)
+}
+
+// releaseHostPorts releases the host ports assigned to the services of a stack, e.g. when it is
+// removed or no longer has services published with x-dc-publish: auto
+func releaseHostPorts(stackName string) error {
+	prefix := stackName + "/"
+	owned := func(allocations map[string]int) bool {
+		for key := range allocations {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	// Most stacks never had ports assigned, so look before taking the lock
+	if allocations, err := readPortAllocations(); err != nil || !owned(allocations) {
+		return err
+	}
+
+	unlock, err := lockFile(portAllocationsPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	allocations, err := readPortAllocations()
+	if err != nil || !owned(allocations) {
+		return err
+	}
+	for key, port := range allocations {
+		if strings.HasPrefix(key, prefix) {
+			enrichLog.Info("Released host port", "stack", stackName, "mapping", strings.TrimPrefix(key, prefix), "host_port", port)
+			delete(allocations, key)
+		}
+	}
+	if DryRun {
+		return nil
+	}
+	return writePortAllocations(allocations)
+}
@@ -0,0 +1,18 @@
+package main
+
+import "dc/internal/compose"
+
+// ensureDualStackPorts applies compose.DualStackPorts to all services when dual_stack_ports is
+// enabled
+func ensureDualStackPorts(composeFile *compose.File) {
+	if composeFile == nil || !getConfigBool("dual_stack_ports", false) {
+		return
+	}
+	for serviceName, service := range composeFile.Services {
+		if len(service.Ports) == 0 {
+			continue
+		}
+		service.Ports = compose.DualStackPorts(service.Ports)
+		composeFile.Services[serviceName] = service
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"dc/internal/compose"
+)
+
+// defaultProbeImage is a small image providing nslookup, nc and wget
+const defaultProbeImage = "busybox:latest"
+
+// runPreflightCheck executes a single check in a throw-away probe container
+func runPreflightCheck(check compose.PreflightCheck) error {
+	probe, err := check.ProbeCommand()
+	if err != nil {
+		return err
+	}
+	args := []string{"run", "--rm"}
+	if check.Network != "" {
+		args = append(args, "--network", check.Network)
+	}
+	args = append(args, getConfig("probe_image", defaultProbeImage))
+	args = append(args, probe...)
+
+	output, err := engineCommand(args...).CombinedOutput()
+	if err != nil {
+		detail := strings.TrimSpace(string(output))
+		if detail == "" {
+			detail = err.Error()
+		}
+		return fmt.Errorf("%s: %s", strings.Join(probe, " "), detail)
+	}
+	return nil
+}
+
+// runPreflightChecks runs all x-composectl.preflight checks of a stack and fails on the
+// first unreachable dependency. Disabled with --skip-preflight=true.
+func runPreflightChecks(composeFile *compose.File, stackName string) error {
+	if composeFile.Composectl == nil || len(composeFile.Composectl.Preflight) == 0 {
+		return nil
+	}
+	if getFlagBool("skip_preflight", false) {
+		preflightLog.Info("Skipping preflight checks", "stack", stackName)
+		return nil
+	}
+
+	for _, check := range composeFile.Composectl.Preflight {
+		preflightLog.Info("Preflight check", "check", check.Describe())
+		if err := runPreflightCheck(check); err != nil {
+			preflightLog.Debug("Preflight check failed", "check", check.Describe(), "stack", stackName, "err", err)
+			return fmt.Errorf("preflight check %s failed: %v", check.Describe(), err)
+		}
+		preflightLog.Info("Preflight check passed", "check", check.Describe())
+	}
+	return nil
+}
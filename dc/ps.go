@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dc/internal/docker"
+)
+
+// ContainerSummary is the compact view of a container printed by `dc stack ps`
+type ContainerSummary struct {
+	Container string   `json:"container"`
+	Service   string   `json:"service"`
+	State     string   `json:"state"`
+	Health    string   `json:"health,omitempty"`   // only set for containers with a healthcheck
+	ExitCode  int      `json:"exitCode,omitempty"` // of exited containers
+	Ports     []string `json:"ports"`              // published ports as host-ip:host-port->port/proto
+	Image     string   `json:"image"`
+	Uptime    string   `json:"uptime,omitempty"` // of running containers
+	Logs      []string `json:"logs,omitempty"`   // the last lines of output with --logs=<n>
+}
+
+// maxPsLogLines caps the log lines per container of `dc stack ps --logs=<n>`
+const maxPsLogLines = 1000
+
+// StackHealthSummary counts the containers of a stack by state
+type StackHealthSummary struct {
+	Total     int `json:"total"`
+	Running   int `json:"running"`
+	Healthy   int `json:"healthy"`
+	Unhealthy int `json:"unhealthy"`
+	Exited    int `json:"exited"`
+}
+
+// StackPs is the output of `dc stack ps`
+type StackPs struct {
+	Stack      string             `json:"stack"`
+	Summary    StackHealthSummary `json:"summary"`
+	Containers []ContainerSummary `json:"containers"`
+}
+
+// publishedPorts formats the published ports of a container, sorted
+func publishedPorts(c docker.Inspect) []string {
+	ports := []string{}
+	for port, bindings := range c.NetworkSettings.Ports {
+		for _, binding := range bindings {
+			host := binding.HostPort
+			if binding.HostIP != "" {
+				host = binding.HostIP + ":" + host
+			}
+			ports = append(ports, host+"->"+port)
+		}
+	}
+	sort.Strings(ports)
+	return ports
+}
+
+// summarizeContainer turns the inspect data of a container into its compact view
+func summarizeContainer(c docker.Inspect, now time.Time) ContainerSummary {
+	summary := ContainerSummary{
+		Container: strings.TrimPrefix(c.Name, "/"),
+		Service:   c.Config.Labels["com.docker.compose.service"],
+		State:     c.State.Status,
+		Ports:     publishedPorts(c),
+		Image:     c.Config.Image,
+	}
+	if c.State.Health != nil {
+		summary.Health = c.State.Health.Status
+	}
+	if c.State.Status == "exited" {
+		summary.ExitCode = c.State.ExitCode
+	}
+	if started, err := time.Parse(time.RFC3339Nano, c.State.StartedAt); err == nil && c.State.Running {
+		summary.Uptime = now.Sub(started).Round(time.Second).String()
+	}
+	return summary
+}
+
+// collectStackPs inspects the containers of a stack, running or not
+func collectStackPs(stackName string) (*StackPs, error) {
+	out, err := engineCommand("ps", "-aq", "--filter", "label=com.docker.compose.project="+stackName).Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker ps failed: %w", err)
+	}
+	containers, err := inspectContainers(strings.Fields(string(out)))
+	if err != nil {
+		return nil, err
+	}
+
+	ps := &StackPs{Stack: stackName, Containers: []ContainerSummary{}}
+	now := time.Now()
+	for _, c := range containers {
+		summary := summarizeContainer(c, now)
+		ps.Containers = append(ps.Containers, summary)
+		ps.Summary.Total++
+		switch {
+		case c.State.Running:
+			ps.Summary.Running++
+		case summary.State == "exited":
+			ps.Summary.Exited++
+		}
+		switch summary.Health {
+		case "healthy":
+			ps.Summary.Healthy++
+		case "unhealthy":
+			ps.Summary.Unhealthy++
+		}
+	}
+	sort.Slice(ps.Containers, func(i, j int) bool {
+		if ps.Containers[i].Service != ps.Containers[j].Service {
+			return ps.Containers[i].Service < ps.Containers[j].Service
+		}
+		return ps.Containers[i].Container < ps.Containers[j].Container
+	})
+	return ps, nil
+}
+
+// containerLogTail returns the last lines of the output of a container, redacted
+func containerLogTail(container string, lines int) ([]string, error) {
+	out, err := engineCommand("logs", "--tail", strconv.Itoa(lines), container).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("docker logs %s failed: %w", container, err)
+	}
+	tail := []string{}
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if line != "" {
+			tail = append(tail, redactText(line))
+		}
+	}
+	return tail, nil
+}
+
+// HandleStackPs prints the containers of a stack with their state, health, published ports,
+// image and uptime as JSON: a lighter view than the inspect data of `dc stack ls`. With
+// --logs=<n> every container also carries its last n lines of output.
+func HandleStackPs(stackName string) error {
+	ps, err := collectStackPs(stackName)
+	if err != nil {
+		return err
+	}
+	if lines, _ := strconv.Atoi(getFlag("logs", "0")); lines > 0 {
+		lines = min(lines, maxPsLogLines)
+		forEachParallel(len(ps.Containers), listParallelism(), func(i int) {
+			c := &ps.Containers[i]
+			logs, err := containerLogTail(c.Container, lines)
+			if err != nil {
+				stackLog.Warn("Failed to read container logs", "container", c.Container, "err", err)
+				return
+			}
+			c.Logs = logs
+		})
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false) // keep the -> of the ports readable
+	return encoder.Encode(ps)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"dc/internal/compose"
+)
+
+// defaultMinFreeDisk is the free space required on the Docker data root before pulling images
+const defaultMinFreeDisk = "2g"
+
+// parseByteSize parses sizes like "512m", "2g", "1.5G", "1024" (bytes) into a byte count
+func parseByteSize(s string) (uint64, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	s = strings.TrimSuffix(s, "b")
+	multiplier := float64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "g"):
+		multiplier = 1 << 30
+	case strings.HasSuffix(s, "t"):
+		multiplier = 1 << 40
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return uint64(value * multiplier), nil
+}
+
+// formatByteSize renders a byte count in a human readable form (e.g. "1.5G")
+func formatByteSize(n uint64) string {
+	units := []string{"", "K", "M", "G", "T"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.1f%s", value, units[i])
+}
+
+// getDockerRootDir returns the Docker data root (where images are stored)
+func getDockerRootDir() string {
+	out, err := engineCommand("info", "--format", "{{.DockerRootDir}}").Output()
+	if err == nil {
+		if dir := strings.TrimSpace(string(out)); dir != "" {
+			return dir
+		}
+	}
+	return "/var/lib/docker"
+}
+
+// checkFreeDiskSpace verifies that the Docker data root has at least the configured
+// amount of free space (min_free_disk, default 2g). A threshold of 0 disables the check.
+func checkFreeDiskSpace() error {
+	threshold, err := parseByteSize(getConfig("min_free_disk", defaultMinFreeDisk))
+	if err != nil {
+		return fmt.Errorf("invalid min_free_disk: %w", err)
+	}
+	if threshold == 0 {
+		return nil
+	}
+
+	rootDir := getDockerRootDir()
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(rootDir, &stat); err != nil {
+		// Rootless or remote daemons may not expose the data root to us; don't block the deploy
+		stackLog.Debug("Cannot stat the Docker root dir, skipping disk space check", "dir", rootDir, "err", err)
+		stackLog.Info("Skipping disk space check, cannot stat the Docker root dir", "dir", rootDir)
+		return nil
+	}
+
+	free := uint64(stat.Bavail) * uint64(stat.Bsize)
+	if free < threshold {
+		return fmt.Errorf("not enough free disk space on %s: %s available, %s required (min_free_disk)",
+			rootDir, formatByteSize(free), formatByteSize(threshold))
+	}
+	stackLog.Info("Free disk space", "dir", rootDir, "free", formatByteSize(free), "required", formatByteSize(threshold))
+	return nil
+}
+
+// pullImages pulls every image referenced by the compose file, streaming docker's
+// per-layer progress. Images are pulled one by one so a failure names the culprit.
+func pullImages(composeFile *compose.File) error {
+	images := make(map[string]bool)
+	for _, service := range composeFile.Services {
+		if service.Image != "" {
+			images[service.Image] = true
+		}
+	}
+	sorted := make([]string, 0, len(images))
+	for image := range images {
+		sorted = append(sorted, image)
+	}
+	sort.Strings(sorted)
+
+	for i, image := range sorted {
+		stackLog.Info("Pulling image", "image", image, "n", i+1, "of", len(sorted))
+		if err := runRetried(engineCommand("pull", image), "pull"); err != nil {
+			return fmt.Errorf("failed to pull image %s: %w", image, err)
+		}
+	}
+	return nil
+}
+
+// prePullStack runs the optional pre-deploy checks for "up": verify free disk space
+// and pull all images. Enabled via pull_before_up (e.g. --pull-before-up=true).
+func prePullStack(composeFile *compose.File, stackName string) error {
+	if !getConfigBool("pull_before_up", false) {
+		return nil
+	}
+	stackLog.Debug("Pre-pulling images", "stack", stackName)
+	if err := checkFreeDiskSpace(); err != nil {
+		return err
+	}
+	return pullImages(composeFile)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"dc/internal/compose"
+	"dc/internal/docker"
+)
+
+// reconstructStack rebuilds a compose YAML for the stack from its containers, running or
+// stopped. The result is a best guess and starts with a comment saying so.
+func reconstructStack(stackName string) (string, error) {
+	out, err := engineCommand("ps", "-qa",
+		"--filter", "label=com.docker.compose.project="+stackName).Output()
+	if err != nil {
+		return "", fmt.Errorf("docker ps -qa: %w", err)
+	}
+
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if id := strings.TrimSpace(line); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("no containers found for stack %q", stackName)
+	}
+
+	inspectData, err := inspectContainers(ids)
+	if err != nil {
+		return "", fmt.Errorf("docker inspect: %w", err)
+	}
+	yamlContent, err := reconstructComposeFromContainers(inspectData, stackName)
+	if err != nil {
+		return "", fmt.Errorf("reconstruction: %w", err)
+	}
+	return yamlContent, nil
+}
+
+// imageConfig returns the config the containers of an image start with, or an empty one if the
+// image can't be inspected (e.g. it was removed), in which case nothing counts as a default
+func imageConfig(image string) docker.ContainerConfig {
+	out, err := engineCommand("image", "inspect", image).Output()
+	if err != nil {
+		stackLog.Debug("Cannot inspect image, keeping all values", "image", image, "err", err)
+		return docker.ContainerConfig{}
+	}
+	var images []docker.ImageInspect
+	if err := json.Unmarshal(out, &images); err != nil || len(images) == 0 {
+		return docker.ContainerConfig{}
+	}
+	return images[0].Config
+}
+
+// escapeAllDollars escapes the $ of literal values from docker for the stack file
+func escapeAllDollars(values []string) []string {
+	escaped := make([]string, len(values))
+	for i, value := range values {
+		escaped[i] = compose.EscapeDollars(value)
+	}
+	return escaped
+}
+
+// reconstructHostConfig sets the service fields docker keeps in the host config: capabilities,
+// sysctls, extra hosts, devices and logging. A logging config is left out if it's the engine's
+// plain json-file or the default dc gives services without one.
+func reconstructHostConfig(service *compose.Service, host docker.HostConfig) {
+	if len(host.CapabilityAdd) > 0 {
+		service.CapAdd = append([]string(nil), host.CapabilityAdd...)
+		sort.Strings(service.CapAdd)
+	}
+	if len(host.CapabilityDrop) > 0 {
+		service.CapDrop = append([]string(nil), host.CapabilityDrop...)
+		sort.Strings(service.CapDrop)
+	}
+	if len(host.Sysctls) > 0 {
+		service.Sysctls = host.Sysctls
+	}
+	if len(host.ExtraHosts) > 0 {
+		service.ExtraHosts = host.ExtraHosts
+	}
+	for _, device := range host.Devices {
+		spec := device.PathOnHost + ":" + device.PathInContainer
+		if device.CgroupPermissions != "" && device.CgroupPermissions != "rwm" {
+			spec += ":" + device.CgroupPermissions
+		}
+		service.Devices = append(service.Devices, spec)
+	}
+	if driver := host.LogConfig.Type; driver != "" {
+		logging := &compose.Logging{Driver: driver}
+		if len(host.LogConfig.Config) > 0 {
+			logging.Options = host.LogConfig.Config
+		}
+		plain := driver == defaultLogDriver && logging.Options == nil
+		if !plain && !reflect.DeepEqual(logging, defaultLogging()) {
+			service.Logging = logging
+		}
+	}
+}
+
+// reconstructHealthcheck returns the compose healthcheck of a container, nil if it has none or
+// the one of its image
+func reconstructHealthcheck(health, imageHealth *docker.HealthConfig) interface{} {
+	if health == nil || len(health.Test) == 0 || reflect.DeepEqual(health, imageHealth) {
+		return nil
+	}
+	if health.Test[0] == "NONE" {
+		return map[string]interface{}{"disable": true}
+	}
+	healthcheck := map[string]interface{}{"test": escapeAllDollars(health.Test)}
+	for key, nanoseconds := range map[string]int64{
+		"interval":       health.Interval,
+		"timeout":        health.Timeout,
+		"start_period":   health.StartPeriod,
+		"start_interval": health.StartInterval,
+	} {
+		if nanoseconds > 0 {
+			healthcheck[key] = time.Duration(nanoseconds).String()
+		}
+	}
+	if health.Retries > 0 {
+		healthcheck["retries"] = health.Retries
+	}
+	return healthcheck
+}
+
+// reconstructTarget returns where `dc stack reconstruct --write` puts the file: over a broken
+// symlink of the stack, else the usual place of a new stack file. An existing stack file is
+// only replaced with --force=true.
+func reconstructTarget(stackName string) (string, error) {
+	for _, path := range stackFileCandidates(stackName) {
+		if isBrokenSymlink(path) {
+			return path, nil
+		}
+		if _, err := os.Stat(path); err == nil {
+			if !getFlagBool("force", false) {
+				return "", fmt.Errorf("%s", msg("stack_exists", stackName, path))
+			}
+			return path, nil
+		}
+	}
+	return filepath.Join(getFirstWritableStackDir(), stackName+".yml"), nil
+}
+
+// HandleReconstructStack prints a compose YAML for the stack reconstructed from its
+// containers. With --write=true it writes it as the stack file, replacing a broken symlink.
+func HandleReconstructStack(stackName string) error {
+	if err := validateStackName(stackName); err != nil {
+		return err
+	}
+	content, err := reconstructStack(stackName)
+	if err != nil {
+		return err
+	}
+	if !getFlagBool("write", false) {
+		os.Stdout.WriteString(content)
+		return nil
+	}
+
+	dest, err := reconstructTarget(stackName)
+	if err != nil {
+		return err
+	}
+	if DryRun {
+		var current []byte
+		if existing, err := os.ReadFile(dest); err == nil {
+			current = existing
+		}
+		fmt.Fprintln(os.Stdout, msg("dry_run_header"))
+		os.Stdout.WriteString(unifiedDiff(string(current), content, dest, dest))
+		return nil
+	}
+	// Writing through a broken symlink would create its target, so the link goes first
+	if isBrokenSymlink(dest) {
+		if err := os.Remove(dest); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(dest), err)
+	}
+	if err := writeStackFile(stackName, dest, []byte(content)); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", dest, err)
+	}
+	fmt.Fprintln(os.Stderr, msg("stack_reconstructed", stackName, dest))
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"dc/internal/secrets"
+)
+
+// Redaction levels (config key redaction):
+//   - off: output is passed through unchanged
+//   - standard: the prod.env values of secret keys and key=value credentials are masked (default)
+//   - paranoid: additionally replaces stack and service names by short hashes, so that
+//     diagnostics can be shared publicly
+const (
+	RedactionOff      = "off"
+	RedactionStandard = "standard"
+	RedactionParanoid = "paranoid"
+)
+
+// minRedactedSecretLength avoids masking short values like "1" or "yes" everywhere
+const minRedactedSecretLength = 4
+
+// credentialRe matches key=value / key: value pairs whose key looks like a credential
+var credentialRe = regexp.MustCompile(`(?i)\b([A-Z0-9_.-]*(?:PASSWORD|PASSWD|SECRET|TOKEN|API_?KEY|PRIVATE_KEY)[A-Z0-9_.-]*)(\s*[=:]\s*)("[^"]*"|'[^']*'|[^\s,;]+)`)
+
+// bearerRe matches HTTP bearer credentials
+var bearerRe = regexp.MustCompile(`(?i)\b(Bearer\s+)[A-Za-z0-9._~+/=-]+`)
+
+var redaction struct {
+	level   string // empty until initRedaction, which disables redaction
+	secrets []string
+
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+// initRedaction loads the redaction level and the secret values to mask; until it has run
+// redactText passes output through unchanged
+func initRedaction() {
+	switch level := strings.ToLower(getConfig("redaction", RedactionStandard)); level {
+	case RedactionOff, RedactionParanoid:
+		redaction.level = level
+	default:
+		redaction.level = RedactionStandard
+	}
+	if redaction.level == RedactionOff {
+		return
+	}
+	envVars, err := secrets.ReadEnvFile(ProdEnvPath)
+	if err != nil {
+		return
+	}
+	// Only values of secret keys: masking e.g. a port or "true" everywhere garbles the output
+	for key, value := range envVars {
+		if len(value) >= minRedactedSecretLength && secrets.IsSensitiveKey(key, value) {
+			redaction.secrets = append(redaction.secrets, value)
+		}
+	}
+	// Replace longer values first so that a value containing another is fully masked
+	sort.Slice(redaction.secrets, func(i, j int) bool { return len(redaction.secrets[i]) > len(redaction.secrets[j]) })
+}
+
+// redactName registers a stack or service name to be hashed in paranoid mode
+func redactName(name string) {
+	if name == "" {
+		return
+	}
+	redaction.mu.Lock()
+	defer redaction.mu.Unlock()
+	if redaction.names == nil {
+		redaction.names = make(map[string]bool)
+	}
+	redaction.names[name] = true
+}
+
+// hashName returns a stable short replacement for a name
+func hashName(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return "h:" + hex.EncodeToString(sum[:4])
+}
+
+// redactText applies the configured redaction level to a piece of output
+func redactText(s string) string {
+	level := redaction.level
+	if level == "" || level == RedactionOff || s == "" {
+		return s
+	}
+
+	for _, secret := range redaction.secrets {
+		s = strings.ReplaceAll(s, secret, "***")
+	}
+	s = credentialRe.ReplaceAllString(s, "$1$2***")
+	s = bearerRe.ReplaceAllString(s, "$1***")
+
+	if level == RedactionParanoid {
+		redaction.mu.Lock()
+		names := make([]string, 0, len(redaction.names))
+		for name := range redaction.names {
+			names = append(names, name)
+		}
+		redaction.mu.Unlock()
+		sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+		for _, name := range names {
+			s = regexp.MustCompile(`\b`+regexp.QuoteMeta(name)+`\b`).ReplaceAllString(s, hashName(name))
+		}
+	}
+	return s
+}
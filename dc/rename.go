@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// stackNameRe matches names accepted by docker compose as project names
+var stackNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// validateStackName rejects names that docker compose would refuse or that could escape StacksDir
+func validateStackName(name string) error {
+	if !stackNameRe.MatchString(name) {
+		return fmt.Errorf("invalid stack name %q: use lowercase letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// positionalArgs returns args without --key=value style flags
+func positionalArgs(args []string) []string {
+	var result []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			result = append(result, arg)
+		}
+	}
+	return result
+}
+
+// hasStackPrefix reports whether a container name is the stack name or starts with it and a
+// - or _ separator, so that renaming "web" leaves "webhook-relay" alone
+func hasStackPrefix(containerName, stackName string) bool {
+	rest, ok := strings.CutPrefix(containerName, stackName)
+	return ok && (rest == "" || rest[0] == '-' || rest[0] == '_')
+}
+
+// rewriteStackIdentity rewrites container names and compose project labels from oldName to newName.
+// When isolate is set (clone), container names that don't carry the old stack prefix get the
+// new stack name as prefix so the copy doesn't collide with the original containers. Services
+// without a container name are left alone: compose names their containers after the project,
+// and a fixed name would break deploy.replicas and --scale.
+func rewriteStackIdentity(composeFile *compose.File, oldName, newName string, isolate bool) {
+	for serviceName, service := range composeFile.Services {
+		switch {
+		case service.ContainerName == "":
+		case hasStackPrefix(service.ContainerName, oldName):
+			service.ContainerName = newName + strings.TrimPrefix(service.ContainerName, oldName)
+		case isolate:
+			service.ContainerName = newName + "-" + service.ContainerName
+		}
+
+		if service.Labels != nil {
+			flat := compose.LabelsToMap(service.Labels)
+			if flat["com.docker.compose.project"] == oldName {
+				flat["com.docker.compose.project"] = newName
+				service.Labels = compose.MapToLabels(flat, service.Labels)
+			}
+		}
+		composeFile.Services[serviceName] = service
+	}
+}
+
+// rewriteStackFile reads a stack file, rewrites its identity and writes it to dest
+func rewriteStackFile(src, dest, oldName, newName string, isolate bool) ([]byte, error) {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	var composeFile compose.File
+	if err := yaml.Unmarshal(content, &composeFile); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", src, err)
+	}
+	rewriteStackIdentity(&composeFile, oldName, newName, isolate)
+
+	var buf strings.Builder
+	if err := encodeYAMLWithMultiline(&buf, &composeFile); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(dest, []byte(buf.String()), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+	return []byte(buf.String()), nil
+}
+
+// copyStack copies the .yml, .effective.yml and metadata of a stack to a new name and returns the new
+// original YAML. If move is set the source files are removed afterwards (rename).
+func copyStack(oldName, newName string, move bool) ([]byte, error) {
+	if err := validateStackName(newName); err != nil {
+		return nil, err
+	}
+	if oldName == newName {
+		return nil, fmt.Errorf("source and target stack names are identical")
+	}
+
+	_, srcPath, err := findYAML(oldName)
+	if err != nil {
+		return nil, err
+	}
+	destPath := filepath.Join(filepath.Dir(srcPath), newName+".yml")
+	for _, p := range []string{destPath, GetStackPath(newName, false)} {
+		if _, err := os.Stat(p); err == nil {
+			return nil, fmt.Errorf("stack %q already exists at %s", newName, p)
+		}
+	}
+
+	if DryRun {
+		fmt.Fprintf(os.Stdout, "%s\n# Would write %s\n", msg("dry_run_header"), destPath)
+		if move {
+			fmt.Fprintf(os.Stdout, "# Would remove %s\n", srcPath)
+		}
+		return nil, nil
+	}
+
+	body, err := rewriteStackFile(srcPath, destPath, oldName, newName, !move)
+	if err != nil {
+		return nil, err
+	}
+	stackLog.Info("Wrote", "path", destPath)
+
+	srcEffective := GetStackPath(oldName, true)
+	if _, err := os.Stat(srcEffective); err == nil {
+		destEffective := GetStackPath(newName, true)
+		if _, err := rewriteStackFile(srcEffective, destEffective, oldName, newName, !move); err != nil {
+			return nil, err
+		}
+		stackLog.Info("Wrote", "path", destEffective)
+		if move {
+			if err := os.Remove(srcEffective); err != nil {
+				stackLog.Debug("Failed to remove", "path", srcEffective, "err", err)
+			}
+		}
+	}
+
+	if err := copyStackMeta(oldName, newName, move); err != nil {
+		return nil, fmt.Errorf("failed to copy the metadata of %s: %w", oldName, err)
+	}
+
+	if move {
+		if err := os.Remove(srcPath); err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", srcPath, err)
+		}
+	}
+	return body, nil
+}
+
+// HandleRenameStack renames a stack's files. With recreate (--recreate=true) the old
+// project is taken down and the stack is brought up under its new project name.
+func HandleRenameStack(oldName, newName string) error {
+	var oldBody []byte
+	recreate := getFlagBool("recreate", false)
+	if recreate {
+		body, _, err := findYAML(oldName)
+		if err != nil {
+			return err
+		}
+		oldBody = body
+	}
+
+	newBody, err := copyStack(oldName, newName, true)
+	if err != nil {
+		return err
+	}
+	if DryRun {
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, msg("stack_renamed", oldName, newName))
+
+	if recreate {
+		if err := HandleDockerComposeFile(oldBody, oldName, false, compose.ActionDown); err != nil {
+			return err
+		}
+		return HandleDockerComposeFile(newBody, newName, false, compose.ActionUp)
+	}
+	return nil
+}
+
+// HandleCloneStack copies a stack under a new name. With recreate (--recreate=true)
+// the clone is brought up immediately.
+func HandleCloneStack(oldName, newName string) error {
+	newBody, err := copyStack(oldName, newName, false)
+	if err != nil {
+		return err
+	}
+	if DryRun {
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, msg("stack_cloned", oldName, newName))
+
+	if getFlagBool("recreate", false) {
+		return HandleDockerComposeFile(newBody, newName, false, compose.ActionUp)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"dc/internal/compose"
+)
+
+func TestRewriteStackIdentity(t *testing.T) {
+	tests := []struct {
+		containerName string
+		isolate       bool
+		want          string
+	}{
+		{"web", false, "site"},
+		{"web-app", false, "site-app"},
+		{"web_app_1", false, "site_app_1"},
+		{"webhook-relay", false, "webhook-relay"},
+		{"webhook-relay", true, "site-webhook-relay"},
+		{"", true, ""},
+		{"", false, ""},
+	}
+	for _, tt := range tests {
+		composeFile := compose.File{Services: map[string]compose.Service{"app": {ContainerName: tt.containerName}}}
+		rewriteStackIdentity(&composeFile, "web", "site", tt.isolate)
+		if got := composeFile.Services["app"].ContainerName; got != tt.want {
+			t.Errorf("container %q (isolate %v) renamed to %q, want %q", tt.containerName, tt.isolate, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"dc/internal/compose"
+)
+
+// mountInfoPath lists the mount points visible to dc
+const mountInfoPath = "/proc/self/mountinfo"
+
+// unescapeMountPath decodes the octal escapes (\040 for space) of /proc/self/mountinfo
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if n, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(path[i])
+	}
+	return sb.String()
+}
+
+// mountFSType returns the filesystem type mounted at path, or "" if path is not a mount point
+func mountFSType(path string) (string, error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		path = "/"
+	}
+	fsType := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// <id> <parent> <major:minor> <root> <mount point> <options> [optional...] - <fstype> <source> <super options>
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || unescapeMountPath(fields[4]) != path {
+			continue
+		}
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) {
+				fsType = fields[i+1] // later mounts shadow earlier ones
+			}
+		}
+	}
+	return fsType, scanner.Err()
+}
+
+// checkHostRequirement verifies a single requirement on the host
+func checkHostRequirement(r compose.HostRequirement) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	switch {
+	case r.Unit != "":
+		args := []string{"is-active", r.Unit}
+		if r.UserUnit {
+			args = append([]string{"--user"}, args...)
+		}
+		output, err := exec.Command("systemctl", args...).Output()
+		state := strings.TrimSpace(string(output))
+		if err != nil {
+			if state == "" {
+				state = err.Error()
+			}
+			return fmt.Errorf("%s is not active (%s)", r.Describe(), state)
+		}
+	case r.Mount != "":
+		fsType, err := mountFSType(r.Mount)
+		if err != nil {
+			return fmt.Errorf("cannot check %s: %v", r.Describe(), err)
+		}
+		if fsType == "" {
+			return fmt.Errorf("%s not mounted", r.Describe())
+		}
+	case r.Path != "":
+		if _, err := os.Stat(r.Path); os.IsNotExist(err) {
+			return fmt.Errorf("%s does not exist", r.Describe())
+		} else if err != nil {
+			return fmt.Errorf("cannot check %s: %v", r.Describe(), err)
+		}
+	}
+	return nil
+}
+
+// checkHostRequirements verifies all x-composectl.requires preconditions of a stack on the host
+// and reports every unmet one. Disabled with --skip-preflight=true.
+func checkHostRequirements(composeFile *compose.File, stackName string) error {
+	if composeFile.Composectl == nil || len(composeFile.Composectl.Requires) == 0 {
+		return nil
+	}
+	if getFlagBool("skip_preflight", false) {
+		preflightLog.Info("Skipping host requirements", "stack", stackName)
+		return nil
+	}
+
+	var failures []string
+	for _, requirement := range composeFile.Composectl.Requires {
+		if err := checkHostRequirement(requirement); err != nil {
+			preflightLog.Debug("Host requirement failed", "requirement", requirement.Describe(), "stack", stackName, "err", err)
+			preflightLog.Error(err.Error())
+			failures = append(failures, err.Error())
+			continue
+		}
+		preflightLog.Info("Host requirement met", "requirement", requirement.Describe())
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("host requirements not met: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
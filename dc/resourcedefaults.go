@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// ResourceDefault is an entry of the resource defaults table: the mem_limit and cpus given to
+// services of an image class that set none. Match is a pattern of the image name (e.g.
+// "postgres" or "ghcr.io/immich-app/*"), Label a label of the service ("key" or "key=value");
+// an entry with both needs both to match, an entry with neither matches every service.
+type ResourceDefault struct {
+	Match    string      `yaml:"match,omitempty"`
+	Label    string      `yaml:"label,omitempty"`
+	MemLimit string      `yaml:"mem_limit,omitempty"`
+	CPUs     interface{} `yaml:"cpus,omitempty"`
+}
+
+// defaultResourceDefaults are the built-in classes, tried in order. Databases and search
+// engines keep their working set in memory, media servers transcode.
+var defaultResourceDefaults = []ResourceDefault{
+	{Match: "postgres", MemLimit: "1g", CPUs: 1.0},
+	{Match: "postgis/postgis", MemLimit: "1g", CPUs: 1.0},
+	{Match: "mysql", MemLimit: "1g", CPUs: 1.0},
+	{Match: "mariadb", MemLimit: "1g", CPUs: 1.0},
+	{Match: "mongo", MemLimit: "1g", CPUs: 1.0},
+	{Match: "influxdb", MemLimit: "1g", CPUs: 1.0},
+	{Match: "clickhouse/clickhouse-server", MemLimit: "2g", CPUs: 1.0},
+	{Match: "elasticsearch", MemLimit: "2g", CPUs: 1.0},
+	{Match: "opensearchproject/opensearch", MemLimit: "2g", CPUs: 1.0},
+	{Match: "jellyfin/jellyfin", MemLimit: "2g", CPUs: 2.0},
+	{Match: "plexinc/pms-docker", MemLimit: "2g", CPUs: 2.0},
+	{MemLimit: "256m", CPUs: 0.5},
+}
+
+// getResourceDefaultsPath returns the file with the user-defined resource defaults (config key
+// resource_defaults_file). Its entries are tried before the built-in ones:
+//
+//   - match: ghcr.io/immich-app/immich-machine-learning
+//     mem_limit: 4g
+//     cpus: 2
+//   - label: com.example.class=database
+//     mem_limit: 1g
+func getResourceDefaultsPath() string {
+	return getConfig("resource_defaults_file", getSettingsPath("resource-defaults.yml"))
+}
+
+// loadResourceDefaults returns the user-defined resource defaults followed by the built-in ones
+func loadResourceDefaults() []ResourceDefault {
+	content, err := os.ReadFile(getResourceDefaultsPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			enrichLog.Debug("Failed to read the user-defined resource defaults", "path", getResourceDefaultsPath(), "err", err)
+		}
+		return defaultResourceDefaults
+	}
+	var custom []ResourceDefault
+	if err := yaml.Unmarshal(content, &custom); err != nil {
+		enrichLog.Warn("Failed to parse the user-defined resource defaults", "path", getResourceDefaultsPath(), "err", err)
+		return defaultResourceDefaults
+	}
+	return append(custom, defaultResourceDefaults...)
+}
+
+// matches reports whether the entry applies to a service
+func (d ResourceDefault) matches(service compose.Service) bool {
+	if d.Match != "" {
+		matched := false
+		for _, candidate := range imageCandidates(service.Image) {
+			if ok, _ := path.Match(d.Match, candidate); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if d.Label != "" {
+		key, value, hasValue := strings.Cut(d.Label, "=")
+		actual, ok := compose.LabelsToMap(service.Labels)[key]
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// deployLimits reports whether a service sets its memory and CPU limits under deploy.resources,
+// which compose refuses to combine with mem_limit and cpus
+func deployLimits(service compose.Service) (memory, cpus bool) {
+	resources, _ := service.Deploy["resources"].(map[string]interface{})
+	limits, _ := resources["limits"].(map[string]interface{})
+	_, memory = limits["memory"]
+	_, cpus = limits["cpus"]
+	return memory, cpus
+}
+
+// ensureResourceDefaults gives services without mem_limit or cpus those of the first entry of
+// the resource defaults table matching them. Services with x-dc-resource-defaults: false are left
+// alone. It returns a warning per default applied, so that the enrich report shows which limits
+// the stack file doesn't set itself.
+func ensureResourceDefaults(composeFile *compose.File) []string {
+	if composeFile == nil || composeFile.Services == nil {
+		return nil
+	}
+	table := loadResourceDefaults()
+	var warnings []string
+	for serviceName, service := range composeFile.Services {
+		if service.ResourceDefaults != nil && !*service.ResourceDefaults {
+			continue
+		}
+		deployMemory, deployCPUs := deployLimits(service)
+		needMemory := strings.TrimSpace(service.MemLimit) == "" && !deployMemory
+		needCPUs := !deployCPUs
+		switch v := service.CPUs.(type) {
+		case nil:
+		case string:
+			needCPUs = needCPUs && strings.TrimSpace(v) == ""
+		default:
+			needCPUs = false
+		}
+		if !needMemory && !needCPUs {
+			continue
+		}
+
+		for _, entry := range table {
+			if !entry.matches(service) {
+				continue
+			}
+			var applied []string
+			if needMemory && entry.MemLimit != "" {
+				service.MemLimit = entry.MemLimit
+				applied = append(applied, "mem_limit "+entry.MemLimit)
+			}
+			if needCPUs && entry.CPUs != nil {
+				service.CPUs = entry.CPUs
+				applied = append(applied, fmt.Sprintf("cpus %v", entry.CPUs))
+			}
+			if len(applied) > 0 {
+				warnings = append(warnings, fmt.Sprintf("service %s: applied the default %s; set the limits in the stack file or x-dc-resource-defaults: false", serviceName, strings.Join(applied, " and ")))
+			}
+			break
+		}
+		composeFile.Services[serviceName] = service
+	}
+	sort.Strings(warnings)
+	return warnings
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// managedLabel marks networks and volumes created by dc, so that `dc network prune` and
+// `dc volume prune` only clean up what dc created unless --all is given
+const managedLabel = "composectl.managed"
+
+// builtinNetworks are docker's own networks, which can never be removed
+var builtinNetworks = map[string]bool{"bridge": true, "host": true, "none": true}
+
+// NetworkInfo is a docker network as listed by `dc network ls`
+type NetworkInfo struct {
+	Name       string   `json:"name"`
+	ID         string   `json:"id"`
+	Driver     string   `json:"driver"`
+	Scope      string   `json:"scope"`
+	Created    string   `json:"created,omitempty"`
+	Managed    bool     `json:"managed"`
+	Containers []string `json:"containers"`
+	Stacks     []string `json:"stacks"` // stacks whose files declare the network
+	Unused     bool     `json:"unused"`
+}
+
+// VolumeInfo is a docker volume as listed by `dc volume ls`
+type VolumeInfo struct {
+	Name       string   `json:"name"`
+	Driver     string   `json:"driver"`
+	Mountpoint string   `json:"mountpoint,omitempty"`
+	Created    string   `json:"created,omitempty"`
+	Managed    bool     `json:"managed"`
+	Containers []string `json:"containers"`
+	Stacks     []string `json:"stacks"` // stacks whose files declare the volume
+	Unused     bool     `json:"unused"`
+}
+
+// createNetwork creates a network with the driver (default bridge) and options of its compose
+// definition, labelled as managed by dc
+func createNetwork(name string, config compose.Network) error {
+	driver := "bridge"
+	if config.Driver != "" {
+		driver = config.Driver
+	}
+	args := []string{"network", "create", "--driver", driver, "--label", managedLabel + "=true"}
+	for key, value := range config.DriverOpts {
+		args = append(args, "-o", fmt.Sprintf("%s=%s", key, value))
+	}
+	resourcesLog.Debug("Creating network", "name", name, "driver", driver)
+	resourcesLog.Info("Creating network", "name", name, "driver", driver)
+	if err := streamCommandOutput(engineCommand(append(args, name)...)); err != nil {
+		return fmt.Errorf("failed to create network %s: %v", name, err)
+	}
+	resourcesLog.Debug("Created network", "name", name, "driver", driver)
+	return nil
+}
+
+// createVolume creates a volume with the driver (default local) and options of its compose
+// definition, labelled as managed by dc
+func createVolume(name string, config compose.Volume) error {
+	driver := "local"
+	if config.Driver != "" {
+		driver = config.Driver
+	}
+	args := []string{"volume", "create", "--driver", driver, "--label", managedLabel + "=true"}
+	for key, value := range config.DriverOpts {
+		args = append(args, "-o", fmt.Sprintf("%s=%s", key, value))
+	}
+	resourcesLog.Debug("Creating volume", "name", name, "driver", driver)
+	resourcesLog.Info("Creating volume", "name", name, "driver", driver)
+	if err := streamCommandOutput(engineCommand(append(args, name)...)); err != nil {
+		return fmt.Errorf("failed to create volume %s: %v", name, err)
+	}
+	resourcesLog.Debug("Created volume", "name", name, "driver", driver)
+	return nil
+}
+
+// declaredResources maps the network and volume names declared by any stack file (including
+// the effective files) to the declaring stacks. Both the plain and the project-prefixed name
+// are recorded, as either may exist depending on how the stack was deployed.
+func declaredResources() (networks, volumes map[string][]string) {
+	networks = make(map[string][]string)
+	volumes = make(map[string][]string)
+	add := func(m map[string][]string, name, stackName string) {
+		for _, existing := range m[name] {
+			if existing == stackName {
+				return
+			}
+		}
+		m[name] = append(m[name], stackName)
+	}
+	for _, dir := range getAllStackDirs() {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.yml"))
+		for _, file := range files {
+			stackName := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(file), ".yml"), ".effective")
+			content, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			var composeFile compose.File
+			if err := yaml.Unmarshal(content, &composeFile); err != nil {
+				continue
+			}
+			for key := range composeFile.Networks {
+				add(networks, key, stackName)
+				add(networks, stackName+"_"+key, stackName)
+			}
+			for key, volume := range composeFile.Volumes {
+				add(volumes, key, stackName)
+				add(volumes, stackVolumeName(stackName, key, volume), stackName)
+			}
+		}
+	}
+	return networks, volumes
+}
+
+// containerResources maps network and volume names to the containers (running or not) using them
+func containerResources() (networks, volumes map[string][]string, err error) {
+	out, err := engineCommand("ps", "-a", "--no-trunc", "--format", "{{.Names}}\t{{.Networks}}\t{{.Mounts}}").Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("docker ps failed: %w", err)
+	}
+	networks = make(map[string][]string)
+	volumes = make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 {
+			continue
+		}
+		for _, network := range strings.Split(fields[1], ",") {
+			if network != "" {
+				networks[network] = append(networks[network], fields[0])
+			}
+		}
+		for _, mount := range strings.Split(fields[2], ",") {
+			if mount != "" {
+				volumes[mount] = append(volumes[mount], fields[0])
+			}
+		}
+	}
+	return networks, volumes, nil
+}
+
+// hasManagedLabel reports whether docker's comma-separated label list marks dc as creator
+func hasManagedLabel(labels string) bool {
+	for _, label := range strings.Split(labels, ",") {
+		if label == managedLabel+"=true" {
+			return true
+		}
+	}
+	return false
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	sort.Strings(s)
+	return s
+}
+
+// listNetworks returns all docker networks with their users, sorted by name
+func listNetworks() ([]NetworkInfo, error) {
+	out, err := engineCommand("network", "ls", "--no-trunc", "--format", "{{json .}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker network ls failed: %w", err)
+	}
+	inUse, _, err := containerResources()
+	if err != nil {
+		return nil, err
+	}
+	declared, _ := declaredResources()
+
+	networks := []NetworkInfo{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		var n struct{ ID, Name, Driver, Scope, CreatedAt, Labels string }
+		if line == "" || json.Unmarshal([]byte(line), &n) != nil {
+			continue
+		}
+		info := NetworkInfo{
+			Name: n.Name, ID: n.ID, Driver: n.Driver, Scope: n.Scope, Created: n.CreatedAt,
+			Managed:    hasManagedLabel(n.Labels),
+			Containers: nonNil(inUse[n.Name]),
+			Stacks:     nonNil(declared[n.Name]),
+		}
+		info.Unused = !builtinNetworks[n.Name] && len(info.Containers) == 0 && len(info.Stacks) == 0
+		networks = append(networks, info)
+	}
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
+	return networks, nil
+}
+
+// listVolumes returns all docker volumes with their users, sorted by name
+func listVolumes() ([]VolumeInfo, error) {
+	out, err := engineCommand("volume", "ls", "--format", "{{json .}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker volume ls failed: %w", err)
+	}
+	_, inUse, err := containerResources()
+	if err != nil {
+		return nil, err
+	}
+	_, declared := declaredResources()
+
+	volumes := []VolumeInfo{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		var v struct{ Name, Driver, Mountpoint, CreatedAt, Labels string }
+		if line == "" || json.Unmarshal([]byte(line), &v) != nil {
+			continue
+		}
+		info := VolumeInfo{
+			Name: v.Name, Driver: v.Driver, Mountpoint: v.Mountpoint, Created: v.CreatedAt,
+			Managed:    hasManagedLabel(v.Labels),
+			Containers: nonNil(inUse[v.Name]),
+			Stacks:     nonNil(declared[v.Name]),
+		}
+		info.Unused = len(info.Containers) == 0 && len(info.Stacks) == 0
+		volumes = append(volumes, info)
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, nil
+}
+
+// HandleInspectResource prints `docker <kind> inspect` of a network or volume
+func HandleInspectResource(kind, name string) error {
+	out, err := engineCommand(kind, "inspect", name).Output()
+	if err != nil {
+		return fmt.Errorf("%s %s not found", kind, name)
+	}
+	var inspected []json.RawMessage
+	if err := json.Unmarshal(out, &inspected); err != nil || len(inspected) == 0 {
+		return fmt.Errorf("%s %s not found", kind, name)
+	}
+	_, err = os.Stdout.Write(append(inspected[0], '\n'))
+	return err
+}
+
+// driverOptsFlag parses --driver-opts=key=value,key=value
+func driverOptsFlag() map[string]string {
+	opts := make(map[string]string)
+	for _, pair := range strings.Split(getFlag("driver_opts", ""), ",") {
+		if key, value, ok := strings.Cut(pair, "="); ok && key != "" {
+			opts[key] = value
+		}
+	}
+	return opts
+}
+
+// HandleCreateNetwork creates a network from --driver and --driver-opts
+func HandleCreateNetwork(name string) error {
+	if engineCommand("network", "inspect", name).Run() == nil {
+		return fmt.Errorf("network %s already exists", name)
+	}
+	if DryRun {
+		fmt.Fprintf(os.Stdout, "%s\n# Would create network %s\n", msg("dry_run_header"), name)
+		return nil
+	}
+	return createNetwork(name, compose.Network{Driver: getFlag("driver", ""), DriverOpts: driverOptsFlag()})
+}
+
+// HandleCreateVolume creates a volume from --driver and --driver-opts
+func HandleCreateVolume(name string) error {
+	if engineCommand("volume", "inspect", name).Run() == nil {
+		return fmt.Errorf("volume %s already exists", name)
+	}
+	if DryRun {
+		fmt.Fprintf(os.Stdout, "%s\n# Would create volume %s\n", msg("dry_run_header"), name)
+		return nil
+	}
+	return createVolume(name, compose.Volume{Driver: getFlag("driver", ""), DriverOpts: driverOptsFlag()})
+}
+
+// removeResource removes a network or volume after checking that it is unused
+func removeResource(kind, name string, containers, stacks []string) error {
+	if len(containers) > 0 {
+		return fmt.Errorf("%s %s is used by container(s) %s", kind, name, strings.Join(containers, ", "))
+	}
+	if len(stacks) > 0 {
+		return fmt.Errorf("%s %s is declared by stack(s) %s", kind, name, strings.Join(stacks, ", "))
+	}
+	if DryRun {
+		fmt.Fprintf(os.Stdout, "%s\n# Would remove %s %s\n", msg("dry_run_header"), kind, name)
+		return nil
+	}
+	if output, err := engineCommand(kind, "rm", name).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove %s %s: %v: %s", kind, name, err, strings.TrimSpace(string(output)))
+	}
+	resourcesLog.Info("Removed", "kind", kind, "name", name)
+	return nil
+}
+
+// HandleRemoveNetwork removes a network unless a container uses it or a stack declares it
+func HandleRemoveNetwork(name string) error {
+	networks, err := listNetworks()
+	if err != nil {
+		return err
+	}
+	for _, n := range networks {
+		if n.Name == name {
+			if builtinNetworks[name] {
+				return fmt.Errorf("network %s is built into docker", name)
+			}
+			return removeResource("network", name, n.Containers, n.Stacks)
+		}
+	}
+	return fmt.Errorf("network %s not found", name)
+}
+
+// HandleRemoveVolume removes a volume unless a container uses it or a stack declares it
+func HandleRemoveVolume(name string) error {
+	volumes, err := listVolumes()
+	if err != nil {
+		return err
+	}
+	for _, v := range volumes {
+		if v.Name == name {
+			return removeResource("volume", name, v.Containers, v.Stacks)
+		}
+	}
+	return fmt.Errorf("volume %s not found", name)
+}
+
+// HandlePruneNetworks removes the unused networks created by dc (all unused ones with --all)
+// and prints the removed names
+func HandlePruneNetworks() error {
+	networks, err := listNetworks()
+	if err != nil {
+		return err
+	}
+	all := getFlagBool("all", false)
+	for _, n := range networks {
+		if n.Unused && (n.Managed || all) {
+			if err := removeResource("network", n.Name, nil, nil); err != nil {
+				resourcesLog.Warn(err.Error())
+				continue
+			}
+			fmt.Println(n.Name)
+		}
+	}
+	return nil
+}
+
+// HandlePruneVolumes removes the unused volumes created by dc (all unused ones with --all)
+// and prints the removed names
+func HandlePruneVolumes() error {
+	volumes, err := listVolumes()
+	if err != nil {
+		return err
+	}
+	all := getFlagBool("all", false)
+	for _, v := range volumes {
+		if v.Unused && (v.Managed || all) {
+			if err := removeResource("volume", v.Name, nil, nil); err != nil {
+				resourcesLog.Warn(err.Error())
+				continue
+			}
+			fmt.Println(v.Name)
+		}
+	}
+	return nil
+}
+
+func listNetworksJSON() error {
+	networks, err := listNetworks()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(networks)
+}
+
+func listVolumesJSON() error {
+	volumes, err := listVolumes()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(volumes)
+}
+
+// handleResourceCommand dispatches the subcommands of `dc network` and `dc volume`
+func handleResourceCommand(kind string, pos []string, die func(format string, args ...interface{}), list func() error, create, remove func(string) error, prune func() error) {
+	var err error
+	switch pos[1] {
+	case "ls", "list":
+		err = list()
+	case "prune":
+		err = prune()
+	case "inspect", "create", "rm", "remove":
+		if len(pos) < 3 {
+			die("Usage: dc %s %s <name>", kind, pos[1])
+		}
+		switch pos[1] {
+		case "inspect":
+			err = HandleInspectResource(kind, pos[2])
+		case "create":
+			err = create(pos[2])
+		default:
+			err = remove(pos[2])
+		}
+	default:
+		die("Unknown %s command: %s", kind, pos[1])
+	}
+	if err != nil {
+		die("%v", err)
+	}
+}
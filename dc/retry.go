@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default retry policy of docker invocations (config keys compose_retries, compose_retry_backoff)
+const (
+	defaultComposeRetries      = 2
+	defaultComposeRetryBackoff = 2 * time.Second
+)
+
+// transientErrors are fragments of docker output about failures that may well succeed when
+// tried again: networks created concurrently by two stacks and registry or network hiccups
+var transientErrors = []string{
+	"network with name",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"connection reset by peer",
+	"context deadline exceeded",
+	"Client.Timeout exceeded",
+	"toomanyrequests",
+	"503 Service Unavailable",
+	"502 Bad Gateway",
+	"unexpected EOF",
+}
+
+// composeTimeout returns the timeout of a docker compose action (compose_timeout_<action>,
+// e.g. compose_timeout_up=10m, falling back to compose_timeout); 0 means none
+func composeTimeout(action string) time.Duration {
+	d, err := time.ParseDuration(getConfig("compose_timeout_"+action, getConfig("compose_timeout", "")))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// transientFailure returns the output line showing that a failure is worth retrying, or ""
+func transientFailure(output []string) string {
+	for i := len(output) - 1; i >= 0; i-- {
+		for _, fragment := range transientErrors {
+			if strings.Contains(output[i], fragment) {
+				return output[i]
+			}
+		}
+	}
+	return ""
+}
+
+// runRetried streams a docker command with the timeout of its action and runs it again, with
+// exponential backoff, as long as it fails transiently. Timeouts and cancellations aren't
+// retried. The command's stdin must be seekable (the compose file as a strings.Reader) so
+// that it can be replayed.
+func runRetried(cmd *exec.Cmd, action string) error {
+	retries, err := strconv.Atoi(getConfig("compose_retries", ""))
+	if err != nil || retries < 0 {
+		retries = defaultComposeRetries
+	}
+	backoff, err := time.ParseDuration(getConfig("compose_retry_backoff", ""))
+	if err != nil || backoff <= 0 {
+		backoff = defaultComposeRetryBackoff
+	}
+	attempts := retries + 1
+	timeout := composeTimeout(action)
+
+	for attempt := 1; ; attempt++ {
+		output, err := streamCommand(cmd, timeout)
+		if err == nil || errors.Is(err, errCancelled) || errors.Is(err, errTimedOut) {
+			return err
+		}
+		reason := transientFailure(output)
+		if reason == "" {
+			return err
+		}
+		if attempt == attempts {
+			if attempts > 1 {
+				writeFrame(FrameError, msg("compose_gave_up", attempts))
+			}
+			return err
+		}
+		writeFrame(FrameStderr, msg("compose_retry", attempt, attempts, reason, backoff))
+		stackLog.Debug("Retrying docker", "action", action, "reason", reason)
+		time.Sleep(backoff)
+		backoff *= 2
+
+		next := exec.Command(cmd.Args[0], cmd.Args[1:]...)
+		next.Dir, next.Env = cmd.Dir, cmd.Env
+		if cmd.Stdin != nil {
+			seeker, ok := cmd.Stdin.(io.Seeker)
+			if !ok {
+				return fmt.Errorf("cannot retry docker %s: its input can't be replayed", action)
+			}
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			next.Stdin = cmd.Stdin
+		}
+		cmd = next
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRevisionRetention is the number of revisions kept per stack (config key revision_retention)
+	defaultRevisionRetention = 10
+
+	// revisionTimeFormat names revision files so that they sort chronologically
+	revisionTimeFormat = "20060102-150405.000"
+)
+
+// getRevisionsDir returns the directory holding previous versions of a stack's .yml
+func getRevisionsDir(stackName string) string {
+	return filepath.Join(StacksDir, ".revisions", stackName)
+}
+
+// listRevisions returns the revision IDs of a stack, newest first
+func listRevisions(stackName string) ([]string, error) {
+	entries, err := os.ReadDir(getRevisionsDir(stackName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var revisions []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".yml") {
+			revisions = append(revisions, strings.TrimSuffix(entry.Name(), ".yml"))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(revisions)))
+	return revisions, nil
+}
+
+// snapshotRevision stores the current content of path as a new revision of the stack,
+// unless the file doesn't exist or is unchanged, and prunes old revisions.
+func snapshotRevision(stackName, path string, newContent []byte) error {
+	current, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if bytes.Equal(current, newContent) {
+		return nil
+	}
+
+	dir := getRevisionsDir(stackName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	revision := time.Now().Format(revisionTimeFormat)
+	if err := os.WriteFile(filepath.Join(dir, revision+".yml"), current, 0644); err != nil {
+		return fmt.Errorf("failed to store revision of %s: %w", path, err)
+	}
+	stackLog.Debug("Stored revision", "revision", revision, "stack", stackName)
+
+	retention, err := strconv.Atoi(getConfig("revision_retention", strconv.Itoa(defaultRevisionRetention)))
+	if err != nil || retention < 1 {
+		retention = defaultRevisionRetention
+	}
+	revisions, err := listRevisions(stackName)
+	if err != nil || len(revisions) <= retention {
+		return nil
+	}
+	for _, old := range revisions[retention:] {
+		if err := os.Remove(filepath.Join(dir, old+".yml")); err != nil {
+			stackLog.Debug("Failed to remove old revision", "revision", old, "stack", stackName, "err", err)
+		}
+	}
+	return nil
+}
+
+// writeStackFile overwrites a stack's .yml after keeping its previous content as a revision
+func writeStackFile(stackName, path string, content []byte) error {
+	if err := snapshotRevision(stackName, path, content); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+// HandleRollbackStack restores a stack's .yml to the given revision. The replaced
+// definition is itself kept as a revision, so a rollback can be undone.
+func HandleRollbackStack(stackName, revision string) error {
+	if strings.ContainsAny(revision, "/\\") || revision == ".." {
+		return fmt.Errorf("invalid revision %q", revision)
+	}
+	content, err := os.ReadFile(filepath.Join(getRevisionsDir(stackName), revision+".yml"))
+	if err != nil {
+		return fmt.Errorf("revision %s of stack %s not found", revision, stackName)
+	}
+
+	path := filepath.Join(getFirstWritableStackDir(), stackName+".yml")
+	if _, existing, err := findYAML(stackName); err == nil {
+		path = existing
+	}
+	if DryRun {
+		current, _ := os.ReadFile(path)
+		fmt.Fprintln(os.Stdout, msg("dry_run_header"))
+		os.Stdout.WriteString(unifiedDiff(string(current), string(content), path, path))
+		return nil
+	}
+	if err := writeStackFile(stackName, path, content); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", path, err)
+	}
+	fmt.Fprintln(os.Stderr, msg("rolled_back", stackName, revision))
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"dc/internal/compose"
+)
+
+// Secrets modes (config key secrets_mode) deciding how secrets backed by an environment
+// variable reach docker compose:
+//   - env: compose reads them from its environment (default)
+//   - file: dc writes each to a file of a restricted runtime directory and the secret becomes a
+//     file: reference, so the value never appears in the YAML piped to docker compose
+//   - swarm: dc creates a swarm secret per value and the secret becomes an external reference;
+//     only for stacks of the swarm backend, which always use it
+const (
+	SecretsModeEnv   = "env"
+	SecretsModeFile  = "file"
+	SecretsModeSwarm = "swarm"
+)
+
+// secretsMode returns the configured secrets mode
+func secretsMode() string {
+	switch mode := strings.ToLower(getConfig("secrets_mode", SecretsModeEnv)); mode {
+	case SecretsModeFile, SecretsModeSwarm:
+		return mode
+	default:
+		return SecretsModeEnv
+	}
+}
+
+// secretFilesLocation returns where the secret files of a stack go, as a directory that must
+// exist and the levels below it: secrets_dir, else dc/secrets under $XDG_STATE_HOME (by default
+// ~/.local/state). The files must survive a reboot: docker restarts containers with a restart
+// policy before dc runs again, and a secret file missing then keeps them from starting. There is
+// no fallback to the shared temp dir, where another user could create the path first.
+func secretFilesLocation(stackName string) (string, []string, error) {
+	if base := getConfig("secrets_dir", ""); base != "" {
+		base = filepath.Clean(base)
+		return filepath.Dir(base), []string{filepath.Base(base), stackName}, nil
+	}
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil || homeDir == "" {
+			return "", nil, fmt.Errorf("secrets mode file needs a home directory, XDG_STATE_HOME or secrets_dir for the secret files")
+		}
+		stateDir = filepath.Join(homeDir, ".local", "state")
+	}
+	return stateDir, []string{"dc", "secrets", stackName}, nil
+}
+
+// secretFilesDir creates the directory holding the secret files of a stack, see
+// secretFilesLocation and makePrivateDirs
+func secretFilesDir(stackName string) (string, error) {
+	root, levels, err := secretFilesLocation(stackName)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return "", err
+	}
+	return makePrivateDirs(root, levels...)
+}
+
+// makePrivateDirs creates the missing levels below root with mode 0700 and returns the last.
+// It fails unless every level is a directory, not a symlink, owned by the user and closed to
+// everyone else, so that nobody can swap in a path of their own.
+func makePrivateDirs(root string, levels ...string) (string, error) {
+	dir := root
+	for _, level := range levels {
+		dir = filepath.Join(dir, level)
+		if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+			return "", err
+		}
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return "", err
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("%s is not a directory", dir)
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+			return "", fmt.Errorf("%s is not owned by the current user", dir)
+		}
+		if info.Mode().Perm()&0077 != 0 {
+			return "", fmt.Errorf("%s is accessible by other users (mode %o), expected 0700", dir, info.Mode().Perm())
+		}
+	}
+	return dir, nil
+}
+
+// secretSourceValue looks up the value of the variable backing a secret in the variables of the
+// stack (case-insensitively, like interpolation), else in the environment
+func secretSourceValue(envVars map[string]string, name string) (string, bool) {
+	if value, ok := envVars[name]; ok {
+		return value, true
+	}
+	for key, value := range envVars {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return os.LookupEnv(name)
+}
+
+// materializeSecrets rewrites the secrets backed by an environment variable according to the
+// secrets mode, always swarm for stacks of the swarm backend. Secret files are kept in a
+// directory only the user can enter; the files themselves are world-readable so that containers
+// running as another user can read them once mounted.
+func materializeSecrets(composeFile *compose.File, stackName string) error {
+	mode := secretsMode()
+	if stackBackend(composeFile) == BackendSwarm {
+		// docker stack deploy can't take secrets from the environment
+		mode = SecretsModeSwarm
+	} else if mode == SecretsModeSwarm && len(composeFile.Secrets) > 0 {
+		// docker compose up refuses external secrets, they only exist in a swarm
+		return fmt.Errorf("secrets mode swarm needs the swarm backend, stack %s uses docker compose; use secrets mode file instead", stackName)
+	}
+	if mode == SecretsModeEnv || len(composeFile.Secrets) == 0 {
+		return nil
+	}
+	envVars, err := readStackEnv(stackName)
+	if err != nil {
+		return err
+	}
+
+	var dir string
+	written := make(map[string]bool)
+	if mode == SecretsModeFile {
+		if dir, err = secretFilesDir(stackName); err != nil {
+			return err
+		}
+	}
+
+	for name, secret := range composeFile.Secrets {
+		if secret.Environment == "" || secret.External || secret.File != "" {
+			continue
+		}
+		value, ok := secretSourceValue(envVars, secret.Environment)
+		if !ok {
+			return fmt.Errorf("secret %s: variable %s is not set", name, secret.Environment)
+		}
+		switch mode {
+		case SecretsModeFile:
+			file := filepath.Join(dir, name)
+			if err := writeSecretFile(file, value); err != nil {
+				return fmt.Errorf("secret %s: %w", name, err)
+			}
+			written[name] = true
+			composeFile.Secrets[name] = compose.Secret{File: file}
+		case SecretsModeSwarm:
+			swarmName, err := ensureSwarmSecret(stackName, name, value)
+			if err != nil {
+				return fmt.Errorf("secret %s: %w", name, err)
+			}
+			composeFile.Secrets[name] = compose.Secret{Name: swarmName, External: true}
+		}
+	}
+
+	// files of secrets the stack no longer has are removed
+	if mode == SecretsModeFile {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if !written[entry.Name()] {
+				os.Remove(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	return nil
+}
+
+// writeSecretFile replaces a secret file atomically
+func writeSecretFile(file, value string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".secret-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0444); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// ensureSwarmSecret creates the swarm secret holding a value unless it exists and returns its
+// name. Swarm secrets can't be changed, so the name carries a hash of the value and a rotated
+// value gets a new secret.
+func ensureSwarmSecret(stackName, name, value string) (string, error) {
+	sum := sha256.Sum256([]byte(stackName + "\x00" + name + "\x00" + value))
+	swarmName := fmt.Sprintf("%s_%s_%s", stackName, name, hex.EncodeToString(sum[:])[:12])
+	if engineCommand("secret", "inspect", swarmName).Run() == nil {
+		return swarmName, nil
+	}
+	cmd := engineCommand("secret", "create", "--label", "com.docker.compose.project="+stackName, swarmName, "-")
+	cmd.Stdin = strings.NewReader(value)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("docker secret create %s: %w: %s", swarmName, err, strings.TrimSpace(string(output)))
+	}
+	secretsLog.Info("Created swarm secret", "name", swarmName)
+	return swarmName, nil
+}
+
+// removeSecretFiles removes the secret files of a stack after it was taken down
+func removeSecretFiles(stackName string) {
+	if secretsMode() != SecretsModeFile {
+		return
+	}
+	root, levels, err := secretFilesLocation(stackName)
+	if err == nil {
+		err = os.RemoveAll(filepath.Join(append([]string{root}, levels...)...))
+	}
+	if err != nil {
+		secretsLog.Warn("Failed to remove the secret files", "stack", stackName, "err", err)
+	}
+}
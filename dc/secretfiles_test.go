@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"dc/internal/compose"
+)
+
+func TestMakePrivateDirs(t *testing.T) {
+	root := t.TempDir()
+	dir, err := makePrivateDirs(root, "dc", "secrets", "web")
+	if err != nil {
+		t.Fatalf("makePrivateDirs: %v", err)
+	}
+	if want := filepath.Join(root, "dc", "secrets", "web"); dir != want {
+		t.Errorf("dir = %s, want %s", dir, want)
+	}
+	for _, level := range []string{"dc", "dc/secrets", "dc/secrets/web"} {
+		info, err := os.Stat(filepath.Join(root, level))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0700 {
+			t.Errorf("%s has mode %o, want 0700", level, perm)
+		}
+	}
+	if _, err := makePrivateDirs(root, "dc", "secrets", "web"); err != nil {
+		t.Errorf("makePrivateDirs on existing dirs: %v", err)
+	}
+}
+
+func TestMakePrivateDirsRefusesPlantedLevels(t *testing.T) {
+	root := t.TempDir()
+	target := t.TempDir()
+	if err := os.Symlink(target, filepath.Join(root, "dc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makePrivateDirs(root, "dc", "secrets"); err == nil {
+		t.Error("makePrivateDirs followed a symlink")
+	}
+
+	root = t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "dc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makePrivateDirs(root, "dc", "secrets"); err == nil {
+		t.Error("makePrivateDirs accepted a level other users can enter")
+	}
+}
+
+func TestSecretFilesLocationPersists(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("SECRETS_DIR", "")
+	root, levels, err := secretFilesLocation("web")
+	if err != nil {
+		t.Fatalf("secretFilesLocation: %v", err)
+	}
+	if want := filepath.Join(home, ".local", "state"); root != want {
+		t.Errorf("root = %s, want %s", root, want)
+	}
+	if got := filepath.Join(levels...); got != filepath.Join("dc", "secrets", "web") {
+		t.Errorf("levels = %s, want dc/secrets/web", got)
+	}
+
+	t.Setenv("HOME", "")
+	if _, _, err := secretFilesLocation("web"); err == nil {
+		t.Error("secretFilesLocation without a home directory, XDG_STATE_HOME and secrets_dir did not fail")
+	}
+}
+
+func TestMaterializeSecretsRefusesSwarmModeOnCompose(t *testing.T) {
+	t.Setenv("SECRETS_MODE", SecretsModeSwarm)
+	composeFile := &compose.File{Secrets: map[string]compose.Secret{"db": {Environment: "DB_PASSWORD"}}}
+	if err := materializeSecrets(composeFile, "web"); err == nil {
+		t.Error("materializeSecrets accepted secrets mode swarm for a docker compose stack")
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dc/internal/compose"
+	"dc/internal/secrets"
+	"gopkg.in/yaml.v3"
+)
+
+// secretAlphabet holds the characters of generated secrets: URL-safe and shell-safe, as in pw
+const secretAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-._"
+
+// defaultSecretLength is the length of generated secrets (config key secret_length)
+const defaultSecretLength = 24
+
+// SecretReference is a stack using a secret, with the services that use it
+type SecretReference struct {
+	Stack     string   `json:"stack"`
+	Services  []string `json:"services"`
+	Running   bool     `json:"running"`
+	Restarted bool     `json:"restarted"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// SecretRotation is the outcome of `dc secret rotate`. The new value is never printed.
+type SecretRotation struct {
+	Secret string            `json:"secret"`
+	DryRun bool              `json:"dryRun,omitempty"`
+	Stacks []SecretReference `json:"stacks"`
+}
+
+// generateSecret returns a random value of the given length from secretAlphabet
+func generateSecret(length int) (string, error) {
+	max := big.NewInt(int64(len(secretAlphabet)))
+	value := make([]byte, length)
+	for i := range value {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		value[i] = secretAlphabet[n.Int64()]
+	}
+	return string(value), nil
+}
+
+// secretLength returns the length of generated secrets: --length, else secret_length
+func secretLength() (int, error) {
+	value := getFlag("length", getConfig("secret_length", strconv.Itoa(defaultSecretLength)))
+	length, err := strconv.Atoi(value)
+	if err != nil || length < 8 {
+		return 0, fmt.Errorf("invalid secret length %q (at least 8)", value)
+	}
+	return length, nil
+}
+
+// SecretUsage is a place in a stack file consuming a variable: a field of a service (with the
+// label key for labels), the secrets of a service through a top-level secret or, without a
+// service, a top-level section such as networks
+type SecretUsage struct {
+	Stack   string `json:"stack"`
+	Service string `json:"service,omitempty"`
+	Field   string `json:"field"`
+	Label   string `json:"label,omitempty"`
+}
+
+// variableNames returns the variables interpolated in a part of a compose file; $$ is an escaped $
+func variableNames(v interface{}) []string {
+	var text string
+	if s, ok := v.(string); ok {
+		text = s
+	} else if out, err := yaml.Marshal(v); err == nil {
+		text = string(out)
+	}
+	return compose.Variables(text)
+}
+
+// stackVariableUsages returns where a stack file consumes variables, keyed by upper-case name
+// since prod.env is looked up case-insensitively
+func stackVariableUsages(stackName string, content []byte) (map[string][]SecretUsage, error) {
+	var composeFile map[string]interface{}
+	if err := yaml.Unmarshal(content, &composeFile); err != nil {
+		return nil, err
+	}
+	usages := make(map[string][]SecretUsage)
+	add := func(name string, usage SecretUsage) {
+		key := strings.ToUpper(name)
+		for _, u := range usages[key] {
+			if u == usage {
+				return
+			}
+		}
+		usages[key] = append(usages[key], usage)
+	}
+
+	backing := make(map[string]string) // top-level secret -> variable
+	if secrets, ok := composeFile["secrets"].(map[string]interface{}); ok {
+		for key, secret := range secrets {
+			if s, ok := secret.(map[string]interface{}); ok {
+				if env, ok := s["environment"].(string); ok && env != "" {
+					backing[key] = env
+				}
+			}
+		}
+	}
+
+	for section, value := range composeFile {
+		if section != "services" {
+			for _, name := range variableNames(value) {
+				add(name, SecretUsage{Stack: stackName, Field: section})
+			}
+			continue
+		}
+		services, _ := value.(map[string]interface{})
+		for serviceName, raw := range services {
+			service, _ := raw.(map[string]interface{})
+			for field, fieldValue := range service {
+				switch field {
+				case "labels":
+					for label, labelValue := range labelsOf(fieldValue) {
+						for _, name := range variableNames(labelValue) {
+							add(name, SecretUsage{Stack: stackName, Service: serviceName, Field: field, Label: label})
+						}
+					}
+				case "secrets":
+					list, _ := fieldValue.([]interface{})
+					for _, item := range list {
+						source := fmt.Sprint(item)
+						if m, ok := item.(map[string]interface{}); ok {
+							source = fmt.Sprint(m["source"])
+						}
+						if env, ok := backing[source]; ok {
+							add(env, SecretUsage{Stack: stackName, Service: serviceName, Field: field})
+						}
+					}
+				default:
+					for _, name := range variableNames(fieldValue) {
+						add(name, SecretUsage{Stack: stackName, Service: serviceName, Field: field})
+					}
+				}
+			}
+		}
+	}
+	return usages, nil
+}
+
+// labelsOf returns the labels of a service given as a map or as a list of key=value
+func labelsOf(v interface{}) map[string]string {
+	labels := make(map[string]string)
+	switch l := v.(type) {
+	case map[string]interface{}:
+		for key, value := range l {
+			labels[key] = fmt.Sprint(value)
+		}
+	case []interface{}:
+		for _, item := range l {
+			key, value, _ := strings.Cut(fmt.Sprint(item), "=")
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// variableUsages scans all stack files for the variables they consume, keyed by upper-case name
+func variableUsages() map[string][]SecretUsage {
+	usages := make(map[string][]SecretUsage)
+	for stackName, file := range stackFiles() {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		stackUsages, err := stackVariableUsages(stackName, content)
+		if err != nil {
+			secretsLog.Warn("Skipping", "file", file, "err", err)
+			continue
+		}
+		for name, u := range stackUsages {
+			usages[name] = append(usages[name], u...)
+		}
+	}
+	for _, u := range usages {
+		sort.Slice(u, func(i, j int) bool {
+			if u[i].Stack != u[j].Stack {
+				return u[i].Stack < u[j].Stack
+			}
+			if u[i].Service != u[j].Service {
+				return u[i].Service < u[j].Service
+			}
+			return u[i].Field+u[i].Label < u[j].Field+u[j].Label
+		})
+	}
+	return usages
+}
+
+// secretReferences returns the stacks whose files use the secret, sorted by name
+func secretReferences(name string) ([]SecretReference, error) {
+	running := make(map[string]bool)
+	projects, err := runningProjects()
+	if err != nil {
+		return nil, err
+	}
+	for _, project := range projects {
+		running[project] = true
+	}
+
+	references := []SecretReference{}
+	for _, usage := range variableUsages()[strings.ToUpper(name)] {
+		if len(references) == 0 || references[len(references)-1].Stack != usage.Stack {
+			references = append(references, SecretReference{Stack: usage.Stack, Services: []string{}, Running: running[usage.Stack]})
+		}
+		ref := &references[len(references)-1]
+		if usage.Service != "" {
+			ref.Services = appendUnique(ref.Services, usage.Service)
+		}
+	}
+	return references, nil
+}
+
+// storeRotatedSecret replaces the value of an existing secret with `<secrets_manager> upd`. When
+// prod.env is not the file of the secrets manager and holds the key as well, it is updated too.
+func storeRotatedSecret(name, value string) error {
+	cmd := exec.Command(SecretsManager, "upd", name)
+	cmd.Stdin = strings.NewReader(value)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if strings.Contains(string(output), "key not found") {
+			return fmt.Errorf("secret %s does not exist", name)
+		}
+		return fmt.Errorf("%s upd %s: %w: %s", SecretsManager, name, err, strings.TrimSpace(string(output)))
+	}
+
+	vars, err := secrets.ReadEnvFile(ProdEnvPath)
+	if err != nil {
+		return err
+	}
+	if current, ok := vars[name]; ok && current != value {
+		_, err := secrets.SetEnvFileKey(ProdEnvPath, name, &value)
+		return err
+	}
+	return nil
+}
+
+// HandleSecretRotate generates a new value for a secret, stores it and prints the stacks using
+// it as JSON. With --restart=true the running ones among them are brought up again one after
+// the other, recreating only the services that use the secret; a failed stack stops the rest.
+func HandleSecretRotate(name string) error {
+	length, err := secretLength()
+	if err != nil {
+		return err
+	}
+	references, err := secretReferences(name)
+	if err != nil {
+		return err
+	}
+	rotation := SecretRotation{Secret: name, DryRun: DryRun, Stacks: references}
+	restart := getFlagBool("restart", false)
+
+	if DryRun {
+		for _, ref := range references {
+			action := "would keep running with the old value until restarted"
+			if !ref.Running {
+				action = "is not running"
+			} else if restart {
+				action = "would be restarted"
+			}
+			fmt.Fprintf(os.Stderr, "# Dry run: stack %s (services %s) %s\n", ref.Stack, strings.Join(ref.Services, ", "), action)
+		}
+		return json.NewEncoder(os.Stdout).Encode(rotation)
+	}
+
+	value, err := generateSecret(length)
+	if err != nil {
+		return err
+	}
+	if err := storeRotatedSecret(name, value); err != nil {
+		return err
+	}
+	writeFrame(FrameStdout, fmt.Sprintf("Rotated secret %s, used by %d stacks", name, len(references)))
+
+	var failed error
+	if restart {
+		self, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to locate dc: %w", err)
+		}
+		// the children get the flags of this invocation, e.g. --stacks-dir, but not those of rotate
+		var flags []string
+		for _, flag := range bulkPassthrough(os.Args[1:]) {
+			if name := flagName(flag); name != "restart" && name != "length" {
+				flags = append(flags, flag)
+			}
+		}
+		for i := range rotation.Stacks {
+			ref := &rotation.Stacks[i]
+			if !ref.Running {
+				continue
+			}
+			if failed != nil {
+				ref.Error = "skipped after an earlier failure"
+				continue
+			}
+			// a stack using the secret outside of its services (e.g. in networks) is restarted whole
+			action := []string{"up"}
+			if len(ref.Services) > 0 {
+				action = append(action, "--services="+strings.Join(ref.Services, ","))
+			}
+			result := runBulkStack(self, ref.Stack, action, flags)
+			if result.ExitCode != 0 {
+				ref.Error = result.Error
+				failed = fmt.Errorf("restart of stack %s failed: %s", ref.Stack, result.Error)
+				continue
+			}
+			ref.Restarted = true
+			writeFrame(FrameStdout, fmt.Sprintf("Restarted stack %s (%s) in %s", ref.Stack, strings.Join(ref.Services, ", "), result.Duration))
+		}
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(rotation); err != nil {
+		return err
+	}
+	return failed
+}
+
+// maskedSecretValue stands in for every value in the inventory, so not even the length leaks
+const maskedSecretValue = "********"
+
+// SecretInventoryEntry is a secret of the env store with the places consuming it
+type SecretInventoryEntry struct {
+	Name     string        `json:"name"`
+	Value    string        `json:"value"` // always masked
+	Usages   []SecretUsage `json:"usages"`
+	Orphaned bool          `json:"orphaned"` // no stack file references it
+	Kept     bool          `json:"kept,omitempty"`
+}
+
+// secretNames returns the names in the env store
+func secretNames() ([]string, error) {
+	vars, err := secrets.ReadEnvFile(ProdEnvPath)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// settingKeys are the prod.env entries that configure dc and dcapi rather than a stack, names or
+// patterns; prune never deletes them
+var settingKeys = []string{
+	"ACCESS_LOG", "ACME_CACHE_DIR", "ACME_DIRECTORY_URL", "ACME_DOMAINS", "ACME_EMAIL",
+	"ACME_HTTP_ADDR", "ADDR", "ADMIN_PASSWORD", "ADMIN_USERNAME", "API_TOKENS_FILE", "ASSETS_DIR",
+	"ASSET_CACHE_DIR", "ASSET_CACHE_MAX_BYTES", "ASSET_CACHE_TTL", "ASSET_HOSTS", "AUDIT_MAX",
+	"AUTH_DISABLED", "AUTH_SECRET_KEY", "AUTOSTART", "AWS_REGION", "BACKEND", "BACKUPS_DIR",
+	"BACKUP_RETENTION", "BACKUP_TARGET", "BASE_PATH", "BOOT_RECORD", "BULK_PARALLELISM",
+	"CANCEL_GRACE", "COMPOSE_COMMAND", "COMPOSE_RETRIES", "COMPOSE_RETRY_BACKOFF",
+	"COMPOSE_TIMEOUT", "COMPOSE_TIMEOUT_*", "CORS_ORIGINS", "DASHBOARD_LABELS", "DASHBOARD_SCHEME",
+	"DCAPI_*", "DCAPI_TOKEN", "DCAPI_URL", "DEVICE_POLL_INTERVAL", "DEVICE_WATCH", "DNS_FILE",
+	"DRIFT_INTERVAL", "DRIFT_MODE", "DUAL_STACK_PORTS", "ENABLE_CHAOS", "ENGINE", "ENV_PATH",
+	"EVENTS", "GROUP_ORDER", "HEALTHCHECKS_FILE", "HEALTHCHECK_DEFAULTS", "HEARTBEAT_*",
+	"HEARTBEAT_URL", "HOMELAB_SUBNET", "IPAM_FILE", "K8S_VOLUME_SIZE", "LANG", "LIST_PARALLELISM",
+	"LOGGING_FILE", "LOG_DEFAULTS", "LOG_DRIVER", "LOG_FORMAT", "LOG_LEVEL", "LOG_MAX_FILE",
+	"LOG_MAX_SIZE", "LOG_OPTIONS", "METRICS_TOKEN", "MIN_FREE_DISK", "NOTIFICATIONS_FILE",
+	"POLICY_*", "POLICY_DIR", "PORT", "PORT_ALLOCATIONS", "PORT_RANGE", "PROBE_IMAGE", "PROFILE",
+	"PROXY_DOMAIN", "PROXY_ENTRYPOINT_*", "PULL_BEFORE_UP", "READ_ONLY", "REDACTION",
+	"RESOURCE_DEFAULTS_FILE", "REVISION_RETENTION", "S3_ACCESS_KEY", "S3_ENDPOINT", "S3_REGION",
+	"S3_SECRET_KEY", "SECRETS_DIR", "SECRETS_MANAGER", "SECRETS_MODE", "SECRET_KEEP", "SECRET_KEY",
+	"SECRET_LENGTH", "SERVICE_ACCOUNTS_FILE", "SFTP_IDENTITY", "SHUTDOWN_TIMEOUT", "STACKS_DIR",
+	"STACKS_GIT", "STACK_META_DB", "STATE_DB", "STATE_FILE", "STATIC_IPS", "STATIC_IP_RANGE",
+	"STATUS_PAGE", "STREAM_OVERFLOW", "STREAM_WRITE_TIMEOUT", "SWAGGER_UI", "TLS_CERT", "TLS_DIR",
+	"TLS_HOSTS", "TLS_KEY", "TLS_SELF_SIGNED", "TRUSTED_PROXIES", "UPDATE_CHECK_INTERVAL",
+	"USAGE_DIR", "USAGE_HISTORY", "USAGE_INTERVAL", "USAGE_RETENTION", "WAIT_TIMEOUT",
+	"WATCH_DEBOUNCE", "WATCH_STACKS", "WS_ALLOWED_ORIGINS", "WS_PING_INTERVAL",
+}
+
+// keptSecret reports whether prune leaves a secret alone: settings of dc and dcapi (settingKeys)
+// and the entries matching secret_keep, comma-separated names or patterns like MY_*
+func keptSecret(name string) bool {
+	for _, pattern := range append(strings.Split(getConfig("secret_keep", ""), ","), settingKeys...) {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(strings.ToUpper(pattern), strings.ToUpper(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// secretInventory lists the secrets of the env store with their usages across all stack files
+func secretInventory() ([]SecretInventoryEntry, error) {
+	names, err := secretNames()
+	if err != nil {
+		return nil, err
+	}
+	usages := variableUsages()
+	inventory := []SecretInventoryEntry{}
+	for _, name := range names {
+		entry := SecretInventoryEntry{Name: name, Value: maskedSecretValue, Usages: usages[strings.ToUpper(name)], Kept: keptSecret(name)}
+		if entry.Usages == nil {
+			entry.Usages = []SecretUsage{}
+		}
+		entry.Orphaned = len(entry.Usages) == 0
+		inventory = append(inventory, entry)
+	}
+	return inventory, nil
+}
+
+// HandleSecretInventory prints the secrets of the env store as JSON, values masked, with the stacks,
+// services and labels consuming each of them and whether no stack references it anymore. Given
+// a name, only that secret is printed, exiting with exitSecretNotFound if there is none.
+func HandleSecretInventory(name string) error {
+	inventory, err := secretInventory()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return json.NewEncoder(os.Stdout).Encode(inventory)
+	}
+	for _, entry := range inventory {
+		if entry.Name == name {
+			return json.NewEncoder(os.Stdout).Encode(entry)
+		}
+	}
+	secretsLog.Error("Secret does not exist", "name", name)
+	os.Exit(exitSecretNotFound)
+	return nil
+}
+
+// HandleSecretPrune deletes the orphaned secrets from the env store, except those matching
+// secret_keep, and prints their names as JSON. With --dry-run=true nothing is deleted.
+func HandleSecretPrune() error {
+	inventory, err := secretInventory()
+	if err != nil {
+		return err
+	}
+	pruned := []string{}
+	for _, entry := range inventory {
+		if !entry.Orphaned || entry.Kept {
+			continue
+		}
+		if DryRun {
+			fmt.Fprintf(os.Stderr, "# Dry run: would delete orphaned secret %s\n", entry.Name)
+		} else if _, err := secrets.SetEnvFileKey(ProdEnvPath, entry.Name, nil); err != nil {
+			return err
+		} else {
+			writeFrame(FrameStdout, fmt.Sprintf("Deleted orphaned secret %s", entry.Name))
+		}
+		pruned = append(pruned, entry.Name)
+	}
+	return json.NewEncoder(os.Stdout).Encode(pruned)
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestKeptSecret(t *testing.T) {
+	t.Setenv("SECRET_KEEP", "BACKUP_*, legacy_token")
+	tests := map[string]bool{
+		"ADMIN_PASSWORD":      true,
+		"AUTH_SECRET_KEY":     true,
+		"stacks_dir":          true,
+		"HEARTBEAT_WEB":       true,
+		"DCAPI_TOKEN":         true,
+		"BACKUP_S3_KEY":       true,
+		"LEGACY_TOKEN":        true,
+		"WIKI_DB_PASSWORD":    false,
+		"NEXTCLOUD_ADMIN_PWD": false,
+	}
+	for name, want := range tests {
+		if got := keptSecret(name); got != want {
+			t.Errorf("keptSecret(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"dc/internal/secrets"
+)
+
+// Exit codes of `dc secret store` that dcapi maps to 404 and 409
+const (
+	exitSecretNotFound = 3
+	exitSecretExists   = 4
+)
+
+// secretNameRe matches the names usable as ${NAME} in compose files
+var secretNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// secretStoreError is a failed env store operation with the exit code telling why
+type secretStoreError struct {
+	code    int
+	message string
+}
+
+func (e *secretStoreError) Error() string { return e.message }
+
+// SecretStoreResult is printed by `dc secret store`; it never holds the value
+type SecretStoreResult struct {
+	Name   string `json:"name"`
+	Stack  string `json:"stack,omitempty"` // set for the env file of a stack
+	Status string `json:"status"`          // created, updated or deleted
+}
+
+// readSecretValue reads a secret value from stdin, without the trailing newline
+func readSecretValue(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("empty value on stdin")
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return "", fmt.Errorf("the value must be a single line")
+	}
+	return value, nil
+}
+
+// secretStore creates, updates, sets (creates or updates) or deletes a key of the env store
+// (prod.env, or the env file of the stack given with --stack) directly, without the secrets
+// manager
+func secretStore(op, name string, stdin io.Reader) (*SecretStoreResult, error) {
+	if !secretNameRe.MatchString(name) {
+		return nil, fmt.Errorf("invalid secret name %q", name)
+	}
+	envPath := ProdEnvPath
+	stack := getFlag("stack", "")
+	if stack != "" {
+		if err := validateStackName(stack); err != nil {
+			return nil, err
+		}
+		if envPath = stackEnvPath(stack); envPath == "" {
+			return nil, fmt.Errorf("stack %s has no env file of its own", stack)
+		}
+	}
+	vars, err := secrets.ReadEnvFile(envPath)
+	if err != nil {
+		return nil, err
+	}
+	_, exists := vars[name]
+	switch {
+	case op == "create" && exists:
+		return nil, &secretStoreError{exitSecretExists, fmt.Sprintf("secret %s already exists", name)}
+	case (op == "update" || op == "delete") && !exists:
+		return nil, &secretStoreError{exitSecretNotFound, fmt.Sprintf("secret %s does not exist", name)}
+	}
+
+	result := &SecretStoreResult{Name: name, Stack: stack, Status: "created"}
+	if exists {
+		result.Status = "updated"
+	}
+	var value *string
+	if op == "delete" {
+		result.Status = "deleted"
+	} else {
+		v, err := readSecretValue(stdin)
+		if err != nil {
+			return nil, err
+		}
+		value = &v
+	}
+	if DryRun {
+		fmt.Fprintf(os.Stderr, "# Dry run: secret %s would be %s in %s\n", name, result.Status, envPath)
+		return result, nil
+	}
+	if _, err := secrets.SetEnvFileKey(envPath, name, value); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// HandleSecretStore runs `dc secret store create|update|set|delete <name>`, the value read from
+// stdin, and prints the outcome as JSON. Failures because the secret exists or is missing exit
+// with exitSecretExists and exitSecretNotFound.
+func HandleSecretStore(op, name string) {
+	switch op {
+	case "create", "update", "set", "delete":
+	default:
+		secretsLog.Error("Unknown secret store operation (expected create, update, set or delete)", "operation", op)
+		os.Exit(1)
+	}
+	result, err := secretStore(op, name, os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if storeErr, ok := err.(*secretStoreError); ok {
+			os.Exit(storeErr.code)
+		}
+		os.Exit(1)
+	}
+	_ = json.NewEncoder(os.Stdout).Encode(result)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+	"gopkg.in/yaml.v3"
+)
+
+// serviceActions are the stack actions that can be limited to some of the services of a stack
+var serviceActions = map[compose.Action]bool{
+	compose.ActionUp:    true,
+	compose.ActionStart: true,
+	compose.ActionStop:  true,
+	compose.ActionDown:  true,
+}
+
+// positionalServices returns the names following the stack name when all of them are services
+// of that stack, as in `dc stack up mystack db app`. Otherwise the names are stacks of a bulk
+// operation and nil is returned.
+func positionalServices(args []string) []string {
+	names := bulkNames(args)
+	if len(names) < 2 {
+		return nil
+	}
+	body, _, err := findYAML(names[0])
+	if err != nil {
+		return nil
+	}
+	var composeFile compose.File
+	if err := yaml.Unmarshal(body, &composeFile); err != nil {
+		return nil
+	}
+	for _, name := range names[1:] {
+		if _, ok := composeFile.Services[name]; !ok {
+			return nil
+		}
+	}
+	return names[1:]
+}
+
+// selectedServices returns the services a stack action is limited to: --services=db,app (used by
+// dcapi) or the services named after the stack. None means the whole stack.
+func selectedServices(args []string) []string {
+	var services []string
+	for _, service := range strings.Split(getFlag("services", ""), ",") {
+		if service = strings.TrimSpace(service); service != "" {
+			services = append(services, service)
+		}
+	}
+	if len(services) > 0 {
+		return services
+	}
+	return positionalServices(args)
+}
+
+// checkServices fails if one of the services isn't part of the compose file
+func checkServices(composeFile *compose.File, stackName string, services []string) error {
+	var unknown []string
+	for _, service := range services {
+		if _, ok := composeFile.Services[service]; !ok {
+			unknown = append(unknown, service)
+		}
+	}
+	if len(unknown) > 0 {
+		known := make([]string, 0, len(composeFile.Services))
+		for name := range composeFile.Services {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return fmt.Errorf("stack %s has no service %s (services: %s)", stackName, strings.Join(unknown, ", "), strings.Join(known, ", "))
+	}
+	return nil
+}
+
+// withServices returns a copy of the compose file holding only the given services
+func withServices(composeFile *compose.File, services []string) *compose.File {
+	if len(services) == 0 {
+		return composeFile
+	}
+	only := *composeFile
+	only.Services = make(map[string]compose.Service, len(services))
+	for _, service := range services {
+		only.Services[service] = composeFile.Services[service]
+	}
+	return &only
+}
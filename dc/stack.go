@@ -3,80 +3,22 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"dc/internal/compose"
+	"dc/internal/docker"
 	"gopkg.in/yaml.v3"
 )
 
-// normalizeEnvironment converts environment variables from map or array format to array format
-// Returns an array of strings in "KEY=VALUE" format
-func normalizeEnvironment(env interface{}) []string {
-	if env == nil {
-		return nil
-	}
-
-	// If it's already an array
-	if envArray, ok := env.([]interface{}); ok {
-		result := make([]string, 0, len(envArray))
-		for _, item := range envArray {
-			if str, ok := item.(string); ok {
-				result = append(result, str)
-			}
-		}
-		return result
-	}
-
-	// If it's a map (from YAML)
-	if envMap, ok := env.(map[string]interface{}); ok {
-		result := make([]string, 0, len(envMap))
-		// Sort keys for consistent output
-		keys := make([]string, 0, len(envMap))
-		for k := range envMap {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-
-		for _, key := range keys {
-			value := envMap[key]
-			// Convert value to string
-			var valueStr string
-			switch v := value.(type) {
-			case string:
-				valueStr = v
-			case int, int64, float64, bool:
-				valueStr = fmt.Sprintf("%v", v)
-			default:
-				valueStr = fmt.Sprintf("%v", v)
-			}
-			result = append(result, fmt.Sprintf("%s=%s", key, valueStr))
-		}
-		return result
-	}
-
-	// If it's already a []string (shouldn't happen after unmarshal, but just in case)
-	if envStrings, ok := env.([]string); ok {
-		return envStrings
-	}
-
-	return nil
-}
-
-// setEnvironmentAsArray converts environment to array format and updates the service
-func setEnvironmentAsArray(service *ComposeService, envArray []string) {
-	if len(envArray) == 0 {
-		service.Environment = nil
-	} else {
-		service.Environment = envArray
-	}
-}
-
 // getStacksList returns a combined list of running stacks from Docker and available YAML files
 func getStacksList() ([]Stack, error) {
 	// Get all containers from Docker (running and stopped)
@@ -97,7 +39,7 @@ func getStacksList() ([]Stack, error) {
 		entries, err := os.ReadDir(dir)
 		if err != nil {
 			if !os.IsNotExist(err) {
-				log.Printf("Warning: failed to read directory %s: %v", dir, err)
+				stackLog.Debug("Failed to read directory", "dir", dir, "err", err)
 			}
 			continue
 		}
@@ -118,50 +60,92 @@ func getStacksList() ([]Stack, error) {
 	}
 
 	// Add YAML stacks that are not running (with simulated containers)
-	for stackName, filePath := range ymlStacks {
-		if !runningStackNames[stackName] {
-			// Parse YAML file and create simulated containers
-			simulatedContainers, err := createSimulatedContainers(stackName, filePath, allContainers)
-			if err != nil {
-				log.Printf("Error creating simulated containers for %s: %v", stackName, err)
-				// Still add the stack but with empty containers
-				runningStacks = append(runningStacks, Stack{
-					Name:       stackName,
-					Containers: []DockerInspect{},
-				})
-			} else {
-				runningStacks = append(runningStacks, Stack{
-					Name:       stackName,
-					Containers: simulatedContainers,
-				})
-			}
+	runningStacks = append(runningStacks, simulateStacks(ymlStacks, runningStackNames, allContainers)...)
+
+	sort.Slice(runningStacks, func(i, j int) bool { return runningStacks[i].Name < runningStacks[j].Name })
+	return runningStacks, nil
+}
+
+// simulateStacks creates the simulated containers of the stack files that aren't running, at
+// most listParallelism files at a time
+func simulateStacks(ymlStacks map[string]string, running map[string]bool, allContainers []map[string]interface{}) []Stack {
+	var names []string
+	for stackName := range ymlStacks {
+		if !running[stackName] {
+			names = append(names, stackName)
 		}
 	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	inspected := inspectByName(allContainers)
 
-	return runningStacks, nil
+	stacks := make([]Stack, len(names))
+	forEachParallel(len(names), listParallelism(), func(i int) {
+		stackName := names[i]
+		simulatedContainers, err := createSimulatedContainers(stackName, ymlStacks[stackName], inspected)
+		if err != nil {
+			stackLog.Debug("Error creating simulated containers", "stack", stackName, "err", err)
+			// Still add the stack but with empty containers
+			simulatedContainers = []docker.Inspect{}
+		}
+		stacks[i] = Stack{Name: stackName, Containers: simulatedContainers}
+	})
+	return stacks
 }
 
-// streamCommandOutput executes a command and streams its stdout and stderr to the HTTP response
-// using chunked transfer encoding. Returns error if command execution fails.
-// Note: Headers should be set by the caller before calling this function if multiple commands are streamed.
+// inspectByName maps the containers of getAllContainers, which already hold their docker
+// inspect data, by container name
+func inspectByName(allContainers []map[string]interface{}) map[string]docker.Inspect {
+	inspectedMap := make(map[string]docker.Inspect)
+	for _, container := range allContainers {
+		jsonData, err := json.Marshal(container)
+		if err != nil {
+			continue
+		}
+		var inspected docker.Inspect
+		if err := json.Unmarshal(jsonData, &inspected); err != nil {
+			stackLog.Debug("Error converting container data", "err", err)
+			continue
+		}
+		inspectedMap[strings.TrimPrefix(inspected.Name, "/")] = inspected
+	}
+	return inspectedMap
+}
+
+// streamCommandOutput executes a command and streams its stdout and stderr as output frames
+// (see writeFrame). Returns error if command execution fails.
 func streamCommandOutput(cmd *exec.Cmd) error {
+	_, err := streamCommand(cmd, 0)
+	return err
+}
+
+// commandTailLines is how many lines of stderr streamCommand returns
+const commandTailLines = 50
+
+// streamCommand is streamCommandOutput stopping the command after timeout (0 for none). It
+// returns the last lines of stderr, where docker reports why it failed.
+func streamCommand(cmd *exec.Cmd, timeout time.Duration) ([]string, error) {
 
 	// Get pipes for stdout and stderr
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("failed to get stdout pipe: %w", err)
+		return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
 	}
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return fmt.Errorf("failed to get stderr pipe: %w", err)
+		return nil, fmt.Errorf("failed to get stderr pipe: %w", err)
 	}
 
 	// Start the command
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start command: %w", err)
+		return nil, fmt.Errorf("failed to start command: %w", err)
 	}
+	exited := cancelOnSignal(cmd, timeout)
 
 	// Use WaitGroup to wait for both streams to complete
+	var tail []string
 	var wg sync.WaitGroup
 	wg.Add(2)
 
@@ -170,8 +154,7 @@ func streamCommandOutput(cmd *exec.Cmd) error {
 		defer wg.Done()
 		scanner := bufio.NewScanner(stdout)
 		for scanner.Scan() {
-			line := scanner.Text()
-			fmt.Fprintf(os.Stderr, "[STDOUT] %s\n", line)
+			writeFrame(FrameStdout, scanner.Text())
 		}
 	}()
 
@@ -180,8 +163,10 @@ func streamCommandOutput(cmd *exec.Cmd) error {
 		defer wg.Done()
 		scanner := bufio.NewScanner(stderr)
 		for scanner.Scan() {
-			line := scanner.Text()
-			fmt.Fprintf(os.Stderr, "[STDERR] %s\n", line)
+			writeFrame(FrameStderr, scanner.Text())
+			if tail = append(tail, scanner.Text()); len(tail) > commandTailLines {
+				tail = tail[1:]
+			}
 		}
 	}()
 
@@ -189,30 +174,58 @@ func streamCommandOutput(cmd *exec.Cmd) error {
 	wg.Wait()
 
 	// Wait for command to finish and get exit status
-	if err := cmd.Wait(); err != nil {
-		fmt.Fprintf(os.Stderr, "[ERROR] Command failed: %v\n", err)
-		return err
+	err = cmd.Wait()
+	switch exited() {
+	case stopCancelled:
+		return tail, errCancelled
+	case stopTimedOut:
+		err = fmt.Errorf("%w after %s", errTimedOut, timeout)
+	}
+	if err != nil {
+		writeFrame(FrameError, fmt.Sprintf("Command failed: %v", err))
+		return tail, err
 	}
 
-	fmt.Fprintf(os.Stderr, "[DONE] Command completed successfully\n")
+	writeFrame(FrameDone, "Command completed successfully")
 
-	return nil
+	return tail, nil
 }
 
 // HandleListStacks handles GET /api/stacks
-// Returns a combined list of running stacks from Docker and available YAML files
-func HandleListStacks() {
+// Returns a combined list of running stacks from Docker and available YAML files, filtered by
+// --name, --label, --state and --group and paged by --limit and --offset
+func HandleListStacks() error {
+	if getFlag("detail", "") == "summary" {
+		return HandleListStackSummaries()
+	}
+	filter, err := listFilterFromFlags()
+	if err != nil {
+		return err
+	}
 	stacks, err := getStacksList()
 	if err != nil {
-		log.Printf("Error getting stacks list: %v", err)
-		return
+		stackLog.Debug("Error getting stacks list", "err", err)
+		return nil
+	}
+	groups := stackGroups()
+	labels := stackLabels()
+	filtered := []Stack{}
+	for _, stack := range stacks {
+		stack.Group = groups[stack.Name]
+		for i := range stack.Containers {
+			redactContainerEnv(&stack.Containers[i])
+		}
+		if filter.matches(stack.Name, labels[stack.Name], stackState(stack.Containers), stack.Group) {
+			filtered = append(filtered, stack)
+		}
 	}
-	json.NewEncoder(os.Stdout).Encode(stacks)
+	return json.NewEncoder(os.Stdout).Encode(paginate(filtered, filter))
 }
 
 // createSimulatedContainers creates simulated container objects from a docker-compose.yml file
-// Uses raw docker inspect JSON format with lowercase keys
-func createSimulatedContainers(stackName, filePath string, allContainers []map[string]interface{}) ([]DockerInspect, error) {
+// Uses raw docker inspect JSON format with lowercase keys. Services whose container exists in
+// inspectedMap (by container name, see inspectByName) get its real inspect data instead.
+func createSimulatedContainers(stackName, filePath string, inspectedMap map[string]docker.Inspect) ([]docker.Inspect, error) {
 	// Read the YAML file
 	content, err := os.ReadFile(filePath)
 	if err != nil {
@@ -220,46 +233,15 @@ func createSimulatedContainers(stackName, filePath string, allContainers []map[s
 	}
 
 	// Parse the YAML
-	var compose ComposeFile
-	if err := yaml.Unmarshal(content, &compose); err != nil {
+	var composeFile compose.File
+	if err := yaml.Unmarshal(content, &composeFile); err != nil {
 		return nil, fmt.Errorf("failed to parse YAML: %w", err)
 	}
 
-	// Get existing container IDs for inspection
-	var existingContainerIDs []string
-	containerNameToID := make(map[string]string)
-	for _, container := range allContainers {
-		if names, ok := container["Names"].(string); ok {
-			normalizedName := strings.TrimPrefix(names, "/")
-			if id, ok := container["ID"].(string); ok {
-				containerNameToID[normalizedName] = id
-				existingContainerIDs = append(existingContainerIDs, id)
-			}
-		}
-	}
-
-	// Inspect existing containers to get full details
-	var inspectedContainers []DockerInspect
-	if len(existingContainerIDs) > 0 {
-		inspectData, err := inspectContainers(existingContainerIDs)
-		if err != nil {
-			log.Printf("Warning: failed to inspect containers: %v", err)
-		} else {
-			inspectedContainers = inspectData
-		}
-	}
-
-	// Create a map of inspected containers by name for quick lookup
-	inspectedMap := make(map[string]DockerInspect)
-	for _, inspected := range inspectedContainers {
-		normalizedName := strings.TrimPrefix(inspected.Name, "/")
-		inspectedMap[normalizedName] = inspected
-	}
-
-	var containers []DockerInspect
+	var containers []docker.Inspect
 
 	// Create a simulated container for each service
-	for serviceName, service := range compose.Services {
+	for serviceName, service := range composeFile.Services {
 		containerName := service.ContainerName
 		if containerName == "" {
 			containerName = serviceName
@@ -270,288 +252,7 @@ func createSimulatedContainers(stackName, filePath string, allContainers []map[s
 			// Use the real docker inspect data
 			containers = append(containers, inspectedData)
 		} else {
-			// Create a simulated docker inspect format container
-			// Build labels map
-			labels := make(map[string]string)
-			labels["com.docker.compose.project"] = stackName
-			labels["com.docker.compose.service"] = serviceName
-			labels["com.docker.compose.oneoff"] = "False"
-
-			// Add custom labels from the service definition
-			if service.Labels != nil {
-				switch v := service.Labels.(type) {
-				case []interface{}:
-					for _, label := range v {
-						if labelStr, ok := label.(string); ok {
-							if parts := strings.SplitN(labelStr, "=", 2); len(parts) == 2 {
-								labels[parts[0]] = parts[1]
-							}
-						}
-					}
-				case map[string]interface{}:
-					for k, val := range v {
-						labels[k] = fmt.Sprintf("%v", val)
-					}
-				}
-			}
-
-			// Build command array
-			var cmd []string
-			switch v := service.Command.(type) {
-			case string:
-				cmd = []string{v}
-			case []interface{}:
-				for _, c := range v {
-					if s, ok := c.(string); ok {
-						cmd = append(cmd, s)
-					}
-				}
-			}
-
-			// Build environment array
-			var env []string
-			switch v := service.Environment.(type) {
-			case []interface{}:
-				for _, e := range v {
-					if s, ok := e.(string); ok {
-						env = append(env, s)
-					}
-				}
-			case map[string]interface{}:
-				for k, val := range v {
-					env = append(env, fmt.Sprintf("%s=%v", k, val))
-				}
-			}
-
-			// Build mounts from volumes
-			var mounts []Mount
-			for _, volume := range service.Volumes {
-				parts := strings.Split(volume, ":")
-				mountType := "volume"
-				source := ""
-				destination := ""
-
-				if len(parts) >= 2 {
-					source = parts[0]
-					destination = parts[1]
-					// If source starts with / or ./, it's a bind mount
-					if strings.HasPrefix(source, "/") || strings.HasPrefix(source, "./") {
-						mountType = "bind"
-					}
-				}
-
-				mounts = append(mounts, Mount{
-					Type:        mountType,
-					Source:      source,
-					Destination: destination,
-					Mode:        "",
-					RW:          true,
-					Propagation: "rprivate",
-				})
-			}
-
-			// Build networks
-			networks := make(map[string]EndpointSettings)
-			switch v := service.Networks.(type) {
-			case []interface{}:
-				for _, net := range v {
-					if netStr, ok := net.(string); ok {
-						networks[netStr] = EndpointSettings{}
-					}
-				}
-			case map[string]interface{}:
-				for net := range v {
-					networks[net] = EndpointSettings{}
-				}
-			}
-
-			// Build exposed ports and port bindings
-			exposedPorts := make(map[string]interface{})
-			portBindings := make(map[string][]PortBinding)
-			for _, portStr := range service.Ports {
-				// Parse port format: "host:container" or "container"
-				parts := strings.Split(portStr, ":")
-				containerPort := ""
-				hostPort := ""
-
-				if len(parts) == 2 {
-					hostPort = parts[0]
-					containerPort = parts[1]
-				} else if len(parts) == 1 {
-					containerPort = parts[0]
-				}
-
-				// Add protocol if not present
-				if !strings.Contains(containerPort, "/") {
-					containerPort = containerPort + "/tcp"
-				}
-
-				exposedPorts[containerPort] = struct{}{}
-
-				if hostPort != "" {
-					portBindings[containerPort] = []PortBinding{
-						{
-							HostIP:   "0.0.0.0",
-							HostPort: hostPort,
-						},
-					}
-				}
-			}
-
-			// Create simulated docker inspect format
-			container := DockerInspect{
-				ID:      "",
-				Created: "",
-				Path:    "",
-				Args:    []string{},
-				State: ContainerState{
-					Status:     "created",
-					Running:    false,
-					Paused:     false,
-					Restarting: false,
-					OOMKilled:  false,
-					Dead:       false,
-					Pid:        0,
-					ExitCode:   0,
-					Error:      "",
-					StartedAt:  "",
-					FinishedAt: "",
-				},
-				Image:           service.Image,
-				ResolvConfPath:  "",
-				HostnamePath:    "",
-				HostsPath:       "",
-				LogPath:         "",
-				Name:            "/" + containerName,
-				RestartCount:    0,
-				Driver:          "overlay2",
-				Platform:        "linux",
-				MountLabel:      "",
-				ProcessLabel:    "",
-				AppArmorProfile: "",
-				ExecIDs:         nil,
-				HostConfig: HostConfig{
-					Binds:           service.Volumes,
-					ContainerIDFile: "",
-					LogConfig: LogConfig{
-						Type:   "json-file",
-						Config: map[string]string{},
-					},
-					NetworkMode:  "default",
-					PortBindings: portBindings,
-					RestartPolicy: RestartPolicy{
-						Name:              "no",
-						MaximumRetryCount: 0,
-					},
-					AutoRemove:           false,
-					VolumeDriver:         "",
-					VolumesFrom:          nil,
-					CapabilityAdd:        nil,
-					CapabilityDrop:       nil,
-					DNS:                  []string{},
-					DNSOptions:           []string{},
-					DNSSearch:            []string{},
-					ExtraHosts:           nil,
-					GroupAdd:             nil,
-					IpcMode:              "private",
-					Cgroup:               "",
-					Links:                nil,
-					OomScoreAdj:          0,
-					PidMode:              "",
-					Privileged:           false,
-					PublishAllPorts:      false,
-					ReadonlyRootfs:       false,
-					SecurityOpt:          nil,
-					UTSMode:              "",
-					UsernsMode:           "",
-					ShmSize:              67108864,
-					Runtime:              "runc",
-					ConsoleSize:          []int{0, 0},
-					Isolation:            "",
-					CPUShares:            0,
-					Memory:               0,
-					NanoCPUs:             0,
-					CgroupParent:         "",
-					BlkioWeight:          0,
-					BlkioWeightDevice:    nil,
-					BlkioDeviceReadBps:   nil,
-					BlkioDeviceWriteBps:  nil,
-					BlkioDeviceReadIOps:  nil,
-					BlkioDeviceWriteIOps: nil,
-					CPUPeriod:            0,
-					CPUQuota:             0,
-					CPURealtimePeriod:    0,
-					CPURealtimeRuntime:   0,
-					CpusetCpus:           "",
-					CpusetMems:           "",
-					Devices:              nil,
-					DeviceCgroupRules:    nil,
-					DiskQuota:            0,
-					KernelMemory:         0,
-					MemoryReservation:    0,
-					MemorySwap:           0,
-					MemorySwappiness:     nil,
-					OomKillDisable:       nil,
-					PidsLimit:            nil,
-					Ulimits:              nil,
-					CPUCount:             0,
-					CPUPercent:           0,
-					IOMaximumIOps:        0,
-					IOMaximumBandwidth:   0,
-				},
-				GraphDriver: GraphDriver{
-					Name: "overlay2",
-					Data: map[string]string{
-						"lowerdir":  "",
-						"mergeddir": "",
-						"upperdir":  "",
-						"workdir":   "",
-					},
-				},
-				Mounts: mounts,
-				Config: ContainerConfig{
-					Hostname:     containerName,
-					Domainname:   "",
-					User:         "",
-					AttachStdin:  false,
-					AttachStdout: false,
-					AttachStderr: false,
-					ExposedPorts: exposedPorts,
-					Tty:          false,
-					OpenStdin:    false,
-					StdinOnce:    false,
-					Env:          env,
-					Cmd:          cmd,
-					Image:        service.Image,
-					Volumes:      nil,
-					WorkingDir:   "",
-					Entrypoint:   nil,
-					OnBuild:      nil,
-					Labels:       labels,
-				},
-				NetworkSettings: NetworkSettings{
-					Bridge:                 "",
-					SandboxID:              "",
-					HairpinMode:            false,
-					LinkLocalIPv6Address:   "",
-					LinkLocalIPv6PrefixLen: 0,
-					Ports:                  portBindings,
-					SandboxKey:             "",
-					SecondaryIPAddresses:   nil,
-					SecondaryIPv6Addresses: nil,
-					EndpointID:             "",
-					Gateway:                "",
-					GlobalIPv6Address:      "",
-					GlobalIPv6PrefixLen:    0,
-					IPAddress:              "",
-					IPPrefixLen:            0,
-					IPv6Gateway:            "",
-					MacAddress:             "",
-					Networks:               networks,
-				},
-			}
-
-			containers = append(containers, container)
+			containers = append(containers, docker.Simulate(stackName, serviceName, containerName, service))
 		}
 	}
 
@@ -561,7 +262,7 @@ func createSimulatedContainers(stackName, filePath string, allContainers []map[s
 // getRunningStacks executes docker ps and returns stacks grouped by compose project
 func getRunningStacks() ([]Stack, error) {
 	// Execute docker ps command
-	cmd := exec.Command("docker", "ps", "-a", "--no-trunc", "--format", "json")
+	cmd := engineCommand("ps", "-a", "--no-trunc", "--format", "json")
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute docker ps: %w", err)
@@ -578,7 +279,7 @@ func getRunningStacks() ([]Stack, error) {
 
 		var container map[string]interface{}
 		if err := json.Unmarshal([]byte(line), &container); err != nil {
-			log.Printf("Error parsing container JSON: %v", err)
+			stackLog.Debug("Error parsing container JSON", "err", err)
 			continue
 		}
 
@@ -615,26 +316,23 @@ func getRunningStacks() ([]Stack, error) {
 		}
 	}
 
-	// Inspect all containers and group by stack
-	var stacks []Stack
-	for projectName, containerIDs := range stacksMap {
-		// Inspect containers to get full details
-		inspectedContainers, err := inspectContainers(containerIDs)
+	// Inspect the containers of the stacks concurrently, listed in the order of their names
+	projectNames := make([]string, 0, len(stacksMap))
+	for projectName := range stacksMap {
+		projectNames = append(projectNames, projectName)
+	}
+	sort.Strings(projectNames)
+	stacks := make([]Stack, len(projectNames))
+	forEachParallel(len(projectNames), listParallelism(), func(i int) {
+		projectName := projectNames[i]
+		inspectedContainers, err := inspectContainers(stacksMap[projectName])
 		if err != nil {
-			log.Printf("Warning: failed to inspect containers for stack %s: %v", projectName, err)
+			stackLog.Debug("Failed to inspect containers", "stack", projectName, "err", err)
 			// Add stack with empty containers on error
-			stacks = append(stacks, Stack{
-				Name:       projectName,
-				Containers: []DockerInspect{},
-			})
-			continue
+			inspectedContainers = []docker.Inspect{}
 		}
-
-		stacks = append(stacks, Stack{
-			Name:       projectName,
-			Containers: inspectedContainers,
-		})
-	}
+		stacks[i] = Stack{Name: projectName, Containers: inspectedContainers}
+	})
 
 	return stacks, nil
 }
@@ -676,19 +374,19 @@ func findContainersByProjectName(projectName string) ([]string, error) {
 }
 
 // inspectContainers runs docker inspect on the given container IDs and returns the parsed JSON
-func inspectContainers(containerIDs []string) ([]DockerInspect, error) {
+func inspectContainers(containerIDs []string) ([]docker.Inspect, error) {
 	if len(containerIDs) == 0 {
-		return []DockerInspect{}, nil
+		return []docker.Inspect{}, nil
 	}
 
 	args := append([]string{"inspect"}, containerIDs...)
-	cmd := exec.Command("docker", args...)
+	cmd := engineCommand(args...)
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("failed to inspect containers: %w", err)
 	}
 
-	var inspectData []DockerInspect
+	var inspectData []docker.Inspect
 	if err := json.Unmarshal(output, &inspectData); err != nil {
 		return nil, fmt.Errorf("failed to parse inspect output: %w", err)
 	}
@@ -697,14 +395,36 @@ func inspectContainers(containerIDs []string) ([]DockerInspect, error) {
 }
 
 // reconstructComposeFromContainers creates a docker-compose YAML from container inspection data
-func reconstructComposeFromContainers(inspectData []DockerInspect, stackName string) (string, error) {
-	compose := ComposeFile{
-		Services: make(map[string]ComposeService),
-		Volumes:  make(map[string]ComposeVolume),
-		Networks: make(map[string]ComposeNetwork),
-		Configs:  make(map[string]ComposeConfig),
-		Secrets:  make(map[string]ComposeSecret),
+func reconstructComposeFromContainers(inspectData []docker.Inspect, stackName string) (string, error) {
+	composeFile := reconstructCompose(inspectData, stackName)
+	maskComposePasswords(&composeFile, stackName)
+
+	// Marshal to YAML with 2-space indentation and multiline string support
+	var buf strings.Builder
+
+	// Add disclaimer comment at the top
+	buf.WriteString("# This docker-compose.yml was automatically reconstructed from running and stopped containers.\n")
+	buf.WriteString("# Some settings may be incomplete or differ from the original configuration.\n")
+	buf.WriteString("# Please review and adjust as needed before using in production.\n")
+
+	if err := encodeYAMLWithMultiline(&buf, &composeFile); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// reconstructCompose creates the compose file of a stack from the inspection data of its containers.
+// Passwords in the environment keep their values; callers mask or store them.
+func reconstructCompose(inspectData []docker.Inspect, stackName string) compose.File {
+	composeFile := compose.File{
+		Services: make(map[string]compose.Service),
+		Volumes:  make(map[string]compose.Volume),
+		Networks: make(map[string]compose.Network),
+		Configs:  make(map[string]compose.Config),
+		Secrets:  make(map[string]compose.Secret),
 	}
+	images := make(map[string]docker.ContainerConfig)
 
 	for _, containerData := range inspectData {
 		// Skip containers that don't belong to this stack
@@ -712,6 +432,13 @@ func reconstructComposeFromContainers(inspectData []DockerInspect, stackName str
 			continue
 		}
 
+		// Values the container shares with its image are left out, compose takes them from there
+		image, ok := images[containerData.Config.Image]
+		if !ok {
+			image = imageConfig(containerData.Config.Image)
+			images[containerData.Config.Image] = image
+		}
+
 		// Extract service name from labels
 		labels := containerData.Config.Labels
 		serviceName, ok := labels["com.docker.compose.service"]
@@ -729,10 +456,38 @@ func reconstructComposeFromContainers(inspectData []DockerInspect, stackName str
 				strings.HasPrefix(key, "org.opencontainers.image") {
 				continue
 			}
-			serviceLabels[key] = value
+			if imageValue, ok := image.Labels[key]; ok && imageValue == value {
+				continue
+			}
+			serviceLabels[key] = compose.EscapeDollars(value)
+		}
+		service := compose.Service{}
+		if len(serviceLabels) > 0 {
+			service.Labels = serviceLabels
+		}
+
+		// depends_on is recorded by compose as "service:condition:restart,..."
+		if dependsOn := labels["com.docker.compose.depends_on"]; dependsOn != "" {
+			deps := make(map[string]interface{})
+			for _, dep := range strings.Split(dependsOn, ",") {
+				parts := strings.Split(dep, ":")
+				if parts[0] == "" {
+					continue
+				}
+				condition := compose.ConditionServiceStarted
+				if len(parts) > 1 && parts[1] != "" {
+					condition = parts[1]
+				}
+				dependency := map[string]interface{}{"condition": condition}
+				if len(parts) > 2 && parts[2] == "true" {
+					dependency["restart"] = true
+				}
+				deps[parts[0]] = dependency
+			}
+			if len(deps) > 0 {
+				service.DependsOn = deps
+			}
 		}
-		service := ComposeService{}
-		service.Labels = serviceLabels
 
 		// Image
 		service.Image = containerData.Config.Image
@@ -748,9 +503,15 @@ func reconstructComposeFromContainers(inspectData []DockerInspect, stackName str
 			service.Restart = containerData.HostConfig.RestartPolicy.Name
 		}
 
-		// Command
-		if len(containerData.Config.Cmd) > 0 {
-			service.Command = containerData.Config.Cmd
+		// Entrypoint and command, unless they are the image's
+		if entrypoint := containerData.Config.Entrypoint; len(entrypoint) > 0 && !slices.Equal(entrypoint, image.Entrypoint) {
+			service.Entrypoint = escapeAllDollars(entrypoint)
+		}
+		if command := containerData.Config.Cmd; len(command) > 0 && !slices.Equal(command, image.Cmd) {
+			service.Command = escapeAllDollars(command)
+		}
+		if user := containerData.Config.User; user != "" && user != image.User {
+			service.User = user
 		}
 
 		// Environment variables
@@ -761,8 +522,11 @@ func reconstructComposeFromContainers(inspectData []DockerInspect, stackName str
 				// Keep only user-defined environment variables
 				if !strings.HasPrefix(envStr, "PATH=") &&
 					!strings.HasPrefix(envStr, "HOSTNAME=") &&
-					!strings.HasPrefix(envStr, "HOME=") {
-					envVars = append(envVars, sanitizeEnvironmentVariable(envStr))
+					!strings.HasPrefix(envStr, "HOME=") &&
+					!slices.Contains(image.Env, envStr) {
+					// values from docker are literal, the stack file needs $ escaped
+					key, value, _ := strings.Cut(envStr, "=")
+					envVars = append(envVars, key+"="+compose.EscapeDollars(value))
 				}
 			}
 			if len(envVars) > 0 {
@@ -770,15 +534,25 @@ func reconstructComposeFromContainers(inspectData []DockerInspect, stackName str
 			}
 		}
 
-		// Ports
+		// Ports (bind addresses are preserved, IPv6 ones in brackets)
+		seenPorts := make(map[string]bool)
 		for containerPort, bindings := range containerData.HostConfig.PortBindings {
+			port, protocol, _ := strings.Cut(containerPort, "/")
+			if protocol == "tcp" {
+				protocol = ""
+			}
 			for _, binding := range bindings {
-				hostPort := binding.HostPort
-				if hostPort != "" {
-					service.Ports = append(service.Ports, fmt.Sprintf("%s:%s", hostPort, containerPort))
+				if binding.HostPort == "" {
+					continue
+				}
+				spec := compose.PortSpec{HostIP: binding.HostIP, HostPort: binding.HostPort, ContainerPort: port, Protocol: protocol}
+				if mapping := spec.String(); !seenPorts[mapping] {
+					seenPorts[mapping] = true
+					service.Ports = append(service.Ports, mapping)
 				}
 			}
 		}
+		sort.Strings(service.Ports)
 
 		// Volumes/Mounts
 		for _, mount := range containerData.Mounts {
@@ -796,153 +570,236 @@ func reconstructComposeFromContainers(inspectData []DockerInspect, stackName str
 			}
 		}
 
-		// Networks
-		var networkNames []string
-		for networkName := range containerData.NetworkSettings.Networks {
-			networkNames = append(networkNames, networkName)
-		}
-		if len(networkNames) > 0 {
-			service.Networks = networkNames
+		// Networks; the host and none networks and sharing another container's stack are modes,
+		// and the default bridge network is what services without networks join
+		switch mode := containerData.HostConfig.NetworkMode; {
+		case mode == "host" || mode == "none" || strings.HasPrefix(mode, "container:"):
+			service.NetworkMode = mode
+		default:
+			var networkNames []string
+			for networkName := range containerData.NetworkSettings.Networks {
+				if networkName != "bridge" {
+					networkNames = append(networkNames, networkName)
+				}
+			}
+			sort.Strings(networkNames)
+			if len(networkNames) > 0 {
+				service.Networks = networkNames
+			}
 		}
 
+		reconstructHostConfig(&service, containerData.HostConfig)
+		service.Healthcheck = reconstructHealthcheck(containerData.Config.Healthcheck, image.Healthcheck)
+
 		enrichWithProxy(&service, serviceName)
 
-		compose.Services[serviceName] = service
+		composeFile.Services[serviceName] = service
 	}
 
 	// Process secrets to ensure proper declaration
-	processSecrets(&compose)
-
-	// Marshal to YAML with 2-space indentation and multiline string support
-	var buf strings.Builder
-
-	// Add disclaimer comment at the top
-	buf.WriteString("# This docker-compose.yml was automatically reconstructed from running and stopped containers.\n")
-	buf.WriteString("# Some settings may be incomplete or differ from the original configuration.\n")
-	buf.WriteString("# Please review and adjust as needed before using in production.\n")
-
-	if err := encodeYAMLWithMultiline(&buf, &compose); err != nil {
-		return "", err
-	}
-
-	return buf.String(), nil
+	processSecrets(&composeFile, stackName)
+	return composeFile
 }
 
-func HandleDockerComposeFile(body []byte, stackName string, dryRun bool, action ComposeAction) {
+// HandleDockerComposeFile enriches a stack file and runs a docker compose action on it. Given
+// services, up, start, stop and down only act on those services. It fails if a check stops the
+// action before docker compose runs, or if docker compose fails.
+func HandleDockerComposeFile(body []byte, stackName string, dryRun bool, action compose.Action, services ...string) error {
 	// First, sanitize passwords and extract them to prod.env
 	// This must be done BEFORE enrichment to capture plaintext passwords
-	var modifiedComposeFile ComposeFile
+	var modifiedComposeFile compose.File
 	if err := yaml.Unmarshal(body, &modifiedComposeFile); err != nil {
-		log.Printf("Error parsing YAML for sanitization: %v", err)
-		fmt.Fprintf(os.Stderr, "Failed to parse YAML: %v\n", err)
-		return
+		return fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	reportExtractedSecrets(sanitizeComposePasswords(&modifiedComposeFile, stackName))
+	redactName(stackName)
+	for serviceName := range modifiedComposeFile.Services {
+		redactName(serviceName)
 	}
-	sanitizeComposePasswords(&modifiedComposeFile)
 
 	// Marshal the sanitized original version back to YAML for .yml file
 	var originalComposeYamlBuffer strings.Builder
 	if err := encodeYAMLWithMultiline(&originalComposeYamlBuffer, &modifiedComposeFile); err != nil {
-		log.Printf("Failed to serialize original YAML: %v", err)
-		fmt.Fprintf(os.Stderr, "Failed to serialize original YAML: %v\n", err)
-		return
+		return fmt.Errorf("failed to serialize original YAML: %w", err)
 	}
 
-	enrichAndSanitizeCompose(&modifiedComposeFile)
+	if err := checkServices(&modifiedComposeFile, stackName, services); err != nil {
+		return err
+	}
+	if action == compose.ActionUp || action == compose.ActionCreate {
+		if err := enforcePolicies(body, stackName); err != nil {
+			return err
+		}
+	}
+
+	enrichAndSanitizeCompose(&modifiedComposeFile, stackName)
+	if action == compose.ActionUp || action == compose.ActionCreate {
+		if err := checkBudget(&modifiedComposeFile, stackName); err != nil {
+			return err
+		}
+	}
 
 	// Marshal the sanitized original version back to YAML for .yml file
 	var modifiedComposeYamlBuffer strings.Builder
 	if err := encodeYAMLWithMultiline(&modifiedComposeYamlBuffer, &modifiedComposeFile); err != nil {
-		log.Printf("Failed to serialize modified YAML: %v", err)
-		fmt.Fprintf(os.Stderr, "Failed to serialize modified YAML: %v\n", err)
-		return
+		return fmt.Errorf("failed to serialize modified YAML: %w", err)
 	}
 
 	var cmd *exec.Cmd
 	var actionName string
+	backend := stackBackend(&modifiedComposeFile)
 
 	if dryRun {
-		return
+		reportDryRun(stackName, backend, action, originalComposeYamlBuffer.String(), modifiedComposeYamlBuffer.String())
+		return nil
+	}
+	if backend == BackendSwarm && len(services) > 0 {
+		return fmt.Errorf("the swarm backend deploys whole stacks; it can't %s single services", action)
+	}
+
+	// newCommand serializes the stack file for the backend and returns the command running the
+	// action on it with the serialized file, a nil command if that failed
+	newCommand := func(projectDir string) (*exec.Cmd, string) {
+		command, err := backendCommand(backend, stackName, action, projectDir)
+		if err != nil {
+			stackLog.Error(err.Error())
+			return nil, ""
+		}
+		modifiedComposeYamlWithPlainTextSecrets, done := serializeYamlWithPlainTextSecrets(&modifiedComposeFile, stackName)
+		if done {
+			return nil, ""
+		}
+		command.Stdin = strings.NewReader(modifiedComposeYamlWithPlainTextSecrets)
+		return command, modifiedComposeYamlWithPlainTextSecrets
 	}
 
 	switch action {
-	case ComposeActionUp:
+	case compose.ActionUp:
 		actionName = "up"
-		// Create missing networks and volumes before docker modifiedComposeFile up/down
-		if err := ensureNetworksExist(&modifiedComposeFile); err != nil {
-			log.Printf("Error ensuring networks exist for stack %s: %v", stackName, err)
-			fmt.Fprintf(os.Stderr, "[ERROR] Failed to ensure networks exist: %v\n", err)
-		}
-		if err := ensureVolumesExist(&modifiedComposeFile); err != nil {
-			log.Printf("Error ensuring volumes exist for stack %s: %v", stackName, err)
-			fmt.Fprintf(os.Stderr, "[ERROR] Failed to ensure volumes exist: %v\n", err)
+		// Create missing networks and volumes before docker modifiedComposeFile up/down; swarm
+		// creates its overlay networks and volumes itself
+		if backend == BackendCompose {
+			if err := ensureNetworksExist(&modifiedComposeFile); err != nil {
+				stackLog.Debug("Error ensuring networks exist", "stack", stackName, "err", err)
+				stackLog.Error("Failed to ensure networks exist", "err", err)
+			}
+			if err := ensureVolumesExist(&modifiedComposeFile); err != nil {
+				stackLog.Debug("Error ensuring volumes exist", "stack", stackName, "err", err)
+				stackLog.Error("Failed to ensure volumes exist", "err", err)
+			}
 		}
 
-		if modifiedComposeYamlWithPlainTextSecrets, done := serializeYamlWithPlainTextSecrets(&modifiedComposeFile); !done {
-			cmd = exec.Command("docker", "compose", "-f", "-", "-p", stackName, "up", "-d", "--wait", "--remove-orphans")
-			cmd.Stdin = strings.NewReader(modifiedComposeYamlWithPlainTextSecrets)
+		if cmd, _ = newCommand(""); cmd != nil {
+			// Abort before touching any container when the pre-deploy checks fail
+			if err := checkHostRequirements(&modifiedComposeFile, stackName); err != nil {
+				return err
+			}
+			if err := runPreflightChecks(&modifiedComposeFile, stackName); err != nil {
+				return err
+			}
+			if err := prePullStack(&modifiedComposeFile, stackName); err != nil {
+				return fmt.Errorf("pre-deploy pull failed: %w", err)
+			}
 		}
-	case ComposeActionDown:
+	case compose.ActionDown:
 		actionName = "down"
-		if modifiedComposeYamlWithPlainTextSecrets, done := serializeYamlWithPlainTextSecrets(&modifiedComposeFile); !done {
+		var modifiedComposeYamlWithPlainTextSecrets string
+		if cmd, modifiedComposeYamlWithPlainTextSecrets = newCommand(""); cmd != nil {
 			os.Stdout.WriteString(modifiedComposeYamlWithPlainTextSecrets)
-
-			cmd = exec.Command("docker", "compose", "-f", "-", "-p", stackName, actionName)
-			cmd.Stdin = strings.NewReader(modifiedComposeYamlWithPlainTextSecrets)
 		}
-	case ComposeActionStop:
+	case compose.ActionStop:
 		actionName = "stop"
-		if modifiedComposeYamlWithPlainTextSecrets, done := serializeYamlWithPlainTextSecrets(&modifiedComposeFile); !done {
-			cmd = exec.Command("docker", "compose", "-f", "-", "-p", stackName, actionName)
-			cmd.Stdin = strings.NewReader(modifiedComposeYamlWithPlainTextSecrets)
-		}
-	case ComposeActionRemove:
+		cmd, _ = newCommand("")
+	case compose.ActionRemove:
 		actionName = "rm"
-		if modifiedComposeYamlWithPlainTextSecrets, done := serializeYamlWithPlainTextSecrets(&modifiedComposeFile); !done {
-			cmd = exec.Command("docker", "compose", "-f", "-", "-p", stackName, "down")
-			cmd.Stdin = strings.NewReader(modifiedComposeYamlWithPlainTextSecrets)
-		}
+		cmd, _ = newCommand("")
 		if _, path, err := findYAML(stackName); err == nil {
 			// Remove the YAML file after stack is removed
 			if err := os.Remove(path); err != nil {
-				log.Printf("Error removing YAML file for stack %s: %v", stackName, err)
-				fmt.Fprintf(os.Stderr, "Failed to remove YAML file for stack %s: %v\n", stackName, err)
+				stackLog.Debug("Error removing YAML file", "stack", stackName, "err", err)
+				stackLog.Error("Failed to remove YAML file", "stack", stackName, "err", err)
 			} else {
-				log.Printf("Successfully removed YAML file for stack %s", stackName)
+				stackLog.Debug("Removed YAML file", "stack", stackName)
+			}
+			if err := deleteStackMeta(stackName); err != nil {
+				stackLog.Warn("Failed to remove stack metadata", "stack", stackName, "err", err)
+			}
+			if err := releaseHostPorts(stackName); err != nil {
+				stackLog.Warn("Failed to release host ports", "stack", stackName, "err", err)
+			}
+			if err := releaseStaticIPs(stackName); err != nil {
+				stackLog.Warn("Failed to release static addresses", "stack", stackName, "err", err)
 			}
 		}
 
-	case ComposeActionStart:
+	case compose.ActionStart:
 		actionName = "start"
-		if modifiedComposeYamlWithPlainTextSecrets, done := serializeYamlWithPlainTextSecrets(&modifiedComposeFile); !done {
-			cmd = exec.Command("docker", "compose", "-f", "-", "-p", stackName, actionName)
-			cmd.Stdin = strings.NewReader(modifiedComposeYamlWithPlainTextSecrets)
+		cmd, _ = newCommand("")
+	case compose.ActionWatch:
+		actionName = "watch"
+		if !hasDevelopSection(&modifiedComposeFile) {
+			return fmt.Errorf("stack %s has no service with a develop: section to watch", stackName)
 		}
-	case ComposeActionCreate:
-		actionName = "create"
-		if modifiedComposeYamlWithPlainTextSecrets, done := serializeYamlWithPlainTextSecrets(&modifiedComposeFile); !done {
-			cmd = exec.Command("docker", "compose", "-f", "-", "-p", stackName, actionName)
-			cmd.Stdin = strings.NewReader(modifiedComposeYamlWithPlainTextSecrets)
+		// Build contexts and watch paths are relative to the stack file, not to dc's working directory
+		projectDir := StacksDir
+		if _, path, err := findYAML(stackName); err == nil {
+			projectDir = filepath.Dir(path)
 		}
+		cmd, _ = newCommand(projectDir)
+	case compose.ActionCreate:
+		actionName = "create"
+		cmd, _ = newCommand("")
+	}
+
+	// newCommand logged why it failed
+	if cmd == nil && action != compose.ActionNone {
+		return fmt.Errorf("failed to prepare stack %s for %s", stackName, action)
+	}
+
+	// docker compose limits the action to the services given after its arguments
+	if cmd != nil && len(services) > 0 && serviceActions[action] {
+		cmd.Args = append(cmd.Args, services...)
 	}
 
 	if cmd != nil {
-		log.Printf("Executing docker modifiedComposeFile %s for stack: %s", actionName, stackName)
+		stackLog.Debug("Executing docker compose", "action", actionName, "stack", stackName)
 
 		// Stream the output (headers already set above)
-		if err := streamCommandOutput(cmd); err != nil {
-			log.Printf("Error executing docker modifiedComposeFile %s for stack %s: %v", actionName, stackName, err)
-			// Error already written to response stream
-			return
+		if err := runRetried(cmd, actionName); err != nil {
+			stackLog.Debug("Error executing docker compose", "action", actionName, "stack", stackName, "err", err)
+			if errors.Is(err, errCancelled) {
+				os.Exit(exitCodeCancelled)
+			}
+			// The output of docker compose was already streamed
+			return fmt.Errorf("docker compose %s failed: %w", actionName, err)
+		}
+		stackLog.Debug("Executed docker compose", "action", actionName, "stack", stackName)
+		// The other services of the stack keep running on their secret files
+		if (action == compose.ActionDown || action == compose.ActionRemove) && len(services) == 0 {
+			removeSecretFiles(stackName)
+		}
+		// Drift takes the containers of a stack stopped on purpose for its intended state
+		if len(services) == 0 {
+			switch action {
+			case compose.ActionStop, compose.ActionDown:
+				setStackStopped(stackName, true)
+			case compose.ActionUp, compose.ActionStart:
+				setStackStopped(stackName, false)
+			}
+		}
+		// Point the routed hosts at the proxy while the stack is deployed
+		switch {
+		case action == compose.ActionUp:
+			syncDNSRecords(&modifiedComposeFile, stackName, true)
+		case (action == compose.ActionDown || action == compose.ActionRemove) && len(services) == 0:
+			syncDNSRecords(&modifiedComposeFile, stackName, false)
 		}
-		log.Printf("Successfully executed docker modifiedComposeFile %s for stack %s", actionName, stackName)
 	}
 
-	if action == ComposeActionNone || action == ComposeActionUp || action == ComposeActionCreate {
+	if action == compose.ActionNone || action == compose.ActionUp || action == compose.ActionCreate {
 		// Ensure the stacks directory exists
 		if err := os.MkdirAll(StacksDir, 0755); err != nil {
-			log.Printf("Error creating stacks directory: %v", err)
-			fmt.Fprintf(os.Stderr, "Failed to create stacks directory\n")
-			return
+			return fmt.Errorf("failed to create stacks directory: %w", err)
 		}
 
 		// Construct the file paths
@@ -950,33 +807,103 @@ func HandleDockerComposeFile(body []byte, stackName string, dryRun bool, action
 		effectiveFilePath := GetStackPath(stackName, true)
 
 		// Write the original file (sanitized user-provided content without plaintext passwords)
-		if err := os.WriteFile(originalFilePath, []byte(originalComposeYamlBuffer.String()), 0644); err != nil {
-			log.Printf("Error writing original stack file %s: %v", originalFilePath, err)
-			fmt.Fprintf(os.Stderr, "Failed to write original stack file\n")
-			return
+		if err := writeStackFile(stackName, originalFilePath, []byte(originalComposeYamlBuffer.String())); err != nil {
+			return fmt.Errorf("failed to write original stack file: %w", err)
 		}
 
 		// Write the effective file (enriched and sanitized - no plaintext passwords)
 		if err := os.WriteFile(effectiveFilePath, []byte(modifiedComposeYamlBuffer.String()), 0644); err != nil {
-			log.Printf("Error writing effective stack file %s: %v", effectiveFilePath, err)
-			fmt.Fprintf(os.Stderr, "Failed to write effective stack file\n")
-			return
+			return fmt.Errorf("failed to write effective stack file: %w", err)
+		}
+		stackLog.Debug("Persisted stack", "stack", stackName, "original", originalFilePath, "effective", effectiveFilePath)
+	}
+
+	// --wait-healthy blocks until every service reports ready, in depends_on order
+	if action == compose.ActionUp && cmd != nil && backend == BackendCompose && getConfigBool("wait_healthy", false) {
+		if err := waitHealthy(withServices(&modifiedComposeFile, services), stackName); err != nil {
+			return fmt.Errorf("stack %s did not become healthy: %w", stackName, err)
+		}
+	}
+	return nil
+}
+
+// reportDryRun prints what HandleDockerComposeFile would do for the action: the docker command
+// of the backend and a diff of every stack file that would be written or removed.
+func reportDryRun(stackName, backend string, action compose.Action, original, effective string) {
+	fmt.Fprintln(os.Stdout, msg("dry_run_header"))
+	if action != compose.ActionNone {
+		if command, err := backendCommand(backend, stackName, action, StacksDir); err != nil {
+			fmt.Fprintf(os.Stdout, "# Would fail: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stdout, "# Would run: %s\n", strings.Join(command.Args, " "))
+		}
+	}
+
+	type plannedFile struct{ path, content string }
+	var files []plannedFile
+	switch action {
+	case compose.ActionNone, compose.ActionUp, compose.ActionCreate:
+		files = append(files,
+			plannedFile{GetStackPath(stackName, false), original},
+			plannedFile{GetStackPath(stackName, true), effective})
+	case compose.ActionRemove:
+		if _, path, err := findYAML(stackName); err == nil {
+			files = append(files, plannedFile{path, ""})
+		}
+	}
+
+	for _, f := range files {
+		current, err := os.ReadFile(f.path)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stdout, "# Cannot read %s: %v\n", f.path, err)
+			continue
+		}
+		if diff := unifiedDiff(string(current), f.content, f.path, f.path); diff != "" {
+			os.Stdout.WriteString(diff)
+		} else {
+			fmt.Fprintf(os.Stdout, "# %s: unchanged\n", f.path)
+		}
+	}
+}
+
+// hasDevelopSection reports whether any service defines docker compose watch rules
+func hasDevelopSection(composeFile *compose.File) bool {
+	for _, service := range composeFile.Services {
+		if service.Develop != nil {
+			return true
 		}
-		log.Printf("Successfully persisted stack: %s (original: %s, effective: %s)", stackName, originalFilePath, effectiveFilePath)
 	}
+	return false
 }
 
-func serializeYamlWithPlainTextSecrets(modifiedComposeFile *ComposeFile) (string, bool) {
+// serializeYamlWithPlainTextSecrets resolves the placeholders of the compose file for docker
+// compose. With secrets_mode file or swarm, secrets backed by a variable are turned into file or
+// external references first, so their values stay out of the YAML.
+func serializeYamlWithPlainTextSecrets(modifiedComposeFile *compose.File, stackName string) (string, bool) {
 	// Replace environment variables in the effective YAML content
-	if err := replaceEnvVarsInCompose(modifiedComposeFile); err != nil {
-		log.Printf("Error replacing environment variables in modifiedComposeFile file: %v", err)
-		fmt.Fprintf(os.Stderr, "[ERROR] Failed to process modifiedComposeFile file: %v\n", err)
+	if err := replaceEnvVarsInCompose(modifiedComposeFile, stackName); err != nil {
+		stackLog.Debug("Error replacing environment variables in the stack file", "err", err)
+		stackLog.Error("Failed to process the stack file", "err", err)
+		return "", true
+	}
+	if err := materializeSecrets(modifiedComposeFile, stackName); err != nil {
+		stackLog.Error("Failed to provide the secrets", "err", err)
 		return "", true
 	}
+	if stackBackend(modifiedComposeFile) == BackendSwarm {
+		warnings, err := prepareSwarmCompose(modifiedComposeFile, stackName)
+		for _, warning := range warnings {
+			stackLog.Warn(warning)
+		}
+		if err != nil {
+			stackLog.Error("Failed to prepare the stack for swarm", "err", err)
+			return "", true
+		}
+	}
 	var modifiedComposeYamlWithPlainTextSecretsBuffer strings.Builder
 	if err := encodeYAMLWithMultiline(&modifiedComposeYamlWithPlainTextSecretsBuffer, modifiedComposeFile); err != nil {
-		log.Printf("Failed to serialize modified YAML with secrets: %v", err)
-		fmt.Fprintf(os.Stderr, "Failed to serialize modified YAML with secrets: %v\n", err)
+		stackLog.Debug("Failed to serialize modified YAML with secrets", "err", err)
+		stackLog.Error("Failed to serialize modified YAML with secrets", "err", err)
 		return "", true
 	}
 	var modifiedComposeYamlWithPlainTextSecrets = modifiedComposeYamlWithPlainTextSecretsBuffer.String()
@@ -986,63 +913,30 @@ func serializeYamlWithPlainTextSecrets(modifiedComposeFile *ComposeFile) (string
 
 // ensureNetworksExist checks all networks defined in the compose file and creates missing ones
 // Networks are created in bridge mode if no driver is specified and external is false
-// If w is not nil, output is streamed to the HTTP response
-func ensureNetworksExist(compose *ComposeFile) error {
-	if compose.Networks == nil {
+func ensureNetworksExist(composeFile *compose.File) error {
+	if composeFile.Networks == nil {
 		return nil
 	}
 
-	for networkName, networkConfig := range compose.Networks {
+	for networkName, networkConfig := range composeFile.Networks {
 		// Skip external networks as they should already exist
 		if networkConfig.External {
-			log.Printf("Skipping external network: %s", networkName)
-			fmt.Fprintf(os.Stderr, "[INFO] Skipping external network: %s\n", networkName)
+			stackLog.Debug("Skipping external network", "network", networkName)
+			stackLog.Info("Skipping external network", "network", networkName)
 			continue
 		}
 
 		// Check if network exists
-		checkCmd := exec.Command("docker", "network", "inspect", networkName)
+		checkCmd := engineCommand("network", "inspect", networkName)
 		if err := checkCmd.Run(); err == nil {
-			log.Printf("Network already exists: %s", networkName)
-			fmt.Fprintf(os.Stderr, "[INFO] Network already exists: %s\n", networkName)
+			stackLog.Debug("Network already exists", "network", networkName)
+			stackLog.Info("Network already exists", "network", networkName)
 			continue
 		}
 
-		// Network doesn't exist, create it
-		// Use the driver specified in config, or default to "bridge"
-		driver := "bridge"
-		if networkConfig.Driver != "" {
-			driver = networkConfig.Driver
-		}
-
-		createArgs := []string{"network", "create", "--driver", driver}
-
-		// Add driver options if specified
-		if networkConfig.DriverOpts != nil {
-			for key, value := range networkConfig.DriverOpts {
-				createArgs = append(createArgs, "-o", fmt.Sprintf("%s=%s", key, value))
-			}
-		}
-
-		createArgs = append(createArgs, networkName)
-
-		createCmd := exec.Command("docker", createArgs...)
-
-		// Stream output if ResponseWriter is provided
-		log.Printf("Creating network: %s with driver: %s", networkName, driver)
-		fmt.Fprintf(os.Stderr, "[INFO] Creating network: %s with driver: %s\n", networkName, driver)
-
-		if err := streamCommandOutput(createCmd); err != nil {
-			return fmt.Errorf("failed to create network %s: %v", networkName, err)
-		} else {
-			// Fall back to non-streaming for backward compatibility
-			output, err := createCmd.CombinedOutput()
-			if err != nil {
-				return fmt.Errorf("failed to create network %s: %v, output: %s", networkName, err, string(output))
-			}
+		if err := createNetwork(networkName, networkConfig); err != nil {
+			return err
 		}
-
-		log.Printf("Successfully created network: %s with driver: %s", networkName, driver)
 	}
 
 	return nil
@@ -1050,69 +944,35 @@ func ensureNetworksExist(compose *ComposeFile) error {
 
 // ensureVolumesExist checks all volumes defined in the compose file and creates missing ones
 // Volumes are created with driver "local" if no driver is specified and external is false
-// If w is not nil, output is streamed to the HTTP response
-func ensureVolumesExist(compose *ComposeFile) error {
-	if compose.Volumes == nil {
+func ensureVolumesExist(composeFile *compose.File) error {
+	if composeFile.Volumes == nil {
 		return nil
 	}
 
-	for volumeName, volumeConfig := range compose.Volumes {
+	for volumeName, volumeConfig := range composeFile.Volumes {
 		// Skip external volumes as they should already exist
 		if volumeConfig.External {
-			log.Printf("Skipping external volume: %s", volumeName)
-			fmt.Fprintf(os.Stderr, "[INFO] Skipping external volume: %s\n", volumeName)
+			stackLog.Debug("Skipping external volume", "volume", volumeName)
+			stackLog.Info("Skipping external volume", "volume", volumeName)
 			continue
 		}
 
 		// Check if volume exists
-		checkCmd := exec.Command("docker", "volume", "inspect", volumeName)
+		checkCmd := engineCommand("volume", "inspect", volumeName)
 		if err := checkCmd.Run(); err == nil {
-			log.Printf("Volume already exists: %s", volumeName)
-			fmt.Fprintf(os.Stderr, "[INFO] Volume already exists: %s\n", volumeName)
+			stackLog.Debug("Volume already exists", "volume", volumeName)
+			stackLog.Info("Volume already exists", "volume", volumeName)
 			continue
 		}
 
-		// Volume doesn't exist, create it
-		// Use the driver specified in config, or default to "local"
-		driver := "local"
-		if volumeConfig.Driver != "" {
-			driver = volumeConfig.Driver
-		}
-
-		createArgs := []string{"volume", "create", "--driver", driver}
-
-		// Add driver options if specified
-		if volumeConfig.DriverOpts != nil {
-			for key, value := range volumeConfig.DriverOpts {
-				createArgs = append(createArgs, "-o", fmt.Sprintf("%s=%s", key, value))
-			}
-		}
-
-		// Add volume name or use the custom name if specified
+		// Use the custom name if specified
 		targetName := volumeName
 		if volumeConfig.Name != "" {
 			targetName = volumeConfig.Name
 		}
-
-		createArgs = append(createArgs, targetName)
-
-		createCmd := exec.Command("docker", createArgs...)
-
-		// Stream output if ResponseWriter is provided
-		log.Printf("Creating volume: %s with driver: %s", targetName, driver)
-		fmt.Fprintf(os.Stderr, "[INFO] Creating volume: %s with driver: %s\n", targetName, driver)
-
-		if err := streamCommandOutput(createCmd); err != nil {
-			return fmt.Errorf("failed to create volume %s: %v", targetName, err)
-		} else {
-			// Fall back to non-streaming for backward compatibility
-			output, err := createCmd.CombinedOutput()
-			if err != nil {
-				return fmt.Errorf("failed to create volume %s: %v, output: %s", targetName, err, string(output))
-			}
+		if err := createVolume(targetName, volumeConfig); err != nil {
+			return err
 		}
-
-		log.Printf("Successfully created volume: %s with driver: %s", targetName, driver)
 	}
 
 	return nil
@@ -1123,26 +983,26 @@ func HandleStreamStackLogs(body []byte, path string) {
 	// Expected format: /api/stacks/{name}/logs
 	pathParts := strings.Split(strings.TrimPrefix(path, "/"), "/")
 	if len(pathParts) < 4 || pathParts[0] != "api" || pathParts[1] != "stacks" || pathParts[3] != "logs" {
-		fmt.Fprintf(os.Stderr, "Invalid URL format\n")
+		stackLog.Error("Invalid URL format")
 		return
 	}
 
 	stackName := pathParts[2]
 	if stackName == "" {
-		fmt.Fprintf(os.Stderr, "Stack name is required\n")
+		stackLog.Error("Stack name is required")
 		return
 	}
 
-	log.Printf("Streaming logs for stack: %s", stackName)
+	stackLog.Debug("Streaming logs", "stack", stackName)
 
 	// Command to stream logs
-	cmd := exec.Command("docker-compose", "-f", GetStackPath(stackName, true), "logs", "-f")
+	cmd := composeCommand("-f", GetStackPath(stackName, true), "logs", "-f")
 
 	// Stream logs to the response
 	err := streamCommandOutput(cmd)
 	if err != nil {
-		log.Printf("Error streaming logs for stack %s: %v", stackName, err)
-		fmt.Fprintf(os.Stderr, "Failed to stream logs\n")
+		stackLog.Debug("Error streaming logs", "stack", stackName, "err", err)
+		stackLog.Error("Failed to stream logs")
 	}
 }
 
@@ -1186,25 +1046,7 @@ func getStacksData() ([]Stack, error) {
 	}
 
 	// Add YAML stacks that are not running (with simulated containers)
-	for stackName, filePath := range ymlStacks {
-		if !runningStackNames[stackName] {
-			// Parse YAML file and create simulated containers
-			simulatedContainers, err := createSimulatedContainers(stackName, filePath, allContainers)
-			if err != nil {
-				log.Printf("Error creating simulated containers for %s: %v", stackName, err)
-				// Still add the stack but with empty containers
-				runningStacks = append(runningStacks, Stack{
-					Name:       stackName,
-					Containers: []DockerInspect{},
-				})
-			} else {
-				runningStacks = append(runningStacks, Stack{
-					Name:       stackName,
-					Containers: simulatedContainers,
-				})
-			}
-		}
-	}
+	runningStacks = append(runningStacks, simulateStacks(ymlStacks, runningStackNames, allContainers)...)
 
 	// Sort stacks alphabetically by name
 	sort.Slice(runningStacks, func(i, j int) bool {
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"dc/internal/secrets"
+)
+
+// stackEnvPath returns the env file of a stack, <stacks dir>/<stack>.env. Its variables take
+// precedence over prod.env for that stack only, so two stacks can use the same name for
+// different values. It is empty for a stack whose env file would be prod.env itself.
+func stackEnvPath(stackName string) string {
+	if stackName == "" {
+		return ""
+	}
+	path := filepath.Join(StacksDir, stackName+".env")
+	if filepath.Clean(path) == filepath.Clean(ProdEnvPath) {
+		return ""
+	}
+	return path
+}
+
+// readStackEnv reads the variables of a stack: prod.env and /run/secrets, overridden by the
+// env file of the stack. Names are matched case-insensitively like in prod.env.
+func readStackEnv(stackName string) (map[string]string, error) {
+	vars, err := readProdEnv(ProdEnvPath)
+	if err != nil {
+		return nil, err
+	}
+	path := stackEnvPath(stackName)
+	if path == "" {
+		return vars, nil
+	}
+	stackVars, err := secrets.ReadEnvFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range stackVars {
+		for existing := range vars {
+			if strings.EqualFold(existing, key) {
+				delete(vars, existing)
+			}
+		}
+		vars[key] = value
+	}
+	return vars, nil
+}
+
+// stackEnvKeys returns the upper-case names of the variables of a stack
+func stackEnvKeys(stackName string) map[string]bool {
+	keys := make(map[string]bool)
+	vars, err := readStackEnv(stackName)
+	if err != nil {
+		return keys
+	}
+	for key := range vars {
+		keys[strings.ToUpper(key)] = true
+	}
+	return keys
+}
+
+// stackSecretKey namespaces the name of an auto-generated secret with the stack, e.g.
+// DB_PASSWORD of stack wiki becomes WIKI_DB_PASSWORD
+func stackSecretKey(stackName, key string) string {
+	if stackName == "" {
+		return key
+	}
+	prefix := secrets.NormalizeKey(stackName) + "_"
+	if normalized := secrets.NormalizeKey(key); strings.HasPrefix(normalized, prefix) {
+		return normalized
+	}
+	return secrets.NormalizeKey(prefix + key)
+}
+
+// secretKeyFor returns the variable backing an auto-generated secret of a stack. New values get
+// the namespaced name; an existing value under the global name keeps being used until the
+// stack is migrated with `dc secret migrate`.
+func secretKeyFor(stackName, key string, newValue bool, known map[string]bool) string {
+	namespaced := stackSecretKey(stackName, key)
+	if newValue || namespaced == key || known[strings.ToUpper(namespaced)] || !known[strings.ToUpper(key)] {
+		return namespaced
+	}
+	secretsLog.Info("Stack uses a global secret; run `dc secret migrate <stack>` to give it its own", "stack", stackName, "key", key, "namespaced", namespaced)
+	return key
+}
+
+// SecretMigration is the outcome of `dc secret migrate` for one stack
+type SecretMigration struct {
+	Stack     string            `json:"stack"`
+	Variables map[string]string `json:"variables"` // global name -> namespaced name
+}
+
+// migratableVariables returns the global secrets a stack file uses that can be namespaced:
+// variables of prod.env with a sensitive name or backing a secret, not already namespaced and
+// not set in the stack env file
+func migratableVariables(stackName string, content []byte) (map[string]string, error) {
+	usages, err := stackVariableUsages(stackName, content)
+	if err != nil {
+		return nil, err
+	}
+	global, err := secrets.ReadEnvFile(ProdEnvPath)
+	if err != nil {
+		return nil, err
+	}
+	local := map[string]string{}
+	if path := stackEnvPath(stackName); path != "" {
+		if local, err = secrets.ReadEnvFile(path); err != nil {
+			return nil, err
+		}
+	}
+	globalNames := make(map[string]string, len(global))
+	for name := range global {
+		globalNames[strings.ToUpper(name)] = name
+	}
+
+	variables := make(map[string]string)
+	for upper, uses := range usages {
+		name, ok := globalNames[upper]
+		if !ok || !(secrets.IsSensitiveKey(name, "") || backsSecret(uses)) {
+			continue
+		}
+		if _, ok := local[name]; ok {
+			continue
+		}
+		if namespaced := stackSecretKey(stackName, name); namespaced != name {
+			variables[name] = namespaced
+		}
+	}
+	return variables, nil
+}
+
+// backsSecret reports whether a variable is used as the value of a secret
+func backsSecret(usages []SecretUsage) bool {
+	for _, usage := range usages {
+		if usage.Field == "secrets" {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteVariables renames variables in the text of a stack file: ${NAME}, ${NAME:-default},
+// $NAME and the environment of top-level secrets. $$ escapes are left alone.
+func rewriteVariables(content string, variables map[string]string) string {
+	for from, to := range variables {
+		quoted := regexp.QuoteMeta(from)
+		content = regexp.MustCompile(`(\$\{)`+quoted+`([:?+-][^}]*)?}`).ReplaceAllString(content, "${1}"+to+"${2}}")
+		content = regexp.MustCompile(`(^|[^$])\$`+quoted+`\b`).ReplaceAllString(content, "${1}$$"+to)
+		content = regexp.MustCompile(`(?m)^(\s+environment:\s*["']?)`+quoted+`(["']?\s*)$`).ReplaceAllString(content, "${1}"+to+"${2}")
+	}
+	return content
+}
+
+// migrateStackSecrets copies the global secrets a stack uses to namespaced names in the secrets
+// store and points the stack file at them. The global entries stay for other stacks using
+// them; `dc secret prune` removes them once orphaned.
+func migrateStackSecrets(stackName, file string) (*SecretMigration, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	variables, err := migratableVariables(stackName, content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	migration := &SecretMigration{Stack: stackName, Variables: variables}
+	if len(variables) == 0 {
+		return migration, nil
+	}
+	rewritten := rewriteVariables(string(content), variables)
+
+	if DryRun {
+		names := make([]string, 0, len(variables))
+		for from := range variables {
+			names = append(names, from)
+		}
+		sort.Strings(names)
+		for _, from := range names {
+			fmt.Fprintf(os.Stderr, "# Dry run: stack %s would use %s instead of %s\n", stackName, variables[from], from)
+		}
+		os.Stderr.WriteString(unifiedDiff(string(content), rewritten, file, file))
+		return migration, nil
+	}
+
+	global, err := secrets.ReadEnvFile(ProdEnvPath)
+	if err != nil {
+		return nil, err
+	}
+	for from, to := range variables {
+		if err := (secretsManager{}).Insert(to, global[from]); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeStackFile(stackName, file, []byte(rewritten)); err != nil {
+		return nil, err
+	}
+	writeFrame(FrameStdout, fmt.Sprintf("Migrated %d secrets of stack %s, redeploy it to apply", len(variables), stackName))
+	return migration, nil
+}
+
+// HandleSecretMigrate namespaces the global secrets of the given stacks (or all with --all)
+// and prints what was renamed as JSON
+func HandleSecretMigrate(stackNames []string) error {
+	files := stackFiles()
+	if getFlagBool("all", false) {
+		stackNames = stackNames[:0]
+		for name := range files {
+			stackNames = append(stackNames, name)
+		}
+		sort.Strings(stackNames)
+	}
+	if len(stackNames) == 0 {
+		return fmt.Errorf("no stacks given")
+	}
+	migrations := []SecretMigration{}
+	for _, stackName := range stackNames {
+		file, ok := files[stackName]
+		if !ok {
+			return fmt.Errorf("stack %s has no stack file", stackName)
+		}
+		migration, err := migrateStackSecrets(stackName, file)
+		if err != nil {
+			return err
+		}
+		migrations = append(migrations, *migration)
+	}
+	return json.NewEncoder(os.Stdout).Encode(migrations)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+	"dc/internal/docker"
+	"gopkg.in/yaml.v3"
+)
+
+// Stack is a stack with the inspect data of its containers, as listed by `dc stack ls`
+type Stack struct {
+	Name       string           `json:"name"`
+	Group      string           `json:"group,omitempty"`
+	Containers []docker.Inspect `json:"containers"`
+}
+
+// StackListEntry is a stack in the summary list of `dc stack ls --detail=summary`
+type StackListEntry struct {
+	Name       string     `json:"name"`
+	Group      string     `json:"group,omitempty"`
+	Status     string     `json:"status"` // running, partial or stopped, as in the landing page summary
+	Services   []string   `json:"services"`
+	Containers ItemCounts `json:"containers"`
+	Unhealthy  int        `json:"unhealthy,omitempty"`
+	Images     []string   `json:"images"`
+	HasFile    bool       `json:"hasFile"` // false for compose projects started outside of dc
+}
+
+// listStackSummaries builds the stack list from a single docker ps and the stack files, without
+// inspecting any container
+func listStackSummaries() ([]StackListEntry, error) {
+	entries := make(map[string]*StackListEntry)
+	entry := func(name string) *StackListEntry {
+		if e, ok := entries[name]; ok {
+			return e
+		}
+		e := &StackListEntry{Name: name, Services: []string{}, Images: []string{}}
+		entries[name] = e
+		return e
+	}
+
+	for name, file := range stackFiles() {
+		e := entry(name)
+		e.HasFile = true
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var composeFile compose.File
+		if yaml.Unmarshal(content, &composeFile) == nil {
+			for service := range composeFile.Services {
+				e.Services = append(e.Services, service)
+			}
+		}
+	}
+
+	images := make(map[string]map[string]bool)
+	err := dockerJSONLines([]string{"ps", "-a", "--no-trunc", "--format", "{{json .}}"}, func(line []byte) error {
+		var c struct{ Image, Labels, State, Status string }
+		if err := json.Unmarshal(line, &c); err != nil {
+			return err
+		}
+		project := labelValue(c.Labels, "com.docker.compose.project")
+		if project == "" {
+			return nil
+		}
+		e := entry(project)
+		e.Containers.Total++
+		if c.State == "running" {
+			e.Containers.Running++
+		}
+		if strings.Contains(c.Status, "(unhealthy)") {
+			e.Unhealthy++
+		}
+		if images[project] == nil {
+			images[project] = make(map[string]bool)
+		}
+		if !images[project][c.Image] {
+			images[project][c.Image] = true
+			e.Images = append(e.Images, c.Image)
+		}
+		if service := labelValue(c.Labels, "com.docker.compose.service"); service != "" && !e.HasFile {
+			e.Services = appendUnique(e.Services, service)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := stackGroups()
+	list := make([]StackListEntry, 0, len(entries))
+	for _, e := range entries {
+		e.Group = groups[e.Name]
+		switch {
+		case e.Containers.Running == 0:
+			e.Status = "stopped"
+		case e.Containers.Running < e.Containers.Total:
+			e.Status = "partial"
+		default:
+			e.Status = "running"
+		}
+		sort.Strings(e.Services)
+		sort.Strings(e.Images)
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// appendUnique appends value unless the slice holds it already
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// HandleListStackSummaries prints the summary list of the stacks as JSON, filtered and paged
+// like the full list
+func HandleListStackSummaries() error {
+	filter, err := listFilterFromFlags()
+	if err != nil {
+		return err
+	}
+	list, err := listStackSummaries()
+	if err != nil {
+		stackLog.Debug("Error getting stacks list", "err", err)
+		return nil
+	}
+	labels := stackLabels()
+	filtered := []StackListEntry{}
+	for _, stack := range list {
+		if filter.matches(stack.Name, labels[stack.Name], stack.Status, stack.Group) {
+			filtered = append(filtered, stack)
+		}
+	}
+	return json.NewEncoder(os.Stdout).Encode(paginate(filtered, filter))
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/yaml.v3"
+)
+
+// stackMetaBucket holds the StackMeta of every stack, keyed by stack name
+var stackMetaBucket = []byte("stacks")
+
+// stackMetaDBPath returns the database holding the stack metadata (config key stack_meta_db)
+func stackMetaDBPath() string {
+	return getConfig("stack_meta_db", filepath.Join(StacksDir, ".dc-meta.db"))
+}
+
+// openStackMetaDB opens the stack metadata database. A read-only open of a missing database
+// returns nil and no error; a writable open creates it and imports the sidecar files.
+func openStackMetaDB(readOnly bool) (*bolt.DB, error) {
+	path := stackMetaDBPath()
+	if readOnly {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil
+		}
+	} else if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// concurrent dc processes (bulk actions, dcapi) wait for each other's lock
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	if !readOnly {
+		if err := importStackMetaFiles(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// stackMetaFiles returns the sidecar metadata files of earlier versions by stack name
+func stackMetaFiles() map[string]string {
+	files := make(map[string]string)
+	for stackName, file := range stackFiles() {
+		if _, err := os.Stat(stackMetaPath(file)); err == nil {
+			files[stackName] = stackMetaPath(file)
+		}
+	}
+	return files
+}
+
+// readStackMetaFile parses a sidecar metadata file
+func readStackMetaFile(path string) (StackMeta, error) {
+	var meta StackMeta
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return meta, err
+	}
+	if err := yaml.Unmarshal(content, &meta); err != nil {
+		return StackMeta{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return meta, nil
+}
+
+// importStackMetaFiles moves the sidecar metadata files into the database. An imported file is
+// renamed so that it isn't imported again.
+func importStackMetaFiles(db *bolt.DB) error {
+	files := stackMetaFiles()
+	if len(files) == 0 {
+		return nil
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(stackMetaBucket)
+		if err != nil {
+			return err
+		}
+		for stackName, path := range files {
+			meta, err := readStackMetaFile(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: not importing %v\n", err)
+				continue
+			}
+			// metadata set in the database wins over a sidecar file left behind
+			if bucket.Get([]byte(stackName)) == nil {
+				value, err := json.Marshal(meta)
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put([]byte(stackName), value); err != nil {
+					return err
+				}
+			}
+			if err := os.Rename(path, path+".imported"); err != nil {
+				return err
+			}
+			stackLog.Info("Imported stack metadata", "stack", stackName, "path", path)
+		}
+		return nil
+	})
+}
+
+// loadStackMetas returns the metadata of every stack that has some. Sidecar files not yet
+// imported are imported first; with --dry-run=true they are only read.
+func loadStackMetas() map[string]StackMeta {
+	metas := make(map[string]StackMeta)
+	files := stackMetaFiles()
+	readOnly := len(files) == 0 || DryRun
+	db, err := openStackMetaDB(readOnly)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring stack metadata: %v\n", err)
+		return metas
+	}
+	if db != nil {
+		defer db.Close()
+		err = db.View(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(stackMetaBucket)
+			if bucket == nil {
+				return nil
+			}
+			return bucket.ForEach(func(key, value []byte) error {
+				var meta StackMeta
+				if err := json.Unmarshal(value, &meta); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: ignoring metadata of stack %s: %v\n", key, err)
+					return nil
+				}
+				metas[string(key)] = meta
+				return nil
+			})
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring stack metadata: %v\n", err)
+		}
+	}
+	if readOnly {
+		for stackName, path := range files {
+			if _, ok := metas[stackName]; ok {
+				continue
+			}
+			if meta, err := readStackMetaFile(path); err == nil {
+				metas[stackName] = meta
+			}
+		}
+	}
+	return metas
+}
+
+// readStackMeta returns the metadata of a stack
+func readStackMeta(stackName string) StackMeta {
+	return loadStackMetas()[stackName]
+}
+
+// updateStackMeta runs fn on the metadata bucket in one transaction
+func updateStackMeta(fn func(bucket *bolt.Bucket) error) error {
+	db, err := openStackMetaDB(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(stackMetaBucket)
+		if err != nil {
+			return err
+		}
+		return fn(bucket)
+	})
+}
+
+// writeStackMeta stores the metadata of a stack; empty metadata removes the entry
+func writeStackMeta(stackName string, meta StackMeta) error {
+	return updateStackMeta(func(bucket *bolt.Bucket) error {
+		if meta == (StackMeta{}) {
+			return bucket.Delete([]byte(stackName))
+		}
+		value, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(stackName), value)
+	})
+}
+
+// copyStackMeta copies the metadata of a stack to a new name; with move the old entry is removed
+func copyStackMeta(oldName, newName string, move bool) error {
+	return updateStackMeta(func(bucket *bolt.Bucket) error {
+		value := bucket.Get([]byte(oldName))
+		if value == nil {
+			return nil
+		}
+		if err := bucket.Put([]byte(newName), append([]byte(nil), value...)); err != nil {
+			return err
+		}
+		if move {
+			return bucket.Delete([]byte(oldName))
+		}
+		return nil
+	})
+}
+
+// setStackStopped records whether a stack was stopped on purpose, see StackMeta.Stopped
+func setStackStopped(stackName string, stopped bool) {
+	err := updateStackMeta(func(bucket *bolt.Bucket) error {
+		var meta StackMeta
+		if value := bucket.Get([]byte(stackName)); value != nil {
+			if err := json.Unmarshal(value, &meta); err != nil {
+				return err
+			}
+		}
+		if meta.Stopped == stopped {
+			return nil
+		}
+		meta.Stopped = stopped
+		if meta == (StackMeta{}) {
+			return bucket.Delete([]byte(stackName))
+		}
+		value, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(stackName), value)
+	})
+	if err != nil {
+		stackLog.Warn("Failed to record the intended state of the stack", "stack", stackName, "stopped", stopped, "err", err)
+	}
+}
+
+// deleteStackMeta removes the metadata of a stack
+func deleteStackMeta(stackName string) error {
+	return writeStackMeta(stackName, StackMeta{})
+}
+
+// HandleStackMeta prints the metadata of a stack as JSON, or changes it with --group=<group>
+// and --autostart=true|false
+func HandleStackMeta(stackName string, args []string) error {
+	if _, _, err := findYAML(stackName); err != nil {
+		return err
+	}
+	meta := readStackMeta(stackName)
+	changed := false
+	for _, arg := range args {
+		name, value, ok := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "--") || !ok {
+			continue
+		}
+		switch name {
+		case "group":
+			meta.Group, changed = value, true
+		case "autostart":
+			autostart, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid autostart value %q (true, false)", value)
+			}
+			meta.Autostart, changed = autostart, true
+		}
+	}
+	if changed {
+		if DryRun {
+			fmt.Fprintf(os.Stdout, "%s\n# Would set the metadata of %s\n", msg("dry_run_header"), stackName)
+		} else if err := writeStackMeta(stackName, meta); err != nil {
+			return err
+		}
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(meta)
+}
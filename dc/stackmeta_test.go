@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useTempStacksDir points StacksDir and the stack files at a temporary home directory
+func useTempStacksDir(t *testing.T) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("STACK_META_DB", "")
+	dir := filepath.Join(home, ".local", "stacks")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldStacksDir := StacksDir
+	StacksDir = dir
+	t.Cleanup(func() { StacksDir = oldStacksDir })
+	for _, name := range []string{"web", "db"} {
+		if err := os.WriteFile(filepath.Join(dir, name+".yml"), []byte("services: {}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestStackMetaImportsSidecarFiles(t *testing.T) {
+	dir := useTempStacksDir(t)
+	sidecar := filepath.Join(dir, "web"+stackMetaSuffix)
+	if err := os.WriteFile(sidecar, []byte("group: core\nautostart: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readStackMeta("web"), (StackMeta{Group: "core", Autostart: true}); got != want {
+		t.Errorf("readStackMeta = %+v, want %+v", got, want)
+	}
+	if _, err := os.Stat(sidecar); !os.IsNotExist(err) {
+		t.Errorf("sidecar file not renamed after the import: %v", err)
+	}
+	if got := readStackMeta("db"); got != (StackMeta{}) {
+		t.Errorf("readStackMeta of a stack without metadata = %+v", got)
+	}
+}
+
+func TestStackMetaWriteCopyDelete(t *testing.T) {
+	useTempStacksDir(t)
+	if err := writeStackMeta("web", StackMeta{Group: "media"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyStackMeta("web", "site", false); err != nil {
+		t.Fatal(err)
+	}
+	metas := loadStackMetas()
+	if metas["web"].Group != "media" || metas["site"].Group != "media" {
+		t.Errorf("metadata after copy = %+v", metas)
+	}
+
+	if err := copyStackMeta("site", "blog", true); err != nil {
+		t.Fatal(err)
+	}
+	metas = loadStackMetas()
+	if _, ok := metas["site"]; ok || metas["blog"].Group != "media" {
+		t.Errorf("metadata after move = %+v", metas)
+	}
+
+	if err := deleteStackMeta("web"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loadStackMetas()["web"]; ok {
+		t.Error("metadata of web left after delete")
+	}
+}
+
+func TestSetStackStoppedKeepsMetadata(t *testing.T) {
+	useTempStacksDir(t)
+	if err := writeStackMeta("web", StackMeta{Group: "media"}); err != nil {
+		t.Fatal(err)
+	}
+
+	setStackStopped("web", true)
+	if meta := readStackMeta("web"); !meta.Stopped || meta.Group != "media" {
+		t.Errorf("metadata after stop = %+v", meta)
+	}
+
+	setStackStopped("web", false)
+	if meta := readStackMeta("web"); meta.Stopped || meta.Group != "media" {
+		t.Errorf("metadata after start = %+v", meta)
+	}
+
+	setStackStopped("db", true)
+	setStackStopped("db", false)
+	if _, ok := loadStackMetas()["db"]; ok {
+		t.Error("metadata of db left after stop and start")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultStatsInterval is the sampling interval of `dc stack stats --follow`
+const defaultStatsInterval = 2 * time.Second
+
+// ContainerStats is one sample of a container's resource usage (the docker stats columns)
+type ContainerStats struct {
+	Container     string  `json:"container"`
+	Service       string  `json:"service"`
+	CPUPercent    float64 `json:"cpuPercent"`
+	MemoryUsage   int64   `json:"memoryUsage"`
+	MemoryLimit   int64   `json:"memoryLimit"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	NetworkRx     int64   `json:"networkRx"`
+	NetworkTx     int64   `json:"networkTx"`
+	BlockRead     int64   `json:"blockRead"`
+	BlockWrite    int64   `json:"blockWrite"`
+	PIDs          int     `json:"pids"`
+}
+
+// StackStats is a sample of all running containers of a stack
+type StackStats struct {
+	Stack      string           `json:"stack"`
+	Time       time.Time        `json:"time"`
+	Containers []ContainerStats `json:"containers"`
+}
+
+// dockerStatsLine is the output of `docker stats --format '{{json .}}'`
+type dockerStatsLine struct {
+	Name     string `json:"Name"`
+	CPUPerc  string `json:"CPUPerc"`
+	MemUsage string `json:"MemUsage"`
+	MemPerc  string `json:"MemPerc"`
+	NetIO    string `json:"NetIO"`
+	BlockIO  string `json:"BlockIO"`
+	PIDs     string `json:"PIDs"`
+}
+
+// byteUnits are the size suffixes used by docker stats, decimal (kB, MB) and binary (KiB, MiB)
+var byteUnits = []struct {
+	suffix string
+	factor float64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"kB", 1e3}, {"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseStatsSize parses docker stats sizes like "12.5MiB" or "1.2kB"
+func parseStatsSize(s string) int64 {
+	s = strings.TrimSpace(s)
+	for _, unit := range byteUnits {
+		if number, ok := strings.CutSuffix(s, unit.suffix); ok {
+			value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+			if err != nil {
+				return 0
+			}
+			return int64(value * unit.factor)
+		}
+	}
+	return 0
+}
+
+// parseSizePair parses "used / limit" pairs such as MemUsage, NetIO and BlockIO
+func parseSizePair(s string) (int64, int64) {
+	first, second, _ := strings.Cut(s, "/")
+	return parseStatsSize(first), parseStatsSize(second)
+}
+
+// parsePercent parses "12.34%"
+func parsePercent(s string) float64 {
+	value, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	return value
+}
+
+// collectStackStats samples the running containers of a stack once
+func collectStackStats(stackName string) (*StackStats, error) {
+	out, err := engineCommand("ps", "--format", `{{.Names}}	{{.Label "com.docker.compose.service"}}`,
+		"--filter", "label=com.docker.compose.project="+stackName).Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker ps failed: %w", err)
+	}
+	services := make(map[string]string)
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		name, service, _ := strings.Cut(line, "\t")
+		if name != "" {
+			services[name] = service
+			names = append(names, name)
+		}
+	}
+
+	stats := &StackStats{Stack: stackName, Time: time.Now().UTC(), Containers: []ContainerStats{}}
+	if len(names) == 0 {
+		return stats, nil
+	}
+	out, err = engineCommand(append([]string{"stats", "--no-stream", "--format", "{{json .}}"}, names...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker stats failed: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		var raw dockerStatsLine
+		if line == "" || json.Unmarshal([]byte(line), &raw) != nil {
+			continue
+		}
+		memUsage, memLimit := parseSizePair(raw.MemUsage)
+		rx, tx := parseSizePair(raw.NetIO)
+		read, write := parseSizePair(raw.BlockIO)
+		pids, _ := strconv.Atoi(strings.TrimSpace(raw.PIDs))
+		stats.Containers = append(stats.Containers, ContainerStats{
+			Container:     raw.Name,
+			Service:       services[raw.Name],
+			CPUPercent:    parsePercent(raw.CPUPerc),
+			MemoryUsage:   memUsage,
+			MemoryLimit:   memLimit,
+			MemoryPercent: parsePercent(raw.MemPerc),
+			NetworkRx:     rx,
+			NetworkTx:     tx,
+			BlockRead:     read,
+			BlockWrite:    write,
+			PIDs:          pids,
+		})
+	}
+	sort.Slice(stats.Containers, func(i, j int) bool { return stats.Containers[i].Container < stats.Containers[j].Container })
+	return stats, nil
+}
+
+// HandleStackStats prints the resource usage of a stack's containers as JSON. With --follow it
+// keeps sampling every --interval (default 2s) and prints one JSON object per line.
+func HandleStackStats(stackName string) error {
+	if !getFlagBool("follow", false) {
+		stats, err := collectStackStats(stackName)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(os.Stdout).Encode(stats)
+	}
+
+	interval, err := time.ParseDuration(getFlag("interval", defaultStatsInterval.String()))
+	if err != nil || interval <= 0 {
+		interval = defaultStatsInterval
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	for {
+		start := time.Now()
+		stats, err := collectStackStats(stackName)
+		if err != nil {
+			return err
+		}
+		if err := encoder.Encode(stats); err != nil {
+			return err
+		}
+		// docker stats itself takes a while, so only sleep for the remainder of the interval
+		time.Sleep(interval - time.Since(start))
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"dc/internal/compose"
+	"dc/internal/docker"
+	"gopkg.in/yaml.v3"
+)
+
+// Public stack states
+const (
+	StatusUp       = "up"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+// PublicStackStatus is what the public status page shows of a stack. It deliberately
+// contains nothing but the state, so that no container, image or host detail leaks.
+type PublicStackStatus struct {
+	Name          string `json:"name"`
+	Title         string `json:"title,omitempty"`
+	State         string `json:"state"`
+	Since         string `json:"since,omitempty"` // when the stack came up, RFC 3339
+	UptimeSeconds int64  `json:"uptimeSeconds,omitempty"`
+}
+
+// publicStacks returns the stacks flagged x-composectl.public with their title
+func publicStacks() map[string]string {
+	public := make(map[string]string)
+	for _, dir := range getAllStackDirs() {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.yml"))
+		for _, file := range files {
+			if strings.HasSuffix(file, ".effective.yml") {
+				continue
+			}
+			stackName := strings.TrimSuffix(filepath.Base(file), ".yml")
+			if _, seen := public[stackName]; seen {
+				continue
+			}
+			content, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			var composeFile compose.File
+			if err := yaml.Unmarshal(content, &composeFile); err != nil || composeFile.Composectl == nil || !composeFile.Composectl.Public {
+				continue
+			}
+			public[stackName] = composeFile.Composectl.Title
+		}
+	}
+	return public
+}
+
+// buildPublicStatus returns the state of all public stacks, sorted by name
+func buildPublicStatus() ([]PublicStackStatus, error) {
+	public := publicStacks()
+	statuses := []PublicStackStatus{}
+	if len(public) == 0 {
+		return statuses, nil
+	}
+	stacks, err := getStacksList()
+	if err != nil {
+		return nil, err
+	}
+	containers := make(map[string][]docker.Inspect)
+	for _, stack := range stacks {
+		containers[stack.Name] = stack.Containers
+	}
+
+	now := time.Now()
+	for name, title := range public {
+		status := PublicStackStatus{Name: name, Title: title, State: StatusDown}
+		running, latestStart := 0, ""
+		for _, c := range containers[name] {
+			unhealthy := c.State.Health != nil && c.State.Health.Status == "unhealthy"
+			if c.State.Running && !c.State.Restarting && !unhealthy {
+				running++
+				if c.State.StartedAt > latestStart {
+					latestStart = c.State.StartedAt
+				}
+			}
+		}
+		switch {
+		case running > 0 && running == len(containers[name]):
+			status.State = StatusUp
+			// The stack is fully up since its most recently started container came up
+			if started, err := time.Parse(time.RFC3339Nano, latestStart); err == nil {
+				status.Since = started.UTC().Format(time.RFC3339)
+				status.UptimeSeconds = int64(now.Sub(started).Seconds())
+			}
+		case running > 0:
+			status.State = StatusDegraded
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses, nil
+}
+
+// HandlePublicStatus prints the public status page data as JSON
+func HandlePublicStatus() error {
+	statuses, err := buildPublicStatus()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(statuses)
+}
@@ -0,0 +1,742 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Key prefixes of the storage target: backups/<stack>/<snapshot>/<volume>.tar.gz and
+// exports/<stack>/<time>/<stack>.tar.gz
+const (
+	storageBackupsPrefix = "backups"
+	storageExportsPrefix = "exports"
+)
+
+// storageTarget stores backup snapshots and export bundles off the host. Keys are slash
+// separated and relative to the target's root.
+type storageTarget interface {
+	String() string
+	// Upload copies a local file to key, reporting progress as bytes are sent
+	Upload(key, localPath string, progress func(sent, total int64)) error
+	// Download copies key to a local file
+	Download(key, localPath string) error
+	// List returns the names of the entries directly below dir; a missing dir is empty
+	List(dir string) ([]string, error)
+	// Remove deletes dir and the files in it
+	Remove(dir string) error
+}
+
+// getStorageTarget returns the configured storage target (config key backup_target), or nil
+// if backups and exports are only kept locally. Supported targets:
+//
+//	/path or file:///path                   a local directory, e.g. a mounted NAS share
+//	s3://bucket[/prefix]                     S3 or MinIO (s3_endpoint, s3_region, s3_access_key, s3_secret_key)
+//	sftp://[user@]host[:port]/path           SFTP through the sftp client (sftp_identity)
+//	rclone:remote:path                       any rclone remote
+func getStorageTarget() (storageTarget, error) {
+	target := getConfig("backup_target", "")
+	switch {
+	case target == "":
+		return nil, nil
+	case strings.HasPrefix(target, "rclone:"):
+		remote := strings.TrimPrefix(target, "rclone:")
+		if !strings.Contains(remote, ":") {
+			return nil, fmt.Errorf("invalid backup_target %q: expected rclone:<remote>:<path>", target)
+		}
+		return rcloneTarget{remote: strings.TrimSuffix(remote, "/")}, nil
+	case strings.HasPrefix(target, "/"):
+		return localTarget{root: target}, nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup_target %q: %w", target, err)
+	}
+	switch u.Scheme {
+	case "file":
+		return localTarget{root: u.Path}, nil
+	case "s3":
+		return newS3Target(u.Host, strings.Trim(u.Path, "/"))
+	case "sftp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid backup_target %q: missing host", target)
+		}
+		return sftpTarget{user: u.User.Username(), host: u.Hostname(), port: u.Port(), root: strings.TrimSuffix(u.Path, "/")}, nil
+	}
+	return nil, fmt.Errorf("unsupported backup_target %q", target)
+}
+
+// uploadProgress reports upload progress as [UPLOAD] frames in steps of 10%
+func uploadProgress(key string) func(sent, total int64) {
+	reported := -1
+	return func(sent, total int64) {
+		percent := 100
+		if total > 0 {
+			percent = int(sent * 100 / total)
+		}
+		if step := percent / 10; step > reported {
+			reported = step
+			writeFrame(FrameStdout, fmt.Sprintf("[UPLOAD] %s: %d%% (%.1f/%.1f MiB)",
+				key, percent, float64(sent)/(1<<20), float64(total)/(1<<20)))
+		}
+	}
+}
+
+// progressReader counts the bytes read through it
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	total    int64
+	progress func(sent, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.sent += int64(n)
+	p.progress(p.sent, p.total)
+	return n, err
+}
+
+// openForUpload opens a local file together with its size
+func openForUpload(localPath string) (*os.File, int64, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// remoteEntries lists dir on the target, newest first (entries are named by timestamp)
+func remoteEntries(target storageTarget, dir string) ([]string, error) {
+	entries, err := target.List(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s on %s: %w", dir, target, err)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(entries)))
+	return entries, nil
+}
+
+// pruneRemote removes all but the newest retention entries of dir on the target
+func pruneRemote(target storageTarget, dir string, retention int) {
+	entries, err := remoteEntries(target, dir)
+	if err != nil {
+		backupLog.Warn(err.Error())
+		return
+	}
+	if len(entries) <= retention {
+		return
+	}
+	for _, entry := range entries[retention:] {
+		if err := target.Remove(path.Join(dir, entry)); err != nil {
+			backupLog.Warn("Failed to remove old backup", "entry", entry, "target", target, "err", err)
+			continue
+		}
+		backupLog.Info("Removed old backup", "entry", entry, "target", target)
+	}
+}
+
+// localTarget stores files below a local directory
+type localTarget struct {
+	root string
+}
+
+func (t localTarget) String() string { return t.root }
+
+func (t localTarget) path(key string) string {
+	return filepath.Join(t.root, filepath.FromSlash(key))
+}
+
+func (t localTarget) Upload(key, localPath string, progress func(sent, total int64)) error {
+	src, size, err := openForUpload(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dest := t.path(key)
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	return copyToFile(dest, &progressReader{r: src, total: size, progress: progress})
+}
+
+func (t localTarget) Download(key, localPath string) error {
+	src, err := os.Open(t.path(key))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return copyToFile(localPath, src)
+}
+
+func (t localTarget) List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(t.path(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+func (t localTarget) Remove(dir string) error {
+	return os.RemoveAll(t.path(dir))
+}
+
+// copyToFile writes r to a new file, replacing it only once complete
+func copyToFile(dest string, r io.Reader) error {
+	tmp := dest + ".part"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// s3Target stores objects in an S3 bucket, signing requests with AWS signature version 4.
+// With s3_endpoint set (MinIO and other S3-compatible stores) path-style URLs are used.
+type s3Target struct {
+	bucket    string
+	prefix    string
+	endpoint  string
+	pathStyle bool
+	region    string
+	accessKey string
+	secretKey string
+	partSize  int64 // files larger than one part are sent as a multipart upload
+	client    *http.Client
+}
+
+// s3PartSize is the part size of multipart uploads. A single PUT is limited to 5 GiB and an
+// upload to 10000 parts, so larger files get larger parts.
+const (
+	s3PartSize = 64 << 20
+	s3MaxParts = 10000
+)
+
+func newS3Target(bucket, prefix string) (*s3Target, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid backup_target: missing S3 bucket")
+	}
+	t := &s3Target{
+		bucket:    bucket,
+		prefix:    prefix,
+		region:    getConfig("s3_region", getConfig("aws_region", "us-east-1")),
+		accessKey: getConfig("s3_access_key", os.Getenv("AWS_ACCESS_KEY_ID")),
+		secretKey: getConfig("s3_secret_key", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		partSize:  s3PartSize,
+		client:    &http.Client{},
+	}
+	if t.accessKey == "" || t.secretKey == "" {
+		return nil, fmt.Errorf("S3 backup_target requires s3_access_key and s3_secret_key")
+	}
+	if endpoint := getConfig("s3_endpoint", ""); endpoint != "" {
+		t.endpoint = strings.TrimSuffix(endpoint, "/")
+		t.pathStyle = true
+	} else {
+		t.endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, t.region)
+	}
+	return t, nil
+}
+
+func (t *s3Target) String() string {
+	return "s3://" + path.Join(t.bucket, t.prefix)
+}
+
+func (t *s3Target) objectKey(key string) string {
+	return strings.TrimPrefix(path.Join(t.prefix, key), "/")
+}
+
+// request sends a signed request for an object key ("" for the bucket)
+func (t *s3Target) request(method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	resource := "/"
+	if t.pathStyle {
+		resource += t.bucket + "/"
+	}
+	resource += key
+	rawQuery := awsCanonicalQuery(query)
+	target := t.endpoint + awsURIEncode(resource, false)
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	t.sign(req, resource, rawQuery, time.Now())
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var s3Err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if xml.NewDecoder(resp.Body).Decode(&s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("S3 %s %s: %s: %s", method, key, s3Err.Code, s3Err.Message)
+		}
+		return nil, fmt.Errorf("S3 %s %s: %s", method, key, resp.Status)
+	}
+	return resp, nil
+}
+
+// sign adds an AWS signature version 4 Authorization header, signing the host and every header
+// already set on the request. Payloads are not hashed (UNSIGNED-PAYLOAD) unless the request
+// carries x-amz-content-sha256, so that uploads can be streamed.
+func (t *s3Target) sign(req *http.Request, resource, rawQuery string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	payloadHash := req.Header.Get("x-amz-content-sha256")
+	if payloadHash == "" {
+		payloadHash = "UNSIGNED-PAYLOAD"
+		req.Header.Set("x-amz-content-sha256", payloadHash)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(resource, false),
+		rawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + t.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + t.secretKey)
+	for _, part := range []string{day, t.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		t.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode percent-encodes everything but unreserved characters (and slashes, unless
+// encodeSlash), as signature version 4 requires
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsCanonicalQuery encodes a query string with sorted keys
+func awsCanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, awsURIEncode(key, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func (t *s3Target) Upload(key, localPath string, progress func(sent, total int64)) error {
+	f, size, err := openForUpload(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if size > t.partSize {
+		return t.uploadMultipart(t.objectKey(key), f, size, progress)
+	}
+	resp, err := t.request(http.MethodPut, t.objectKey(key), nil, &progressReader{r: f, total: size, progress: progress}, size)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// uploadMultipart sends a file as a multipart upload, which is aborted if a part fails
+func (t *s3Target) uploadMultipart(key string, f *os.File, size int64, progress func(sent, total int64)) error {
+	resp, err := t.request(http.MethodPost, key, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("invalid S3 multipart upload response: %v", err)
+	}
+
+	if err := t.uploadParts(key, initiated.UploadID, f, size, progress); err != nil {
+		if resp, abortErr := t.request(http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil, 0); abortErr == nil {
+			resp.Body.Close()
+		} else {
+			backupLog.Warn("Failed to abort the multipart upload", "key", key, "err", abortErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// uploadParts sends the parts of a multipart upload and completes it
+func (t *s3Target) uploadParts(key, uploadID string, f *os.File, size int64, progress func(sent, total int64)) error {
+	partSize := t.partSize
+	if minimum := (size + s3MaxParts - 1) / s3MaxParts; partSize < minimum {
+		partSize = minimum
+	}
+	type completedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []completedPart
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+partSize, number+1 {
+		length := min(partSize, size-offset)
+		query := url.Values{"partNumber": {fmt.Sprint(number)}, "uploadId": {uploadID}}
+		body := &progressReader{r: io.NewSectionReader(f, offset, length), sent: offset, total: size, progress: progress}
+		resp, err := t.request(http.MethodPut, key, query, body, length)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	}
+
+	complete, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := t.request(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, bytes.NewReader(complete), int64(len(complete)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// S3 may report a failed completion in the body of a 200 response
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.NewDecoder(resp.Body).Decode(&result) == nil && result.XMLName.Local == "Error" {
+		return fmt.Errorf("S3 complete multipart upload %s: %s: %s", key, result.Code, result.Message)
+	}
+	return nil
+}
+
+func (t *s3Target) Download(key, localPath string) error {
+	resp, err := t.request(http.MethodGet, t.objectKey(key), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return copyToFile(localPath, resp.Body)
+}
+
+// listObjects lists the keys below prefix, or with a delimiter only the next level
+func (t *s3Target) listObjects(prefix, delimiter string) (keys, prefixes []string, err error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	for {
+		resp, err := t.request(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		var result struct {
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+			Contents              []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			CommonPrefixes []struct {
+				Prefix string `xml:"Prefix"`
+			} `xml:"CommonPrefixes"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid S3 list response: %w", err)
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		for _, p := range result.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+		if !result.IsTruncated {
+			return keys, prefixes, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (t *s3Target) List(dir string) ([]string, error) {
+	prefix := t.objectKey(dir) + "/"
+	keys, prefixes, err := t.listObjects(prefix, "/")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range append(keys, prefixes...) {
+		if name = strings.TrimSuffix(strings.TrimPrefix(name, prefix), "/"); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (t *s3Target) Remove(dir string) error {
+	keys, _, err := t.listObjects(t.objectKey(dir)+"/", "")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		resp, err := t.request(http.MethodDelete, key, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// sftpTarget stores files on an SFTP server using the sftp client in batch mode, so the
+// usual ssh configuration (keys, known_hosts, agent) applies
+type sftpTarget struct {
+	user, host, port, root string
+}
+
+func (t sftpTarget) String() string {
+	dest := t.host
+	if t.user != "" {
+		dest = t.user + "@" + dest
+	}
+	if t.port != "" {
+		dest += ":" + t.port
+	}
+	return "sftp://" + dest + t.root
+}
+
+func (t sftpTarget) path(key string) string {
+	return path.Join(t.root, key)
+}
+
+// batch runs sftp commands; lines starting with "-" may fail without aborting the batch
+func (t sftpTarget) batch(commands ...string) (string, error) {
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+	if t.port != "" {
+		args = append(args, "-P", t.port)
+	}
+	if identity := getConfig("sftp_identity", ""); identity != "" {
+		args = append(args, "-i", identity)
+	}
+	dest := t.host
+	if t.user != "" {
+		dest = t.user + "@" + dest
+	}
+	cmd := exec.Command("sftp", append(args, dest)...)
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("sftp failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+func sftpQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (t sftpTarget) Upload(key, localPath string, progress func(sent, total int64)) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	progress(0, info.Size())
+	var commands []string
+	dir := ""
+	for _, part := range strings.Split(path.Dir(t.path(key)), "/") {
+		if dir = path.Join(dir, part); part != "" {
+			commands = append(commands, "-mkdir "+sftpQuote("/"+dir))
+		}
+	}
+	commands = append(commands, "put "+sftpQuote(localPath)+" "+sftpQuote(t.path(key)))
+	if _, err := t.batch(commands...); err != nil {
+		return err
+	}
+	progress(info.Size(), info.Size())
+	return nil
+}
+
+func (t sftpTarget) Download(key, localPath string) error {
+	_, err := t.batch("get " + sftpQuote(t.path(key)) + " " + sftpQuote(localPath))
+	return err
+}
+
+func (t sftpTarget) List(dir string) ([]string, error) {
+	out, err := t.batch("-ls -1 " + sftpQuote(t.path(dir)))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "sftp>") {
+			continue
+		}
+		names = append(names, path.Base(line))
+	}
+	return names, nil
+}
+
+func (t sftpTarget) Remove(dir string) error {
+	names, err := t.List(dir)
+	if err != nil {
+		return err
+	}
+	var commands []string
+	for _, name := range names {
+		commands = append(commands, "rm "+sftpQuote(path.Join(t.path(dir), name)))
+	}
+	_, err = t.batch(append(commands, "rmdir "+sftpQuote(t.path(dir)))...)
+	return err
+}
+
+// rcloneTarget stores files on any rclone remote, configured in rclone's own config file
+type rcloneTarget struct {
+	remote string
+}
+
+func (t rcloneTarget) String() string { return "rclone:" + t.remote }
+
+func (t rcloneTarget) path(key string) string {
+	return t.remote + "/" + key
+}
+
+func (t rcloneTarget) run(args ...string) (string, error) {
+	cmd := exec.Command("rclone", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("rclone %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+func (t rcloneTarget) Upload(key, localPath string, progress func(sent, total int64)) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	progress(0, info.Size())
+	if _, err := t.run("copyto", localPath, t.path(key)); err != nil {
+		return err
+	}
+	progress(info.Size(), info.Size())
+	return nil
+}
+
+func (t rcloneTarget) Download(key, localPath string) error {
+	_, err := t.run("copyto", t.path(key), localPath)
+	return err
+}
+
+func (t rcloneTarget) List(dir string) ([]string, error) {
+	out, err := exec.Command("rclone", "lsf", t.path(dir)).Output()
+	if err != nil {
+		// exit code 3: directory not found
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 3 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("rclone lsf failed: %w", err)
+	}
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line = strings.TrimSuffix(line, "/"); line != "" {
+			names = append(names, line)
+		}
+	}
+	return names, nil
+}
+
+func (t rcloneTarget) Remove(dir string) error {
+	_, err := t.run("purge", t.path(dir))
+	return err
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"dc/internal/docker"
+)
+
+const (
+	// restartLoopThreshold is the restart count from which a container is reported as looping,
+	// if it was last started within restartLoopWindow
+	restartLoopThreshold = 3
+	restartLoopWindow    = 10 * time.Minute
+
+	// recentDeploymentsLimit is the number of deployments listed in the summary
+	recentDeploymentsLimit = 10
+)
+
+// Summary is the landing page overview returned by GET /api/summary
+type Summary struct {
+	Stacks            StackCounts  `json:"stacks"`
+	Containers        ItemCounts   `json:"containers"`
+	Images            int          `json:"images"`
+	Volumes           int          `json:"volumes"`
+	RecentDeployments []Deployment `json:"recentDeployments"`
+	Alerts            []Alert      `json:"alerts"`
+}
+
+// StackCounts counts stacks by state: all containers running, some running, none running
+type StackCounts struct {
+	Total   int `json:"total"`
+	Running int `json:"running"`
+	Partial int `json:"partial"`
+	Stopped int `json:"stopped"`
+}
+
+// ItemCounts counts all and running items
+type ItemCounts struct {
+	Total   int `json:"total"`
+	Running int `json:"running"`
+}
+
+// Deployment is the most recent container start of a stack
+type Deployment struct {
+	Stack     string `json:"stack"`
+	StartedAt string `json:"startedAt"`
+}
+
+// Alert is a condition that needs attention
+type Alert struct {
+	Type      string `json:"type"` // unhealthy, restart-loop, pending-update
+	Stack     string `json:"stack"`
+	Container string `json:"container"`
+	Message   string `json:"message"`
+}
+
+// countDockerObjects returns the number of lines of `docker <object> ls -q`
+func countDockerObjects(object string) int {
+	out, err := engineCommand(object, "ls", "-q").Output()
+	if err != nil {
+		return 0
+	}
+	return len(splitLines(strings.TrimSpace(string(out))))
+}
+
+// localImageID returns the ID the given image reference currently resolves to locally
+func localImageID(ref string, cache map[string]string) string {
+	if id, ok := cache[ref]; ok {
+		return id
+	}
+	out, err := engineCommand("image", "inspect", "--format", "{{.Id}}", ref).Output()
+	id := ""
+	if err == nil {
+		id = strings.TrimSpace(string(out))
+	}
+	cache[ref] = id
+	return id
+}
+
+// restartLooping reports whether a container keeps restarting: it is restarting now, or it was
+// restarted restartLoopThreshold times and the last start is recent. RestartCount counts the
+// restarts since the container was created, so restarts long ago alone are no loop.
+func restartLooping(c docker.Inspect, now time.Time) bool {
+	if c.State.Restarting {
+		return true
+	}
+	if c.RestartCount < restartLoopThreshold {
+		return false
+	}
+	started, err := time.Parse(time.RFC3339Nano, c.State.StartedAt)
+	return err == nil && now.Sub(started) < restartLoopWindow
+}
+
+// buildSummary aggregates stack, container and alert information in one pass
+func buildSummary() (*Summary, error) {
+	stacks, err := getStacksList()
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &Summary{RecentDeployments: []Deployment{}, Alerts: []Alert{}}
+	imageIDs := make(map[string]string)
+	for _, stack := range stacks {
+		if stack.Name == "none" {
+			continue
+		}
+		summary.Stacks.Total++
+
+		running, latestStart := 0, ""
+		for _, c := range stack.Containers {
+			summary.Containers.Total++
+			name := strings.TrimPrefix(c.Name, "/")
+			if c.State.Running {
+				running++
+				summary.Containers.Running++
+				if c.State.StartedAt > latestStart {
+					latestStart = c.State.StartedAt
+				}
+			}
+
+			if c.State.Health != nil && c.State.Health.Status == "unhealthy" {
+				summary.Alerts = append(summary.Alerts, Alert{"unhealthy", stack.Name, name,
+					"healthcheck failing"})
+			}
+			if restartLooping(c, time.Now()) {
+				summary.Alerts = append(summary.Alerts, Alert{"restart-loop", stack.Name, name,
+					"container keeps restarting"})
+			}
+			// A newer image was pulled for the container's tag but it was not recreated yet
+			if c.State.Running && c.Image != "" && c.Config.Image != "" {
+				if id := localImageID(c.Config.Image, imageIDs); id != "" && id != c.Image {
+					summary.Alerts = append(summary.Alerts, Alert{"pending-update", stack.Name, name,
+						"a newer " + c.Config.Image + " image is available locally"})
+				}
+			}
+		}
+
+		switch {
+		case len(stack.Containers) > 0 && running == len(stack.Containers):
+			summary.Stacks.Running++
+		case running > 0:
+			summary.Stacks.Partial++
+		default:
+			summary.Stacks.Stopped++
+		}
+		if latestStart != "" {
+			summary.RecentDeployments = append(summary.RecentDeployments, Deployment{stack.Name, latestStart})
+		}
+	}
+
+	// Docker timestamps are RFC 3339 and sort lexically
+	sort.Slice(summary.RecentDeployments, func(i, j int) bool {
+		return summary.RecentDeployments[i].StartedAt > summary.RecentDeployments[j].StartedAt
+	})
+	if len(summary.RecentDeployments) > recentDeploymentsLimit {
+		summary.RecentDeployments = summary.RecentDeployments[:recentDeploymentsLimit]
+	}
+	for i, d := range summary.RecentDeployments {
+		if t, err := time.Parse(time.RFC3339Nano, d.StartedAt); err == nil {
+			summary.RecentDeployments[i].StartedAt = t.UTC().Format(time.RFC3339)
+		}
+	}
+
+	summary.Images = countDockerObjects("image")
+	summary.Volumes = countDockerObjects("volume")
+	return summary, nil
+}
+
+// HandleSummary prints the landing page summary as JSON
+func HandleSummary() error {
+	summary, err := buildSummary()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(summary)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"dc/internal/docker"
+)
+
+func TestRestartLooping(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	container := func(restarting bool, restarts int, startedAt time.Time) docker.Inspect {
+		var c docker.Inspect
+		c.State.Restarting = restarting
+		c.RestartCount = restarts
+		c.State.StartedAt = startedAt.Format(time.RFC3339Nano)
+		return c
+	}
+	tests := []struct {
+		name      string
+		container docker.Inspect
+		want      bool
+	}{
+		{"restarting now", container(true, 0, now.Add(-time.Hour)), true},
+		{"restarted recently", container(false, 5, now.Add(-time.Minute)), true},
+		{"restarted weeks ago", container(false, 3, now.Add(-21*24*time.Hour)), false},
+		{"few restarts", container(false, 2, now.Add(-time.Minute)), false},
+		{"never started", docker.Inspect{RestartCount: 4}, false},
+	}
+	for _, tt := range tests {
+		if got := restartLooping(tt.container, now); got != tt.want {
+			t.Errorf("%s: restartLooping = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DiskUsageCategory is one line of `docker system df`
+type DiskUsageCategory struct {
+	Type        string `json:"type"`
+	Total       int    `json:"total"`
+	Active      int    `json:"active"`
+	Size        int64  `json:"size"`
+	Reclaimable int64  `json:"reclaimable"`
+}
+
+// StackDiskUsage is the disk space attributed to the containers of one compose project.
+// Images shared by several stacks are counted for each of them.
+type StackDiskUsage struct {
+	Stack         string   `json:"stack"`
+	Containers    int      `json:"containers"`
+	ContainerSize int64    `json:"containerSize"` // writable layers
+	Images        []string `json:"images"`
+	ImageSize     int64    `json:"imageSize"`
+	Volumes       []string `json:"volumes"`
+	VolumeSize    int64    `json:"volumeSize"`
+}
+
+// DiskUsage is the output of `dc system df`
+type DiskUsage struct {
+	Categories []DiskUsageCategory `json:"categories"`
+	Stacks     []StackDiskUsage    `json:"stacks"`
+}
+
+// PruneResult is the output of `dc system prune`
+type PruneResult struct {
+	DryRun         bool     `json:"dryRun,omitempty"`
+	Images         []string `json:"images"`
+	Containers     []string `json:"containers"`
+	Networks       []string `json:"networks"`
+	SpaceReclaimed int64    `json:"spaceReclaimed"`
+}
+
+// parseDockerSize parses sizes printed by docker, ignoring suffixes like " (50%)" or " (virtual 1GB)"
+func parseDockerSize(s string) int64 {
+	size, _, _ := strings.Cut(strings.TrimSpace(s), " ")
+	return parseStatsSize(size)
+}
+
+// dockerJSONLines runs a docker command printing one JSON object per line and decodes each
+func dockerJSONLines(args []string, decode func(line []byte) error) error {
+	out, err := engineCommand(args...).Output()
+	if err != nil {
+		return fmt.Errorf("docker %s failed: %w", strings.Join(args[:2], " "), err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line == "" {
+			continue
+		}
+		if err := decode([]byte(line)); err != nil {
+			return fmt.Errorf("unexpected docker %s output: %w", strings.Join(args[:2], " "), err)
+		}
+	}
+	return nil
+}
+
+// labelValue returns a label from docker's comma-separated label list
+func labelValue(labels, key string) string {
+	for _, label := range strings.Split(labels, ",") {
+		if k, v, ok := strings.Cut(label, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// diskUsageCategories parses `docker system df`
+func diskUsageCategories() ([]DiskUsageCategory, error) {
+	categories := []DiskUsageCategory{}
+	err := dockerJSONLines([]string{"system", "df", "--format", "{{json .}}"}, func(line []byte) error {
+		var row struct{ Type, TotalCount, Active, Size, Reclaimable string }
+		if err := json.Unmarshal(line, &row); err != nil {
+			return err
+		}
+		category := DiskUsageCategory{Type: row.Type, Size: parseDockerSize(row.Size), Reclaimable: parseDockerSize(row.Reclaimable)}
+		fmt.Sscan(row.TotalCount, &category.Total)
+		fmt.Sscan(row.Active, &category.Active)
+		categories = append(categories, category)
+		return nil
+	})
+	return categories, err
+}
+
+// verboseDiskUsage returns the image sizes (by ID and repository:tag) and volume sizes of
+// `docker system df -v`
+func verboseDiskUsage() (images, volumes map[string]int64, err error) {
+	out, err := engineCommand("system", "df", "-v", "--format", "{{json .}}").Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("docker system df failed: %w", err)
+	}
+	var df struct {
+		Images []struct {
+			ID, Repository, Tag, Size string
+		}
+		Volumes []struct {
+			Name, Size string
+		}
+	}
+	if err := json.Unmarshal(out, &df); err != nil {
+		return nil, nil, fmt.Errorf("unexpected docker system df output: %w", err)
+	}
+	images = make(map[string]int64)
+	volumes = make(map[string]int64)
+	for _, image := range df.Images {
+		size := parseDockerSize(image.Size)
+		images[strings.TrimPrefix(image.ID, "sha256:")] = size
+		if image.Repository != "" && image.Repository != "<none>" {
+			images[image.Repository+":"+image.Tag] = size
+		}
+	}
+	for _, volume := range df.Volumes {
+		volumes[volume.Name] = parseDockerSize(volume.Size)
+	}
+	return images, volumes, nil
+}
+
+// imageSize looks up an image reference as printed by docker ps
+func imageSize(images map[string]int64, image string) int64 {
+	if size, ok := images[image]; ok {
+		return size
+	}
+	if !strings.Contains(image, ":") {
+		return images[image+":latest"]
+	}
+	return images[strings.TrimPrefix(image, "sha256:")]
+}
+
+// HandleSystemDF prints docker's disk usage by category and by stack as JSON
+func HandleSystemDF() error {
+	categories, err := diskUsageCategories()
+	if err != nil {
+		return err
+	}
+	// Per-stack sizes are best effort: the verbose format varies between docker versions
+	imageSizes, volumeSizes, err := verboseDiskUsage()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		imageSizes, volumeSizes = map[string]int64{}, map[string]int64{}
+	}
+	_, declaredVolumes := declaredResources()
+
+	stacks := make(map[string]*StackDiskUsage)
+	images := make(map[string]map[string]bool)
+	volumes := make(map[string]map[string]bool)
+	err = dockerJSONLines([]string{"ps", "-a", "--size", "--no-trunc", "--format", "{{json .}}"}, func(line []byte) error {
+		var c struct{ Image, Labels, Mounts, Size string }
+		if err := json.Unmarshal(line, &c); err != nil {
+			return err
+		}
+		project := labelValue(c.Labels, "com.docker.compose.project")
+		if project == "" {
+			return nil
+		}
+		usage, ok := stacks[project]
+		if !ok {
+			usage = &StackDiskUsage{Stack: project}
+			stacks[project] = usage
+			images[project] = make(map[string]bool)
+			volumes[project] = make(map[string]bool)
+		}
+		usage.Containers++
+		usage.ContainerSize += parseDockerSize(c.Size)
+		images[project][c.Image] = true
+		for _, mount := range strings.Split(c.Mounts, ",") {
+			if _, isVolume := volumeSizes[mount]; isVolume {
+				volumes[project][mount] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Volumes declared by a stack belong to it even while no container mounts them
+	for name := range volumeSizes {
+		for _, stackName := range declaredVolumes[name] {
+			if _, ok := stacks[stackName]; ok {
+				volumes[stackName][name] = true
+			}
+		}
+	}
+
+	result := DiskUsage{Categories: categories, Stacks: []StackDiskUsage{}}
+	for project, usage := range stacks {
+		for image := range images[project] {
+			usage.Images = append(usage.Images, image)
+			usage.ImageSize += imageSize(imageSizes, image)
+		}
+		for volume := range volumes[project] {
+			usage.Volumes = append(usage.Volumes, volume)
+			usage.VolumeSize += volumeSizes[volume]
+		}
+		usage.Images = nonNil(usage.Images)
+		usage.Volumes = nonNil(usage.Volumes)
+		result.Stacks = append(result.Stacks, *usage)
+	}
+	sort.Slice(result.Stacks, func(i, j int) bool { return result.Stacks[i].Stack < result.Stacks[j].Stack })
+	return json.NewEncoder(os.Stdout).Encode(result)
+}
+
+// knownStacks returns the names of all stacks with a stack file
+func knownStacks() map[string]bool {
+	known := make(map[string]bool)
+	for stackName := range stackFiles() {
+		known[stackName] = true
+	}
+	return known
+}
+
+// HandleSystemPrune removes what the toggles select and prints a PruneResult as JSON:
+//
+//	--images=true      dangling images
+//	--containers=true  stopped containers that don't belong to a known stack
+//	--networks=true    networks no container uses and no stack declares
+func HandleSystemPrune() error {
+	pruneImages := getFlagBool("images", false)
+	pruneContainers := getFlagBool("containers", false)
+	pruneNetworks := getFlagBool("networks", false)
+	if !pruneImages && !pruneContainers && !pruneNetworks {
+		return fmt.Errorf("nothing to prune: enable --images, --containers and/or --networks")
+	}
+	result := PruneResult{DryRun: DryRun, Images: []string{}, Containers: []string{}, Networks: []string{}}
+
+	if pruneContainers {
+		known := knownStacks()
+		err := dockerJSONLines([]string{"ps", "-a", "--size", "--no-trunc", "--filter", "status=exited", "--filter", "status=created", "--filter", "status=dead", "--format", "{{json .}}"}, func(line []byte) error {
+			var c struct{ ID, Names, Labels, Size string }
+			if err := json.Unmarshal(line, &c); err != nil {
+				return err
+			}
+			if known[labelValue(c.Labels, "com.docker.compose.project")] {
+				return nil
+			}
+			if !DryRun {
+				if output, err := engineCommand("rm", c.ID).CombinedOutput(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to remove container %s: %s\n", c.Names, strings.TrimSpace(string(output)))
+					return nil
+				}
+			}
+			result.Containers = append(result.Containers, c.Names)
+			result.SpaceReclaimed += parseDockerSize(c.Size)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Containers go first: removing them can leave their images dangling
+	if pruneImages {
+		err := dockerJSONLines([]string{"images", "--filter", "dangling=true", "--no-trunc", "--format", "{{json .}}"}, func(line []byte) error {
+			var image struct{ ID, Size string }
+			if err := json.Unmarshal(line, &image); err != nil {
+				return err
+			}
+			if !DryRun {
+				if output, err := engineCommand("rmi", image.ID).CombinedOutput(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to remove image %s: %s\n", image.ID, strings.TrimSpace(string(output)))
+					return nil
+				}
+			}
+			result.Images = append(result.Images, image.ID)
+			result.SpaceReclaimed += parseDockerSize(image.Size)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if pruneNetworks {
+		networks, err := listNetworks()
+		if err != nil {
+			return err
+		}
+		for _, n := range networks {
+			if !n.Unused {
+				continue
+			}
+			if !DryRun {
+				if output, err := engineCommand("network", "rm", n.Name).CombinedOutput(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to remove network %s: %s\n", n.Name, strings.TrimSpace(string(output)))
+					continue
+				}
+			}
+			result.Networks = append(result.Networks, n.Name)
+		}
+	}
+
+	return json.NewEncoder(os.Stdout).Encode(result)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"dc/internal/compose"
+	"dc/internal/secrets"
+	"gopkg.in/yaml.v3"
+)
+
+// transformStep is an enrichment step that only transforms the compose structure: it neither
+// reads nor writes server state (stack files, prod.env, the secrets store or docker)
+type transformStep struct {
+	Name        string
+	Description string
+	Apply       func(composeFile *compose.File) []string // returns warnings
+}
+
+// transformSteps are the steps available to `dc transform` and POST /api/transform, in the order
+// in which they are applied
+var transformSteps = []transformStep{
+	{"container-names", "set container_name to the service name where missing", func(composeFile *compose.File) []string {
+		compose.EnsureContainerNames(composeFile)
+		return nil
+	}},
+	{"resource-defaults", "set the mem_limit and cpus defaults of the image class where missing", ensureResourceDefaults},
+	{"healthchecks", "add healthchecks for well-known images to services without one", func(composeFile *compose.File) []string {
+		var warnings []string
+		for _, name := range addDefaultHealthchecks(composeFile) {
+			warnings = append(warnings, fmt.Sprintf("service %s: added a default healthcheck for %s", name, composeFile.Services[name].Image))
+		}
+		return warnings
+	}},
+	{"log-defaults", "give services without a logging config the default one (log_driver)", func(composeFile *compose.File) []string {
+		var warnings []string
+		for _, name := range addLogDefaults(composeFile) {
+			warnings = append(warnings, fmt.Sprintf("service %s: added the default %s logging", name, composeFile.Services[name].Logging.Driver))
+		}
+		return warnings
+	}},
+	{"homelab-network", "attach every service to the homelab network", func(composeFile *compose.File) []string {
+		compose.EnsureNetwork(composeFile, homelabNetwork)
+		return nil
+	}},
+	{"dual-stack-ports", "publish ports on IPv4 and IPv6 explicitly", func(composeFile *compose.File) []string {
+		for name, service := range composeFile.Services {
+			service.Ports = compose.DualStackPorts(service.Ports)
+			composeFile.Services[name] = service
+		}
+		return nil
+	}},
+	{"undeclared-resources", "declare referenced networks and volumes as external", func(composeFile *compose.File) []string {
+		networks, volumes := compose.AddUndeclaredNetworksAndVolumes(composeFile)
+		var warnings []string
+		for _, name := range networks {
+			warnings = append(warnings, fmt.Sprintf("network %s is not declared; added as external", name))
+		}
+		for _, name := range volumes {
+			warnings = append(warnings, fmt.Sprintf("volume %s is not declared; added as external", name))
+		}
+		return warnings
+	}},
+	{"sanitize-env", "replace plaintext credentials in environment by ${VAR} references", func(composeFile *compose.File) []string {
+		var warnings []string
+		for name, service := range composeFile.Services {
+			var env []string
+			for _, envVar := range compose.NormalizeEnvironment(service.Environment) {
+				if key, _, _ := strings.Cut(envVar, "="); compose.KeepPlaintext(composeFile, name, key) {
+					env = append(env, envVar)
+					continue
+				}
+				sanitized := compose.SanitizeEnvVar(envVar, nil)
+				if key, value, ok := strings.Cut(envVar, "="); ok && sanitized != envVar && value != "" && !compose.ReferencesVariables(value) {
+					warnings = append(warnings, fmt.Sprintf("service %s: %s holds a plaintext credential; replaced by ${%s}, which must be provided as a secret", name, key, secrets.NormalizeKey(key)))
+				}
+				env = append(env, sanitized)
+			}
+			if env != nil {
+				service.Environment = env
+				composeFile.Services[name] = service
+			}
+		}
+		return warnings
+	}},
+	{"proxy-labels", "add Traefik labels to services with an HTTP port", func(composeFile *compose.File) []string {
+		for name, service := range composeFile.Services {
+			enrichWithProxy(&service, name)
+			composeFile.Services[name] = service
+		}
+		return nil
+	}},
+	{"dashboard-labels", "add the dashboard labels (dashboard_labels) of the services in x-composectl.dashboard", func(composeFile *compose.File) []string {
+		return ensureDashboardLabels(composeFile, composeFile.Group)
+	}},
+}
+
+// defaultTransformSteps are applied when no steps are given; healthchecks and dual-stack-ports are opt-in
+var defaultTransformSteps = []string{"container-names", "resource-defaults", "log-defaults", "homelab-network", "undeclared-resources", "sanitize-env", "proxy-labels", "dashboard-labels"}
+
+// TransformResult is the output of `dc transform`
+type TransformResult struct {
+	YAML     string   `json:"yaml"`
+	Steps    []string `json:"steps"`
+	Warnings []string `json:"warnings"`
+}
+
+// transformCompose applies the named steps to compose YAML without touching any server state
+func transformCompose(content []byte, steps []string) (*TransformResult, error) {
+	if len(steps) == 0 {
+		steps = defaultTransformSteps
+	}
+	selected := make(map[string]bool)
+	for _, step := range steps {
+		known := false
+		for _, s := range transformSteps {
+			if s.Name == step {
+				known = true
+				break
+			}
+		}
+		if !known {
+			names := make([]string, len(transformSteps))
+			for i, s := range transformSteps {
+				names[i] = s.Name
+			}
+			return nil, fmt.Errorf("unknown transform step %q (known steps: %s)", step, strings.Join(names, ", "))
+		}
+		selected[step] = true
+	}
+
+	var composeFile compose.File
+	if err := yaml.Unmarshal(content, &composeFile); err != nil {
+		return nil, fmt.Errorf("invalid compose YAML: %w", err)
+	}
+	if len(composeFile.Services) == 0 {
+		return nil, fmt.Errorf("compose YAML has no services")
+	}
+
+	result := &TransformResult{Warnings: []string{}}
+	for _, step := range transformSteps {
+		if !selected[step.Name] {
+			continue
+		}
+		result.Steps = append(result.Steps, step.Name)
+		warnings := step.Apply(&composeFile)
+		sort.Strings(warnings)
+		result.Warnings = append(result.Warnings, warnings...)
+	}
+
+	var buf strings.Builder
+	if err := encodeYAMLWithMultiline(&buf, &composeFile); err != nil {
+		return nil, err
+	}
+	result.YAML = buf.String()
+	return result, nil
+}
+
+// HandleTransform reads compose YAML from stdin, applies --steps=a,b (default: all but opt-in
+// steps) and prints the transformed YAML and warnings as JSON. Nothing is read or written on
+// the server, so it can back CI pipelines that want the enrichment as a service.
+func HandleTransform() error {
+	content, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read stdin: %w", err)
+	}
+	var steps []string
+	for _, step := range strings.Split(getFlag("steps", ""), ",") {
+		if step = strings.TrimSpace(step); step != "" {
+			steps = append(steps, step)
+		}
+	}
+	result, err := transformCompose(content, steps)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(result)
+}
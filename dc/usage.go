@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Usage history defaults: one sample per minute, kept for a week
+const (
+	defaultUsageInterval  = time.Minute
+	defaultUsageRetention = 7 * 24 * time.Hour
+	defaultUsageRange     = 24 * time.Hour
+)
+
+// Usage files are ring buffers: a header followed by capacity fixed-size records.
+//
+//	header: "DCU1" | capacity uint32 | next uint32 | count uint32
+//	record: unix seconds int64 | cpu percent float64 | memory bytes int64 | containers int64
+const (
+	usageMagic      = "DCU1"
+	usageHeaderSize = 16
+	usageRecordSize = 32
+)
+
+// UsageSample is the summed resource usage of a stack's running containers at one point in time
+type UsageSample struct {
+	Time        time.Time `json:"time"`
+	CPUPercent  float64   `json:"cpuPercent"`
+	MemoryUsage int64     `json:"memoryUsage"`
+	Containers  int64     `json:"containers"`
+}
+
+// UsageHistory is the output of `dc stack usage`
+type UsageHistory struct {
+	Stack   string        `json:"stack"`
+	Range   string        `json:"range"`
+	Samples []UsageSample `json:"samples"`
+}
+
+// getUsageDir returns the directory holding one usage file per stack (config key usage_dir)
+func getUsageDir() string {
+	return getConfig("usage_dir", filepath.Join(StacksDir, ".usage"))
+}
+
+func usagePath(stackName string) string {
+	return filepath.Join(getUsageDir(), stackName+".usage")
+}
+
+// usageDurations returns the sampling interval (usage_interval) and retention (usage_retention)
+func usageDurations() (time.Duration, time.Duration) {
+	interval, err := time.ParseDuration(getConfig("usage_interval", ""))
+	if err != nil || interval <= 0 {
+		interval = defaultUsageInterval
+	}
+	retention, err := time.ParseDuration(getConfig("usage_retention", ""))
+	if err != nil || retention < interval {
+		retention = defaultUsageRetention
+	}
+	return interval, retention
+}
+
+// usageSampleFromStats sums a stats sample of a stack
+func usageSampleFromStats(stats *StackStats) UsageSample {
+	sample := UsageSample{Time: stats.Time, Containers: int64(len(stats.Containers))}
+	for _, c := range stats.Containers {
+		sample.CPUPercent += c.CPUPercent
+		sample.MemoryUsage += c.MemoryUsage
+	}
+	return sample
+}
+
+// readUsage returns the samples of a usage file, oldest first
+func readUsage(path string) ([]UsageSample, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < usageHeaderSize || string(content[:4]) != usageMagic {
+		return nil, fmt.Errorf("%s is not a usage file", path)
+	}
+	capacity := int(binary.LittleEndian.Uint32(content[4:]))
+	next := int(binary.LittleEndian.Uint32(content[8:]))
+	count := int(binary.LittleEndian.Uint32(content[12:]))
+	if capacity == 0 || next >= capacity || count > capacity || len(content) < usageHeaderSize+count*usageRecordSize {
+		return nil, fmt.Errorf("%s is corrupt", path)
+	}
+
+	samples := make([]UsageSample, 0, count)
+	for i := 0; i < count; i++ {
+		slot := (next - count + i + capacity) % capacity
+		record := content[usageHeaderSize+slot*usageRecordSize:]
+		samples = append(samples, UsageSample{
+			Time:        time.Unix(int64(binary.LittleEndian.Uint64(record)), 0).UTC(),
+			CPUPercent:  math.Float64frombits(binary.LittleEndian.Uint64(record[8:])),
+			MemoryUsage: int64(binary.LittleEndian.Uint64(record[16:])),
+			Containers:  int64(binary.LittleEndian.Uint64(record[24:])),
+		})
+	}
+	return samples, nil
+}
+
+func encodeUsageRecord(sample UsageSample) []byte {
+	record := make([]byte, usageRecordSize)
+	binary.LittleEndian.PutUint64(record, uint64(sample.Time.Unix()))
+	binary.LittleEndian.PutUint64(record[8:], math.Float64bits(sample.CPUPercent))
+	binary.LittleEndian.PutUint64(record[16:], uint64(sample.MemoryUsage))
+	binary.LittleEndian.PutUint64(record[24:], uint64(sample.Containers))
+	return record
+}
+
+func encodeUsageHeader(capacity, next, count int) []byte {
+	header := make([]byte, usageHeaderSize)
+	copy(header, usageMagic)
+	binary.LittleEndian.PutUint32(header[4:], uint32(capacity))
+	binary.LittleEndian.PutUint32(header[8:], uint32(next))
+	binary.LittleEndian.PutUint32(header[12:], uint32(count))
+	return header
+}
+
+// rewriteUsage writes a fresh usage file holding the newest samples that fit
+func rewriteUsage(path string, samples []UsageSample, capacity int) error {
+	if over := len(samples) - capacity; over > 0 {
+		samples = samples[over:]
+	}
+	content := encodeUsageHeader(capacity, len(samples)%capacity, len(samples))
+	for _, sample := range samples {
+		content = append(content, encodeUsageRecord(sample)...)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// appendUsage adds a sample to a stack's usage file, overwriting the oldest sample when full.
+// A missing or unreadable file, or one with another capacity, is rewritten first.
+func appendUsage(path string, sample UsageSample, capacity int) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err == nil {
+		header := make([]byte, usageHeaderSize)
+		if _, err = io.ReadFull(f, header); err == nil && string(header[:4]) == usageMagic &&
+			int(binary.LittleEndian.Uint32(header[4:])) == capacity {
+			next := int(binary.LittleEndian.Uint32(header[8:]))
+			count := int(binary.LittleEndian.Uint32(header[12:]))
+			if next < capacity && count <= capacity {
+				defer f.Close()
+				if _, err := f.WriteAt(encodeUsageRecord(sample), int64(usageHeaderSize+next*usageRecordSize)); err != nil {
+					return err
+				}
+				if count < capacity {
+					count++
+				}
+				_, err := f.WriteAt(encodeUsageHeader(capacity, (next+1)%capacity, count), 0)
+				return err
+			}
+		}
+		f.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	samples, _ := readUsage(path)
+	return rewriteUsage(path, append(samples, sample), capacity)
+}
+
+// runningProjects returns the compose projects with running containers
+func runningProjects() ([]string, error) {
+	out, err := engineCommand("ps", "--format", `{{.Label "com.docker.compose.project"}}`).Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker ps failed: %w", err)
+	}
+	seen := make(map[string]bool)
+	var projects []string
+	for _, project := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if project != "" && !seen[project] {
+			seen[project] = true
+			projects = append(projects, project)
+		}
+	}
+	sort.Strings(projects)
+	return projects, nil
+}
+
+// recordUsage samples every running stack once and appends the samples to the usage files
+func recordUsage(capacity int) error {
+	projects, err := runningProjects()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(getUsageDir(), 0755); err != nil {
+		return err
+	}
+	for _, project := range projects {
+		stats, err := collectStackStats(project)
+		if err != nil {
+			stackLog.Debug("Failed to sample usage", "stack", project, "err", err)
+			continue
+		}
+		if err := appendUsage(usagePath(project), usageSampleFromStats(stats), capacity); err != nil {
+			stackLog.Warn("Failed to record usage", "stack", project, "err", err)
+		}
+	}
+	pingHeartbeat("usage")
+	return nil
+}
+
+// HandleCollectUsage records the usage of all running stacks, every --usage-interval with --follow
+func HandleCollectUsage() error {
+	interval, retention := usageDurations()
+	capacity := int(retention / interval)
+	if !getFlagBool("follow", false) {
+		return recordUsage(capacity)
+	}
+	for {
+		start := time.Now()
+		if err := recordUsage(capacity); err != nil {
+			stackLog.Warn(err.Error())
+		}
+		time.Sleep(interval - time.Since(start))
+	}
+}
+
+// downsampleUsage averages samples into at most points buckets of equal duration
+func downsampleUsage(samples []UsageSample, from, to time.Time, points int) []UsageSample {
+	if points <= 0 || len(samples) <= points {
+		return samples
+	}
+	step := to.Sub(from) / time.Duration(points)
+	var result []UsageSample
+	for i := 0; i < len(samples); {
+		bucketEnd := from.Add(step * (time.Duration(samples[i].Time.Sub(from)/step) + 1))
+		var sum UsageSample
+		n := 0
+		for ; i < len(samples) && samples[i].Time.Before(bucketEnd); i++ {
+			sum.CPUPercent += samples[i].CPUPercent
+			sum.MemoryUsage += samples[i].MemoryUsage
+			sum.Containers += samples[i].Containers
+			n++
+		}
+		center := bucketEnd.Add(-step / 2).Truncate(time.Second)
+		if center.After(to) {
+			center = to.Truncate(time.Second)
+		}
+		result = append(result, UsageSample{
+			Time:        center,
+			CPUPercent:  sum.CPUPercent / float64(n),
+			MemoryUsage: sum.MemoryUsage / int64(n),
+			Containers:  int64(math.Round(float64(sum.Containers) / float64(n))),
+		})
+	}
+	return result
+}
+
+// HandleStackUsage prints the usage history of a stack over --range (default 24h) as JSON,
+// averaged into at most --points samples if given
+func HandleStackUsage(stackName string) error {
+	rangeStr := getFlag("range", defaultUsageRange.String())
+	window, err := time.ParseDuration(rangeStr)
+	if err != nil || window <= 0 {
+		return fmt.Errorf("invalid range %q", rangeStr)
+	}
+	samples, err := readUsage(usagePath(stackName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-window)
+	history := UsageHistory{Stack: stackName, Range: window.String(), Samples: []UsageSample{}}
+	for _, sample := range samples {
+		if !sample.Time.Before(from) {
+			history.Samples = append(history.Samples, sample)
+		}
+	}
+	points, _ := strconv.Atoi(getFlag("points", "0"))
+	history.Samples = downsampleUsage(history.Samples, from, to, points)
+	return json.NewEncoder(os.Stdout).Encode(history)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultProfile is the profile used while the config file names none
+const defaultProfile = "default"
+
+// UserConfig is the config file of a dc user, by default ~/.config/dc/config.yml (DC_CONFIG
+// overrides the path). Each profile holds config keys, e.g. dcapi_url, dcapi_token, stacks_dir
+// or proxy_domain, so switching between servers is a single `dc config use-profile <name>`:
+//
+//	current-profile: home
+//	profiles:
+//	  home:
+//	    dcapi_url: https://dc.home.example
+//	    stacks_dir: /srv/stacks
+type UserConfig struct {
+	CurrentProfile string                       `yaml:"current-profile,omitempty"`
+	Profiles       map[string]map[string]string `yaml:"profiles,omitempty"`
+}
+
+var (
+	userConfigOnce sync.Once
+	userConfig     *UserConfig
+)
+
+// userConfigPath returns the path of the config file
+func userConfigPath() string {
+	if path := os.Getenv("DC_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dc", "config.yml")
+}
+
+// readUserConfig reads the config file; a missing file is an empty config
+func readUserConfig() (*UserConfig, error) {
+	config := &UserConfig{Profiles: make(map[string]map[string]string)}
+	path := userConfigPath()
+	if path == "" {
+		return config, nil
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]map[string]string)
+	}
+	return config, nil
+}
+
+// writeUserConfig writes the config file, readable by its owner only as it may hold tokens
+func writeUserConfig(config *UserConfig) error {
+	path := userConfigPath()
+	if path == "" {
+		return fmt.Errorf("no config directory, set DC_CONFIG")
+	}
+	content, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0600)
+}
+
+// profileName returns the selected profile: --profile, the PROFILE env var or the current profile of the file
+func (c *UserConfig) profileName() string {
+	if c.CurrentProfile != "" {
+		return getConfig("profile", c.CurrentProfile)
+	}
+	return getConfig("profile", defaultProfile)
+}
+
+// userConfigValue returns the value of key in the selected profile of the config file
+func userConfigValue(key string) (string, string, bool) {
+	userConfigOnce.Do(func() {
+		config, err := readUserConfig()
+		if err != nil {
+			configLog.Debug("Failed to read config file", "err", err)
+			config = &UserConfig{}
+		}
+		userConfig = config
+	})
+	profile := userConfig.profileName()
+	for k, v := range userConfig.Profiles[profile] {
+		if normalizeConfigKey(k) == key {
+			return v, profile, true
+		}
+	}
+	return "", profile, false
+}
+
+// normalizeConfigKey turns a key given as flag (stacks-dir) or env var (STACKS_DIR) into stacks_dir
+func normalizeConfigKey(key string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimLeft(key, "-"), "-", "_"))
+}
+
+// HandleConfig manages the config file: `dc config get|set|unset <key> [<value>]`,
+// `dc config use-profile <name>` and `dc config profiles`. get, set and unset work on the
+// selected profile, --profile=<name> picks another one.
+func HandleConfig(args []string) error {
+	words := positionalArgs(args)
+	if len(words) == 0 {
+		return fmt.Errorf("Usage: dc config get|set|unset|use-profile|profiles")
+	}
+	config, err := readUserConfig()
+	if err != nil {
+		return err
+	}
+	profile := config.profileName()
+
+	switch words[0] {
+	case "get":
+		if len(words) != 2 {
+			return fmt.Errorf("Usage: dc config get <key> [--profile=<name>]")
+		}
+		key := normalizeConfigKey(words[1])
+		for k, v := range config.Profiles[profile] {
+			if normalizeConfigKey(k) == key {
+				fmt.Println(v)
+				return nil
+			}
+		}
+		return fmt.Errorf("%s is not set in profile %s", key, profile)
+	case "set", "unset":
+		if (words[0] == "set" && len(words) != 3) || (words[0] == "unset" && len(words) != 2) {
+			return fmt.Errorf("Usage: dc config set <key> <value> | unset <key> [--profile=<name>]")
+		}
+		key := normalizeConfigKey(words[1])
+		settings := config.Profiles[profile]
+		if settings == nil {
+			settings = make(map[string]string)
+			config.Profiles[profile] = settings
+		}
+		for k := range settings {
+			if normalizeConfigKey(k) == key {
+				delete(settings, k)
+			}
+		}
+		if words[0] == "set" {
+			settings[key] = words[2]
+		}
+		if config.CurrentProfile == "" {
+			config.CurrentProfile = profile
+		}
+		return writeUserConfig(config)
+	case "use-profile":
+		if len(words) != 2 {
+			return fmt.Errorf("Usage: dc config use-profile <name>")
+		}
+		if _, ok := config.Profiles[words[1]]; !ok {
+			return fmt.Errorf("profile %s not found, create it with dc config set <key> <value> --profile=%s", words[1], words[1])
+		}
+		config.CurrentProfile = words[1]
+		return writeUserConfig(config)
+	case "profiles", "ls":
+		type profileInfo struct {
+			Name    string `json:"name"`
+			Current bool   `json:"current"`
+		}
+		profiles := []profileInfo{}
+		for name := range config.Profiles {
+			profiles = append(profiles, profileInfo{Name: name, Current: name == profile})
+		}
+		sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+		return json.NewEncoder(os.Stdout).Encode(profiles)
+	default:
+		return fmt.Errorf("unknown config command %q", words[0])
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"sort"
 	"strings"
 
+	"dc/internal/compose"
 	"gopkg.in/yaml.v3"
 )
 
@@ -97,7 +98,7 @@ func sortMappingNode(node *yaml.Node) {
 
 // encodeYAMLWithMultiline encodes a value to YAML with multiline strings properly formatted
 // and preserves the order of map entries
-func encodeYAMLWithMultiline(buf *strings.Builder, value *ComposeFile) error {
+func encodeYAMLWithMultiline(buf *strings.Builder, value *compose.File) error {
 	// First, marshal to a YAML node
 	var node yaml.Node
 	if err := node.Encode(value); err != nil {
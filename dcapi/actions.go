@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultActionRetain is how many actions are kept per stack (config key action_retain)
+const defaultActionRetain = 50
+
+// composeActions are the dc stack commands whose outcome is recorded in the action history
+var composeActions = map[string]bool{
+	"up": true, "down": true, "start": true, "stop": true, "create": true,
+	"update": true, "rollback": true, "restore": true,
+}
+
+// StackAction is the outcome of a compose action on a stack, as listed by
+// GET /api/stacks/{name}/actions
+type StackAction struct {
+	Time       time.Time `json:"time"`
+	Principal  string    `json:"principal,omitempty"`
+	Action     string    `json:"action"` // e.g. "up"
+	DurationMS int64     `json:"durationMs"`
+	ExitCode   int       `json:"exitCode"`
+	Error      string    `json:"error,omitempty"` // the first error line of a failed action
+}
+
+// actionStacks returns the stacks a dc command acts on if it is a compose action: the
+// arguments after the action up to the first flag
+func actionStacks(args []string) []string {
+	if len(args) < 3 || args[0] != "stack" || !composeActions[args[1]] {
+		return nil
+	}
+	var stacks []string
+	for _, arg := range args[2:] {
+		if strings.HasPrefix(arg, "-") {
+			break
+		}
+		stacks = append(stacks, arg)
+	}
+	return stacks
+}
+
+// argValue returns the value of a --name=value flag of a dc command
+func argValue(args []string, name string) (string, bool) {
+	for _, arg := range args {
+		if value, ok := strings.CutPrefix(arg, "--"+name+"="); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// firstErrorLine returns the first line of output that reports an error, else the last line.
+// Debug messages, which dc prints when it fails, are skipped.
+func firstErrorLine(output []byte) string {
+	var last string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "[DEBUG]") {
+			continue
+		}
+		if strings.Contains(strings.ToLower(line), "error") {
+			return line
+		}
+		last = line
+	}
+	return last
+}
+
+// recordStackActions adds the outcome of a compose action to the history of each stack it acted
+// on. Dry runs change nothing and are left out.
+func recordStackActions(args []string, start time.Time, output []byte, exitCode int) {
+	stacks := actionStacks(args)
+	if len(stacks) == 0 {
+		return
+	}
+	if dryRun, _ := argValue(args, "dry-run"); dryRun == "true" {
+		return
+	}
+	action := StackAction{
+		Time:       start.UTC(),
+		Action:     args[1],
+		DurationMS: time.Since(start).Milliseconds(),
+		ExitCode:   exitCode,
+	}
+	action.Principal, _ = argValue(args, "actor")
+	if exitCode != 0 {
+		action.Error = redactText(firstErrorLine(output))
+	}
+	for _, stack := range stacks {
+		recordStackAction(stack, action)
+	}
+}
+
+// recordStackAction appends an action to the history of a stack and drops its oldest actions
+// beyond action_retain
+func recordStackAction(stack string, action StackAction) {
+	suffix, err := randomHex(4)
+	if err != nil {
+		jobsLog.Error("Error recording stack action", "stack", stack, "err", err)
+		return
+	}
+	prefix := stack + "/"
+	key := fmt.Sprintf("%s%020d-%s", prefix, action.Time.UnixNano(), suffix)
+	if err := stateStore().Put(bucketActions, key, action); err != nil {
+		jobsLog.Error("Error recording stack action", "stack", stack, "err", err)
+		return
+	}
+
+	retain := streamConfigInt("action_retain", defaultActionRetain)
+	var keys []string
+	stateStore().ForEach(bucketActions, func(key string, value json.RawMessage) error {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if len(keys) > retain {
+		if err := stateStore().Delete(bucketActions, keys[:len(keys)-retain]...); err != nil {
+			jobsLog.Error("Error trimming stack actions", "stack", stack, "err", err)
+		}
+	}
+}
+
+// handleStackActions handles GET /api/stacks/{stack}/actions: the recorded actions of a stack,
+// newest first, limited to ?limit entries (default 20)
+func handleStackActions(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+	prefix := r.PathValue("stack") + "/"
+	actions := []StackAction{}
+	err = stateStore().ForEach(bucketActions, func(key string, value json.RawMessage) error {
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		var action StackAction
+		if err := json.Unmarshal(value, &action); err != nil {
+			return err
+		}
+		actions = append(actions, action)
+		return nil
+	})
+	if err != nil {
+		jobsLog.Error("Error reading stack actions", "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+	for i, j := 0, len(actions)-1; i < j; i, j = i+1, j-1 {
+		actions[i], actions[j] = actions[j], actions[i]
+	}
+	if len(actions) > limit {
+		actions = actions[:limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(actions)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAssetHosts are the hosts icons may be fetched from (config key asset_hosts)
+	defaultAssetHosts = "cdn.jsdelivr.net,raw.githubusercontent.com,www.gravatar.com,gravatar.com"
+
+	// defaultAssetCacheMaxBytes bounds the size of the asset cache (config key asset_cache_max_bytes)
+	defaultAssetCacheMaxBytes = 64 << 20
+
+	// defaultAssetCacheTTL is how long a cached asset is served before it is revalidated
+	defaultAssetCacheTTL = 7 * 24 * time.Hour
+)
+
+// assetExtensions maps served asset extensions; anything else is stored as .img
+var assetExtensions = map[string]bool{".svg": true, ".png": true, ".jpg": true, ".jpeg": true, ".ico": true, ".webp": true, ".gif": true}
+
+// assetLastUsed records when each cached file was last served; files not served since
+// startup fall back to their modification time
+var assetLastUsed = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: map[string]time.Time{}}
+
+// getAssetCacheDir returns the directory holding thumbnails and cached icon assets
+func getAssetCacheDir() string {
+	return getConfig("asset_cache_dir", "thumbnails")
+}
+
+// assetCacheTTL returns the configured revalidation interval (e.g. ASSET_CACHE_TTL=72h)
+func assetCacheTTL() time.Duration {
+	if ttl, err := time.ParseDuration(getConfig("asset_cache_ttl", "")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultAssetCacheTTL
+}
+
+// isAllowedAssetHost reports whether remote assets may be fetched from host
+func isAllowedAssetHost(host string) bool {
+	for _, allowed := range strings.Split(getConfig("asset_hosts", defaultAssetHosts), ",") {
+		if strings.EqualFold(strings.TrimSpace(allowed), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheAsset returns the local path of the cached copy of a remote asset, fetching it when missing
+// or older than the TTL. When the fetch fails a stale copy is served, so that dashboards keep their
+// icons while the internet is down.
+func cacheAsset(remoteURL string) (string, error) {
+	u, err := url.Parse(remoteURL)
+	if err != nil || u.Scheme != "https" || !isAllowedAssetHost(u.Hostname()) {
+		return "", fmt.Errorf("asset URL %q is not allowed (https only, hosts: %s)", remoteURL, getConfig("asset_hosts", defaultAssetHosts))
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	if !assetExtensions[ext] {
+		ext = ".img"
+	}
+	cachePath := filepath.Join(getAssetCacheDir(), generateSafeFilename(remoteURL)+ext)
+
+	info, statErr := os.Stat(cachePath)
+	if statErr == nil && time.Since(info.ModTime()) < assetCacheTTL() {
+		touchAsset(cachePath)
+		return cachePath, nil
+	}
+
+	if err := os.MkdirAll(getAssetCacheDir(), 0755); err != nil {
+		return "", err
+	}
+	if err := downloadImage(remoteURL, cachePath); err != nil {
+		if statErr == nil {
+			assetsLog.Warn("Failed to refresh asset, serving cached copy", "url", remoteURL, "err", err)
+			touchAsset(cachePath)
+			return cachePath, nil
+		}
+		os.Remove(cachePath)
+		return "", err
+	}
+	evictAssets()
+	return cachePath, nil
+}
+
+// touchAsset marks a cached file as recently used for eviction purposes
+func touchAsset(cachePath string) {
+	assetLastUsed.Lock()
+	assetLastUsed.at[cachePath] = time.Now()
+	assetLastUsed.Unlock()
+}
+
+// evictAssets removes the least recently used files until the cache fits asset_cache_max_bytes
+func evictAssets() {
+	maxBytes, err := strconv.ParseInt(getConfig("asset_cache_max_bytes", ""), 10, 64)
+	if err != nil || maxBytes <= 0 {
+		maxBytes = defaultAssetCacheMaxBytes
+	}
+
+	assetLastUsed.Lock()
+	defer assetLastUsed.Unlock()
+
+	entries, err := os.ReadDir(getAssetCacheDir())
+	if err != nil {
+		return
+	}
+	type cachedFile struct {
+		path     string
+		size     int64
+		lastUsed time.Time
+	}
+	var files []cachedFile
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		f := cachedFile{filepath.Join(getAssetCacheDir(), entry.Name()), info.Size(), info.ModTime()}
+		if at, ok := assetLastUsed.at[f.path]; ok {
+			f.lastUsed = at
+		}
+		files = append(files, f)
+		total += info.Size()
+	}
+	if total <= maxBytes {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].lastUsed.Before(files[j].lastUsed) })
+	for _, f := range files {
+		if total <= maxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err == nil {
+			delete(assetLastUsed.at, f.path)
+			total -= f.size
+			assetsLog.Info("Evicted cached asset", "path", f.path)
+		}
+	}
+}
+
+// HandleAsset serves a remote icon asset through the local cache
+// GET /api/assets?url=https://cdn.jsdelivr.net/gh/walkxcode/dashboard-icons/svg/nginx.svg
+func HandleAsset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	remoteURL := r.URL.Query().Get("url")
+	if remoteURL == "" {
+		httpError(w, r, "url_param_required", http.StatusBadRequest)
+		return
+	}
+	cachePath, err := cacheAsset(remoteURL)
+	if err != nil {
+		assetsLog.Error("Error caching asset", "url", remoteURL, "err", err)
+		httpError(w, r, "asset_fetch_failed", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, cachePath)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultAuditMax is how many audit entries are kept (config key audit_max)
+const defaultAuditMax = 1000
+
+// AuditEntry records a request that changed something, as listed by GET /api/audit
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal"`
+	Kind      string    `json:"kind"` // user, token or service-account
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Remote    string    `json:"remote,omitempty"` // client address, see clientIP
+}
+
+// principalKind tells users, personal access tokens and service accounts apart
+func principalKind(p *Principal) string {
+	switch {
+	case p.Token != nil:
+		return "token"
+	case p.ServiceAccount != nil:
+		return "service-account"
+	default:
+		return "user"
+	}
+}
+
+// recordAudit appends an entry to the audit log and drops the oldest entries beyond audit_max
+func recordAudit(entry AuditEntry) {
+	suffix, err := randomHex(4)
+	if err != nil {
+		auditLog.Error("Error recording audit entry", "err", err)
+		return
+	}
+	key := fmt.Sprintf("%020d-%s", entry.Time.UnixNano(), suffix)
+	if err := stateStore().Put(bucketAudit, key, entry); err != nil {
+		auditLog.Error("Error recording audit entry", "err", err)
+		return
+	}
+
+	max, err := strconv.Atoi(getConfig("audit_max", ""))
+	if err != nil || max <= 0 {
+		max = defaultAuditMax
+	}
+	var keys []string
+	stateStore().ForEach(bucketAudit, func(key string, value json.RawMessage) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if len(keys) > max {
+		if err := stateStore().Delete(bucketAudit, keys[:len(keys)-max]...); err != nil {
+			auditLog.Error("Error trimming audit log", "err", err)
+		}
+	}
+}
+
+// audited records every authenticated request that isn't read-only in the audit log
+func audited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := principalFromRequest(r)
+		if principal == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		recordAudit(AuditEntry{
+			Time:      time.Now().UTC(),
+			Principal: principal.Name,
+			Kind:      principalKind(principal),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    rec.status,
+			Remote:    clientIP(r),
+		})
+	}
+}
+
+// HandleAuditAPI lists the audit log, newest first, limited to ?limit entries (default 100).
+// Only interactive users may read it.
+func HandleAuditAPI(w http.ResponseWriter, r *http.Request) {
+	principal := principalFromRequest(r)
+	if principal == nil || !principal.Interactive() {
+		httpError(w, r, "forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+
+	entries := []AuditEntry{}
+	err = stateStore().ForEach(bucketAudit, func(key string, value json.RawMessage) error {
+		var entry AuditEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		auditLog.Error("Error reading audit log", "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
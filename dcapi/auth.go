@@ -4,8 +4,8 @@ import (
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -15,7 +15,8 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// SessionStore holds active sessions in memory
+// SessionStore holds active sessions in memory, backed by the state store so that sessions
+// survive a restart of dcapi. Sessions are keyed by the SHA-256 of their token.
 type SessionStore struct {
 	mu       sync.RWMutex
 	sessions map[string]*SessionInfo
@@ -26,8 +27,15 @@ type SessionInfo struct {
 	Username  string    `json:"username"`
 	ExpiresAt time.Time `json:"expires_at"`
 	CreatedAt time.Time `json:"created_at"`
+
+	// persistedExpiry is the expiry last written to the state store
+	persistedExpiry time.Time
 }
 
+// sessionPersistInterval is how far a renewal must extend a session before it is written to
+// the state store; sessions are renewed on every request, which is too often for the file
+const sessionPersistInterval = 10 * time.Minute
+
 // Claims represents JWT claims
 type Claims struct {
 	Username string `json:"username"`
@@ -39,18 +47,51 @@ var sessionStore = &SessionStore{
 	sessions: make(map[string]*SessionInfo),
 }
 
+// Restore loads the unexpired sessions from the state store
+func (s *SessionStore) Restore() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	err := stateStore().ForEach(bucketSessions, func(key string, value json.RawMessage) error {
+		var info SessionInfo
+		if err := json.Unmarshal(value, &info); err != nil {
+			return err
+		}
+		if now.Before(info.ExpiresAt) {
+			info.persistedExpiry = info.ExpiresAt
+			s.sessions[key] = &info
+		}
+		return nil
+	})
+	if err != nil {
+		authLog.Error("Error restoring sessions", "err", err)
+		return
+	}
+	authLog.Info("Restored sessions", "sessions", len(s.sessions))
+}
+
+// persistSession writes a session to the state store
+func persistSession(key string, info *SessionInfo) {
+	info.persistedExpiry = info.ExpiresAt
+	if err := stateStore().Put(bucketSessions, key, info); err != nil {
+		authLog.Error("Error saving session", "err", err)
+	}
+}
+
 // AddSession adds a new session to the store
 func (s *SessionStore) AddSession(token string, info *SessionInfo) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.sessions[token] = info
+	key := hashToken(token)
+	s.sessions[key] = info
+	persistSession(key, info)
 }
 
 // GetSession retrieves a session from the store
 func (s *SessionStore) GetSession(token string) (*SessionInfo, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	info, exists := s.sessions[token]
+	info, exists := s.sessions[hashToken(token)]
 	return info, exists
 }
 
@@ -58,15 +99,23 @@ func (s *SessionStore) GetSession(token string) (*SessionInfo, bool) {
 func (s *SessionStore) RemoveSession(token string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.sessions, token)
+	key := hashToken(token)
+	delete(s.sessions, key)
+	if err := stateStore().Delete(bucketSessions, key); err != nil {
+		authLog.Error("Error removing session", "err", err)
+	}
 }
 
 // RenewSession extends the expiration time of an existing session
 func (s *SessionStore) RenewSession(token string, newExpiresAt time.Time) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if info, exists := s.sessions[token]; exists {
+	key := hashToken(token)
+	if info, exists := s.sessions[key]; exists {
 		info.ExpiresAt = newExpiresAt
+		if newExpiresAt.Sub(info.persistedExpiry) >= sessionPersistInterval {
+			persistSession(key, info)
+		}
 	}
 }
 
@@ -76,9 +125,23 @@ func (s *SessionStore) CleanupExpiredSessions() {
 	defer s.mu.Unlock()
 
 	now := time.Now()
-	for token, info := range s.sessions {
+	var expired []string
+	for key, info := range s.sessions {
 		if now.After(info.ExpiresAt) {
-			delete(s.sessions, token)
+			delete(s.sessions, key)
+			expired = append(expired, key)
+		}
+	}
+	// sessions that expired while dcapi was down were never restored
+	stateStore().ForEach(bucketSessions, func(key string, value json.RawMessage) error {
+		if _, active := s.sessions[key]; !active {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if len(expired) > 0 {
+		if err := stateStore().Delete(bucketSessions, expired...); err != nil {
+			authLog.Error("Error removing expired sessions", "err", err)
 		}
 	}
 }
@@ -108,7 +171,7 @@ func isAuthDisabled() bool {
 func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	// If auth is disabled globally, return a static token and create a long-lived session
 	if isAuthDisabled() {
-		log.Println("AUTH_DISABLED is set; skipping login authentication")
+		authLog.Debug("AUTH_DISABLED is set; skipping login authentication")
 		// Use a fixed token value so clients can send any token or this one. Store it so middleware can find it.
 		const disabledToken = "AUTH_DISABLED"
 		expiresAt := time.Now().Add(100 * 365 * 24 * time.Hour) // very long lived
@@ -124,7 +187,7 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 
 	// Only accept POST requests
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -132,7 +195,7 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	username, password, ok := r.BasicAuth()
 	if !ok {
 		w.Header().Set("WWW-Authenticate", `Basic realm="dc - Login"`)
-		http.Error(w, "Basic authentication required", http.StatusUnauthorized)
+		httpError(w, r, "basic_auth_required", http.StatusUnauthorized)
 		return
 	}
 
@@ -151,8 +214,9 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword)) == 1
 
 	if !usernameMatch || !passwordMatch {
+		authLog.Warn("Failed login", "user", username, "remote", clientIP(r))
 		w.Header().Set("WWW-Authenticate", `Basic realm="dc - Login"`)
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		httpError(w, r, "invalid_credentials", http.StatusUnauthorized)
 		return
 	}
 
@@ -172,12 +236,12 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString([]byte(secretKey))
 	if err != nil {
-		log.Printf("Error signing token: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		authLog.Error("Error signing token", "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
 		return
 	}
 
-	// Store session in memory
+	// Store session
 	sessionStore.AddSession(tokenString, &SessionInfo{
 		Username:  username,
 		ExpiresAt: expiresAt,
@@ -190,28 +254,28 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 func HandleLogout(w http.ResponseWriter, r *http.Request) {
 	// If auth is disabled, just return success (noop)
 	if isAuthDisabled() {
-		log.Println("AUTH_DISABLED is set; skipping logout")
+		authLog.Debug("AUTH_DISABLED is set; skipping logout")
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
 	// Only accept POST requests
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	// Get Authorization header
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, "Authorization header required", http.StatusUnauthorized)
+		httpError(w, r, "auth_header_required", http.StatusUnauthorized)
 		return
 	}
 
 	// Expect "Bearer <token>"
 	const prefix = "Bearer "
 	if !strings.HasPrefix(authHeader, prefix) {
-		http.Error(w, "Invalid authorization format", http.StatusUnauthorized)
+		httpError(w, r, "invalid_auth_format", http.StatusUnauthorized)
 		return
 	}
 
@@ -223,7 +287,7 @@ func HandleLogout(w http.ResponseWriter, r *http.Request) {
 		return []byte(secretKey), nil
 	})
 	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		httpError(w, r, "invalid_token", http.StatusUnauthorized)
 		return
 	}
 
@@ -281,36 +345,68 @@ func validateBearerToken(tokenString string) (*Claims, error) {
 }
 
 func JwtAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	next = audited(next)
 	return func(w http.ResponseWriter, r *http.Request) {
 		// If auth is disabled, skip auth checks entirely
 		if isAuthDisabled() {
 			// Allow the request through
-			next(w, r)
+			next(w, withPrincipal(r, &Principal{Name: getConfig("admin_username", "admin")}))
 			return
 		}
 
 		// Only accept Bearer token (no Basic Auth fallback)
 		authHeader := r.Header.Get("Authorization")
 		if !strings.HasPrefix(authHeader, "Bearer ") {
-			log.Printf("Missing or invalid Authorization header")
+			authLog.Debug("Missing or invalid Authorization header")
 			w.Header().Set("WWW-Authenticate", `Bearer realm="dcapi"`)
 			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("401 Unauthorized\n"))
+			w.Write([]byte(msg(r, "unauthorized") + "\n"))
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
 		// Validate bearer token (also renews session)
-		_, err := validateBearerToken(tokenString)
-		if err != nil {
-			log.Printf("Bearer token validation failed: %v", err)
+		claims, err := validateBearerToken(tokenString)
+		if err == nil {
+			next(w, withPrincipal(r, &Principal{Name: claims.Username}))
+			return
+		}
+
+		// Personal access tokens are restricted to their scopes
+		if strings.HasPrefix(tokenString, apiTokenPrefix) {
+			apiToken, tokenErr := findAPIToken(tokenString)
+			if tokenErr != nil {
+				authLog.Warn("API token validation failed", "err", tokenErr)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="dcapi"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(msg(r, "unauthorized") + "\n"))
+				return
+			}
+			if !apiToken.Allows(r.Method, r.URL.Path) && r.URL.Path != capabilitiesPath {
+				authLog.Warn("API token denied", "token", apiToken.ID, "owner", apiToken.Owner, "method", r.Method, "path", r.URL.Path)
+				httpError(w, r, "forbidden", http.StatusForbidden)
+				return
+			}
+			next(w, withPrincipal(r, &Principal{Name: apiToken.Owner, Token: apiToken}))
+			return
+		}
+
+		// Fall back to service account tokens, which are restricted to their rules
+		account, saErr := findServiceAccount(tokenString)
+		if saErr != nil {
+			authLog.Debug("Bearer token validation failed", "err", err)
 			w.Header().Set("WWW-Authenticate", `Bearer realm="dcapi"`)
 			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("401 Unauthorized\n"))
+			w.Write([]byte(msg(r, "unauthorized") + "\n"))
+			return
+		}
+		if !account.Allows(r.Method, r.URL.Path) && r.URL.Path != capabilitiesPath {
+			authLog.Warn("Service account denied", "account", account.Name, "method", r.Method, "path", r.URL.Path)
+			httpError(w, r, "forbidden", http.StatusForbidden)
 			return
 		}
-		next(w, r)
+		next(w, withPrincipal(r, &Principal{Name: account.Name, ServiceAccount: account}))
 	}
 }
 
@@ -319,7 +415,7 @@ func SessionCleanup() {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		log.Println("Cleaning up expired sessions...")
+		authLog.Debug("Cleaning up expired sessions")
 		sessionStore.CleanupExpiredSessions()
 	}
 }
@@ -342,18 +438,18 @@ func getConfig(key string, defaultValue string) string {
 		argFlagDouble := "--" + keyFlag
 
 		if (arg == argFlag || arg == argFlagDouble) && i+1 < len(args) {
-			log.Printf("Loaded %s from program arguments: %s", keyUpper, args[i+1])
+			configLog.Info("Loaded from program arguments", "key", keyUpper, "value", args[i+1])
 			return args[i+1]
 		}
 		// Handle --key=value format
 		if strings.HasPrefix(arg, argFlagDouble+"=") {
 			value := strings.TrimPrefix(arg, argFlagDouble+"=")
-			log.Printf("Loaded %s from program arguments: %s", keyUpper, value)
+			configLog.Info("Loaded from program arguments", "key", keyUpper, "value", value)
 			return value
 		}
 		if strings.HasPrefix(arg, argFlag+"=") {
 			value := strings.TrimPrefix(arg, argFlag+"=")
-			log.Printf("Loaded %s from program arguments: %s", keyUpper, value)
+			configLog.Info("Loaded from program arguments", "key", keyUpper, "value", value)
 			return value
 		}
 	}
@@ -362,10 +458,10 @@ func getConfig(key string, defaultValue string) string {
 	fileEnvVar := keyUpper + "_FILE"
 	if configFile := os.Getenv(fileEnvVar); configFile != "" {
 		if content, err := readSecretFile(configFile); err == nil {
-			log.Printf("Loaded %s from file: %s", keyUpper, configFile)
+			configLog.Info("Loaded from file", "key", keyUpper, "file", configFile)
 			return content
 		} else {
-			log.Printf("Warning: Failed to read %s (%s): %v", fileEnvVar, configFile, err)
+			configLog.Warn("Failed to read", "variable", fileEnvVar, "file", configFile, "err", err)
 		}
 	}
 
@@ -382,7 +478,7 @@ func getConfig(key string, defaultValue string) string {
 	}
 	for _, secretPath := range secretPaths {
 		if content, err := readSecretFile(secretPath); err == nil {
-			log.Printf("Loaded %s from Docker secrets: %s", keyUpper, secretPath)
+			configLog.Info("Loaded from Docker secrets", "key", keyUpper, "path", secretPath)
 			return content
 		}
 	}
@@ -397,38 +493,51 @@ func getConfig(key string, defaultValue string) string {
 // 3. Check SECRET_KEY env var
 // 4. Check prod.env file (case insensitive)
 // 5. Check default Docker secrets location (/run/secrets/SECRET_KEY - case insensitive)
-// 6. Generate and save a new random secret key to prod.env
+// 6. Generate a new random secret key and keep it in the state store
 func GetSecretKey(args []string) string {
 	secretKey := getConfig("secret_key", "")
 
-	// If no secret key found, generate one and save it to prod.env
+	// If no secret key found, use the generated one from the state store
 	if secretKey == "" {
 		var err error
 		secretKey, err = generateAndSaveSecretKey()
 		if err != nil {
-			log.Fatalf("Failed to generate secret key: %v", err)
+			authLog.Error("Failed to generate secret key", "err", err)
+			os.Exit(1)
 		}
 	}
 
 	return secretKey
 }
 
-// generateAndSaveSecretKey generates a new random secret key and saves it to prod.env
+// generateAndSaveSecretKey returns the generated secret key from the state store, generating
+// it on first use, so that tokens stay valid across restarts
 func generateAndSaveSecretKey() (string, error) {
 	secretKey := getConfig("auth_secret_key", "")
 	if secretKey != "" {
 		return secretKey, nil
 	}
+	secretKeyMu.Lock()
+	defer secretKeyMu.Unlock()
+	if ok, err := stateStore().Get(bucketSettings, "secret_key", &secretKey); err == nil && ok {
+		return secretKey, nil
+	}
 	// Generate a 64-character random secret key
 	secretKey, err := generateURLSafePassword(64)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate secret key: %w", err)
 	}
+	if err := stateStore().Put(bucketSettings, "secret_key", secretKey); err != nil {
+		authLog.Error("Error saving generated secret key", "err", err)
+	}
 
-	fmt.Errorf("Using generated secret key. %s", secretKey)
+	authLog.Info("Using generated secret key")
 	return secretKey, nil
 }
 
+// secretKeyMu keeps concurrent first requests from generating different keys
+var secretKeyMu sync.Mutex
+
 // generateURLSafePassword generates a cryptographically secure URL-safe random password
 func generateURLSafePassword(length int) (string, error) {
 	// Generate random bytes (we need more bytes than the final length due to base64 encoding)
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// RunAutostart runs `dc boot` once when dcapi starts with autostart enabled (--autostart=true
+// or AUTOSTART=true), bringing up the stacks marked autostart after a host reboot
+func RunAutostart() {
+	if !strings.EqualFold(getConfig("autostart", "false"), "true") {
+		return
+	}
+	cmd := exec.Command("dc", "boot")
+	stderr, err := cmd.StderrPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		autostartLog.Error("Error starting autostart", "err", err)
+		return
+	}
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		autostartLog.Info(scanner.Text())
+	}
+	if err := cmd.Wait(); err != nil {
+		autostartLog.Warn("Autostart finished with failures", "err", err)
+	} else {
+		autostartLog.Info("Autostart finished")
+	}
+}
+
+// HandleBootStatus handles GET /api/boot: the outcome of the last `dc boot`
+func HandleBootStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	HandleAction(w, "dc", "boot", "status")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// bulkActionPaths maps the actions of POST /api/stacks/_bulk to the per-stack endpoint a caller
+// must be allowed to use for every selected stack
+var bulkActionPaths = map[string]string{
+	"start":  "start",
+	"stop":   "stop",
+	"up":     "up",
+	"down":   "down",
+	"update": "up",
+}
+
+var (
+	stackNamePattern     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	labelSelectorPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*(=[A-Za-z0-9_.-]*)?(,[A-Za-z0-9][A-Za-z0-9_.-]*(=[A-Za-z0-9_.-]*)?)*$`)
+)
+
+// BulkRequest is the body of POST /api/stacks/_bulk. Exactly one of Names, Selector
+// (x-composectl labels, e.g. "tier=backend"), Group or All selects the stacks.
+type BulkRequest struct {
+	Action   string   `json:"action"`
+	Names    []string `json:"names,omitempty"`
+	Selector string   `json:"selector,omitempty"`
+	Group    string   `json:"group,omitempty"`
+	All      bool     `json:"all,omitempty"`
+	Parallel int      `json:"parallel,omitempty"`
+}
+
+// HandleBulkStacks handles POST /api/stacks/_bulk: runs start, stop, up, down or update (up with
+// pull) for several stacks concurrently through `dc stack <action> ...`. With ?stream=true the
+// output of all stacks is streamed, each frame tagged with its stack.
+func HandleBulkStacks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "invalid_json", http.StatusBadRequest, err)
+		return
+	}
+	actionPath, ok := bulkActionPaths[req.Action]
+	selectors := 0
+	for _, set := range []bool{len(req.Names) > 0, req.Selector != "", req.Group != "", req.All} {
+		if set {
+			selectors++
+		}
+	}
+	if !ok || selectors != 1 || (req.Selector != "" && !labelSelectorPattern.MatchString(req.Selector)) ||
+		(req.Group != "" && !stackNamePattern.MatchString(req.Group)) {
+		httpError(w, r, "bulk_request_invalid", http.StatusBadRequest)
+		return
+	}
+	for _, name := range req.Names {
+		if !stackNamePattern.MatchString(name) {
+			httpError(w, r, "bulk_request_invalid", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The caller must be allowed the action on each named stack, or on any stack for a selection
+	targets := req.Names
+	if len(targets) == 0 {
+		targets = []string{"*"}
+	}
+	if principal := principalFromRequest(r); principal != nil {
+		for _, name := range targets {
+			if !principal.Allows(http.MethodPost, "/api/stacks/"+name+"/"+actionPath) {
+				httpError(w, r, "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	args := append([]string{"stack", req.Action}, req.Names...)
+	if req.Selector != "" {
+		args = append(args, "--label="+req.Selector)
+	}
+	if req.Group != "" {
+		args = append(args, "--group="+req.Group)
+	}
+	if req.All {
+		args = append(args, "--all")
+	}
+	if req.Parallel > 0 {
+		args = append(args, "--parallel="+strconv.Itoa(req.Parallel))
+	}
+	handleMaybeStreamed(w, r, "_bulk", append(args, mutationFlags(r, nil)...))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// defaultCancelGrace is how long a cancelled command may take to stop after SIGTERM before it
+// is killed (config key cancel_grace)
+const defaultCancelGrace = 10 * time.Second
+
+func cancelGrace() time.Duration {
+	if d, err := time.ParseDuration(getConfig("cancel_grace", "")); err == nil && d > 0 {
+		return d
+	}
+	return defaultCancelGrace
+}
+
+// terminateProcessGroup stops cmd and its process group: SIGTERM to dc, which passes it on to
+// docker compose so that it can finish cleanly, and SIGKILL to the whole group if dc is still
+// running after the grace period
+func terminateProcessGroup(cmd *exec.Cmd, exited <-chan struct{}) {
+	select {
+	case <-exited:
+		return // the process group may already be gone
+	default:
+	}
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		jobsLog.Error("Error terminating process", "pid", cmd.Process.Pid, "err", err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(cancelGrace()):
+		jobsLog.Warn("Process did not stop in time, killing it", "pid", cmd.Process.Pid, "grace", cancelGrace())
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+			jobsLog.Error("Error killing process", "pid", cmd.Process.Pid, "err", err)
+		}
+	}
+}
+
+// runCancellable runs a command in its own process group and terminates the group once ctx is done
+func runCancellable(ctx context.Context, cmd *exec.Cmd) error {
+	// Own process group, so that killing also ends docker compose started by dc
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			terminateProcessGroup(cmd, exited)
+		case <-exited:
+		}
+	}()
+	err := cmd.Wait()
+	close(exited)
+	return err
+}
+
+// HandleRequestAction runs a dc action like HandleAction, but stops it when the client goes
+// away; without anyone waiting for the result it would only keep running unobserved
+func HandleRequestAction(w http.ResponseWriter, r *http.Request, args ...string) {
+	cmd := exec.Command("dc", args...)
+	cmd.Env = commandEnv(r)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	start := time.Now()
+	err := runCancellable(r.Context(), cmd)
+	observeCommand(args, start, out.Bytes(), err)
+	if r.Context().Err() != nil {
+		jobsLog.Info("Client went away, cancelled action", "action", jobAction(args))
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "command_failed", redactText(out.String()))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write(out.Bytes())
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// capabilitiesPath may be requested by every authenticated caller, whatever its scopes or rules
+const capabilitiesPath = "/api/capabilities"
+
+// capability is a UI action and the request that performs it. In stack capabilities {stack}
+// is replaced by the stack name; {name} stands for any name chosen by the user.
+type capability struct {
+	Action      string
+	Method      string
+	Path        string
+	Interactive bool // only logged in users, never tokens or service accounts
+}
+
+// globalCapabilities are the actions that don't belong to a stack
+var globalCapabilities = []capability{
+	{"stacks:list", http.MethodGet, "/api/stacks", false},
+	{"stacks:import", http.MethodPost, "/api/stacks/import", false},
+	{"stacks:import-bundle", http.MethodPost, "/api/stacks/import-bundle", false},
+	{"stacks:adopt", http.MethodPost, "/api/stacks/{name}/adopt", false},
+	{"stacks:bulk", http.MethodPost, "/api/stacks/_bulk", false},
+	{"summary", http.MethodGet, "/api/summary", false},
+	{"capacity", http.MethodGet, "/api/capacity", false},
+	{"graph", http.MethodGet, "/api/graph", false},
+	{"boot", http.MethodGet, "/api/boot", false},
+	{"drift", http.MethodGet, "/api/drift", false},
+	{"jobs", http.MethodGet, "/api/jobs", false},
+	{"jobs:cancel", http.MethodDelete, "/api/jobs/{name}", false},
+	{"events", http.MethodGet, "/api/events", false},
+	{"transform", http.MethodPost, "/api/transform", false},
+	{"convert", http.MethodPost, "/api/convert", false},
+	{"lint", http.MethodPost, "/api/lint", false},
+	{"secrets:read", http.MethodGet, "/api/secrets", false},
+	{"secrets:create", http.MethodPost, "/api/secrets", false},
+	{"secrets:write", http.MethodPut, "/api/secrets/{name}", false},
+	{"secrets:delete", http.MethodDelete, "/api/secrets/{name}", false},
+	{"secrets:rotate", http.MethodPost, "/api/secrets/{name}/rotate", false},
+	{"tokens:manage", http.MethodPost, "/api/tokens", true},
+	{"notifications:manage", http.MethodPost, "/api/notifications", true},
+	{"settings:dns", http.MethodPut, "/api/settings/dns", true},
+	{"settings:logging", http.MethodPut, "/api/settings/logging", true},
+	{"audit", http.MethodGet, "/api/audit", true},
+	{"networks:list", http.MethodGet, "/api/networks", false},
+	{"networks:create", http.MethodPost, "/api/networks", false},
+	{"networks:prune", http.MethodPost, "/api/networks/prune", false},
+	{"networks:delete", http.MethodDelete, "/api/networks/{name}", false},
+	{"volumes:list", http.MethodGet, "/api/volumes", false},
+	{"volumes:create", http.MethodPost, "/api/volumes", false},
+	{"volumes:prune", http.MethodPost, "/api/volumes/prune", false},
+	{"volumes:delete", http.MethodDelete, "/api/volumes/{name}", false},
+	{"system:df", http.MethodGet, "/api/system/df", false},
+	{"system:health", http.MethodGet, "/api/system/health", false},
+	{"system:prune", http.MethodPost, "/api/system/prune", false},
+	{"containers:list", http.MethodGet, "/api/containers", false},
+	{"containers:inspect", http.MethodGet, "/api/containers/{name}", false},
+	{"containers:exec", http.MethodGet, "/api/containers/{name}/exec", true},
+}
+
+// stackCapabilities are the actions on a single stack
+var stackCapabilities = []capability{
+	{"view", http.MethodGet, "/api/stacks/{stack}", false},
+	{"save", http.MethodPut, "/api/stacks/{stack}", false},
+	{"delete", http.MethodDelete, "/api/stacks/{stack}", false},
+	{"up", http.MethodPost, "/api/stacks/{stack}/up", false},
+	{"down", http.MethodPost, "/api/stacks/{stack}/down", false},
+	{"start", http.MethodPost, "/api/stacks/{stack}/start", false},
+	{"stop", http.MethodPost, "/api/stacks/{stack}/stop", false},
+	{"create", http.MethodPost, "/api/stacks/{stack}/create", false},
+	{"logs", http.MethodGet, "/api/stacks/{stack}/logs", false},
+	{"ps", http.MethodGet, "/api/stacks/{stack}/ps", false},
+	{"stats", http.MethodGet, "/api/stacks/{stack}/stats", false},
+	{"usage", http.MethodGet, "/api/stacks/{stack}/usage", false},
+	{"watch", http.MethodPost, "/api/stacks/{stack}/watch", false},
+	{"history", http.MethodGet, "/api/stacks/{stack}/history", false},
+	{"actions", http.MethodGet, "/api/stacks/{stack}/actions", false},
+	{"revisions", http.MethodGet, "/api/stacks/{stack}/revisions", false},
+	{"rollback", http.MethodPost, "/api/stacks/{stack}/rollback/{name}", false},
+	{"reconstruct", http.MethodPost, "/api/stacks/{stack}/reconstruct", false},
+	{"export", http.MethodGet, "/api/stacks/{stack}/export", false},
+	{"export:upload", http.MethodPost, "/api/stacks/{stack}/export", false},
+	{"exports", http.MethodGet, "/api/stacks/{stack}/exports", false},
+	{"backup", http.MethodPost, "/api/stacks/{stack}/backup", false},
+	{"backups", http.MethodGet, "/api/stacks/{stack}/backups", false},
+	{"restore", http.MethodPost, "/api/stacks/{stack}/restore", false},
+	{"meta", http.MethodGet, "/api/stacks/{stack}/meta", false},
+	{"meta:set", http.MethodPut, "/api/stacks/{stack}/meta", false},
+	{"rename", http.MethodPost, "/api/stacks/{stack}/rename", false},
+	{"clone", http.MethodPost, "/api/stacks/{stack}/clone", false},
+	{"chaos", http.MethodPost, "/api/stacks/{stack}/chaos/{name}", false},
+}
+
+// Capabilities is the response of GET /api/capabilities
+type Capabilities struct {
+	User        string                     `json:"user"`
+	Interactive bool                       `json:"interactive"`
+	ReadOnly    bool                       `json:"readOnly"`
+	Global      map[string]bool            `json:"global"`
+	Stacks      map[string]map[string]bool `json:"stacks"`
+}
+
+// allowedActions evaluates capabilities for a principal
+func allowedActions(principal *Principal, capabilities []capability, stack string) map[string]bool {
+	allowed := make(map[string]bool, len(capabilities))
+	for _, c := range capabilities {
+		urlPath := strings.ReplaceAll(strings.ReplaceAll(c.Path, "{stack}", stack), "{name}", "_")
+		allowed[c.Action] = principal.Allows(c.Method, urlPath) && (!c.Interactive || principal.Interactive()) &&
+			readOnlyAllows(c.Method, urlPath)
+	}
+	return allowed
+}
+
+// listStackNames returns the names of all stacks known to dc
+func listStackNames() ([]string, error) {
+	out, err := exec.Command("dc", "stack", "ls").Output()
+	if err != nil {
+		return nil, err
+	}
+	var stacks []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(out, &stacks); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(stacks))
+	for _, s := range stacks {
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// HandleCapabilities serves GET /api/capabilities[?stack=<name>]: the actions the caller may
+// perform globally and on each stack it may read, evaluated with the same rules that authorize
+// the requests themselves, so that clients can hide or disable what would be refused.
+func HandleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	principal := principalFromRequest(r)
+	if principal == nil {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var stacks []string
+	if stack := r.URL.Query().Get("stack"); stack != "" {
+		stacks = []string{stack}
+	} else {
+		names, err := listStackNames()
+		if err != nil {
+			httpError(w, r, "internal_error", http.StatusInternalServerError)
+			return
+		}
+		stacks = names
+	}
+
+	capabilities := Capabilities{
+		User:        principal.Name,
+		Interactive: principal.Interactive(),
+		ReadOnly:    readOnlyMode(),
+		Global:      allowedActions(principal, globalCapabilities, ""),
+		Stacks:      make(map[string]map[string]bool, len(stacks)),
+	}
+	for _, stack := range stacks {
+		// tokens and service accounts only learn of the stacks they may read
+		if !principal.Allows(http.MethodGet, "/api/stacks/"+stack) {
+			continue
+		}
+		capabilities.Stacks[stack] = allowedActions(principal, stackCapabilities, stack)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(capabilities)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCapabilitiesHideUnreadableStacks(t *testing.T) {
+	principal := &Principal{Name: "ci", ServiceAccount: &ServiceAccount{Name: "ci", Rules: []PermissionRule{
+		{Methods: []string{http.MethodGet}, Stacks: []string{"web"}},
+	}}}
+	for stack, visible := range map[string]bool{"web": true, "db": false} {
+		r := withPrincipal(httptest.NewRequest(http.MethodGet, "/api/capabilities?stack="+stack, nil), principal)
+		w := httptest.NewRecorder()
+		HandleCapabilities(w, r)
+		var capabilities Capabilities
+		if err := json.Unmarshal(w.Body.Bytes(), &capabilities); err != nil {
+			t.Fatalf("%s: invalid response %q: %v", stack, w.Body.String(), err)
+		}
+		if _, ok := capabilities.Stacks[stack]; ok != visible {
+			t.Errorf("stack %s listed = %v, want %v", stack, ok, visible)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// WatchSession is a running `docker compose watch` for a stack
+type WatchSession struct {
+	Stack     string    `json:"stack"`
+	StartedAt time.Time `json:"startedAt"`
+	Running   bool      `json:"running"`
+	LastLine  string    `json:"lastLine,omitempty"`
+
+	cmd *exec.Cmd
+}
+
+var (
+	watchSessions   = make(map[string]*WatchSession)
+	watchSessionsMu sync.Mutex
+)
+
+// startWatchSession runs `dc stack watch` in the background and broadcasts its output
+// (file syncs, rebuilds, restarts) to websocket clients as watch-output messages
+func startWatchSession(stackName string) (*WatchSession, error) {
+	watchSessionsMu.Lock()
+	defer watchSessionsMu.Unlock()
+	if session, ok := watchSessions[stackName]; ok && session.Running {
+		return session, nil
+	}
+
+	cmd := exec.Command("dc", "stack", "watch", stackName, "--output-format=json")
+	// Own process group, so that stopping also ends docker compose started by dc
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	session := &WatchSession{Stack: stackName, StartedAt: time.Now(), Running: true, cmd: cmd}
+	watchSessions[stackName] = session
+	watchLog.Info("Started compose watch", "stack", stackName, "pid", cmd.Process.Pid)
+	broadcast <- FileChangeMessage{Type: "watch-started", Stack: stackName}
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			frame := parseRedactedFrame(scanner.Text())
+			watchSessionsMu.Lock()
+			session.LastLine = frame.Line
+			watchSessionsMu.Unlock()
+			broadcast <- FileChangeMessage{Type: "watch-output", Stack: stackName, Line: frame.Line, Stream: frame.Stream}
+		}
+		err := cmd.Wait()
+		watchSessionsMu.Lock()
+		session.Running = false
+		watchSessionsMu.Unlock()
+		watchLog.Info("Compose watch ended", "stack", stackName, "err", err)
+		broadcast <- FileChangeMessage{Type: "watch-stopped", Stack: stackName}
+	}()
+	return session, nil
+}
+
+// stopWatchSession interrupts the watch session of a stack, if any
+func stopWatchSession(stackName string) bool {
+	watchSessionsMu.Lock()
+	defer watchSessionsMu.Unlock()
+	session, ok := watchSessions[stackName]
+	if !ok || !session.Running {
+		return false
+	}
+	if err := syscall.Kill(-session.cmd.Process.Pid, syscall.SIGINT); err != nil {
+		watchLog.Error("Error stopping compose watch", "stack", stackName, "err", err)
+		return false
+	}
+	return true
+}
+
+// stopWatchSessions interrupts all watch sessions
+func stopWatchSessions() {
+	watchSessionsMu.Lock()
+	var stacks []string
+	for stack, session := range watchSessions {
+		if session.Running {
+			stacks = append(stacks, stack)
+		}
+	}
+	watchSessionsMu.Unlock()
+	for _, stack := range stacks {
+		stopWatchSession(stack)
+	}
+}
+
+// HandleWatchSession handles /api/stacks/{name}/watch:
+// GET returns the session state, POST starts watching, DELETE stops it
+func HandleWatchSession(w http.ResponseWriter, r *http.Request, stackName string) {
+	switch r.Method {
+	case http.MethodGet:
+		watchSessionsMu.Lock()
+		session, ok := watchSessions[stackName]
+		var state WatchSession
+		if ok {
+			state = *session
+		} else {
+			state = WatchSession{Stack: stackName}
+		}
+		watchSessionsMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	case http.MethodPost:
+		session, err := startWatchSession(stackName)
+		if err != nil {
+			watchLog.Error("Error starting compose watch", "stack", stackName, "err", err)
+			httpError(w, r, "internal_error", http.StatusInternalServerError)
+			return
+		}
+		watchSessionsMu.Lock()
+		state := *session
+		watchSessionsMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(state)
+	case http.MethodDelete:
+		if !stopWatchSession(stackName) {
+			httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// RunDeviceWatcher keeps `dc devices watch` running when DEVICE_WATCH=true, so that services
+// configured via x-composectl.devices.watch are restarted when their USB device is re-plugged
+func RunDeviceWatcher() {
+	if !strings.EqualFold(getConfig("device_watch", "false"), "true") {
+		return
+	}
+	for {
+		cmd := exec.Command("dc", "devices", "watch")
+		stderr, err := cmd.StderrPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			devicesLog.Error("Error starting device watcher", "err", err)
+		} else {
+			scanner := bufio.NewScanner(stderr)
+			for scanner.Scan() {
+				devicesLog.Info(scanner.Text())
+			}
+			devicesLog.Warn("Device watcher exited", "err", cmd.Wait())
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// dnsProviderTypes are the DNS providers dc can manage records with
+var dnsProviderTypes = []string{"cloudflare", "pihole", "rfc2136"}
+
+// redactedSecret stands for a credential in API responses; sending it back keeps the stored one
+const redactedSecret = "***"
+
+// DNSSettings configures the DNS records dc creates on `dc stack up` for the hosts of the
+// stack's Traefik rules and removes on `dc stack down`. dc reads them from the same file.
+type DNSSettings struct {
+	Target    string              `yaml:"target" json:"target"` // address or host name the records point at
+	TTL       int                 `yaml:"ttl,omitempty" json:"ttl,omitempty"`
+	Providers []DNSProviderConfig `yaml:"providers" json:"providers"`
+}
+
+// DNSProviderConfig is a provider responsible for the hosts in Zone
+type DNSProviderConfig struct {
+	Type         string `yaml:"type" json:"type"` // cloudflare, pihole or rfc2136
+	Zone         string `yaml:"zone" json:"zone"`
+	Target       string `yaml:"target,omitempty" json:"target,omitempty"`
+	Token        string `yaml:"token,omitempty" json:"token,omitempty"`       // cloudflare
+	ZoneID       string `yaml:"zone_id,omitempty" json:"zoneId,omitempty"`    // cloudflare
+	URL          string `yaml:"url,omitempty" json:"url,omitempty"`           // pihole
+	Password     string `yaml:"password,omitempty" json:"password,omitempty"` // pihole
+	Server       string `yaml:"server,omitempty" json:"server,omitempty"`     // rfc2136
+	KeyName      string `yaml:"key_name,omitempty" json:"keyName,omitempty"`
+	KeySecret    string `yaml:"key_secret,omitempty" json:"keySecret,omitempty"`
+	KeyAlgorithm string `yaml:"key_algorithm,omitempty" json:"keyAlgorithm,omitempty"`
+}
+
+var dnsSettingsMu sync.Mutex
+
+// getDNSSettingsPath returns the file holding the DNS settings (config key dns_file, shared
+// with dc, by default in dc's settings dir)
+func getDNSSettingsPath() (string, error) {
+	return settingsFilePath("dns_file", "dns.yml")
+}
+
+// loadDNSSettings reads the DNS settings; the caller must hold dnsSettingsMu
+func loadDNSSettings() (DNSSettings, error) {
+	settings := DNSSettings{Providers: []DNSProviderConfig{}}
+	path, err := getDNSSettingsPath()
+	if err != nil {
+		return settings, err
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return settings, nil
+	} else if err != nil {
+		return settings, err
+	}
+	if err := yaml.Unmarshal(content, &settings); err != nil {
+		return settings, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return settings, nil
+}
+
+// saveDNSSettings writes the DNS settings; the caller must hold dnsSettingsMu
+func saveDNSSettings(settings DNSSettings) error {
+	content, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	path, err := getDNSSettingsPath()
+	if err != nil {
+		return err
+	}
+	return writeSettingsFile(path, content)
+}
+
+// redactedDNSSettings hides the credentials of the providers in API responses
+func redactedDNSSettings(settings DNSSettings) DNSSettings {
+	providers := make([]DNSProviderConfig, len(settings.Providers))
+	for i, p := range settings.Providers {
+		for _, secret := range []*string{&p.Token, &p.Password, &p.KeySecret} {
+			if *secret != "" {
+				*secret = redactedSecret
+			}
+		}
+		providers[i] = p
+	}
+	settings.Providers = providers
+	return settings
+}
+
+// validateDNSSettings checks the settings and puts back the stored credentials of providers
+// (same type and zone) whose credentials were sent redacted
+func validateDNSSettings(settings *DNSSettings, stored DNSSettings) error {
+	if settings.TTL < 0 {
+		return fmt.Errorf("ttl must not be negative")
+	}
+	for i := range settings.Providers {
+		p := &settings.Providers[i]
+		if !matchesFilter(dnsProviderTypes, p.Type) {
+			return fmt.Errorf("provider %d: type must be one of %s", i+1, strings.Join(dnsProviderTypes, ", "))
+		}
+		if strings.Trim(p.Zone, ".") == "" {
+			return fmt.Errorf("provider %d: zone is required", i+1)
+		}
+		if p.Target == "" && settings.Target == "" {
+			return fmt.Errorf("provider %d: target is required unless set for all providers", i+1)
+		}
+		for _, old := range stored.Providers {
+			if old.Type != p.Type || old.Zone != p.Zone {
+				continue
+			}
+			if p.Token == redactedSecret {
+				p.Token = old.Token
+			}
+			if p.Password == redactedSecret {
+				p.Password = old.Password
+			}
+			if p.KeySecret == redactedSecret {
+				p.KeySecret = old.KeySecret
+			}
+		}
+		switch {
+		case p.Type == "cloudflare" && (p.Token == "" || p.Token == redactedSecret):
+			return fmt.Errorf("provider %d: cloudflare requires a token", i+1)
+		case p.Type == "pihole" && !strings.HasPrefix(p.URL, "http"):
+			return fmt.Errorf("provider %d: pihole requires an http(s) url", i+1)
+		case p.Type == "rfc2136" && p.Server == "":
+			return fmt.Errorf("provider %d: rfc2136 requires a server", i+1)
+		case p.Password == redactedSecret || p.KeySecret == redactedSecret:
+			return fmt.Errorf("provider %d: the credentials must be given again", i+1)
+		}
+	}
+	return nil
+}
+
+// HandleDNSSettings handles GET and PUT /api/settings/dns: the providers dc creates DNS records
+// with, credentials redacted. Like webhooks, they can only be managed by interactive users.
+func HandleDNSSettings(w http.ResponseWriter, r *http.Request) {
+	principal := principalFromRequest(r)
+	if principal == nil || principal.ServiceAccount != nil || principal.Token != nil {
+		httpError(w, r, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	dnsSettingsMu.Lock()
+	defer dnsSettingsMu.Unlock()
+	stored, err := loadDNSSettings()
+	if err != nil {
+		configLog.Error("Error loading DNS settings", "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+
+	settings := stored
+	if r.Method == http.MethodPut {
+		settings = DNSSettings{}
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			httpError(w, r, "invalid_json", http.StatusBadRequest, err)
+			return
+		}
+		if settings.Providers == nil {
+			settings.Providers = []DNSProviderConfig{}
+		}
+		if err := validateDNSSettings(&settings, stored); err != nil {
+			httpError(w, r, "dns_settings_invalid", http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := saveDNSSettings(settings); err != nil {
+			configLog.Error("Error saving DNS settings", "err", err)
+			httpError(w, r, "internal_error", http.StatusInternalServerError)
+			return
+		}
+		configLog.Info("User updated DNS settings", "user", principal.Name, "providers", len(settings.Providers))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(redactedDNSSettings(settings))
+}
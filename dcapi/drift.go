@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DriftReport is the drift of one stack, as printed by `dc drift`
+type DriftReport struct {
+	Stack       string `json:"stack"`
+	Mode        string `json:"mode"`
+	Drifted     bool   `json:"drifted"`
+	Differences []struct {
+		Service   string `json:"service"`
+		Container string `json:"container,omitempty"`
+		Kind      string `json:"kind"`
+	} `json:"differences"`
+	Corrected bool   `json:"corrected,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// summary describes the differences in one line, e.g. "web: stopped, db: missing"
+func (r DriftReport) summary() string {
+	parts := make([]string, 0, len(r.Differences))
+	for _, d := range r.Differences {
+		parts = append(parts, d.Service+": "+d.Kind)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// RunDriftReconciler compares the containers of every stack with its effective file each
+// drift_interval (e.g. 5m; unset disables it). New drift is published as a drift event and
+// notified; stacks in correct mode (x-composectl.drift: correct) are brought up again.
+func RunDriftReconciler() {
+	interval, err := time.ParseDuration(getConfig("drift_interval", ""))
+	if err != nil || interval <= 0 {
+		return
+	}
+	reported := make(map[string]string)
+	for {
+		time.Sleep(interval)
+
+		out, err := exec.Command("dc", "drift", "--correct=true").Output()
+		if err != nil {
+			driftLog.Error("Error checking for drift", "err", err)
+			continue
+		}
+		var reports []DriftReport
+		if err := json.Unmarshal(out, &reports); err != nil {
+			driftLog.Error("Error checking for drift", "err", err)
+			continue
+		}
+		drifted := make(map[string]string)
+		for _, report := range reports {
+			if report.Error != "" && !report.Drifted {
+				driftLog.Error("Error checking for drift", "stack", report.Stack, "err", report.Error)
+				continue
+			}
+			if !report.Drifted {
+				continue
+			}
+			summary := report.summary()
+			if report.Corrected {
+				publishEvent(StackEvent{Stack: report.Stack, Action: "drift-corrected", Time: time.Now().UTC()})
+				notify(Notification{Event: EventDriftCorrected, Stack: report.Stack, Message: "brought up again after drift: " + summary})
+				continue
+			}
+			if report.Error != "" {
+				summary = fmt.Sprintf("%s; correcting failed: %s", summary, report.Error)
+			}
+			// the same drift is reported once, until it changes or is resolved
+			drifted[report.Stack] = summary
+			if reported[report.Stack] != summary {
+				publishEvent(StackEvent{Stack: report.Stack, Action: "drift", Time: time.Now().UTC()})
+				notify(Notification{Event: EventDriftDetected, Stack: report.Stack, Message: summary})
+			}
+		}
+		reported = drifted
+	}
+}
+
+// HandleDrift handles GET /api/drift: the drift of every deployed stack, or of ?stack=
+func HandleDrift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	args := []string{"drift"}
+	if stack := r.URL.Query().Get("stack"); stack != "" {
+		if !stackNamePattern.MatchString(stack) {
+			httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+			return
+		}
+		args = append(args, stack)
+	}
+	HandleAction(w, "dc", args...)
+}
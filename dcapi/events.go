@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StackEvent is a container lifecycle event of a stack, as printed by `dc events -f --output-format=json`
+type StackEvent struct {
+	Stack     string    `json:"stack"`
+	Service   string    `json:"service"`
+	Container string    `json:"container"`
+	Action    string    `json:"action"`
+	Health    string    `json:"health,omitempty"`
+	ExitCode  *int      `json:"exitCode,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// eventSubscriber is an SSE client of /api/events, optionally limited to one stack
+type eventSubscriber struct {
+	stack string
+	queue *clientQueue
+}
+
+var (
+	eventSubscribers   = make(map[*eventSubscriber]bool)
+	eventSubscribersMu sync.Mutex
+)
+
+// publishEvent sends a stack event to websocket clients (as type container-<action>) and to
+// the matching SSE subscribers, without waiting for any of them
+func publishEvent(event StackEvent) {
+	broadcast <- FileChangeMessage{Type: "container-" + event.Action, Stack: event.Stack, Event: &event}
+	notifyStackEvent(event)
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	eventSubscribersMu.Lock()
+	defer eventSubscribersMu.Unlock()
+	for subscriber := range eventSubscribers {
+		if subscriber.stack == "" || subscriber.stack == event.Stack {
+			subscriber.queue.push(payload)
+		}
+	}
+}
+
+// RunEventSubscriber keeps `dc events -f` running and republishes its events. It is enabled
+// by default and can be turned off with EVENTS=false.
+func RunEventSubscriber() {
+	if strings.EqualFold(getConfig("events", "true"), "false") {
+		return
+	}
+	for {
+		cmd := exec.Command("dc", "events", "-f", "--output-format=json")
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			eventsLog.Error("Error starting event subscriber", "err", err)
+		} else {
+			scanner := bufio.NewScanner(stdout)
+			for scanner.Scan() {
+				var event StackEvent
+				if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.Stack == "" {
+					continue
+				}
+				publishEvent(event)
+			}
+			eventsLog.Warn("Event subscriber exited", "err", cmd.Wait())
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// HandleEvents serves GET /api/events as server-sent events of type container, optionally
+// filtered by ?stack=. Clients that accept application/x-ndjson get one JSON event per line.
+func HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	subscriber := &eventSubscriber{stack: r.URL.Query().Get("stack"), queue: newClientQueue()}
+	eventSubscribersMu.Lock()
+	eventSubscribers[subscriber] = true
+	eventSubscribersMu.Unlock()
+	defer func() {
+		eventSubscribersMu.Lock()
+		delete(eventSubscribers, subscriber)
+		eventSubscribersMu.Unlock()
+		subscriber.queue.close()
+	}()
+
+	controller := http.NewResponseController(w)
+	timeout := streamWriteTimeout()
+	asJSON := wantsFrames(r) && !wantsEvents(r)
+	if asJSON {
+		w.Header().Set("Content-Type", ndjsonContentType)
+	} else {
+		setEventStreamHeaders(w)
+	}
+	w.WriteHeader(http.StatusOK)
+	_ = controller.Flush()
+
+	for {
+		select {
+		case payload := <-subscriber.queue.ch:
+			message := "event: container\ndata: " + string(payload) + "\n\n"
+			if asJSON {
+				message = string(payload) + "\n"
+			}
+			_ = controller.SetWriteDeadline(time.Now().Add(timeout))
+			if _, err := w.Write([]byte(message)); err != nil {
+				return
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
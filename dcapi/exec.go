@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/gorilla/websocket"
+)
+
+var containerIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// execControl is a text frame sent over an exec websocket. Clients send "resize" and "input";
+// the server sends "exit" once the command has finished.
+type execControl struct {
+	Type string `json:"type"`
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+	Data string `json:"data,omitempty"`
+	Code *int   `json:"code,omitempty"`
+}
+
+// HandleContainerExec serves the terminal websocket at /api/containers/{id}/exec[?cmd=..&cols=..&rows=..].
+// The command (default: sh) runs through `dc container exec` on a pseudo terminal. Binary frames
+// carry terminal input and output; text frames carry execControl messages. Only logged in users
+// may open a terminal, never tokens or service accounts.
+func HandleContainerExec(w http.ResponseWriter, r *http.Request) {
+	principal := principalFromRequest(r)
+	if principal == nil || !principal.Interactive() {
+		httpError(w, r, "forbidden", http.StatusForbidden)
+		return
+	}
+	id, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/containers"), "/"), "/")
+	if action != "exec" || !containerIDPattern.MatchString(id) {
+		httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+		return
+	}
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	master, slave, err := openPTY()
+	if err != nil {
+		execLog.Error("Error opening terminal", "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+	defer master.Close()
+	query := r.URL.Query()
+	if cols, _ := strconv.Atoi(query.Get("cols")); cols > 0 {
+		rows, _ := strconv.Atoi(query.Get("rows"))
+		if rows <= 0 {
+			rows = 24
+		}
+		_ = resizePTY(master, cols, rows)
+	}
+
+	cmd := exec.Command("dc", append([]string{"container", "exec", id, "--"}, query["cmd"]...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := cmd.Start(); err != nil {
+		slave.Close()
+		execLog.Error("Error starting exec", "container", id, "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+	slave.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		execLog.Warn("WebSocket upgrade failed", "err", err)
+		cmd.Process.Kill()
+		cmd.Wait()
+		return
+	}
+	defer conn.Close()
+	execLog.Info("User opened a terminal", "user", principal.Name, "container", id)
+
+	// gorilla connections allow one concurrent writer
+	var writeMu sync.Mutex
+	write := func(messageType int, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(messageType, data)
+	}
+
+	go bridgeExecInput(conn, master, cmd)
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := master.Read(buf)
+		if n > 0 {
+			if write(websocket.BinaryMessage, buf[:n]) != nil {
+				break
+			}
+		}
+		if err != nil {
+			// reading the master fails with EIO once the last process on the terminal exits
+			if !errors.Is(err, syscall.EIO) && !errors.Is(err, os.ErrClosed) {
+				execLog.Error("Error reading terminal", "container", id, "err", err)
+			}
+			break
+		}
+	}
+
+	code := 0
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			code = -1
+		} else {
+			code = exitErr.ExitCode()
+		}
+	}
+	if exit, err := json.Marshal(execControl{Type: "exit", Code: &code}); err == nil {
+		_ = write(websocket.TextMessage, exit)
+	}
+	writeMu.Lock()
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	writeMu.Unlock()
+	execLog.Info("Terminal closed", "container", id, "exit_code", code)
+}
+
+// bridgeExecInput forwards client frames to the terminal until the client goes away, in which
+// case the command and everything it started on the terminal is killed
+func bridgeExecInput(conn *websocket.Conn, master *os.File, cmd *exec.Cmd) {
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			return
+		}
+		if messageType == websocket.BinaryMessage {
+			if _, err := master.Write(data); err != nil {
+				return
+			}
+			continue
+		}
+		var control execControl
+		if err := json.Unmarshal(data, &control); err != nil {
+			continue
+		}
+		switch control.Type {
+		case "resize":
+			if control.Cols > 0 && control.Rows > 0 {
+				if err := resizePTY(master, control.Cols, control.Rows); err != nil {
+					execLog.Error("Error resizing terminal", "err", err)
+				}
+			}
+		case "input":
+			if _, err := master.Write([]byte(control.Data)); err != nil {
+				return
+			}
+		}
+	}
+}
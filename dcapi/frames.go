@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Streams of an OutputFrame, as written by dc with --output-format=json. Lines that dc
+// prints without framing (e.g. its own messages) use the log stream.
+const (
+	FrameStdout = "stdout"
+	FrameStderr = "stderr"
+	FrameError  = "error"
+	FrameDone   = "done"
+	FrameLog    = "log"
+)
+
+// ndjsonContentType is the Accept value selecting JSON framing for streamed output
+const ndjsonContentType = "application/x-ndjson"
+
+// sseContentType is the Accept value selecting server-sent events for streamed output
+const sseContentType = "text/event-stream"
+
+// sseEventTypes are the SSE event types of the frames by stream: the output of the command as
+// stdout and stderr, dc's own messages as progress. The done event, which carries the exit
+// code, is sent by dcapi once the command exited.
+var sseEventTypes = map[string]string{
+	FrameStdout: "stdout",
+	FrameStderr: "stderr",
+	FrameError:  "stderr",
+	FrameDone:   "progress",
+	FrameLog:    "progress",
+}
+
+// OutputFrame is one line of streamed command output
+type OutputFrame struct {
+	Stream string    `json:"stream"`
+	Line   string    `json:"line"`
+	TS     time.Time `json:"ts"`
+	Stack  string    `json:"stack,omitempty"` // set on the output of bulk operations
+}
+
+// framePrefixes are the plain-text prefixes by stream
+var framePrefixes = map[string]string{
+	FrameStdout: "[STDOUT] ",
+	FrameStderr: "[STDERR] ",
+	FrameError:  "[ERROR] ",
+	FrameDone:   "[DONE] ",
+}
+
+// Text formats the frame in the plain-text format used before JSON framing
+func (f OutputFrame) Text() string {
+	if f.Stack != "" {
+		return framePrefixes[f.Stream] + "[" + f.Stack + "] " + f.Line
+	}
+	return framePrefixes[f.Stream] + f.Line
+}
+
+// parseFrame reads a line of dc output, wrapping unframed lines in a log frame
+func parseFrame(line string) OutputFrame {
+	var frame OutputFrame
+	if strings.HasPrefix(line, "{") && json.Unmarshal([]byte(line), &frame) == nil && frame.Stream != "" {
+		return frame
+	}
+	return OutputFrame{Stream: FrameLog, Line: line, TS: time.Now().UTC()}
+}
+
+// parseRedactedFrame reads a line of dc output like parseFrame and redacts its text. The frame is
+// parsed first: redacting the JSON could cut into its quotes and turn it into a log frame.
+func parseRedactedFrame(line string) OutputFrame {
+	frame := parseFrame(line)
+	frame.Line = redactText(frame.Line)
+	return frame
+}
+
+// wantsFrames reports whether the client negotiated JSON framing via Accept
+func wantsFrames(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+// frameEvent returns the SSE event type of a frame's stream
+func frameEvent(stream string) string {
+	if event, ok := sseEventTypes[stream]; ok {
+		return event
+	}
+	return "progress"
+}
+
+// wantsEvents reports whether the client negotiated server-sent events via Accept
+func wantsEvents(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), sseContentType)
+}
+
+// setEventStreamHeaders starts a response of server-sent events. Proxies such as nginx are asked
+// not to buffer it.
+func setEventStreamHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", sseContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+}
+
+// formatEvent renders a server-sent event with the JSON of v as data. The id, if not empty,
+// lets clients resume with Last-Event-ID.
+func formatEvent(id, event string, v interface{}) string {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		encoded = []byte("{}")
+	}
+	var b strings.Builder
+	if id != "" {
+		b.WriteString("id: " + id + "\n")
+	}
+	b.WriteString("event: " + event + "\ndata: " + string(encoded) + "\n\n")
+	return b.String()
+}
+
+// formatFrame renders a frame for a client, as a JSON line or in plain text
+func formatFrame(frame OutputFrame, asJSON bool) string {
+	if !asJSON {
+		return frame.Text()
+	}
+	encoded, err := json.Marshal(frame)
+	if err != nil {
+		return frame.Text()
+	}
+	return string(encoded)
+}
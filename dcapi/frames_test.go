@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestParseRedactedFrame(t *testing.T) {
+	old := redactionLevel
+	redactionLevel = RedactionStandard
+	t.Cleanup(func() { redactionLevel = old })
+
+	frame := parseRedactedFrame(`{"stream":"stdout","line":"DB_PASSWORD=hunter2","ts":"2026-01-02T03:04:05Z"}`)
+	if frame.Stream != FrameStdout || frame.Line != "DB_PASSWORD=***" {
+		t.Errorf("frame = %+v, want the stdout line redacted", frame)
+	}
+	frame = parseRedactedFrame(`token: "abc def"`)
+	if frame.Stream != FrameLog || frame.Line != "token: ***" {
+		t.Errorf("frame = %+v, want the log line redacted", frame)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Heartbeats tell an external dead-man switch (a healthchecks.io check, an Uptime Kuma push
+// monitor) that a background job keeps running. The ping URL of an operation is
+// heartbeat_<operation>_url, falling back to heartbeat_url; dc pings for its own operations.
+
+// heartbeatURL returns the ping URL configured for an operation, or ""
+func heartbeatURL(operation string) string {
+	return getConfig("heartbeat_"+operation+"_url", getConfig("heartbeat_url", ""))
+}
+
+// pingHeartbeat reports a successful pass of a background job. Failures are only logged.
+func pingHeartbeat(operation string) {
+	target := heartbeatURL(operation)
+	if target == "" {
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(target)
+	if err != nil {
+		// the URL itself is the monitor's secret, keep it out of the log
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		notificationsLog.Error("Heartbeat ping failed", "operation", operation, "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		notificationsLog.Error("Heartbeat ping failed", "operation", operation, "status", resp.Status)
+	}
+}
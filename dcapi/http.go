@@ -1,145 +1,817 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
+// exportRefPattern matches <stack>[/<export>] references to exports on the storage target
+var exportRefPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*(/[0-9-]+)?$`)
+
+// apiVersionPrefix is the prefix of the current API version. The unversioned /api paths remain
+// as deprecated aliases for existing clients.
+const apiVersionPrefix = "/api/v1"
+
 func RegisterHTTPHandlers() {
-	http.HandleFunc("/api/auth/login", HandleLogin)
-	http.HandleFunc("/api/auth/logout", JwtAuthMiddleware(HandleLogout))
-	http.HandleFunc("/api/auth/status", JwtAuthMiddleware(HandleAuthStatus))
-	http.HandleFunc("/ws", JwtAuthMiddleware(HandleWebSocket))
-	http.HandleFunc("/api/thumbnail", JwtAuthMiddleware(HandleThumbnail))
-	http.HandleFunc("/api/stacks", JwtAuthMiddleware(HandleStackAPI))
-	http.HandleFunc("/api/stacks/", JwtAuthMiddleware(HandleStackAPI))
-	http.HandleFunc("/api/secrets", JwtAuthMiddleware(HandleSecretAPI))
-	http.HandleFunc("/api/secrets/", JwtAuthMiddleware(HandleSecretAPI))
-}
-
-// HandleStackAPI routes stack API requests to appropriate handlers
-func HandleStackAPI(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	path = strings.TrimPrefix(path, "/api")
-
-	if !strings.HasPrefix(path, "/stacks") {
-		http.Error(w, "Not found "+path, http.StatusNotFound)
-		return
-	}
-
-	path = strings.TrimPrefix(path, "/stacks")
-	path = strings.TrimPrefix(path, "/")
-	segments := []string{}
-	if strings.Contains(path, "/") {
-		segments = strings.Split(path, "/")
-	} else if path != "" {
-		segments = []string{path}
-	}
-
-	if len(segments) == 2 {
-		stackName := segments[0]
-		actionName := segments[1]
-		switch actionName {
-		case "stop", "start", "up", "down", "create":
-			if r.Method == http.MethodPost || r.Method == http.MethodPut {
-				HandleAction(w, "dc", "stack", actionName, stackName)
-			} else {
-				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			}
-		case "logs":
-			if r.Method == http.MethodGet {
-				HandleAction(w, "dc", "stack", actionName, stackName)
-			} else {
-				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			}
-		case "rm", "remove", "del", "delete":
-			if r.Method == http.MethodDelete {
-				HandleAction(w, "dc", "stack", "rm", stackName)
-			} else {
-				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	auth := JwtAuthMiddleware
+	route(http.MethodPost, "/api/auth/login", HandleLogin)
+	route(http.MethodPost, "/api/auth/logout", HandleLogout, auth)
+	route(http.MethodGet, "/api/auth/status", HandleAuthStatus, auth)
+	http.HandleFunc("/ws", chain(JwtAuthMiddleware(HandleWebSocket), commonMiddleware...))
+	mount("/api/thumbnail/", HandleThumbnail, auth)
+	http.HandleFunc("/thumbnail/", chain(JwtAuthMiddleware(HandleThumbnail), commonMiddleware...))
+	mount("/api/assets", HandleAsset, auth)
+	registerStackRoutes()
+	mount("/api/networks", HandleNetworksAPI, auth)
+	mount("/api/networks/", HandleNetworksAPI, auth)
+	mount("/api/volumes", HandleVolumesAPI, auth)
+	mount("/api/volumes/", HandleVolumesAPI, auth)
+	mount("/api/system/", HandleSystemAPI, auth)
+	mount("/api/containers", HandleContainersAPI, auth)
+	mount("/api/containers/", HandleContainerExec, auth)
+	route(http.MethodGet, "/api/containers/{id}", HandleContainerInspect, auth)
+	mount("/api/summary", HandleSummary, auth)
+	mount("/api/capacity", HandleCapacity, auth)
+	mount("/api/graph", HandleGraph, auth)
+	mount("/api/boot", HandleBootStatus, auth)
+	mount("/api/drift", HandleDrift, auth)
+	mount(capabilitiesPath, HandleCapabilities, auth)
+	mount("/api/events", HandleEvents, auth)
+	mount("/api/transform", HandleTransform, auth)
+	mount("/api/lint", HandleLint, auth)
+	mount("/api/convert", HandleConvert, auth)
+	mount("/api/tokens", HandleTokensAPI, auth)
+	mount("/api/tokens/", HandleTokensAPI, auth)
+	mount("/api/jobs", HandleJobsAPI, auth)
+	mount("/api/jobs/", HandleJobsAPI, auth)
+	mount("/api/audit", HandleAuditAPI, auth)
+	mount("/api/notifications", HandleNotificationsAPI, auth)
+	mount("/api/notifications/", HandleNotificationsAPI, auth)
+	route(http.MethodGet, "/api/settings/dns", HandleDNSSettings, auth)
+	route(http.MethodPut, "/api/settings/dns", HandleDNSSettings, auth)
+	route(http.MethodGet, "/api/settings/logging", HandleLoggingSettings, auth)
+	route(http.MethodPut, "/api/settings/logging", HandleLoggingSettings, auth)
+	mount("/api/secrets", HandleSecretAPI, auth)
+	mount("/api/secrets/", HandleSecretAPI, auth)
+	http.HandleFunc(apiVersionPrefix+"/openapi.json", chain(HandleOpenAPI, commonMiddleware...))
+	http.HandleFunc(apiVersionPrefix+"/docs", chain(HandleSwaggerUI, commonMiddleware...))
+	http.HandleFunc("/api/", chain(func(w http.ResponseWriter, r *http.Request) {
+		httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+	}, commonMiddleware...))
+	http.HandleFunc("/metrics", HandleMetrics)
+	http.HandleFunc("/status", HandleStatus)
+	http.HandleFunc("/", chain(HandleUI, commonMiddleware...))
+}
+
+// handleAPI registers an API handler under apiVersionPrefix and at its deprecated unversioned
+// path. The handler always sees the unversioned path, which is also what token scopes and
+// service account rules match.
+func handleAPI(path string, handler http.HandlerFunc) {
+	suffix := strings.TrimPrefix(path, "/api")
+	http.HandleFunc(apiVersionPrefix+suffix, func(w http.ResponseWriter, r *http.Request) {
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/api" + strings.TrimPrefix(r.URL.Path, apiVersionPrefix)
+		r2.URL.RawPath = ""
+		handler(w, r2)
+	})
+	http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", basePath()+apiVersionPrefix+strings.TrimPrefix(r.URL.Path, "/api")))
+		handler(w, r)
+	})
+}
+
+// StackCopyRequest is the body of POST /api/stacks/{name}/rename and /clone
+type StackCopyRequest struct {
+	Name     string `json:"name"`
+	Recreate bool   `json:"recreate"`
+}
+
+// StackMetaRequest is the body of PUT /api/stacks/{name}/meta; fields left out stay unchanged
+type StackMetaRequest struct {
+	Group     *string `json:"group,omitempty"`
+	Autostart *bool   `json:"autostart,omitempty"`
+}
+
+// StackActionRequest is the optional body of POST /api/stacks/{name}/up, /start, /stop and /down
+type StackActionRequest struct {
+	Services []string `json:"services"` // limits the action to these services of the stack
+}
+
+// stackActionServices reads the services of a stack action from a JSON request body. Other
+// bodies are ignored: the GUI sends the stack file along with start and stop.
+func stackActionServices(r *http.Request) ([]string, bool) {
+	var req StackActionRequest
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return nil, true
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return nil, false
+	}
+	for _, service := range req.Services {
+		if !stackNamePattern.MatchString(service) {
+			return nil, false
+		}
+	}
+	return req.Services, true
+}
+
+// registerStackRoutes registers the routes of /api/stacks. Actions also accept PUT and removal
+// the rm, remove, del and delete suffixes for existing clients.
+func registerStackRoutes() {
+	auth := JwtAuthMiddleware
+	route(http.MethodGet, "/api/stacks", handleListStacks, auth)
+	route(http.MethodGet, "/api/stacks/{$}", handleListStacks, auth)
+	route(http.MethodPost, "/api/stacks/_bulk", HandleBulkStacks, auth)
+	route(http.MethodPost, "/api/stacks/import", HandleImportStack, auth)
+	route(http.MethodPost, "/api/stacks/import-bundle", handleImportBundle, auth)
+	route(http.MethodGet, "/api/stacks/{stack}", handleViewStack, auth)
+	route(http.MethodPut, "/api/stacks/{stack}", handleSaveStack, auth)
+	route(http.MethodDelete, "/api/stacks/{stack}", handleRemoveStack, auth)
+	for _, action := range []string{"stop", "start", "up", "down", "create"} {
+		route(http.MethodPost, "/api/stacks/{stack}/"+action, handleStackAction(action), auth)
+		route(http.MethodPut, "/api/stacks/{stack}/"+action, handleStackAction(action), auth)
+	}
+	for _, alias := range []string{"rm", "remove", "del", "delete"} {
+		route(http.MethodDelete, "/api/stacks/{stack}/"+alias, handleRemoveStack, auth)
+	}
+	route(http.MethodGet, "/api/stacks/{stack}/view", handleViewStack, auth)
+	route(http.MethodGet, "/api/stacks/{stack}/stream", func(w http.ResponseWriter, r *http.Request) {
+		HandleResumeStream(w, r, r.PathValue("stack"))
+	}, auth)
+	route(http.MethodGet, "/api/stacks/{stack}/ps", handleStackPs, auth)
+	route(http.MethodGet, "/api/stacks/{stack}/stats", func(w http.ResponseWriter, r *http.Request) {
+		HandleStackStats(w, r, r.PathValue("stack"))
+	}, auth)
+	route(http.MethodGet, "/api/stacks/{stack}/usage", func(w http.ResponseWriter, r *http.Request) {
+		HandleStackUsage(w, r, r.PathValue("stack"))
+	}, auth)
+	route(http.MethodGet, "/api/stacks/{stack}/meta", handleStackQuery("meta"), auth)
+	route(http.MethodPut, "/api/stacks/{stack}/meta", handleSetStackMeta, auth)
+	route(http.MethodPost, "/api/stacks/{stack}/rename", handleCopyStack("rename"), auth)
+	route(http.MethodPost, "/api/stacks/{stack}/clone", handleCopyStack("clone"), auth)
+	route(http.MethodGet, "/api/stacks/{stack}/export", handleDownloadExport, auth)
+	route(http.MethodPost, "/api/stacks/{stack}/export", handleUploadExport, auth)
+	route(http.MethodGet, "/api/stacks/{stack}/exports", handleStackQuery("exports"), auth)
+	route(http.MethodPost, "/api/stacks/{stack}/backup", handleBackupStack, auth)
+	route(http.MethodGet, "/api/stacks/{stack}/backups", handleListBackups, auth)
+	route(http.MethodPost, "/api/stacks/{stack}/restore", handleRestoreStack, auth)
+	route(http.MethodGet, "/api/stacks/{stack}/revisions", handleStackQuery("revisions"), auth)
+	route(http.MethodGet, "/api/stacks/{stack}/history", handleStackQuery("history"), auth)
+	route(http.MethodGet, "/api/stacks/{stack}/actions", handleStackActions, auth)
+	route(http.MethodPost, "/api/stacks/{stack}/rollback/{revision}", handleRollbackStack, auth)
+	route(http.MethodGet, "/api/stacks/{stack}/reconstruct", handleStackQuery("reconstruct"), auth)
+	route(http.MethodPost, "/api/stacks/{stack}/reconstruct", handleReconstructStack, auth)
+	route(http.MethodPost, "/api/stacks/{stack}/adopt", handleAdoptStack, auth)
+	route(http.MethodPost, "/api/stacks/{stack}/chaos/{experiment}", handleChaos, auth)
+	route(http.MethodGet, "/api/stacks/{stack}/logs", handleStackLogs, auth)
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+		route(method, "/api/stacks/{stack}/watch", func(w http.ResponseWriter, r *http.Request) {
+			HandleWatchSession(w, r, r.PathValue("stack"))
+		}, auth)
+	}
+}
+
+// handleListStacks handles GET /api/stacks; ?detail=summary lists the stacks from a single
+// docker ps instead of inspecting every container
+func handleListStacks(w http.ResponseWriter, r *http.Request) {
+	HandleAction(w, "dc", append([]string{"stack", "ls"}, queryFlags(r, listFilterParams("detail"))...)...)
+}
+
+// handleViewStack handles GET /api/stacks/{stack}
+func handleViewStack(w http.ResponseWriter, r *http.Request) {
+	HandleAction(w, "dc", "stack", "view", r.PathValue("stack"))
+}
+
+// handleSaveStack handles PUT /api/stacks/{stack} with the stack file as body
+func handleSaveStack(w http.ResponseWriter, r *http.Request) {
+	HandleActionWithStdin(w, r, r.Body, "dc", append([]string{"stack", "save", r.PathValue("stack")}, mutationFlags(r, nil)...)...)
+}
+
+// handleRemoveStack handles DELETE /api/stacks/{stack}
+func handleRemoveStack(w http.ResponseWriter, r *http.Request) {
+	HandleAction(w, "dc", append([]string{"stack", "rm", r.PathValue("stack")}, mutationFlags(r, nil)...)...)
+}
+
+// handleStackPs handles GET /api/stacks/{stack}/ps; ?logs=<n> adds the last n log lines of
+// every container, so a stack page needs no log stream per container
+func handleStackPs(w http.ResponseWriter, r *http.Request) {
+	HandleAction(w, "dc", append([]string{"stack", "ps", r.PathValue("stack")}, queryFlags(r, map[string]string{
+		"logs": "logs",
+	})...)...)
+}
+
+// handleStackQuery returns the handler of a read-only dc stack command without options
+func handleStackQuery(command string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		HandleAction(w, "dc", "stack", command, r.PathValue("stack"))
+	}
+}
+
+// handleStackAction returns the handler of POST /api/stacks/{stack}/<action> for stop, start,
+// up, down and create
+func handleStackAction(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stackName := r.PathValue("stack")
+		args := append([]string{"stack", action, stackName}, mutationFlags(r, nil)...)
+		if action != "create" {
+			services, ok := stackActionServices(r)
+			if !ok {
+				httpError(w, r, "services_invalid", http.StatusBadRequest)
+				return
 			}
-		case "view":
-			if r.Method == http.MethodGet {
-				HandleAction(w, "dc", "stack", "view", segments[0])
-			} else {
-				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			if len(services) > 0 {
+				args = append(args, "--services="+strings.Join(services, ","))
 			}
-		default:
-			http.Error(w, "Not found "+path, http.StatusNotFound)
-		}
-	} else if len(segments) == 1 {
-		if r.Method == http.MethodGet {
-			HandleAction(w, "dc", "stack", "view", segments[0])
-		} else if r.Method == http.MethodPut {
-			HandleActionWithStdin(w, r.Body, "dc", "stack", "save", segments[0])
-		} else if r.Method == http.MethodDelete {
-			HandleAction(w, "dc", "stack", "rm", segments[0])
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	} else if len(segments) == 0 {
-		if r.Method == http.MethodGet {
-			HandleAction(w, "dc", "stack", "ls")
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
+		if action == "up" {
+			args = append(args, queryFlags(r, map[string]string{
+				"pull":           "pull-before-up",
+				"min_free_disk":  "min-free-disk",
+				"skip_preflight": "skip-preflight",
+				"wait_healthy":   "wait-healthy",
+				"wait_timeout":   "wait-timeout",
+			})...)
+		}
+		handleMaybeStreamed(w, r, stackName, args)
+	}
+}
+
+// handleCopyStack returns the handler of POST /api/stacks/{stack}/rename and /clone
+func handleCopyStack(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req StackCopyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			httpError(w, r, "copy_name_required", http.StatusBadRequest)
+			return
+		}
+		args := append([]string{"stack", action, r.PathValue("stack"), req.Name, fmt.Sprintf("--recreate=%t", req.Recreate)}, mutationFlags(r, nil)...)
+		HandleAction(w, "dc", args...)
+	}
+}
+
+// handleSetStackMeta handles PUT /api/stacks/{stack}/meta: sets the group and autostart of a stack
+func handleSetStackMeta(w http.ResponseWriter, r *http.Request) {
+	var req StackMetaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "invalid_json", http.StatusBadRequest, err)
+		return
+	}
+	args := []string{"stack", "meta", r.PathValue("stack")}
+	if req.Group != nil {
+		args = append(args, "--group="+*req.Group)
+	}
+	if req.Autostart != nil {
+		args = append(args, fmt.Sprintf("--autostart=%t", *req.Autostart))
+	}
+	HandleAction(w, "dc", append(args, mutationFlags(r, nil)...)...)
+}
+
+// StackAdoptRequest is the body of POST /api/stacks/{stack}/adopt
+type StackAdoptRequest struct {
+	Containers []string `json:"containers"`        // names or IDs of the containers started by hand
+	Confirm    bool     `json:"confirm,omitempty"` // remove the original containers of an adopted stack instead
+	Force      bool     `json:"force,omitempty"`
+}
+
+// handleAdoptStack handles POST /api/stacks/{stack}/adopt: the containers become a new stack
+// and are recreated by compose. With confirm the original containers kept by an adoption are
+// removed.
+func handleAdoptStack(w http.ResponseWriter, r *http.Request) {
+	var req StackAdoptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (len(req.Containers) == 0 && !req.Confirm) {
+		httpError(w, r, "containers_required", http.StatusBadRequest)
+		return
+	}
+	stackName := r.PathValue("stack")
+	args := []string{"stack", "adopt", stackName}
+	if req.Confirm {
+		handleMaybeStreamed(w, r, stackName, append(append(args, "--confirm=true"), mutationFlags(r, nil)...))
+		return
+	}
+	for _, container := range req.Containers {
+		// container names and IDs start with a letter or digit, anything else would be a flag
+		if container == "" || strings.HasPrefix(container, "-") {
+			httpError(w, r, "containers_required", http.StatusBadRequest)
+			return
+		}
+		args = append(args, container)
+	}
+	args = append(args, fmt.Sprintf("--force=%t", req.Force))
+	handleMaybeStreamed(w, r, stackName, append(args, mutationFlags(r, nil)...))
+}
+
+// handleDownloadExport handles GET /api/stacks/{stack}/export: the bundle of a stack, or its
+// Kubernetes manifests with ?format=k8s
+func handleDownloadExport(w http.ResponseWriter, r *http.Request) {
+	stackName := r.PathValue("stack")
+	if r.URL.Query().Get("format") == "k8s" {
+		args := append([]string{"stack", "export", stackName, "--format=k8s"}, queryFlags(r, map[string]string{
+			"secret_values": "secret-values",
+		})...)
+		HandleDownloadAction(w, r, "application/yaml", stackName+".k8s.yaml", "dc", args...)
+		return
+	}
+	r, ok := withBundlePassphrase(w, r)
+	if !ok {
+		return
+	}
+	HandleDownloadAction(w, r, "application/gzip", stackName+".tar.gz", "dc", "stack", "export", stackName)
+}
+
+// handleUploadExport handles POST /api/stacks/{stack}/export, which uploads the bundle to the
+// configured storage target
+func handleUploadExport(w http.ResponseWriter, r *http.Request) {
+	stackName := r.PathValue("stack")
+	r, ok := withBundlePassphrase(w, r)
+	if !ok {
+		return
+	}
+	args := append([]string{"stack", "export", stackName, "--upload=true"}, mutationFlags(r, map[string]string{
+		"retention": "backup-retention",
+	})...)
+	handleMaybeStreamed(w, r, stackName, args)
+}
+
+// handleBackupStack handles POST /api/stacks/{stack}/backup
+func handleBackupStack(w http.ResponseWriter, r *http.Request) {
+	stackName := r.PathValue("stack")
+	handleMaybeStreamed(w, r, stackName, append([]string{"stack", "backup", stackName}, mutationFlags(r, map[string]string{
+		"retention": "backup-retention",
+	})...))
+}
+
+// handleListBackups handles GET /api/stacks/{stack}/backups
+func handleListBackups(w http.ResponseWriter, r *http.Request) {
+	HandleAction(w, "dc", append([]string{"stack", "backups", r.PathValue("stack")}, queryFlags(r, map[string]string{
+		"remote": "remote",
+	})...)...)
+}
+
+// handleRestoreStack handles POST /api/stacks/{stack}/restore
+func handleRestoreStack(w http.ResponseWriter, r *http.Request) {
+	stackName := r.PathValue("stack")
+	handleMaybeStreamed(w, r, stackName, append([]string{"stack", "restore", stackName}, mutationFlags(r, map[string]string{
+		"snapshot": "snapshot",
+		"remote":   "remote",
+	})...))
+}
+
+// handleRollbackStack handles POST /api/stacks/{stack}/rollback/{revision}
+func handleRollbackStack(w http.ResponseWriter, r *http.Request) {
+	HandleAction(w, "dc", append([]string{"stack", "rollback", r.PathValue("stack"), r.PathValue("revision")}, mutationFlags(r, nil)...)...)
+}
+
+// handleReconstructStack handles POST /api/stacks/{stack}/reconstruct, writing the stack file
+// reconstructed from the containers; an existing stack file is only replaced with ?force=true
+func handleReconstructStack(w http.ResponseWriter, r *http.Request) {
+	HandleAction(w, "dc", append([]string{"stack", "reconstruct", r.PathValue("stack"), "--write=true"}, mutationFlags(r, map[string]string{
+		"force": "force",
+	})...)...)
+}
+
+// handleChaos handles POST /api/stacks/{stack}/chaos/{experiment}
+func handleChaos(w http.ResponseWriter, r *http.Request) {
+	HandleAction(w, "dc", append([]string{"stack", "chaos", r.PathValue("stack"), r.PathValue("experiment")}, mutationFlags(r, map[string]string{
+		"container": "container",
+		"service":   "service",
+		"delay":     "delay",
+	})...)...)
+}
+
+// handleStackLogs handles GET /api/stacks/{stack}/logs. docker compose logs -f does not end, so
+// its output must be streamed.
+func handleStackLogs(w http.ResponseWriter, r *http.Request) {
+	stackName := r.PathValue("stack")
+	HandleStreamAction(w, r, stackName, "stack", "logs", stackName)
+}
+
+// handleImportBundle handles POST /api/stacks/import-bundle with a bundle as body, or with
+// ?remote=<stack>[/<export>] to import an export from the storage target
+func handleImportBundle(w http.ResponseWriter, r *http.Request) {
+	r, ok := withBundlePassphrase(w, r)
+	if !ok {
+		return
+	}
+	flags := mutationFlags(r, map[string]string{
+		"name":  "name",
+		"force": "force",
+	})
+	if ref := r.URL.Query().Get("remote"); ref != "" {
+		if !exportRefPattern.MatchString(ref) {
+			httpError(w, r, "invalid_export", http.StatusBadRequest, ref)
+			return
+		}
+		HandleActionWithStdin(w, r, nil, "dc", append([]string{"stack", "import-bundle", ref, "--remote=true"}, flags...)...)
+		return
+	}
+	HandleActionWithStdin(w, r, r.Body, "dc", append([]string{"stack", "import-bundle", "-"}, flags...)...)
+}
+
+// bundlePassphraseHeader carries the passphrase of a bundle, which must not be in the URL
+// where proxies, access logs and browser histories keep it
+const bundlePassphraseHeader = "X-Bundle-Passphrase"
+
+// commandEnvKey holds the environment variables for the dc command of a request
+type commandEnvKey struct{}
+
+// withCommandEnv passes environment variables to the dc command a request runs, for values
+// that must not be in its arguments, which any local user can read
+func withCommandEnv(r *http.Request, env ...string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), commandEnvKey{}, env))
+}
+
+// commandEnv returns the environment for the dc command of a request, nil to inherit dcapi's
+func commandEnv(r *http.Request) []string {
+	env, _ := r.Context().Value(commandEnvKey{}).([]string)
+	if len(env) == 0 {
+		return nil
+	}
+	return append(os.Environ(), env...)
+}
+
+// withBundlePassphrase hands the passphrase of the X-Bundle-Passphrase header to dc as
+// EXPORT_PASSPHRASE. A ?passphrase is refused rather than ignored, which would export the
+// secrets unencrypted.
+func withBundlePassphrase(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if r.URL.Query().Has("passphrase") {
+		httpError(w, r, "passphrase_in_query", http.StatusBadRequest, bundlePassphraseHeader)
+		return r, false
+	}
+	if passphrase := r.Header.Get(bundlePassphraseHeader); passphrase != "" {
+		return withCommandEnv(r, "EXPORT_PASSPHRASE="+passphrase), true
+	}
+	return r, true
+}
+
+// StackImportRequest is the JSON body of POST /api/stacks/import.
+// Either Path (a compose file or project directory on the server) or Content must be set.
+type StackImportRequest struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Content string `json:"content"`
+	Force   bool   `json:"force"`
+	Up      bool   `json:"up"`
+}
+
+// HandleImportStack handles POST /api/stacks/import with either a JSON body
+// or a multipart form (file field "file", optional fields "name", "force", "up")
+func HandleImportStack(w http.ResponseWriter, r *http.Request) {
+	var req StackImportRequest
+	var content io.Reader
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			httpError(w, r, "invalid_multipart", http.StatusBadRequest, err)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			httpError(w, r, "multipart_file_required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		content = file
+		req.Name = r.FormValue("name")
+		req.Force = r.FormValue("force") == "true"
+		req.Up = r.FormValue("up") == "true"
 	} else {
-		http.Error(w, "Not found "+path, http.StatusNotFound)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "invalid_json", http.StatusBadRequest, err)
+			return
+		}
+		if req.Content != "" {
+			content = strings.NewReader(req.Content)
+		} else if req.Path == "" {
+			httpError(w, r, "import_source_required", http.StatusBadRequest)
+			return
+		}
+	}
+
+	source := req.Path
+	if content != nil {
+		source = "-"
+	}
+	args := []string{"stack", "import", source, fmt.Sprintf("--force=%t", req.Force), fmt.Sprintf("--up=%t", req.Up)}
+	if req.Name != "" {
+		args = append(args, "--name="+req.Name)
+	}
+	args = append(args, mutationFlags(r, nil)...)
+
+	if content != nil {
+		HandleActionWithStdin(w, r, content, "dc", args...)
+	} else {
+		HandleAction(w, "dc", args...)
+	}
+}
+
+// HandleSummary handles GET /api/summary: stack/container counts, recent deployments and alerts
+func HandleSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	HandleAction(w, "dc", "summary")
+}
+
+// HandleCapacity handles GET /api/capacity: declared memory and CPUs by stack, budgets and
+// over-commit of the host
+func HandleCapacity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
 	}
+	HandleAction(w, "dc", "capacity")
 }
 
-// HandleSecretAPI routes secret API requests to appropriate handlers
-func HandleSecretAPI(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	path = strings.TrimPrefix(path, "/api/secrets")
-	path = strings.TrimPrefix(path, "/")
+// HandleGraph handles GET /api/graph: dependencies between stacks through external networks
+// and volumes, traefik routing and service hostnames (?format=dot|mermaid for a rendered graph)
+func HandleGraph(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	HandleAction(w, "dc", append([]string{"graph"}, queryFlags(r, map[string]string{"format": "format"})...)...)
+}
 
-	if path == "" {
-		if r.Method == http.MethodGet {
-			HandleAction(w, "dc", "secret", "ls")
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// TransformRequest is the JSON body of POST /api/transform
+type TransformRequest struct {
+	YAML  string   `json:"yaml"`
+	Steps []string `json:"steps"`
+}
+
+// HandleTransform handles POST /api/transform: it applies enrichment steps to compose YAML and
+// returns the result and warnings without reading or writing anything on the server. The body
+// is either a TransformRequest or the YAML itself with ?steps=a,b.
+func HandleTransform(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req TransformRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "invalid_json", http.StatusBadRequest, err)
+			return
 		}
+	} else {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			httpError(w, r, "internal_error", http.StatusInternalServerError)
+			return
+		}
+		req.YAML = string(body)
+		if steps := r.URL.Query().Get("steps"); steps != "" {
+			req.Steps = strings.Split(steps, ",")
+		}
+	}
+	if strings.TrimSpace(req.YAML) == "" {
+		httpError(w, r, "transform_yaml_required", http.StatusBadRequest)
 		return
 	}
 
-	// path is now the key name
-	key := path
-	switch r.Method {
-	case http.MethodGet:
-		HandleAction(w, "dc", "secret", "get", key)
-	case http.MethodPut:
-		HandleActionWithStdin(w, r.Body, "dc", "secret", "ups", key)
-	case http.MethodDelete:
-		HandleAction(w, "dc", "secret", "del", key)
+	cmd := exec.Command("dc", "transform", "--steps="+strings.Join(req.Steps, ","), "--lang="+requestLanguage(r))
+	cmd.Stdin = strings.NewReader(req.YAML)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	start := time.Now()
+	out, err := cmd.Output()
+	observeCommand(cmd.Args[1:], start, nil, err)
+	if err != nil {
+		// dc dies with the reason as its last line, e.g. an unknown step or invalid YAML
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		writeAPIError(w, http.StatusBadRequest, "transform_failed", redactText(lines[len(lines)-1]))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
+
+// ConvertRequest is the JSON body of POST /api/convert
+type ConvertRequest struct {
+	Command string `json:"command"`        // a docker run command line
+	Name    string `json:"name,omitempty"` // the stack name, default the service name
+}
+
+// ConvertResult is the response of POST /api/convert
+type ConvertResult struct {
+	YAML     string   `json:"yaml"`
+	Stack    string   `json:"stack"`
+	Warnings []string `json:"warnings"`
+}
+
+// HandleConvert handles POST /api/convert: it converts a docker run command into a stack file
+// without writing anything; the result is saved through POST /api/stacks/import. The body is
+// either a ConvertRequest or the command line itself with ?name=.
+func HandleConvert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req ConvertRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "invalid_json", http.StatusBadRequest, err)
+			return
+		}
+	} else {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			httpError(w, r, "internal_error", http.StatusInternalServerError)
+			return
+		}
+		req.Command = string(body)
+		req.Name = r.URL.Query().Get("name")
+	}
+	if strings.TrimSpace(req.Command) == "" {
+		httpError(w, r, "convert_command_required", http.StatusBadRequest)
+		return
+	}
+
+	// --env-file would read a file of this host, e.g. prod.env, into the response
+	args := []string{"convert", "run", "--output-format=json", "--allow-env-file=false", "--lang=" + requestLanguage(r)}
+	if req.Name != "" {
+		args = append(args, "--name="+req.Name)
+	}
+	cmd := exec.Command("dc", args...)
+	cmd.Stdin = strings.NewReader(req.Command)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	start := time.Now()
+	out, err := cmd.Output()
+	observeCommand(cmd.Args[1:], start, nil, err)
+	if err != nil {
+		// dc dies with the reason as its last line, e.g. an unsupported option
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		writeAPIError(w, http.StatusBadRequest, "convert_failed", redactText(lines[len(lines)-1]))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
+
+// HandleLint handles POST /api/lint for webhook checks of git servers and CI: the body is a
+// compose file, the response the JSON list of findings (schema, lint and secret scan). The
+// status is 422 if any finding is an error, so that a check can fail on the status alone.
+func HandleLint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cmd := exec.Command("dc", "lint", "-", "--output-format=json", "--lang="+requestLanguage(r))
+	cmd.Stdin = r.Body
+	start := time.Now()
+	out, err := cmd.Output()
+	observeCommand(cmd.Args[1:], start, nil, err)
+	if !json.Valid(out) {
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	_, _ = w.Write(out)
+}
+
+// mutationFlags returns the dc flags of a state-changing request: the given query parameters,
+// ?dry_run=true (dc then reports planned changes instead of applying them), the language for
+// dc's messages and the acting principal, which dc records e.g. as the author of stacks-dir
+// git commits
+func mutationFlags(r *http.Request, params map[string]string) []string {
+	all := map[string]string{"dry_run": "dry-run"}
+	for param, flag := range params {
+		all[param] = flag
+	}
+	flags := append(queryFlags(r, all), "--lang="+requestLanguage(r))
+	if principal := principalFromRequest(r); principal != nil {
+		flags = append(flags, "--actor="+principal.Name)
+	}
+	return flags
+}
+
+// listFilterParams are the query parameters filtering and paging a list, passed on to dc
+// as flags of the same name, plus the extra parameters given
+func listFilterParams(extra ...string) map[string]string {
+	params := map[string]string{}
+	for _, param := range append([]string{"name", "label", "state", "group", "limit", "offset"}, extra...) {
+		params[param] = param
+	}
+	return params
+}
+
+// HandleContainersAPI handles GET /api/containers: all containers from a single docker ps,
+// filtered by ?name, ?label, ?state, ?group and ?stack and paged by ?limit and ?offset
+func HandleContainersAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	HandleAction(w, "dc", append([]string{"container", "ls"}, queryFlags(r, listFilterParams("stack"))...)...)
+}
+
+// HandleContainerInspect handles GET /api/containers/{id}: the inspect data of a container, with
+// the environment values of sensitive keys masked
+func HandleContainerInspect(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !containerIDPattern.MatchString(id) {
+		httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+		return
+	}
+	HandleAction(w, "dc", "container", "inspect", id)
+}
+
+// handleMaybeStreamed runs a dc action. With ?stream=true its output is streamed while it runs,
+// with ?async=true it answers right away with a job to follow at /api/jobs/{id}. Otherwise the
+// action is stopped if the client disconnects before it is done.
+func handleMaybeStreamed(w http.ResponseWriter, r *http.Request, stackName string, args []string) {
+	switch {
+	case r.URL.Query().Get("async") == "true":
+		HandleJobAction(w, r, stackName, args)
+	case r.URL.Query().Get("stream") == "true":
+		HandleStreamJob(w, r, stackName, args)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		HandleRequestAction(w, r, args...)
+	}
+}
+
+// queryFlags translates selected query parameters into dc flags,
+// e.g. ?pull=true with {"pull": "pull-before-up"} becomes --pull-before-up=true
+func queryFlags(r *http.Request, params map[string]string) []string {
+	keys := make([]string, 0, len(params))
+	for param := range params {
+		keys = append(keys, param)
+	}
+	sort.Strings(keys)
+
+	var flags []string
+	query := r.URL.Query()
+	for _, param := range keys {
+		if value := query.Get(param); value != "" {
+			flags = append(flags, "--"+params[param]+"="+value)
+		}
 	}
+	return flags
 }
 
 func HandleAction(w http.ResponseWriter, c string, args ...string) {
 	cmd := exec.Command(c, args...)
 	cmd.Stdin = os.Stdin
+	start := time.Now()
 	out, err := cmd.CombinedOutput()
+	observeCommand(args, start, out, err)
 	if err != nil {
-		http.Error(w, string(out), http.StatusInternalServerError)
+		writeAPIError(w, http.StatusInternalServerError, "command_failed", redactText(string(out)))
 		return
 	}
 	w.Header().Set("Content-Type", "text/plain")
 	_, _ = w.Write(out)
 }
 
-func HandleActionWithStdin(w http.ResponseWriter, stdin io.Reader, c string, args ...string) {
+func HandleActionWithStdin(w http.ResponseWriter, r *http.Request, stdin io.Reader, c string, args ...string) {
 	cmd := exec.Command(c, args...)
 	cmd.Stdin = stdin
+	cmd.Env = commandEnv(r)
+	start := time.Now()
 	out, err := cmd.CombinedOutput()
+	observeCommand(args, start, out, err)
 	if err != nil {
-		http.Error(w, string(out), http.StatusInternalServerError)
+		writeAPIError(w, http.StatusInternalServerError, "command_failed", redactText(string(out)))
 		return
 	}
 	w.Header().Set("Content-Type", "text/plain")
 	_, _ = w.Write(out)
 }
+
+// HandleDownloadAction runs a command and sends its stdout as a file download.
+// Unlike HandleAction, stderr is kept out of the body so binary output stays intact.
+func HandleDownloadAction(w http.ResponseWriter, r *http.Request, contentType, filename string, c string, args ...string) {
+	cmd := exec.Command(c, args...)
+	cmd.Env = commandEnv(r)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	start := time.Now()
+	out, err := cmd.Output()
+	observeCommand(args, start, stderr.Bytes(), err)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "command_failed", redactText(stderr.String()))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job states
+const (
+	JobRunning     = "running"
+	JobSucceeded   = "succeeded"
+	JobFailed      = "failed"
+	JobCancelled   = "cancelled"   // stopped by DELETE /api/jobs/{id}
+	JobInterrupted = "interrupted" // dcapi stopped while the job ran
+)
+
+// defaultJobRetain is how many finished jobs are kept (config key job_retain)
+const defaultJobRetain = 100
+
+// Job is a dc action run in the background: every streamed action and every action requested
+// with ?async=true. Jobs are kept in the state store, so their status and output outlive both
+// the request that started them and a restart of dcapi. The job id is also the resume token
+// of the operation running it.
+type Job struct {
+	ID         string        `json:"id"`
+	Stack      string        `json:"stack"`
+	Action     string        `json:"action"` // e.g. "stack up"; flags are left out as they may hold secrets
+	Principal  string        `json:"principal,omitempty"`
+	Status     string        `json:"status"`
+	ExitCode   int           `json:"exitCode"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	FinishedAt *time.Time    `json:"finishedAt,omitempty"`
+	LogDropped int           `json:"logDropped,omitempty"` // lines dropped from the front of Log
+	Log        []OutputFrame `json:"log,omitempty"`
+}
+
+// jobAction returns the dc command of the arguments without stack names and flags
+func jobAction(args []string) string {
+	var action []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") || len(action) == 2 {
+			break
+		}
+		action = append(action, arg)
+	}
+	return strings.Join(action, " ")
+}
+
+// trackedJobs counts the jobs whose outcome is still to be recorded, which shutdown waits for
+var trackedJobs sync.WaitGroup
+
+// startJob runs a dc action as a job and records it until it finishes
+func startJob(r *http.Request, stackName string, args []string) (*operation, *Job, error) {
+	op, err := startOperation(stackName, args, commandEnv(r))
+	if err != nil {
+		return nil, nil, err
+	}
+	job := &Job{ID: op.ID, Stack: stackName, Action: jobAction(args), Status: JobRunning, CreatedAt: time.Now().UTC()}
+	if principal := principalFromRequest(r); principal != nil {
+		job.Principal = principal.Name
+	}
+	saveJob(job)
+	trackedJobs.Add(1)
+	go func() {
+		defer trackedJobs.Done()
+		trackJob(op, *job)
+	}()
+	return op, job, nil
+}
+
+// trackJob records the outcome and output of a job once its operation is done
+func trackJob(op *operation, job Job) {
+	for {
+		_, _, done, changed := op.since(0)
+		if done {
+			break
+		}
+		<-changed
+	}
+	op.mu.Lock()
+	job.ExitCode = op.exitCode
+	job.Log = append([]OutputFrame(nil), op.lines...)
+	job.LogDropped = op.base
+	finishedAt := op.doneAt.UTC()
+	cancelled, interrupted := op.cancelled, op.interrupted
+	op.mu.Unlock()
+	job.FinishedAt = &finishedAt
+	switch {
+	case interrupted:
+		job.Status = JobInterrupted
+	case cancelled:
+		job.Status = JobCancelled
+	case job.ExitCode != 0:
+		job.Status = JobFailed
+	default:
+		job.Status = JobSucceeded
+	}
+	saveJob(&job)
+	trimJobs()
+}
+
+// saveJob writes a job to the state store
+func saveJob(job *Job) {
+	if err := stateStore().Put(bucketJobs, job.ID, job); err != nil {
+		jobsLog.Error("Error saving job", "job", job.ID, "err", err)
+	}
+}
+
+// loadJobs returns the stored jobs, newest first
+func loadJobs() ([]Job, error) {
+	jobs := []Job{}
+	err := stateStore().ForEach(bucketJobs, func(key string, value json.RawMessage) error {
+		var job Job
+		if err := json.Unmarshal(value, &job); err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+		return nil
+	})
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs, err
+}
+
+// trimJobs drops the oldest finished jobs beyond job_retain
+func trimJobs() {
+	retain := streamConfigInt("job_retain", defaultJobRetain)
+	jobs, err := loadJobs()
+	if err != nil {
+		jobsLog.Error("Error trimming jobs", "err", err)
+		return
+	}
+	var expired []string
+	kept := 0
+	for _, job := range jobs {
+		if job.Status == JobRunning {
+			continue
+		}
+		if kept++; kept > retain {
+			expired = append(expired, job.ID)
+		}
+	}
+	if len(expired) > 0 {
+		if err := stateStore().Delete(bucketJobs, expired...); err != nil {
+			jobsLog.Error("Error trimming jobs", "err", err)
+		}
+	}
+}
+
+// RecoverJobs marks the jobs that were running when dcapi stopped as interrupted
+func RecoverJobs() {
+	jobs, err := loadJobs()
+	if err != nil {
+		jobsLog.Error("Error loading jobs", "err", err)
+		return
+	}
+	for _, job := range jobs {
+		if job.Status == JobRunning {
+			job := job
+			job.Status = JobInterrupted
+			job.ExitCode = -1
+			saveJob(&job)
+		}
+	}
+}
+
+// jobOperation returns the operation of a job: the running or recently finished one, or one
+// replaying the stored output of an older job
+func jobOperation(id string) (*operation, *Job, bool) {
+	var job Job
+	if ok, err := stateStore().Get(bucketJobs, id, &job); err != nil || !ok {
+		return nil, nil, false
+	}
+	operationsMu.Lock()
+	op, ok := operations[id]
+	operationsMu.Unlock()
+	if ok {
+		return op, &job, true
+	}
+	op = &operation{ID: job.ID, Stack: job.Stack, lines: job.Log, base: job.LogDropped,
+		done: true, exitCode: job.ExitCode, changed: make(chan struct{})}
+	return op, &job, true
+}
+
+// withLiveLog fills in the output of a running job from its operation
+func withLiveLog(job *Job, op *operation) {
+	if job.Status != JobRunning {
+		return
+	}
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	job.Log = append([]OutputFrame(nil), op.lines...)
+	job.LogDropped = op.base
+}
+
+// HandleJobAction starts a dc action as a job and answers 202 with the job right away
+func HandleJobAction(w http.ResponseWriter, r *http.Request, stackName string, args []string) {
+	_, job, err := startJob(r, stackName, args)
+	if err != nil {
+		jobsLog.Error("Error starting job", "stack", stackName, "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", basePath()+apiVersionPrefix+"/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// HandleStreamJob starts a dc action as a job and streams its output while it runs
+func HandleStreamJob(w http.ResponseWriter, r *http.Request, stackName string, args []string) {
+	op, _, err := startJob(r, stackName, args)
+	if err != nil {
+		jobsLog.Error("Error starting streamed action", "stack", stackName, "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+	streamOperation(w, r, op, 0)
+}
+
+// jobVisible reports whether the caller may see a job: tokens and service accounts only see
+// the jobs of stacks they may read
+func jobVisible(r *http.Request, job *Job) bool {
+	principal := principalFromRequest(r)
+	return principal != nil && principal.Allows(http.MethodGet, "/api/stacks/"+job.Stack)
+}
+
+// HandleJobsAPI serves the jobs:
+// GET /api/jobs lists them without output, newest first (?stack=, ?limit=, default 50),
+// GET /api/jobs/{id} returns a job with its buffered output and
+// GET /api/jobs/{id}/stream?from=<lines received> attaches to its output like a streamed action and
+// DELETE /api/jobs/{id} cancels a running job: SIGTERM to its processes, SIGKILL after cancel_grace.
+func HandleJobsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs"), "/"), "/")
+
+	if segments[0] == "" {
+		if r.Method != http.MethodGet {
+			httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		jobs, err := loadJobs()
+		if err != nil {
+			jobsLog.Error("Error loading jobs", "err", err)
+			httpError(w, r, "internal_error", http.StatusInternalServerError)
+			return
+		}
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 50
+		}
+		stack := r.URL.Query().Get("stack")
+		list := []Job{}
+		for _, job := range jobs {
+			if len(list) == limit {
+				break
+			}
+			if (stack == "" || job.Stack == stack) && jobVisible(r, &job) {
+				job.Log = nil
+				list = append(list, job)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	op, job, ok := jobOperation(segments[0])
+	if !ok || !jobVisible(r, job) || len(segments) > 2 || (len(segments) == 2 && segments[1] != "stream") {
+		httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		if len(segments) != 1 || !op.Cancel() {
+			httpError(w, r, "job_not_running", http.StatusConflict, job.ID)
+			return
+		}
+		jobsLog.Info("Job cancelled", "job", job.ID, "action", job.Action, "stack", job.Stack)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if len(segments) == 2 {
+		streamOperation(w, r, op, streamOffset(r))
+		return
+	}
+	withLiveLog(job, op)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// logShipTypes are the destinations dc can ship container logs to
+var logShipTypes = []string{"loki", "elasticsearch"}
+
+// LoggingSettings configures shipping the logs of the containers of the stacks, which dcapi
+// runs `dc logs forward` for. dc reads them from the same file.
+type LoggingSettings struct {
+	Enabled  bool              `yaml:"enabled" json:"enabled"`
+	Type     string            `yaml:"type" json:"type"` // loki or elasticsearch
+	URL      string            `yaml:"url" json:"url"`   // Loki push endpoint or Elasticsearch address
+	Index    string            `yaml:"index,omitempty" json:"index,omitempty"`
+	Username string            `yaml:"username,omitempty" json:"username,omitempty"`
+	Password string            `yaml:"password,omitempty" json:"password,omitempty"`
+	Token    string            `yaml:"token,omitempty" json:"token,omitempty"`
+	Labels   map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Stacks   []string          `yaml:"stacks,omitempty" json:"stacks,omitempty"`
+}
+
+var (
+	loggingSettingsMu sync.Mutex
+
+	// logForwarder is the running `dc logs forward`, nil if there is none
+	logForwarder   *exec.Cmd
+	logForwarderMu sync.Mutex
+
+	// logForwarderReload wakes RunLogForwarder up when the settings changed
+	logForwarderReload = make(chan struct{}, 1)
+)
+
+// getLoggingSettingsPath returns the file holding the log shipping settings (config key
+// logging_file, shared with dc, by default in dc's settings dir)
+func getLoggingSettingsPath() (string, error) {
+	return settingsFilePath("logging_file", "logging.yml")
+}
+
+// loadLoggingSettings reads the log shipping settings
+func loadLoggingSettings() (LoggingSettings, error) {
+	var settings LoggingSettings
+	path, err := getLoggingSettingsPath()
+	if err != nil {
+		return settings, err
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return settings, nil
+	} else if err != nil {
+		return settings, err
+	}
+	if err := yaml.Unmarshal(content, &settings); err != nil {
+		return settings, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return settings, nil
+}
+
+// saveLoggingSettings writes the log shipping settings; the caller must hold loggingSettingsMu
+func saveLoggingSettings(settings LoggingSettings) error {
+	content, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	path, err := getLoggingSettingsPath()
+	if err != nil {
+		return err
+	}
+	return writeSettingsFile(path, content)
+}
+
+// redactedLoggingSettings hides the credentials in API responses
+func redactedLoggingSettings(settings LoggingSettings) LoggingSettings {
+	for _, secret := range []*string{&settings.Password, &settings.Token} {
+		if *secret != "" {
+			*secret = redactedSecret
+		}
+	}
+	return settings
+}
+
+// validateLoggingSettings checks the settings and puts back the stored credentials if they
+// were sent redacted
+func validateLoggingSettings(settings *LoggingSettings, stored LoggingSettings) error {
+	if settings.Password == redactedSecret {
+		settings.Password = stored.Password
+	}
+	if settings.Token == redactedSecret {
+		settings.Token = stored.Token
+	}
+	if !settings.Enabled && settings.Type == "" && settings.URL == "" {
+		return nil
+	}
+	if !matchesFilter(logShipTypes, settings.Type) {
+		return fmt.Errorf("type must be one of %s", strings.Join(logShipTypes, ", "))
+	}
+	if !strings.HasPrefix(settings.URL, "http") {
+		return fmt.Errorf("an http(s) url is required")
+	}
+	if settings.Index != "" && settings.Type != "elasticsearch" {
+		return fmt.Errorf("index only applies to elasticsearch")
+	}
+	for key := range settings.Labels {
+		switch key {
+		case "stack", "service", "container", "stream":
+			return fmt.Errorf("label %s is set by dc", key)
+		}
+	}
+	return nil
+}
+
+// HandleLoggingSettings handles GET and PUT /api/settings/logging: where container logs are
+// shipped to, credentials redacted. Saving restarts the forwarder. Like the DNS settings, they
+// can only be managed by interactive users.
+func HandleLoggingSettings(w http.ResponseWriter, r *http.Request) {
+	principal := principalFromRequest(r)
+	if principal == nil || principal.ServiceAccount != nil || principal.Token != nil {
+		httpError(w, r, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	loggingSettingsMu.Lock()
+	defer loggingSettingsMu.Unlock()
+	stored, err := loadLoggingSettings()
+	if err != nil {
+		configLog.Error("Error loading logging settings", "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+
+	settings := stored
+	if r.Method == http.MethodPut {
+		settings = LoggingSettings{}
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			httpError(w, r, "invalid_json", http.StatusBadRequest, err)
+			return
+		}
+		if err := validateLoggingSettings(&settings, stored); err != nil {
+			httpError(w, r, "logging_settings_invalid", http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := saveLoggingSettings(settings); err != nil {
+			configLog.Error("Error saving logging settings", "err", err)
+			httpError(w, r, "internal_error", http.StatusInternalServerError)
+			return
+		}
+		configLog.Info("User updated logging settings", "user", principal.Name, "enabled", settings.Enabled, "type", settings.Type)
+		reloadLogForwarder()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(redactedLoggingSettings(settings))
+}
+
+// stopLogForwarder stops the running forwarder, if any
+func stopLogForwarder() {
+	logForwarderMu.Lock()
+	defer logForwarderMu.Unlock()
+	if logForwarder != nil && logForwarder.Process != nil {
+		syscall.Kill(-logForwarder.Process.Pid, syscall.SIGTERM)
+	}
+}
+
+// reloadLogForwarder stops the running forwarder and has RunLogForwarder start it again with
+// the current settings
+func reloadLogForwarder() {
+	stopLogForwarder()
+	select {
+	case logForwarderReload <- struct{}{}:
+	default:
+	}
+}
+
+// RunLogForwarder keeps `dc logs forward` running while log shipping is enabled in the logging
+// settings, and restarts it when they change
+func RunLogForwarder() {
+	for {
+		loggingSettingsMu.Lock()
+		settings, err := loadLoggingSettings()
+		loggingSettingsMu.Unlock()
+		if err != nil {
+			forwarderLog.Error("Error loading logging settings", "err", err)
+		}
+		if err != nil || !settings.Enabled {
+			select {
+			case <-logForwarderReload:
+			case <-time.After(time.Minute):
+			}
+			continue
+		}
+
+		cmd := exec.Command("dc", "logs", "forward")
+		// Own process group, so that stopping also ends the docker logs started by dc
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		stderr, err := cmd.StderrPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			forwarderLog.Error("Error starting log forwarder", "err", err)
+		} else {
+			logForwarderMu.Lock()
+			logForwarder = cmd
+			logForwarderMu.Unlock()
+			forwarderLog.Info("Log forwarder started", "type", settings.Type)
+			scanner := bufio.NewScanner(stderr)
+			for scanner.Scan() {
+				forwarderLog.Info("Log forwarder output", "line", scanner.Text())
+			}
+			err := cmd.Wait()
+			logForwarderMu.Lock()
+			logForwarder = nil
+			logForwarderMu.Unlock()
+			forwarderLog.Warn("Log forwarder exited", "err", err)
+		}
+		select {
+		case <-logForwarderReload:
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
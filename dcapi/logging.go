@@ -0,0 +1,39 @@
+package main
+
+import (
+	"dc/logging"
+)
+
+// rootLog is where all loggers write; initLogging configures it. Console lines carry the time
+// like the output of the log package did.
+var rootLog = logging.NewSink(logging.Options{Timestamps: true})
+
+// Loggers of the components of dcapi
+var (
+	assetsLog        = rootLog.Logger("assets")
+	auditLog         = rootLog.Logger("audit")
+	authLog          = rootLog.Logger("auth")
+	autostartLog     = rootLog.Logger("autostart")
+	configLog        = rootLog.Logger("config")
+	devicesLog       = rootLog.Logger("devices")
+	driftLog         = rootLog.Logger("drift")
+	eventsLog        = rootLog.Logger("events")
+	execLog          = rootLog.Logger("exec")
+	filesLog         = rootLog.Logger("files")
+	forwarderLog     = rootLog.Logger("forwarder")
+	httpLog          = rootLog.Logger("http")
+	jobsLog          = rootLog.Logger("jobs")
+	metricsLog       = rootLog.Logger("metrics")
+	notificationsLog = rootLog.Logger("notifications")
+	serverLog        = rootLog.Logger("server")
+	statsLog         = rootLog.Logger("stats")
+	thumbnailsLog    = rootLog.Logger("thumbnails")
+	watchLog         = rootLog.Logger("watch")
+	websocketLog     = rootLog.Logger("websocket")
+)
+
+// initLogging applies log_level (debug, info, warn or error), log_format and the --verbose and
+// --quiet flags, redacts secrets from the log and routes the log package through slog
+func initLogging() {
+	rootLog.Setup(getConfig("log_format", logging.FormatConsole), getConfig("log_level", "info"), redactText)
+}
@@ -1,22 +1,43 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"os"
 )
 
 func main() {
+	initRedaction()
+	initLogging()
+
+	sessionStore.Restore()
+	RecoverJobs()
 	go SessionCleanup()
 	go HandleBroadcast()
-	// go WatchFiles()
+	go RunDeviceWatcher()
+	go RunEventSubscriber()
+	go RunUpdateChecker()
+	go RunUsageCollector()
+	go RunAutostart()
+	go RunDriftReconciler()
+	go RunLogForwarder()
+	go WatchFiles()
 
 	go RegisterHTTPHandlers()
 
+	if readOnlyMode() {
+		serverLog.Info("Read-only mode: requests changing anything are refused")
+	}
 	port := getConfig("port", "8882")
 	addr := getConfig("addr", "0.0.0.0")
 	listenAddr := fmt.Sprintf("%s:%s", addr, port)
 
-	log.Printf("Server running on http://%s:%s", addr, port)
-	log.Fatal(http.ListenAndServe(listenAddr, nil))
+	server := newServer(listenAddr)
+	stopped := handleSignals(server)
+	if err := ListenAndServe(server); !errors.Is(err, http.ErrServerClosed) {
+		serverLog.Error("Server stopped", "err", err)
+		os.Exit(1)
+	}
+	<-stopped
 }
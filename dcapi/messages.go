@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is used when neither Accept-Language nor the lang setting matches a catalog
+const defaultLanguage = "en"
+
+// messages is the catalog of user-facing API messages by language and key.
+// To add a language, add a map with the same keys; missing keys fall back to English.
+var messages = map[string]map[string]string{
+	"en": {
+		"method_not_allowed":       "Method not allowed",
+		"not_found":                "Not found %s",
+		"job_not_running":          "Job %s is not running",
+		"forbidden":                "Forbidden",
+		"read_only":                "dcapi runs in read-only mode",
+		"unauthorized":             "401 Unauthorized",
+		"internal_error":           "Internal server error",
+		"basic_auth_required":      "Basic authentication required",
+		"invalid_credentials":      "Invalid credentials",
+		"auth_header_required":     "Authorization header required",
+		"invalid_auth_format":      "Invalid authorization format",
+		"invalid_token":            "Invalid token",
+		"invalid_json":             "Invalid JSON body: %v",
+		"invalid_multipart":        "Invalid multipart form: %v",
+		"multipart_file_required":  "Multipart form requires a \"file\" field",
+		"import_source_required":   "Either \"path\" or \"content\" is required",
+		"invalid_export":           "Invalid export reference %q, expected <stack>[/<export>]",
+		"resource_name_required":   "Request body must be JSON with a valid \"name\"",
+		"bulk_request_invalid":     "Request body must be JSON with an \"action\" (start, stop, up, down, update) and either \"names\", a \"selector\", a \"group\" or \"all\"",
+		"copy_name_required":       "Request body must be JSON with a non-empty \"name\"",
+		"services_invalid":         "Request body must be JSON with \"services\", a list of service names",
+		"secret_name_invalid":      "Invalid secret name %q, expected letters, digits and underscores",
+		"secret_value_invalid":     "The secret value must be a non-empty single line",
+		"secret_exists":            "Secret %s already exists",
+		"image_name_required":      "Image name is required",
+		"url_param_required":       "Query parameter url is required",
+		"thumbnail_fetch_failed":   "Failed to fetch thumbnail",
+		"thumbnail_download_fail":  "Failed to download thumbnail",
+		"asset_fetch_failed":       "Failed to fetch asset",
+		"token_fields_required":    "A token requires a \"name\" and at least one scope",
+		"unknown_scope":            "Unknown scope %q (known scopes: %s)",
+		"transform_yaml_required":  "Compose YAML is required",
+		"convert_command_required": "A docker run command is required",
+		"passphrase_in_query":      "The passphrase must not be in the URL, send it in the %s header",
+		"containers_required":      "A \"containers\" list of container names or IDs is required",
+		"webhook_fields_required":  "A webhook requires a \"name\", an http(s) \"url\" and a type of: %s",
+		"unknown_event":            "Unknown event %q (known events: %s)",
+		"dns_settings_invalid":     "Invalid DNS settings: %s",
+		"logging_settings_invalid": "Invalid logging settings: %s",
+		"status_title":             "Service status",
+		"status_up":                "Up",
+		"status_degraded":          "Degraded",
+		"status_down":              "Down",
+		"status_since":             "since %s",
+		"status_empty":             "No services are published.",
+	},
+	"de": {
+		"method_not_allowed":       "Methode nicht erlaubt",
+		"not_found":                "Nicht gefunden: %s",
+		"job_not_running":          "Job %s läuft nicht",
+		"forbidden":                "Zugriff verweigert",
+		"read_only":                "dcapi läuft im Nur-Lese-Modus",
+		"unauthorized":             "401 Nicht autorisiert",
+		"internal_error":           "Interner Serverfehler",
+		"basic_auth_required":      "Basic-Authentifizierung erforderlich",
+		"invalid_credentials":      "Ungültige Anmeldedaten",
+		"auth_header_required":     "Authorization-Header erforderlich",
+		"invalid_auth_format":      "Ungültiges Authorization-Format",
+		"invalid_token":            "Ungültiges Token",
+		"invalid_json":             "Ungültiger JSON-Body: %v",
+		"invalid_multipart":        "Ungültiges Multipart-Formular: %v",
+		"multipart_file_required":  "Das Multipart-Formular benötigt ein Feld \"file\"",
+		"import_source_required":   "Entweder \"path\" oder \"content\" ist erforderlich",
+		"invalid_export":           "Ungültige Export-Referenz %q, erwartet wird <stack>[/<export>]",
+		"resource_name_required":   "Der Body muss JSON mit einem gültigen \"name\" sein",
+		"bulk_request_invalid":     "Der Body muss JSON mit einer \"action\" (start, stop, up, down, update) und entweder \"names\", einem \"selector\", einer \"group\" oder \"all\" sein",
+		"copy_name_required":       "Der Body muss JSON mit einem nicht leeren \"name\" sein",
+		"services_invalid":         "Der Body muss JSON mit \"services\", einer Liste von Dienstnamen, sein",
+		"secret_name_invalid":      "Ungültiger Secret-Name %q, erlaubt sind Buchstaben, Ziffern und Unterstriche",
+		"secret_value_invalid":     "Der Wert des Secrets muss eine nicht leere einzelne Zeile sein",
+		"secret_exists":            "Secret %s existiert bereits",
+		"image_name_required":      "Image-Name ist erforderlich",
+		"url_param_required":       "Der Query-Parameter url ist erforderlich",
+		"thumbnail_fetch_failed":   "Vorschaubild konnte nicht abgerufen werden",
+		"thumbnail_download_fail":  "Vorschaubild konnte nicht heruntergeladen werden",
+		"asset_fetch_failed":       "Asset konnte nicht abgerufen werden",
+		"token_fields_required":    "Ein Token benötigt einen \"name\" und mindestens einen Scope",
+		"unknown_scope":            "Unbekannter Scope %q (bekannte Scopes: %s)",
+		"transform_yaml_required":  "Compose-YAML ist erforderlich",
+		"convert_command_required": "Ein docker-run-Befehl ist erforderlich",
+		"passphrase_in_query":      "Die Passphrase darf nicht in der URL stehen, sie gehört in den Header %s",
+		"containers_required":      "Eine Liste \"containers\" mit Containernamen oder -IDs ist erforderlich",
+		"webhook_fields_required":  "Ein Webhook benötigt einen \"name\", eine http(s)-\"url\" und einen Typ aus: %s",
+		"unknown_event":            "Unbekanntes Ereignis %q (bekannte Ereignisse: %s)",
+		"dns_settings_invalid":     "Ungültige DNS-Einstellungen: %s",
+		"logging_settings_invalid": "Ungültige Logging-Einstellungen: %s",
+		"status_title":             "Dienststatus",
+		"status_up":                "Verfügbar",
+		"status_degraded":          "Eingeschränkt",
+		"status_down":              "Nicht verfügbar",
+		"status_since":             "seit %s",
+		"status_empty":             "Es sind keine Dienste veröffentlicht.",
+	},
+}
+
+// requestLanguage picks the catalog language for a request from its Accept-Language header
+// (highest quality first), falling back to the lang setting and then English
+func requestLanguage(r *http.Request) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+	var candidates []candidate
+	if r != nil {
+		for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+			tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+			primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+			if _, ok := messages[primary]; ok && q > 0 {
+				candidates = append(candidates, candidate{primary, q})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	if len(candidates) > 0 {
+		return candidates[0].lang
+	}
+	if lang := strings.ToLower(getConfig("lang", defaultLanguage)); messages[lang] != nil {
+		return lang
+	}
+	return defaultLanguage
+}
+
+// msg returns the catalog message for key in the request's language, formatted with args
+func msg(r *http.Request, key string, args ...interface{}) string {
+	format, ok := messages[requestLanguage(r)][key]
+	if !ok {
+		if format, ok = messages[defaultLanguage][key]; !ok {
+			format = key
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// httpError replies with an APIError carrying the localized catalog message for key
+func httpError(w http.ResponseWriter, r *http.Request, key string, status int, args ...interface{}) {
+	w.Header().Set("Content-Language", requestLanguage(r))
+	writeAPIError(w, status, key, msg(r, key, args...))
+}
+
+// APIError is the body of every error response
+type APIError struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+// APIErrorDetail tells clients what went wrong: Code is a stable key to branch on, Message is
+// meant for humans and may be localized or be the output of a failed dc command
+type APIErrorDetail struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Status    int    `json:"status"`
+	RequestID string `json:"request_id,omitempty"` // to find the request in the logs
+}
+
+// writeAPIError writes an error response with an APIError body
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIError{Error: APIErrorDetail{Code: code, Message: message, Status: status, RequestID: w.Header().Get(requestIDHeader)}})
+}
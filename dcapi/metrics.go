@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the histogram upper bounds in seconds for API requests and compose actions
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// histogram is a cumulative Prometheus histogram for one label set
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// metricFamily holds the series of one metric keyed by their rendered label set
+type metricFamily struct {
+	name, help, kind string
+	counters         map[string]float64
+	histograms       map[string]*histogram
+}
+
+var (
+	metricsMu sync.Mutex
+
+	httpRequestDuration = &metricFamily{name: "composectl_http_request_duration_seconds", kind: "histogram",
+		help: "Latency of API requests by route, method and status code."}
+	actionDuration = &metricFamily{name: "composectl_action_duration_seconds", kind: "histogram",
+		help: "Duration of dc actions run by the API."}
+	actionsTotal = &metricFamily{name: "composectl_actions_total", kind: "counter",
+		help: "dc actions run by the API by exit code."}
+	secretsGenerated = &metricFamily{name: "composectl_secrets_generated_total", kind: "counter",
+		help: "Secrets generated by actions run through the API."}
+	websocketDropped = &metricFamily{name: "composectl_websocket_dropped_messages_total", kind: "counter",
+		help: "Broadcast messages dropped because a websocket client's queue was full."}
+	websocketEvicted = &metricFamily{name: "composectl_websocket_evicted_clients_total", kind: "counter",
+		help: "Websocket clients disconnected for being too slow or not answering pings."}
+)
+
+// labels renders label pairs (name, value, name, value, ...) in exposition format
+func labels(pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%s", pairs[i], strconv.Quote(pairs[i+1])))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *metricFamily) add(labelSet string, v float64) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if m.counters == nil {
+		m.counters = make(map[string]float64)
+	}
+	m.counters[labelSet] += v
+}
+
+func (m *metricFamily) observe(labelSet string, v float64) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if m.histograms == nil {
+		m.histograms = make(map[string]*histogram)
+	}
+	h, ok := m.histograms[labelSet]
+	if !ok {
+		h = &histogram{}
+		m.histograms[labelSet] = h
+	}
+	h.observe(v)
+}
+
+// withLabel joins an existing label set with one more label
+func withLabel(labelSet, label string) string {
+	if labelSet == "" {
+		return label
+	}
+	return labelSet + "," + label
+}
+
+// write renders the family in Prometheus text format; the caller must hold metricsMu
+func (m *metricFamily) write(sb *strings.Builder) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	keys := make([]string, 0, len(m.counters)+len(m.histograms))
+	for k := range m.counters {
+		keys = append(keys, k)
+	}
+	for k := range m.histograms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if h, ok := m.histograms[k]; ok {
+			var cumulative uint64
+			for i, bound := range latencyBuckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(sb, "%s_bucket{%s} %d\n", m.name, withLabel(k, labels("le", strconv.FormatFloat(bound, 'g', -1, 64))), cumulative)
+			}
+			fmt.Fprintf(sb, "%s_bucket{%s} %d\n", m.name, withLabel(k, labels("le", "+Inf")), h.count)
+			fmt.Fprintf(sb, "%s_sum{%s} %g\n%s_count{%s} %d\n", m.name, k, h.sum, m.name, k, h.count)
+			continue
+		}
+		if k == "" {
+			fmt.Fprintf(sb, "%s %g\n", m.name, m.counters[k])
+		} else {
+			fmt.Fprintf(sb, "%s{%s} %g\n", m.name, k, m.counters[k])
+		}
+	}
+}
+
+// writeGauge renders a single gauge series
+func writeGauge(sb *strings.Builder, name, help string, series map[string]float64) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	keys := make([]string, 0, len(series))
+	for k := range series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "" {
+			fmt.Fprintf(sb, "%s %g\n", name, series[k])
+		} else {
+			fmt.Fprintf(sb, "%s{%s} %g\n", name, k, series[k])
+		}
+	}
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack is needed for the websocket upgrade, which type-asserts http.Hijacker
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Flush keeps streamed responses working through the recorder
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// MetricsHandler records the latency of every request by the matched route pattern
+func MetricsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.observe(labels("route", route, "method", r.Method, "code", strconv.Itoa(rec.status)),
+			time.Since(start).Seconds())
+	})
+}
+
+// commandAction returns the metric label for a dc invocation, e.g. "stack up" or "secret ls"
+func commandAction(args []string) string {
+	var words []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		words = append(words, arg)
+		if len(words) == 2 || words[0] != "stack" && words[0] != "secret" {
+			break
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// observeCommand records the duration and exit code of a dc action, the secrets it generated
+// and the outcome of compose actions in the history of their stacks
+func observeCommand(args []string, start time.Time, output []byte, err error) {
+	action := commandAction(args)
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		exitCode = -1
+	}
+	actionDuration.observe(labels("action", action), time.Since(start).Seconds())
+	actionsTotal.add(labels("action", action, "exit_code", strconv.Itoa(exitCode)), 1)
+	notifyDeploy(args, exitCode)
+	recordStackActions(args, start, output, exitCode)
+	if n := strings.Count(string(output), "Generated new secret '"); n > 0 {
+		secretsGenerated.add("", float64(n))
+	}
+}
+
+// metricsSummary is the part of `dc summary` exported as gauges
+type metricsSummary struct {
+	Stacks struct {
+		Running int `json:"running"`
+		Partial int `json:"partial"`
+		Stopped int `json:"stopped"`
+	} `json:"stacks"`
+	Containers struct {
+		Total   int `json:"total"`
+		Running int `json:"running"`
+	} `json:"containers"`
+}
+
+// HandleMetrics serves GET /metrics in the Prometheus text format. If METRICS_TOKEN is set,
+// scrapers must send it as a bearer token.
+func HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := getConfig("metrics_token", ""); token != "" {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			httpError(w, r, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var sb strings.Builder
+	metricsMu.Lock()
+	for _, m := range []*metricFamily{httpRequestDuration, actionDuration, actionsTotal, secretsGenerated, websocketDropped, websocketEvicted} {
+		m.write(&sb)
+	}
+	metricsMu.Unlock()
+
+	writeGauge(&sb, "composectl_websocket_clients", "Connected websocket clients.", map[string]float64{"": float64(wsHub.clientCount())})
+
+	out, err := exec.Command("dc", "summary").Output()
+	var summary metricsSummary
+	if err == nil {
+		err = json.Unmarshal(out, &summary)
+	}
+	if err != nil {
+		metricsLog.Error("Error collecting stack metrics", "err", err)
+	} else {
+		writeGauge(&sb, "composectl_stacks", "Stacks by state.", map[string]float64{
+			labels("state", "running"): float64(summary.Stacks.Running),
+			labels("state", "partial"): float64(summary.Stacks.Partial),
+			labels("state", "stopped"): float64(summary.Stacks.Stopped),
+		})
+		writeGauge(&sb, "composectl_containers", "Containers by state.", map[string]float64{
+			labels("state", "running"): float64(summary.Containers.Running),
+			labels("state", "stopped"): float64(summary.Containers.Total - summary.Containers.Running),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(sb.String()))
+}
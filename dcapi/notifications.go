@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Notification events
+const (
+	EventUnhealthy       = "unhealthy"        // a healthcheck started failing or a container ran out of memory
+	EventCrashed         = "crashed"          // a container exited with a non-zero exit code
+	EventDeployFinished  = "deploy-finished"  // dc stack up succeeded
+	EventDeployFailed    = "deploy-failed"    // dc stack up failed
+	EventUpdateAvailable = "update-available" // a newer image was pulled but the container not recreated
+	EventDriftDetected   = "drift-detected"   // containers differ from the stack's effective file
+	EventDriftCorrected  = "drift-corrected"  // a drifted stack was brought up again
+)
+
+// notificationEvents lists all events a webhook can subscribe to
+var notificationEvents = []string{EventUnhealthy, EventCrashed, EventDeployFinished, EventDeployFailed, EventUpdateAvailable,
+	EventDriftDetected, EventDriftCorrected}
+
+// webhookTypes are the supported payload formats
+var webhookTypes = []string{"generic", "slack", "discord", "ntfy", "gotify"}
+
+// defaultUpdateCheckInterval is how often pending image updates are checked (config key update_check_interval)
+const defaultUpdateCheckInterval = time.Hour
+
+// Webhook is a notification target. Stacks and Events restrict what it receives; empty means all.
+type Webhook struct {
+	ID        string    `yaml:"id" json:"id"`
+	Name      string    `yaml:"name" json:"name"`
+	Type      string    `yaml:"type" json:"type"`
+	URL       string    `yaml:"url" json:"url"`
+	Token     string    `yaml:"token,omitempty" json:"token,omitempty"` // gotify app token or ntfy access token
+	Stacks    []string  `yaml:"stacks,omitempty" json:"stacks,omitempty"`
+	Events    []string  `yaml:"events,omitempty" json:"events,omitempty"`
+	CreatedAt time.Time `yaml:"created_at" json:"createdAt"`
+}
+
+// Notification is what a webhook is told; generic webhooks receive it as JSON
+type Notification struct {
+	Event     string    `json:"event"`
+	Stack     string    `json:"stack"`
+	Container string    `json:"container,omitempty"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+}
+
+type notificationsFile struct {
+	Webhooks []Webhook `yaml:"webhooks"`
+}
+
+var notificationsMu sync.Mutex
+
+// getNotificationsPath returns the file holding the webhooks (config key notifications_file)
+func getNotificationsPath() string {
+	return getConfig("notifications_file", "notifications.yml")
+}
+
+// loadWebhooks reads all webhooks; the caller must hold notificationsMu
+func loadWebhooks() ([]Webhook, error) {
+	content, err := os.ReadFile(getNotificationsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var file notificationsFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", getNotificationsPath(), err)
+	}
+	return file.Webhooks, nil
+}
+
+// saveWebhooks writes all webhooks; the caller must hold notificationsMu
+func saveWebhooks(webhooks []Webhook) error {
+	content, err := yaml.Marshal(notificationsFile{Webhooks: webhooks})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(getNotificationsPath(), content, 0600)
+}
+
+// matchesFilter reports whether list is empty (meaning all) or contains value
+func matchesFilter(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// text formats a notification as a single human readable line
+func (n Notification) text() string {
+	if n.Container != "" {
+		return fmt.Sprintf("[%s] %s (%s): %s", n.Event, n.Stack, n.Container, n.Message)
+	}
+	return fmt.Sprintf("[%s] %s: %s", n.Event, n.Stack, n.Message)
+}
+
+// newWebhookRequest builds the request for a webhook in its type's format
+func newWebhookRequest(hook Webhook, n Notification) (*http.Request, error) {
+	var body interface{}
+	url := hook.URL
+	switch hook.Type {
+	case "slack":
+		body = map[string]string{"text": n.text()}
+	case "discord":
+		body = map[string]string{"content": n.text()}
+	case "gotify":
+		url = strings.TrimSuffix(url, "/") + "/message"
+		body = map[string]interface{}{"title": n.Event + ": " + n.Stack, "message": n.text(), "priority": 5}
+	case "ntfy":
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(n.text()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Title", n.Event+": "+n.Stack)
+		req.Header.Set("Tags", n.Event)
+		if hook.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+hook.Token)
+		}
+		return req, nil
+	default:
+		body = n
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if hook.Type == "gotify" {
+		req.Header.Set("X-Gotify-Key", hook.Token)
+	}
+	return req, nil
+}
+
+// sendWebhook delivers a notification to one webhook
+func sendWebhook(hook Webhook, n Notification) error {
+	req, err := newWebhookRequest(hook, n)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// notify sends a notification to all matching webhooks in the background
+func notify(n Notification) {
+	if n.Time.IsZero() {
+		n.Time = time.Now().UTC()
+	}
+	notificationsMu.Lock()
+	webhooks, err := loadWebhooks()
+	notificationsMu.Unlock()
+	if err != nil {
+		notificationsLog.Error("Error loading webhooks", "err", err)
+		return
+	}
+	for _, hook := range webhooks {
+		if !matchesFilter(hook.Stacks, n.Stack) || !matchesFilter(hook.Events, n.Event) {
+			continue
+		}
+		go func(hook Webhook) {
+			if err := sendWebhook(hook, n); err != nil {
+				notificationsLog.Error("Error notifying webhook", "webhook", hook.ID, "webhook_name", hook.Name, "err", err)
+			}
+		}(hook)
+	}
+}
+
+// notifyStackEvent turns container lifecycle events into notifications
+func notifyStackEvent(event StackEvent) {
+	switch {
+	case event.Action == "health_status" && event.Health == "unhealthy":
+		notify(Notification{Event: EventUnhealthy, Stack: event.Stack, Container: event.Container, Message: "healthcheck failing", Time: event.Time})
+	case event.Action == "oom":
+		notify(Notification{Event: EventUnhealthy, Stack: event.Stack, Container: event.Container, Message: "container ran out of memory", Time: event.Time})
+	case event.Action == "die" && event.ExitCode != nil && *event.ExitCode != 0:
+		notify(Notification{Event: EventCrashed, Stack: event.Stack, Container: event.Container,
+			Message: fmt.Sprintf("container exited with code %d", *event.ExitCode), Time: event.Time})
+	}
+}
+
+// notifyDeploy reports the outcome of a `dc stack up` run by the API
+func notifyDeploy(args []string, exitCode int) {
+	if len(args) < 3 || args[0] != "stack" || args[1] != "up" {
+		return
+	}
+	if exitCode == 0 {
+		notify(Notification{Event: EventDeployFinished, Stack: args[2], Message: "deployment finished"})
+	} else {
+		notify(Notification{Event: EventDeployFailed, Stack: args[2], Message: fmt.Sprintf("deployment failed with exit code %d", exitCode)})
+	}
+}
+
+// RunUpdateChecker periodically looks for pending image updates in `dc summary` and notifies
+// each one once. It does nothing while neither a webhook nor an update_check heartbeat is set.
+func RunUpdateChecker() {
+	interval, err := time.ParseDuration(getConfig("update_check_interval", ""))
+	if err != nil || interval <= 0 {
+		interval = defaultUpdateCheckInterval
+	}
+	notified := make(map[string]bool)
+	for {
+		time.Sleep(interval)
+
+		notificationsMu.Lock()
+		webhooks, _ := loadWebhooks()
+		notificationsMu.Unlock()
+		if len(webhooks) == 0 && heartbeatURL("update_check") == "" {
+			continue
+		}
+		out, err := exec.Command("dc", "summary").Output()
+		if err != nil {
+			notificationsLog.Error("Error checking for image updates", "err", err)
+			continue
+		}
+		var summary struct {
+			Alerts []struct {
+				Type      string `json:"type"`
+				Stack     string `json:"stack"`
+				Container string `json:"container"`
+				Message   string `json:"message"`
+			} `json:"alerts"`
+		}
+		if err := json.Unmarshal(out, &summary); err != nil {
+			notificationsLog.Error("Error checking for image updates", "err", err)
+			continue
+		}
+		pending := make(map[string]bool)
+		for _, alert := range summary.Alerts {
+			if alert.Type != "pending-update" {
+				continue
+			}
+			key := alert.Stack + "/" + alert.Container + "/" + alert.Message
+			pending[key] = true
+			if !notified[key] {
+				notify(Notification{Event: EventUpdateAvailable, Stack: alert.Stack, Container: alert.Container, Message: alert.Message})
+			}
+		}
+		// Forget applied updates, so that the next update of the same image is reported again
+		notified = pending
+		pingHeartbeat("update_check")
+	}
+}
+
+// redactedWebhook hides the webhook's credentials in API responses
+func redactedWebhook(hook Webhook) Webhook {
+	if hook.Token != "" {
+		hook.Token = "***"
+	}
+	return hook
+}
+
+// HandleNotificationsAPI manages webhooks:
+// GET /api/notifications lists them, POST /api/notifications registers one,
+// DELETE /api/notifications/{id} removes one and POST /api/notifications/{id}/test sends a test.
+// Like tokens, webhooks can only be managed by interactive users.
+func HandleNotificationsAPI(w http.ResponseWriter, r *http.Request) {
+	principal := principalFromRequest(r)
+	if principal == nil || principal.ServiceAccount != nil || principal.Token != nil {
+		httpError(w, r, "forbidden", http.StatusForbidden)
+		return
+	}
+	id, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/notifications"), "/"), "/")
+
+	notificationsMu.Lock()
+	defer notificationsMu.Unlock()
+	webhooks, err := loadWebhooks()
+	if err != nil {
+		notificationsLog.Error("Error loading webhooks", "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		list := make([]Webhook, 0, len(webhooks))
+		for _, hook := range webhooks {
+			list = append(list, redactedWebhook(hook))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case r.Method == http.MethodPost && id == "":
+		var hook Webhook
+		if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+			httpError(w, r, "invalid_json", http.StatusBadRequest, err)
+			return
+		}
+		if hook.Type == "" {
+			hook.Type = "generic"
+		}
+		if hook.Name == "" || !strings.HasPrefix(hook.URL, "http") || !matchesFilter(webhookTypes, hook.Type) {
+			httpError(w, r, "webhook_fields_required", http.StatusBadRequest, strings.Join(webhookTypes, ", "))
+			return
+		}
+		for _, event := range hook.Events {
+			if !matchesFilter(notificationEvents, event) {
+				httpError(w, r, "unknown_event", http.StatusBadRequest, event, strings.Join(notificationEvents, ", "))
+				return
+			}
+		}
+		if hook.ID, err = randomHex(8); err != nil {
+			httpError(w, r, "internal_error", http.StatusInternalServerError)
+			return
+		}
+		hook.CreatedAt = time.Now().UTC()
+		if err := saveWebhooks(append(webhooks, hook)); err != nil {
+			notificationsLog.Error("Error saving webhooks", "err", err)
+			httpError(w, r, "internal_error", http.StatusInternalServerError)
+			return
+		}
+		notificationsLog.Info("User registered webhook", "user", principal.Name, "type", hook.Type, "webhook", hook.ID, "webhook_name", hook.Name)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(redactedWebhook(hook))
+
+	case r.Method == http.MethodPost && id != "" && action == "test":
+		for _, hook := range webhooks {
+			if hook.ID == id {
+				if err := sendWebhook(hook, Notification{Event: "test", Stack: "composectl", Message: "test notification", Time: time.Now().UTC()}); err != nil {
+					writeAPIError(w, http.StatusBadGateway, "webhook_failed", redactText(err.Error()))
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+
+	case r.Method == http.MethodDelete && id != "" && action == "":
+		for i, hook := range webhooks {
+			if hook.ID == id {
+				if err := saveWebhooks(append(webhooks[:i:i], webhooks[i+1:]...)); err != nil {
+					notificationsLog.Error("Error saving webhooks", "err", err)
+					httpError(w, r, "internal_error", http.StatusInternalServerError)
+					return
+				}
+				notificationsLog.Info("User removed webhook", "user", principal.Name, "webhook", hook.ID, "webhook_name", hook.Name)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+
+	default:
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiParam is a query parameter of an API operation
+type apiParam struct {
+	Name        string
+	Type        string // string, integer or boolean
+	Description string
+}
+
+// apiOperation describes an endpoint for the OpenAPI document. Bodies are a Go value whose type
+// gives the JSON schema, or a content type string for other bodies.
+type apiOperation struct {
+	Method      string
+	Path        string // unversioned, e.g. /api/stacks/{stack}/up
+	Tag         string
+	Summary     string
+	Query       []apiParam
+	Headers     []apiParam
+	Body        interface{}
+	Response    interface{} // nil for the text/plain output of dc
+	Status      int         // of a successful response, default 200
+	Mutation    bool        // takes ?dry_run
+	Streamed    bool        // takes ?stream and ?async, see handleMaybeStreamed
+	Public      bool        // no authentication
+	Interactive bool        // only logged in users, never tokens or service accounts
+}
+
+// stackActionQuery are the query parameters of POST /api/stacks/{stack}/up
+var stackActionQuery = []apiParam{
+	{"pull", "boolean", "pull images before starting"},
+	{"min_free_disk", "string", "refuse to start below this free disk space, e.g. 5GB"},
+	{"skip_preflight", "boolean", "skip the preflight checks"},
+	{"wait_healthy", "boolean", "wait until the services are healthy"},
+	{"wait_timeout", "string", "how long to wait for healthy services, e.g. 2m"},
+}
+
+// listQuery are the query parameters of listFilterParams
+var listQuery = []apiParam{
+	{"name", "string", "filter by name"},
+	{"label", "string", "filter by label, key or key=value"},
+	{"state", "string", "filter by state, e.g. running"},
+	{"group", "string", "filter by group"},
+	{"limit", "integer", "page size"},
+	{"offset", "integer", "page start"},
+}
+
+// apiOperations are the endpoints of the API. Keep them in sync with RegisterHTTPHandlers and
+// the handlers; they are the source of GET /api/v1/openapi.json.
+var apiOperations = []apiOperation{
+	{Method: http.MethodPost, Path: "/api/auth/login", Tag: "auth", Summary: "Log in with basic authentication; the response is the session token", Public: true},
+	{Method: http.MethodPost, Path: "/api/auth/logout", Tag: "auth", Summary: "End the session", Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/auth/status", Tag: "auth", Summary: "Check the session or token"},
+	{Method: http.MethodGet, Path: "/api/capabilities", Tag: "auth", Summary: "The actions the caller may perform", Query: []apiParam{{"stack", "string", "only this stack"}}, Response: Capabilities{}},
+	{Method: http.MethodGet, Path: "/api/tokens", Tag: "auth", Summary: "List personal access tokens", Response: []APIToken{}, Interactive: true},
+	{Method: http.MethodPost, Path: "/api/tokens", Tag: "auth", Summary: "Create a personal access token", Body: TokenCreateRequest{}, Response: TokenCreateResponse{}, Status: http.StatusCreated, Interactive: true},
+	{Method: http.MethodDelete, Path: "/api/tokens/{id}", Tag: "auth", Summary: "Revoke a personal access token", Status: http.StatusNoContent, Interactive: true},
+
+	{Method: http.MethodGet, Path: "/api/stacks", Tag: "stacks", Summary: "List stacks", Query: append([]apiParam{{"detail", "string", "summary lists the stacks from a single docker ps"}}, listQuery...)},
+	{Method: http.MethodPost, Path: "/api/stacks/import", Tag: "stacks", Summary: "Import a compose file or project", Body: StackImportRequest{}, Mutation: true},
+	{Method: http.MethodPost, Path: "/api/stacks/import-bundle", Tag: "stacks", Summary: "Import an exported bundle, the body or ?remote= from the storage target", Body: "application/gzip", Mutation: true, Query: []apiParam{
+		{"remote", "string", "<stack>[/<export>] on the storage target instead of a body"},
+		{"name", "string", "import under this name"},
+		{"force", "boolean", "replace an existing stack"},
+	}, Headers: []apiParam{{bundlePassphraseHeader, "string", "passphrase of an encrypted bundle"}}},
+	{Method: http.MethodPost, Path: "/api/stacks/_bulk", Tag: "stacks", Summary: "Run an action on several stacks", Body: BulkRequest{}, Streamed: true},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}", Tag: "stacks", Summary: "Get a stack"},
+	{Method: http.MethodPut, Path: "/api/stacks/{stack}", Tag: "stacks", Summary: "Save the stack file", Body: "application/yaml", Mutation: true},
+	{Method: http.MethodDelete, Path: "/api/stacks/{stack}", Tag: "stacks", Summary: "Delete a stack", Mutation: true},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/up", Tag: "stacks", Summary: "Deploy a stack", Body: StackActionRequest{}, Query: stackActionQuery, Mutation: true, Streamed: true},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/down", Tag: "stacks", Summary: "Take a stack down", Body: StackActionRequest{}, Mutation: true, Streamed: true},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/start", Tag: "stacks", Summary: "Start the containers of a stack", Body: StackActionRequest{}, Mutation: true, Streamed: true},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/stop", Tag: "stacks", Summary: "Stop the containers of a stack", Body: StackActionRequest{}, Mutation: true, Streamed: true},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/create", Tag: "stacks", Summary: "Create the containers of a stack without starting them", Mutation: true, Streamed: true},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/ps", Tag: "stacks", Summary: "The containers of a stack", Query: []apiParam{
+		{"logs", "integer", "include the last n log lines of every container, at most 1000"},
+	}},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/logs", Tag: "stacks", Summary: "Stream the logs of a stack"},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/stream", Tag: "stacks", Summary: "Resume a streamed action", Query: []apiParam{
+		{"resume", "string", "the resume token of the stream"},
+		{"from", "integer", "lines received so far"},
+	}},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/stats", Tag: "stacks", Summary: "Resource usage of the containers", Query: []apiParam{
+		{"stream", "boolean", "send server-sent events"},
+		{"interval", "string", "sample interval of the stream, default 2s"},
+	}},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/usage", Tag: "stacks", Summary: "CPU and memory history", Query: []apiParam{
+		{"range", "string", "e.g. 24h"},
+		{"points", "integer", "number of points"},
+	}},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/watch", Tag: "stacks", Summary: "The compose watch session"},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/watch", Tag: "stacks", Summary: "Start compose watch"},
+	{Method: http.MethodDelete, Path: "/api/stacks/{stack}/watch", Tag: "stacks", Summary: "Stop compose watch"},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/history", Tag: "stacks", Summary: "Deployment history"},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/actions", Tag: "stacks", Summary: "Outcomes of the compose actions, newest first", Response: []StackAction{}, Query: []apiParam{{"limit", "integer", "default 20"}}},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/revisions", Tag: "stacks", Summary: "Revisions of the stack file"},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/rollback/{revision}", Tag: "stacks", Summary: "Roll back to a revision", Mutation: true},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/adopt", Tag: "stacks", Summary: "Turn containers started by hand into a new stack", Body: StackAdoptRequest{}, Mutation: true, Streamed: true},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/reconstruct", Tag: "stacks", Summary: "A stack file reconstructed from the containers", Response: "text/plain"},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/reconstruct", Tag: "stacks", Summary: "Write the stack file reconstructed from the containers, e.g. over a broken symlink", Mutation: true, Query: []apiParam{
+		{"force", "boolean", "replace an existing stack file"},
+	}},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/meta", Tag: "stacks", Summary: "The group and autostart of a stack"},
+	{Method: http.MethodPut, Path: "/api/stacks/{stack}/meta", Tag: "stacks", Summary: "Set the group and autostart of a stack", Body: StackMetaRequest{}, Mutation: true},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/rename", Tag: "stacks", Summary: "Rename a stack", Body: StackCopyRequest{}, Mutation: true},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/clone", Tag: "stacks", Summary: "Clone a stack", Body: StackCopyRequest{}, Mutation: true},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/export", Tag: "stacks", Summary: "Download a bundle, or Kubernetes manifests with ?format=k8s", Response: "application/gzip", Query: []apiParam{
+		{"format", "string", "k8s for Kubernetes manifests"},
+		{"secret_values", "boolean", "include secret values in the manifests"},
+	}, Headers: []apiParam{{bundlePassphraseHeader, "string", "encrypt the bundle"}}},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/export", Tag: "stacks", Summary: "Upload a bundle to the storage target", Mutation: true, Streamed: true, Query: []apiParam{
+		{"retention", "integer", "number of exports to keep"},
+	}, Headers: []apiParam{{bundlePassphraseHeader, "string", "encrypt the bundle"}}},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/exports", Tag: "stacks", Summary: "Exports on the storage target"},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/backup", Tag: "stacks", Summary: "Back up the volumes", Mutation: true, Streamed: true, Query: []apiParam{
+		{"retention", "integer", "number of backups to keep"},
+	}},
+	{Method: http.MethodGet, Path: "/api/stacks/{stack}/backups", Tag: "stacks", Summary: "List backups", Query: []apiParam{
+		{"remote", "boolean", "list the backups on the storage target"},
+	}},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/restore", Tag: "stacks", Summary: "Restore the volumes from a backup", Mutation: true, Streamed: true, Query: []apiParam{
+		{"snapshot", "string", "the backup to restore, default the latest"},
+		{"remote", "boolean", "restore from the storage target"},
+	}},
+	{Method: http.MethodPost, Path: "/api/stacks/{stack}/chaos/{experiment}", Tag: "stacks", Summary: "Run a chaos experiment", Mutation: true, Query: []apiParam{
+		{"container", "string", "target container"},
+		{"service", "string", "target service"},
+		{"delay", "string", "e.g. 200ms"},
+	}},
+
+	{Method: http.MethodGet, Path: "/api/containers", Tag: "containers", Summary: "List containers", Query: append([]apiParam{{"stack", "string", "filter by stack"}}, listQuery...)},
+	{Method: http.MethodGet, Path: "/api/containers/{id}", Tag: "containers", Summary: "Inspect a container; environment values of sensitive keys are masked"},
+	{Method: http.MethodGet, Path: "/api/containers/{id}/exec", Tag: "containers", Summary: "Open a terminal websocket", Interactive: true, Status: http.StatusSwitchingProtocols, Query: []apiParam{
+		{"cmd", "string", "command, default sh"},
+		{"cols", "integer", "terminal width"},
+		{"rows", "integer", "terminal height"},
+	}},
+	{Method: http.MethodGet, Path: "/api/networks", Tag: "resources", Summary: "List networks"},
+	{Method: http.MethodPost, Path: "/api/networks", Tag: "resources", Summary: "Create a network", Body: ResourceCreateRequest{}, Mutation: true},
+	{Method: http.MethodPost, Path: "/api/networks/prune", Tag: "resources", Summary: "Remove unused networks", Mutation: true, Query: []apiParam{{"all", "boolean", "also those not created by dc"}}},
+	{Method: http.MethodGet, Path: "/api/networks/{name}", Tag: "resources", Summary: "Inspect a network"},
+	{Method: http.MethodDelete, Path: "/api/networks/{name}", Tag: "resources", Summary: "Remove a network", Mutation: true},
+	{Method: http.MethodGet, Path: "/api/volumes", Tag: "resources", Summary: "List volumes"},
+	{Method: http.MethodPost, Path: "/api/volumes", Tag: "resources", Summary: "Create a volume", Body: ResourceCreateRequest{}, Mutation: true},
+	{Method: http.MethodPost, Path: "/api/volumes/prune", Tag: "resources", Summary: "Remove unused volumes", Mutation: true, Query: []apiParam{{"all", "boolean", "also those not created by dc"}}},
+	{Method: http.MethodGet, Path: "/api/volumes/{name}", Tag: "resources", Summary: "Inspect a volume"},
+	{Method: http.MethodDelete, Path: "/api/volumes/{name}", Tag: "resources", Summary: "Remove a volume", Mutation: true},
+	{Method: http.MethodGet, Path: "/api/system/df", Tag: "resources", Summary: "Disk usage by category and stack"},
+	{Method: http.MethodGet, Path: "/api/system/health", Tag: "resources", Summary: "Check the container engine, compose, the stack files and prod.env (503 if a check failed)", Response: HealthReport{}},
+	{Method: http.MethodPost, Path: "/api/system/prune", Tag: "resources", Summary: "Remove unused resources", Mutation: true, Query: []apiParam{
+		{"images", "boolean", "prune images"},
+		{"containers", "boolean", "prune containers"},
+		{"networks", "boolean", "prune networks"},
+	}},
+
+	{Method: http.MethodGet, Path: "/api/secrets", Tag: "secrets", Summary: "List secrets with masked values and their usages", Response: "application/json"},
+	{Method: http.MethodPost, Path: "/api/secrets", Tag: "secrets", Summary: "Create a secret", Body: SecretRequest{}, Response: "application/json", Mutation: true, Query: []apiParam{{"stack", "string", "store in the env file of this stack"}}},
+	{Method: http.MethodGet, Path: "/api/secrets/{name}", Tag: "secrets", Summary: "Get a secret with a masked value", Response: "application/json"},
+	{Method: http.MethodPut, Path: "/api/secrets/{name}", Tag: "secrets", Summary: "Create or update a secret, the value as body or JSON {\"value\"}", Body: "text/plain", Response: "application/json", Mutation: true, Query: []apiParam{{"stack", "string", "store in the env file of this stack"}}},
+	{Method: http.MethodDelete, Path: "/api/secrets/{name}", Tag: "secrets", Summary: "Delete a secret", Response: "application/json", Mutation: true, Query: []apiParam{{"stack", "string", "delete from the env file of this stack"}}},
+	{Method: http.MethodPost, Path: "/api/secrets/{name}/rotate", Tag: "secrets", Summary: "Generate a new value", Mutation: true, Streamed: true, Query: []apiParam{
+		{"restart", "boolean", "restart the stacks using the secret"},
+		{"length", "integer", "length of the new value"},
+	}},
+
+	{Method: http.MethodGet, Path: "/api/jobs", Tag: "jobs", Summary: "List jobs, newest first", Response: []Job{}, Query: []apiParam{
+		{"stack", "string", "filter by stack"},
+		{"limit", "integer", "default 50"},
+	}},
+	{Method: http.MethodGet, Path: "/api/jobs/{id}", Tag: "jobs", Summary: "A job with its output", Response: Job{}},
+	{Method: http.MethodGet, Path: "/api/jobs/{id}/stream", Tag: "jobs", Summary: "Follow the output of a job", Query: []apiParam{{"from", "integer", "lines received so far"}}},
+	{Method: http.MethodDelete, Path: "/api/jobs/{id}", Tag: "jobs", Summary: "Cancel a running job"},
+
+	{Method: http.MethodGet, Path: "/api/summary", Tag: "system", Summary: "Counts, recent deployments and alerts"},
+	{Method: http.MethodGet, Path: "/api/capacity", Tag: "system", Summary: "Declared memory and CPUs by stack against the host capacity"},
+	{Method: http.MethodGet, Path: "/api/graph", Tag: "system", Summary: "Dependencies between stacks", Query: []apiParam{{"format", "string", "dot or mermaid"}}},
+	{Method: http.MethodGet, Path: "/api/boot", Tag: "system", Summary: "The outcome of the last boot"},
+	{Method: http.MethodGet, Path: "/api/drift", Tag: "system", Summary: "Drift of the deployed stacks", Query: []apiParam{{"stack", "string", "only this stack"}}},
+	{Method: http.MethodGet, Path: "/api/events", Tag: "system", Summary: "Server-sent events", Response: "text/event-stream", Query: []apiParam{{"stack", "string", "only events of this stack"}}},
+	{Method: http.MethodPost, Path: "/api/transform", Tag: "system", Summary: "Apply enrichment steps to compose YAML", Body: TransformRequest{}, Response: "application/json", Query: []apiParam{{"steps", "string", "comma separated steps for a YAML body"}}},
+	{Method: http.MethodPost, Path: "/api/convert", Tag: "system", Summary: "Convert a docker run command into a stack file", Body: ConvertRequest{}, Response: ConvertResult{}, Query: []apiParam{{"name", "string", "stack name for a command line body"}}},
+	{Method: http.MethodPost, Path: "/api/lint", Tag: "system", Summary: "Lint a compose file; 422 if a finding is an error", Body: "application/yaml", Response: "application/json"},
+	{Method: http.MethodGet, Path: "/api/audit", Tag: "system", Summary: "The audit log, newest first", Response: "application/json", Interactive: true, Query: []apiParam{{"limit", "integer", "default 100"}}},
+	{Method: http.MethodGet, Path: "/api/notifications", Tag: "system", Summary: "List webhooks", Response: []Webhook{}, Interactive: true},
+	{Method: http.MethodPost, Path: "/api/notifications", Tag: "system", Summary: "Register a webhook", Body: Webhook{}, Response: Webhook{}, Status: http.StatusCreated, Interactive: true},
+	{Method: http.MethodDelete, Path: "/api/notifications/{id}", Tag: "system", Summary: "Remove a webhook", Status: http.StatusNoContent, Interactive: true},
+	{Method: http.MethodPost, Path: "/api/notifications/{id}/test", Tag: "system", Summary: "Send a test notification", Status: http.StatusNoContent, Interactive: true},
+	{Method: http.MethodGet, Path: "/api/settings/dns", Tag: "system", Summary: "The DNS providers of the routed hosts, credentials redacted", Response: DNSSettings{}, Interactive: true},
+	{Method: http.MethodPut, Path: "/api/settings/dns", Tag: "system", Summary: "Replace the DNS providers; redacted credentials keep the stored ones", Body: DNSSettings{}, Response: DNSSettings{}, Interactive: true},
+	{Method: http.MethodGet, Path: "/api/settings/logging", Tag: "system", Summary: "Where container logs are shipped to, credentials redacted", Response: LoggingSettings{}, Interactive: true},
+	{Method: http.MethodPut, Path: "/api/settings/logging", Tag: "system", Summary: "Replace the log shipping settings and restart the forwarder; redacted credentials keep the stored ones", Body: LoggingSettings{}, Response: LoggingSettings{}, Interactive: true},
+	{Method: http.MethodGet, Path: "/api/assets", Tag: "system", Summary: "A remote icon through the local cache", Response: "image/*", Query: []apiParam{{"url", "string", "the icon URL"}}},
+	{Method: http.MethodGet, Path: "/api/thumbnail/{image}", Tag: "system", Summary: "The thumbnail of a Docker Hub image", Response: "image/*"},
+}
+
+// pathParamPattern matches the {name} parameters of an operation path
+var pathParamPattern = regexp.MustCompile(`\{([a-z]+)\}`)
+
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument []byte
+)
+
+// buildOpenAPI generates the OpenAPI 3 document of apiOperations
+func buildOpenAPI() map[string]interface{} {
+	paths := map[string]map[string]interface{}{}
+	tags := map[string]bool{}
+	for _, op := range apiOperations {
+		path := apiVersionPrefix + strings.TrimPrefix(op.Path, "/api")
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		tags[op.Tag] = true
+
+		var params []map[string]interface{}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+			params = append(params, map[string]interface{}{"name": match[1], "in": "path", "required": true, "schema": map[string]string{"type": "string"}})
+		}
+		query := append([]apiParam{}, op.Query...)
+		if op.Mutation {
+			query = append(query, apiParam{"dry_run", "boolean", "report the planned changes instead of applying them"})
+		}
+		if op.Streamed {
+			query = append(query,
+				apiParam{"stream", "boolean", "stream the output while the action runs, as server-sent events with Accept: text/event-stream"},
+				apiParam{"async", "boolean", "answer 202 with a job to follow at /api/v1/jobs/{id}"})
+		}
+		for _, p := range query {
+			params = append(params, map[string]interface{}{"name": p.Name, "in": "query", "description": p.Description, "schema": map[string]string{"type": p.Type}})
+		}
+		for _, p := range op.Headers {
+			params = append(params, map[string]interface{}{"name": p.Name, "in": "header", "description": p.Description, "schema": map[string]string{"type": p.Type}})
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if status != http.StatusNoContent && status != http.StatusSwitchingProtocols {
+			success["content"] = openAPIContent(op.Response, "text/plain")
+		}
+		operation := map[string]interface{}{
+			"tags":        []string{op.Tag},
+			"summary":     op.Summary,
+			"operationId": operationID(op),
+			"responses": map[string]interface{}{
+				fmt.Sprint(status): success,
+				"default":          map[string]interface{}{"description": "Error", "content": openAPIContent(APIError{}, "")},
+			},
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.Body != nil {
+			operation["requestBody"] = map[string]interface{}{"content": openAPIContent(op.Body, "")}
+		}
+		switch {
+		case op.Public:
+			operation["security"] = []interface{}{map[string][]string{"basicAuth": {}}}
+		case op.Interactive:
+			operation["description"] = "Only logged in users, never tokens or service accounts."
+		}
+		paths[path][strings.ToLower(op.Method)] = operation
+	}
+
+	tagList := make([]map[string]string, 0, len(tags))
+	for _, tag := range sortedTags(tags) {
+		tagList = append(tagList, map[string]string{"name": tag})
+	}
+	document := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "composectl API",
+			"version": strings.TrimPrefix(apiVersionPrefix, "/api/"),
+		},
+		"tags":     tagList,
+		"paths":    paths,
+		"security": []interface{}{map[string][]string{"bearerAuth": {}}},
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]string{"type": "http", "scheme": "bearer", "description": "A session token of /api/v1/auth/login or a personal access token"},
+				"basicAuth":  map[string]string{"type": "http", "scheme": "basic"},
+			},
+		},
+	}
+	// Behind a reverse proxy the paths are relative to the base path
+	if base := basePath(); base != "" {
+		document["servers"] = []map[string]string{{"url": base}}
+	}
+	return document
+}
+
+// operationID derives a stable operation id from method and path, e.g. postStacksStackUp
+func operationID(op apiOperation) string {
+	id := strings.ToLower(op.Method)
+	for _, part := range strings.FieldsFunc(strings.TrimPrefix(op.Path, "/api"), func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '-' || r == '_'
+	}) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// sortedTags returns the tags in order of appearance in apiOperations
+func sortedTags(tags map[string]bool) []string {
+	var sorted []string
+	seen := map[string]bool{}
+	for _, op := range apiOperations {
+		if tags[op.Tag] && !seen[op.Tag] {
+			seen[op.Tag] = true
+			sorted = append(sorted, op.Tag)
+		}
+	}
+	return sorted
+}
+
+// openAPIContent describes a body: a content type string, a Go value as its JSON schema, or
+// fallback if nil
+func openAPIContent(body interface{}, fallback string) map[string]interface{} {
+	switch b := body.(type) {
+	case nil:
+		return map[string]interface{}{fallback: map[string]interface{}{"schema": map[string]string{"type": "string"}}}
+	case string:
+		schema := map[string]string{"type": "string"}
+		switch {
+		case b == "application/json":
+			schema = map[string]string{}
+		case !strings.HasPrefix(b, "text/") && b != "application/yaml":
+			schema["format"] = "binary"
+		}
+		return map[string]interface{}{b: map[string]interface{}{"schema": schema}}
+	default:
+		return map[string]interface{}{"application/json": map[string]interface{}{"schema": jsonSchema(reflect.TypeOf(body))}}
+	}
+}
+
+// jsonSchema returns the JSON schema of a type as encoding/json marshals it
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		addStructProperties(t, properties)
+		return map[string]interface{}{"type": "object", "properties": properties}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// addStructProperties adds the JSON fields of a struct, including those of embedded structs
+func addStructProperties(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addStructProperties(field.Type, properties)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = jsonSchema(field.Type)
+	}
+}
+
+// HandleOpenAPI serves GET /api/v1/openapi.json without authentication, so that clients can be
+// generated from it
+func HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	openAPIOnce.Do(func() {
+		var err error
+		if openAPIDocument, err = json.MarshalIndent(buildOpenAPI(), "", "  "); err != nil {
+			httpLog.Error("Error generating the OpenAPI document", "err", err)
+		}
+	})
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPIDocument)
+}
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at the OpenAPI document
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>composectl API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "%s/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// HandleSwaggerUI serves Swagger UI at GET /api/v1/docs. It is off unless swagger_ui is enabled.
+func HandleSwaggerUI(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(getConfig("swagger_ui", "false"), "true") {
+		httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+		return
+	}
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, swaggerUIPage, basePath()+apiVersionPrefix)
+}
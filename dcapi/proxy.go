@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// corsAllowedHeaders are the request headers cross-origin clients may send
+const corsAllowedHeaders = "Authorization, Content-Type, Accept, Last-Event-ID, " + bundlePassphraseHeader + ", " + requestIDHeader
+
+// corsExposedHeaders are the response headers cross-origin clients may read
+const corsExposedHeaders = "Location, Link, Deprecation, " + requestIDHeader
+
+// basePath returns the path prefix dcapi is served under behind a reverse proxy (config key
+// base_path, e.g. /composectl), empty if it is served at the root
+func basePath() string {
+	if base := strings.Trim(getConfig("base_path", ""), "/"); base != "" {
+		return "/" + base
+	}
+	return ""
+}
+
+// withBasePath serves the requests under base_path as if they had no prefix and redirects the
+// prefix itself to the prefix with a slash. Requests without the prefix, e.g. from a proxy
+// stripping it, are served unchanged.
+func withBasePath(next http.Handler) http.Handler {
+	base := basePath()
+	if base == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == base {
+			target := base + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, base+"/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = strings.TrimPrefix(r.URL.Path, base)
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// trustedProxies returns the addresses and networks of trusted_proxies (comma-separated, e.g.
+// 172.18.0.0/16,10.0.0.5), the reverse proxies whose X-Forwarded-For dcapi believes
+func trustedProxies() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range splitList(getConfig("trusted_proxies", "")) {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+		} else {
+			serverLog.Warn("Ignoring invalid trusted proxy", "proxy", entry)
+		}
+	}
+	return networks
+}
+
+// isTrustedProxy reports whether an address belongs to a trusted proxy
+func isTrustedProxy(address string, proxies []*net.IPNet) bool {
+	ip := net.ParseIP(strings.TrimSpace(address))
+	if ip == nil {
+		return false
+	}
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromTrustedProxy reports whether a request was sent by a trusted proxy
+func fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return isTrustedProxy(host, trustedProxies())
+}
+
+// clientIP returns the address of the client of a request. Behind trusted proxies it is the
+// last address of X-Forwarded-For that isn't one of them, since each proxy appends the address
+// it received the request from and anything before can be forged by the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	proxies := trustedProxies()
+	if !isTrustedProxy(host, proxies) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		if address == "" {
+			continue
+		}
+		if !isTrustedProxy(address, proxies) {
+			return address
+		}
+		host = address
+	}
+	return host
+}
+
+// corsOrigin returns the Access-Control-Allow-Origin of a request's origin: the origin if
+// cors_origins (comma-separated, * for any) lists it, else empty
+func corsOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, allowed := range splitList(getConfig("cors_origins", "")) {
+		if allowed == "*" || strings.EqualFold(strings.TrimRight(allowed, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// withCORS lets the browser clients of the origins of cors_origins call the API and answers
+// their preflight requests. Clients authenticate with bearer tokens, so no credentials are
+// allowed.
+func withCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := corsOrigin(r.Header.Get("Origin"))
+		if origin == "" {
+			next(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var errPTYUnsupported = errors.New("terminals are only supported on linux")
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errPTYUnsupported
+}
+
+func resizePTY(master *os.File, cols, rows int) error {
+	return errPTYUnsupported
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"dc/logging"
+)
+
+// readOnlyExempt are the requests read-only mode lets through although they are no GET: logging
+// in and out, and the endpoints that only compute a result
+var readOnlyExempt = map[string]bool{
+	"/api/auth/login":  true,
+	"/api/auth/logout": true,
+	"/api/transform":   true,
+	"/api/convert":     true,
+	"/api/lint":        true,
+}
+
+// readOnlyMode reports whether dcapi runs with --read-only (or READ_ONLY=true), e.g. to show the
+// dashboard on the LAN while stacks are only changed locally
+func readOnlyMode() bool {
+	return logging.Flag("read-only") || strings.EqualFold(getConfig("read_only", "false"), "true")
+}
+
+// readOnlyAllows reports whether read-only mode lets a request through: reads, except opening a
+// terminal in a container, and the exempt requests
+func readOnlyAllows(method, urlPath string) bool {
+	if !readOnlyMode() {
+		return true
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return !isContainerExecPath(urlPath)
+	}
+	return readOnlyExempt[urlPath]
+}
+
+// isContainerExecPath reports whether a request opens a terminal in a container, reading the
+// path like HandleContainerExec does, so that e.g. a trailing slash doesn't get around it
+func isContainerExecPath(urlPath string) bool {
+	if !strings.HasPrefix(urlPath, "/api/containers/") {
+		return false
+	}
+	_, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(urlPath, "/api/containers"), "/"), "/")
+	return action == "exec"
+}
+
+// enforceReadOnly answers 403 to the requests read-only mode doesn't let through
+func enforceReadOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !readOnlyAllows(r.Method, r.URL.Path) {
+			httpError(w, r, "read_only", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestReadOnlyAllowsRoutes checks every documented route with every method in read-only mode:
+// only reads get through, except terminals, and the few exempt requests that change nothing
+func TestReadOnlyAllowsRoutes(t *testing.T) {
+	t.Setenv("READ_ONLY", "true")
+	writesAllowed := map[string]bool{
+		"/api/auth/login":  true,
+		"/api/auth/logout": true,
+		"/api/transform":   true,
+		"/api/convert":     true,
+		"/api/lint":        true,
+	}
+	readsDenied := map[string]bool{
+		"/api/containers/abc123/exec": true,
+	}
+	methods := []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+	for _, op := range apiOperations {
+		path := pathParamPattern.ReplaceAllStringFunc(op.Path, func(param string) string {
+			if param == "{id}" {
+				return "abc123"
+			}
+			return "web"
+		})
+		for _, variant := range []string{path, path + "/"} {
+			for _, method := range methods {
+				read := method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+				normalized := strings.TrimSuffix(variant, "/")
+				want := writesAllowed[variant] || (read && !readsDenied[normalized])
+				if got := readOnlyAllows(method, variant); got != want {
+					t.Errorf("readOnlyAllows(%s, %s) = %v, want %v", method, variant, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestReadOnlyDeniesExec(t *testing.T) {
+	t.Setenv("READ_ONLY", "true")
+	for _, path := range []string{
+		"/api/containers/abc/exec",
+		"/api/containers/abc/exec/",
+		"/api/containers//abc/exec",
+		"/api/containers/abc/exec//",
+	} {
+		if readOnlyAllows(http.MethodGet, path) {
+			t.Errorf("read-only mode opens a terminal through GET %s", path)
+		}
+	}
+	if !readOnlyAllows(http.MethodGet, "/api/containers/abc") {
+		t.Error("read-only mode denies inspecting a container")
+	}
+}
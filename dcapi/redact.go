@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// Redaction levels (config key redaction, shared with dc which inherits the environment):
+//   - off: logs and error payloads are passed through unchanged
+//   - standard: key=value credentials and bearer tokens are masked (default)
+//   - paranoid: additionally replaces stack names in API paths by short hashes
+const (
+	RedactionOff      = "off"
+	RedactionStandard = "standard"
+	RedactionParanoid = "paranoid"
+)
+
+// credentialRe matches key=value / key: value pairs whose key looks like a credential
+var credentialRe = regexp.MustCompile(`(?i)\b([A-Z0-9_.-]*(?:PASSWORD|PASSWD|SECRET|TOKEN|API_?KEY|PRIVATE_KEY)[A-Z0-9_.-]*)(\s*[=:]\s*)("[^"]*"|'[^']*'|[^\s,;]+)`)
+
+// bearerRe matches HTTP bearer credentials and personal access tokens
+var bearerRe = regexp.MustCompile(`(?i)\b(Bearer\s+)[A-Za-z0-9._~+/=-]+|\b` + apiTokenPrefix + `[a-f0-9]+`)
+
+// stackPathRe matches the stack name segment of stack API paths
+var stackPathRe = regexp.MustCompile(`(/api/stacks/)([^/\s?"]+)`)
+
+// redactionLevel is set once by initRedaction; empty disables redaction
+var redactionLevel string
+
+// initRedaction reads the redaction level. It runs before log output is redacted,
+// since getConfig logs and must not be redacted recursively.
+func initRedaction() {
+	switch level := strings.ToLower(getConfig("redaction", RedactionStandard)); level {
+	case RedactionOff, RedactionParanoid:
+		redactionLevel = level
+	default:
+		redactionLevel = RedactionStandard
+	}
+}
+
+// hashName returns a stable short replacement for a name
+func hashName(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return "h:" + hex.EncodeToString(sum[:4])
+}
+
+// redactText applies the configured redaction level to log output or an error payload
+func redactText(s string) string {
+	if redactionLevel == "" || redactionLevel == RedactionOff || s == "" {
+		return s
+	}
+	s = credentialRe.ReplaceAllString(s, "$1$2***")
+	s = bearerRe.ReplaceAllStringFunc(s, func(match string) string {
+		if strings.HasPrefix(match, apiTokenPrefix) {
+			return apiTokenPrefix + "***"
+		}
+		return match[:len("Bearer ")] + "***"
+	})
+	if redactionLevel == RedactionParanoid {
+		s = stackPathRe.ReplaceAllStringFunc(s, func(match string) string {
+			parts := stackPathRe.FindStringSubmatch(match)
+			switch parts[2] {
+			case "import", "import-bundle":
+				return match
+			}
+			return parts[1] + hashName(parts[2])
+		})
+	}
+	return s
+}
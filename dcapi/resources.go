@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// resourceNamePattern matches valid docker network and volume names
+var resourceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ResourceCreateRequest is the body of POST /api/networks and POST /api/volumes
+type ResourceCreateRequest struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver,omitempty"`
+	DriverOpts map[string]string `json:"driverOpts,omitempty"`
+}
+
+// HandleNetworksAPI serves /api/networks through `dc network`
+func HandleNetworksAPI(w http.ResponseWriter, r *http.Request) {
+	handleResourceAPI(w, r, "network", "/api/networks")
+}
+
+// HandleVolumesAPI serves /api/volumes through `dc volume`
+func HandleVolumesAPI(w http.ResponseWriter, r *http.Request) {
+	handleResourceAPI(w, r, "volume", "/api/volumes")
+}
+
+// handleResourceAPI serves the networks and volumes endpoints:
+//
+//	GET    /api/{kind}s              list with the containers and stacks using each one
+//	POST   /api/{kind}s              create from a ResourceCreateRequest
+//	POST   /api/{kind}s/prune        remove unused ones created by dc (?all=true: all unused ones)
+//	GET    /api/{kind}s/{name}       docker inspect output
+//	DELETE /api/{kind}s/{name}       remove, refused while a container uses it or a stack declares it
+func handleResourceAPI(w http.ResponseWriter, r *http.Request, kind, prefix string) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		HandleAction(w, "dc", kind, "ls")
+	case name == "" && r.Method == http.MethodPost:
+		var req ResourceCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !resourceNamePattern.MatchString(req.Name) {
+			httpError(w, r, "resource_name_required", http.StatusBadRequest)
+			return
+		}
+		args := []string{kind, "create", req.Name}
+		if req.Driver != "" {
+			args = append(args, "--driver="+req.Driver)
+		}
+		if len(req.DriverOpts) > 0 {
+			opts := make([]string, 0, len(req.DriverOpts))
+			for key, value := range req.DriverOpts {
+				opts = append(opts, key+"="+value)
+			}
+			sort.Strings(opts)
+			args = append(args, "--driver-opts="+strings.Join(opts, ","))
+		}
+		HandleAction(w, "dc", append(args, mutationFlags(r, nil)...)...)
+	case name == "prune" && r.Method == http.MethodPost:
+		HandleAction(w, "dc", append([]string{kind, "prune"}, mutationFlags(r, map[string]string{
+			"all": "all",
+		})...)...)
+	case !resourceNamePattern.MatchString(name):
+		httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+	case r.Method == http.MethodGet:
+		HandleAction(w, "dc", kind, "inspect", name)
+	case r.Method == http.MethodDelete:
+		HandleAction(w, "dc", append([]string{kind, "rm", name}, mutationFlags(r, nil)...)...)
+	default:
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+	}
+}
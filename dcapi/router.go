@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// middleware wraps a handler, e.g. to authenticate its requests
+type middleware func(http.HandlerFunc) http.HandlerFunc
+
+// commonMiddleware wraps every route, outermost first
+var commonMiddleware = []middleware{withRequestID, withCORS, logRequests, recoverPanics, enforceReadOnly}
+
+// chain wraps a handler in middleware, the first being the outermost
+func chain(handler http.HandlerFunc, middleware ...middleware) http.HandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// mount registers a handler of all methods on an API path pattern, wrapped in middleware. It is
+// for handlers that dispatch on the method and the rest of the path themselves.
+func mount(pattern string, handler http.HandlerFunc, middleware ...middleware) {
+	handleAPI(pattern, chain(chain(handler, middleware...), commonMiddleware...))
+}
+
+// routes holds the handlers of the patterns registered with route by method
+var routes = map[string]map[string]http.HandlerFunc{}
+
+// route registers the handler of a method on an API path pattern, wrapped in middleware. Patterns
+// name their path parameters, e.g. /api/stacks/{stack}/up, which handlers read with r.PathValue.
+// Requests of a method the pattern has no handler for are answered 405 with the allowed methods.
+func route(method, pattern string, handler http.HandlerFunc, middleware ...middleware) {
+	handlers, ok := routes[pattern]
+	if !ok {
+		handlers = map[string]http.HandlerFunc{}
+		routes[pattern] = handlers
+		mount(pattern, func(w http.ResponseWriter, r *http.Request) {
+			handler, ok := handlers[r.Method]
+			if !ok {
+				w.Header().Set("Allow", allowedMethods(handlers))
+				httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			handler(w, r)
+		})
+	}
+	handlers[method] = chain(handler, middleware...)
+}
+
+// allowedMethods returns the Allow header of a pattern's handlers
+func allowedMethods(handlers map[string]http.HandlerFunc) string {
+	methods := make([]string, 0, len(handlers))
+	for method := range handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// requestIDHeader carries the correlation ID of a request, which error responses and the logs of
+// the request repeat
+const requestIDHeader = "X-Request-ID"
+
+// requestIDPattern matches the request IDs accepted from clients and proxies
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID keeps the request ID of a client or proxy, else assigns one, and answers with it
+func withRequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			var err error
+			if id, err = randomHex(8); err != nil {
+				id = "-"
+			}
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next(w, r)
+	}
+}
+
+// recoverPanics answers 500 instead of dropping the connection when a handler panics. Panics with
+// http.ErrAbortHandler are left to net/http, which aborts the response on purpose.
+func recoverPanics(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			httpLog.Error("Panic serving request", "method", r.Method, "path", r.URL.Path, "request_id", r.Header.Get(requestIDHeader), "err", err, "trace", string(debug.Stack()))
+			if !rec.wroteHeader {
+				httpError(rec, r, "internal_error", http.StatusInternalServerError)
+			}
+		}()
+		next(rec, r)
+	}
+}
+
+// accessLogKey is the context key of the accessLogEntry of a request
+type accessLogKey struct{}
+
+// accessLogEntry collects what handlers learn about a request for its access log line
+type accessLogEntry struct {
+	user string
+}
+
+// logRequests writes an access log line per request with its method, path, status, duration,
+// user and request ID if access_log is enabled
+func logRequests(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(getConfig("access_log", "false"), "true") {
+			next(w, r)
+			return
+		}
+		start := time.Now()
+		entry := &accessLogEntry{}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, entry)))
+		httpLog.Info("Request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote", clientIP(r),
+			"status", rec.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"user", entry.user,
+			"request_id", r.Header.Get(requestIDHeader))
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exit codes of `dc secret store` and `dc secret inventory <name>`
+const (
+	dcExitSecretNotFound = 3
+	dcExitSecretExists   = 4
+)
+
+// secretNamePattern matches the names usable as ${NAME} in compose files
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// secretStoreMu serializes writes to the env store, which dc replaces as a whole
+var secretStoreMu sync.Mutex
+
+// SecretRequest is the body of POST /api/secrets, and optionally of PUT /api/secrets/{name}
+type SecretRequest struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// secretValue reads the value of a write request: the "value" of a JSON body, else the raw body
+func secretValue(r *http.Request) (string, bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		return "", false
+	}
+	value := string(body)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var req SecretRequest
+		if json.Unmarshal(body, &req) != nil {
+			return "", false
+		}
+		value = req.Value
+	}
+	value = strings.TrimRight(value, "\r\n")
+	return value, value != "" && !strings.ContainsAny(value, "\r\n")
+}
+
+// runSecretCommand runs a dc secret command and answers with its JSON output, mapping the exit
+// codes of a missing or existing secret to 404 and 409
+func runSecretCommand(w http.ResponseWriter, r *http.Request, name string, stdin string, args ...string) {
+	cmd := exec.Command("dc", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	start := time.Now()
+	out, err := cmd.Output()
+	observeCommand(args, start, []byte(stderr.String()), err)
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == dcExitSecretNotFound:
+		httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == dcExitSecretExists:
+		httpError(w, r, "secret_exists", http.StatusConflict, name)
+	case err != nil:
+		writeAPIError(w, http.StatusInternalServerError, "command_failed", redactText(stderr.String()))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(out)
+	}
+}
+
+// HandleSecretAPI serves the env store (prod.env). Values are write-only: reads return the names
+// with masked values and where each secret is used. Writes take ?stack=<stack> to target the env
+// file of that stack instead.
+//
+//	GET    /api/secrets                 all secrets
+//	POST   /api/secrets                 create, JSON {"name", "value"}; 409 if it exists
+//	GET    /api/secrets/{name}          one secret
+//	PUT    /api/secrets/{name}          create or update, the value as body or JSON {"value"}
+//	DELETE /api/secrets/{name}          delete
+//	POST   /api/secrets/{name}/rotate   generate a new value, ?restart=true restarts its stacks
+func HandleSecretAPI(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	path = strings.TrimPrefix(path, "/api/secrets")
+	path = strings.TrimPrefix(path, "/")
+
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			runSecretCommand(w, r, "", "", "secret", "inventory")
+		case http.MethodPost:
+			var req SecretRequest
+			if json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req) != nil || !secretNamePattern.MatchString(req.Name) {
+				httpError(w, r, "secret_name_invalid", http.StatusBadRequest, req.Name)
+				return
+			}
+			value := strings.TrimRight(req.Value, "\r\n")
+			if value == "" || strings.ContainsAny(value, "\r\n") {
+				httpError(w, r, "secret_value_invalid", http.StatusBadRequest)
+				return
+			}
+			secretStoreMu.Lock()
+			defer secretStoreMu.Unlock()
+			runSecretCommand(w, r, req.Name, value, append([]string{"secret", "store", "create", req.Name}, mutationFlags(r, map[string]string{"stack": "stack"})...)...)
+		default:
+			httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// POST /api/secrets/{name}/rotate generates a new value and restarts the stacks using it
+	// with ?restart=true
+	if key, ok := strings.CutSuffix(path, "/rotate"); ok {
+		if r.Method != http.MethodPost {
+			httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !secretNamePattern.MatchString(key) {
+			httpError(w, r, "secret_name_invalid", http.StatusBadRequest, key)
+			return
+		}
+		handleMaybeStreamed(w, r, "", append([]string{"secret", "rotate", key}, mutationFlags(r, map[string]string{"restart": "restart", "length": "length"})...))
+		return
+	}
+
+	// path is now the key name
+	key := path
+	if !secretNamePattern.MatchString(key) {
+		httpError(w, r, "secret_name_invalid", http.StatusBadRequest, key)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		runSecretCommand(w, r, key, "", "secret", "inventory", key)
+	case http.MethodPut:
+		value, ok := secretValue(r)
+		if !ok {
+			httpError(w, r, "secret_value_invalid", http.StatusBadRequest)
+			return
+		}
+		secretStoreMu.Lock()
+		defer secretStoreMu.Unlock()
+		runSecretCommand(w, r, key, value, append([]string{"secret", "store", "set", key}, mutationFlags(r, map[string]string{"stack": "stack"})...)...)
+	case http.MethodDelete:
+		secretStoreMu.Lock()
+		defer secretStoreMu.Unlock()
+		runSecretCommand(w, r, key, "", append([]string{"secret", "store", "delete", key}, mutationFlags(r, map[string]string{"stack": "stack"})...)...)
+	default:
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ServiceAccount is a machine account for automation (e.g. CI). Its bearer token is only
+// stored as a SHA-256 hex digest; generate one with:
+//
+//	token=$(openssl rand -hex 32); printf '%s' "$token" | sha256sum
+type ServiceAccount struct {
+	Name        string           `yaml:"name" json:"name"`
+	TokenSHA256 string           `yaml:"token_sha256" json:"-"`
+	Rules       []PermissionRule `yaml:"rules" json:"rules"`
+}
+
+// PermissionRule grants access to requests matching all of its non-empty fields.
+// Paths are path.Match patterns (e.g. /api/stacks/*/up), Stacks restricts the
+// {name} segment of /api/stacks/{name}/... requests.
+type PermissionRule struct {
+	Methods []string `yaml:"methods,omitempty" json:"methods,omitempty"`
+	Paths   []string `yaml:"paths,omitempty" json:"paths,omitempty"`
+	Stacks  []string `yaml:"stacks,omitempty" json:"stacks,omitempty"`
+}
+
+// serviceAccountsFile is the YAML file holding service accounts:
+//
+//	service_accounts:
+//	  - name: ci
+//	    token_sha256: 9f86d0...
+//	    rules:
+//	      - methods: [PUT, POST]
+//	        paths: [/api/stacks/*, /api/stacks/*/up]
+//	        stacks: [myapp]
+type serviceAccountsFile struct {
+	ServiceAccounts []ServiceAccount `yaml:"service_accounts"`
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Name           string
+	ServiceAccount *ServiceAccount
+	Token          *APIToken
+}
+
+type principalKey struct{}
+
+// Allows reports whether the principal may make the request. Tokens and service accounts are
+// limited to their scopes and rules; interactive users may make any request.
+func (p *Principal) Allows(method, urlPath string) bool {
+	switch {
+	case p.Token != nil:
+		return p.Token.Allows(method, urlPath)
+	case p.ServiceAccount != nil:
+		return p.ServiceAccount.Allows(method, urlPath)
+	}
+	return true
+}
+
+// Interactive reports whether the principal is a logged in user rather than automation
+func (p *Principal) Interactive() bool {
+	return p.Token == nil && p.ServiceAccount == nil
+}
+
+// withPrincipal stores the authenticated caller in the request context
+func withPrincipal(r *http.Request, p *Principal) *http.Request {
+	if entry, ok := r.Context().Value(accessLogKey{}).(*accessLogEntry); ok {
+		entry.user = p.Name
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// principalFromRequest returns the authenticated caller of a request, if any
+func principalFromRequest(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalKey{}).(*Principal)
+	return p
+}
+
+var serviceAccountsCache struct {
+	mu       sync.Mutex
+	path     string
+	modTime  time.Time
+	accounts []ServiceAccount
+}
+
+// loadServiceAccounts reads the service accounts file (service_accounts_file, default
+// service-accounts.yml in the working directory), reloading it when it changes.
+func loadServiceAccounts() []ServiceAccount {
+	path := getConfig("service_accounts_file", "service-accounts.yml")
+
+	serviceAccountsCache.mu.Lock()
+	defer serviceAccountsCache.mu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		serviceAccountsCache.accounts = nil
+		return nil
+	}
+	if path == serviceAccountsCache.path && info.ModTime().Equal(serviceAccountsCache.modTime) {
+		return serviceAccountsCache.accounts
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		authLog.Warn("Failed to read service accounts file", "path", path, "err", err)
+		return serviceAccountsCache.accounts
+	}
+	var file serviceAccountsFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		authLog.Warn("Failed to parse service accounts file", "path", path, "err", err)
+		return serviceAccountsCache.accounts
+	}
+
+	serviceAccountsCache.path = path
+	serviceAccountsCache.modTime = info.ModTime()
+	serviceAccountsCache.accounts = file.ServiceAccounts
+	authLog.Info("Loaded service accounts", "count", len(file.ServiceAccounts), "path", path)
+	return file.ServiceAccounts
+}
+
+// findServiceAccount returns the service account owning the given bearer token
+func findServiceAccount(token string) (*ServiceAccount, error) {
+	sum := sha256.Sum256([]byte(token))
+	digest := []byte(hex.EncodeToString(sum[:]))
+	for _, account := range loadServiceAccounts() {
+		expected := []byte(strings.ToLower(strings.TrimSpace(account.TokenSHA256)))
+		if subtle.ConstantTimeCompare(digest, expected) == 1 {
+			account := account
+			return &account, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown service account token")
+}
+
+// stackFromPath extracts {name} from /api/stacks/{name}[/...]
+func stackFromPath(urlPath string) string {
+	rest := strings.TrimPrefix(urlPath, "/api/stacks/")
+	if rest == urlPath {
+		return ""
+	}
+	name, _, _ := strings.Cut(rest, "/")
+	return name
+}
+
+// matchesAny reports whether value matches one of the patterns (an empty list matches everything)
+func matchesAny(patterns []string, value string, match func(pattern, value string) bool) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the rule permits the request
+func (rule PermissionRule) Allows(method, urlPath string) bool {
+	if !matchesAny(rule.Methods, method, strings.EqualFold) {
+		return false
+	}
+	if !matchesAny(rule.Paths, urlPath, func(pattern, value string) bool {
+		ok, err := path.Match(pattern, value)
+		return err == nil && ok
+	}) {
+		return false
+	}
+	if len(rule.Stacks) > 0 {
+		stack := stackFromPath(urlPath)
+		if stack == "" {
+			return false
+		}
+		return matchesAny(rule.Stacks, stack, func(pattern, value string) bool {
+			ok, err := path.Match(pattern, value)
+			return err == nil && ok
+		})
+	}
+	return true
+}
+
+// Allows reports whether any rule of the service account permits the request
+func (a *ServiceAccount) Allows(method, urlPath string) bool {
+	for _, rule := range a.Rules {
+		if rule.Allows(method, urlPath) {
+			return true
+		}
+	}
+	return false
+}
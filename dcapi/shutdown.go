@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultShutdownTimeout is how long dcapi waits on SIGTERM for requests and jobs to finish
+// before it interrupts them (config key shutdown_timeout)
+const defaultShutdownTimeout = 60 * time.Second
+
+func shutdownTimeout() time.Duration {
+	if d, err := time.ParseDuration(getConfig("shutdown_timeout", "")); err == nil && d > 0 {
+		return d
+	}
+	return defaultShutdownTimeout
+}
+
+// requestsContext is the base context of every request. shutdown cancels it once the compose
+// actions are done, which ends the streaming handlers (server-sent events, followed logs and
+// stats) that otherwise only return when their client goes away.
+var requestsContext, cancelRequests = context.WithCancel(context.Background())
+
+// newServer returns the server of the registered handlers. Websocket clients are told that
+// dcapi goes away when it shuts down, as their connections are hijacked and not waited for.
+func newServer(listenAddr string) *http.Server {
+	server := &http.Server{
+		Addr:        listenAddr,
+		Handler:     withBasePath(MetricsHandler(http.DefaultServeMux)),
+		BaseContext: func(net.Listener) context.Context { return requestsContext },
+	}
+	server.RegisterOnShutdown(func() {
+		wsHub.closeAll(websocket.CloseGoingAway, "server shutting down")
+	})
+	return server
+}
+
+// handleSignals shuts the server down gracefully on SIGTERM or SIGINT and reloads the
+// configuration on SIGHUP. The returned channel is closed once the shutdown is complete; a
+// second SIGTERM or SIGINT exits right away.
+func handleSignals(server *http.Server) <-chan struct{} {
+	stopped := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				reloadConfig()
+				continue
+			}
+			serverLog.Info("Shutting down", "signal", sig.String(), "timeout", shutdownTimeout())
+			go func() {
+				for sig := range signals {
+					if sig != syscall.SIGHUP {
+						serverLog.Warn("Stopping without waiting for requests and jobs", "signal", sig.String())
+						os.Exit(1)
+					}
+				}
+			}()
+			shutdown(server)
+			close(stopped)
+			return
+		}
+	}()
+	return stopped
+}
+
+// shutdown stops accepting connections and waits up to shutdown_timeout for the running
+// requests and compose actions. Actions still running then are interrupted, terminating their
+// process groups so that no docker compose is left behind, and recorded as interrupted jobs.
+// Streaming requests are then ended, as they would keep the server from stopping.
+// The state store, which holds jobs and the audit log, is written on every change, so it is up
+// to date once the requests are done.
+func shutdown(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+
+	// Following logs never ends on its own
+	stopLogFollowers()
+	serverDone := make(chan error, 1)
+	go func() { serverDone <- server.Shutdown(ctx) }()
+
+	for _, op := range runningOperations() {
+		for {
+			_, _, done, changed := op.since(0)
+			if done || ctx.Err() != nil {
+				break
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+			}
+		}
+	}
+	for _, op := range runningOperations() {
+		if op.Interrupt() {
+			jobsLog.Warn("Interrupted compose action at shutdown", "stack", op.Stack, "job", op.ID)
+		}
+	}
+	trackedJobs.Wait()
+	// The streams of the actions have seen them end; the other streams would never end
+	cancelRequests()
+
+	if err := <-serverDone; err != nil {
+		serverLog.Warn("Closing remaining connections", "err", err)
+		server.Close()
+	}
+	stopWatchSessions()
+	stopLogForwarder()
+	serverLog.Info("Server stopped")
+}
+
+// reloadConfig applies the configuration that is only read at startup again, e.g. after the
+// files of *_FILE variables or the TLS certificate files changed: log level and format,
+// redaction and the certificate. The log forwarder restarts with its current settings.
+func reloadConfig() {
+	serverLog.Info("Reloading configuration")
+	initLogging()
+	initRedaction()
+	if err := serverCertificate.reload(); err != nil {
+		serverLog.Error("Error reloading certificate, keeping the current one", "err", err)
+	}
+	statusCache.mu.Lock()
+	statusCache.statuses = nil
+	statusCache.mu.Unlock()
+	reloadLogForwarder()
+}
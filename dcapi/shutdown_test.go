@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdownEndsStreamingRequests(t *testing.T) {
+	t.Setenv("SHUTDOWN_TIMEOUT", "5s")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(listener.Addr().String())
+	streaming := make(chan struct{})
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		close(streaming)
+		<-r.Context().Done() // like an event stream, which only ends with its request
+	})
+	go server.Serve(listener)
+
+	// the client keeps reading, so only the server can end the request
+	resp, err := http.Get("http://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	<-streaming
+
+	stopped := make(chan struct{})
+	go func() {
+		shutdown(server)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("shutdown waited for a streaming request")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of the state store
+const (
+	bucketSessions  = "sessions"   // login sessions by the SHA-256 of their JWT
+	bucketAPITokens = "api_tokens" // personal access tokens by id
+	bucketAudit     = "audit"      // audit entries by time-ordered key
+	bucketJobs      = "jobs"       // background jobs by id
+	bucketActions   = "actions"    // compose actions by stack and time-ordered key
+	bucketSettings  = "settings"   // single values such as the generated signing key
+)
+
+// StateStore is the embedded store of dcapi: named buckets of JSON values in a bbolt database
+// (config key state_db). Every change is a transaction of its own, so a crash loses at most the
+// change being written. Stack metadata (groups, autostart) is kept by dc in its own database,
+// see `dc stack meta`, because dc must work without dcapi.
+type StateStore struct {
+	db *bolt.DB
+}
+
+var (
+	stateOnce sync.Once
+	state     *StateStore
+)
+
+// stateStore returns the store of dcapi, opened on first use. If the database can't be opened,
+// e.g. because another dcapi holds it, the state is kept in a database that is deleted right
+// away, so a broken or busy database is never touched.
+func stateStore() *StateStore {
+	stateOnce.Do(func() {
+		path := getConfig("state_db", "dcapi-state.db")
+		store, err := openStateStore(path)
+		if err != nil {
+			serverLog.Warn("State store unavailable, state is kept in memory only", "path", path, "err", err)
+			store, err = openTransientStateStore()
+			if err != nil {
+				serverLog.Error("Failed to create a transient state store", "err", err)
+				os.Exit(1)
+			}
+		}
+		state = store
+	})
+	return state
+}
+
+// openStateStore opens the database at path, creating it if needed, and imports the state file
+// of earlier versions (config key state_file) into a new database
+func openStateStore(path string) (*StateStore, error) {
+	_, statErr := os.Stat(path)
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s := &StateStore{db: db}
+	if os.IsNotExist(statErr) {
+		if err := s.importStateFile(getConfig("state_file", "dcapi-state.json")); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// openTransientStateStore opens a database whose file is removed while it stays open
+func openTransientStateStore() (*StateStore, error) {
+	dir, err := os.MkdirTemp("", "dcapi-state-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	db, err := bolt.Open(filepath.Join(dir, "state.db"), 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &StateStore{db: db}, nil
+}
+
+// importStateFile moves the buckets of a JSON state file into the store and renames the file,
+// so that it isn't imported again; a missing file is nothing to import
+func (s *StateStore) importStateFile(path string) error {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var buckets map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(content, &buckets); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		for name, values := range buckets {
+			bucket, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			for key, value := range values {
+				if err := bucket.Put([]byte(key), value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	serverLog.Info("Imported the state file into the state store", "file", path)
+	return os.Rename(path, path+".imported")
+}
+
+// Close closes the database
+func (s *StateStore) Close() error {
+	return s.db.Close()
+}
+
+// Get decodes the value of key into v and reports whether it exists
+func (s *StateStore) Get(bucket, key string, v interface{}) (bool, error) {
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		value := b.Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, v)
+	})
+	return found, err
+}
+
+// ForEach calls fn for every key of a bucket in key order. fn must not change the store.
+func (s *StateStore) ForEach(bucket string, fn func(key string, value json.RawMessage) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		// values are only valid during the transaction, so fn gets a copy
+		return b.ForEach(func(key, value []byte) error {
+			return fn(string(key), append(json.RawMessage(nil), value...))
+		})
+	})
+}
+
+// Put stores v under key
+func (s *StateStore) Put(bucket, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), value)
+	})
+}
+
+// Delete removes keys from a bucket
+func (s *StateStore) Delete(bucket string, keys ...string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		for _, key := range keys {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("STATE_FILE", filepath.Join(dir, "missing.json"))
+	store, err := openStateStore(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, key := range []string{"b", "a"} {
+		if err := store.Put("jobs", key, map[string]string{"id": key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got map[string]string
+	if found, err := store.Get("jobs", "a", &got); err != nil || !found || got["id"] != "a" {
+		t.Errorf("Get = %v, %v, %v", got, found, err)
+	}
+	if found, _ := store.Get("sessions", "a", &got); found {
+		t.Error("Get found a key in a missing bucket")
+	}
+
+	var keys []string
+	store.ForEach("jobs", func(key string, value json.RawMessage) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("ForEach keys = %v, want [a b]", keys)
+	}
+
+	if err := store.Delete("jobs", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := store.Get("jobs", "a", &got); found {
+		t.Error("Get found a deleted key")
+	}
+}
+
+func TestStateStoreImportsStateFile(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "dcapi-state.json")
+	if err := os.WriteFile(stateFile, []byte(`{"settings":{"theme":"dark"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("STATE_FILE", stateFile)
+	store, err := openStateStore(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var theme string
+	if found, err := store.Get("settings", "theme", &theme); err != nil || !found || theme != "dark" {
+		t.Errorf("imported setting = %q, %v, %v", theme, found, err)
+	}
+	if _, err := os.Stat(stateFile + ".imported"); err != nil {
+		t.Errorf("state file not renamed after the import: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// HandleStackStats handles GET /api/stacks/{name}/stats. With ?stream=true (or an Accept header
+// of text/event-stream) it sends a server-sent event with a new sample every ?interval= (default 2s)
+// until the client disconnects.
+func HandleStackStats(w http.ResponseWriter, r *http.Request, stackName string) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Query().Get("stream") != "true" && !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		HandleAction(w, "dc", "stack", "stats", stackName)
+		return
+	}
+
+	args := append([]string{"stack", "stats", stackName, "--follow=true"}, queryFlags(r, map[string]string{
+		"interval": "interval",
+	})...)
+	// The request context ends dc (and its docker stats calls) when the client goes away
+	cmd := exec.CommandContext(r.Context(), "dc", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		statsLog.Error("Error starting stats stream", "stack", stackName, "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+	defer cmd.Wait()
+
+	controller := http.NewResponseController(w)
+	timeout := streamWriteTimeout()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		_ = controller.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := fmt.Fprintf(w, "event: stats\ndata: %s\n\n", scanner.Text()); err != nil {
+			break
+		}
+		if err := controller.Flush(); err != nil {
+			break
+		}
+	}
+	if cmd.Process != nil {
+		_ = cmd.Process.Kill()
+	}
+}
+
+// HandleStackUsage handles GET /api/stacks/{name}/usage?range=24h&points=<n>: the CPU and memory
+// history recorded by the usage collector
+func HandleStackUsage(w http.ResponseWriter, r *http.Request, stackName string) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	HandleAction(w, "dc", append([]string{"stack", "usage", stackName}, queryFlags(r, map[string]string{
+		"range":  "range",
+		"points": "points",
+	})...)...)
+}
+
+// RunUsageCollector keeps `dc usage collect --follow` running when USAGE_HISTORY=true, so that
+// the resource usage of running stacks is sampled into their usage history files
+func RunUsageCollector() {
+	if !strings.EqualFold(getConfig("usage_history", "false"), "true") {
+		return
+	}
+	for {
+		cmd := exec.Command("dc", "usage", "collect", "--follow=true")
+		stderr, err := cmd.StderrPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			statsLog.Error("Error starting usage collector", "err", err)
+		} else {
+			scanner := bufio.NewScanner(stderr)
+			for scanner.Scan() {
+				statsLog.Debug("Usage collector output", "line", scanner.Text())
+			}
+			statsLog.Warn("Usage collector exited", "err", cmd.Wait())
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusCacheTTL limits how often the unauthenticated status page runs dc
+const statusCacheTTL = 30 * time.Second
+
+// PublicStackStatus mirrors the output of `dc status`
+type PublicStackStatus struct {
+	Name          string `json:"name"`
+	Title         string `json:"title,omitempty"`
+	State         string `json:"state"`
+	Since         string `json:"since,omitempty"`
+	UptimeSeconds int64  `json:"uptimeSeconds,omitempty"`
+}
+
+var statusCache struct {
+	mu        sync.Mutex
+	statuses  []PublicStackStatus
+	fetchedAt time.Time
+}
+
+// publicStatus returns the status of the public stacks, cached for statusCacheTTL
+func publicStatus() ([]PublicStackStatus, error) {
+	statusCache.mu.Lock()
+	defer statusCache.mu.Unlock()
+	if statusCache.statuses != nil && time.Since(statusCache.fetchedAt) < statusCacheTTL {
+		return statusCache.statuses, nil
+	}
+	out, err := exec.Command("dc", "status").Output()
+	if err != nil {
+		return nil, err
+	}
+	var statuses []PublicStackStatus
+	if err := json.Unmarshal(out, &statuses); err != nil {
+		return nil, err
+	}
+	statusCache.statuses = statuses
+	statusCache.fetchedAt = time.Now()
+	return statuses, nil
+}
+
+var statusPage = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="60">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 2rem auto; padding: 0 1rem; }
+li { display: flex; justify-content: space-between; padding: .75rem 0; border-bottom: 1px solid #ddd; }
+.up { color: #1a7f37; } .degraded { color: #9a6700; } .down { color: #cf222e; }
+small { color: #666; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Stacks}}<ul style="list-style: none; padding: 0">
+{{range .Stacks}}<li><span>{{.Name}}</span><span><strong class="{{.State}}">{{.Label}}</strong> <small>{{.Since}}</small></span></li>
+{{end}}</ul>{{else}}<p>{{.Empty}}</p>{{end}}
+</body>
+</html>
+`))
+
+// HandleStatus serves the unauthenticated status page at GET /status: HTML for browsers, JSON
+// with ?format=json or Accept: application/json. Only stacks flagged x-composectl.public are
+// listed, with nothing but their state. The page is off unless status_page is enabled.
+func HandleStatus(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(getConfig("status_page", "false"), "true") {
+		httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+		return
+	}
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	statuses, err := publicStatus()
+	if err != nil {
+		httpLog.Error("Error collecting public status", "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=30")
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+		return
+	}
+
+	type row struct{ Name, State, Label, Since string }
+	data := struct {
+		Lang, Title, Empty string
+		Stacks             []row
+	}{Lang: requestLanguage(r), Title: msg(r, "status_title"), Empty: msg(r, "status_empty")}
+	for _, s := range statuses {
+		name := s.Title
+		if name == "" {
+			name = s.Name
+		}
+		since := ""
+		if t, err := time.Parse(time.RFC3339, s.Since); err == nil {
+			since = msg(r, "status_since", t.Local().Format("2006-01-02 15:04"))
+		}
+		data.Stacks = append(data.Stacks, row{Name: name, State: s.State, Label: msg(r, "status_"+s.State), Since: since})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusPage.Execute(w, data); err != nil {
+		httpLog.Error("Error rendering status page", "err", err)
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Overflow policies for the bounded per-client queues (config key stream_overflow):
+//   - drop-oldest: a full queue discards its oldest message to make room (default)
+//   - drop-newest: a full queue discards the incoming message
+const (
+	OverflowDropOldest = "drop-oldest"
+	OverflowDropNewest = "drop-newest"
+)
+
+const (
+	defaultClientBuffer     = 256
+	defaultReplayLines      = 1000
+	defaultWriteTimeout     = 10 * time.Second
+	defaultOperationRetain  = 10 * time.Minute
+	operationResumeHeader   = "X-Resume-Token"
+	operationExitCodeHeader = "X-Exit-Code"
+)
+
+// streamConfigInt reads a positive integer setting
+func streamConfigInt(key string, defaultValue int) int {
+	if n, err := strconv.Atoi(getConfig(key, "")); err == nil && n > 0 {
+		return n
+	}
+	return defaultValue
+}
+
+// streamWriteTimeout is the deadline for a single write to a client (config key stream_write_timeout)
+func streamWriteTimeout() time.Duration {
+	if d, err := time.ParseDuration(getConfig("stream_write_timeout", "")); err == nil && d > 0 {
+		return d
+	}
+	return defaultWriteTimeout
+}
+
+// clientQueue is a bounded queue between a producer that must never block (the broadcaster)
+// and a single, possibly slow, client writer
+type clientQueue struct {
+	ch        chan []byte
+	policy    string
+	mu        sync.Mutex
+	dropped   int
+	overflows int // consecutive pushes that found the queue full
+	closed    bool
+}
+
+func newClientQueue() *clientQueue {
+	policy := getConfig("stream_overflow", OverflowDropOldest)
+	if policy != OverflowDropNewest {
+		policy = OverflowDropOldest
+	}
+	return &clientQueue{ch: make(chan []byte, streamConfigInt("stream_client_buffer", defaultClientBuffer)), policy: policy}
+}
+
+// push enqueues a message without blocking, applying the overflow policy when full.
+// It reports whether a message was dropped.
+func (q *clientQueue) push(msg []byte) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	overflowed := false
+	for {
+		select {
+		case q.ch <- msg:
+			if !overflowed {
+				q.overflows = 0
+			}
+			return overflowed
+		default:
+		}
+		overflowed = true
+		q.dropped++
+		q.overflows++
+		if q.policy == OverflowDropNewest {
+			return true
+		}
+		select {
+		case <-q.ch:
+		default:
+		}
+	}
+}
+
+// stalled reports whether the last n pushes all found the queue full
+func (q *clientQueue) stalled(n int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.overflows >= n
+}
+
+// close ends the writer once the remaining messages are drained; it may be called repeatedly
+func (q *clientQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+}
+
+// operation is the output of a dc action run with ?stream=true. The output is kept in a
+// bounded tail so that a client that disconnects can resume with the token from the
+// X-Resume-Token header: GET /api/stacks/{name}/stream?resume=<token>&from=<lines received>
+type operation struct {
+	ID    string
+	Stack string
+
+	mu          sync.Mutex
+	lines       []OutputFrame
+	base        int           // number of lines dropped from the front of lines
+	changed     chan struct{} // closed and replaced whenever lines or done change
+	done        bool
+	exitCode    int
+	doneAt      time.Time
+	cancel      context.CancelFunc
+	cancelled   bool
+	interrupted bool // stopped because dcapi shut down
+}
+
+var (
+	operations   = make(map[string]*operation)
+	operationsMu sync.Mutex
+)
+
+// append adds a line of output, dropping the oldest line beyond the replay limit
+func (op *operation) append(line OutputFrame, limit int) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.lines = append(op.lines, line)
+	if over := len(op.lines) - limit; over > 0 {
+		op.lines = append([]OutputFrame(nil), op.lines[over:]...)
+		op.base += over
+	}
+	close(op.changed)
+	op.changed = make(chan struct{})
+}
+
+// finish marks the operation complete with the exit code of dc
+func (op *operation) finish(exitCode int) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.done, op.exitCode, op.doneAt = true, exitCode, time.Now()
+	close(op.changed)
+	op.changed = make(chan struct{})
+}
+
+// Cancel terminates the operation's process group and reports whether it was still running
+func (op *operation) Cancel() bool {
+	op.mu.Lock()
+	if op.done || op.cancel == nil {
+		op.mu.Unlock()
+		return false
+	}
+	op.cancelled = true
+	op.mu.Unlock()
+	op.cancel()
+	return true
+}
+
+// Interrupt terminates the operation's process group because dcapi shuts down and reports
+// whether it was still running
+func (op *operation) Interrupt() bool {
+	op.mu.Lock()
+	if op.done || op.cancel == nil {
+		op.mu.Unlock()
+		return false
+	}
+	op.interrupted = true
+	op.mu.Unlock()
+	op.cancel()
+	return true
+}
+
+// runningOperations returns the operations that haven't finished
+func runningOperations() []*operation {
+	operationsMu.Lock()
+	defer operationsMu.Unlock()
+	var running []*operation
+	for _, op := range operations {
+		op.mu.Lock()
+		if !op.done {
+			running = append(running, op)
+		}
+		op.mu.Unlock()
+	}
+	return running
+}
+
+// since returns the lines from offset on (and how many requested lines were already dropped),
+// whether the operation is done and a channel that is closed on the next change
+func (op *operation) since(offset int) ([]OutputFrame, int, bool, <-chan struct{}) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	dropped := 0
+	if offset < op.base {
+		dropped = op.base - offset
+		offset = op.base
+	}
+	var lines []OutputFrame
+	if i := offset - op.base; i < len(op.lines) {
+		lines = append(lines, op.lines[i:]...)
+	}
+	return lines, dropped, op.done, op.changed
+}
+
+// startOperation runs a dc command in the background, with env as its environment (nil to
+// inherit dcapi's). Output is read independently of any client, so a slow or disconnected
+// client never blocks the command's pipes.
+func startOperation(stackName string, args []string, env []string) (*operation, error) {
+	id, err := randomHex(12)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("dc", append(args, "--output-format=json")...)
+	cmd.Env = env
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = cmd.Stdout
+	// Own process group, so that cancelling also ends docker compose started by dc
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			terminateProcessGroup(cmd, exited)
+		case <-exited:
+		}
+	}()
+
+	op := &operation{ID: id, Stack: stackName, changed: make(chan struct{}), cancel: cancel}
+	operationsMu.Lock()
+	for key, old := range operations {
+		old.mu.Lock()
+		expired := old.done && time.Since(old.doneAt) > defaultOperationRetain
+		old.mu.Unlock()
+		if expired {
+			delete(operations, key)
+		}
+	}
+	operations[id] = op
+	operationsMu.Unlock()
+
+	limit := streamConfigInt("stream_replay_lines", defaultReplayLines)
+	go func() {
+		var output strings.Builder
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			frame := parseRedactedFrame(scanner.Text())
+			output.WriteString(frame.Line + "\n")
+			op.append(frame, limit)
+		}
+		err := cmd.Wait()
+		close(exited)
+		observeCommand(args, start, []byte(output.String()), err)
+		exitCode := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		} else if err != nil {
+			exitCode = -1
+		}
+		op.finish(exitCode)
+	}()
+	return op, nil
+}
+
+// streamOperation writes the operation's output from offset on as a chunked response, as
+// server-sent events if the client accepts text/event-stream, as JSON frames if it accepts
+// application/x-ndjson and as plain text otherwise. Each write has a deadline, so a stalled
+// client is dropped instead of piling up.
+//
+// An event's id is the number of lines received with it, the from of a resumed stream. As
+// browsers can't read trailers, the exit code comes as a final done event.
+func streamOperation(w http.ResponseWriter, r *http.Request, op *operation, offset int) {
+	controller := http.NewResponseController(w)
+	timeout := streamWriteTimeout()
+	asEvents := wantsEvents(r)
+	asJSON := wantsFrames(r)
+	switch {
+	case asEvents:
+		setEventStreamHeaders(w)
+	case asJSON:
+		w.Header().Set("Content-Type", ndjsonContentType)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set(operationResumeHeader, op.ID)
+	w.Header().Set("Trailer", operationExitCodeHeader)
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		lines, dropped, done, changed := op.since(offset)
+		var chunk strings.Builder
+		if dropped > 0 {
+			notice := OutputFrame{Stream: FrameLog, Line: fmt.Sprintf("... %d lines dropped ...", dropped), TS: time.Now().UTC()}
+			if asEvents {
+				chunk.WriteString(formatEvent("", frameEvent(FrameLog), notice))
+			} else {
+				chunk.WriteString(formatFrame(notice, asJSON) + "\n")
+			}
+		}
+		offset += dropped
+		for _, frame := range lines {
+			offset++
+			if asEvents {
+				chunk.WriteString(formatEvent(strconv.Itoa(offset), frameEvent(frame.Stream), frame))
+			} else {
+				chunk.WriteString(formatFrame(frame, asJSON) + "\n")
+			}
+		}
+		if done && asEvents {
+			op.mu.Lock()
+			chunk.WriteString(formatEvent("", "done", map[string]int{"exitCode": op.exitCode}))
+			op.mu.Unlock()
+		}
+		if chunk.Len() > 0 {
+			_ = controller.SetWriteDeadline(time.Now().Add(timeout))
+			if _, err := w.Write([]byte(chunk.String())); err != nil {
+				jobsLog.Debug("Stream client dropped", "operation", op.ID, "err", err)
+				return
+			}
+			if err := controller.Flush(); err != nil {
+				jobsLog.Debug("Stream client dropped", "operation", op.ID, "err", err)
+				return
+			}
+		}
+		if done {
+			op.mu.Lock()
+			w.Header().Set(operationExitCodeHeader, strconv.Itoa(op.exitCode))
+			op.mu.Unlock()
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// HandleStreamAction runs a dc action for a stack and streams its output while it runs
+func HandleStreamAction(w http.ResponseWriter, r *http.Request, stackName string, args ...string) {
+	op, err := startOperation(stackName, args, commandEnv(r))
+	if err != nil {
+		jobsLog.Error("Error starting streamed action", "stack", stackName, "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+	streamOperation(w, r, op, 0)
+}
+
+// streamOffset returns the lines a client already received from ?from, or from the
+// Last-Event-ID header that EventSource reconnects with
+func streamOffset(r *http.Request) int {
+	from := r.URL.Query().Get("from")
+	if from == "" {
+		from = r.Header.Get("Last-Event-ID")
+	}
+	offset, err := strconv.Atoi(from)
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// HandleResumeStream handles GET /api/stacks/{name}/stream?resume=<token>&from=<offset>
+func HandleResumeStream(w http.ResponseWriter, r *http.Request, stackName string) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	operationsMu.Lock()
+	op, ok := operations[r.URL.Query().Get("resume")]
+	operationsMu.Unlock()
+	if !ok {
+		// operations are forgotten a while after they finish, jobs are kept
+		op, _, ok = jobOperation(r.URL.Query().Get("resume"))
+	}
+	if !ok || op.Stack != stackName {
+		httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+		return
+	}
+	streamOperation(w, r, op, streamOffset(r))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// HealthCheck is a check of `dc doctor`: status ok, warn or fail and a hint how to fix it
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// HealthReport is the output of `dc doctor`
+type HealthReport struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []HealthCheck `json:"checks"`
+}
+
+// HandleSystemAPI serves GET /api/system/df (disk usage by category and stack),
+// GET /api/system/health and POST /api/system/prune?images=true&containers=true&networks=true
+// through `dc system`
+func HandleSystemAPI(w http.ResponseWriter, r *http.Request) {
+	switch action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/system"), "/"); {
+	case action == "df" && r.Method == http.MethodGet:
+		HandleAction(w, "dc", "system", "df")
+	case action == "health" && r.Method == http.MethodGet:
+		handleSystemHealth(w)
+	case action == "prune" && r.Method == http.MethodPost:
+		HandleAction(w, "dc", append([]string{"system", "prune"}, mutationFlags(r, map[string]string{
+			"images":     "images",
+			"containers": "containers",
+			"networks":   "networks",
+		})...)...)
+	case action == "df" || action == "health" || action == "prune":
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+	default:
+		httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+	}
+}
+
+// handleSystemHealth answers the checks of `dc doctor`, 503 if one failed. If dc itself can't
+// be run, that is reported as a failed check.
+func handleSystemHealth(w http.ResponseWriter) {
+	// dc doctor exits 1 if a check failed, the report is on stdout either way
+	out, err := exec.Command("dc", "doctor", "--output-format=json").Output()
+	var report HealthReport
+	if jsonErr := json.Unmarshal(out, &report); jsonErr != nil {
+		if err == nil {
+			err = jsonErr
+		}
+		report = HealthReport{Checks: []HealthCheck{{
+			Name:    "dc",
+			Status:  "fail",
+			Message: fmt.Sprintf("dc doctor failed: %v", err),
+			Hint:    "install dc on the PATH of dcapi",
+		}}}
+	}
+	status := http.StatusOK
+	if !report.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -17,17 +16,17 @@ import (
 // GET /thumbnail/{image} returns the thumbnail for the specified Docker Hub image
 func HandleThumbnail(w http.ResponseWriter, r *http.Request) {
 	// Extract image name from URL path
-	imageName := strings.TrimPrefix(r.URL.Path, "/thumbnail/")
+	imageName := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api"), "/thumbnail/")
 	if imageName == "" {
-		http.Error(w, "Image name is required", http.StatusBadRequest)
+		httpError(w, r, "image_name_required", http.StatusBadRequest)
 		return
 	}
 
 	// Create thumbnails directory if it doesn't exist
-	thumbnailsDir := "thumbnails"
+	thumbnailsDir := getAssetCacheDir()
 	if err := os.MkdirAll(thumbnailsDir, 0755); err != nil {
-		log.Printf("Error creating thumbnails directory: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		thumbnailsLog.Error("Error creating thumbnails directory", "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
 		return
 	}
 
@@ -38,27 +37,30 @@ func HandleThumbnail(w http.ResponseWriter, r *http.Request) {
 	// Check if thumbnail already exists
 	if _, err := os.Stat(thumbnailPath); err == nil {
 		// Thumbnail exists, serve it
+		touchAsset(thumbnailPath)
 		http.ServeFile(w, r, thumbnailPath)
 		return
 	}
 
 	// Thumbnail doesn't exist, scrape Docker Hub
-	log.Printf("Thumbnail not found for %s, scraping Docker Hub...", imageName)
+	thumbnailsLog.Info("Thumbnail not found, scraping Docker Hub", "image", imageName)
 	gravatarURL, err := scrapeDockerHubGravatar(imageName)
 	if err != nil {
-		log.Printf("Error scraping Docker Hub for %s: %v", imageName, err)
-		http.Error(w, "Failed to fetch thumbnail", http.StatusNotFound)
+		thumbnailsLog.Error("Error scraping Docker Hub", "image", imageName, "err", err)
+		httpError(w, r, "thumbnail_fetch_failed", http.StatusNotFound)
 		return
 	}
 
 	// Download the gravatar image
 	if err := downloadImage(gravatarURL, thumbnailPath); err != nil {
-		log.Printf("Error downloading gravatar for %s: %v", imageName, err)
-		http.Error(w, "Failed to download thumbnail", http.StatusInternalServerError)
+		thumbnailsLog.Error("Error downloading gravatar", "image", imageName, "err", err)
+		httpError(w, r, "thumbnail_download_fail", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Successfully downloaded thumbnail for %s", imageName)
+	thumbnailsLog.Info("Downloaded thumbnail", "image", imageName)
+	touchAsset(thumbnailPath)
+	evictAssets()
 	// Serve the newly downloaded thumbnail
 	http.ServeFile(w, r, thumbnailPath)
 }
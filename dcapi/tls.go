@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// selfSignedValidity is how long a generated self-signed certificate is valid
+const selfSignedValidity = 2 * 365 * 24 * time.Hour
+
+// splitList splits a comma separated config value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ListenAndServe serves the registered handlers with server on its address, or on the socket
+// systemd passed (see listen). TLS is enabled by one of (in this order of precedence):
+//   - ACME_DOMAINS: certificates from Let's Encrypt (or ACME_DIRECTORY_URL), cached in ACME_CACHE_DIR
+//   - --tls-cert/--tls-key: a certificate and key from PEM files, read again on SIGHUP
+//   - TLS_SELF_SIGNED=true: a self-signed certificate generated into TLS_DIR on first run
+//
+// Without any of them the server falls back to plain http. It returns http.ErrServerClosed once
+// the server is shut down.
+func ListenAndServe(server *http.Server) error {
+	listener, err := listen(server.Addr)
+	if err != nil {
+		return err
+	}
+
+	if domains := splitList(getConfig("acme_domains", "")); len(domains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(getConfig("acme_cache_dir", "acme-cache")),
+			Email:      getConfig("acme_email", ""),
+		}
+		if directoryURL := getConfig("acme_directory_url", ""); directoryURL != "" {
+			manager.Client = &acme.Client{DirectoryURL: directoryURL}
+		}
+		// HTTP-01 challenges need port 80; TLS-ALPN-01 works on the TLS port alone
+		if challengeAddr := getConfig("acme_http_addr", ""); challengeAddr != "" {
+			go func() {
+				serverLog.Info("Serving ACME HTTP challenges", "addr", challengeAddr)
+				serverLog.Error("ACME challenge listener stopped", "err", http.ListenAndServe(challengeAddr, manager.HTTPHandler(nil)))
+			}()
+		}
+		server.TLSConfig = manager.TLSConfig()
+		serverLog.Info("Server running", "url", "https://"+listener.Addr().String(), "domains", strings.Join(domains, ", "))
+		return server.ServeTLS(listener, "", "")
+	}
+
+	certFile := getConfig("tls_cert", "")
+	keyFile := getConfig("tls_key", "")
+	if certFile == "" && keyFile == "" && strings.EqualFold(getConfig("tls_self_signed", "false"), "true") {
+		var err error
+		if certFile, keyFile, err = ensureSelfSignedCert(); err != nil {
+			return fmt.Errorf("failed to create self-signed certificate: %w", err)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return fmt.Errorf("both --tls-cert and --tls-key are required")
+		}
+		serverCertificate.certFile, serverCertificate.keyFile = certFile, keyFile
+		if err := serverCertificate.reload(); err != nil {
+			return fmt.Errorf("failed to load certificate: %w", err)
+		}
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: serverCertificate.get}
+		serverLog.Info("Server running", "url", "https://"+listener.Addr().String())
+		return server.ServeTLS(listener, "", "")
+	}
+
+	serverLog.Info("Server running", "url", "http://"+listener.Addr().String())
+	return server.Serve(listener)
+}
+
+// listen returns the listener of the server: the socket systemd passed with socket activation
+// (LISTEN_FDS), else a new one on addr. With a socket unit the socket outlives dcapi, so during
+// a restart connections wait in its backlog instead of being refused.
+func listen(addr string) (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) && os.Getenv("LISTEN_FDS") != "" {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		// Passed sockets start at file descriptor 3; dcapi serves the first one
+		file := os.NewFile(3, "systemd-socket")
+		defer file.Close()
+		listener, err := net.FileListener(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to use the socket passed by systemd: %w", err)
+		}
+		serverLog.Info("Using socket passed by systemd", "addr", listener.Addr().String())
+		return listener, nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// certificateFiles is a certificate read from PEM files, replaced by reload (e.g. on SIGHUP after
+// the files were renewed) without restarting the server
+type certificateFiles struct {
+	certFile, keyFile string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+}
+
+// serverCertificate is the certificate of --tls-cert/--tls-key or the self-signed one
+var serverCertificate certificateFiles
+
+// reload reads the certificate files; the current certificate is kept if they are invalid
+func (c *certificateFiles) reload() error {
+	if c.certFile == "" {
+		return nil
+	}
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.certificate = &certificate
+	c.mu.Unlock()
+	return nil
+}
+
+// get returns the current certificate to TLS handshakes
+func (c *certificateFiles) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.certificate, nil
+}
+
+// ensureSelfSignedCert returns the self-signed certificate and key in TLS_DIR, generating them
+// if they do not exist yet. The certificate covers localhost, the hostname and TLS_HOSTS.
+func ensureSelfSignedCert() (string, string, error) {
+	dir := getConfig("tls_dir", "tls")
+	certFile := filepath.Join(dir, "self-signed.crt")
+	keyFile := filepath.Join(dir, "self-signed.key")
+	if _, err := os.Stat(certFile); err == nil {
+		if _, err := os.Stat(keyFile); err == nil {
+			return certFile, keyFile, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	hosts = append(hosts, splitList(getConfig("tls_hosts", ""))...)
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "dcapi", Organization: []string{"composectl"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return "", "", err
+	}
+	serverLog.Info("Generated self-signed certificate", "file", certFile, "hosts", strings.Join(hosts, ", "))
+	return certFile, keyFile, nil
+}
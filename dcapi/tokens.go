@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// apiTokenPrefix marks personal access tokens so they can be told apart from JWTs
+const apiTokenPrefix = "dct_"
+
+// scopeRules maps each token scope to the requests it permits
+var scopeRules = map[string][]PermissionRule{
+	"stacks:read": {
+		{Methods: []string{http.MethodGet}, Paths: []string{"/api/stacks", "/api/stacks/*", "/api/stacks/*/*", "/api/summary", "/api/graph", "/api/boot", "/api/drift", "/api/jobs", "/api/jobs/*", "/api/jobs/*/*", "/api/events", "/api/containers"}},
+	},
+	"stacks:deploy": {
+		{Methods: []string{http.MethodPost, http.MethodPut}, Paths: []string{
+			"/api/stacks/*/up", "/api/stacks/*/down", "/api/stacks/*/start", "/api/stacks/*/stop", "/api/stacks/*/create",
+			"/api/stacks/_bulk",
+		}},
+		{Methods: []string{http.MethodDelete}, Paths: []string{"/api/jobs/*"}},
+	},
+	"stacks:write": {
+		{Methods: []string{http.MethodPut, http.MethodDelete}, Paths: []string{"/api/stacks/*", "/api/stacks/*/*"}},
+		{Methods: []string{http.MethodPost}, Paths: []string{"/api/stacks/*", "/api/stacks/*/*", "/api/stacks/*/*/*"}},
+	},
+	"transform": {
+		{Methods: []string{http.MethodPost}, Paths: []string{"/api/transform", "/api/convert"}},
+	},
+	"lint": {
+		{Methods: []string{http.MethodPost}, Paths: []string{"/api/lint"}},
+	},
+	"secrets:read": {
+		{Methods: []string{http.MethodGet}, Paths: []string{"/api/secrets", "/api/secrets/*"}},
+		// Bundles without a passphrase and k8s Secrets with secret_values hold the secrets
+		{Methods: []string{http.MethodGet}, Paths: []string{"/api/stacks/*/export"}},
+	},
+	"secrets:write": {
+		{Methods: []string{http.MethodPut, http.MethodDelete}, Paths: []string{"/api/secrets/*"}},
+		{Methods: []string{http.MethodPost}, Paths: []string{"/api/secrets", "/api/secrets/*/rotate"}},
+	},
+	"resources:read": {
+		{Methods: []string{http.MethodGet}, Paths: []string{"/api/networks", "/api/networks/*", "/api/volumes", "/api/volumes/*", "/api/system/df", "/api/system/health"}},
+	},
+	"resources:write": {
+		{Methods: []string{http.MethodPost}, Paths: []string{"/api/networks", "/api/networks/prune", "/api/volumes", "/api/volumes/prune", "/api/system/prune"}},
+		{Methods: []string{http.MethodDelete}, Paths: []string{"/api/networks/*", "/api/volumes/*"}},
+	},
+}
+
+// scopeExclusions are the requests a scope's rules match but must not permit
+var scopeExclusions = map[string][]PermissionRule{
+	"stacks:read": {
+		{Methods: []string{http.MethodGet}, Paths: []string{"/api/stacks/*/export"}},
+	},
+}
+
+// scopeAllows reports whether the scope permits the request
+func scopeAllows(scope, method, urlPath string) bool {
+	for _, rule := range scopeExclusions[scope] {
+		if rule.Allows(method, urlPath) {
+			return false
+		}
+	}
+	for _, rule := range scopeRules[scope] {
+		if rule.Allows(method, urlPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIToken is a long-lived personal access token. Only the SHA-256 of the token is stored;
+// the token itself is returned once, when it is created.
+type APIToken struct {
+	ID          string     `yaml:"id" json:"id"`
+	Name        string     `yaml:"name" json:"name"`
+	Owner       string     `yaml:"owner" json:"owner"`
+	TokenSHA256 string     `yaml:"token_sha256" json:"-"`
+	Scopes      []string   `yaml:"scopes" json:"scopes"`
+	CreatedAt   time.Time  `yaml:"created_at" json:"createdAt"`
+	ExpiresAt   *time.Time `yaml:"expires_at,omitempty" json:"expiresAt,omitempty"`
+}
+
+// TokenCreateRequest is the body of POST /api/tokens
+type TokenCreateRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+// TokenCreateResponse returns the new token; it cannot be retrieved again
+type TokenCreateResponse struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+type apiTokensFile struct {
+	APITokens []APIToken `yaml:"api_tokens"`
+}
+
+// storedAPIToken is an APIToken as kept in the state store, including the hash the API hides
+type storedAPIToken struct {
+	APIToken
+	TokenSHA256 string `json:"token_sha256"`
+}
+
+var apiTokensMu sync.Mutex
+
+// getAPITokensPath returns the file that held the hashed tokens before the state store
+// (config key api_tokens_file); its tokens are imported once
+func getAPITokensPath() string {
+	return getConfig("api_tokens_file", "api-tokens.yml")
+}
+
+// loadAPITokens reads all tokens from the state store; the caller must hold apiTokensMu,
+// which keeps the import of the api tokens file from running twice
+func loadAPITokens() ([]APIToken, error) {
+	var tokens []APIToken
+	err := stateStore().ForEach(bucketAPITokens, func(key string, value json.RawMessage) error {
+		var stored storedAPIToken
+		if err := json.Unmarshal(value, &stored); err != nil {
+			return fmt.Errorf("invalid api token %s: %w", key, err)
+		}
+		stored.APIToken.TokenSHA256 = stored.TokenSHA256
+		tokens = append(tokens, stored.APIToken)
+		return nil
+	})
+	if err != nil || tokens != nil {
+		return tokens, err
+	}
+	return importAPITokensFile()
+}
+
+// importAPITokensFile moves the tokens of the api tokens file into the state store and renames
+// the file, so that revoked tokens can't come back; the caller must hold apiTokensMu
+func importAPITokensFile() ([]APIToken, error) {
+	content, err := os.ReadFile(getAPITokensPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var file apiTokensFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", getAPITokensPath(), err)
+	}
+	for _, token := range file.APITokens {
+		if err := stateStore().Put(bucketAPITokens, token.ID, storedAPIToken{token, token.TokenSHA256}); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Rename(getAPITokensPath(), getAPITokensPath()+".imported"); err != nil {
+		return nil, err
+	}
+	authLog.Info("Imported api tokens", "count", len(file.APITokens), "path", getAPITokensPath())
+	return file.APITokens, nil
+}
+
+// hashToken returns the hex SHA-256 of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// findAPIToken returns the unexpired token matching the bearer token
+func findAPIToken(token string) (*APIToken, error) {
+	apiTokensMu.Lock()
+	tokens, err := loadAPITokens()
+	apiTokensMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	digest := []byte(hashToken(token))
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare(digest, []byte(t.TokenSHA256)) == 1 {
+			if t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt) {
+				return nil, fmt.Errorf("token %s expired", t.ID)
+			}
+			t := t
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown api token")
+}
+
+// Allows reports whether one of the token's scopes permits the request
+func (t *APIToken) Allows(method, urlPath string) bool {
+	for _, scope := range t.Scopes {
+		if scopeAllows(scope, method, urlPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HandleTokensAPI manages personal access tokens:
+// GET /api/tokens lists them, POST /api/tokens creates one, DELETE /api/tokens/{id} revokes one.
+// Only interactive users may manage tokens, so a token can't mint broader tokens.
+func HandleTokensAPI(w http.ResponseWriter, r *http.Request) {
+	principal := principalFromRequest(r)
+	if principal == nil || principal.ServiceAccount != nil || principal.Token != nil {
+		httpError(w, r, "forbidden", http.StatusForbidden)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/tokens"), "/")
+
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+	tokens, err := loadAPITokens()
+	if err != nil {
+		authLog.Error("Error loading api tokens", "err", err)
+		httpError(w, r, "internal_error", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		if tokens == nil {
+			tokens = []APIToken{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokens)
+
+	case r.Method == http.MethodPost && id == "":
+		var req TokenCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "invalid_json", http.StatusBadRequest, err)
+			return
+		}
+		if req.Name == "" || len(req.Scopes) == 0 {
+			httpError(w, r, "token_fields_required", http.StatusBadRequest)
+			return
+		}
+		for _, scope := range req.Scopes {
+			if _, ok := scopeRules[scope]; !ok {
+				httpError(w, r, "unknown_scope", http.StatusBadRequest, scope, strings.Join(knownScopes(), ", "))
+				return
+			}
+		}
+		secret, err := randomHex(32)
+		tokenID, idErr := randomHex(8)
+		if err != nil || idErr != nil {
+			httpError(w, r, "internal_error", http.StatusInternalServerError)
+			return
+		}
+		token := APIToken{
+			ID:          tokenID,
+			Name:        req.Name,
+			Owner:       principal.Name,
+			TokenSHA256: hashToken(apiTokenPrefix + secret),
+			Scopes:      req.Scopes,
+			CreatedAt:   time.Now().UTC(),
+		}
+		if req.ExpiresInDays > 0 {
+			expires := token.CreatedAt.AddDate(0, 0, req.ExpiresInDays)
+			token.ExpiresAt = &expires
+		}
+		if err := stateStore().Put(bucketAPITokens, token.ID, storedAPIToken{token, token.TokenSHA256}); err != nil {
+			authLog.Error("Error saving api tokens", "err", err)
+			httpError(w, r, "internal_error", http.StatusInternalServerError)
+			return
+		}
+		authLog.Info("User created api token", "user", principal.Name, "token", token.ID, "token_name", token.Name, "scopes", token.Scopes)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(TokenCreateResponse{APIToken: token, Token: apiTokenPrefix + secret})
+
+	case r.Method == http.MethodDelete && id != "":
+		for _, t := range tokens {
+			if t.ID == id {
+				if err := stateStore().Delete(bucketAPITokens, t.ID); err != nil {
+					authLog.Error("Error saving api tokens", "err", err)
+					httpError(w, r, "internal_error", http.StatusInternalServerError)
+					return
+				}
+				authLog.Info("User revoked api token", "user", principal.Name, "token", t.ID, "token_name", t.Name)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		httpError(w, r, "not_found", http.StatusNotFound, r.URL.Path)
+
+	default:
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// knownScopes returns the sorted scope names
+func knownScopes() []string {
+	scopes := make([]string, 0, len(scopeRules))
+	for scope := range scopeRules {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
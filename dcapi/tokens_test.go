@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestScopeAllows(t *testing.T) {
+	tests := []struct {
+		scope  string
+		method string
+		path   string
+		want   bool
+	}{
+		{"stacks:read", http.MethodGet, "/api/stacks/web", true},
+		{"stacks:read", http.MethodGet, "/api/stacks/web/logs", true},
+		{"stacks:read", http.MethodGet, "/api/stacks/web/exports", true},
+		{"stacks:read", http.MethodGet, "/api/stacks/web/export", false},
+		{"stacks:read", http.MethodPost, "/api/stacks/web/export", false},
+		{"secrets:read", http.MethodGet, "/api/stacks/web/export", true},
+		{"secrets:read", http.MethodGet, "/api/stacks/web/logs", false},
+		{"stacks:write", http.MethodPost, "/api/stacks/web/export", true},
+	}
+	for _, tt := range tests {
+		if got := scopeAllows(tt.scope, tt.method, tt.path); got != tt.want {
+			t.Errorf("scopeAllows(%q, %s, %s) = %v, want %v", tt.scope, tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAPITokenAllowsExport(t *testing.T) {
+	token := APIToken{Scopes: []string{"stacks:read"}}
+	if token.Allows(http.MethodGet, "/api/stacks/web/export") {
+		t.Error("stacks:read token can download an export")
+	}
+	token.Scopes = append(token.Scopes, "secrets:read")
+	if !token.Allows(http.MethodGet, "/api/stacks/web/export") {
+		t.Error("stacks:read and secrets:read token cannot download an export")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// uiFiles is the web interface: the static build of dcgui, which `make build` copies into ui/
+//
+//go:embed all:ui
+var uiFiles embed.FS
+
+// uiFS returns the files of the web interface: those of assets_dir (--assets-dir) if set, to
+// work on the interface without rebuilding dcapi, else the embedded ones
+func uiFS() fs.FS {
+	if dir := getConfig("assets_dir", ""); dir != "" {
+		return os.DirFS(dir)
+	}
+	files, _ := fs.Sub(uiFiles, "ui")
+	return files
+}
+
+// uiFile returns the file serving a path of the web interface: the file itself, the page of the
+// same name (e.g. login.html for /login), else index.html, which routes on the client. Without
+// a build of dcgui that is the placeholder page.
+func uiFile(files fs.FS, urlPath string) (string, bool) {
+	name := strings.Trim(path.Clean("/"+urlPath), "/")
+	candidates := []string{"index.html", "placeholder.html"}
+	if name != "" {
+		candidates = append([]string{name, name + ".html", path.Join(name, "index.html")}, candidates...)
+	}
+	for _, candidate := range candidates {
+		if info, err := fs.Stat(files, candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// HandleUI serves the web interface. Pages are never cached; the fingerprinted assets under
+// _app/immutable are cached for a year.
+func HandleUI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		httpError(w, r, "method_not_allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	files := uiFS()
+	name, ok := uiFile(files, r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case strings.HasSuffix(name, ".html"):
+		w.Header().Set("Cache-Control", "no-store")
+	case strings.HasPrefix(name, "_app/immutable/"):
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	http.ServeFileFS(w, r, files, name)
+}
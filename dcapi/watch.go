@@ -1,36 +1,232 @@
 package main
 
 import (
-	"log"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
 )
 
-// WatchFiles monitors the pages and components directories for changes
-func WatchFiles() {
-	watcher, err := fsnotify.NewWatcher()
+// defaultWatchDebounce is how long a stack file must stay unchanged before a change is
+// handled (config key watch_debounce); editors and dc often write a file in several steps
+const defaultWatchDebounce = 500 * time.Millisecond
+
+// stackDirs asks dc for the directories holding stack files
+func stackDirs() ([]string, error) {
+	out, err := exec.Command("dc", "stack", "dirs").Output()
 	if err != nil {
-		log.Fatal("Error creating file watcher:", err)
+		return nil, err
 	}
-	defer watcher.Close()
+	var dirs []string
+	err = json.Unmarshal(out, &dirs)
+	return dirs, err
+}
+
+// settingsDir asks dc once for the directory of the settings files shared with it
+var settingsDir = sync.OnceValues(func() (string, error) {
+	out, err := exec.Command("dc", "stack", "settings-dir").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the settings dir from dc: %w", err)
+	}
+	var dir string
+	err = json.Unmarshal(out, &dir)
+	return dir, err
+})
+
+// settingsFilePath returns the path of a settings file shared with dc: the config key, else the
+// file of that name in dc's settings dir
+func settingsFilePath(key, name string) (string, error) {
+	if path := getConfig(key, ""); path != "" {
+		return path, nil
+	}
+	dir, err := settingsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// writeSettingsFile writes a settings file, which may hold credentials, creating its directory
+func writeSettingsFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0600)
+}
+
+// stackOfFile returns the stack a file belongs to, or "" if it isn't a stack file. Effective
+// files are written by dc itself on every deployment and are ignored like hidden files.
+func stackOfFile(path string) string {
+	base := filepath.Base(path)
+	if strings.HasPrefix(base, ".") || !strings.HasSuffix(base, ".yml") || strings.HasSuffix(base, ".effective.yml") {
+		return ""
+	}
+	return strings.TrimSuffix(base, ".yml")
+}
 
-	// Watch pages directory
-	err = addWatchRecursive(watcher, "pages")
+// autoReloadEnabled reads x-composectl.auto_reload of a stack file
+func autoReloadEnabled(path string) bool {
+	content, err := os.ReadFile(path)
 	if err != nil {
-		log.Println("Error watching pages directory:", err)
+		return false
+	}
+	var stack struct {
+		Composectl struct {
+			AutoReload bool `yaml:"auto_reload"`
+		} `yaml:"x-composectl"`
 	}
+	if err := yaml.Unmarshal(content, &stack); err != nil {
+		filesLog.Warn("Not reloading", "path", path, "err", err)
+		return false
+	}
+	return stack.Composectl.AutoReload
+}
 
-	// Watch components directory (if it exists)
-	if _, err := os.Stat("components"); err == nil {
-		err = addWatchRecursive(watcher, "components")
+// stackReloader redeploys stacks with at most one `dc stack up` per stack at a time. A change
+// arriving during a reload queues one more reload once it is done. `dc stack up` rewrites the
+// stack file itself, so a file whose content is the one of the last reload isn't reloaded again.
+type stackReloader struct {
+	mu       sync.Mutex
+	running  map[string]bool
+	pending  map[string]bool
+	deployed map[string][sha256.Size]byte
+}
+
+// fileHash returns the hash of a file's content, ok false if it can't be read
+func fileHash(path string) ([sha256.Size]byte, bool) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, false
+	}
+	return sha256.Sum256(content), true
+}
+
+// changed reports whether the stack file differs from the one of the last reload
+func (r *stackReloader) changed(stack, path string) bool {
+	hash, ok := fileHash(path)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deployed, known := r.deployed[stack]
+	return !ok || !known || hash != deployed
+}
+
+func (r *stackReloader) reload(stack, path string) {
+	r.mu.Lock()
+	if r.running[stack] {
+		r.pending[stack] = true
+		r.mu.Unlock()
+		return
+	}
+	r.running[stack] = true
+	r.mu.Unlock()
+
+	for {
+		if !r.changed(stack, path) {
+			filesLog.Debug("Not reloading, the stack file is the deployed one", "stack", stack)
+			if r.done(stack) {
+				return
+			}
+			continue
+		}
+		args := []string{"stack", "up", stack}
+		filesLog.Info("Reloading stack after its stack file changed", "stack", stack)
+		output, err := exec.Command("dc", args...).CombinedOutput()
+		exitCode := 0
 		if err != nil {
-			log.Println("Error watching components directory:", err)
+			exitCode = -1
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				exitCode = exitErr.ExitCode()
+			}
+			filesLog.Error("Reloading stack failed", "stack", stack, "err", err, "output", lastLine(string(output)))
+			broadcast <- FileChangeMessage{Type: "stack-reload-failed", Stack: stack, Line: lastLine(string(output))}
+		} else {
+			broadcast <- FileChangeMessage{Type: "stack-reloaded", Stack: stack}
+		}
+		notifyDeploy(args, exitCode)
+		if hash, ok := fileHash(path); ok {
+			r.mu.Lock()
+			r.deployed[stack] = hash
+			r.mu.Unlock()
+		}
+
+		if r.done(stack) {
+			return
+		}
+	}
+}
+
+// done ends a reload unless another one is pending, which it takes
+func (r *stackReloader) done(stack string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.pending[stack] {
+		delete(r.running, stack)
+		return true
+	}
+	delete(r.pending, stack)
+	return false
+}
+
+// lastLine returns the last non-empty line of command output
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
+
+// WatchFiles monitors the stack directories and tells websocket clients about changed stack
+// files (stack-changed, stack-removed). Stacks with x-composectl.auto_reload: true are brought
+// up again after their file changed. Watching is on by default and off with WATCH_STACKS=false.
+func WatchFiles() {
+	if strings.EqualFold(getConfig("watch_stacks", "true"), "false") {
+		return
+	}
+	debounce, err := time.ParseDuration(getConfig("watch_debounce", ""))
+	if err != nil || debounce <= 0 {
+		debounce = defaultWatchDebounce
+	}
+	dirs, err := stackDirs()
+	if err != nil {
+		filesLog.Warn("File watcher disabled, failed to get the stack directories", "err", err)
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		filesLog.Warn("File watcher disabled", "err", err)
+		return
+	}
+	defer watcher.Close()
+	// Stack files live directly in the stack dirs, so subdirectories (volumes, .git) aren't watched
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			filesLog.Error("Error watching directory", "dir", dir, "err", err)
+			continue
 		}
+		filesLog.Info("Watching stack files", "dir", dir)
 	}
 
-	log.Println("File watcher started for pages and components directories")
+	reloader := &stackReloader{running: make(map[string]bool), pending: make(map[string]bool), deployed: make(map[string][sha256.Size]byte)}
+	var mu sync.Mutex
+	timers := make(map[string]*time.Timer)
+	handle := func(path, stack string) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			broadcast <- FileChangeMessage{Type: "stack-removed", Path: path, Stack: stack}
+			return
+		}
+		broadcast <- FileChangeMessage{Type: "stack-changed", Path: path, Stack: stack}
+		if autoReloadEnabled(path) {
+			reloader.reload(stack, path)
+		}
+	}
 
 	for {
 		select {
@@ -38,43 +234,22 @@ func WatchFiles() {
 			if !ok {
 				return
 			}
-			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove) != 0 {
-				log.Printf("File change detected: %s [%s]", event.Name, event.Op)
-				broadcast <- FileChangeMessage{
-					Type: event.Op.String(),
-					Path: event.Name,
-				}
-
-				// If a new directory was created, watch it too
-				if event.Op&fsnotify.Create != 0 {
-					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
-						addWatchRecursive(watcher, event.Name)
-					}
-				}
+			stack := stackOfFile(event.Name)
+			if stack == "" || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			path := event.Name
+			mu.Lock()
+			if timer, ok := timers[path]; ok {
+				timer.Stop()
 			}
+			timers[path] = time.AfterFunc(debounce, func() { handle(path, stack) })
+			mu.Unlock()
 		case err, ok := <-watcher.Errors:
 			if !ok {
 				return
 			}
-			log.Println("File watcher error:", err)
+			filesLog.Error("File watcher error", "err", err)
 		}
 	}
 }
-
-// addWatchRecursive adds a directory and all its subdirectories to the watcher
-func addWatchRecursive(watcher *fsnotify.Watcher, path string) error {
-	return filepath.Walk(path, func(walkPath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			err = watcher.Add(walkPath)
-			if err != nil {
-				log.Printf("Error watching %s: %v", walkPath, err)
-				return err
-			}
-			log.Printf("Watching: %s", walkPath)
-		}
-		return nil
-	})
-}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStackReloaderSkipsDeployedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.yml")
+	if err := os.WriteFile(path, []byte("services: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &stackReloader{running: map[string]bool{}, pending: map[string]bool{}, deployed: map[string][sha256.Size]byte{}}
+	if !r.changed("web", path) {
+		t.Error("a stack never reloaded counts as unchanged")
+	}
+	hash, _ := fileHash(path)
+	r.deployed["web"] = hash
+	if r.changed("web", path) {
+		t.Error("the file dc stack up wrote counts as changed")
+	}
+	if err := os.WriteFile(path, []byte("services:\n  app: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !r.changed("web", path) {
+		t.Error("an edited stack file counts as unchanged")
+	}
+}
@@ -1,9 +1,17 @@
 package main
 
 import (
-	"log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -12,66 +20,529 @@ var (
 	upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			return true // Allow all origins for development
-		},
+		CheckOrigin:     checkOrigin,
 	}
-	clients   = make(map[*websocket.Conn]bool)
-	clientsMu sync.Mutex
 	broadcast = make(chan FileChangeMessage)
+	wsHub     = newHub()
 )
 
-// FileChangeMessage represents a file change notification
+const (
+	defaultPingInterval = 30 * time.Second
+	defaultEvictAfter   = 32
+)
+
+// checkOrigin accepts websocket upgrades without an Origin (clients other than browsers), from
+// dcapi's own origin and from ws_allowed_origins, a comma-separated list in which * allows any
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil {
+		if strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		// Behind a trusted proxy the origin is the host the proxy was asked for
+		if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" && fromTrustedProxy(r) && strings.EqualFold(u.Host, forwarded) {
+			return true
+		}
+	}
+	for _, allowed := range strings.Split(getConfig("ws_allowed_origins", ""), ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" || allowed != "" && strings.EqualFold(strings.TrimRight(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Topics of websocket messages. A client that subscribed to topics receives only their
+// messages; a client that never subscribed receives all messages except log lines.
+const (
+	topicEvents      = "events" // container lifecycle events of all stacks
+	topicStackPrefix = "stack:" // the messages of a stack except its log lines; stack:* of all stacks
+	topicLogsPrefix  = "logs:"  // the log lines of the containers of a stack
+)
+
+// messageTopics returns the topics a message is sent to
+func messageTopics(msg FileChangeMessage) []string {
+	if msg.Type == "log" {
+		return []string{topicLogsPrefix + msg.Stack}
+	}
+	topics := []string{topicStackPrefix + msg.Stack}
+	if strings.HasPrefix(msg.Type, "container-") {
+		topics = append(topics, topicEvents)
+	}
+	return topics
+}
+
+// wsClient is a websocket client with its queue and topic subscriptions
+type wsClient struct {
+	conn      *websocket.Conn
+	queue     *clientQueue
+	principal *Principal
+
+	mu     sync.Mutex
+	topics map[string]bool // nil until the client subscribes
+}
+
+// wants reports whether the client receives messages of the topics
+func (c *wsClient) wants(topics []string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, topic := range topics {
+		switch {
+		case strings.HasPrefix(topic, topicLogsPrefix):
+			if c.topics[topic] {
+				return true
+			}
+		case c.topics == nil || c.topics[topic]:
+			return true
+		case strings.HasPrefix(topic, topicStackPrefix) && c.topics[topicStackPrefix+"*"]:
+			return true
+		}
+	}
+	return false
+}
+
+// wsRequest is a message of a client, e.g. {"action": "subscribe", "topics": ["stack:web",
+// "logs:web", "events"]}. The client is answered with a subscribed message listing all its
+// topics, or with subscribe-failed and the reason in Line.
+type wsRequest struct {
+	Action string   `json:"action"` // subscribe or unsubscribe
+	Topics []string `json:"topics"`
+}
+
+// validTopic reports whether a topic exists and the client may subscribe to it. Log lines are
+// for those allowed to read the logs of the stack.
+func (c *wsClient) validTopic(topic string) error {
+	switch {
+	case topic == topicEvents:
+		return nil
+	case strings.HasPrefix(topic, topicStackPrefix) && len(topic) > len(topicStackPrefix):
+		return nil
+	case strings.HasPrefix(topic, topicLogsPrefix):
+		stack := strings.TrimPrefix(topic, topicLogsPrefix)
+		if !stackNamePattern.MatchString(stack) {
+			return fmt.Errorf("invalid stack in topic %s", topic)
+		}
+		if c.principal == nil || !c.principal.Allows(http.MethodGet, "/api/stacks/"+stack+"/logs") {
+			return fmt.Errorf("not allowed to read the logs of %s", stack)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown topic %s", topic)
+}
+
+// handleRequest applies a subscription request of the client
+func (c *wsClient) handleRequest(req wsRequest) {
+	reply := FileChangeMessage{Type: "subscribed"}
+	c.mu.Lock()
+	switch req.Action {
+	case "subscribe":
+		for _, topic := range req.Topics {
+			if err := c.validTopic(topic); err != nil {
+				reply = FileChangeMessage{Type: "subscribe-failed", Line: err.Error()}
+				break
+			}
+		}
+		if reply.Type != "subscribed" {
+			break
+		}
+		if c.topics == nil {
+			c.topics = make(map[string]bool)
+		}
+		for _, topic := range req.Topics {
+			if c.topics[topic] {
+				continue
+			}
+			if stack, ok := strings.CutPrefix(topic, topicLogsPrefix); ok {
+				if err := followLogs(stack); err != nil {
+					websocketLog.Error("Error following logs", "stack", stack, "err", err)
+					continue
+				}
+			}
+			c.topics[topic] = true
+		}
+	case "unsubscribe":
+		for _, topic := range req.Topics {
+			if !c.topics[topic] {
+				continue
+			}
+			delete(c.topics, topic)
+			if stack, ok := strings.CutPrefix(topic, topicLogsPrefix); ok {
+				unfollowLogs(stack)
+			}
+		}
+	default:
+		reply = FileChangeMessage{Type: "subscribe-failed", Line: fmt.Sprintf("unknown action %q", req.Action)}
+	}
+	if reply.Type == "subscribed" {
+		reply.Topics = []string{}
+		for topic := range c.topics {
+			reply.Topics = append(reply.Topics, topic)
+		}
+		sort.Strings(reply.Topics)
+	}
+	c.mu.Unlock()
+	c.reply(reply)
+}
+
+// reply queues a message for the client alone
+func (c *wsClient) reply(msg FileChangeMessage) {
+	if payload, err := json.Marshal(msg); err == nil {
+		c.queue.push(payload)
+	}
+}
+
+// release ends the log followers of the client's subscriptions
+func (c *wsClient) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic := range c.topics {
+		if stack, ok := strings.CutPrefix(topic, topicLogsPrefix); ok {
+			unfollowLogs(stack)
+		}
+	}
+	c.topics = map[string]bool{}
+}
+
+// logFollower runs `dc stack logs` for a stack while clients subscribe to its log lines
+type logFollower struct {
+	op          *operation
+	subscribers int
+}
+
+var (
+	logFollowers   = make(map[string]*logFollower)
+	logFollowersMu sync.Mutex
+)
+
+// followLogs adds a subscriber to the log lines of a stack, starting to follow them for the first
+func followLogs(stack string) error {
+	logFollowersMu.Lock()
+	defer logFollowersMu.Unlock()
+	if follower, ok := logFollowers[stack]; ok {
+		follower.subscribers++
+		return nil
+	}
+	op, err := startOperation(stack, []string{"stack", "logs", stack}, nil)
+	if err != nil {
+		return err
+	}
+	logFollowers[stack] = &logFollower{op: op, subscribers: 1}
+	go relayLogs(stack, op)
+	return nil
+}
+
+// unfollowLogs removes a subscriber of the log lines of a stack, stopping to follow them after the last
+func unfollowLogs(stack string) {
+	logFollowersMu.Lock()
+	defer logFollowersMu.Unlock()
+	follower, ok := logFollowers[stack]
+	if !ok {
+		return
+	}
+	if follower.subscribers--; follower.subscribers <= 0 {
+		delete(logFollowers, stack)
+		follower.op.Cancel()
+	}
+}
+
+// stopLogFollowers stops following the logs of all stacks
+func stopLogFollowers() {
+	logFollowersMu.Lock()
+	defer logFollowersMu.Unlock()
+	for stack, follower := range logFollowers {
+		delete(logFollowers, stack)
+		follower.op.Cancel()
+	}
+}
+
+// relayLogs broadcasts the log lines of a stack until following them ends. If dc exits on its
+// own, e.g. because the stack went down, the next subscription follows the logs again.
+func relayLogs(stack string, op *operation) {
+	offset := 0
+	for {
+		lines, dropped, done, changed := op.since(offset)
+		offset += dropped + len(lines)
+		for _, frame := range lines {
+			broadcast <- FileChangeMessage{Type: "log", Stack: stack, Line: frame.Line, Stream: frame.Stream}
+		}
+		if done {
+			logFollowersMu.Lock()
+			if follower, ok := logFollowers[stack]; ok && follower.op == op {
+				delete(logFollowers, stack)
+			}
+			logFollowersMu.Unlock()
+			return
+		}
+		<-changed
+	}
+}
+
+// hub fans broadcast messages out to the websocket clients. Every client has a bounded
+// queue drained by its own writer, so one stalled client can neither block the broadcast
+// nor the other clients; a client whose queue stays full is evicted.
+type hub struct {
+	mu      sync.Mutex
+	clients map[*websocket.Conn]*wsClient
+
+	// replay holds the most recent broadcast messages for clients resuming with ?resume=<token>
+	replay    []replayedMessage
+	replaySeq uint64
+	// replayEpoch distinguishes resume tokens of this process from those of a previous run
+	replayEpoch string
+}
+
+func newHub() *hub {
+	return &hub{clients: make(map[*websocket.Conn]*wsClient), replayEpoch: strconv.FormatInt(time.Now().UnixNano(), 36)}
+}
+
+// FileChangeMessage represents a file change notification. Compose watch sessions use the
+// types watch-started, watch-output (one line of output in Line, framed as Stream) and watch-stopped;
+// container lifecycle events use container-start, container-die, container-health_status and
+// container-oom with the details in Event. The stack file watcher uses stack-changed and
+// stack-removed, and stack-reloaded or stack-reload-failed (the error in Line) for auto_reload.
+// Subscribers of a stack's logs get its log lines as type log. Topics lists the subscriptions
+// of a client in the answer to its subscription request.
+// ResumeToken identifies the message for resuming after a reconnect; a resync message tells
+// the client that messages were lost and it should reload its state.
 type FileChangeMessage struct {
-	Type string `json:"type"`
-	Path string `json:"path"`
+	Type        string      `json:"type"`
+	Path        string      `json:"path"`
+	Stack       string      `json:"stack,omitempty"`
+	Line        string      `json:"line,omitempty"`
+	Stream      string      `json:"stream,omitempty"`
+	Event       *StackEvent `json:"event,omitempty"`
+	Topics      []string    `json:"topics,omitempty"`
+	ResumeToken string      `json:"resumeToken,omitempty"`
+}
+
+type replayedMessage struct {
+	seq     uint64
+	topics  []string
+	payload []byte
+}
+
+// resumeMessages returns the buffered messages after the resume token, or false if the token
+// is from another run or older than the buffer; the caller must hold h.mu
+func (h *hub) resumeMessages(token string) ([]replayedMessage, bool) {
+	epoch, seqStr, ok := strings.Cut(token, ".")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if !ok || err != nil || epoch != h.replayEpoch || seq > h.replaySeq {
+		return nil, false
+	}
+	if seq < h.replaySeq && (len(h.replay) == 0 || h.replay[0].seq > seq+1) {
+		return nil, false
+	}
+	var messages []replayedMessage
+	for _, m := range h.replay {
+		if m.seq > seq {
+			messages = append(messages, m)
+		}
+	}
+	return messages, true
+}
+
+// register adds a client, first queueing what it missed of its topics if it resumes with a token
+func (h *hub) register(client *wsClient, resumeToken string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if resumeToken != "" {
+		if messages, ok := h.resumeMessages(resumeToken); ok {
+			for _, m := range messages {
+				if client.wants(m.topics) {
+					client.queue.push(m.payload)
+				}
+			}
+		} else if payload, err := json.Marshal(FileChangeMessage{Type: "resync"}); err == nil {
+			client.queue.push(payload)
+		}
+	}
+	h.clients[client.conn] = client
+}
+
+// unregister removes a client, stops its writer and ends its log subscriptions; it may be
+// called repeatedly
+func (h *hub) unregister(conn *websocket.Conn) {
+	h.mu.Lock()
+	client, ok := h.clients[conn]
+	delete(h.clients, conn)
+	h.mu.Unlock()
+	if ok {
+		client.queue.close()
+		client.release()
+	}
+}
+
+// evict disconnects a client that cannot keep up; its reader then unregisters it
+func (h *hub) evict(conn *websocket.Conn, reason string) {
+	websocketLog.Warn("Evicting websocket client", "remote", conn.RemoteAddr(), "reason", reason)
+	websocketEvicted.add("", 1)
+	closeClient(conn, websocket.ClosePolicyViolation, "too slow")
+}
+
+// closeClient sends a close message, if the connection still takes one, and closes it
+func closeClient(conn *websocket.Conn, code int, text string) {
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// closeAll disconnects every client, e.g. with going away when dcapi shuts down
+func (h *hub) closeAll(code int, text string) {
+	h.mu.Lock()
+	conns := make([]*websocket.Conn, 0, len(h.clients))
+	for conn := range h.clients {
+		conns = append(conns, conn)
+	}
+	h.mu.Unlock()
+	for _, conn := range conns {
+		closeClient(conn, code, text)
+	}
+}
+
+func (h *hub) clientCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// publish stamps a message with its resume token, keeps it for replay and queues it for every
+// client of its topics
+func (h *hub) publish(msg FileChangeMessage, replayLimit, evictAfter int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.replaySeq++
+	msg.ResumeToken = h.replayEpoch + "." + strconv.FormatUint(h.replaySeq, 10)
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		websocketLog.Error("Error encoding broadcast message", "err", err)
+		return
+	}
+	topics := messageTopics(msg)
+	h.replay = append(h.replay, replayedMessage{seq: h.replaySeq, topics: topics, payload: payload})
+	if over := len(h.replay) - replayLimit; over > 0 {
+		h.replay = append([]replayedMessage(nil), h.replay[over:]...)
+	}
+	for conn, client := range h.clients {
+		if !client.wants(topics) {
+			continue
+		}
+		if client.queue.push(payload) {
+			websocketDropped.add("", 1)
+			if client.queue.stalled(evictAfter) {
+				delete(h.clients, conn)
+				client.queue.close()
+				go client.release()
+				go h.evict(conn, fmt.Sprintf("%d messages in a row found its queue full", evictAfter))
+			}
+		}
+	}
+}
+
+// wsPingInterval is how often clients are pinged (config key ws_ping_interval); a client
+// that does not answer within two intervals is disconnected
+func wsPingInterval() time.Duration {
+	if d, err := time.ParseDuration(getConfig("ws_ping_interval", "")); err == nil && d > 0 {
+		return d
+	}
+	return defaultPingInterval
 }
 
-// HandleWebSocket manages WebSocket connections
+// writeClient sends queued messages and keepalive pings to a websocket client, giving up on
+// the client if a write does not complete within the write timeout. It is the only writer of conn.
+func writeClient(conn *websocket.Conn, queue *clientQueue, pingInterval time.Duration) {
+	timeout := streamWriteTimeout()
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case payload, ok := <-queue.ch:
+			if !ok {
+				return
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+			if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				websocketLog.Error("Error sending to client", "err", err)
+				conn.Close()
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout)); err != nil {
+				websocketLog.Error("Error pinging client", "err", err)
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// HandleWebSocket manages WebSocket connections of authenticated clients. ?topics= subscribes
+// to a comma-separated list of topics right away, so that resuming replays only their messages;
+// later subscriptions are JSON messages of the client (see wsRequest).
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("WebSocket upgrade error:", err)
+		websocketLog.Warn("WebSocket upgrade failed", "err", err)
 		return
 	}
 	defer conn.Close()
 
-	// Register client
-	clientsMu.Lock()
-	clients[conn] = true
-	clientsMu.Unlock()
+	client := &wsClient{conn: conn, queue: newClientQueue(), principal: principalFromRequest(r)}
+	if topics := r.URL.Query().Get("topics"); topics != "" {
+		client.handleRequest(wsRequest{Action: "subscribe", Topics: strings.Split(topics, ",")})
+	}
+	wsHub.register(client, r.URL.Query().Get("resume"))
+	pingInterval := wsPingInterval()
+	go writeClient(conn, client.queue, pingInterval)
 
-	log.Println("Client connected")
+	websocketLog.Debug("Client connected")
 
 	// Unregister client on disconnect
 	defer func() {
-		clientsMu.Lock()
-		delete(clients, conn)
-		clientsMu.Unlock()
-		log.Println("Client disconnected")
+		wsHub.unregister(conn)
+		websocketLog.Debug("Client disconnected")
 	}()
 
-	// Keep connection alive and handle client messages
+	// Any message or pong from the client proves it is alive
+	pongWait := 2 * pingInterval
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	conn.SetReadLimit(64 * 1024)
 	for {
-		_, _, err := conn.ReadMessage()
+		messageType, data, err := conn.ReadMessage()
 		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				websocketEvicted.add("", 1)
+				websocketLog.Warn("Websocket client did not answer pings", "remote", conn.RemoteAddr())
+				closeClient(conn, websocket.CloseGoingAway, "no pong")
+			}
 			break
 		}
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			client.reply(FileChangeMessage{Type: "subscribe-failed", Line: "invalid message: " + err.Error()})
+			continue
+		}
+		client.handleRequest(req)
 	}
 }
 
-// HandleBroadcast sends file change messages to all connected clients
+// HandleBroadcast publishes file change messages to all connected clients. It never waits
+// for a client: each has a bounded queue drained by its own writer, and a client that
+// misses ws_evict_after messages in a row is disconnected.
 func HandleBroadcast() {
+	replayLimit := streamConfigInt("stream_replay_lines", defaultReplayLines)
+	evictAfter := streamConfigInt("ws_evict_after", defaultEvictAfter)
 	for msg := range broadcast {
-		clientsMu.Lock()
-		for conn := range clients {
-			err := conn.WriteJSON(msg)
-			if err != nil {
-				log.Println("Error sending to client:", err)
-				conn.Close()
-				delete(clients, conn)
-			}
-		}
-		clientsMu.Unlock()
+		wsHub.publish(msg, replayLimit, evictAfter)
 	}
 }
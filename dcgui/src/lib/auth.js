@@ -1,13 +1,21 @@
 import { goto } from "$app/navigation";
 import { browser } from "$app/environment";
+import { base } from "$app/paths";
 
 function gotoRoot() {
   const loginHref = (typeof window !== 'undefined' && window.location && window.location.origin)
-      ? `${window.location.origin}/login`
-      : '/login';
+      ? `${window.location.origin}${base}/login`
+      : `${base}/login`;
   goto(loginHref);
 }
 
+/**
+ * Prefix an absolute API path with the base path the GUI is served under (BASE_PATH at build time)
+ */
+export function apiUrl(url) {
+  return url.startsWith("/") ? `${base}${url}` : url;
+}
+
 /**
  * Authenticated fetch wrapper that handles 401/403 redirects
  */
@@ -23,7 +31,7 @@ export async function authFetch(url, options = {}) {
       "Authorization": `Bearer ${token}`,
     };
   }
-  const response = await fetch(url, options);
+  const response = await fetch(apiUrl(url), options);
 
   // Check for authentication errors
   if (response.status === 401 || response.status === 403) {
@@ -38,7 +46,19 @@ export async function authFetch(url, options = {}) {
 }
 
 /**
- * Check authentication status by calling /api/auth/status with bearer token (if present)
+ * Read the message of an error response: the JSON error envelope of the API, else the body
+ */
+export async function errorText(response) {
+  const text = await response.text();
+  try {
+    return JSON.parse(text).error?.message || text;
+  } catch {
+    return text;
+  }
+}
+
+/**
+ * Check authentication status by calling /api/v1/auth/status with bearer token (if present)
  * Returns the fetch Response or null on error. Performs redirects similar to previous inline logic.
  */
 export async function checkAuth() {
@@ -51,7 +71,7 @@ export async function checkAuth() {
     headers["Authorization"] = `Bearer ${token}`;
   }
 
-  const res = await fetch('/api/auth/status', { headers });
+  const res = await fetch(apiUrl('/api/v1/auth/status'), { headers });
 
   // If the response is not 2xx, and we're on the login page, redirect to /
   if (!res.ok && browser && window.location && window.location.pathname.indexOf('/login') >= 0) {
@@ -0,0 +1,57 @@
+// Line diff of two texts for previews, computed with the longest common subsequence.
+// Texts beyond MAX_CELLS compared lines are shown as a removal of the old and an addition of
+// the new text instead, to keep the browser responsive.
+const MAX_CELLS = 4_000_000;
+
+/**
+ * Diff two texts line by line
+ * @returns {{op: ' ' | '+' | '-', text: string}[]}
+ */
+export function diffLines(before, after) {
+  const a = (before || "").split("\n");
+  const b = (after || "").split("\n");
+  if (a.length * b.length > MAX_CELLS) {
+    return [
+      ...a.map(text => ({ op: "-", text })),
+      ...b.map(text => ({ op: "+", text })),
+    ];
+  }
+
+  // lengths[i][j] is the length of the common subsequence of a[i:] and b[j:]
+  const lengths = Array.from({ length: a.length + 1 }, () => new Uint32Array(b.length + 1));
+  for (let i = a.length - 1; i >= 0; i--) {
+    for (let j = b.length - 1; j >= 0; j--) {
+      lengths[i][j] = a[i] === b[j]
+        ? lengths[i + 1][j + 1] + 1
+        : Math.max(lengths[i + 1][j], lengths[i][j + 1]);
+    }
+  }
+
+  const lines = [];
+  let i = 0;
+  let j = 0;
+  while (i < a.length && j < b.length) {
+    if (a[i] === b[j]) {
+      lines.push({ op: " ", text: a[i] });
+      i++;
+      j++;
+    } else if (lengths[i + 1][j] >= lengths[i][j + 1]) {
+      lines.push({ op: "-", text: a[i++] });
+    } else {
+      lines.push({ op: "+", text: b[j++] });
+    }
+  }
+  while (i < a.length) lines.push({ op: "-", text: a[i++] });
+  while (j < b.length) lines.push({ op: "+", text: b[j++] });
+  return lines;
+}
+
+/**
+ * Count the added and removed lines of a diff
+ */
+export function diffStats(lines) {
+  return {
+    added: lines.filter(line => line.op === "+").length,
+    removed: lines.filter(line => line.op === "-").length,
+  };
+}
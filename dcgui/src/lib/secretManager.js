@@ -1,23 +1,12 @@
-import { authFetch } from "./auth.js";
-
-// Parse KEY=value lines from `dc secret ls` output into [{name, value}]
-export function parseSecretList(text) {
-  return (text || "")
-    .split("\n")
-    .map(line => line.trim())
-    .filter(line => line && !line.startsWith("#") && line.includes("="))
-    .map(line => {
-      const eq = line.indexOf("=");
-      return { name: line.slice(0, eq), value: line.slice(eq + 1) };
-    });
-}
+import { authFetch, errorText } from "./auth.js";
 
+// GET /api/v1/secrets returns [{name, value (masked), usages: [{stack, service, field, label}], orphaned}]
 export async function fetchSecrets() {
   try {
-    const response = await authFetch("/api/secrets");
+    const response = await authFetch("/api/v1/secrets");
     if (!response.ok) return [];
-    const text = await response.text();
-    return parseSecretList(text).sort((a, b) => a.name.localeCompare(b.name, undefined, { sensitivity: "base" }));
+    const secrets = await response.json();
+    return (secrets || []).sort((a, b) => a.name.localeCompare(b.name, undefined, { sensitivity: "base" }));
   } catch (err) {
     console.error("fetchSecrets error", err);
     return [];
@@ -25,21 +14,21 @@ export async function fetchSecrets() {
 }
 
 export async function upsertSecret(name, value) {
-  const response = await authFetch(`/api/secrets/${encodeURIComponent(name)}`, {
+  const response = await authFetch(`/api/v1/secrets/${encodeURIComponent(name)}`, {
     method: "PUT",
     body: value,
   });
   if (!response.ok) {
-    throw await response.text();
+    throw await errorText(response);
   }
   return await response.text();
 }
 
 export async function deleteSecret(name) {
-  const response = await authFetch(`/api/secrets/${encodeURIComponent(name)}`, {
+  const response = await authFetch(`/api/v1/secrets/${encodeURIComponent(name)}`, {
     method: "DELETE",
   });
   if (!response.ok) {
-    throw await response.text();
+    throw await errorText(response);
   }
 }
\ No newline at end of file
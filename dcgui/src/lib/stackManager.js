@@ -1,5 +1,5 @@
 import { EditorView, basicSetup } from "codemirror";
-import { authFetch } from "./auth.js";
+import { authFetch, errorText } from "./auth.js";
 
 // Common function to handle streaming responses
 async function handleStreamingResponse(response, log, successMessage, errorPrefix) {
@@ -87,7 +87,7 @@ async function ftch({ url, log, successMessage, errorMessage, ...options }) {
       .then(async response => {
         const responseText = [];
         if (!response.ok) {
-          throw await response.text();
+          throw await errorText(response);
         }
         const decoder = new TextDecoder();
         await response.body.pipeTo(new WritableStream({
@@ -157,7 +157,7 @@ export async function del({ url, log, successMessage, errorMessage, ...options }
 
 export async function fetchStacks() {
     try {
-        const response = await authFetch('/api/stacks');
+        const response = await authFetch('/api/v1/stacks');
         if (!response.ok) {
           return [];
         }
@@ -173,7 +173,7 @@ export async function fetchStacks() {
 
 export async function fetchStackDoc(stackName, log) {
   return await get({
-    url: `/api/stacks/${stackName}`,
+    url: `/api/v1/stacks/${stackName}`,
     log,
     successMessage: 'Stack content fetched successfully',
     errorMessage: 'Failed to fetch stack content'
@@ -182,7 +182,7 @@ export async function fetchStackDoc(stackName, log) {
 
 export async function playStack(stackName, body, log) {
   return await put({
-    url: `/api/stacks/${stackName}/start`,
+    url: `/api/v1/stacks/${stackName}/start`,
     body,
     log,
     successMessage: 'Stack deployed successfully',
@@ -192,7 +192,7 @@ export async function playStack(stackName, body, log) {
 
 export async function stopStack(stackName, body, log) {
   return await put({
-    url: `/api/stacks/${stackName}/stop`,
+    url: `/api/v1/stacks/${stackName}/stop`,
     body,
     log,
     successMessage: 'Stack stopped successfully',
@@ -202,7 +202,7 @@ export async function stopStack(stackName, body, log) {
 
 export async function deleteStack(stackName, body, log) {
   return await del({
-    url: `/api/stacks/${stackName}`,
+    url: `/api/v1/stacks/${stackName}`,
     body,
     log,
     successMessage: 'Stack deleted successfully',
@@ -212,10 +212,109 @@ export async function deleteStack(stackName, body, log) {
 
 export async function saveStack(stackName, body, log) {
   return await put({
-    url: `/api/stacks/${stackName}`,
+    url: `/api/v1/stacks/${stackName}`,
     body,
     log,
     successMessage: 'Stack saved successfully',
     errorMessage: 'Failed to save stack'
   })
 }
+
+// POST /api/v1/transform applies the enrichment steps of a deploy to YAML without saving
+// anything and returns {yaml, steps, warnings}
+export async function previewStack(body) {
+  const response = await authFetch('/api/v1/transform', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ yaml: body }),
+  });
+  if (!response.ok) {
+    throw await errorText(response);
+  }
+  return await response.json();
+}
+
+// Save the stack file, then deploy it with `stack up` and stream the output
+export async function deployStack(stackName, body, log) {
+  await saveStack(stackName, body, log);
+  return await ftch({
+    url: `/api/v1/stacks/${stackName}/up?stream=true`,
+    method: 'POST',
+    log,
+    successMessage: 'Stack deployed successfully',
+    errorMessage: 'Failed to deploy stack'
+  });
+}
+
+// Actions of the stack action buttons: the request that runs them as a job, and whether they
+// destroy something and need the stack name typed to confirm
+export const stackActions = {
+  start: { label: 'Start', icon: '▶️', path: 'start' },
+  stop: { label: 'Stop', icon: '⏹️', path: 'stop' },
+  update: { label: 'Update', icon: '🔄', path: 'up', query: 'pull=true' },
+  down: { label: 'Down', icon: '⏬', path: 'down', destructive: true },
+  delete: { label: 'Delete', icon: '🗑️', destructive: true },
+};
+
+// Read a text/event-stream response and pass each event as {event, data} to onEvent
+async function readEventStream(response, onEvent) {
+  const reader = response.body.getReader();
+  const decoder = new TextDecoder();
+  let buffer = '';
+  for (;;) {
+    const { done, value } = await reader.read();
+    if (done) break;
+    buffer += decoder.decode(value, { stream: true });
+    let end;
+    while ((end = buffer.indexOf('\n\n')) >= 0) {
+      const block = buffer.slice(0, end);
+      buffer = buffer.slice(end + 2);
+      let event = 'message';
+      const data = [];
+      for (const line of block.split('\n')) {
+        if (line.startsWith('event: ')) event = line.slice(7);
+        else if (line.startsWith('data: ')) data.push(line.slice(6));
+      }
+      try {
+        onEvent({ event, data: JSON.parse(data.join('\n')) });
+      } catch {
+        // not a JSON event
+      }
+    }
+  }
+}
+
+// Run a stack action as a job and pass its output lines to log. Returns the exit code; the job
+// keeps running on the server if the page is left.
+export async function runStackAction(stackName, action, log) {
+  const spec = stackActions[action];
+  if (action === 'delete') {
+    await deleteStack(stackName, '', log);
+    return 0;
+  }
+  const query = ['async=true', spec.query].filter(Boolean).join('&');
+  const response = await authFetch(`/api/v1/stacks/${stackName}/${spec.path}?${query}`, { method: 'POST' });
+  if (!response.ok) {
+    throw await errorText(response);
+  }
+  const job = await response.json();
+
+  const stream = await authFetch(`/api/v1/jobs/${job.id}/stream`, {
+    headers: { 'Accept': 'text/event-stream' },
+  });
+  if (!stream.ok) {
+    throw await errorText(stream);
+  }
+  let exitCode = null;
+  await readEventStream(stream, ({ event, data }) => {
+    if (event === 'done') {
+      exitCode = data.exitCode;
+    } else if (data.line !== undefined) {
+      log(data.line + '\n');
+    }
+  });
+  if (exitCode === null) {
+    throw 'Connection to the job lost';
+  }
+  return exitCode;
+}